@@ -3,45 +3,196 @@ package compile
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/pgavlin/warp/cmd/warp/validate"
 	"github.com/pgavlin/warp/compiler/source/golang"
 	"github.com/pgavlin/warp/load"
 	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
 	"github.com/spf13/cobra"
 )
 
 func Command() *cobra.Command {
 	var packageName string
 	var isCommand bool
+	var isPlugin bool
 	var outputPath string
 	var format bool
 	var useRawPointers bool
 	var noInternalThreads bool
+	var typedAPI bool
+	var boundsCheckElimination bool
+	var inlineThreshold int
+	var inlineProfile string
+	var outDir string
+	var functionsPerFile int
+	var filesPerPackage int
+	var importPath string
+	var link bool
+	var lineDirectives bool
+	var incremental bool
+	var asmFunctions []string
+	var asmAll bool
+	var asmProfile string
+	var asmAnnotations bool
 
 	command := &cobra.Command{
 		Use:   "compile",
 		Short: "Compile a WebAssembly module to Go source",
 		Long:  "Compile a WebAssembly module to Go source",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) != 1 {
+			switch {
+			case link && len(args) == 0:
+				return errors.New("expected at least one argument")
+			case !link && len(args) != 1:
 				return errors.New("expected exactly one argument")
 			}
 
-			if isCommand != (packageName == "") {
+			if isPlugin {
+				if packageName != "" || link {
+					return errors.New("--plugin cannot be used with --pkg or --link")
+				}
+				isCommand = false
+			} else if isCommand != (packageName == "") {
 				return errors.New("exactly one of --pkg and --cmd must be specified")
 			}
+			if link {
+				if isCommand {
+					return errors.New("--link requires --pkg")
+				}
+				if outDir != "" || inlineProfile != "" || lineDirectives || incremental {
+					return errors.New("--link cannot be used with --out-dir, --inline-profile, --line-directives, or --incremental")
+				}
+			}
+			if incremental {
+				if cmd.Flags().Changed("functions-per-file") {
+					return errors.New("--incremental cannot be used with --functions-per-file")
+				}
+				// Incremental compilations write one function per file.
+				functionsPerFile = 1
+			}
 
-			mod, err := load.LoadFile(args[0])
-			if err != nil {
-				return err
+			modules := make([]golang.LinkedModule, len(args))
+			for i, path := range args {
+				source, err := validate.Load(path)
+				if err != nil {
+					return err
+				}
+				if err := source.Validate(true); err != nil {
+					source.Print(os.Stderr, err)
+					return fmt.Errorf("%v is invalid", path)
+				}
+				mod := source.Module
+				modules[i] = golang.LinkedModule{Name: moduleName(path, mod), Module: mod}
 			}
+			mod, modName := modules[0].Module, modules[0].Name
 
 			baseName := filepath.Base(args[0])
 			baseName = baseName[:len(baseName)-len(filepath.Ext(baseName))]
 
+			options := golang.Options{
+				UseRawPointers:         useRawPointers,
+				NoInternalThreads:      noInternalThreads,
+				TypedAPI:               typedAPI,
+				BoundsCheckElimination: boundsCheckElimination,
+				InlineThreshold:        inlineThreshold,
+				FunctionsPerFile:       functionsPerFile,
+				FilesPerPackage:        filesPerPackage,
+				ImportPath:             importPath,
+				LineDirectives:         lineDirectives,
+				Incremental:            incremental,
+			}
+			if inlineProfile != "" {
+				f, err := os.Open(inlineProfile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				options.InlineProfile, err = golang.ReadInlineProfile(f, mod)
+				if err != nil {
+					return fmt.Errorf("reading inline profile: %w", err)
+				}
+			}
+
+			options.AllAssembly = asmAll
+			if len(asmFunctions) != 0 {
+				var err error
+				if options.AssemblyFunctions, err = golang.LookupFunctions(mod, asmFunctions); err != nil {
+					return fmt.Errorf("selecting functions for assembly: %w", err)
+				}
+			}
+			if asmAnnotations {
+				annotated, err := golang.ReadAssemblyAnnotations(mod)
+				if err != nil {
+					return err
+				}
+				if options.AssemblyFunctions == nil {
+					options.AssemblyFunctions = map[uint32]bool{}
+				}
+				for funcidx := range annotated {
+					options.AssemblyFunctions[funcidx] = true
+				}
+			}
+			if asmProfile != "" {
+				f, err := os.Open(asmProfile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				options.AssemblyProfile, err = golang.ReadInlineProfile(f, mod)
+				if err != nil {
+					return fmt.Errorf("reading assembly profile: %w", err)
+				}
+			}
+
+			if outDir != "" {
+				if outputPath != "" {
+					return errors.New("at most one of --out and --out-dir may be specified")
+				}
+				if filesPerPackage > 0 && importPath == "" {
+					var err error
+					if options.ImportPath, err = findImportPath(outDir); err != nil {
+						return err
+					}
+				}
+
+				if lineDirectives {
+					options.DisassemblyPath = baseName + disassemblyExt
+					if err := writeDisassembly(filepath.Join(outDir, options.DisassemblyPath), args[0], mod); err != nil {
+						return err
+					}
+				}
+
+				dir := golang.Directory(outDir)
+				if format {
+					dir = golang.FormatDirectory(dir)
+				}
+				switch {
+				case isPlugin:
+					if err := writePluginHash(outDir, baseName, mod); err != nil {
+						return err
+					}
+					return golang.CompilePluginDirectory(dir, modName, mod, &options)
+				case !isCommand:
+					return golang.CompileModuleDirectory(dir, packageName, modName, mod, &options)
+				}
+				return golang.CompileCommandDirectory(dir, modName, mod, &options)
+			}
+
+			if incremental {
+				return errors.New("--incremental requires --out-dir")
+			}
+
 			var dest io.Writer
 			switch outputPath {
 			case "":
@@ -64,6 +215,18 @@ func Command() *cobra.Command {
 				dest = f
 			}
 
+			if lineDirectives {
+				// The disassembly is written alongside the output.
+				options.DisassemblyPath = baseName + disassemblyExt
+				if outputPath != "" && outputPath != "-" {
+					name := filepath.Base(outputPath)
+					options.DisassemblyPath = strings.TrimSuffix(name, filepath.Ext(name)) + disassemblyExt
+				}
+				if err := writeDisassembly(filepath.Join(filepath.Dir(outputPath), options.DisassemblyPath), args[0], mod); err != nil {
+					return err
+				}
+			}
+
 			w := bufio.NewWriter(dest)
 			defer w.Flush()
 			dest = w
@@ -72,21 +235,16 @@ func Command() *cobra.Command {
 				dest = golang.Format(dest)
 			}
 
-			modName := ""
-			if names, err := mod.Names(); err == nil {
-				for _, entry := range names.Entries {
-					if m, ok := entry.(*wasm.ModuleNameSubsection); ok {
-						modName = m.Name
+			if link {
+				return golang.CompileLinkedModules(dest, packageName, modules, &options)
+			}
+			if isPlugin {
+				if outputPath != "-" {
+					if err := writePluginHash(filepath.Dir(outputPath), baseName, mod); err != nil {
+						return err
 					}
 				}
-			}
-			if modName == "" {
-				modName = baseName
-			}
-
-			options := golang.Options{
-				UseRawPointers:    useRawPointers,
-				NoInternalThreads: noInternalThreads,
+				return golang.CompilePlugin(dest, modName, mod, &options)
 			}
 			if !isCommand {
 				return golang.CompileModule(dest, packageName, modName, mod, &options)
@@ -97,11 +255,135 @@ func Command() *cobra.Command {
 	}
 
 	command.PersistentFlags().StringVar(&packageName, "pkg", "", "the name of the generated package")
-	command.PersistentFlags().BoolVarP(&isCommand, "cmd", "c", true, "true to automatically detect WASI commands")
+	command.PersistentFlags().BoolVarP(&isCommand, "cmd", "c", true, "true to compile a WASI or Go command. Modules that import from the go module are compiled as Go commands")
+	command.PersistentFlags().BoolVar(&isPlugin, "plugin", false, "true to compile a package main that exports the module's definition for use as a Go plugin (go build -buildmode=plugin). The module's content hash is written alongside the output to a file named for the plugin, e.g. foo.so.hash for foo.wasm; build the plugin into the same directory or move the hash file with it. See 'warp run --plugin-dir'")
 	command.PersistentFlags().StringVarP(&outputPath, "out", "o", "", "the path for the output file. Defaults to the name of the input file + '.go'")
+	command.PersistentFlags().StringVar(&outDir, "out-dir", "", "the path for an output directory. If set, the module's declarations are written to module.go and its functions are split across multiple files. Unless --incremental is set, the directory should not contain the output of a previous compilation")
+	command.PersistentFlags().IntVar(&functionsPerFile, "functions-per-file", golang.DefaultFunctionsPerFile, "the number of functions to write to each file when --out-dir is set. Cannot be used with --incremental")
+	command.PersistentFlags().IntVar(&filesPerPackage, "files-per-package", 0, "if set, shard the functions written by --out-dir into sub-packages of at most this many files each")
+	command.PersistentFlags().StringVar(&importPath, "import-path", "", "the import path of the directory given by --out-dir. Defaults to the path implied by the enclosing go.mod")
+	command.PersistentFlags().BoolVar(&incremental, "incremental", false, "write each function to a file named for a hash of its body and context, and skip functions that are unchanged since the previous compilation into --out-dir, leaving their files untouched. The function-to-file mapping is recorded in manifest.json. The files form a single package that go build recompiles whenever any function changes; use --files-per-package so that only the packages that contain changed functions are recompiled. Cannot be used with --functions-per-file")
+	command.PersistentFlags().BoolVar(&link, "link", false, "compile each argument into the same package and resolve imports between the modules statically. Requires --pkg")
 	command.PersistentFlags().BoolVarP(&format, "format", "f", false, "true to gofmt the generated source code")
 	command.PersistentFlags().BoolVar(&useRawPointers, "raw-pointers", false, "true to compile loads and stores to raw pointer accesses")
 	command.PersistentFlags().BoolVar(&noInternalThreads, "no-internal-threads", false, "true to elide stack depth tracking in generated code")
+	command.PersistentFlags().BoolVar(&typedAPI, "typed", false, "true to generate a typed Go API for the module's exports and imports")
+	command.PersistentFlags().BoolVar(&boundsCheckElimination, "bce", false, "true to elide memory bounds checks that are proven redundant. Has no effect with --raw-pointers")
+	command.PersistentFlags().IntVar(&inlineThreshold, "inline", 0, "inline calls to leaf functions with at most this many instructions (use --inline=N to set the threshold)")
+	command.PersistentFlags().Lookup("inline").NoOptDefVal = strconv.Itoa(golang.DefaultInlineThreshold)
+	command.PersistentFlags().StringVar(&inlineProfile, "inline-profile", "", "a profile written by 'warp run --profile' used to guide inlining")
+	command.PersistentFlags().StringSliceVar(&asmFunctions, "asm", nil, "experimental: compile the named functions to Go assembly for amd64. Requires --out-dir")
+	command.PersistentFlags().BoolVar(&asmAll, "asm-all", false, "experimental: compile all functions to Go assembly for amd64. Requires --out-dir")
+	command.PersistentFlags().StringVar(&asmProfile, "asm-profile", "", "experimental: compile the functions that account for at least 1% of the calls in a profile written by 'warp run --profile' to Go assembly for amd64. Requires --out-dir")
+	command.PersistentFlags().BoolVar(&asmAnnotations, "asm-annotations", false, "experimental: compile the functions listed by the module's warp.asm custom section to Go assembly for amd64. Requires --out-dir")
+	command.PersistentFlags().BoolVar(&lineDirectives, "line-directives", false, "true to emit //line directives that map the generated code to the module's DWARF source or to its text format, which is written alongside the output with the extension '.disasm.wat'")
 
 	return command
 }
+
+// disassemblyExt is the extension of the module disassembly written by --line-directives. It differs from ".wat" so
+// that the disassembly of a text-format input is not written over the input.
+const disassemblyExt = ".disasm.wat"
+
+// writePluginHash records the content hash of the module in the given directory for the plugin that is built from the
+// compiled module. The plugin is named for the module's file.
+func writePluginHash(dir, baseName string, mod *wasm.Module) error {
+	hash, ok := wasm.ContentHash(mod)
+	if !ok {
+		return nil
+	}
+	return load.WritePluginHash(filepath.Join(dir, baseName+".so"), hash)
+}
+
+// writeDisassembly writes the text format of the given module to the given path. It refuses to overwrite the file at
+// inputPath.
+func writeDisassembly(path, inputPath string, mod *wasm.Module) error {
+	// The input may itself be in the text format, so never write the disassembly over it.
+	if same, err := samePath(path, inputPath); err != nil {
+		return err
+	} else if same {
+		return fmt.Errorf("the disassembly of %v would overwrite the input", inputPath)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return wast.WriteTo(f, mod)
+}
+
+// samePath returns true if the given paths refer to the same file.
+func samePath(a, b string) (bool, error) {
+	a, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	b, err = filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return a == b, nil
+}
+
+// moduleName returns the name of the given module. The name is taken from the module's name section if present, and
+// from the name of the file that contains the module otherwise.
+func moduleName(path string, mod *wasm.Module) string {
+	if names, err := mod.Names(); err == nil {
+		for _, entry := range names.Entries {
+			if m, ok := entry.(*wasm.ModuleNameSubsection); ok && m.Name != "" {
+				return m.Name
+			}
+		}
+	}
+
+	name := filepath.Base(path)
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+// findImportPath returns the import path of the given directory as determined by the nearest enclosing go.mod.
+func findImportPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for root := dir; ; {
+		data, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+		switch {
+		case err == nil:
+			modulePath := modulePath(data)
+			if modulePath == "" {
+				return "", fmt.Errorf("%v: missing module path", filepath.Join(root, "go.mod"))
+			}
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return "", err
+			}
+			return path.Join(modulePath, filepath.ToSlash(rel)), nil
+		case !os.IsNotExist(err):
+			return "", err
+		}
+
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", fmt.Errorf("could not determine the import path of %v; use --import-path", dir)
+		}
+		root = parent
+	}
+}
+
+// modulePath returns the module path declared by the given go.mod file.
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			if p, err := strconv.Unquote(fields[1]); err == nil {
+				return p
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
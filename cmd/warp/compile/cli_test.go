@@ -0,0 +1,133 @@
+package compile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pgavlin/warp/cmd/warp/validate"
+	"github.com/pgavlin/warp/load"
+	"github.com/pgavlin/warp/wasm"
+)
+
+const fooWAT = `;; foo adds one to its argument.
+(module
+  (func (export "add1") (param i32) (result i32)
+    (i32.add (local.get 0) (i32.const 1))))
+`
+
+// fooWasm is the binary encoding of fooWAT.
+var fooWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x06, 0x01, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x03, 0x02, 0x01, 0x00,
+	0x07, 0x08, 0x01, 0x04, 'a', 'd', 'd', '1', 0x00, 0x00,
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x41, 0x01, 0x6a, 0x0b,
+}
+
+func TestLineDirectivesTextInput(t *testing.T) {
+	cases := []struct {
+		name   string
+		args   []string
+		output string
+	}{
+		{"default", nil, "foo.go"},
+		{"out", []string{"--out", "bar.go"}, "bar.go"},
+		{"out-dir", []string{"--out-dir", "out"}, filepath.Join("out", "functions_0.go")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(dir))
+			defer func() { require.NoError(t, os.Chdir(wd)) }()
+
+			require.NoError(t, ioutil.WriteFile("foo.wat", []byte(fooWAT), 0600))
+			if c.name == "out-dir" {
+				require.NoError(t, os.Mkdir("out", 0700))
+			}
+
+			command := Command()
+			command.SetArgs(append([]string{"--cmd=false", "--pkg", "foo", "--line-directives"}, append(c.args, "foo.wat")...))
+			require.NoError(t, command.Execute())
+
+			// The input is left untouched.
+			source, err := ioutil.ReadFile("foo.wat")
+			require.NoError(t, err)
+			assert.Equal(t, fooWAT, string(source))
+
+			// The disassembly is written alongside the output, and the line directives refer to it.
+			output, err := ioutil.ReadFile(c.output)
+			require.NoError(t, err)
+			disassembly := strings.TrimSuffix(filepath.Base(c.output), ".go") + disassemblyExt
+			if c.name == "out-dir" {
+				disassembly = "foo" + disassemblyExt
+			}
+			assert.Contains(t, string(output), "//line "+disassembly+":")
+			assert.FileExists(t, filepath.Join(filepath.Dir(c.output), disassembly))
+		})
+	}
+}
+
+func TestWriteDisassemblyRefusesInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo.wat")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fooWAT), 0600))
+
+	err := writeDisassembly(filepath.Join(filepath.Dir(path), ".", "foo.wat"), path, nil)
+	assert.Error(t, err)
+
+	source, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, fooWAT, string(source))
+}
+
+func TestIncrementalFunctionsPerFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "foo.wat")
+	require.NoError(t, ioutil.WriteFile(input, []byte(fooWAT), 0600))
+
+	// Incremental compilations write one function per file, so an explicit --functions-per-file is rejected.
+	command := Command()
+	command.SetArgs([]string{"--pkg", "foo", "--cmd=false", "--out-dir", dir, "--incremental", "--functions-per-file", "2", input})
+	command.SilenceUsage, command.SilenceErrors = true, true
+	assert.EqualError(t, command.Execute(), "--incremental cannot be used with --functions-per-file")
+
+	command = Command()
+	command.SetArgs([]string{"--pkg", "foo", "--cmd=false", "--out-dir", dir, "--incremental", input})
+	require.NoError(t, command.Execute())
+	assert.FileExists(t, filepath.Join(dir, "manifest.json"))
+}
+
+func TestPluginHash(t *testing.T) {
+	// Text-format modules cannot be hashed, so the input is the binary encoding of fooWAT.
+	dir := t.TempDir()
+	input := filepath.Join(dir, "foo.wasm")
+	require.NoError(t, ioutil.WriteFile(input, fooWasm, 0600))
+
+	source, err := validate.Load(input)
+	require.NoError(t, err)
+	hash, ok := wasm.ContentHash(source.Module)
+	require.True(t, ok)
+
+	// The hash is written alongside the output, named for the plugin built from it.
+	out, outDir := filepath.Join(dir, "out"), filepath.Join(dir, "outdir")
+	require.NoError(t, os.Mkdir(out, 0700))
+	require.NoError(t, os.Mkdir(outDir, 0700))
+	for _, args := range [][]string{{"--out", filepath.Join(out, "bar.go")}, {"--out-dir", outDir}} {
+		command := Command()
+		command.SetArgs(append([]string{"--plugin"}, append(args, input)...))
+		require.NoError(t, command.Execute())
+	}
+
+	for _, d := range []string{out, outDir} {
+		recorded, err := ioutil.ReadFile(filepath.Join(d, "foo.so"+load.PluginHashExt))
+		require.NoError(t, err)
+		assert.Equal(t, hash+"\n", string(recorded))
+	}
+}
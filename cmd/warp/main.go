@@ -11,6 +11,7 @@ import (
 	"github.com/pgavlin/warp/cmd/warp/compile"
 	"github.com/pgavlin/warp/cmd/warp/dump"
 	"github.com/pgavlin/warp/cmd/warp/run"
+	"github.com/pgavlin/warp/cmd/warp/validate"
 	"github.com/pgavlin/warp/wasi"
 )
 
@@ -58,6 +59,9 @@ func configureCLI() *cobra.Command {
 	rootCommand.AddCommand(compile.Command())
 	rootCommand.AddCommand(dump.Command())
 	rootCommand.AddCommand(run.Command())
+	rootCommand.AddCommand(run.DAPCommand())
+	rootCommand.AddCommand(run.DebugCommand())
+	rootCommand.AddCommand(validate.Command())
 
 	rootCommand.PersistentFlags().StringVar(&cpuProfile, "cpu", "", "emit Go CPU profile data to this path")
 	rootCommand.PersistentFlags().StringVar(&memProfile, "mem", "", "emit Go memory profile data to this path")
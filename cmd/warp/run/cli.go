@@ -11,9 +11,13 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/pgavlin/warp/exec"
 	"github.com/pgavlin/warp/go_wasm_exec"
+	"github.com/pgavlin/warp/interpreter"
 	"github.com/pgavlin/warp/load"
 	"github.com/pgavlin/warp/wasi"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
 
 	"github.com/spf13/cobra"
 )
@@ -217,10 +221,119 @@ func (p *preopens) Type() string {
 	return "mount"
 }
 
+// A program is a WebAssembly command to run.
+type program struct {
+	path      string                    // The path to the program's module.
+	module    *wasm.Module              // The program's module.
+	def       exec.ModuleDefinition     // The definition of the program's module.
+	interpret load.ModuleDefinitionFunc // The function used to define modules imported by the program.
+
+	args    []string       // The program's arguments.
+	preopen []wasi.Preopen // The directories to mount in a WASI program's filesystem.
+	debug   bool           // True to enable debugging support.
+	trace   io.Writer      // The execution trace writer, if any.
+
+	coverage *exec.Coverage // The coverage record, if any.
+}
+
+// run runs the program inside a WASI- or Go-compliant environment.
+func (p *program) run() error {
+	isGo := false
+	if p.module.Import != nil {
+		for _, entry := range p.module.Import.Entries {
+			if entry.ModuleName == "go" {
+				isGo = true
+				break
+			}
+		}
+	}
+
+	env := map[string]string{}
+	for _, v := range os.Environ() {
+		kvp := strings.SplitN(v, "=", 2)
+		env[kvp[0]] = kvp[1]
+	}
+
+	ext := filepath.Ext(p.path)
+	name := p.path[:len(p.path)-len(ext)]
+
+	if isGo {
+		return go_wasm_exec.Run(name, p.def, &go_wasm_exec.Options{
+			Env:  env,
+			Args: p.args,
+
+			Debug:    p.debug,
+			Trace:    p.trace,
+			Coverage: p.coverage,
+			Resolver: load.NewFSResolver(os.DirFS("."), p.interpret),
+		})
+	}
+
+	return wasi.Run(name, p.def, &wasi.RunOptions{
+		Options: &wasi.Options{
+			Env:     env,
+			Args:    p.args,
+			Preopen: p.preopen,
+		},
+		Debug:    p.debug,
+		Trace:    p.trace,
+		Coverage: p.coverage,
+		Resolver: load.NewFSResolver(os.DirFS("."), p.interpret),
+	})
+}
+
+// writeCoverage writes an lcov report of the program's coverage to the given path. If any of the program's functions
+// lack line information, they are reported against the program's disassembly, which is written alongside the report.
+func (p *program) writeCoverage(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	watPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".wat"
+	disassembled, err := interpreter.WriteLCOV(f, p.module, p.coverage.Module(""), watPath)
+	if err != nil || !disassembled {
+		return err
+	}
+
+	wat, err := os.Create(watPath)
+	if err != nil {
+		return err
+	}
+	defer wat.Close()
+
+	return wast.WriteTo(wat, p.module)
+}
+
+// resolvePlugin returns the definition in the plugin for the module at the given path if the plugin was compiled from
+// the same module. The plugin is named for the module's file. If there is no such plugin, resolvePlugin returns nil. If
+// the plugin exists but cannot be opened or loaded, resolvePlugin returns an error.
+func resolvePlugin(dir, path string, mod *wasm.Module) (exec.ModuleDefinition, error) {
+	hash, ok := wasm.ContentHash(mod)
+	if !ok {
+		return nil, nil
+	}
+
+	name := filepath.Base(path)
+	name = name[:len(name)-len(filepath.Ext(name))]
+
+	def, err := load.NewPluginResolver(dir).ResolveModuleHash(name, hash)
+	if err == exec.ErrModuleNotFound {
+		return nil, nil
+	}
+	return def, err
+}
+
 func Command() *cobra.Command {
 	var preopen preopens
 	var debug bool
 	var trace string
+	var cacheDir string
+	var profile string
+	var coverage string
+	var pluginDir string
+	var mmap bool
 
 	command := &cobra.Command{
 		Use:   "run [path to module]",
@@ -231,34 +344,44 @@ func Command() *cobra.Command {
 				return errors.New("expected at least one argument")
 			}
 
-			mod, err := load.LoadFile(args[0])
-			if err != nil {
-				return err
-			}
-
-			isGo := false
-			if mod.Import != nil {
-				for _, entry := range mod.Import.Entries {
-					if entry.ModuleName == "go" {
-						isGo = true
-						break
-					}
+			var mod *wasm.Module
+			var err error
+			if mmap {
+				mapping, err := load.MapFile(args[0])
+				if err != nil {
+					return err
+				}
+				defer mapping.Close()
+				mod = mapping.Module
+			} else {
+				if mod, err = load.LoadFile(args[0]); err != nil {
+					return err
 				}
 			}
 
-			def, err := load.Intepret(mod)
-			if err != nil {
-				return err
+			var profiler *interpreter.Profiler
+			if profile != "" {
+				profiler = interpreter.NewProfiler()
 			}
 
-			env := map[string]string{}
-			for _, v := range os.Environ() {
-				kvp := strings.SplitN(v, "=", 2)
-				env[kvp[0]] = kvp[1]
+			interpret := load.Intepret
+			if cacheDir != "" || profiler != nil {
+				interpret = load.Interpreter(&interpreter.Options{CacheDir: cacheDir, Profiler: profiler})
 			}
 
-			ext := filepath.Ext(args[0])
-			name := args[0][:len(args[0])-len(ext)]
+			var def exec.ModuleDefinition
+			if pluginDir != "" && !debug && trace == "" && profiler == nil && coverage == "" {
+				// A plugin that fails to load is not fatal: the module is interpreted instead.
+				if def, err = resolvePlugin(pluginDir, args[0], mod); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to load plugin for %v; interpreting the module instead: %v\n", args[0], err)
+					def = nil
+				}
+			}
+			if def == nil {
+				if def, err = interpret(mod); err != nil {
+					return err
+				}
+			}
 
 			var traceWriter io.Writer
 			if trace != "" {
@@ -283,33 +406,51 @@ func Command() *cobra.Command {
 				traceWriter = w
 			}
 
-			if isGo {
-				return go_wasm_exec.Run(name, def, &go_wasm_exec.Options{
-					Env:  env,
-					Args: args[1:],
+			p := program{
+				path:      args[0],
+				module:    mod,
+				def:       def,
+				interpret: interpret,
+				args:      args[1:],
+				preopen:   preopen.values,
+				debug:     debug,
+				trace:     traceWriter,
+			}
+			if coverage != "" {
+				p.coverage = exec.NewCoverage()
+			}
+			err = p.run()
+
+			if p.coverage != nil {
+				if cerr := p.writeCoverage(coverage); cerr != nil {
+					return cerr
+				}
+			}
 
-					Debug:    debug,
-					Trace:    traceWriter,
-					Resolver: load.NewFSResolver(os.DirFS("."), load.Intepret),
-				})
+			if profiler != nil {
+				f, perr := os.Create(profile)
+				if perr != nil {
+					return perr
+				}
+				defer f.Close()
+
+				if perr = profiler.WriteProfile(f); perr != nil {
+					return perr
+				}
 			}
 
-			return wasi.Run(name, def, &wasi.RunOptions{
-				Options: &wasi.Options{
-					Env:     env,
-					Args:    args[1:],
-					Preopen: preopen.values,
-				},
-				Debug:    debug,
-				Trace:    traceWriter,
-				Resolver: load.NewFSResolver(os.DirFS("."), load.Intepret),
-			})
+			return err
 		},
 	}
 
 	command.PersistentFlags().VarP(&preopen, "mount", "m", "list of directories to mount in the form (to=)from(,flags)")
 	command.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debugging support")
 	command.PersistentFlags().StringVarP(&trace, "trace", "t", "", "write an execution trace to the specified file. Implies -d.")
+	command.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "cache compiled code in the specified directory")
+	command.PersistentFlags().StringVar(&profile, "profile", "", "write a pprof profile of the program's functions to the specified file")
+	command.PersistentFlags().StringVar(&pluginDir, "plugin-dir", "", "run the program's module using a plugin compiled by 'warp compile --plugin' from the specified directory if the hash recorded alongside the plugin matches the module, falling back to the interpreter if the plugin fails to load. Ignored when debugging, tracing, profiling, or collecting coverage")
+	command.PersistentFlags().BoolVar(&mmap, "mmap", false, "map the program's module into memory rather than reading it. Function bodies are only read when they are first called")
+	command.PersistentFlags().StringVar(&coverage, "coverage", "", "write an lcov coverage report for the program to the specified file")
 
 	return command
 }
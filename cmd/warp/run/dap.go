@@ -0,0 +1,26 @@
+package run
+
+import (
+	"os"
+
+	"github.com/pgavlin/warp/dap"
+
+	"github.com/spf13/cobra"
+)
+
+func DAPCommand() *cobra.Command {
+	var preopen preopens
+
+	command := &cobra.Command{
+		Use:   "dap",
+		Short: "Run a Debug Adapter Protocol server",
+		Long:  "Run a Debug Adapter Protocol server that debugs WASI commands. The server communicates over stdin and stdout.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dap.Serve(os.Stdin, os.Stdout, &dap.Options{Preopen: preopen.values})
+		},
+	}
+
+	command.PersistentFlags().VarP(&preopen, "mount", "m", "list of directories to mount in the form (to=)from(,flags)")
+
+	return command
+}
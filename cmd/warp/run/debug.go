@@ -0,0 +1,622 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/interpreter"
+	"github.com/pgavlin/warp/load"
+	"github.com/pgavlin/warp/wasi"
+	"github.com/pgavlin/warp/wasm"
+
+	"github.com/spf13/cobra"
+)
+
+// errQuit is raised from within a stopped program when the user asks to quit.
+var errQuit = errors.New("quit")
+
+const debugHelp = `Commands:
+  run, r                      start the program
+  break, b LOCATION           set a breakpoint at FILE:LINE, FUNCTION[+OFFSET], or INDEX[+OFFSET]
+  delete, d [ID...]           delete the given breakpoints, or all breakpoints
+  info breakpoints            list breakpoints
+  continue, c                 continue execution
+  step, s                     execute the next instruction, stepping into calls
+  next, n                     execute the next instruction, stepping over calls
+  finish                      run until the current function returns
+  backtrace, bt               print the stopped frames
+  frame, f [N]                select frame N, or describe the selected frame
+  up, down                    select the caller or callee of the selected frame
+  list, l                     print the instructions around the selected frame's IP
+  locals, info locals         print the selected frame's locals
+  stack                       print the selected frame's operand stack
+  globals, info globals       print the selected frame's module's globals
+  x ADDRESS [LENGTH]          dump LENGTH bytes of memory starting at ADDRESS
+  set local|global INDEX VAL  set the value of a local or global
+  help                        print this message
+  quit, q                     exit the debugger
+`
+
+// A debugSession is an interactive debugging session.
+type debugSession struct {
+	debugger *interpreter.Debugger
+	program  program
+
+	in  *bufio.Scanner
+	out io.Writer
+
+	resume interpreter.ResumeMode // The mode in which execution was last resumed.
+	stop   *interpreter.Stop      // The current stop, if the program is stopped.
+	frame  int                    // The index of the selected frame.
+}
+
+func DebugCommand() *cobra.Command {
+	var preopen preopens
+
+	command := &cobra.Command{
+		Use:   "debug [path to module]",
+		Short: "Debug WebAssembly commands",
+		Long:  "Run WebAssembly commands under an interactive debugger.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("expected at least one argument")
+			}
+
+			mod, err := load.LoadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			s := &debugSession{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+			s.debugger = interpreter.NewDebugger(s.stopped)
+			s.program = program{
+				path:      args[0],
+				module:    mod,
+				def:       interpreter.NewModuleDefinition(mod, &interpreter.Options{Debugger: s.debugger}),
+				interpret: load.Intepret,
+				args:      args[1:],
+				preopen:   preopen.values,
+			}
+			s.loop()
+			return nil
+		},
+	}
+
+	command.PersistentFlags().VarP(&preopen, "mount", "m", "list of directories to mount in the form (to=)from(,flags)")
+
+	return command
+}
+
+// read prompts for and reads the next command. read returns false if the input has been exhausted.
+func (s *debugSession) read() (string, []string, bool) {
+	for {
+		fmt.Fprint(s.out, "(warp) ")
+		if !s.in.Scan() {
+			fmt.Fprintln(s.out)
+			return "", nil, false
+		}
+		if fields := strings.Fields(s.in.Text()); len(fields) != 0 {
+			return fields[0], fields[1:], true
+		}
+	}
+}
+
+// loop runs the debugger's top-level command loop.
+func (s *debugSession) loop() {
+	fmt.Fprintln(s.out, `Type "help" for a list of commands.`)
+	for {
+		cmd, args, ok := s.read()
+		if !ok {
+			return
+		}
+		switch cmd {
+		case "run", "r":
+			if s.run() {
+				return
+			}
+		case "quit", "q":
+			return
+		default:
+			s.command(cmd, args)
+		}
+	}
+}
+
+// run runs the program to completion. run returns true if the user quit while the program was stopped.
+func (s *debugSession) run() (quit bool) {
+	interrupts, done := make(chan os.Signal, 1), make(chan struct{})
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for {
+			select {
+			case <-interrupts:
+				s.debugger.Pause()
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		signal.Stop(interrupts)
+		close(done)
+	}()
+
+	defer func() {
+		if x := recover(); x != nil {
+			if x == errQuit {
+				quit = true
+				return
+			}
+			fmt.Fprintf(s.out, "Program trapped: %v\n", x)
+		}
+	}()
+
+	s.resume = interpreter.Continue
+	err := s.program.run()
+	if exit, ok := err.(*wasi.ExitError); ok {
+		fmt.Fprintf(s.out, "Program exited with code %d.\n", exit.Code())
+	} else if err != nil {
+		fmt.Fprintf(s.out, "Program failed: %v\n", err)
+	} else {
+		fmt.Fprintln(s.out, "Program exited normally.")
+	}
+	return false
+}
+
+// stopped handles a stop in the program's execution.
+func (s *debugSession) stopped(stop *interpreter.Stop) interpreter.ResumeMode {
+	// A step that was requested during a previous run may complete in this one.
+	if stop.Reason == interpreter.StopStep && s.resume == interpreter.Continue {
+		return interpreter.Continue
+	}
+
+	s.stop, s.frame = stop, 0
+	defer func() { s.stop = nil }()
+
+	switch stop.Reason {
+	case interpreter.StopBreakpoint:
+		fmt.Fprintf(s.out, "Breakpoint %d, ", stop.Breakpoint.ID)
+	case interpreter.StopPause:
+		fmt.Fprint(s.out, "Paused, ")
+	}
+	s.printFrame(0)
+
+	for {
+		cmd, args, ok := s.read()
+		if !ok {
+			panic(errQuit)
+		}
+
+		switch cmd {
+		case "continue", "c":
+			s.resume = interpreter.Continue
+		case "step", "s":
+			s.resume = interpreter.StepInto
+		case "next", "n":
+			s.resume = interpreter.StepOver
+		case "finish":
+			s.resume = interpreter.StepOut
+		case "run", "r":
+			fmt.Fprintln(s.out, "The program is already running.")
+			continue
+		case "quit", "q":
+			panic(errQuit)
+		default:
+			s.command(cmd, args)
+			continue
+		}
+		return s.resume
+	}
+}
+
+// command runs a command that does not affect the program's execution.
+func (s *debugSession) command(cmd string, args []string) {
+	if cmd == "info" || cmd == "i" {
+		if len(args) == 0 {
+			fmt.Fprintln(s.out, `"info" must be followed by "breakpoints", "locals", or "globals".`)
+			return
+		}
+		cmd, args = args[0], args[1:]
+		switch cmd {
+		case "breakpoints", "break", "b":
+			s.listBreakpoints()
+			return
+		case "locals", "globals":
+		default:
+			fmt.Fprintf(s.out, "Unknown info command %q.\n", cmd)
+			return
+		}
+	}
+
+	switch cmd {
+	case "help", "h":
+		fmt.Fprint(s.out, debugHelp)
+		return
+	case "break", "b":
+		s.setBreakpoint(args)
+		return
+	case "delete", "d":
+		s.deleteBreakpoints(args)
+		return
+	case "backtrace", "bt", "where", "frame", "f", "up", "down", "list", "l", "locals", "stack", "globals", "x", "set":
+		if s.stop == nil {
+			fmt.Fprintln(s.out, "The program is not stopped.")
+			return
+		}
+	default:
+		fmt.Fprintf(s.out, "Unknown command %q. Try \"help\".\n", cmd)
+		return
+	}
+
+	switch cmd {
+	case "backtrace", "bt", "where":
+		for i := range s.stop.Frames {
+			s.printFrame(i)
+		}
+	case "frame", "f":
+		if len(args) != 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 || n >= len(s.stop.Frames) {
+				fmt.Fprintf(s.out, "No frame %v.\n", args[0])
+				return
+			}
+			s.frame = n
+		}
+		s.printFrame(s.frame)
+	case "up":
+		if s.frame == len(s.stop.Frames)-1 {
+			fmt.Fprintln(s.out, "Initial frame selected; you cannot go up.")
+			return
+		}
+		s.frame++
+		s.printFrame(s.frame)
+	case "down":
+		if s.frame == 0 {
+			fmt.Fprintln(s.out, "Bottom (innermost) frame selected; you cannot go down.")
+			return
+		}
+		s.frame--
+		s.printFrame(s.frame)
+	case "list", "l":
+		s.list()
+	case "locals":
+		s.printLocals()
+	case "stack":
+		s.printStack()
+	case "globals":
+		s.printGlobals()
+	case "x":
+		s.dumpMemory(args)
+	case "set":
+		s.set(args)
+	}
+}
+
+// functionName returns a printable name for the given frame's function.
+func functionName(frame *interpreter.DebugFrame) string {
+	if name, ok := frame.FunctionName(); ok {
+		return fmt.Sprintf("%s (func %d)", name, frame.Function)
+	}
+	return fmt.Sprintf("func %d", frame.Function)
+}
+
+// printFrame describes the n'th frame.
+func (s *debugSession) printFrame(n int) {
+	frame := s.stop.Frames[n]
+
+	fmt.Fprintf(s.out, "#%d ", n)
+	if frame.Module != "" {
+		fmt.Fprintf(s.out, "%s.", frame.Module)
+	}
+	fmt.Fprint(s.out, functionName(frame))
+	if frame.IP >= 0 {
+		fmt.Fprintf(s.out, " at %d", frame.IP)
+		if file, line, ok := frame.SourceLocation(); ok {
+			fmt.Fprintf(s.out, " (%s:%d)", file, line)
+		}
+	}
+	fmt.Fprintln(s.out)
+}
+
+func (s *debugSession) selectedFrame() *interpreter.DebugFrame {
+	return s.stop.Frames[s.frame]
+}
+
+// list prints the instructions around the selected frame's IP.
+func (s *debugSession) list() {
+	frame := s.selectedFrame()
+	if frame.IP < 0 {
+		fmt.Fprintln(s.out, "The frame's position is not known.")
+		return
+	}
+
+	instructions := frame.Instructions()
+	start, end := frame.IP-5, frame.IP+6
+	if start < 0 {
+		start = 0
+	}
+	if end > len(instructions) {
+		end = len(instructions)
+	}
+	for ip := start; ip < end; ip++ {
+		marker := "  "
+		if ip == frame.IP {
+			marker = "=>"
+		}
+		fmt.Fprintf(s.out, "%s %4d  %v\n", marker, ip, &instructions[ip])
+	}
+}
+
+// formatValue formats a raw value of the given type.
+func formatValue(t wasm.ValueType, v uint64) string {
+	switch t {
+	case wasm.ValueTypeI32:
+		return fmt.Sprintf("%d (0x%x)", int32(v), uint32(v))
+	case wasm.ValueTypeI64:
+		return fmt.Sprintf("%d (0x%x)", int64(v), v)
+	case wasm.ValueTypeF32:
+		return fmt.Sprint(math.Float32frombits(uint32(v)))
+	case wasm.ValueTypeF64:
+		return fmt.Sprint(math.Float64frombits(v))
+	default:
+		return fmt.Sprintf("0x%x", v)
+	}
+}
+
+// parseValue parses a raw value of the given type.
+func parseValue(t wasm.ValueType, s string) (uint64, error) {
+	switch t {
+	case wasm.ValueTypeI32:
+		v, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			u, uerr := strconv.ParseUint(s, 0, 32)
+			if uerr != nil {
+				return 0, err
+			}
+			v = int64(u)
+		}
+		return uint64(uint32(v)), nil
+	case wasm.ValueTypeI64:
+		v, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			u, uerr := strconv.ParseUint(s, 0, 64)
+			if uerr != nil {
+				return 0, err
+			}
+			return u, nil
+		}
+		return uint64(v), nil
+	case wasm.ValueTypeF32:
+		v, err := strconv.ParseFloat(s, 32)
+		return uint64(math.Float32bits(float32(v))), err
+	case wasm.ValueTypeF64:
+		v, err := strconv.ParseFloat(s, 64)
+		return math.Float64bits(v), err
+	default:
+		return strconv.ParseUint(s, 0, 64)
+	}
+}
+
+func (s *debugSession) printLocals() {
+	frame := s.selectedFrame()
+	if frame.Locals == nil {
+		fmt.Fprintln(s.out, "The frame's locals are not available.")
+		return
+	}
+
+	types := frame.LocalTypes()
+	for i, v := range frame.Locals {
+		fmt.Fprintf(s.out, "$%d %v = %s\n", i, types[i], formatValue(types[i], v))
+	}
+}
+
+func (s *debugSession) printStack() {
+	frame := s.selectedFrame()
+	if frame.Stack == nil {
+		fmt.Fprintln(s.out, "The frame's operand stack is not available.")
+		return
+	}
+	if len(frame.Stack) == 0 {
+		fmt.Fprintln(s.out, "The operand stack is empty.")
+		return
+	}
+
+	// The stack is untyped, so print each value as an integer.
+	for i := len(frame.Stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(s.out, "[%d] 0x%x\n", i, frame.Stack[i])
+	}
+}
+
+func (s *debugSession) printGlobals() {
+	frame := s.selectedFrame()
+	for i := 0; i < frame.Globals(); i++ {
+		global, _ := frame.Global(uint32(i))
+		typ := global.Type()
+
+		mut := ""
+		if typ.Mutable {
+			mut = "mut "
+		}
+		fmt.Fprintf(s.out, "$%d %s%v = %s\n", i, mut, typ.Type, formatValue(typ.Type, global.Get()))
+	}
+}
+
+func (s *debugSession) dumpMemory(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(s.out, "usage: x ADDRESS [LENGTH]")
+		return
+	}
+
+	mem := s.selectedFrame().Memory()
+	if mem == nil {
+		fmt.Fprintln(s.out, "The module has no memory.")
+		return
+	}
+	bytes := mem.Bytes()
+
+	address, err := strconv.ParseUint(args[0], 0, 32)
+	if err != nil {
+		fmt.Fprintf(s.out, "Invalid address %q.\n", args[0])
+		return
+	}
+	length := uint64(64)
+	if len(args) == 2 {
+		if length, err = strconv.ParseUint(args[1], 0, 32); err != nil {
+			fmt.Fprintf(s.out, "Invalid length %q.\n", args[1])
+			return
+		}
+	}
+	if address >= uint64(len(bytes)) {
+		fmt.Fprintf(s.out, "Address 0x%x is out of bounds.\n", address)
+		return
+	}
+	if end := uint64(len(bytes)); address+length > end {
+		length = end - address
+	}
+
+	for row := address; row < address+length; row += 16 {
+		end := row + 16
+		if end > address+length {
+			end = address + length
+		}
+		fmt.Fprintf(s.out, "%08x ", row)
+		for _, b := range bytes[row:end] {
+			fmt.Fprintf(s.out, " %02x", b)
+		}
+		fmt.Fprintln(s.out)
+	}
+}
+
+func (s *debugSession) set(args []string) {
+	if len(args) != 3 || (args[0] != "local" && args[0] != "global") {
+		fmt.Fprintln(s.out, "usage: set local|global INDEX VALUE")
+		return
+	}
+
+	index, err := strconv.ParseUint(args[1], 0, 32)
+	if err != nil {
+		fmt.Fprintf(s.out, "Invalid index %q.\n", args[1])
+		return
+	}
+
+	frame := s.selectedFrame()
+	var typ wasm.ValueType
+	var store func(v uint64)
+	if args[0] == "local" {
+		if frame.Locals == nil || index >= uint64(len(frame.Locals)) {
+			fmt.Fprintf(s.out, "No local %d.\n", index)
+			return
+		}
+		typ, store = frame.LocalTypes()[index], func(v uint64) { frame.Locals[index] = v }
+	} else {
+		var global *exec.Global
+		if global, _ = frame.Global(uint32(index)); index >= uint64(frame.Globals()) || global == nil {
+			fmt.Fprintf(s.out, "No global %d.\n", index)
+			return
+		}
+		if !global.Type().Mutable {
+			fmt.Fprintf(s.out, "Global %d is immutable.\n", index)
+			return
+		}
+		typ, store = global.Type().Type, global.Set
+	}
+
+	v, err := parseValue(typ, args[2])
+	if err != nil {
+		fmt.Fprintf(s.out, "Invalid %v %q.\n", typ, args[2])
+		return
+	}
+	store(v)
+}
+
+// setBreakpoint sets a breakpoint at a source line, a named function, or a function index.
+func (s *debugSession) setBreakpoint(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(s.out, "usage: break FILE:LINE | FUNCTION[+OFFSET] | INDEX[+OFFSET]")
+		return
+	}
+	location := args[0]
+
+	if colon := strings.LastIndexByte(location, ':'); colon != -1 {
+		if line, err := strconv.Atoi(location[colon+1:]); err == nil {
+			breakpoints, err := s.debugger.SetLineBreakpoint(location[:colon], line)
+			if err != nil {
+				fmt.Fprintf(s.out, "%v\n", err)
+				return
+			}
+			for _, b := range breakpoints {
+				s.printBreakpoint("Breakpoint ", b)
+			}
+			return
+		}
+	}
+
+	function, offset := location, 0
+	if plus := strings.LastIndexByte(location, '+'); plus != -1 {
+		o, err := strconv.Atoi(location[plus+1:])
+		if err != nil || o < 0 {
+			fmt.Fprintf(s.out, "Invalid offset %q.\n", location[plus+1:])
+			return
+		}
+		function, offset = location[:plus], o
+	}
+
+	var b *interpreter.Breakpoint
+	if index, err := strconv.ParseUint(function, 0, 32); err == nil {
+		b = s.debugger.SetBreakpoint(uint32(index), offset)
+	} else if b, err = s.debugger.SetFunctionBreakpoint(function, offset); err != nil {
+		fmt.Fprintf(s.out, "%v\n", err)
+		return
+	}
+	s.printBreakpoint("Breakpoint ", b)
+}
+
+func (s *debugSession) printBreakpoint(prefix string, b *interpreter.Breakpoint) {
+	fmt.Fprintf(s.out, "%s%d at func %d+%d", prefix, b.ID, b.Function, b.Offset)
+	if b.File != "" {
+		fmt.Fprintf(s.out, " (%s:%d)", b.File, b.Line)
+	}
+	fmt.Fprintln(s.out)
+}
+
+func (s *debugSession) listBreakpoints() {
+	breakpoints := s.debugger.Breakpoints()
+	if len(breakpoints) == 0 {
+		fmt.Fprintln(s.out, "No breakpoints.")
+		return
+	}
+	for _, b := range breakpoints {
+		s.printBreakpoint("", b)
+	}
+}
+
+func (s *debugSession) deleteBreakpoints(args []string) {
+	breakpoints := s.debugger.Breakpoints()
+	if len(args) == 0 {
+		for _, b := range breakpoints {
+			s.debugger.ClearBreakpoint(b)
+		}
+		return
+	}
+
+	byID := map[int]*interpreter.Breakpoint{}
+	for _, b := range breakpoints {
+		byID[b.ID] = b
+	}
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if b, ok := byID[id]; err == nil && ok {
+			s.debugger.ClearBreakpoint(b)
+		} else {
+			fmt.Fprintf(s.out, "No breakpoint %v.\n", arg)
+		}
+	}
+}
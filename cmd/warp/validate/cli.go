@@ -0,0 +1,44 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func Command() *cobra.Command {
+	var first bool
+
+	command := &cobra.Command{
+		Use:   "validate [path to module]...",
+		Short: "Validate WebAssembly modules",
+		Long:  "Validate WebAssembly modules and report the location of each error",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("expected at least one argument")
+			}
+
+			invalid := 0
+			for _, path := range args {
+				source, err := Load(path)
+				if err != nil {
+					return err
+				}
+				if err := source.Validate(!first); err != nil {
+					source.Print(os.Stderr, err)
+					invalid++
+				}
+			}
+			if invalid != 0 {
+				return fmt.Errorf("%v of %v modules are invalid", invalid, len(args))
+			}
+			return nil
+		},
+	}
+
+	command.PersistentFlags().BoolVar(&first, "first", false, "stop at the first error in each module")
+
+	return command
+}
@@ -0,0 +1,163 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	wasmvalidate "github.com/pgavlin/warp/wasm/validate"
+	"github.com/pgavlin/warp/wast"
+)
+
+// contextLines is the maximum number of lines of disassembly that are printed for an invalid instruction in a
+// binary module.
+const contextLines = 4
+
+// A Source is a module loaded from a file along with the information necessary to describe its validation errors.
+type Source struct {
+	Path   string       // The path of the file that holds the module.
+	Module *wasm.Module // The decoded module.
+
+	lines     []string        // The lines of the module's text format source, if any.
+	sourceMap *wast.SourceMap // The module's source map, if it was loaded from the text format.
+}
+
+// Load loads a module in either the binary or the text format from the file at the given path.
+func Load(path string) (*Source, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == wasm.Magic {
+		mod, err := wasm.DecodeModuleBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Source{Path: path, Module: mod}, nil
+	}
+
+	syntax, err := wast.ParseModule(wast.NewScanner(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	mod, sourceMap, err := syntax.DecodeSource()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return &Source{
+		Path:      path,
+		Module:    mod,
+		lines:     strings.Split(string(data), "\n"),
+		sourceMap: sourceMap,
+	}, nil
+}
+
+// Validate validates the module, including its function bodies. If all is true, Validate reports every error in the
+// module. Otherwise, Validate stops at the first error. Function bodies are validated in parallel.
+func (s *Source) Validate(all bool) error {
+	return wasmvalidate.Validate(s.Module, wasmvalidate.Options{
+		ValidateCode: true,
+		AllErrors:    all,
+		Source:       s.sourceMap,
+		Parallelism:  runtime.GOMAXPROCS(0),
+	})
+}
+
+// Print writes each error in err to w. Each validation error is followed by the text of the invalid instruction with a
+// caret beneath it. If the module was loaded from the text format, the text is taken from the module's source.
+// Otherwise, the text is a disassembly of the invalid instruction and those that precede it.
+func (s *Source) Print(w io.Writer, err error) {
+	var list wasmvalidate.ErrorList
+	var verr *wasmvalidate.Error
+	switch {
+	case errors.As(err, &list):
+		for _, e := range list {
+			s.printError(w, e)
+		}
+	case errors.As(err, &verr):
+		s.printError(w, verr)
+	default:
+		fmt.Fprintf(w, "%v: %v\n", s.Path, err)
+	}
+}
+
+func (s *Source) printError(w io.Writer, e *wasmvalidate.Error) {
+	fmt.Fprintf(w, "%v: %v\n", s.Path, e)
+
+	switch {
+	case s.lines != nil && e.Pos.Line > 0 && e.Pos.Line <= len(s.lines):
+		printCaret(w, []string{strings.TrimRight(s.lines[e.Pos.Line-1], "\r")}, e.Pos.Column-1)
+	case e.Section == wasm.SectionIDCode && e.Instruction >= 0:
+		// The index of a code section error is the index of the invalid function's body.
+		body := s.Module.Code.Bodies[e.Index]
+		if lines, column, ok := disassemble(body.Code, e.Instruction); ok {
+			printCaret(w, lines, column)
+		}
+	}
+}
+
+// printCaret prints the given lines followed by a caret beneath the given zero-based column of the last line.
+func printCaret(w io.Writer, lines []string, column int) {
+	last := lines[len(lines)-1]
+	if column < 0 || column > len(last) {
+		column = 0
+	}
+
+	// Preserve any tabs that precede the caret so that it lines up with the text above it.
+	indent := []byte(last[:column])
+	for i, c := range indent {
+		if c != '\t' {
+			indent[i] = ' '
+		}
+	}
+
+	for _, l := range lines {
+		fmt.Fprintf(w, "\t%v\n", l)
+	}
+	fmt.Fprintf(w, "\t%s^\n", indent)
+}
+
+// disassemble returns the text of the instruction at the given index within a function body along with the text of
+// the instructions that immediately precede it. Each instruction is indented by its nesting depth. disassemble also
+// returns the column at which the text of the instruction at ip begins. The body is not validated, but its
+// instructions must be well-formed up to and including the instruction at ip.
+func disassemble(body []byte, ip int) ([]string, int, bool) {
+	r := bytes.NewReader(body)
+
+	var lines []string
+	depth, column := 1, 0
+	for i := 0; i <= ip; i++ {
+		var instr code.Instruction
+		if err := instr.Decode(r); err != nil {
+			return nil, 0, false
+		}
+
+		switch instr.Opcode {
+		case code.OpElse, code.OpEnd:
+			if depth > 1 {
+				depth--
+			}
+		}
+
+		column = 2 * depth
+		lines = append(lines, strings.Repeat(" ", column)+instr.String())
+
+		switch instr.Opcode {
+		case code.OpBlock, code.OpLoop, code.OpIf, code.OpElse:
+			depth++
+		}
+	}
+
+	if len(lines) > contextLines {
+		lines = lines[len(lines)-contextLines:]
+	}
+	return lines, column, true
+}
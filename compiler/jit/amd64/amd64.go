@@ -0,0 +1,17 @@
+// Package amd64 compiles WebAssembly modules to x86-64 machine code.
+//
+// Each module instance is compiled when it is instantiated. Compiled code accesses linear memory directly and relies
+// on the guard pages that surround a memory's reservation for bounds checks. Calls to host functions, indirect calls
+// to functions that were not compiled by this package, memory growth, and traps exit to Go.
+//
+// Compiled code does not support tracing, debugging, or coverage. The Go runtime cannot preempt a goroutine while it
+// is executing compiled code, so a long-running loop that never exits to Go delays garbage collection until it exits.
+//
+// The compiler requires an x86-64 processor that supports SSE4.1 and POPCNT. It is only available on platforms that
+// use guard pages for linear memory.
+package amd64
+
+import "errors"
+
+// ErrUnsupported is returned by Allocate if compiled code cannot run on the current platform.
+var ErrUnsupported = errors.New("the amd64 compiler is not supported on this platform")
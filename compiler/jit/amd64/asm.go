@@ -0,0 +1,643 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import "encoding/binary"
+
+// A reg is a general-purpose register.
+type reg uint8
+
+const (
+	rax reg = iota
+	rcx
+	rdx
+	rbx
+	rsp
+	rbp
+	rsi
+	rdi
+	r8
+	r9
+	r10
+	r11
+	r12
+	r13
+	r14
+	r15
+)
+
+// An xreg is an SSE register.
+type xreg uint8
+
+const (
+	xmm0 xreg = iota
+	xmm1
+	xmm2
+	xmm3
+)
+
+// A cond is a condition code as encoded in the low nibble of the jcc, setcc, and cmovcc opcodes.
+type cond uint8
+
+const (
+	condO  cond = 0x0
+	condNO cond = 0x1
+	condB  cond = 0x2
+	condAE cond = 0x3
+	condE  cond = 0x4
+	condNE cond = 0x5
+	condBE cond = 0x6
+	condA  cond = 0x7
+	condS  cond = 0x8
+	condNS cond = 0x9
+	condP  cond = 0xa
+	condNP cond = 0xb
+	condL  cond = 0xc
+	condGE cond = 0xd
+	condLE cond = 0xe
+	condG  cond = 0xf
+)
+
+// invert returns the inverse of the condition.
+func (c cond) invert() cond {
+	return c ^ 1
+}
+
+// An operand is a register or memory operand.
+type operand struct {
+	direct bool // True if the operand is a register.
+	reg    uint8
+
+	base     reg
+	index    reg
+	hasIndex bool
+	scale    uint8
+	disp     int32
+
+	rip   bool  // True if the operand is RIP-relative. Only valid for lea.
+	label label // The target of a RIP-relative operand.
+}
+
+// r returns a register operand.
+func r(rg reg) operand {
+	return operand{direct: true, reg: uint8(rg)}
+}
+
+// x returns an SSE register operand.
+func x(rg xreg) operand {
+	return operand{direct: true, reg: uint8(rg)}
+}
+
+// m returns a memory operand that addresses base+disp.
+func m(base reg, disp int32) operand {
+	return operand{base: base, disp: disp}
+}
+
+// mi returns a memory operand that addresses base+index*scale+disp.
+func mi(base, index reg, scale uint8, disp int32) operand {
+	return operand{base: base, index: index, hasIndex: true, scale: scale, disp: disp}
+}
+
+// A label identifies a position in the code.
+type label int
+
+// A fixup records a 32-bit displacement that refers to a label. The displacement is relative to base.
+type fixup struct {
+	at    int   // The offset of the displacement.
+	base  int   // The offset the displacement is relative to.
+	label label // The target of the displacement.
+}
+
+// An assembler encodes x86-64 instructions.
+type assembler struct {
+	code   []byte
+	labels []int
+	fixups []fixup
+}
+
+// newLabel creates a new, unbound label.
+func (a *assembler) newLabel() label {
+	a.labels = append(a.labels, -1)
+	return label(len(a.labels) - 1)
+}
+
+// bind binds the label to the current position.
+func (a *assembler) bind(l label) {
+	a.labels[l] = len(a.code)
+}
+
+// offset returns the position of a bound label.
+func (a *assembler) offset(l label) int {
+	return a.labels[l]
+}
+
+// pc returns the current position.
+func (a *assembler) pc() int {
+	return len(a.code)
+}
+
+// link resolves the displacements that refer to labels.
+func (a *assembler) link() {
+	for _, f := range a.fixups {
+		target := a.labels[f.label]
+		if target < 0 {
+			panic("unbound label")
+		}
+		binary.LittleEndian.PutUint32(a.code[f.at:], uint32(int32(target-f.base)))
+	}
+	a.fixups = a.fixups[:0]
+}
+
+func (a *assembler) byte(b ...byte) {
+	a.code = append(a.code, b...)
+}
+
+func (a *assembler) u32(v uint32) {
+	a.code = append(a.code, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (a *assembler) u64(v uint64) {
+	a.u32(uint32(v))
+	a.u32(uint32(v >> 32))
+}
+
+// rel32 emits a displacement to the given label that is relative to the end of the displacement.
+func (a *assembler) rel32(l label) {
+	a.fixups = append(a.fixups, fixup{at: len(a.code), base: len(a.code) + 4, label: l})
+	a.u32(0)
+}
+
+// Encoding flags for emit.
+const (
+	encW    = 1 << iota // Set REX.W.
+	encByte             // The register operand is a byte register: force a REX prefix for SPL, BPL, SIL, and DIL.
+)
+
+// emit emits an instruction with the given mandatory prefix (0 for none), flags, opcode, ModRM reg field, and r/m
+// operand. imm holds the bytes of any immediate that follows the r/m operand.
+func (a *assembler) emit(prefix byte, flags int, opcode []byte, regField uint8, rm operand, imm ...byte) {
+	// The instruction is encoded into a local buffer and then appended to the code all at once.
+	var b [24]byte
+	n := 0
+
+	if prefix != 0 {
+		b[n], n = prefix, n+1
+	}
+
+	rex := byte(0)
+	if flags&encW != 0 {
+		rex |= 0x48
+	}
+	if regField&8 != 0 {
+		rex |= 0x44
+	}
+	if rm.direct {
+		if rm.reg&8 != 0 {
+			rex |= 0x41
+		}
+		if flags&encByte != 0 && ((regField >= 4 && regField < 8) || (rm.reg >= 4 && rm.reg < 8)) {
+			rex |= 0x40
+		}
+	} else {
+		if !rm.rip && rm.base&8 != 0 {
+			rex |= 0x41
+		}
+		if rm.hasIndex && rm.index&8 != 0 {
+			rex |= 0x42
+		}
+		if flags&encByte != 0 && regField >= 4 && regField < 8 {
+			rex |= 0x40
+		}
+	}
+	if rex != 0 {
+		b[n], n = rex, n+1
+	}
+
+	n += copy(b[n:], opcode)
+
+	regBits := (regField & 7) << 3
+	switch {
+	case rm.direct:
+		b[n], n = 0xc0|regBits|rm.reg&7, n+1
+	case rm.rip:
+		b[n], n = regBits|5, n+1
+		at := len(a.code) + n
+		a.fixups = append(a.fixups, fixup{at: at, base: at + 4 + len(imm), label: rm.label})
+		n += 4
+	default:
+		base := byte(rm.base & 7)
+
+		var mod byte
+		switch {
+		case rm.disp == 0 && base != 5:
+			mod = 0x00
+		case rm.disp >= -128 && rm.disp < 128:
+			mod = 0x40
+		default:
+			mod = 0x80
+		}
+
+		if rm.hasIndex || base == 4 {
+			b[n], n = mod|regBits|4, n+1
+
+			index, scale := byte(4), byte(0)
+			if rm.hasIndex {
+				index = byte(rm.index & 7)
+				switch rm.scale {
+				case 1:
+					scale = 0
+				case 2:
+					scale = 1
+				case 4:
+					scale = 2
+				case 8:
+					scale = 3
+				default:
+					panic("invalid scale")
+				}
+			}
+			b[n], n = scale<<6|index<<3|base, n+1
+		} else {
+			b[n], n = mod|regBits|base, n+1
+		}
+
+		switch mod {
+		case 0x40:
+			b[n], n = byte(int8(rm.disp)), n+1
+		case 0x80:
+			binary.LittleEndian.PutUint32(b[n:], uint32(rm.disp))
+			n += 4
+		}
+	}
+
+	n += copy(b[n:], imm)
+	a.code = append(a.code, b[:n]...)
+}
+
+func width(w bool) int {
+	if w {
+		return encW
+	}
+	return 0
+}
+
+func fitsInt8(v int64) bool {
+	return v >= -128 && v < 128
+}
+
+func fitsInt32(v int64) bool {
+	return v >= -1<<31 && v < 1<<31
+}
+
+func imm32(v int32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
+
+// mov moves a 32- or 64-bit value from src to dst. At most one operand may be a memory operand.
+func (a *assembler) mov(w bool, dst, src operand) {
+	if src.direct {
+		a.emit(0, width(w), []byte{0x89}, src.reg, dst)
+	} else {
+		a.emit(0, width(w), []byte{0x8b}, dst.reg, src)
+	}
+}
+
+// movq moves a 64-bit value from src to dst.
+func (a *assembler) movq(dst, src operand) {
+	a.mov(true, dst, src)
+}
+
+// movl moves a 32-bit value from src to dst. Writes to registers zero the upper half of the register.
+func (a *assembler) movl(dst, src operand) {
+	a.mov(false, dst, src)
+}
+
+// movImm loads a constant into a register using the shortest encoding.
+func (a *assembler) movImm(dst reg, v uint64) {
+	switch {
+	case v == 0:
+		a.alu(false, aluXor, r(dst), r(dst))
+	case v <= 0xffffffff:
+		if dst&8 != 0 {
+			a.byte(0x41)
+		}
+		a.byte(0xb8 | byte(dst&7))
+		a.u32(uint32(v))
+	case fitsInt32(int64(v)):
+		a.emit(0, encW, []byte{0xc7}, 0, r(dst), imm32(int32(v))...)
+	default:
+		a.byte(0x48 | byte(dst>>3))
+		a.byte(0xb8 | byte(dst&7))
+		a.u64(v)
+	}
+}
+
+// movMemImm stores a sign-extended 32-bit constant to memory.
+func (a *assembler) movMemImm(w bool, dst operand, v int32) {
+	a.emit(0, width(w), []byte{0xc7}, 0, dst, imm32(v)...)
+}
+
+// store8 stores the low byte of a register.
+func (a *assembler) store8(dst operand, src reg) {
+	a.emit(0, encByte, []byte{0x88}, uint8(src), dst)
+}
+
+// store16 stores the low 16 bits of a register.
+func (a *assembler) store16(dst operand, src reg) {
+	a.emit(0x66, 0, []byte{0x89}, uint8(src), dst)
+}
+
+// movzx8 zero-extends a byte into a register.
+func (a *assembler) movzx8(dst reg, src operand) {
+	a.emit(0, encByte, []byte{0x0f, 0xb6}, uint8(dst), src)
+}
+
+// movzx16 zero-extends a 16-bit value into a register.
+func (a *assembler) movzx16(dst reg, src operand) {
+	a.emit(0, 0, []byte{0x0f, 0xb7}, uint8(dst), src)
+}
+
+// movsx8 sign-extends a byte into a 32- or 64-bit register.
+func (a *assembler) movsx8(w bool, dst reg, src operand) {
+	a.emit(0, width(w)|encByte, []byte{0x0f, 0xbe}, uint8(dst), src)
+}
+
+// movsx16 sign-extends a 16-bit value into a 32- or 64-bit register.
+func (a *assembler) movsx16(w bool, dst reg, src operand) {
+	a.emit(0, width(w), []byte{0x0f, 0xbf}, uint8(dst), src)
+}
+
+// movsxd sign-extends a 32-bit value into a 64-bit register.
+func (a *assembler) movsxd(dst reg, src operand) {
+	a.emit(0, encW, []byte{0x63}, uint8(dst), src)
+}
+
+// lea loads the address of a memory operand.
+func (a *assembler) lea(dst reg, src operand) {
+	a.emit(0, encW, []byte{0x8d}, uint8(dst), src)
+}
+
+// leaLabel loads the address of a label.
+func (a *assembler) leaLabel(dst reg, l label) {
+	a.lea(dst, operand{rip: true, label: l})
+}
+
+// An aluOp is an arithmetic or logical operation encoded in the ModRM reg field of opcodes 0x81 and 0x83.
+type aluOp uint8
+
+const (
+	aluAdd aluOp = 0
+	aluOr  aluOp = 1
+	aluAdc aluOp = 2
+	aluSbb aluOp = 3
+	aluAnd aluOp = 4
+	aluSub aluOp = 5
+	aluXor aluOp = 6
+	aluCmp aluOp = 7
+)
+
+// alu performs dst = dst op src. At most one operand may be a memory operand.
+func (a *assembler) alu(w bool, op aluOp, dst, src operand) {
+	if src.direct {
+		a.emit(0, width(w), []byte{byte(op)<<3 | 0x01}, src.reg, dst)
+	} else {
+		a.emit(0, width(w), []byte{byte(op)<<3 | 0x03}, dst.reg, src)
+	}
+}
+
+// aluImm performs dst = dst op imm.
+func (a *assembler) aluImm(w bool, op aluOp, dst operand, v int32) {
+	if fitsInt8(int64(v)) {
+		a.emit(0, width(w), []byte{0x83}, uint8(op), dst, byte(int8(v)))
+	} else {
+		a.emit(0, width(w), []byte{0x81}, uint8(op), dst, imm32(v)...)
+	}
+}
+
+// test sets the flags according to dst & src.
+func (a *assembler) test(w bool, dst operand, src reg) {
+	a.emit(0, width(w), []byte{0x85}, uint8(src), dst)
+}
+
+// imul performs dst = dst * src.
+func (a *assembler) imul(w bool, dst reg, src operand) {
+	a.emit(0, width(w), []byte{0x0f, 0xaf}, uint8(dst), src)
+}
+
+// A unaryOp is a unary operation encoded in the ModRM reg field of opcode 0xf7.
+type unaryOp uint8
+
+const (
+	unaryNot  unaryOp = 2
+	unaryNeg  unaryOp = 3
+	unaryDiv  unaryOp = 6
+	unaryIdiv unaryOp = 7
+)
+
+// unary performs a unary operation. Division divides rdx:rax (or edx:eax) by the operand.
+func (a *assembler) unary(w bool, op unaryOp, dst operand) {
+	a.emit(0, width(w), []byte{0xf7}, uint8(op), dst)
+}
+
+// signExtendAX sign-extends eax into edx (cdq) or rax into rdx (cqo).
+func (a *assembler) signExtendAX(w bool) {
+	if w {
+		a.byte(0x48)
+	}
+	a.byte(0x99)
+}
+
+// A shiftOp is a shift or rotate operation encoded in the ModRM reg field of opcodes 0xd3 and 0xc1.
+type shiftOp uint8
+
+const (
+	shiftRol shiftOp = 0
+	shiftRor shiftOp = 1
+	shiftShl shiftOp = 4
+	shiftShr shiftOp = 5
+	shiftSar shiftOp = 7
+)
+
+// shiftCL shifts dst by cl.
+func (a *assembler) shiftCL(w bool, op shiftOp, dst operand) {
+	a.emit(0, width(w), []byte{0xd3}, uint8(op), dst)
+}
+
+// shiftImm shifts dst by a constant.
+func (a *assembler) shiftImm(w bool, op shiftOp, dst operand, n uint8) {
+	a.emit(0, width(w), []byte{0xc1}, uint8(op), dst, n)
+}
+
+// bsr finds the index of the most significant set bit. ZF is set if the source is zero.
+func (a *assembler) bsr(w bool, dst reg, src operand) {
+	a.emit(0, width(w), []byte{0x0f, 0xbd}, uint8(dst), src)
+}
+
+// bsf finds the index of the least significant set bit. ZF is set if the source is zero.
+func (a *assembler) bsf(w bool, dst reg, src operand) {
+	a.emit(0, width(w), []byte{0x0f, 0xbc}, uint8(dst), src)
+}
+
+// popcnt counts the set bits in the source.
+func (a *assembler) popcnt(w bool, dst reg, src operand) {
+	a.emit(0xf3, width(w), []byte{0x0f, 0xb8}, uint8(dst), src)
+}
+
+// A bitOp is a bit test operation encoded in the ModRM reg field of opcode 0x0f 0xba.
+type bitOp uint8
+
+const (
+	bitReset      bitOp = 6
+	bitComplement bitOp = 7
+)
+
+// bit performs a bit test operation on the given bit of dst.
+func (a *assembler) bit(w bool, op bitOp, dst operand, n uint8) {
+	a.emit(0, width(w), []byte{0x0f, 0xba}, uint8(op), dst, n)
+}
+
+// setcc sets the low byte of dst to 1 if the condition holds and 0 otherwise.
+func (a *assembler) setcc(c cond, dst reg) {
+	a.emit(0, encByte, []byte{0x0f, 0x90 | byte(c)}, 0, r(dst))
+}
+
+// cmov moves src to dst if the condition holds.
+func (a *assembler) cmov(w bool, c cond, dst reg, src operand) {
+	a.emit(0, width(w), []byte{0x0f, 0x40 | byte(c)}, uint8(dst), src)
+}
+
+// jmp jumps to a label.
+func (a *assembler) jmp(l label) {
+	a.byte(0xe9)
+	a.rel32(l)
+}
+
+// jcc jumps to a label if the condition holds.
+func (a *assembler) jcc(c cond, l label) {
+	a.byte(0x0f, 0x80|byte(c))
+	a.rel32(l)
+}
+
+// jmpIndirect jumps to the address held in the operand.
+func (a *assembler) jmpIndirect(target operand) {
+	a.emit(0, 0, []byte{0xff}, 4, target)
+}
+
+// ret returns to the address at the top of the machine stack.
+func (a *assembler) ret() {
+	a.byte(0xc3)
+}
+
+// repStosq stores rax to rcx quadwords at rdi.
+func (a *assembler) repStosq() {
+	a.byte(0xf3, 0x48, 0xab)
+}
+
+// sse emits an SSE instruction with the given mandatory prefix and two-byte opcode 0x0f op.
+func (a *assembler) sse(prefix byte, w bool, op byte, dst uint8, src operand, imm ...byte) {
+	a.emit(prefix, width(w), []byte{0x0f, op}, dst, src, imm...)
+}
+
+// Float sizes for SSE instructions.
+const (
+	f32 = false
+	f64 = true
+)
+
+// scalarPrefix returns the mandatory prefix for a scalar SSE instruction of the given size.
+func scalarPrefix(double bool) byte {
+	if double {
+		return 0xf2
+	}
+	return 0xf3
+}
+
+// packedPrefix returns the mandatory prefix for a packed SSE instruction of the given size.
+func packedPrefix(double bool) byte {
+	if double {
+		return 0x66
+	}
+	return 0
+}
+
+// movsLoad loads a scalar float from memory or another register.
+func (a *assembler) movsLoad(double bool, dst xreg, src operand) {
+	a.sse(scalarPrefix(double), false, 0x10, uint8(dst), src)
+}
+
+// movsStore stores a scalar float to memory.
+func (a *assembler) movsStore(double bool, dst operand, src xreg) {
+	a.sse(scalarPrefix(double), false, 0x11, uint8(src), dst)
+}
+
+// movToX moves a 32- or 64-bit value from a general-purpose register or memory to an SSE register.
+func (a *assembler) movToX(w bool, dst xreg, src operand) {
+	a.sse(0x66, w, 0x6e, uint8(dst), src)
+}
+
+// movFromX moves a 32- or 64-bit value from an SSE register to a general-purpose register or memory.
+func (a *assembler) movFromX(w bool, dst operand, src xreg) {
+	a.sse(0x66, w, 0x7e, uint8(src), dst)
+}
+
+// SSE arithmetic opcodes.
+const (
+	sseSqrt = 0x51
+	sseAnd  = 0x54
+	sseOr   = 0x56
+	sseXor  = 0x57
+	sseAdd  = 0x58
+	sseMul  = 0x59
+	sseSub  = 0x5c
+	sseMin  = 0x5d
+	sseDiv  = 0x5e
+	sseMax  = 0x5f
+)
+
+// arith performs dst = dst op src for a scalar arithmetic opcode.
+func (a *assembler) arith(double bool, op byte, dst xreg, src operand) {
+	a.sse(scalarPrefix(double), false, op, uint8(dst), src)
+}
+
+// logical performs dst = dst op src for a packed logical opcode.
+func (a *assembler) logical(double bool, op byte, dst xreg, src operand) {
+	a.sse(packedPrefix(double), false, op, uint8(dst), src)
+}
+
+// ucomis compares two scalar floats and sets ZF, PF, and CF. Unordered operands set all three.
+func (a *assembler) ucomis(double bool, lhs xreg, rhs operand) {
+	a.sse(packedPrefix(double), false, 0x2e, uint8(lhs), rhs)
+}
+
+// cvtsi2s converts a signed 32- or 64-bit integer to a scalar float.
+func (a *assembler) cvtsi2s(double bool, w bool, dst xreg, src operand) {
+	a.sse(scalarPrefix(double), w, 0x2a, uint8(dst), src)
+}
+
+// cvtts2si converts a scalar float to a signed 32- or 64-bit integer with truncation.
+func (a *assembler) cvtts2si(double bool, w bool, dst reg, src operand) {
+	a.sse(scalarPrefix(double), w, 0x2c, uint8(dst), src)
+}
+
+// cvts2s converts between scalar float sizes. If double is true, the source is a float64.
+func (a *assembler) cvts2s(double bool, dst xreg, src operand) {
+	a.sse(scalarPrefix(double), false, 0x5a, uint8(dst), src)
+}
+
+// Rounding modes for rounds.
+const (
+	roundNearest = 0
+	roundFloor   = 1
+	roundCeil    = 2
+	roundTrunc   = 3
+)
+
+// rounds rounds a scalar float using the given rounding mode.
+func (a *assembler) rounds(double bool, dst xreg, src operand, mode byte) {
+	op := byte(0x0a)
+	if double {
+		op = 0x0b
+	}
+	a.emit(0x66, 0, []byte{0x0f, 0x3a, op}, uint8(dst), src, mode)
+}
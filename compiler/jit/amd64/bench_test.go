@@ -0,0 +1,64 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pgavlin/warp/bench/data"
+	"github.com/pgavlin/warp/bench/flate"
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/interpreter"
+	"github.com/pgavlin/warp/wasi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlate(t *testing.T) {
+	if !supported {
+		t.Skip("compiled code is not supported by this processor")
+	}
+
+	var stdout bytes.Buffer
+	err := wasi.Run("flate", NewModuleDefinition(flate.Module), &wasi.RunOptions{
+		Options: &wasi.Options{
+			Stdin:  bytes.NewReader(data.Enwik8[:1<<20]),
+			Stdout: &stdout,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, data.Enwik8[:1<<20], stdout.Bytes())
+}
+
+// BenchmarkFlate compares compiled code with the interpreter's fcode.
+func BenchmarkFlate(b *testing.B) {
+	engines := []struct {
+		name       string
+		definition func() exec.ModuleDefinition
+	}{
+		{"amd64", func() exec.ModuleDefinition {
+			return NewModuleDefinition(flate.Module)
+		}},
+		{"fcode", func() exec.ModuleDefinition {
+			return interpreter.NewModuleDefinition(flate.Module, &interpreter.Options{CodeKind: interpreter.FCodeOnly})
+		}},
+	}
+	for _, e := range engines {
+		b.Run(e.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := wasi.Run("flate", e.definition(), &wasi.RunOptions{
+					Options: &wasi.Options{
+						Stdin:  bytes.NewReader(data.Enwik8[:1<<16]),
+						Stdout: io.Discard,
+					},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cpuid executes the CPUID instruction. It is implemented in assembly.
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+// supported is true if the processor supports the instructions used by compiled code.
+var supported = func() bool {
+	const (
+		sse41  = 1 << 19
+		popcnt = 1 << 23
+	)
+	_, _, ecx, _ := cpuid(1, 0)
+	return ecx&sse41 != 0 && ecx&popcnt != 0
+}()
+
+// A codeBlock holds executable machine code. The code is unmapped when the block is collected.
+type codeBlock struct {
+	mem []byte
+	ret uintptr // The address of a return instruction that returns from the outermost call to Go.
+}
+
+// newCodeBlock copies the given machine code into executable memory.
+func newCodeBlock(code []byte) (*codeBlock, error) {
+	size := (len(code) + syscall.Getpagesize() - 1) &^ (syscall.Getpagesize() - 1)
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	copy(mem, code)
+	if err := syscall.Mprotect(mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+
+	block := &codeBlock{mem: mem}
+	runtime.SetFinalizer(block, func(b *codeBlock) { syscall.Munmap(b.mem) })
+	return block, nil
+}
+
+// address returns the address of the code at the given offset.
+func (b *codeBlock) address(offset int) uintptr {
+	return uintptr(unsafe.Pointer(&b.mem[0])) + uintptr(offset)
+}
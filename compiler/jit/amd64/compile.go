@@ -0,0 +1,1300 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// Compiled code runs on a separate value stack that is addressed by R13. Each function's frame holds its locals
+// (parameters first), the address to which the function returns, and its operand stack, in that order. A caller
+// passes arguments to its callee by pointing R13 at the arguments on top of its own operand stack; the callee returns
+// its results in the first slots of its frame, where they overwrite the arguments.
+//
+// R14 holds a pointer to the context for the current call from Go and R15 holds the start of the current module's
+// linear memory. The machine stack pointer is never modified: it always points at the return address into enter, so
+// faults in compiled code appear to the Go runtime as faults in enter.
+const (
+	regFrame   = r13
+	regContext = r14
+	regMemory  = r15
+)
+
+// Offsets of the context fields that are accessed by compiled code.
+const (
+	ctxExit   = int32(unsafe.Offsetof(context{}.exit))
+	ctxArg    = int32(unsafe.Offsetof(context{}.arg))
+	ctxArgs   = int32(unsafe.Offsetof(context{}.args))
+	ctxResume = int32(unsafe.Offsetof(context{}.resume))
+	ctxFrame  = int32(unsafe.Offsetof(context{}.frame))
+	ctxModule = int32(unsafe.Offsetof(context{}.module))
+	ctxLimit  = int32(unsafe.Offsetof(context{}.limit))
+	ctxDepth  = int32(unsafe.Offsetof(context{}.depth))
+)
+
+// Offsets of the function fields that are accessed by compiled code.
+const (
+	fnCode   = int32(unsafe.Offsetof(function{}.code))
+	fnTypeID = int32(unsafe.Offsetof(function{}.typeID))
+)
+
+// Trap codes. Compiled code passes a trap code to Go when it exits with exitTrap.
+const (
+	trapUnreachable = iota
+	trapIntegerDivideByZero
+	trapIntegerOverflow
+	trapInvalidConversionToInteger
+	trapOutOfBoundsMemoryAccess
+	trapUndefinedElement
+	trapCallStackExhausted
+
+	numTraps
+)
+
+var traps = [numTraps]exec.Trap{
+	trapUnreachable:                exec.TrapUnreachable,
+	trapIntegerDivideByZero:        exec.TrapIntegerDivideByZero,
+	trapIntegerOverflow:            exec.TrapIntegerOverflow,
+	trapInvalidConversionToInteger: exec.TrapInvalidConversionToInteger,
+	trapOutOfBoundsMemoryAccess:    exec.TrapOutOfBoundsMemoryAccess,
+	trapUndefinedElement:           exec.TrapUndefinedElement,
+	trapCallStackExhausted:         exec.TrapCallStackExhausted,
+}
+
+// A block records the state of a block, loop, or if that is being compiled.
+type block struct {
+	opcode    byte
+	label     label // The target of branches to the block.
+	elseLabel label // The else arm of an if.
+	hasElse   bool  // True if the block is an if that has an else arm.
+	base      int   // The height of the operand stack below the block's parameters.
+	params    int   // The number of parameters.
+	results   int   // The number of results.
+	live      bool  // True if the start of the block is reachable.
+}
+
+// arity returns the number of values passed by a branch to the block.
+func (b *block) arity() int {
+	if b.opcode == code.OpLoop {
+		return b.params
+	}
+	return b.results
+}
+
+// A compiler compiles the functions of a module instance into a single block of machine code. Compiled code refers
+// to the instance's memory, table, and globals using their absolute addresses, so a module's functions can only be
+// compiled once its imports have been resolved.
+type compiler struct {
+	assembler
+
+	module *module
+	scope  *code.StaticScope
+
+	entries []label         // The entry points of the module's functions.
+	retStub label           // A return instruction that returns from the outermost call to Go.
+	exit    label           // The exit stub.
+	traps   [numTraps]label // The trap stubs.
+	used    [numTraps]bool  // True for each trap stub that is referenced.
+	typeIDs []uint32        // The canonical IDs of the module's types.
+
+	// The state of the function being compiled.
+	body        []code.Instruction
+	ip          int
+	numLocals   int
+	blocks      []block
+	height      int  // The height of the operand stack.
+	unreachable bool // True if the current instruction is unreachable.
+	fused       bool // True if the operand on top of the stack is held in the flags.
+	fusedCond   cond // The condition for the fused operand.
+}
+
+// compile compiles the module's functions.
+func compile(m *module, wasmModule *wasm.Module) (*codeBlock, error) {
+	c := compiler{module: m, scope: code.NewStaticScope(wasmModule)}
+
+	// Machine code is typically about five times the size of its bytecode.
+	size := 0
+	if wasmModule.Code != nil {
+		for _, body := range wasmModule.Code.Bodies {
+			size += len(body.Code)
+		}
+	}
+	c.code = make([]byte, 0, 6*size+256)
+	c.entries = make([]label, len(m.functions))
+	for i := range c.entries {
+		c.entries[i] = c.newLabel()
+	}
+	c.retStub, c.exit = c.newLabel(), c.newLabel()
+	for i := range c.traps {
+		c.traps[i] = c.newLabel()
+	}
+	c.typeIDs = make([]uint32, len(m.types))
+	for i, sig := range m.types {
+		c.typeIDs[i] = signatureID(sig)
+	}
+
+	c.bind(c.retStub)
+	c.ret()
+	c.emitExit()
+
+	for i := range m.functions {
+		c.bind(c.entries[i])
+		if err := c.compileFunction(&m.functions[i], wasmModule.Code.Bodies[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	c.emitTraps()
+	c.link()
+
+	block, err := newCodeBlock(c.code)
+	if err != nil {
+		return nil, err
+	}
+	block.ret = block.address(c.offset(c.retStub))
+	for i := range m.functions {
+		m.functions[i].code = block.address(c.offset(c.entries[i]))
+	}
+	return block, nil
+}
+
+// emitExit emits the exit stub. Compiled code exits to Go by jumping to the exit stub with the exit code in rax, its
+// arguments in rcx and rdx, and the address at which to resume in rdi.
+func (c *compiler) emitExit() {
+	c.bind(c.exit)
+	c.movq(m(regContext, ctxExit), r(rax))
+	c.movq(m(regContext, ctxArg), r(rcx))
+	c.movq(m(regContext, ctxArgs), r(rdx))
+	c.movq(m(regContext, ctxResume), r(rdi))
+	c.movq(m(regContext, ctxFrame), r(regFrame))
+	c.movImm(rax, uint64(uintptr(unsafe.Pointer(c.module))))
+	c.movq(m(regContext, ctxModule), r(rax))
+	c.ret()
+}
+
+// emitTraps emits the trap stubs that are referenced by the compiled code.
+func (c *compiler) emitTraps() {
+	for i, l := range c.traps {
+		if c.used[i] {
+			c.bind(l)
+			c.movImm(rcx, uint64(i))
+			c.movImm(rax, exitTrap)
+			c.jmp(c.exit)
+		}
+	}
+}
+
+// trap returns the label of the stub for the given trap.
+func (c *compiler) trap(kind int) label {
+	c.used[kind] = true
+	return c.traps[kind]
+}
+
+// exitTo exits to Go with the given exit code. The exit's arguments must be in rcx and rdx. Execution resumes
+// after the exit.
+func (c *compiler) exitTo(exit int) {
+	resume := c.newLabel()
+	c.movImm(rax, uint64(exit))
+	c.leaLabel(rdi, resume)
+	c.jmp(c.exit)
+	c.bind(resume)
+}
+
+// local returns the slot for a local.
+func (c *compiler) local(localidx uint32) operand {
+	return m(regFrame, int32(8*localidx))
+}
+
+// stack returns the slot for the operand at the given height.
+func (c *compiler) stack(height int) operand {
+	return m(regFrame, int32(8*(c.numLocals+1+height)))
+}
+
+// top returns the slot for the operand at the given depth from the top of the stack.
+func (c *compiler) top(depth int) operand {
+	return c.stack(c.height - 1 - depth)
+}
+
+// push pushes an operand and returns its slot.
+func (c *compiler) push() operand {
+	c.height++
+	return c.top(0)
+}
+
+// pop pops an operand and returns its slot.
+func (c *compiler) pop() operand {
+	c.height--
+	return c.stack(c.height)
+}
+
+// loadMemoryBase loads the start of the module's memory into R15.
+func (c *compiler) loadMemoryBase() {
+	if c.module.mem0 != nil {
+		c.movImm(regMemory, uint64(c.module.mem0.Start()))
+	}
+}
+
+func (c *compiler) compileFunction(fn *function, body wasm.FunctionBody) error {
+	c.scope.SetFunction(fn.signature, body)
+	decoded, err := code.Decode(body.Code, c.scope, fn.signature.ReturnTypes)
+	if err != nil {
+		return err
+	}
+
+	c.body, c.numLocals = decoded.Instructions, len(c.scope.Locals)
+	c.height, c.unreachable, c.fused = 0, false, false
+
+	// Check the call depth and the size of the value stack.
+	frameSize := c.numLocals + 1 + decoded.Metrics.MaxStackDepth
+	c.aluImm(true, aluSub, m(regContext, ctxDepth), 1)
+	c.jcc(condB, c.trap(trapCallStackExhausted))
+	c.lea(rcx, m(regFrame, int32(8*frameSize)))
+	c.alu(true, aluCmp, r(rcx), m(regContext, ctxLimit))
+	c.jcc(condA, c.trap(trapCallStackExhausted))
+
+	// Save the return address and zero the function's non-parameter locals.
+	c.movq(c.local(uint32(c.numLocals)), r(rax))
+	params := len(fn.signature.ParamTypes)
+	if n := c.numLocals - params; n > 8 {
+		c.lea(rdi, c.local(uint32(params)))
+		c.movImm(rcx, uint64(n))
+		c.movImm(rax, 0)
+		c.repStosq()
+	} else if n > 0 {
+		c.movImm(rax, 0)
+		for i := params; i < c.numLocals; i++ {
+			c.movq(c.local(uint32(i)), r(rax))
+		}
+	}
+	c.loadMemoryBase()
+
+	c.blocks = append(c.blocks[:0], block{
+		opcode:  code.OpBlock,
+		label:   c.newLabel(),
+		results: len(fn.signature.ReturnTypes),
+		live:    true,
+	})
+	for c.ip = 0; c.ip < len(c.body); c.ip++ {
+		c.instruction(&c.body[c.ip])
+	}
+	return nil
+}
+
+// epilogue returns the function's results to its caller. The results must be at the bottom of the operand stack.
+func (c *compiler) epilogue(results int) {
+	c.movq(r(rcx), c.local(uint32(c.numLocals)))
+	for i := 0; i < results; i++ {
+		c.movq(r(rax), c.stack(i))
+		c.movq(c.local(uint32(i)), r(rax))
+	}
+	c.aluImm(true, aluAdd, m(regContext, ctxDepth), 1)
+	c.jmpIndirect(r(rcx))
+}
+
+// moveOperands moves the n operands on top of the stack to the given height.
+func (c *compiler) moveOperands(height, n int) {
+	from := c.height - n
+	if from == height {
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.movq(r(rax), c.stack(from+i))
+		c.movq(c.stack(height+i), r(rax))
+	}
+}
+
+// needsMoves returns true if a branch to the given block needs to move operands.
+func (c *compiler) needsMoves(b *block) bool {
+	n := b.arity()
+	return n != 0 && c.height-n != b.base
+}
+
+// branch emits an unconditional branch to the block at the given depth.
+func (c *compiler) branch(depth int) {
+	b := &c.blocks[len(c.blocks)-1-depth]
+	c.moveOperands(b.base, b.arity())
+	c.jmp(b.label)
+}
+
+// blockType returns the number of parameters and results for a block.
+func (c *compiler) blockType(instr *code.Instruction) (params, results int) {
+	in, out, _ := instr.BlockType(c.scope)
+	return len(in), len(out)
+}
+
+// next returns the opcode of the next instruction.
+func (c *compiler) next() byte {
+	if c.ip+1 < len(c.body) {
+		return c.body[c.ip+1].Opcode
+	}
+	return code.OpEnd
+}
+
+// condition pops a condition from the stack and returns the condition code that is true if the popped value is
+// non-zero.
+func (c *compiler) condition() cond {
+	if c.fused {
+		c.fused = false
+		c.height--
+		return c.fusedCond
+	}
+	c.aluImm(false, aluCmp, c.pop(), 0)
+	return condNE
+}
+
+// setCond pushes the result of a comparison. If the next instruction is a conditional branch, the result is left in
+// the flags instead.
+func (c *compiler) setCond(cc cond) {
+	if next := c.next(); next == code.OpBrIf || next == code.OpIf {
+		c.fused, c.fusedCond = true, cc
+		c.height++
+		return
+	}
+	c.setcc(cc, rax)
+	c.movzx8(rax, r(rax))
+	c.movq(c.push(), r(rax))
+}
+
+// skip processes an unreachable instruction. Only structured control instructions are interesting: they determine
+// where reachable code resumes.
+func (c *compiler) skip(instr *code.Instruction) {
+	switch instr.Opcode {
+	case code.OpBlock, code.OpLoop, code.OpIf:
+		c.blocks = append(c.blocks, block{opcode: instr.Opcode})
+	case code.OpElse:
+		if b := &c.blocks[len(c.blocks)-1]; b.live {
+			c.elseArm(b)
+		}
+	case code.OpEnd:
+		c.end()
+	}
+}
+
+// elseArm starts the else arm of an if.
+func (c *compiler) elseArm(b *block) {
+	if !c.unreachable {
+		c.jmp(b.label)
+	}
+	c.bind(b.elseLabel)
+	b.hasElse = true
+	c.height, c.unreachable = b.base+b.params, false
+}
+
+// end ends the innermost block.
+func (c *compiler) end() {
+	b := c.blocks[len(c.blocks)-1]
+	c.blocks = c.blocks[:len(c.blocks)-1]
+
+	if !b.live {
+		return
+	}
+
+	switch b.opcode {
+	case code.OpLoop:
+		// Branches to a loop target its start, so the end of a loop is only reachable by falling through.
+	case code.OpIf:
+		if !b.hasElse {
+			c.bind(b.elseLabel)
+		}
+		c.bind(b.label)
+		c.unreachable = false
+	default:
+		c.bind(b.label)
+		c.unreachable = false
+	}
+	c.height = b.base + b.results
+
+	if len(c.blocks) == 0 {
+		c.epilogue(b.results)
+	}
+}
+
+func (c *compiler) instruction(instr *code.Instruction) {
+	if c.unreachable {
+		c.skip(instr)
+		return
+	}
+
+	switch instr.Opcode {
+	case code.OpUnreachable:
+		c.jmp(c.trap(trapUnreachable))
+		c.unreachable = true
+
+	case code.OpNop:
+
+	case code.OpBlock, code.OpLoop:
+		params, results := c.blockType(instr)
+		b := block{opcode: instr.Opcode, label: c.newLabel(), base: c.height - params, params: params, results: results, live: true}
+		if instr.Opcode == code.OpLoop {
+			c.bind(b.label)
+		}
+		c.blocks = append(c.blocks, b)
+
+	case code.OpIf:
+		cc := c.condition()
+		params, results := c.blockType(instr)
+		b := block{opcode: code.OpIf, label: c.newLabel(), elseLabel: c.newLabel(), base: c.height - params, params: params, results: results, live: true}
+		c.jcc(cc.invert(), b.elseLabel)
+		c.blocks = append(c.blocks, b)
+
+	case code.OpElse:
+		c.elseArm(&c.blocks[len(c.blocks)-1])
+
+	case code.OpEnd:
+		c.end()
+
+	case code.OpBr:
+		c.branch(instr.Labelidx())
+		c.unreachable = true
+
+	case code.OpBrIf:
+		cc := c.condition()
+		b := &c.blocks[len(c.blocks)-1-instr.Labelidx()]
+		if !c.needsMoves(b) {
+			c.jcc(cc, b.label)
+		} else {
+			skip := c.newLabel()
+			c.jcc(cc.invert(), skip)
+			c.branch(instr.Labelidx())
+			c.bind(skip)
+		}
+
+	case code.OpBrTable:
+		c.brTable(instr)
+		c.unreachable = true
+
+	case code.OpReturn:
+		c.moveOperands(0, c.blocks[0].results)
+		c.jmp(c.blocks[0].label)
+		c.unreachable = true
+
+	case code.OpCall:
+		c.call(instr.Funcidx())
+
+	case code.OpCallIndirect:
+		c.callIndirect(instr.Typeidx())
+
+	case code.OpDrop:
+		c.pop()
+
+	case code.OpSelect:
+		cond, v2, v1 := c.pop(), c.pop(), c.top(0)
+		c.movq(r(rax), v1)
+		c.aluImm(false, aluCmp, cond, 0)
+		c.cmov(true, condE, rax, v2)
+		c.movq(v1, r(rax))
+
+	case code.OpLocalGet:
+		c.movq(r(rax), c.local(instr.Localidx()))
+		c.movq(c.push(), r(rax))
+	case code.OpLocalSet:
+		c.movq(r(rax), c.pop())
+		c.movq(c.local(instr.Localidx()), r(rax))
+	case code.OpLocalTee:
+		c.movq(r(rax), c.top(0))
+		c.movq(c.local(instr.Localidx()), r(rax))
+
+	case code.OpGlobalGet:
+		c.movImm(rcx, uint64(c.module.globalAddress(instr.Globalidx())))
+		c.movq(r(rax), m(rcx, 0))
+		c.movq(c.push(), r(rax))
+	case code.OpGlobalSet:
+		c.movImm(rcx, uint64(c.module.globalAddress(instr.Globalidx())))
+		c.movq(r(rax), c.pop())
+		c.movq(m(rcx, 0), r(rax))
+
+	case code.OpI32Load, code.OpF32Load:
+		c.load(instr, 4, func(dst reg, src operand) { c.movl(r(dst), src) })
+	case code.OpI64Load, code.OpF64Load:
+		c.load(instr, 8, func(dst reg, src operand) { c.movq(r(dst), src) })
+	case code.OpI32Load8S:
+		c.load(instr, 1, func(dst reg, src operand) { c.movsx8(false, dst, src) })
+	case code.OpI32Load8U, code.OpI64Load8U:
+		c.load(instr, 1, c.movzx8)
+	case code.OpI32Load16S:
+		c.load(instr, 2, func(dst reg, src operand) { c.movsx16(false, dst, src) })
+	case code.OpI32Load16U, code.OpI64Load16U:
+		c.load(instr, 2, c.movzx16)
+	case code.OpI64Load8S:
+		c.load(instr, 1, func(dst reg, src operand) { c.movsx8(true, dst, src) })
+	case code.OpI64Load16S:
+		c.load(instr, 2, func(dst reg, src operand) { c.movsx16(true, dst, src) })
+	case code.OpI64Load32S:
+		c.load(instr, 4, c.movsxd)
+	case code.OpI64Load32U:
+		c.load(instr, 4, func(dst reg, src operand) { c.movl(r(dst), src) })
+
+	case code.OpI32Store, code.OpF32Store, code.OpI64Store32:
+		c.store(instr, 4, func(dst operand, src reg) { c.movl(dst, r(src)) })
+	case code.OpI64Store, code.OpF64Store:
+		c.store(instr, 8, func(dst operand, src reg) { c.movq(dst, r(src)) })
+	case code.OpI32Store8, code.OpI64Store8:
+		c.store(instr, 1, c.store8)
+	case code.OpI32Store16, code.OpI64Store16:
+		c.store(instr, 2, c.store16)
+
+	case code.OpMemorySize:
+		c.movImm(rcx, uint64(c.module.memorySizeAddress()))
+		c.movq(r(rax), m(rcx, 0))
+		c.shiftImm(true, shiftShr, r(rax), 16)
+		c.movq(c.push(), r(rax))
+	case code.OpMemoryGrow:
+		c.movl(r(rcx), c.top(0))
+		c.lea(rdx, c.top(0))
+		c.exitTo(exitMemoryGrow)
+
+	case code.OpI32Const, code.OpF32Const:
+		c.constant(uint64(uint32(instr.Immediate)))
+	case code.OpI64Const, code.OpF64Const:
+		c.constant(instr.Immediate)
+
+	case code.OpI32Eqz:
+		c.aluImm(false, aluCmp, c.pop(), 0)
+		c.setCond(condE)
+	case code.OpI64Eqz:
+		c.aluImm(true, aluCmp, c.pop(), 0)
+		c.setCond(condE)
+
+	case code.OpI32Eq, code.OpI32Ne, code.OpI32LtS, code.OpI32LtU, code.OpI32GtS, code.OpI32GtU, code.OpI32LeS, code.OpI32LeU, code.OpI32GeS, code.OpI32GeU:
+		c.compare(false, intConditions[instr.Opcode-code.OpI32Eq])
+	case code.OpI64Eq, code.OpI64Ne, code.OpI64LtS, code.OpI64LtU, code.OpI64GtS, code.OpI64GtU, code.OpI64LeS, code.OpI64LeU, code.OpI64GeS, code.OpI64GeU:
+		c.compare(true, intConditions[instr.Opcode-code.OpI64Eq])
+
+	case code.OpF32Eq, code.OpF32Ne, code.OpF32Lt, code.OpF32Gt, code.OpF32Le, code.OpF32Ge:
+		c.compareFloat(f32, instr.Opcode-code.OpF32Eq)
+	case code.OpF64Eq, code.OpF64Ne, code.OpF64Lt, code.OpF64Gt, code.OpF64Le, code.OpF64Ge:
+		c.compareFloat(f64, instr.Opcode-code.OpF64Eq)
+
+	case code.OpI32Clz:
+		c.clz(false)
+	case code.OpI32Ctz:
+		c.ctz(false)
+	case code.OpI32Popcnt:
+		c.popcnt(false, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI32Add:
+		c.binary(false, aluAdd)
+	case code.OpI32Sub:
+		c.binary(false, aluSub)
+	case code.OpI32Mul:
+		c.mul(false)
+	case code.OpI32DivS:
+		c.divide(false, true, false)
+	case code.OpI32DivU:
+		c.divide(false, false, false)
+	case code.OpI32RemS:
+		c.divide(false, true, true)
+	case code.OpI32RemU:
+		c.divide(false, false, true)
+	case code.OpI32And:
+		c.binary(false, aluAnd)
+	case code.OpI32Or:
+		c.binary(false, aluOr)
+	case code.OpI32Xor:
+		c.binary(false, aluXor)
+	case code.OpI32Shl:
+		c.shift(false, shiftShl)
+	case code.OpI32ShrS:
+		c.shift(false, shiftSar)
+	case code.OpI32ShrU:
+		c.shift(false, shiftShr)
+	case code.OpI32Rotl:
+		c.shift(false, shiftRol)
+	case code.OpI32Rotr:
+		c.shift(false, shiftRor)
+
+	case code.OpI64Clz:
+		c.clz(true)
+	case code.OpI64Ctz:
+		c.ctz(true)
+	case code.OpI64Popcnt:
+		c.popcnt(true, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI64Add:
+		c.binary(true, aluAdd)
+	case code.OpI64Sub:
+		c.binary(true, aluSub)
+	case code.OpI64Mul:
+		c.mul(true)
+	case code.OpI64DivS:
+		c.divide(true, true, false)
+	case code.OpI64DivU:
+		c.divide(true, false, false)
+	case code.OpI64RemS:
+		c.divide(true, true, true)
+	case code.OpI64RemU:
+		c.divide(true, false, true)
+	case code.OpI64And:
+		c.binary(true, aluAnd)
+	case code.OpI64Or:
+		c.binary(true, aluOr)
+	case code.OpI64Xor:
+		c.binary(true, aluXor)
+	case code.OpI64Shl:
+		c.shift(true, shiftShl)
+	case code.OpI64ShrS:
+		c.shift(true, shiftSar)
+	case code.OpI64ShrU:
+		c.shift(true, shiftShr)
+	case code.OpI64Rotl:
+		c.shift(true, shiftRol)
+	case code.OpI64Rotr:
+		c.shift(true, shiftRor)
+
+	case code.OpF32Abs:
+		c.bit(false, bitReset, c.top(0), 31)
+	case code.OpF32Neg:
+		c.bit(false, bitComplement, c.top(0), 31)
+	case code.OpF32Ceil:
+		c.round(f32, roundCeil)
+	case code.OpF32Floor:
+		c.round(f32, roundFloor)
+	case code.OpF32Trunc:
+		c.round(f32, roundTrunc)
+	case code.OpF32Nearest:
+		c.round(f32, roundNearest)
+	case code.OpF32Sqrt:
+		c.arith(f32, sseSqrt, xmm0, c.top(0))
+		c.movsStore(f32, c.top(0), xmm0)
+	case code.OpF32Add:
+		c.binaryFloat(f32, sseAdd)
+	case code.OpF32Sub:
+		c.binaryFloat(f32, sseSub)
+	case code.OpF32Mul:
+		c.binaryFloat(f32, sseMul)
+	case code.OpF32Div:
+		c.binaryFloat(f32, sseDiv)
+	case code.OpF32Min:
+		c.minMax(f32, sseMin)
+	case code.OpF32Max:
+		c.minMax(f32, sseMax)
+	case code.OpF32Copysign:
+		c.copysign(f32)
+
+	case code.OpF64Abs:
+		c.bit(true, bitReset, c.top(0), 63)
+	case code.OpF64Neg:
+		c.bit(true, bitComplement, c.top(0), 63)
+	case code.OpF64Ceil:
+		c.round(f64, roundCeil)
+	case code.OpF64Floor:
+		c.round(f64, roundFloor)
+	case code.OpF64Trunc:
+		c.round(f64, roundTrunc)
+	case code.OpF64Nearest:
+		c.round(f64, roundNearest)
+	case code.OpF64Sqrt:
+		c.arith(f64, sseSqrt, xmm0, c.top(0))
+		c.movsStore(f64, c.top(0), xmm0)
+	case code.OpF64Add:
+		c.binaryFloat(f64, sseAdd)
+	case code.OpF64Sub:
+		c.binaryFloat(f64, sseSub)
+	case code.OpF64Mul:
+		c.binaryFloat(f64, sseMul)
+	case code.OpF64Div:
+		c.binaryFloat(f64, sseDiv)
+	case code.OpF64Min:
+		c.minMax(f64, sseMin)
+	case code.OpF64Max:
+		c.minMax(f64, sseMax)
+	case code.OpF64Copysign:
+		c.copysign(f64)
+
+	case code.OpI32WrapI64, code.OpI64ExtendI32U:
+		c.movl(r(rax), c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI32TruncF32S:
+		c.truncate(f32, false, true, false)
+	case code.OpI32TruncF32U:
+		c.truncate(f32, false, false, false)
+	case code.OpI32TruncF64S:
+		c.truncate(f64, false, true, false)
+	case code.OpI32TruncF64U:
+		c.truncate(f64, false, false, false)
+	case code.OpI64ExtendI32S, code.OpI64Extend32S:
+		c.movsxd(rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI64TruncF32S:
+		c.truncate(f32, true, true, false)
+	case code.OpI64TruncF32U:
+		c.truncate(f32, true, false, false)
+	case code.OpI64TruncF64S:
+		c.truncate(f64, true, true, false)
+	case code.OpI64TruncF64U:
+		c.truncate(f64, true, false, false)
+
+	case code.OpF32ConvertI32S:
+		c.convert(f32, false, true)
+	case code.OpF32ConvertI32U:
+		c.convert(f32, false, false)
+	case code.OpF32ConvertI64S:
+		c.convert(f32, true, true)
+	case code.OpF32ConvertI64U:
+		c.convert(f32, true, false)
+	case code.OpF32DemoteF64:
+		c.cvts2s(f64, xmm0, c.top(0))
+		c.movsStore(f32, c.top(0), xmm0)
+	case code.OpF64ConvertI32S:
+		c.convert(f64, false, true)
+	case code.OpF64ConvertI32U:
+		c.convert(f64, false, false)
+	case code.OpF64ConvertI64S:
+		c.convert(f64, true, true)
+	case code.OpF64ConvertI64U:
+		c.convert(f64, true, false)
+	case code.OpF64PromoteF32:
+		c.cvts2s(f32, xmm0, c.top(0))
+		c.movsStore(f64, c.top(0), xmm0)
+
+	case code.OpI32ReinterpretF32, code.OpI64ReinterpretF64, code.OpF32ReinterpretI32, code.OpF64ReinterpretI64:
+		// Values are stored as raw bits, so reinterpretation is a no-op.
+
+	case code.OpI32Extend8S:
+		c.movsx8(false, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI32Extend16S:
+		c.movsx16(false, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI64Extend8S:
+		c.movsx8(true, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+	case code.OpI64Extend16S:
+		c.movsx16(true, rax, c.top(0))
+		c.movq(c.top(0), r(rax))
+
+	case code.OpPrefix:
+		switch instr.Immediate {
+		case code.OpI32TruncSatF32S:
+			c.truncate(f32, false, true, true)
+		case code.OpI32TruncSatF32U:
+			c.truncate(f32, false, false, true)
+		case code.OpI32TruncSatF64S:
+			c.truncate(f64, false, true, true)
+		case code.OpI32TruncSatF64U:
+			c.truncate(f64, false, false, true)
+		case code.OpI64TruncSatF32S:
+			c.truncate(f32, true, true, true)
+		case code.OpI64TruncSatF32U:
+			c.truncate(f32, true, false, true)
+		case code.OpI64TruncSatF64S:
+			c.truncate(f64, true, true, true)
+		case code.OpI64TruncSatF64U:
+			c.truncate(f64, true, false, true)
+		default:
+			panic("unreachable")
+		}
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// brTable emits a branch table. The table holds the offsets of the branch targets relative to the start of the table.
+func (c *compiler) brTable(instr *code.Instruction) {
+	index := c.pop()
+
+	// Branches that need to move operands go through a stub that moves the operands and then branches to the target.
+	type stub struct {
+		label label
+		depth int
+	}
+	var stubs []stub
+	targets := map[int]label{}
+	target := func(depth int) label {
+		if l, ok := targets[depth]; ok {
+			return l
+		}
+		b := &c.blocks[len(c.blocks)-1-depth]
+		l := b.label
+		if c.needsMoves(b) {
+			l = c.newLabel()
+			stubs = append(stubs, stub{label: l, depth: depth})
+		}
+		targets[depth] = l
+		return l
+	}
+
+	table := c.newLabel()
+	c.movl(r(rax), index)
+	c.aluImm(false, aluCmp, r(rax), int32(len(instr.Labels)))
+	c.jcc(condAE, target(instr.Default()))
+	c.leaLabel(rcx, table)
+	c.movsxd(rax, mi(rcx, rax, 4, 0))
+	c.alu(true, aluAdd, r(rax), r(rcx))
+	c.jmpIndirect(r(rax))
+
+	c.bind(table)
+	start := c.pc()
+	for _, depth := range instr.Labels {
+		c.fixups = append(c.fixups, fixup{at: c.pc(), base: start, label: target(depth)})
+		c.u32(0)
+	}
+
+	for _, s := range stubs {
+		c.bind(s.label)
+		c.branch(s.depth)
+	}
+}
+
+// call emits a direct call to the given function.
+func (c *compiler) call(funcidx uint32) {
+	sig, _ := c.scope.GetFunctionSignature(funcidx)
+	args := c.height - len(sig.ParamTypes)
+	frame := int32(8 * (c.numLocals + 1 + args))
+
+	imports := uint32(len(c.module.importedFunctions))
+	if funcidx >= imports {
+		c.callNative(frame, func() { c.jmp(c.entries[funcidx-imports]) })
+	} else if f, ok := c.module.importedFunctions[funcidx].(*function); ok && f.code != 0 {
+		// The import is a compiled function.
+		c.callNative(frame, func() {
+			c.movImm(rcx, uint64(f.code))
+			c.jmpIndirect(r(rcx))
+		})
+		c.loadMemoryBase()
+	} else {
+		c.movImm(rcx, uint64(funcidx))
+		c.lea(rdx, m(regFrame, frame))
+		c.exitTo(exitCall)
+	}
+
+	c.height = args + len(sig.ReturnTypes)
+}
+
+// callNative emits a call to compiled code. jump must emit the jump to the callee.
+func (c *compiler) callNative(frame int32, jump func()) {
+	after := c.newLabel()
+	c.lea(regFrame, m(regFrame, frame))
+	c.leaLabel(rax, after)
+	jump()
+	c.bind(after)
+	c.lea(regFrame, m(regFrame, -frame))
+}
+
+// callIndirect emits an indirect call through the module's table. Calls to compiled functions with the expected
+// signature are made directly; all other calls exit to Go.
+func (c *compiler) callIndirect(typeidx uint32) {
+	sig := c.module.types[typeidx]
+	index := c.pop()
+	args := c.height - len(sig.ParamTypes)
+	frame := int32(8 * (c.numLocals + 1 + args))
+
+	slow, done := c.newLabel(), c.newLabel()
+
+	// Load the table entry.
+	c.movl(r(rax), index)
+	c.movImm(rcx, uint64(c.module.tableEntriesAddress()))
+	c.alu(true, aluCmp, r(rax), m(rcx, 8))
+	c.jcc(condAE, c.trap(trapUndefinedElement))
+	c.movq(r(rcx), m(rcx, 0))
+	c.shiftImm(true, shiftShl, r(rax), 4)
+	c.movImm(rdx, uint64(functionITab))
+	c.alu(true, aluCmp, r(rdx), mi(rcx, rax, 1, 0))
+	c.jcc(condNE, slow)
+	c.movq(r(rdx), mi(rcx, rax, 1, 8))
+	c.aluImm(false, aluCmp, m(rdx, fnTypeID), int32(c.typeIDs[typeidx]))
+	c.jcc(condNE, slow)
+	c.callNative(frame, func() { c.jmpIndirect(m(rdx, fnCode)) })
+	c.loadMemoryBase()
+	c.jmp(done)
+
+	c.bind(slow)
+	c.movImm(rcx, uint64(typeidx))
+	c.lea(rdx, m(regFrame, frame))
+	c.exitTo(exitCallIndirect)
+	c.bind(done)
+
+	c.height = args + len(sig.ReturnTypes)
+}
+
+// address computes the address of a memory access. The returned operand uses rax.
+func (c *compiler) address(addr operand, offset uint32, size int32) operand {
+	mem := c.module.mem0
+	if mem == nil || mem.Start() == 0 {
+		// The memory has no pages and can never grow: all accesses are out of bounds.
+		c.jmp(c.trap(trapOutOfBoundsMemoryAccess))
+		return mi(regMemory, rax, 1, 0)
+	}
+
+	c.movl(r(rax), addr)
+	if offset < 1<<31 {
+		// The memory's reservation covers any address below 2^32+2^31, so the access is checked by the guard pages.
+		return mi(regMemory, rax, 1, int32(offset))
+	}
+
+	c.movImm(rcx, uint64(offset))
+	c.alu(true, aluAdd, r(rax), r(rcx))
+	c.lea(rdx, m(rax, size))
+	c.movImm(rcx, uint64(c.module.memorySizeAddress()))
+	c.alu(true, aluCmp, r(rdx), m(rcx, 0))
+	c.jcc(condA, c.trap(trapOutOfBoundsMemoryAccess))
+	return mi(regMemory, rax, 1, 0)
+}
+
+// load emits a load of the given size. emit must emit the load into its destination register.
+func (c *compiler) load(instr *code.Instruction, size int32, emit func(dst reg, src operand)) {
+	addr := c.top(0)
+	emit(rcx, c.address(addr, instr.Offset(), size))
+	c.movq(addr, r(rcx))
+}
+
+// store emits a store of the given size. emit must emit the store from its source register.
+func (c *compiler) store(instr *code.Instruction, size int32, emit func(dst operand, src reg)) {
+	value, addr := c.pop(), c.pop()
+	c.movq(r(rcx), value)
+	emit(c.address(addr, instr.Offset(), size), rcx)
+}
+
+// constant pushes a constant.
+func (c *compiler) constant(v uint64) {
+	if fitsInt32(int64(v)) && int64(v) >= 0 {
+		c.movMemImm(true, c.push(), int32(v))
+		return
+	}
+	c.movImm(rax, v)
+	c.movq(c.push(), r(rax))
+}
+
+// intConditions maps the integer comparisons to condition codes in opcode order.
+var intConditions = [...]cond{condE, condNE, condL, condB, condG, condA, condLE, condBE, condGE, condAE}
+
+// compare emits an integer comparison.
+func (c *compiler) compare(w bool, cc cond) {
+	rhs, lhs := c.pop(), c.pop()
+	c.mov(w, r(rax), lhs)
+	c.alu(w, aluCmp, r(rax), rhs)
+	c.setCond(cc)
+}
+
+// compareFloat emits a float comparison. op is the offset of the comparison's opcode from eq.
+func (c *compiler) compareFloat(double bool, op byte) {
+	rhs, lhs := c.pop(), c.pop()
+
+	const (
+		eq = iota
+		ne
+		lt
+		gt
+		le
+		ge
+	)
+
+	// Unordered comparisons set ZF, PF, and CF, so equality must also check PF. The remaining comparisons are
+	// arranged so that they test CF and ZF, which are false for unordered operands.
+	switch op {
+	case eq, ne:
+		c.movImm(rax, 0)
+		c.movImm(rcx, 0)
+		c.movsLoad(double, xmm0, lhs)
+		c.ucomis(double, xmm0, rhs)
+		if op == eq {
+			c.setcc(condE, rax)
+			c.setcc(condNP, rcx)
+			c.alu(false, aluAnd, r(rax), r(rcx))
+		} else {
+			c.setcc(condNE, rax)
+			c.setcc(condP, rcx)
+			c.alu(false, aluOr, r(rax), r(rcx))
+		}
+		c.movq(c.push(), r(rax))
+	case lt, le:
+		c.movsLoad(double, xmm0, rhs)
+		c.ucomis(double, xmm0, lhs)
+		if op == lt {
+			c.setCond(condA)
+		} else {
+			c.setCond(condAE)
+		}
+	case gt, ge:
+		c.movsLoad(double, xmm0, lhs)
+		c.ucomis(double, xmm0, rhs)
+		if op == gt {
+			c.setCond(condA)
+		} else {
+			c.setCond(condAE)
+		}
+	}
+}
+
+// binary emits an integer arithmetic or logical operation.
+func (c *compiler) binary(w bool, op aluOp) {
+	rhs, lhs := c.pop(), c.top(0)
+	c.mov(w, r(rax), lhs)
+	c.alu(w, op, r(rax), rhs)
+	c.movq(lhs, r(rax))
+}
+
+// mul emits an integer multiplication.
+func (c *compiler) mul(w bool) {
+	rhs, lhs := c.pop(), c.top(0)
+	c.mov(w, r(rax), lhs)
+	c.imul(w, rax, rhs)
+	c.movq(lhs, r(rax))
+}
+
+// divide emits an integer division or remainder.
+func (c *compiler) divide(w, signed, remainder bool) {
+	rhs, lhs := c.pop(), c.top(0)
+
+	c.mov(w, r(rcx), rhs)
+	c.test(w, r(rcx), rcx)
+	c.jcc(condE, c.trap(trapIntegerDivideByZero))
+	c.mov(w, r(rax), lhs)
+
+	if !signed {
+		c.movImm(rdx, 0)
+		c.unary(w, unaryDiv, r(rcx))
+	} else {
+		// x86 faults on the overflowing division of the minimum integer by -1. The quotient traps; the remainder is 0.
+		divide, done := c.newLabel(), c.newLabel()
+		c.aluImm(w, aluCmp, r(rcx), -1)
+		c.jcc(condNE, divide)
+		if remainder {
+			c.movImm(rdx, 0)
+			c.jmp(done)
+		} else {
+			if w {
+				c.movImm(rdx, 1<<63)
+				c.alu(true, aluCmp, r(rax), r(rdx))
+			} else {
+				c.aluImm(false, aluCmp, r(rax), math.MinInt32)
+			}
+			c.jcc(condE, c.trap(trapIntegerOverflow))
+		}
+		c.bind(divide)
+		c.signExtendAX(w)
+		c.unary(w, unaryIdiv, r(rcx))
+		c.bind(done)
+	}
+
+	if remainder {
+		c.movq(lhs, r(rdx))
+	} else {
+		c.movq(lhs, r(rax))
+	}
+}
+
+// shift emits a shift or rotate.
+func (c *compiler) shift(w bool, op shiftOp) {
+	rhs, lhs := c.pop(), c.top(0)
+	c.movl(r(rcx), rhs)
+	c.mov(w, r(rax), lhs)
+	c.shiftCL(w, op, r(rax))
+	c.movq(lhs, r(rax))
+}
+
+// clz emits a count of leading zeros. bsr leaves its destination unchanged for a zero input, so the input is
+// handled using a conditional move: (2*bits-1) ^ (bits-1) == bits.
+func (c *compiler) clz(w bool) {
+	bits := uint64(32)
+	if w {
+		bits = 64
+	}
+	c.movImm(rcx, 2*bits-1)
+	c.bsr(w, rax, c.top(0))
+	c.cmov(w, condE, rax, r(rcx))
+	c.aluImm(w, aluXor, r(rax), int32(bits-1))
+	c.movq(c.top(0), r(rax))
+}
+
+// ctz emits a count of trailing zeros.
+func (c *compiler) ctz(w bool) {
+	bits := uint64(32)
+	if w {
+		bits = 64
+	}
+	c.movImm(rcx, bits)
+	c.bsf(w, rax, c.top(0))
+	c.cmov(w, condE, rax, r(rcx))
+	c.movq(c.top(0), r(rax))
+}
+
+// binaryFloat emits a float arithmetic operation.
+func (c *compiler) binaryFloat(double bool, op byte) {
+	rhs, lhs := c.pop(), c.top(0)
+	c.movsLoad(double, xmm0, lhs)
+	c.arith(double, op, xmm0, rhs)
+	c.movsStore(double, lhs, xmm0)
+}
+
+// minMax emits a float min or max. minss and maxss return their second operand if either operand is NaN or if both
+// operands are zero, so both cases are handled separately.
+func (c *compiler) minMax(double bool, op byte) {
+	rhs, lhs := c.pop(), c.top(0)
+
+	nan, ordered, done := c.newLabel(), c.newLabel(), c.newLabel()
+	c.movsLoad(double, xmm0, lhs)
+	c.movsLoad(double, xmm1, rhs)
+	c.ucomis(double, xmm0, x(xmm1))
+	c.jcc(condP, nan)
+	c.jcc(condNE, ordered)
+
+	// The operands are equal, but may be zeroes of different signs: -0 is less than +0.
+	if op == sseMin {
+		c.logical(double, sseOr, xmm0, x(xmm1))
+	} else {
+		c.logical(double, sseAnd, xmm0, x(xmm1))
+	}
+	c.jmp(done)
+
+	c.bind(nan)
+	c.arith(double, sseAdd, xmm0, x(xmm1))
+	c.jmp(done)
+
+	c.bind(ordered)
+	c.arith(double, op, xmm0, x(xmm1))
+
+	c.bind(done)
+	c.movsStore(double, lhs, xmm0)
+}
+
+// copysign emits a float copysign.
+func (c *compiler) copysign(double bool) {
+	rhs, lhs := c.pop(), c.top(0)
+	sign := uint8(31)
+	if double {
+		sign = 63
+	}
+	c.mov(double, r(rax), lhs)
+	c.bit(double, bitReset, r(rax), sign)
+	c.mov(double, r(rcx), rhs)
+	c.shiftImm(double, shiftShr, r(rcx), sign)
+	c.shiftImm(double, shiftShl, r(rcx), sign)
+	c.alu(double, aluOr, r(rax), r(rcx))
+	c.movq(lhs, r(rax))
+}
+
+// round emits a float rounding operation.
+func (c *compiler) round(double bool, mode byte) {
+	c.rounds(double, xmm0, c.top(0), mode)
+	c.movsStore(double, c.top(0), xmm0)
+}
+
+// floatConstant loads a float constant into an SSE register.
+func (c *compiler) floatConstant(double bool, dst xreg, v float64) {
+	if double {
+		c.movImm(rax, math.Float64bits(v))
+	} else {
+		c.movImm(rax, uint64(math.Float32bits(float32(v))))
+	}
+	c.movToX(double, dst, r(rax))
+}
+
+// truncate emits a conversion from a float to an integer. Conversions of NaN and of values that are out of range
+// either trap or saturate.
+func (c *compiler) truncate(double, w, signed, saturating bool) {
+	value := c.top(0)
+
+	// Determine the range of the conversion: lower < x < upper, or lower <= x < upper if lowerInclusive is set.
+	var lower, upper float64
+	var lowerInclusive bool
+	var min, max uint64
+	switch {
+	case signed && !w:
+		lower, upper, min, max = math.MinInt32, 1<<31, 1<<31, math.MaxInt32
+		if double {
+			lower--
+		} else {
+			lowerInclusive = true
+		}
+	case signed && w:
+		lower, upper, min, max, lowerInclusive = math.MinInt64, 1<<63, 1<<63, math.MaxInt64, true
+	case !w:
+		lower, upper, min, max = -1, 1<<32, 0, math.MaxUint32
+	default:
+		lower, upper, min, max = -1, 1<<64, 0, math.MaxUint64
+	}
+
+	nan, under, over, done := c.newLabel(), c.newLabel(), c.newLabel(), c.newLabel()
+	if !saturating {
+		nan = c.trap(trapInvalidConversionToInteger)
+		under, over = c.trap(trapIntegerOverflow), c.trap(trapIntegerOverflow)
+	}
+
+	c.movsLoad(double, xmm0, value)
+	c.ucomis(double, xmm0, x(xmm0))
+	c.jcc(condP, nan)
+	c.floatConstant(double, xmm1, lower)
+	c.ucomis(double, xmm0, x(xmm1))
+	if lowerInclusive {
+		c.jcc(condB, under)
+	} else {
+		c.jcc(condBE, under)
+	}
+	c.floatConstant(double, xmm1, upper)
+	c.ucomis(double, xmm0, x(xmm1))
+	c.jcc(condAE, over)
+
+	switch {
+	case signed:
+		c.cvtts2si(double, w, rax, x(xmm0))
+	case !w:
+		c.cvtts2si(double, true, rax, x(xmm0))
+	default:
+		// Values of 2^63 and above are converted relative to 2^63.
+		big := c.newLabel()
+		c.floatConstant(double, xmm1, 1<<63)
+		c.ucomis(double, xmm0, x(xmm1))
+		c.jcc(condAE, big)
+		c.cvtts2si(double, true, rax, x(xmm0))
+		c.jmp(done)
+		c.bind(big)
+		c.arith(double, sseSub, xmm0, x(xmm1))
+		c.cvtts2si(double, true, rax, x(xmm0))
+		c.bit(true, bitComplement, r(rax), 63)
+	}
+
+	if saturating {
+		c.jmp(done)
+		c.bind(nan)
+		c.movImm(rax, 0)
+		c.jmp(done)
+		c.bind(under)
+		c.movImm(rax, min)
+		c.jmp(done)
+		c.bind(over)
+		c.movImm(rax, max)
+	}
+
+	c.bind(done)
+	c.movq(value, r(rax))
+}
+
+// convert emits a conversion from an integer to a float.
+func (c *compiler) convert(double, w, signed bool) {
+	value := c.top(0)
+
+	c.logical(f32, sseXor, xmm0, x(xmm0))
+	switch {
+	case signed:
+		c.cvtsi2s(double, w, xmm0, value)
+	case !w:
+		c.movl(r(rax), value)
+		c.cvtsi2s(double, true, xmm0, r(rax))
+	default:
+		// Values of 2^63 and above are halved, converted, and doubled. The low bit is preserved in order to round
+		// correctly.
+		big, done := c.newLabel(), c.newLabel()
+		c.movq(r(rax), value)
+		c.test(true, r(rax), rax)
+		c.jcc(condS, big)
+		c.cvtsi2s(double, true, xmm0, r(rax))
+		c.jmp(done)
+		c.bind(big)
+		c.movq(r(rcx), r(rax))
+		c.shiftImm(true, shiftShr, r(rcx), 1)
+		c.aluImm(false, aluAnd, r(rax), 1)
+		c.alu(true, aluOr, r(rcx), r(rax))
+		c.cvtsi2s(double, true, xmm0, r(rcx))
+		c.arith(double, sseAdd, xmm0, x(xmm0))
+		c.bind(done)
+	}
+	c.movsStore(double, value, xmm0)
+}
@@ -0,0 +1,29 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+#include "go_asm.h"
+#include "textflag.h"
+
+// func enter(ctx *context, pc uintptr)
+//
+// enter calls compiled code at pc. The call's frame pointer and memory start are taken from the context, and the
+// code returns to the address in the context's link field.
+TEXT ·enter(SB), NOSPLIT, $0-16
+	MOVQ ctx+0(FP), R14
+	MOVQ pc+8(FP), BX
+	MOVQ context_frame(R14), R13
+	MOVQ context_memory(R14), R15
+	MOVQ context_link(R14), AX
+	CALL BX
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
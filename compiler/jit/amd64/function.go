@@ -0,0 +1,269 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+)
+
+// A function holds a compiled WASM function.
+type function struct {
+	code   uintptr // The address of the function's entry point. Zero until the function's module is instantiated.
+	typeID uint32  // The canonical ID of the function's signature.
+
+	module    *module          // The function's module.
+	index     uint32           // The function's index.
+	signature wasm.FunctionSig // The function signature.
+}
+
+// functionITab is the itab pointer for a *function stored in an exec.Function. Compiled code compares table entries
+// against this value in order to call compiled functions directly.
+var functionITab = func() uintptr {
+	var f exec.Function = (*function)(nil)
+	return (*[2]uintptr)(unsafe.Pointer(&f))[0]
+}()
+
+var signatureIDs = struct {
+	sync.Mutex
+	ids map[string]uint32
+}{ids: map[string]uint32{}}
+
+// signatureID returns the canonical ID for a function signature. Equal signatures have equal IDs.
+func signatureID(sig wasm.FunctionSig) uint32 {
+	key := make([]byte, 0, len(sig.ParamTypes)+len(sig.ReturnTypes)+1)
+	for _, t := range sig.ParamTypes {
+		key = append(key, byte(t))
+	}
+	key = append(key, 0)
+	for _, t := range sig.ReturnTypes {
+		key = append(key, byte(t))
+	}
+
+	signatureIDs.Lock()
+	defer signatureIDs.Unlock()
+
+	id, ok := signatureIDs.ids[string(key)]
+	if !ok {
+		id = uint32(len(signatureIDs.ids))
+		signatureIDs.ids[string(key)] = id
+	}
+	return id
+}
+
+func (f *function) GetSignature() wasm.FunctionSig {
+	return f.signature
+}
+
+func (f *function) Call(thread *exec.Thread, args ...interface{}) []interface{} {
+	if len(args) != len(f.signature.ParamTypes) {
+		panic(fmt.Errorf("expected %v args; got %v", len(f.signature.ParamTypes), len(args)))
+	}
+
+	rawArgs, rawReturns := make([]uint64, len(args)), make([]uint64, len(f.signature.ReturnTypes))
+	for i, v := range args {
+		paramType := f.signature.ParamTypes[i]
+
+		switch v := v.(type) {
+		case int32:
+			if paramType != wasm.ValueTypeI32 {
+				panic(fmt.Errorf("cannot assign int32 argument to a parameter of type %v", paramType))
+			}
+			rawArgs[i] = uint64(v)
+		case int64:
+			if paramType != wasm.ValueTypeI64 {
+				panic(fmt.Errorf("cannot assign int64 argument to a parameter of type %v", paramType))
+			}
+			rawArgs[i] = uint64(v)
+		case float32:
+			if paramType != wasm.ValueTypeF32 {
+				panic(fmt.Errorf("cannot assign float32 argument to a parameter of type %v", paramType))
+			}
+			rawArgs[i] = uint64(math.Float32bits(v))
+		case float64:
+			if paramType != wasm.ValueTypeF64 {
+				panic(fmt.Errorf("cannot assign float64 argument to a parameter of type %v", paramType))
+			}
+			rawArgs[i] = math.Float64bits(v)
+		default:
+			panic(fmt.Errorf("cannot assign %T argument to a parameter of type %v", v, f.signature.ParamTypes[i]))
+		}
+	}
+
+	f.UncheckedCall(thread, rawArgs, rawReturns)
+
+	returns := make([]interface{}, len(f.signature.ReturnTypes))
+	for i, t := range f.signature.ReturnTypes {
+		switch t {
+		case wasm.ValueTypeI32:
+			returns[i] = int32(rawReturns[i])
+		case wasm.ValueTypeI64:
+			returns[i] = int64(rawReturns[i])
+		case wasm.ValueTypeF32:
+			returns[i] = math.Float32frombits(uint32(rawReturns[i]))
+		case wasm.ValueTypeF64:
+			returns[i] = math.Float64frombits(rawReturns[i])
+		default:
+			panic("unreachable")
+		}
+	}
+	return returns
+}
+
+func (f *function) UncheckedCall(thread *exec.Thread, args, returns []uint64) {
+	if f.code == 0 {
+		panic(exec.TrapUninitializedElement)
+	}
+
+	thread.Enter()
+
+	// Faults in compiled code are out-of-bounds memory accesses.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() { exec.TranslateRecover(recover()) }()
+
+	ctx := contexts.Get().(*context)
+	ctx.thread, ctx.depth = thread, uint64(thread.MaxDepth())
+	ctx.frame, ctx.link = ctx.base, f.module.code.ret
+	copy(ctx.stack, args)
+
+	ctx.run(f.code)
+
+	// The function's module must remain reachable while its code is running: its code is unmapped when it is
+	// collected.
+	runtime.KeepAlive(f)
+
+	copy(returns, ctx.stack)
+	ctx.thread, ctx.module = nil, nil
+	contexts.Put(ctx)
+
+	thread.Leave()
+}
+
+// Exit codes. Compiled code exits to Go by storing an exit code and its arguments in the context and returning from
+// the outermost call.
+const (
+	exitReturn = iota
+	exitTrap
+	exitCall
+	exitCallIndirect
+	exitMemoryGrow
+)
+
+// stackSize is the size of the value stack in slots.
+const stackSize = 1 << 20
+
+// A context holds the state for a call from Go into compiled code.
+type context struct {
+	// These fields are accessed by compiled code.
+	exit   uint64  // The exit code.
+	arg    uint64  // The exit's argument.
+	args   uintptr // The address of the exit's operands.
+	resume uintptr // The address at which to resume execution.
+	frame  uintptr // The frame pointer.
+	memory uintptr // The start of the memory of the module that exited.
+	link   uintptr // The return address for the outermost call.
+	limit  uintptr // The end of the value stack.
+	depth  uint64  // The number of calls that may be made before the call stack is exhausted.
+	module *module // The module that exited.
+
+	thread  *exec.Thread // The thread that is executing the call.
+	base    uintptr      // The start of the value stack.
+	stack   []uint64     // The value stack.
+	scratch []uint64     // Scratch space for the arguments of calls to Go.
+}
+
+// Contexts are pooled. A context's value stack is allocated outside of the Go heap: its pages are only committed as
+// they are used, and the garbage collector never needs to scan it.
+var contexts = sync.Pool{
+	New: func() interface{} {
+		mem, err := syscall.Mmap(-1, 0, 8*stackSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+		if err != nil {
+			panic(err)
+		}
+
+		ctx := &context{base: uintptr(unsafe.Pointer(&mem[0]))}
+		ctx.stack = (*[stackSize]uint64)(unsafe.Pointer(&mem[0]))[:]
+		ctx.limit = ctx.base + 8*stackSize
+		runtime.SetFinalizer(ctx, func(ctx *context) { syscall.Munmap(mem) })
+		return ctx
+	},
+}
+
+// enter calls compiled code at pc. It is implemented in assembly.
+//
+//go:noescape
+func enter(ctx *context, pc uintptr)
+
+// run executes compiled code at pc until the outermost call returns, handling the code's exits.
+func (ctx *context) run(pc uintptr) {
+	for {
+		ctx.exit = exitReturn
+		enter(ctx, pc)
+
+		switch ctx.exit {
+		case exitReturn:
+			return
+		case exitTrap:
+			panic(traps[ctx.arg])
+		case exitCall:
+			ctx.call(ctx.module.importedFunctions[ctx.arg])
+		case exitCallIndirect:
+			ctx.callIndirect(uint32(ctx.arg))
+		case exitMemoryGrow:
+			operands := ctx.operands()
+			result, err := ctx.module.mem0.Grow(uint32(ctx.arg))
+			if err != nil {
+				operands[0] = uint64(math.MaxUint32)
+			} else {
+				operands[0] = uint64(result)
+			}
+		default:
+			panic("unreachable")
+		}
+
+		pc, ctx.memory = ctx.resume, ctx.module.mem0.Start()
+	}
+}
+
+// operands returns the value stack starting at the exit's operands.
+func (ctx *context) operands() []uint64 {
+	return ctx.stack[int((ctx.args-ctx.base)/8):]
+}
+
+// call calls a function that is not compiled. Its arguments are the exit's operands, and its results replace them.
+func (ctx *context) call(f exec.Function) {
+	sig, operands := f.GetSignature(), ctx.operands()
+	args := append(ctx.scratch[:0], operands[:len(sig.ParamTypes)]...)
+	f.UncheckedCall(ctx.thread, args, operands[:len(sig.ReturnTypes)])
+	ctx.scratch = args
+}
+
+// callIndirect calls a function through the module's table on behalf of compiled code. The index of the table
+// entry is above the call's arguments.
+func (ctx *context) callIndirect(typeidx uint32) {
+	m := ctx.module
+	sig := m.types[typeidx]
+
+	table := m.table0.Entries()
+	index := uint32(ctx.operands()[len(sig.ParamTypes)])
+	if index >= uint32(len(table)) {
+		panic(exec.TrapUndefinedElement)
+	}
+	f := table[int(index)]
+	if f == nil {
+		panic(exec.TrapUninitializedElement)
+	}
+	if !f.GetSignature().Equals(sig) {
+		panic(exec.TrapIndirectCallTypeMismatch)
+	}
+	ctx.call(f)
+}
@@ -0,0 +1,357 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+)
+
+// ErrInvalidMemoryIndex indicates that the memory index associated with a data section is
+// not valid.
+var ErrInvalidMemoryIndex = fmt.Errorf("invalid memory index")
+
+// Compiled code accesses the fields of memories, tables, and globals directly.
+var (
+	memorySizeOffset   = fieldOffset(exec.Memory{}, "size")
+	tableEntriesOffset = fieldOffset(exec.Table{}, "entries")
+	globalValueOffset  = fieldOffset(exec.Global{}, "value")
+)
+
+func fieldOffset(v interface{}, name string) uintptr {
+	f, ok := reflect.TypeOf(v).FieldByName(name)
+	if !ok {
+		panic(fmt.Errorf("%T has no field %v", v, name))
+	}
+	return f.Offset
+}
+
+// A module holds an instance of a WASM module.
+type module struct {
+	name string // The name of the module.
+
+	types     []wasm.FunctionSig // The types used by this module.
+	functions []function         // The function table for this module.
+	mem0      *exec.Memory       // The first memory for this module.
+	table0    *exec.Table        // The first table for this module.
+	globals   []exec.Global      // The globals defined by this module.
+
+	importedFunctions []exec.Function // The functions imported by this module.
+	importedGlobals   []*exec.Global  // The globals imported by this module.
+
+	code *codeBlock // The module's compiled code.
+
+	exports map[string]interface{} // The module's exports.
+}
+
+// memorySizeAddress returns the address of the size of the module's memory in bytes.
+func (m *module) memorySizeAddress() uintptr {
+	return uintptr(unsafe.Pointer(m.mem0)) + memorySizeOffset
+}
+
+// tableEntriesAddress returns the address of the slice that holds the entries of the module's table.
+func (m *module) tableEntriesAddress() uintptr {
+	return uintptr(unsafe.Pointer(m.table0)) + tableEntriesOffset
+}
+
+// globalAddress returns the address of the value of a global.
+func (m *module) globalAddress(index uint32) uintptr {
+	g, _ := m.getGlobal(index)
+	return uintptr(unsafe.Pointer(g)) + globalValueOffset
+}
+
+func (m *module) getFunction(index uint32) (exec.Function, bool) {
+	if index < uint32(len(m.importedFunctions)) {
+		return m.importedFunctions[int(index)], true
+	}
+	index -= uint32(len(m.importedFunctions))
+	if index >= uint32(len(m.functions)) {
+		return nil, false
+	}
+	return &m.functions[int(index)], true
+}
+
+func (m *module) getGlobal(index uint32) (*exec.Global, bool) {
+	if index < uint32(len(m.importedGlobals)) {
+		return m.importedGlobals[int(index)], true
+	}
+	index -= uint32(len(m.importedGlobals))
+	if index >= uint32(len(m.globals)) {
+		return nil, false
+	}
+	return &m.globals[int(index)], true
+}
+
+func (m *module) Name() string {
+	return m.name
+}
+
+func (m *module) newExportError(name string, importKind wasm.External, export interface{}) error {
+	if export == nil {
+		return &exec.ExportNotFoundError{ModuleName: m.name, FieldName: name}
+	}
+
+	var exportKind wasm.External
+	switch export.(type) {
+	case *function:
+		exportKind = wasm.ExternalFunction
+	case *exec.Table:
+		exportKind = wasm.ExternalTable
+	case *exec.Memory:
+		exportKind = wasm.ExternalMemory
+	case *exec.Global:
+		exportKind = wasm.ExternalGlobal
+	default:
+		panic("unreachable")
+	}
+	return exec.NewKindMismatchError(m.name, name, importKind, exportKind)
+}
+
+func (m *module) GetFunction(name string) (exec.Function, error) {
+	export := m.exports[name]
+	if function, ok := export.(exec.Function); ok {
+		return function, nil
+	}
+	return nil, m.newExportError(name, wasm.ExternalFunction, export)
+}
+
+func (m *module) GetTable(name string) (*exec.Table, error) {
+	export := m.exports[name]
+	if table, ok := export.(*exec.Table); ok {
+		return table, nil
+	}
+	return nil, m.newExportError(name, wasm.ExternalTable, export)
+}
+
+func (m *module) GetMemory(name string) (*exec.Memory, error) {
+	export := m.exports[name]
+	if memory, ok := export.(*exec.Memory); ok {
+		return memory, nil
+	}
+	return nil, m.newExportError(name, wasm.ExternalMemory, export)
+}
+
+func (m *module) GetGlobal(name string) (*exec.Global, error) {
+	export := m.exports[name]
+	if global, ok := export.(*exec.Global); ok {
+		return global, nil
+	}
+	return nil, m.newExportError(name, wasm.ExternalGlobal, export)
+}
+
+type allocatedModule struct {
+	*module
+
+	imports  []wasm.ImportEntry         // The module's imports.
+	globals  []wasm.GlobalEntry         // The module's globals.
+	exports  []wasm.ExportEntry         // The module's exports.
+	elements []wasm.ElementSegment      // The module's element segments.
+	data     []wasm.DataSegment         // The module's data segments.
+	start    *wasm.SectionStartFunction // The module's start function, if any.
+
+	definition *moduleDefinition // The module's definition.
+}
+
+func (m *allocatedModule) defineExports() error {
+	for _, export := range m.exports {
+		switch export.Kind {
+		case wasm.ExternalFunction:
+			m.module.exports[export.FieldStr], _ = m.getFunction(export.Index)
+		case wasm.ExternalMemory:
+			if export.Index != 0 {
+				return ErrInvalidMemoryIndex
+			}
+			m.module.exports[export.FieldStr] = m.mem0
+		case wasm.ExternalTable:
+			if export.Index != 0 {
+				return exec.InvalidTableIndexError(export.Index)
+			}
+			m.module.exports[export.FieldStr] = m.table0
+		case wasm.ExternalGlobal:
+			m.module.exports[export.FieldStr], _ = m.getGlobal(export.Index)
+		}
+	}
+	return nil
+}
+
+func (m *allocatedModule) Instantiate(imports exec.ImportResolver) (exec.Module, error) {
+	// Resolve imports.
+	funcidx, globalidx := 0, 0
+	for _, import_ := range m.imports {
+		switch type_ := import_.Type.(type) {
+		case wasm.FuncImport:
+			if type_.Type >= uint32(len(m.types)) {
+				return nil, exec.ErrInvalidTypeIndex
+			}
+			sig := m.types[int(type_.Type)]
+			f, err := imports.ResolveFunction(import_.ModuleName, import_.FieldName, sig)
+			if err != nil {
+				return nil, err
+			}
+			m.importedFunctions[funcidx] = f
+			funcidx++
+		case wasm.MemoryImport:
+			if m.mem0 != nil {
+				return nil, &exec.InvalidImportError{}
+			}
+			mem, err := imports.ResolveMemory(import_.ModuleName, import_.FieldName, type_.Type)
+			if err != nil {
+				return nil, err
+			}
+			m.mem0 = mem
+		case wasm.TableImport:
+			if m.table0 != nil {
+				return nil, &exec.InvalidImportError{}
+			}
+			table, err := imports.ResolveTable(import_.ModuleName, import_.FieldName, type_.Type)
+			if err != nil {
+				return nil, err
+			}
+			m.table0 = table
+		case wasm.GlobalVarImport:
+			g, err := imports.ResolveGlobal(import_.ModuleName, import_.FieldName, type_.Type)
+			if err != nil {
+				return nil, err
+			}
+			m.importedGlobals[globalidx] = g
+			globalidx++
+		default:
+			panic("unreachable")
+		}
+	}
+
+	// Initialize globals.
+	if err := m.initializeGlobals(); err != nil {
+		return nil, err
+	}
+
+	// Define exports.
+	if err := m.defineExports(); err != nil {
+		return nil, err
+	}
+
+	// Compile the module's functions. Compiled code refers to the module's imports, so compilation cannot begin any
+	// earlier.
+	code, err := compile(m.module, m.definition.mod)
+	if err != nil {
+		return nil, err
+	}
+	m.code = code
+
+	// Check element and data segments.
+	elementOffsets, err := m.checkElementSegments()
+	if err != nil {
+		return nil, err
+	}
+	dataOffsets, err := m.checkDataSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Evaluate element and segments.
+	m.evaluateElementSegments(elementOffsets)
+	m.evaluateDataSegments(dataOffsets)
+
+	// Evaluate the module's start function, if any.
+	if m.start != nil {
+		thread := exec.NewThread(0)
+		func_, _ := m.getFunction(m.start.Index)
+		func_.UncheckedCall(&thread, nil, nil)
+	}
+
+	return m.module, nil
+}
+
+func (m *allocatedModule) initializeGlobals() error {
+	for i, globalEntry := range m.globals {
+		value, err := exec.EvalConstantExpression(m.importedGlobals, globalEntry.Init)
+		if err != nil {
+			return err
+		}
+
+		switch value := value.(type) {
+		case int32:
+			m.module.globals[i] = exec.NewGlobalI32(!globalEntry.Type.Mutable, value)
+		case int64:
+			m.module.globals[i] = exec.NewGlobalI64(!globalEntry.Type.Mutable, value)
+		case float32:
+			m.module.globals[i] = exec.NewGlobalF32(!globalEntry.Type.Mutable, value)
+		case float64:
+			m.module.globals[i] = exec.NewGlobalF64(!globalEntry.Type.Mutable, value)
+		default:
+			panic("unreachable")
+		}
+	}
+	return nil
+}
+
+func (m *allocatedModule) checkElementSegments() ([]int, error) {
+	offsets := make([]int, len(m.elements))
+	for i, element := range m.elements {
+		offsetV, err := exec.EvalConstantExpression(m.importedGlobals, element.Offset)
+		if err != nil {
+			return nil, err
+		}
+		offset, ok := offsetV.(int32)
+		if !ok {
+			return nil, exec.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.ValueOf(offsetV).Kind()}
+		}
+
+		if element.Index != 0 || m.table0 == nil {
+			return nil, exec.InvalidTableIndexError(element.Index)
+		}
+
+		entries := m.table0.Entries()
+		if offset < 0 || offset > int32(len(entries)) || len(element.Elems) > len(entries[int(offset):]) {
+			return nil, exec.ErrElementSegmentDoesNotFit
+		}
+		offsets[i] = int(offset)
+	}
+	return offsets, nil
+}
+
+func (m *allocatedModule) evaluateElementSegments(offsets []int) {
+	for i, element := range m.elements {
+		offset, entries := offsets[i], m.table0.Entries()
+		for j, funcIndex := range element.Elems {
+			entries[offset+j], _ = m.getFunction(funcIndex)
+		}
+	}
+}
+
+func (m *allocatedModule) checkDataSegments() ([]int, error) {
+	offsets := make([]int, len(m.data))
+	for i, data := range m.data {
+		offsetV, err := exec.EvalConstantExpression(m.importedGlobals, data.Offset)
+		if err != nil {
+			return nil, err
+		}
+		offset, ok := offsetV.(int32)
+		if !ok {
+			return nil, exec.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.ValueOf(offsetV).Kind()}
+		}
+
+		if data.Index != 0 || m.mem0 == nil {
+			return nil, exec.InvalidTableIndexError(data.Index)
+		}
+
+		bytes := m.mem0.Bytes()
+		if offset < 0 || offset > int32(len(bytes)) || len(bytes[int(offset):]) < len(data.Data) {
+			return nil, exec.ErrDataSegmentDoesNotFit
+		}
+		offsets[i] = int(offset)
+	}
+	return offsets, nil
+}
+
+func (m *allocatedModule) evaluateDataSegments(offsets []int) {
+	for i, data := range m.data {
+		offset, bytes := offsets[i], m.mem0.Bytes()
+		copy(bytes[offset:], data.Data)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build amd64 && !memtrace && !js && !plan9 && !windows
+// +build amd64,!memtrace,!js,!plan9,!windows
+
+package amd64
+
+import (
+	"io"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+)
+
+type moduleDefinition struct {
+	mod *wasm.Module
+}
+
+// NewModuleDefinition creates a new ModuleDefinition from the given WASM module. The module's functions are compiled
+// to machine code when the module is instantiated.
+func NewModuleDefinition(module *wasm.Module) exec.ModuleDefinition {
+	return &moduleDefinition{mod: module}
+}
+
+// LoadModuleDefinition decodes a WASM module from the given Reader and uses it to create a ModuleDefinition.
+func LoadModuleDefinition(r io.Reader) (exec.ModuleDefinition, error) {
+	mod, err := wasm.DecodeModule(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewModuleDefinition(mod), nil
+}
+
+func (def *moduleDefinition) Allocate(name string) (exec.AllocatedModule, error) {
+	if !supported {
+		return nil, ErrUnsupported
+	}
+
+	module := allocatedModule{
+		module:     &module{name: name},
+		definition: def,
+	}
+
+	// Allocate import entries.
+	if def.mod.Import != nil {
+		module.imports = def.mod.Import.Entries
+
+		funcImports, globalImports := 0, 0
+		for _, import_ := range def.mod.Import.Entries {
+			switch import_.Type.(type) {
+			case wasm.FuncImport:
+				funcImports++
+			case wasm.GlobalVarImport:
+				globalImports++
+			}
+		}
+		module.importedFunctions = make([]exec.Function, funcImports)
+		module.importedGlobals = make([]*exec.Global, globalImports)
+	}
+
+	if def.mod.Types != nil {
+		module.types = def.mod.Types.Entries
+	}
+
+	// Allocate globals, functions, memories, and tables.
+	if def.mod.Global != nil {
+		module.globals = def.mod.Global.Globals
+		module.module.globals = make([]exec.Global, len(def.mod.Global.Globals))
+	}
+
+	if def.mod.Code != nil {
+		module.functions = make([]function, len(def.mod.Code.Bodies))
+		for i := range def.mod.Code.Bodies {
+			f := &module.functions[i]
+			f.module = module.module
+			f.index = uint32(len(module.importedFunctions) + i)
+			f.signature = def.mod.Types.Entries[def.mod.Function.Types[i]]
+			f.typeID = signatureID(f.signature)
+		}
+	}
+
+	if def.mod.Memory != nil && len(def.mod.Memory.Entries) != 0 {
+		mem0Def := def.mod.Memory.Entries[0]
+		min := mem0Def.Limits.Initial
+		max := mem0Def.Limits.Maximum
+		if mem0Def.Limits.Flags == 0 {
+			max = 65536
+		}
+		m := exec.NewMemory(min, max)
+		module.mem0 = &m
+	}
+
+	if def.mod.Table != nil && len(def.mod.Table.Entries) != 0 {
+		table0Def := def.mod.Table.Entries[0]
+		min := table0Def.Limits.Initial
+		max := table0Def.Limits.Maximum
+		if table0Def.Limits.Flags == 0 {
+			max = ^uint32(0)
+		}
+		t := exec.NewTable(min, max)
+		module.table0 = &t
+	}
+
+	// Define exports.
+	module.module.exports = map[string]interface{}{}
+	if def.mod.Export != nil {
+		module.exports = def.mod.Export.Entries
+		if err := module.defineExports(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Record initialization info.
+	if def.mod.Elements != nil {
+		module.elements = def.mod.Elements.Entries
+	}
+	if def.mod.Data != nil {
+		module.data = def.mod.Data.Entries
+	}
+	module.start = def.mod.Start
+
+	return &module, nil
+}
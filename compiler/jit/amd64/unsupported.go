@@ -0,0 +1,32 @@
+//go:build !amd64 || memtrace || js || plan9 || windows
+// +build !amd64 memtrace js plan9 windows
+
+package amd64
+
+import (
+	"io"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+)
+
+type moduleDefinition struct{}
+
+// NewModuleDefinition creates a new ModuleDefinition from the given WASM module. Compiled code is not supported on
+// this platform, so the definition's Allocate method always returns ErrUnsupported.
+func NewModuleDefinition(module *wasm.Module) exec.ModuleDefinition {
+	return moduleDefinition{}
+}
+
+// LoadModuleDefinition decodes a WASM module from the given Reader and uses it to create a ModuleDefinition.
+func LoadModuleDefinition(r io.Reader) (exec.ModuleDefinition, error) {
+	mod, err := wasm.DecodeModule(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewModuleDefinition(mod), nil
+}
+
+func (moduleDefinition) Allocate(name string) (exec.AllocatedModule, error) {
+	return nil, ErrUnsupported
+}
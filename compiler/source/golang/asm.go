@@ -0,0 +1,1439 @@
+package golang
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pgavlin/warp/compiler/wax"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/pgavlin/warp/wasm/leb128"
+	"github.com/pgavlin/warp/wasm/validate"
+)
+
+// AssemblySectionName is the name of the custom section that annotates the functions of a module that should be
+// compiled to assembly. The section's payload is a vector of function names. See ReadAssemblyAnnotations.
+const AssemblySectionName = "warp.asm"
+
+// ErrAssemblyOutput indicates that functions were selected for compilation to assembly, but the compilation has no
+// output for assembly.
+var ErrAssemblyOutput = errors.New("compiling functions to assembly requires an assembly output")
+
+// ErrShardedAssembly indicates that functions were selected for compilation to assembly, but the module's functions
+// are sharded into packages.
+var ErrShardedAssembly = errors.New("functions compiled to assembly cannot be sharded into packages")
+
+// LookupFunctions returns the indices of the named functions of the given module. Functions are named as described
+// by ReadInlineProfile. The result is suitable for use as Options.AssemblyFunctions.
+func LookupFunctions(module *wasm.Module, names []string) (map[uint32]bool, error) {
+	lookup := functionIndices(module)
+
+	functions := map[uint32]bool{}
+	for _, name := range names {
+		index, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", name)
+		}
+		functions[index] = true
+	}
+	return functions, nil
+}
+
+// ReadAssemblyAnnotations returns the indices of the functions that are listed by the module's warp.asm custom
+// section, if any. The section's payload is a vector of function names, each encoded as a length-prefixed UTF-8
+// string. Functions are named as described by ReadInlineProfile. The result is suitable for use as
+// Options.AssemblyFunctions.
+func ReadAssemblyAnnotations(module *wasm.Module) (map[uint32]bool, error) {
+	section := module.Custom(AssemblySectionName)
+	if section == nil {
+		return nil, nil
+	}
+
+	r := bytes.NewReader(section.Data)
+	count, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v section: %w", AssemblySectionName, err)
+	}
+	names := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		n, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading %v section: %w", AssemblySectionName, err)
+		}
+		if uint64(n) > uint64(r.Len()) {
+			return nil, fmt.Errorf("reading %v section: name %v is truncated", AssemblySectionName, i)
+		}
+		name := make([]byte, n)
+		r.Read(name)
+		names = append(names, string(name))
+	}
+	return LookupFunctions(module, names)
+}
+
+// selectAssemblyFunctions records the defined functions that will be compiled to assembly.
+func (m *moduleCompiler) selectAssemblyFunctions() {
+	if m.module.Code == nil {
+		return
+	}
+
+	totalCalls := int64(0)
+	for _, calls := range m.asmProfile {
+		totalCalls += calls
+	}
+
+	for i := range m.module.Code.Bodies {
+		funcidx := uint32(i + len(m.importedFunctions))
+
+		// Functions that account for at least 1% of all calls are hot.
+		calls := m.asmProfile[funcidx]
+		if m.allAsm || m.asmSelection[funcidx] || calls != 0 && calls*100 >= totalCalls {
+			if m.asmFunctions == nil {
+				m.asmFunctions = map[uint32]bool{}
+			}
+			m.asmFunctions[funcidx] = true
+		}
+	}
+}
+
+// CompileModuleAssembly compiles the given module into Go source code and Go assembly for amd64. The source is
+// written to w as by CompileModule, and the assembly for the functions that Options selects for compilation to
+// assembly is written to s. The assembly must be written to a file in the same package as the source whose name ends
+// in "_amd64.s".
+func CompileModuleAssembly(w, s io.Writer, packageName, name string, module *wasm.Module, options *Options) error {
+	if err := validate.ValidateModule(module, true); err != nil {
+		return err
+	}
+
+	name = identName(name)
+
+	compiler := moduleCompiler{
+		packageName:  packageName,
+		name:         unexportName(name),
+		exportedName: exportName(name),
+		module:       module,
+		asmOutput:    true,
+	}
+	options.apply(&compiler)
+
+	if err := compiler.compile(); err != nil {
+		return err
+	}
+	if err := compiler.emit(w); err != nil {
+		return err
+	}
+
+	functions := make([]*functionCompiler, len(compiler.functions))
+	for i := range compiler.functions {
+		functions[i] = &compiler.functions[i]
+	}
+	return compiler.emitAssemblyFile(s, functions)
+}
+
+// assemblyFilePath returns the path of the assembly file that accompanies the Go file with the given path.
+func assemblyFilePath(goPath string) string {
+	return strings.TrimSuffix(goPath, ".go") + "_amd64.s"
+}
+
+// hasAssembly returns true if any of the given functions is compiled to assembly.
+func (m *moduleCompiler) hasAssembly(functions []*functionCompiler) bool {
+	for _, f := range functions {
+		if m.asmFunctions[uint32(f.index)] {
+			return true
+		}
+	}
+	return false
+}
+
+// emitAssemblyFile emits an assembly file that contains the given functions. Functions that are not compiled to
+// assembly are skipped.
+func (m *moduleCompiler) emitAssemblyFile(w io.Writer, functions []*functionCompiler) error {
+	if err := printf(w, "#include \"textflag.h\"\n#include \"funcdata.h\"\n#include \"go_asm.h\"\n"); err != nil {
+		return err
+	}
+	for _, f := range functions {
+		if f.asm != nil {
+			if _, err := w.Write(f.asm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The traps raised by compiled assembly. These are passed to the module's asmTrap helper.
+const (
+	asmTrapUnreachable = iota
+	asmTrapDivideByZero
+	asmTrapIntegerOverflow
+
+	asmTrapCount
+)
+
+// asmPrefix marks the operations of the module's asmOp helper that are encoded with the prefix opcode.
+const asmPrefix = 0xfc00
+
+// Operands of the module's asmOp helper.
+const (
+	asmA32  = "float64(math.Float32frombits(uint32(a)))"
+	asmB32  = "float64(math.Float32frombits(uint32(b)))"
+	asmA64  = "math.Float64frombits(a)"
+	asmB64  = "math.Float64frombits(b)"
+	asmF32  = "uint64(math.Float32bits(float32(%s)))"
+	asmF64  = "math.Float64bits(%s)"
+	asmUint = "uint64(%s)"
+)
+
+// asmOps lists the operations that compiled assembly delegates to the module's asmOp helper, and the Go expressions
+// that implement them. Operands and results are passed as the bits of the corresponding values.
+var asmOps = []struct {
+	op   uint64
+	expr string
+}{
+	{code.OpI32Popcnt, fmt.Sprintf(asmUint, "bits.OnesCount32(uint32(a))")},
+	{code.OpI64Popcnt, fmt.Sprintf(asmUint, "bits.OnesCount64(a)")},
+
+	{code.OpF32Ceil, fmt.Sprintf(asmF32, "math.Ceil("+asmA32+")")},
+	{code.OpF32Floor, fmt.Sprintf(asmF32, "math.Floor("+asmA32+")")},
+	{code.OpF32Trunc, fmt.Sprintf(asmF32, "math.Trunc("+asmA32+")")},
+	{code.OpF32Nearest, fmt.Sprintf(asmF32, "math.RoundToEven("+asmA32+")")},
+	{code.OpF32Min, fmt.Sprintf(asmF32, "exec.Fmin("+asmA32+", "+asmB32+")")},
+	{code.OpF32Max, fmt.Sprintf(asmF32, "exec.Fmax("+asmA32+", "+asmB32+")")},
+
+	{code.OpF64Ceil, fmt.Sprintf(asmF64, "math.Ceil("+asmA64+")")},
+	{code.OpF64Floor, fmt.Sprintf(asmF64, "math.Floor("+asmA64+")")},
+	{code.OpF64Trunc, fmt.Sprintf(asmF64, "math.Trunc("+asmA64+")")},
+	{code.OpF64Nearest, fmt.Sprintf(asmF64, "math.RoundToEven("+asmA64+")")},
+	{code.OpF64Min, fmt.Sprintf(asmF64, "exec.Fmin("+asmA64+", "+asmB64+")")},
+	{code.OpF64Max, fmt.Sprintf(asmF64, "exec.Fmax("+asmA64+", "+asmB64+")")},
+
+	{code.OpI32TruncF32S, fmt.Sprintf(asmUint, "exec.I32TruncS("+asmA32+")")},
+	{code.OpI32TruncF32U, fmt.Sprintf(asmUint, "exec.I32TruncU("+asmA32+")")},
+	{code.OpI32TruncF64S, fmt.Sprintf(asmUint, "exec.I32TruncS("+asmA64+")")},
+	{code.OpI32TruncF64U, fmt.Sprintf(asmUint, "exec.I32TruncU("+asmA64+")")},
+	{code.OpI64TruncF32S, fmt.Sprintf(asmUint, "exec.I64TruncS("+asmA32+")")},
+	{code.OpI64TruncF32U, fmt.Sprintf(asmUint, "exec.I64TruncU("+asmA32+")")},
+	{code.OpI64TruncF64S, fmt.Sprintf(asmUint, "exec.I64TruncS("+asmA64+")")},
+	{code.OpI64TruncF64U, fmt.Sprintf(asmUint, "exec.I64TruncU("+asmA64+")")},
+
+	{code.OpF32ConvertI64U, fmt.Sprintf(asmF32, "a")},
+	{code.OpF64ConvertI64U, fmt.Sprintf(asmF64, "float64(a)")},
+
+	{asmPrefix | code.OpI32TruncSatF32S, fmt.Sprintf(asmUint, "exec.I32TruncSatS("+asmA32+")")},
+	{asmPrefix | code.OpI32TruncSatF32U, fmt.Sprintf(asmUint, "exec.I32TruncSatU("+asmA32+")")},
+	{asmPrefix | code.OpI32TruncSatF64S, fmt.Sprintf(asmUint, "exec.I32TruncSatS("+asmA64+")")},
+	{asmPrefix | code.OpI32TruncSatF64U, fmt.Sprintf(asmUint, "exec.I32TruncSatU("+asmA64+")")},
+	{asmPrefix | code.OpI64TruncSatF32S, fmt.Sprintf(asmUint, "exec.I64TruncSatS("+asmA32+")")},
+	{asmPrefix | code.OpI64TruncSatF32U, fmt.Sprintf(asmUint, "exec.I64TruncSatU("+asmA32+")")},
+	{asmPrefix | code.OpI64TruncSatF64S, fmt.Sprintf(asmUint, "exec.I64TruncSatS("+asmA64+")")},
+	{asmPrefix | code.OpI64TruncSatF64U, fmt.Sprintf(asmUint, "exec.I64TruncSatU("+asmA64+")")},
+}
+
+// asmHelper returns the name of the given helper function for compiled assembly.
+func (m *moduleCompiler) asmHelper(name string) string {
+	return fmt.Sprintf("%s_asm%s", m.name, name)
+}
+
+// emitAssemblyHelpers emits the Go functions that compiled assembly calls to perform operations that are not
+// implemented inline. The helpers use the ABI0 calling convention: each parameter and result occupies eight bytes.
+func (m *moduleCompiler) emitAssemblyHelpers(w io.Writer) error {
+	if len(m.asmFunctions) == 0 {
+		return nil
+	}
+
+	err := printf(w, `func %s(trap uint64) {
+	switch trap {
+	case %d:
+		panic(exec.TrapUnreachable)
+	case %d:
+		panic(exec.TrapIntegerDivideByZero)
+	default:
+		panic(exec.TrapIntegerOverflow)
+	}
+}
+
+func %s(op, a, b uint64) uint64 {
+	switch op {
+`, m.asmHelper("Trap"), asmTrapUnreachable, asmTrapDivideByZero, m.asmHelper("Op"))
+	if err != nil {
+		return err
+	}
+	for _, op := range asmOps {
+		if err := printf(w, "\tcase %#x:\n\t\treturn %s\n", op.op, op.expr); err != nil {
+			return err
+		}
+	}
+	err = printf(w, `	}
+	panic("unreachable")
+}
+
+func %s(m *%sInstance) uint64 {
+	return uint64(m.%s.Size())
+}
+
+func %s(m *%[2]sInstance, pages uint64) uint64 {
+	sz, err := m.%[3]s.Grow(uint32(pages))
+	if err != nil {
+		return math.MaxUint64
+	}
+	return uint64(sz)
+}
+`, m.asmHelper("MemorySize"), m.name, m.ident("mem0"), m.asmHelper("MemoryGrow"))
+	if err != nil {
+		return err
+	}
+
+	if !m.noInternalThreads {
+		err := printf(w, "\nfunc %s(t *exec.Thread) {\n\tt.Enter()\n}\n\nfunc %s(t *exec.Thread) {\n\tt.Leave()\n}\n", m.asmHelper("Enter"), m.asmHelper("Leave"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Globals that are represented by exec.Global values are accessed through helpers.
+	var globals []uint32
+	for i := range m.importedGlobals {
+		globals = append(globals, uint32(i))
+	}
+	for globalidx := range m.exportedGlobals {
+		if globalidx >= uint32(len(m.importedGlobals)) {
+			globals = append(globals, globalidx)
+		}
+	}
+	if len(globals) == 0 {
+		return nil
+	}
+	sort.Slice(globals, func(i, j int) bool { return globals[i] < globals[j] })
+
+	if err := printf(w, "\nfunc %s(m *%sInstance, globalidx uint64) uint64 {\n\tswitch globalidx {\n", m.asmHelper("GlobalGet"), m.name); err != nil {
+		return err
+	}
+	for _, globalidx := range globals {
+		if err := printf(w, "\tcase %d:\n\t\treturn m.%s%[1]d.Get()\n", globalidx, m.ident("g")); err != nil {
+			return err
+		}
+	}
+	if err := printf(w, "\t}\n\tpanic(\"unreachable\")\n}\n"); err != nil {
+		return err
+	}
+
+	if err := printf(w, "\nfunc %s(m *%sInstance, globalidx, v uint64) {\n\tswitch globalidx {\n", m.asmHelper("GlobalSet"), m.name); err != nil {
+		return err
+	}
+	for _, globalidx := range globals {
+		if err := printf(w, "\tcase %d:\n\t\tm.%s%[1]d.Set(v)\n", globalidx, m.ident("g")); err != nil {
+			return err
+		}
+	}
+	return printf(w, "\t}\n}\n")
+}
+
+// isExecGlobal returns true if the given global is represented by an exec.Global value.
+func (m *moduleCompiler) isExecGlobal(globalidx uint32) bool {
+	return globalidx < uint32(len(m.importedGlobals)) || m.exportedGlobals[globalidx]
+}
+
+// An abiLayout describes the locations of the arguments and results of a call that uses the ABI0 calling convention.
+type abiLayout struct {
+	params  []int // The offsets of the parameters.
+	results []int // The offsets of the results.
+	size    int   // The total size of the arguments and results.
+}
+
+// newABILayout returns the layout of a call with parameters and results of the given sizes. Each value is aligned to
+// its size, and the results begin at the next pointer-aligned offset after the parameters.
+func newABILayout(params, results []int) abiLayout {
+	var l abiLayout
+	offset := 0
+	for _, size := range params {
+		offset = (offset + size - 1) &^ (size - 1)
+		l.params = append(l.params, offset)
+		offset += size
+	}
+	offset = (offset + 7) &^ 7
+	for _, size := range results {
+		offset = (offset + size - 1) &^ (size - 1)
+		l.results = append(l.results, offset)
+		offset += size
+	}
+	l.size = (offset + 7) &^ 7
+	return l
+}
+
+func valueSize(t wasm.ValueType) int {
+	switch t {
+	case wasm.ValueTypeI64, wasm.ValueTypeF64:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// signatureLayout returns the layout of a call to a function with the given signature. The parameters of the Go
+// function are the module instance, the thread unless internal threads are disabled, the table index if the call is
+// indirect, and the parameters of the signature.
+func (m *moduleCompiler) signatureLayout(sig wasm.FunctionSig, indirect bool) (abiLayout, int) {
+	params := []int{8}
+	if !m.noInternalThreads {
+		params = append(params, 8)
+	}
+	if indirect {
+		params = append(params, 4)
+	}
+	first := len(params)
+	for _, t := range sig.ParamTypes {
+		params = append(params, valueSize(t))
+	}
+	results := make([]int, len(sig.ReturnTypes))
+	for i, t := range sig.ReturnTypes {
+		results[i] = valueSize(t)
+	}
+	return newABILayout(params, results), first
+}
+
+func isFloat(t wasm.ValueType) bool {
+	return t == wasm.ValueTypeF32 || t == wasm.ValueTypeF64
+}
+
+// primary returns the register that holds the result of an expression of the given type.
+func primary(t wasm.ValueType) string {
+	if isFloat(t) {
+		return "X0"
+	}
+	return "AX"
+}
+
+// secondary returns the register that holds the second operand of a binary operation of the given type.
+func secondary(t wasm.ValueType) string {
+	if isFloat(t) {
+		return "X1"
+	}
+	return "CX"
+}
+
+// move returns the instruction that moves a value of the given type between registers or memory.
+func move(t wasm.ValueType) string {
+	switch t {
+	case wasm.ValueTypeI64:
+		return "MOVQ"
+	case wasm.ValueTypeF32:
+		return "MOVSS"
+	case wasm.ValueTypeF64:
+		return "MOVSD"
+	default:
+		return "MOVL"
+	}
+}
+
+// suffix returns the operand size suffix of integer instructions that operate on values of the given type, or on the
+// bits of values of the given type.
+func suffix(t wasm.ValueType) string {
+	if t == wasm.ValueTypeI64 || t == wasm.ValueTypeF64 {
+		return "Q"
+	}
+	return "L"
+}
+
+// An asmFunction compiles a function into Go assembly for amd64.
+//
+// The compiled function uses the ABI0 calling convention, and can be called from Go like any other function of the
+// module. Each local and temp occupies an eight-byte slot in the function's frame, with the exception of parameters,
+// which are accessed in place. Expressions are evaluated into AX or X0, with the second operand of a binary operation
+// in CX or X1. Operations that are not implemented inline call Go helpers, which clobber all registers. The start of
+// the module's memory is cached in R12 within straight-line code. Out-of-bounds memory accesses rely on the guard
+// pages that surround the module's memory.
+type asmFunction struct {
+	f *functionCompiler
+	m *moduleCompiler
+
+	w bytes.Buffer
+
+	layout     abiLayout // The layout of the function's parameters and results.
+	slots      int       // The number of local and temp slots.
+	scratch    int       // The number of scratch slots in use.
+	maxScratch int       // The maximum number of scratch slots in use.
+	outgoing   int       // The size of the outgoing argument area.
+
+	labels    int
+	blocks    map[*wax.Block]int
+	traps     [asmTrapCount]bool
+	memLoaded bool
+}
+
+// compileAssembly compiles the function into Go assembly.
+func (f *functionCompiler) compileAssembly() []byte {
+	m := f.m
+	a := &asmFunction{f: f, m: m, blocks: map[*wax.Block]int{}}
+	a.layout, _ = m.signatureLayout(f.Signature, false)
+	a.slots = 2*len(f.Locals) + f.Temps
+
+	if !m.noInternalThreads {
+		a.callThreadHelper("Enter")
+	}
+	for i, t := range f.Locals[len(f.Signature.ParamTypes):] {
+		if f.UsedLocals[len(f.Signature.ParamTypes)+i] {
+			a.emit("MOV%s $0, %s", suffix(t), a.local(len(f.Signature.ParamTypes)+i))
+		}
+	}
+
+	returns := false
+	for _, d := range f.Body {
+		a.def(d)
+		returns = !d.IsPseudo() && d.Instr.Opcode == code.OpReturn
+	}
+	if !returns {
+		a.emit("RET")
+	}
+
+	for trap, used := range a.traps {
+		if used {
+			a.label(a.trapLabel(trap))
+			a.emit("MOVQ $%d, 0(SP)", trap)
+			a.emit("CALL ·%s(SB)", m.asmHelper("Trap"))
+			a.emit("UNDEF")
+			a.reserve(8)
+		}
+	}
+
+	var text bytes.Buffer
+	frame := a.outgoing + 8*(a.slots+a.maxScratch)
+	fmt.Fprintf(&text, "\n// func %s", m.functionName(uint32(f.index)))
+	if err := m.emitFunctionSignature(&text, f.Signature, false); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(&text, "\nTEXT ·%s(SB), 0, $%d-%d\n\tNO_LOCAL_POINTERS\n", m.functionName(uint32(f.index)), frame, a.layout.size)
+	text.Write(a.w.Bytes())
+	return text.Bytes()
+}
+
+func (a *asmFunction) emit(format string, args ...interface{}) {
+	a.w.WriteByte('\t')
+	fmt.Fprintf(&a.w, format, args...)
+	a.w.WriteByte('\n')
+}
+
+// label emits a label. The start of memory must be reloaded after a label, as it may not have been loaded along all
+// paths to the label.
+func (a *asmFunction) label(name string) {
+	fmt.Fprintf(&a.w, "%s:\n", name)
+	a.memLoaded = false
+}
+
+func (a *asmFunction) newLabel(kind string) string {
+	a.labels++
+	return fmt.Sprintf("%s%d", kind, a.labels)
+}
+
+func (a *asmFunction) blockLabel(b *wax.Block, kind string) string {
+	id, ok := a.blocks[b]
+	if !ok {
+		id = len(a.blocks)
+		a.blocks[b] = id
+	}
+	return fmt.Sprintf("block%d_%s", id, kind)
+}
+
+func (a *asmFunction) trapLabel(trap int) string {
+	return [...]string{"trap_unreachable", "trap_divide_by_zero", "trap_integer_overflow"}[trap]
+}
+
+func (a *asmFunction) trap(trap int) string {
+	a.traps[trap] = true
+	return a.trapLabel(trap)
+}
+
+// reserve ensures that the outgoing argument area is at least the given size.
+func (a *asmFunction) reserve(size int) {
+	if size > a.outgoing {
+		a.outgoing = size
+	}
+}
+
+// slot returns the operand for the slot with the given index, relative to the top of the frame.
+func (a *asmFunction) slot(index int) string {
+	return fmt.Sprintf("s%d-%d(SP)", index, 8*(index+1))
+}
+
+// local returns the operand for the given local. Parameters are accessed in the caller's frame.
+func (a *asmFunction) local(localidx int) string {
+	if localidx < len(a.f.Signature.ParamTypes) {
+		return fmt.Sprintf("v%d+%d(FP)", localidx, a.layout.params[a.paramIndex(localidx)])
+	}
+	return a.slot(localidx)
+}
+
+func (a *asmFunction) paramIndex(localidx int) int {
+	if a.m.noInternalThreads {
+		return localidx + 1
+	}
+	return localidx + 2
+}
+
+// temp returns the operand for the given temp. Temps follow the locals.
+func (a *asmFunction) temp(temp int) string {
+	return a.slot(len(a.f.Locals) + temp)
+}
+
+// pushScratch allocates a scratch slot.
+func (a *asmFunction) pushScratch() string {
+	s := a.slot(a.slots + a.scratch)
+	a.scratch++
+	if a.scratch > a.maxScratch {
+		a.maxScratch = a.scratch
+	}
+	return s
+}
+
+func (a *asmFunction) popScratch(n int) {
+	a.scratch -= n
+}
+
+// loadMemory loads the start of the module's memory into R12 if necessary.
+func (a *asmFunction) loadMemory() {
+	if !a.memLoaded {
+		a.emit("MOVQ m+0(FP), R12")
+		a.emit("MOVQ %sInstance_%s(R12), R12", a.m.name, a.m.ident("mem"))
+		a.memLoaded = true
+	}
+}
+
+// call emits a call to the given function. All registers are clobbered by the call.
+func (a *asmFunction) call(name string) {
+	a.emit("CALL ·%s(SB)", name)
+	a.memLoaded = false
+}
+
+func (a *asmFunction) callThreadHelper(name string) {
+	a.emit("MOVQ t+8(FP), AX")
+	a.emit("MOVQ AX, 0(SP)")
+	a.call(a.m.asmHelper(name))
+	a.reserve(8)
+}
+
+// isSimple returns true if the given use can be loaded into a register without clobbering other registers.
+func (a *asmFunction) isSimple(u *wax.Use) bool {
+	if u.IsTemp() {
+		return true
+	}
+	x := u.X
+	if x.IsPseudo() {
+		return x.Instr.Opcode == wax.PseudoBoolConst
+	}
+	switch x.Instr.Opcode {
+	case code.OpLocalGet, code.OpI32Const, code.OpI64Const, code.OpF32Const, code.OpF64Const:
+		return true
+	case code.OpGlobalGet:
+		return !a.m.isExecGlobal(x.Instr.Globalidx())
+	}
+	return false
+}
+
+// loadSimple loads a simple use into the given register. R11 may be clobbered.
+func (a *asmFunction) loadSimple(u *wax.Use, reg string) {
+	if u.IsTemp() {
+		a.emit("%s %s, %s", move(u.Type), a.temp(u.Temp), reg)
+		return
+	}
+
+	x := u.X
+	if x.IsPseudo() {
+		v := 0
+		if wax.BoolConst(x) {
+			v = 1
+		}
+		a.emit("MOVL $%d, %s", v, reg)
+		return
+	}
+
+	switch x.Instr.Opcode {
+	case code.OpLocalGet:
+		localidx := int(x.Instr.Localidx())
+		a.emit("%s %s, %s", move(a.f.Locals[localidx]), a.local(localidx), reg)
+	case code.OpGlobalGet:
+		globalidx := x.Instr.Globalidx()
+		a.emit("MOVQ m+0(FP), R11")
+		a.emit("%s %sInstance_%s%d(R11), %s", move(a.m.globalType(globalidx)), a.m.name, a.m.ident("g"), globalidx, reg)
+	case code.OpI32Const:
+		a.emit("MOVL $%d, %s", x.Instr.I32(), reg)
+	case code.OpI64Const:
+		a.emit("MOVQ $%d, %s", x.Instr.I64(), reg)
+	case code.OpF32Const:
+		a.emit("MOVL $%d, R11", int32(x.Instr.Immediate))
+		a.emit("MOVL R11, %s", reg)
+	case code.OpF64Const:
+		a.emit("MOVQ $%d, R11", int64(x.Instr.Immediate))
+		a.emit("MOVQ R11, %s", reg)
+	}
+}
+
+// eval evaluates the given use into its primary register.
+func (a *asmFunction) eval(u *wax.Use) {
+	if a.isSimple(u) {
+		a.loadSimple(u, primary(u.Type))
+		return
+	}
+	a.expr(u.X, u.Type)
+}
+
+// evalPair evaluates the given uses into the primary register of the first and the secondary register of the
+// second.
+func (a *asmFunction) evalPair(u0, u1 *wax.Use) {
+	switch {
+	case a.isSimple(u1):
+		a.eval(u0)
+		a.loadSimple(u1, secondary(u1.Type))
+	case a.isSimple(u0):
+		a.eval(u1)
+		a.emit("%s %s, %s", move(u1.Type), primary(u1.Type), secondary(u1.Type))
+		a.loadSimple(u0, primary(u0.Type))
+	default:
+		a.eval(u0)
+		s := a.pushScratch()
+		a.emit("%s %s, %s", move(u0.Type), primary(u0.Type), s)
+		a.eval(u1)
+		a.emit("%s %s, %s", move(u1.Type), primary(u1.Type), secondary(u1.Type))
+		a.emit("%s %s, %s", move(u0.Type), s, primary(u0.Type))
+		a.popScratch(1)
+	}
+}
+
+// evalAll evaluates the given uses in order, and returns the scratch slots that hold the values of those uses that
+// are not simple. The caller must pop the returned slots.
+func (a *asmFunction) evalAll(uses wax.Uses) ([]string, int) {
+	slots, n := make([]string, len(uses)), 0
+	for i, u := range uses {
+		if !a.isSimple(u) {
+			a.eval(u)
+			slots[i] = a.pushScratch()
+			a.emit("%s %s, %s", move(u.Type), primary(u.Type), slots[i])
+			n++
+		}
+	}
+	return slots, n
+}
+
+// loadEvaluated loads a use evaluated by evalAll into its primary register.
+func (a *asmFunction) loadEvaluated(u *wax.Use, slot string) {
+	if slot == "" {
+		a.loadSimple(u, primary(u.Type))
+		return
+	}
+	a.emit("%s %s, %s", move(u.Type), slot, primary(u.Type))
+}
+
+// assignTemps assigns the values of the given uses to consecutive temps. All uses are evaluated before any temp is
+// assigned.
+func (a *asmFunction) assignTemps(temp int, uses wax.Uses) {
+	if len(uses) == 1 {
+		a.eval(uses[0])
+		a.emit("%s %s, %s", move(uses[0].Type), primary(uses[0].Type), a.temp(temp))
+		return
+	}
+
+	slots, n := a.evalAll(uses)
+	for i, u := range uses {
+		a.loadEvaluated(u, slots[i])
+		a.emit("%s %s, %s", move(u.Type), primary(u.Type), a.temp(temp+i))
+	}
+	a.popScratch(n)
+}
+
+// intCompares maps integer comparisons to their condition codes.
+var intCompares = map[byte]string{
+	code.OpI32Eq: "EQ", code.OpI32Ne: "NE", code.OpI32LtS: "LT", code.OpI32LtU: "CS", code.OpI32GtS: "GT",
+	code.OpI32GtU: "HI", code.OpI32LeS: "LE", code.OpI32LeU: "LS", code.OpI32GeS: "GE", code.OpI32GeU: "CC",
+
+	code.OpI64Eq: "EQ", code.OpI64Ne: "NE", code.OpI64LtS: "LT", code.OpI64LtU: "CS", code.OpI64GtS: "GT",
+	code.OpI64GtU: "HI", code.OpI64LeS: "LE", code.OpI64LeU: "LS", code.OpI64GeS: "GE", code.OpI64GeU: "CC",
+}
+
+// inverseConditions maps condition codes to their inverses.
+var inverseConditions = map[string]string{
+	"EQ": "NE", "NE": "EQ", "LT": "GE", "GE": "LT", "GT": "LE", "LE": "GT",
+	"CS": "CC", "CC": "CS", "HI": "LS", "LS": "HI",
+}
+
+// compare evaluates the given condition into the flags, and returns the condition code that is set if the condition
+// is true.
+func (a *asmFunction) compare(u *wax.Use) string {
+	if !u.IsTemp() && !u.X.IsPseudo() {
+		x := u.X
+		if cc, ok := intCompares[x.Instr.Opcode]; ok {
+			a.evalPair(x.Uses[0], x.Uses[1])
+			a.emit("CMP%s AX, CX", suffix(x.Uses[0].Type))
+			return cc
+		}
+		if x.Instr.Opcode == code.OpI32Eqz || x.Instr.Opcode == code.OpI64Eqz {
+			a.eval(x.Uses[0])
+			a.emit("TEST%s AX, AX", suffix(x.Uses[0].Type))
+			return "EQ"
+		}
+	}
+	a.eval(u)
+	a.emit("TESTL AX, AX")
+	return "NE"
+}
+
+// jump jumps to the given label if the given condition has the given sense.
+func (a *asmFunction) jump(cond *wax.Use, sense bool, label string) {
+	cc := a.compare(cond)
+	if !sense {
+		cc = inverseConditions[cc]
+	}
+	a.emit("J%s %s", cc, label)
+}
+
+func (a *asmFunction) def(x *wax.Def) {
+	if x.IsPseudo() {
+		switch x.Instr.Opcode {
+		case wax.PseudoEnter:
+			if !a.m.noInternalThreads {
+				a.callThreadHelper("Enter")
+			}
+			return
+		case wax.PseudoLeave:
+			if !a.m.noInternalThreads {
+				a.callThreadHelper("Leave")
+			}
+			return
+		}
+		panic(fmt.Errorf("unexpected pseudo instruction %#v", x.Instr))
+	}
+
+	switch x.Instr.Opcode {
+	case code.OpUnreachable:
+		a.emit("JMP %s", a.trap(asmTrapUnreachable))
+
+	case code.OpBlock, code.OpLoop:
+		if len(x.Uses) != 0 {
+			a.assignTemps(x.Block.InTemp, x.Uses)
+		}
+		if x.Instr.Opcode == code.OpLoop {
+			a.label(a.blockLabel(x.Block, "loop"))
+		}
+	case code.OpIf:
+		ins, cond := x.Uses[:len(x.Uses)-1], x.Uses[len(x.Uses)-1]
+		if len(ins) != 0 {
+			a.assignTemps(x.Block.InTemp, ins)
+		}
+		target := a.blockLabel(x.Block, "end")
+		if x.Block.Else != nil {
+			target = a.blockLabel(x.Block, "else")
+		} else {
+			for i, t := range x.Block.Ins {
+				a.emit("%s %s, %s", move(t), a.temp(x.Block.InTemp+i), primary(t))
+				a.emit("%s %s, %s", move(t), primary(t), a.temp(x.Block.OutTemp+i))
+			}
+		}
+		a.jump(cond, false, target)
+	case code.OpElse:
+		if len(x.Uses) != 0 {
+			a.assignTemps(x.Block.OutTemp, x.Uses)
+		}
+		a.emit("JMP %s", a.blockLabel(x.Block, "end"))
+		a.label(a.blockLabel(x.Block, "else"))
+	case code.OpEnd:
+		if x.Block != nil {
+			if len(x.Uses) != 0 {
+				a.assignTemps(x.Block.OutTemp, x.Uses)
+			}
+			a.label(a.blockLabel(x.Block, "end"))
+		}
+
+	case code.OpBr:
+		a.branch(x, 0, x.Uses)
+	case code.OpBrIf:
+		cond, uses := x.Uses[len(x.Uses)-1], x.Uses[:len(x.Uses)-1]
+		if len(uses) == 0 {
+			a.jump(cond, true, a.branchLabel(x.BranchTargets[0]))
+		} else {
+			skip := a.newLabel("skip")
+			a.jump(cond, false, skip)
+			a.branch(x, 0, uses)
+			a.label(skip)
+		}
+		if len(x.Types) != 0 {
+			a.assignTemps(x.Temp, uses)
+		}
+	case code.OpBrTable:
+		index, uses := x.Uses[len(x.Uses)-1], x.Uses[:len(x.Uses)-1]
+		a.eval(index)
+
+		cases := make([]string, len(x.BranchTargets))
+		for i, b := range x.BranchTargets {
+			if len(uses) == 0 {
+				cases[i] = a.branchLabel(b)
+			} else {
+				cases[i] = a.newLabel("case")
+			}
+			if i < len(x.BranchTargets)-1 {
+				a.emit("CMPL AX, $%d", i)
+				a.emit("JEQ %s", cases[i])
+			} else {
+				a.emit("JMP %s", cases[i])
+			}
+		}
+		if len(uses) != 0 {
+			for i := range x.BranchTargets {
+				a.label(cases[i])
+				a.branch(x, i, uses)
+			}
+		}
+
+	case code.OpCall:
+		funcidx := x.Instr.Funcidx()
+		sig, _ := a.m.GetFunctionSignature(funcidx)
+		a.callFunction(a.m.functionName(funcidx), sig, false, nil, x.Uses, x.Temp)
+	case code.OpCallIndirect:
+		sig := a.m.module.Types.Entries[x.Instr.Typeidx()]
+		tableidx, uses := x.Uses[len(x.Uses)-1], x.Uses[:len(x.Uses)-1]
+		a.callFunction(a.m.functionTypeName(sig)+"CallIndirect", sig, true, tableidx, uses, x.Temp)
+
+	case code.OpReturn:
+		for i, u := range x.Uses {
+			a.eval(u)
+			a.emit("%s %s, r%d+%d(FP)", move(u.Type), primary(u.Type), i, a.layout.results[i])
+		}
+		if !a.m.noInternalThreads {
+			a.callThreadHelper("Leave")
+		}
+		a.emit("RET")
+
+	case code.OpDrop:
+		if !a.isSimple(x.Uses[0]) {
+			a.eval(x.Uses[0])
+		}
+
+	case code.OpSelect:
+		t := x.Types[0]
+		otherwise, end := a.newLabel("else"), a.newLabel("end")
+		a.jump(x.Uses[2], false, otherwise)
+		a.eval(x.Uses[0])
+		a.emit("%s %s, %s", move(t), primary(t), a.temp(x.Temp))
+		a.emit("JMP %s", end)
+		a.label(otherwise)
+		a.eval(x.Uses[1])
+		a.emit("%s %s, %s", move(t), primary(t), a.temp(x.Temp))
+		a.label(end)
+
+	case code.OpLocalSet:
+		localidx := int(x.Instr.Localidx())
+		if a.f.UsedLocals[localidx] {
+			a.eval(x.Uses[0])
+			a.emit("%s %s, %s", move(a.f.Locals[localidx]), primary(x.Uses[0].Type), a.local(localidx))
+		} else if !a.isSimple(x.Uses[0]) {
+			a.eval(x.Uses[0])
+		}
+
+	case code.OpLocalTee:
+		localidx := int(x.Instr.Localidx())
+		t := a.f.Locals[localidx]
+		a.eval(x.Uses[0])
+		a.emit("%s %s, %s", move(t), primary(t), a.local(localidx))
+		a.emit("%s %s, %s", move(t), primary(t), a.temp(x.Temp))
+
+	case code.OpGlobalSet:
+		globalidx := x.Instr.Globalidx()
+		t := a.m.globalType(globalidx)
+		a.eval(x.Uses[0])
+		if !a.m.isExecGlobal(globalidx) {
+			a.emit("MOVQ m+0(FP), R11")
+			a.emit("%s %s, %sInstance_%s%d(R11)", move(t), primary(t), a.m.name, a.m.ident("g"), globalidx)
+			return
+		}
+		switch t {
+		case wasm.ValueTypeI32:
+			a.emit("MOVLQSX AX, AX")
+		case wasm.ValueTypeF32:
+			a.emit("MOVL X0, AX")
+		case wasm.ValueTypeF64:
+			a.emit("MOVQ X0, AX")
+		}
+		a.emit("MOVQ AX, 16(SP)")
+		a.emit("MOVQ m+0(FP), AX")
+		a.emit("MOVQ AX, 0(SP)")
+		a.emit("MOVQ $%d, 8(SP)", globalidx)
+		a.call(a.m.asmHelper("GlobalSet"))
+		a.reserve(24)
+
+	case code.OpI32Store:
+		a.store(x, "MOVL")
+	case code.OpI64Store:
+		a.store(x, "MOVQ")
+	case code.OpF32Store:
+		a.store(x, "MOVSS")
+	case code.OpF64Store:
+		a.store(x, "MOVSD")
+	case code.OpI32Store8, code.OpI64Store8:
+		a.store(x, "MOVB")
+	case code.OpI32Store16, code.OpI64Store16:
+		a.store(x, "MOVW")
+	case code.OpI64Store32:
+		a.store(x, "MOVL")
+
+	case code.OpMemoryGrow:
+		a.eval(x.Uses[0])
+		a.emit("MOVL AX, AX")
+		a.emit("MOVQ AX, 8(SP)")
+		a.emit("MOVQ m+0(FP), AX")
+		a.emit("MOVQ AX, 0(SP)")
+		a.call(a.m.asmHelper("MemoryGrow"))
+		a.emit("MOVL 16(SP), AX")
+		a.emit("MOVL AX, %s", a.temp(x.Temp))
+		a.reserve(24)
+
+	default:
+		t := x.Types[0]
+		a.eval(wax.UseExpression(t, x.Expression))
+		a.emit("%s %s, %s", move(t), primary(t), a.temp(x.Temp))
+	}
+}
+
+// branchLabel returns the label that a branch to the given block jumps to.
+func (a *asmFunction) branchLabel(b *wax.Block) string {
+	if b.Entry.Instr.Opcode == code.OpLoop {
+		return a.blockLabel(b, "loop")
+	}
+	return a.blockLabel(b, "end")
+}
+
+// branch assigns the given values to the temps of the given branch target and jumps to the target.
+func (a *asmFunction) branch(x *wax.Def, target int, uses wax.Uses) {
+	dest := x.BranchTargets[target]
+	if len(uses) != 0 {
+		temp := dest.OutTemp
+		if dest.Entry.Instr.Opcode == code.OpLoop {
+			temp = dest.InTemp
+		}
+		a.assignTemps(temp, uses)
+	}
+	a.emit("JMP %s", a.branchLabel(dest))
+}
+
+// callFunction calls the given function with the given arguments and assigns its results to consecutive temps. If
+// the call is indirect, the table index precedes the arguments, and is evaluated first.
+func (a *asmFunction) callFunction(name string, sig wasm.FunctionSig, indirect bool, tableidx *wax.Use, uses wax.Uses, temp int) {
+	layout, first := a.m.signatureLayout(sig, indirect)
+
+	if indirect {
+		uses = append(wax.Uses{tableidx}, uses...)
+		first--
+	}
+	slots, n := a.evalAll(uses)
+	for i, u := range uses {
+		a.loadEvaluated(u, slots[i])
+		a.emit("%s %s, %d(SP)", move(u.Type), primary(u.Type), layout.params[first+i])
+	}
+	a.popScratch(n)
+
+	a.emit("MOVQ m+0(FP), AX")
+	a.emit("MOVQ AX, 0(SP)")
+	if !a.m.noInternalThreads {
+		a.emit("MOVQ t+8(FP), AX")
+		a.emit("MOVQ AX, 8(SP)")
+	}
+	a.call(name)
+	a.reserve(layout.size)
+
+	for i, t := range sig.ReturnTypes {
+		a.emit("%s %d(SP), %s", move(t), layout.results[i], primary(t))
+		a.emit("%s %s, %s", move(t), primary(t), a.temp(temp+i))
+	}
+}
+
+// address evaluates the effective address of the given memory access into AX, relative to the start of memory in
+// R12, and returns the operand for the access.
+func (a *asmFunction) address(x *wax.Expression) string {
+	a.loadMemory()
+	a.emit("MOVL AX, AX")
+	offset := x.Instr.Offset()
+	if offset <= 0x7fffffff {
+		return fmt.Sprintf("%d(R12)(AX*1)", offset)
+	}
+	a.emit("MOVL $%d, DX", int32(offset))
+	a.emit("ADDQ DX, AX")
+	return "(R12)(AX*1)"
+}
+
+func (a *asmFunction) store(x *wax.Def, instr string) {
+	a.evalPair(x.Uses[0], x.Uses[1])
+	a.emit("%s %s, %s", instr, secondary(x.Uses[1].Type), a.address(x.Expression))
+}
+
+func (a *asmFunction) load(x *wax.Expression, instr, reg string) {
+	a.eval(x.Uses[0])
+	a.emit("%s %s, %s", instr, a.address(x), reg)
+}
+
+// callOp calls the module's asmOp helper to perform the given operation on the given operands.
+func (a *asmFunction) callOp(op uint64, result wasm.ValueType, uses ...*wax.Use) {
+	if len(uses) == 1 {
+		a.eval(uses[0])
+		a.emit("%s %s, 8(SP)", move(uses[0].Type), primary(uses[0].Type))
+	} else {
+		a.evalPair(uses[0], uses[1])
+		a.emit("%s %s, 8(SP)", move(uses[0].Type), primary(uses[0].Type))
+		a.emit("%s %s, 16(SP)", move(uses[1].Type), secondary(uses[1].Type))
+	}
+	a.emit("MOVQ $%d, 0(SP)", op)
+	a.call(a.m.asmHelper("Op"))
+	a.emit("%s 24(SP), %s", move(result), primary(result))
+	a.reserve(32)
+}
+
+// setCondition converts the flags into a boolean in AX.
+func (a *asmFunction) setCondition(cc string) {
+	a.emit("SET%s AX", cc)
+	a.emit("MOVBLZX AX, AX")
+}
+
+// floatCompare compares the given floating point operands and sets AX to the result.
+func (a *asmFunction) floatCompare(x *wax.Expression, instr string) {
+	a.evalPair(x.Uses[0], x.Uses[1])
+	switch x.Instr.Opcode {
+	case code.OpF32Eq, code.OpF64Eq:
+		// Equality requires that the operands are ordered.
+		a.emit("%s X1, X0", instr)
+		a.emit("SETEQ AX")
+		a.emit("SETPC CX")
+		a.emit("ANDL CX, AX")
+		a.emit("MOVBLZX AX, AX")
+	case code.OpF32Ne, code.OpF64Ne:
+		a.emit("%s X1, X0", instr)
+		a.emit("SETNE AX")
+		a.emit("SETPS CX")
+		a.emit("ORL CX, AX")
+		a.emit("MOVBLZX AX, AX")
+	case code.OpF32Lt, code.OpF64Lt:
+		a.emit("%s X0, X1", instr)
+		a.setCondition("HI")
+	case code.OpF32Gt, code.OpF64Gt:
+		a.emit("%s X1, X0", instr)
+		a.setCondition("HI")
+	case code.OpF32Le, code.OpF64Le:
+		a.emit("%s X0, X1", instr)
+		a.setCondition("CC")
+	case code.OpF32Ge, code.OpF64Ge:
+		a.emit("%s X1, X0", instr)
+		a.setCondition("CC")
+	}
+}
+
+// binary evaluates the operands of the given expression and applies the given instruction to them.
+func (a *asmFunction) binary(x *wax.Expression, instr string) {
+	a.evalPair(x.Uses[0], x.Uses[1])
+	a.emit("%s %s, %s", instr, secondary(x.Uses[1].Type), primary(x.Uses[0].Type))
+}
+
+// divide emits a signed or unsigned division or remainder.
+func (a *asmFunction) divide(x *wax.Expression, t wasm.ValueType, signed, remainder bool) {
+	s := suffix(t)
+	a.evalPair(x.Uses[0], x.Uses[1])
+	a.emit("TEST%s CX, CX", s)
+	a.emit("JEQ %s", a.trap(asmTrapDivideByZero))
+
+	if !signed {
+		a.emit("XOR%s DX, DX", s)
+		a.emit("DIV%s CX", s)
+		if remainder {
+			a.emit("MOV%s DX, AX", s)
+		}
+		return
+	}
+
+	// The division of the smallest integer by -1 overflows. The quotient traps, and the remainder is zero.
+	divide, end := a.newLabel("divide"), a.newLabel("end")
+	a.emit("CMP%s CX, $-1", s)
+	a.emit("JNE %s", divide)
+	if remainder {
+		a.emit("XOR%s AX, AX", s)
+	} else {
+		a.emit("NEG%s AX", s)
+		a.emit("JOS %s", a.trap(asmTrapIntegerOverflow))
+	}
+	a.emit("JMP %s", end)
+	a.label(divide)
+	if t == wasm.ValueTypeI64 {
+		a.emit("CQO")
+	} else {
+		a.emit("CDQ")
+	}
+	a.emit("IDIV%s CX", s)
+	if remainder {
+		a.emit("MOV%s DX, AX", s)
+	}
+	a.label(end)
+}
+
+// expr evaluates the given expression into the primary register of the given type.
+func (a *asmFunction) expr(x *wax.Expression, t wasm.ValueType) {
+	if x.IsPseudo() {
+		switch x.Instr.Opcode {
+		case wax.PseudoI32ConvertBool:
+			// Booleans are represented as 0 or 1.
+			a.eval(x.Uses[0])
+			return
+		}
+		panic(fmt.Errorf("unexpected pseudo instruction %#v", x.Instr))
+	}
+
+	switch x.Instr.Opcode {
+	case code.OpLocalTee:
+		localidx := int(x.Instr.Localidx())
+		a.emit("%s %s, %s", move(t), a.local(localidx), primary(t))
+		return
+
+	case code.OpGlobalGet:
+		a.emit("MOVQ m+0(FP), AX")
+		a.emit("MOVQ AX, 0(SP)")
+		a.emit("MOVQ $%d, 8(SP)", x.Instr.Globalidx())
+		a.call(a.m.asmHelper("GlobalGet"))
+		a.emit("%s 16(SP), %s", move(t), primary(t))
+		a.reserve(24)
+		return
+
+	case code.OpI32Load:
+		a.load(x, "MOVL", "AX")
+	case code.OpI64Load:
+		a.load(x, "MOVQ", "AX")
+	case code.OpF32Load:
+		a.load(x, "MOVSS", "X0")
+	case code.OpF64Load:
+		a.load(x, "MOVSD", "X0")
+	case code.OpI32Load8S:
+		a.load(x, "MOVBLSX", "AX")
+	case code.OpI32Load8U:
+		a.load(x, "MOVBLZX", "AX")
+	case code.OpI32Load16S:
+		a.load(x, "MOVWLSX", "AX")
+	case code.OpI32Load16U:
+		a.load(x, "MOVWLZX", "AX")
+	case code.OpI64Load8S:
+		a.load(x, "MOVBQSX", "AX")
+	case code.OpI64Load8U:
+		a.load(x, "MOVBQZX", "AX")
+	case code.OpI64Load16S:
+		a.load(x, "MOVWQSX", "AX")
+	case code.OpI64Load16U:
+		a.load(x, "MOVWQZX", "AX")
+	case code.OpI64Load32S:
+		a.load(x, "MOVLQSX", "AX")
+	case code.OpI64Load32U:
+		a.load(x, "MOVL", "AX")
+
+	case code.OpMemorySize:
+		a.emit("MOVQ m+0(FP), AX")
+		a.emit("MOVQ AX, 0(SP)")
+		a.call(a.m.asmHelper("MemorySize"))
+		a.emit("MOVL 8(SP), AX")
+		a.reserve(16)
+
+	case code.OpI32Eqz, code.OpI64Eqz,
+		code.OpI32Eq, code.OpI32Ne, code.OpI32LtS, code.OpI32LtU, code.OpI32GtS,
+		code.OpI32GtU, code.OpI32LeS, code.OpI32LeU, code.OpI32GeS, code.OpI32GeU,
+		code.OpI64Eq, code.OpI64Ne, code.OpI64LtS, code.OpI64LtU, code.OpI64GtS,
+		code.OpI64GtU, code.OpI64LeS, code.OpI64LeU, code.OpI64GeS, code.OpI64GeU:
+		a.setCondition(a.compare(wax.UseExpression(wax.ValueTypeBool, x)))
+
+	case code.OpF32Eq, code.OpF32Ne, code.OpF32Lt, code.OpF32Gt, code.OpF32Le, code.OpF32Ge:
+		a.floatCompare(x, "UCOMISS")
+	case code.OpF64Eq, code.OpF64Ne, code.OpF64Lt, code.OpF64Gt, code.OpF64Le, code.OpF64Ge:
+		a.floatCompare(x, "UCOMISD")
+
+	case code.OpI32Clz, code.OpI64Clz:
+		// BSR leaves its destination unchanged and sets ZF if its source is zero.
+		s, bits := suffix(x.Uses[0].Type), 31
+		if s == "Q" {
+			bits = 63
+		}
+		a.eval(x.Uses[0])
+		a.emit("MOV%s $-1, DX", s)
+		a.emit("BSR%s AX, AX", s)
+		a.emit("CMOV%sEQ DX, AX", s)
+		a.emit("NEG%s AX", s)
+		a.emit("ADD%s $%d, AX", s, bits)
+	case code.OpI32Ctz, code.OpI64Ctz:
+		s, bits := suffix(x.Uses[0].Type), 32
+		if s == "Q" {
+			bits = 64
+		}
+		a.eval(x.Uses[0])
+		a.emit("MOV%s $%d, DX", s, bits)
+		a.emit("BSF%s AX, AX", s)
+		a.emit("CMOV%sEQ DX, AX", s)
+
+	case code.OpI32Add, code.OpI64Add:
+		a.binary(x, "ADD"+suffix(t))
+	case code.OpI32Sub, code.OpI64Sub:
+		a.binary(x, "SUB"+suffix(t))
+	case code.OpI32Mul, code.OpI64Mul:
+		a.binary(x, "IMUL"+suffix(t))
+	case code.OpI32And, code.OpI64And:
+		a.binary(x, "AND"+suffix(t))
+	case code.OpI32Or, code.OpI64Or:
+		a.binary(x, "OR"+suffix(t))
+	case code.OpI32Xor, code.OpI64Xor:
+		a.binary(x, "XOR"+suffix(t))
+	case code.OpI32Shl, code.OpI64Shl:
+		// Shift counts are masked by the processor.
+		a.binary(x, "SHL"+suffix(t))
+	case code.OpI32ShrS, code.OpI64ShrS:
+		a.binary(x, "SAR"+suffix(t))
+	case code.OpI32ShrU, code.OpI64ShrU:
+		a.binary(x, "SHR"+suffix(t))
+	case code.OpI32Rotl, code.OpI64Rotl:
+		a.binary(x, "ROL"+suffix(t))
+	case code.OpI32Rotr, code.OpI64Rotr:
+		a.binary(x, "ROR"+suffix(t))
+	case code.OpI32DivS, code.OpI64DivS:
+		a.divide(x, t, true, false)
+	case code.OpI32DivU, code.OpI64DivU:
+		a.divide(x, t, false, false)
+	case code.OpI32RemS, code.OpI64RemS:
+		a.divide(x, t, true, true)
+	case code.OpI32RemU, code.OpI64RemU:
+		a.divide(x, t, false, true)
+
+	case code.OpF32Add:
+		a.binary(x, "ADDSS")
+	case code.OpF32Sub:
+		a.binary(x, "SUBSS")
+	case code.OpF32Mul:
+		a.binary(x, "MULSS")
+	case code.OpF32Div:
+		a.binary(x, "DIVSS")
+	case code.OpF64Add:
+		a.binary(x, "ADDSD")
+	case code.OpF64Sub:
+		a.binary(x, "SUBSD")
+	case code.OpF64Mul:
+		a.binary(x, "MULSD")
+	case code.OpF64Div:
+		a.binary(x, "DIVSD")
+	case code.OpF32Sqrt:
+		a.eval(x.Uses[0])
+		a.emit("SQRTSS X0, X0")
+	case code.OpF64Sqrt:
+		a.eval(x.Uses[0])
+		a.emit("SQRTSD X0, X0")
+
+	// The sign of a floating point value is manipulated in the integer registers so that NaNs are preserved.
+	case code.OpF32Abs:
+		a.eval(x.Uses[0])
+		a.emit("MOVL X0, AX")
+		a.emit("ANDL $0x7fffffff, AX")
+		a.emit("MOVL AX, X0")
+	case code.OpF32Neg:
+		a.eval(x.Uses[0])
+		a.emit("MOVL X0, AX")
+		a.emit("XORL $-2147483648, AX")
+		a.emit("MOVL AX, X0")
+	case code.OpF32Copysign:
+		a.evalPair(x.Uses[0], x.Uses[1])
+		a.emit("MOVL X0, AX")
+		a.emit("MOVL X1, CX")
+		a.emit("ANDL $0x7fffffff, AX")
+		a.emit("ANDL $-2147483648, CX")
+		a.emit("ORL CX, AX")
+		a.emit("MOVL AX, X0")
+	case code.OpF64Abs:
+		a.eval(x.Uses[0])
+		a.emit("MOVQ X0, AX")
+		a.emit("BTRQ $63, AX")
+		a.emit("MOVQ AX, X0")
+	case code.OpF64Neg:
+		a.eval(x.Uses[0])
+		a.emit("MOVQ X0, AX")
+		a.emit("BTCQ $63, AX")
+		a.emit("MOVQ AX, X0")
+	case code.OpF64Copysign:
+		a.evalPair(x.Uses[0], x.Uses[1])
+		a.emit("MOVQ X0, AX")
+		a.emit("MOVQ X1, CX")
+		a.emit("BTRQ $63, AX")
+		a.emit("SHRQ $63, CX")
+		a.emit("SHLQ $63, CX")
+		a.emit("ORQ CX, AX")
+		a.emit("MOVQ AX, X0")
+
+	case code.OpI32WrapI64:
+		a.eval(x.Uses[0])
+	case code.OpI64ExtendI32S:
+		a.eval(x.Uses[0])
+		a.emit("MOVLQSX AX, AX")
+	case code.OpI64ExtendI32U:
+		a.eval(x.Uses[0])
+		a.emit("MOVL AX, AX")
+
+	case code.OpF32ConvertI32S:
+		a.eval(x.Uses[0])
+		a.emit("CVTSL2SS AX, X0")
+	case code.OpF32ConvertI32U:
+		a.eval(x.Uses[0])
+		a.emit("MOVL AX, AX")
+		a.emit("CVTSQ2SS AX, X0")
+	case code.OpF32ConvertI64S:
+		a.eval(x.Uses[0])
+		a.emit("CVTSQ2SS AX, X0")
+	case code.OpF32DemoteF64:
+		a.eval(x.Uses[0])
+		a.emit("CVTSD2SS X0, X0")
+	case code.OpF64ConvertI32S:
+		a.eval(x.Uses[0])
+		a.emit("CVTSL2SD AX, X0")
+	case code.OpF64ConvertI32U:
+		a.eval(x.Uses[0])
+		a.emit("MOVL AX, AX")
+		a.emit("CVTSQ2SD AX, X0")
+	case code.OpF64ConvertI64S:
+		a.eval(x.Uses[0])
+		a.emit("CVTSQ2SD AX, X0")
+	case code.OpF64PromoteF32:
+		a.eval(x.Uses[0])
+		a.emit("CVTSS2SD X0, X0")
+
+	case code.OpI32ReinterpretF32:
+		a.eval(x.Uses[0])
+		a.emit("MOVL X0, AX")
+	case code.OpI64ReinterpretF64:
+		a.eval(x.Uses[0])
+		a.emit("MOVQ X0, AX")
+	case code.OpF32ReinterpretI32:
+		a.eval(x.Uses[0])
+		a.emit("MOVL AX, X0")
+	case code.OpF64ReinterpretI64:
+		a.eval(x.Uses[0])
+		a.emit("MOVQ AX, X0")
+
+	case code.OpI32Extend8S:
+		a.eval(x.Uses[0])
+		a.emit("MOVBLSX AX, AX")
+	case code.OpI32Extend16S:
+		a.eval(x.Uses[0])
+		a.emit("MOVWLSX AX, AX")
+	case code.OpI64Extend8S:
+		a.eval(x.Uses[0])
+		a.emit("MOVBQSX AX, AX")
+	case code.OpI64Extend16S:
+		a.eval(x.Uses[0])
+		a.emit("MOVWQSX AX, AX")
+	case code.OpI64Extend32S:
+		a.eval(x.Uses[0])
+		a.emit("MOVLQSX AX, AX")
+
+	case code.OpPrefix:
+		a.callOp(asmPrefix|x.Instr.Immediate, t, x.Uses[0])
+
+	default:
+		for _, op := range asmOps {
+			if op.op == uint64(x.Instr.Opcode) {
+				a.callOp(op.op, t, x.Uses...)
+				return
+			}
+		}
+		panic(fmt.Errorf("unexpected instruction %#v", x.Instr))
+	}
+}
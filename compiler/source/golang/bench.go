@@ -16,6 +16,12 @@ import (
 	"github.com/pgavlin/warp/wasm"
 )
 
+// optimize enables all of the compiler's optimization passes. It is set by passing -optimize as a leading argument.
+var optimize bool
+
+// inline enables inlining with the default threshold. It is set by passing -inline as a leading argument.
+var inline bool
+
 type benchmark struct {
 	name     string
 	modules  map[string]*wasm.Module
@@ -39,11 +45,24 @@ func (b *benchmark) compileModule(root, name string, module *wasm.Module) error
 	defer mt.Close()
 
 	return golang.CompileModule(mt, "bench", name, module, &golang.Options{
-		UseRawPointers:    useRawPointers(),
-		NoInternalThreads: true,
+		UseRawPointers:           useRawPointers(),
+		NoInternalThreads:        true,
+		ConstantPropagation:      optimize,
+		CopyPropagation:          optimize,
+		DeadCodeElimination:      optimize,
+		RedundantLoadElimination: optimize,
+		BoundsCheckElimination:   optimize,
+		InlineThreshold:          inlineThreshold(),
 	})
 }
 
+func inlineThreshold() int {
+	if inline {
+		return golang.DefaultInlineThreshold
+	}
+	return 0
+}
+
 func (b *benchmark) compile(root string) error {
 	for name, mod := range b.modules {
 		if err := b.compileModule(root, name, mod); err != nil {
@@ -66,6 +85,19 @@ func (b *benchmark) compile(root string) error {
 }
 
 func main() {
+args:
+	for len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "-optimize":
+			optimize = true
+		case "-inline":
+			inline = true
+		default:
+			break args
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// create a directory to hold the compiled code
 	dir, err := os.MkdirTemp("test", "bench")
 	if err != nil {
@@ -98,7 +98,7 @@ func (c *constExpressionCompiler) emitConstExpression(w io.Writer, x *constExpre
 	case code.OpGlobalGet:
 		globalidx := x.instr.Globalidx()
 		if globalidx < uint32(len(c.m.importedGlobals)) || c.m.exportedGlobals[globalidx] {
-			if err := printf(w, "m.g%d", globalidx); err != nil {
+			if err := printf(w, "m.%s%d", c.m.ident("g"), globalidx); err != nil {
 				return nil, err
 			}
 			switch c.m.globalType(globalidx) {
@@ -114,7 +114,7 @@ func (c *constExpressionCompiler) emitConstExpression(w io.Writer, x *constExpre
 				panic("unexpected global type")
 			}
 		}
-		return nil, printf(w, "m.g%d", globalidx)
+		return nil, printf(w, "m.%s%d", c.m.ident("g"), globalidx)
 	case code.OpI32Const:
 		v := int32(x.instr.Immediate)
 		return v, printf(w, "int32(%d)", v)
@@ -0,0 +1,472 @@
+package golang
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/validate"
+)
+
+// DefaultFunctionsPerFile is the number of functions written to each file by CompileModuleDirectory and
+// CompileCommandDirectory if Options.FunctionsPerFile is not set.
+const DefaultFunctionsPerFile = 256
+
+// ErrMissingImportPath indicates that a module's functions cannot be sharded into sub-packages because the import
+// path of the output directory is unknown.
+var ErrMissingImportPath = errors.New("sharding functions into packages requires an import path")
+
+// An OutputDirectory receives the files written by CompileModuleDirectory and CompileCommandDirectory.
+type OutputDirectory interface {
+	// Create creates the file with the given slash-separated path. The path is relative to the root of the
+	// directory.
+	Create(path string) (io.WriteCloser, error)
+}
+
+type localDirectory string
+
+type localFile struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func (f *localFile) Close() error {
+	err := f.Flush()
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d localDirectory) Open(p string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(p)))
+}
+
+func (d localDirectory) Remove(p string) error {
+	return os.Remove(filepath.Join(string(d), filepath.FromSlash(p)))
+}
+
+func (d localDirectory) Create(p string) (io.WriteCloser, error) {
+	p = filepath.Join(string(d), filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{Writer: bufio.NewWriter(f), f: f}, nil
+}
+
+// Directory returns an OutputDirectory that writes files to the given directory in the local filesystem.
+// Subdirectories are created as necessary. The result is an IncrementalDirectory.
+func Directory(path string) OutputDirectory {
+	return localDirectory(path)
+}
+
+type formattedDirectory struct {
+	dir OutputDirectory
+}
+
+type formattedFile struct {
+	formatter
+	f io.WriteCloser
+}
+
+func (f *formattedFile) Close() error {
+	err := f.flush()
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d formattedDirectory) Create(path string) (io.WriteCloser, error) {
+	f, err := d.dir.Create(path)
+	if err != nil || !strings.HasSuffix(path, ".go") {
+		return f, err
+	}
+	return &formattedFile{formatter: formatter{w: f}, f: f}, nil
+}
+
+type formattedIncrementalDirectory struct {
+	formattedDirectory
+	dir IncrementalDirectory
+}
+
+func (d formattedIncrementalDirectory) Open(path string) (io.ReadCloser, error) {
+	return d.dir.Open(path)
+}
+
+func (d formattedIncrementalDirectory) Remove(path string) error {
+	return d.dir.Remove(path)
+}
+
+// FormatDirectory returns an OutputDirectory that formats the Go source code in each .go file prior to emitting it. If
+// the given directory is an IncrementalDirectory, so is the result.
+func FormatDirectory(dir OutputDirectory) OutputDirectory {
+	if incremental, ok := dir.(IncrementalDirectory); ok {
+		return formattedIncrementalDirectory{formattedDirectory: formattedDirectory{dir: dir}, dir: incremental}
+	}
+	return formattedDirectory{dir: dir}
+}
+
+// CompileModuleDirectory compiles the given module into Go source code and writes the source to a set of files in
+// the given directory. The module's declarations are written to module.go, and its functions are split across
+// files of at most Options.FunctionsPerFile functions each. The files are part of the named package, which will
+// contain an exec.ModuleDefinition with the exported version of the given name.
+//
+// If Options.FilesPerPackage is set, the module's functions are instead sharded into sub-packages of the
+// internal directory, and the module's declarations are written to the sub-package internal/shared. In this mode,
+// Options.ImportPath must be the import path of the directory.
+func CompileModuleDirectory(dir OutputDirectory, packageName, name string, module *wasm.Module, options *Options) error {
+	if err := validate.ValidateModule(module, true); err != nil {
+		return err
+	}
+
+	name = identName(name)
+
+	compiler := moduleCompiler{
+		packageName:  packageName,
+		name:         unexportName(name),
+		exportedName: exportName(name),
+		module:       module,
+	}
+	options.apply(&compiler)
+
+	return compiler.compileDirectory(dir)
+}
+
+// CompileCommandDirectory compiles the given WASI or Go module into Go source code and writes the source to a set
+// of files in the given directory. The files are part of package main, which will contain a main function. See
+// CompileCommand and CompileModuleDirectory for details.
+func CompileCommandDirectory(dir OutputDirectory, name string, module *wasm.Module, options *Options) error {
+	if err := validate.ValidateModule(module, true); err != nil {
+		return err
+	}
+
+	compiler := moduleCompiler{
+		isCommand:    true,
+		packageName:  "main",
+		name:         unexportName(name),
+		exportedName: exportName(name),
+		module:       module,
+	}
+	options.apply(&compiler)
+
+	return compiler.compileDirectory(dir)
+}
+
+func (m *moduleCompiler) compileDirectory(dir OutputDirectory) error {
+	if m.filesPerPackage > 0 {
+		if m.importPath == "" {
+			return ErrMissingImportPath
+		}
+
+		// The module's declarations are referenced from other packages, and must be exported.
+		m.sharded, m.name = true, exportName(m.name)
+	}
+
+	if c := m.incremental; c != nil {
+		incremental, ok := dir.(IncrementalDirectory)
+		if !ok {
+			return ErrNotIncremental
+		}
+		if m.functionsPerFile != 1 {
+			return ErrIncrementalFunctionsPerFile
+		}
+		c.dir = incremental
+		c.readPrevious()
+
+		dir = recordingDirectory{OutputDirectory: dir, files: &c.files}
+	}
+
+	if err := m.compile(); err != nil {
+		return err
+	}
+	if m.sharded && len(m.asmFunctions) != 0 {
+		return ErrShardedAssembly
+	}
+	m.asmOutput = true
+	if err := m.emitDirectory(dir); err != nil {
+		return err
+	}
+	if m.incremental != nil {
+		return m.finishIncremental()
+	}
+	return nil
+}
+
+// functionFile returns the index of the file that contains the given function, or -1 if the function is an import.
+func (m *moduleCompiler) functionFile(funcidx uint32) int {
+	if funcidx < uint32(len(m.importedFunctions)) {
+		return -1
+	}
+	return (int(funcidx) - len(m.importedFunctions)) / m.functionsPerFile
+}
+
+// functionPackage returns the index of the package that contains the given function, or -1 if the function is
+// declared alongside the module.
+func (m *moduleCompiler) functionPackage(funcidx uint32) int {
+	file := m.functionFile(funcidx)
+	if file == -1 || !m.sharded {
+		return -1
+	}
+	return file / m.filesPerPackage
+}
+
+// functionFilePath returns the path of the file with the given index. The files of an incremental compilation contain
+// a single function each, and are named for the function's hash.
+func (m *moduleCompiler) functionFilePath(file int) string {
+	name := fmt.Sprintf("functions_%d.go", file)
+	if c := m.incremental; c != nil && c.hashes[file] != "" {
+		name = fmt.Sprintf("functions_%d_%s.go", file, c.hashes[file][:16])
+	}
+	if m.sharded {
+		return fmt.Sprintf("internal/functions%d/%s", file/m.filesPerPackage, name)
+	}
+	return name
+}
+
+// skipFile returns true if the file with the given index is unchanged since the previous incremental compilation.
+func (m *moduleCompiler) skipFile(file int) bool {
+	return m.incremental != nil && m.incremental.unchanged[file]
+}
+
+// createFile creates the file with the given path and calls emit to write its contents.
+func createFile(dir OutputDirectory, path string, emit func(w io.Writer) error) (err error) {
+	f, err := dir.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return emit(f)
+}
+
+func (m *moduleCompiler) emitDirectory(dir OutputDirectory) error {
+	if err := m.checkEntrypoint(); err != nil {
+		return err
+	}
+
+	// Assign functions to files.
+	var files [][]*functionCompiler
+	for i := range m.functions {
+		f := &m.functions[i]
+		file := m.functionFile(uint32(f.index))
+		if file == len(files) {
+			files = append(files, nil)
+		}
+		files[file] = append(files[file], f)
+	}
+
+	if !m.sharded {
+		err := createFile(dir, "module.go", func(w io.Writer) error {
+			if err := m.emitPackage(w); err != nil {
+				return err
+			}
+			if err := m.emitDeclarations(w); err != nil {
+				return err
+			}
+			if err := m.emitImportedFunctions(w); err != nil {
+				return err
+			}
+			if m.isCommand {
+				return m.emitMain(w)
+			}
+			if m.isPlugin {
+				return m.emitPluginSymbols(w)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, functions := range files {
+			if m.skipFile(i) {
+				continue
+			}
+			err := createFile(dir, m.functionFilePath(i), func(w io.Writer) error {
+				return m.emitFunctionFile(w, m.packageName, functions)
+			})
+			if err != nil {
+				return err
+			}
+			if m.hasAssembly(functions) {
+				err := createFile(dir, assemblyFilePath(m.functionFilePath(i)), func(w io.Writer) error {
+					return m.emitAssemblyFile(w, functions)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	sharedPath := path.Join(m.importPath, "internal", "shared")
+
+	// Emit the shared declarations.
+	err := createFile(dir, "internal/shared/module.go", func(w io.Writer) error {
+		imports := []string{
+			"math",
+			"math/bits",
+			"unsafe",
+			"github.com/pgavlin/warp/exec",
+			"github.com/pgavlin/warp/wasm",
+		}
+		if err := emitPackageClause(w, "shared", imports); err != nil {
+			return err
+		}
+		if err := m.emitDeclarations(w); err != nil {
+			return err
+		}
+		if err := m.emitImportedFunctions(w); err != nil {
+			return err
+		}
+		return m.emitFunctionVariables(w)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Emit the shards. Each file registers its functions with the shared package when it is initialized. Relative
+	// paths in line directives are relative to the directory that contains the file.
+	m.qualifier, m.lineDirectory = "shared.", "../.."
+	defer func() { m.qualifier, m.lineDirectory = "", "" }()
+
+	var packages []string
+	for i, functions := range files {
+		pkg := i / m.filesPerPackage
+		pkgName := fmt.Sprintf("functions%d", pkg)
+		if pkg == len(packages) {
+			packages = append(packages, path.Join(m.importPath, "internal", pkgName))
+		}
+		if m.skipFile(i) {
+			continue
+		}
+
+		err := createFile(dir, m.functionFilePath(i), func(w io.Writer) error {
+			return m.emitFunctionFile(w, pkgName, functions, sharedPath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Emit the root package, which links the shards.
+	return createFile(dir, "module.go", func(w io.Writer) error {
+		if err := printf(w, "package %s\n\nimport (\n", m.packageName); err != nil {
+			return err
+		}
+		if m.isCommand {
+			if err := printf(w, "\t%q\n", m.runtimePackage()); err != nil {
+				return err
+			}
+		}
+		if m.isPlugin {
+			if err := printf(w, "\t%q\n", "github.com/pgavlin/warp/exec"); err != nil {
+				return err
+			}
+		}
+		if err := printf(w, "\t%q\n", sharedPath); err != nil {
+			return err
+		}
+		for _, pkg := range packages {
+			if err := printf(w, "\t_ %q\n", pkg); err != nil {
+				return err
+			}
+		}
+		if err := printf(w, ")\n\nvar %s = shared.%[1]s\n", m.exportedName); err != nil {
+			return err
+		}
+		if m.typedAPI {
+			err := printf(w, "\ntype %sImports = shared.%[1]sImports\n\ntype %[1]sModule = shared.%[1]sModule\n\nvar New%[1]sModule = shared.New%[1]sModule\n", m.exportedName)
+			if err != nil {
+				return err
+			}
+		}
+		if m.isCommand {
+			return m.emitMain(w)
+		}
+		if m.isPlugin {
+			return m.emitPluginSymbols(w)
+		}
+		return nil
+	})
+}
+
+// emitFunctionFile emits a file in the given package that contains the given functions.
+func (m *moduleCompiler) emitFunctionFile(w io.Writer, packageName string, functions []*functionCompiler, imports ...string) error {
+	imports = append([]string{
+		"math",
+		"math/bits",
+		"unsafe",
+		"github.com/pgavlin/warp/exec",
+	}, imports...)
+	if err := emitPackageClause(w, packageName, imports); err != nil {
+		return err
+	}
+
+	err := printf(w, `
+var _ = math.MaxInt32
+var _ = bits.UintSize
+var _ = unsafe.Pointer(uintptr(0))
+var _ exec.Function
+
+`)
+	if err != nil {
+		return err
+	}
+
+	// Register the functions of a shard with the shared package when the shard is initialized.
+	if m.sharded {
+		if err := printf(w, "func init() {\n"); err != nil {
+			return err
+		}
+		for _, f := range functions {
+			name := m.functionName(uint32(f.index))
+			if err := printf(w, "\tshared.%s = %s\n", name, name); err != nil {
+				return err
+			}
+		}
+		if err := printf(w, "}\n\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range functions {
+		if err := f.emit(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitFunctionVariables emits the variables through which sharded functions are referenced from the module's
+// declarations and from other shards.
+func (m *moduleCompiler) emitFunctionVariables(w io.Writer) error {
+	for _, f := range m.functions {
+		if err := printf(w, "var %s func", m.functionName(uint32(f.index))); err != nil {
+			return err
+		}
+		if err := m.emitFunctionSignature(w, f.Signature, false); err != nil {
+			return err
+		}
+		if err := printf(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
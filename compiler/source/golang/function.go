@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/pgavlin/warp/compiler/wax"
 	"github.com/pgavlin/warp/wasm"
@@ -38,7 +39,7 @@ func goType(t wasm.ValueType) string {
 }
 
 func (m *moduleCompiler) emitFunctionSignature(w io.Writer, sig wasm.FunctionSig, indirect bool) error {
-	if err := printf(w, "(m *%sInstance", m.name); err != nil {
+	if err := printf(w, "(m *%s%sInstance", m.qualifier, m.name); err != nil {
 		return err
 	}
 	if !m.noInternalThreads {
@@ -87,6 +88,9 @@ type functionCompiler struct {
 
 	m     *moduleCompiler
 	index int
+
+	// asm holds the function's assembly, if it is compiled to assembly.
+	asm []byte
 }
 
 func (f *functionCompiler) compile(m *moduleCompiler, index int, typeIndex uint32, signature wasm.FunctionSig, body wasm.FunctionBody) {
@@ -103,21 +107,39 @@ func (f *functionCompiler) compile(m *moduleCompiler, index int, typeIndex uint3
 
 	// Compile the function body into expression trees.
 	for ip, instr := range codeBody.Instructions {
+		if instr.Opcode == code.OpCall && !f.Unreachable() {
+			if callee, ok := m.inlineFunctions[instr.Funcidx()]; ok {
+				f.ImportInlinedCall(ip, callee, s)
+				continue
+			}
+		}
 		f.ImportInstruction(ip, instr, s)
 	}
 
+	// Implicit returns are attributed to the function's final end instruction.
+	end := len(codeBody.Instructions) - 1
+
 	hasReturn := false
 	if len(f.Stack) > 0 {
-		f.ImportInstruction(0, code.Return(), s)
+		f.ImportInstruction(end, code.Return(), s)
 		hasReturn = true
 	}
 	f.FinishImport()
 
 	if !hasReturn && m.noInternalThreads {
 		// We need a terminal return for Leave().
-		f.Body = append(f.Body, &wax.Def{Expression: &wax.Expression{Function: &f.Function, Instr: code.Return()}})
+		f.Body = append(f.Body, &wax.Def{Expression: &wax.Expression{Function: &f.Function, IP: end, Instr: code.Return()}})
+	}
+
+	if m.asmFunctions[uint32(index)] {
+		// Compiled assembly does not perform bounds checks.
+		f.Optimize(m.passes &^ wax.PassBoundsCheckElimination)
+		f.asm = f.compileAssembly()
+		return
 	}
 
+	f.Optimize(m.passes)
+
 	for _, d := range f.Body {
 		markUntypedExpressions(d.Expression)
 	}
@@ -264,6 +286,19 @@ func (f *functionCompiler) FormatDef(fs fmt.State, verb rune, d *wax.Def) {
 }
 
 func (f *functionCompiler) emit(w io.Writer) error {
+	// If line directives are enabled, precede each line with the source location of the code it implements.
+	var header sourceLocation
+	var lines []sourceLocation
+	var lw *lineWriter
+	if f.m.lineDirectives {
+		var err error
+		if header, lines, err = f.m.functionLines(uint32(f.index)); err != nil {
+			return err
+		}
+		lw = newLineWriter(w, header)
+		w = lw
+	}
+
 	// Emit the function signature.
 	if err := printf(w, "func %s", f.m.functionName(uint32(f.index))); err != nil {
 		return err
@@ -271,6 +306,10 @@ func (f *functionCompiler) emit(w io.Writer) error {
 	if err := f.m.emitFunctionSignature(w, f.Signature, false); err != nil {
 		return err
 	}
+	if f.m.asmFunctions[uint32(f.index)] {
+		// The function's body is implemented in assembly.
+		return printf(w, "\n")
+	}
 	if err := printf(w, "{\n"); err != nil {
 		return err
 	}
@@ -290,14 +329,35 @@ func (f *functionCompiler) emit(w io.Writer) error {
 	}
 
 	for _, x := range f.Body {
+		if lw != nil {
+			// The function's implicit outermost block precedes its first instruction.
+			switch {
+			case x.IP < 0:
+				lw.seek(header)
+			case x.IP < len(lines):
+				lw.seek(lines[x.IP])
+			}
+		}
 		if err := f.emitDef(w, x); err != nil {
 			return err
 		}
 	}
 
+	if lw != nil && len(lines) != 0 {
+		lw.seek(lines[len(lines)-1])
+	}
 	return printf(w, "}\n")
 }
 
+// calleeName returns the name used to call the given function from the function being compiled.
+func (f *functionCompiler) calleeName(funcidx uint32) string {
+	name := f.m.functionName(funcidx)
+	if f.m.sharded && f.m.functionPackage(funcidx) != f.m.functionPackage(uint32(f.index)) {
+		return f.m.qualifier + name
+	}
+	return name
+}
+
 func (m *moduleCompiler) emitImportedFunction(w io.Writer, index uint32, sig wasm.FunctionSig) error {
 	// Emit the function signature.
 	if err := printf(w, "func %s", m.functionName(index)); err != nil {
@@ -310,6 +370,15 @@ func (m *moduleCompiler) emitImportedFunction(w io.Writer, index uint32, sig was
 		return err
 	}
 
+	if m.typedAPI {
+		if err := m.emitTypedImportCall(w, index, sig); err != nil {
+			return err
+		}
+	}
+	if err := m.emitLinkedCall(w, index, sig); err != nil {
+		return err
+	}
+
 	threadArg := "t"
 	if m.noInternalThreads {
 		if err := printf(w, "\tt := exec.NewThread(0)\n"); err != nil {
@@ -465,45 +534,64 @@ func (f *functionCompiler) emitBlockOuts(w io.Writer, b *wax.Block, uses wax.Use
 	return f.emitAssignTemps(w, b.OutTemp, uses)
 }
 
+// memory returns the expressions that refer to the function's memory and to the start of that memory.
+func (f *functionCompiler) memory() (string, string) {
+	return "m." + f.m.ident("mem0"), "m." + f.m.ident("mem")
+}
+
 func (f *functionCompiler) load(x *wax.Expression, loadWidth int) string {
+	mem0, mem := f.memory()
+	if x.Flags&wax.FlagsInBounds != 0 && !f.m.useRawPointers {
+		return fmt.Sprintf("%s.UncheckedUint%v(uint32(%4U), %d)", mem0, loadWidth, x.Uses[0], x.Instr.Offset())
+	}
+
 	switch {
 	case x.Instr.Offset() == 0:
 		if f.m.useRawPointers {
-			return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(m.mem + uintptr(uint32(%4U))))", loadWidth, x.Uses[0])
+			return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(%s + uintptr(uint32(%4U))))", loadWidth, mem, x.Uses[0])
 		}
-		return fmt.Sprintf("m.mem0.Uint%vAt(uint32(%4U))", loadWidth, x.Uses[0])
+		return fmt.Sprintf("%s.Uint%vAt(uint32(%4U))", mem0, loadWidth, x.Uses[0])
 	case isConst0(x.Uses[0]):
 		if f.m.useRawPointers {
-			return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(m.mem + %d))", loadWidth, x.Instr.Offset())
+			return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(%s + %d))", loadWidth, mem, x.Instr.Offset())
 		}
-		return fmt.Sprintf("m.mem0.Uint%vAt(%d)", loadWidth, x.Instr.Offset())
+		return fmt.Sprintf("%s.Uint%vAt(%d)", mem0, loadWidth, x.Instr.Offset())
 	}
 	if f.m.useRawPointers {
-		return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(m.mem + uintptr(uint32(%4U)) + %d))", loadWidth, x.Uses[0], x.Instr.Offset())
+		return fmt.Sprintf("*(*uint%v)(unsafe.Pointer(%s + uintptr(uint32(%4U)) + %d))", loadWidth, mem, x.Uses[0], x.Instr.Offset())
 	}
-	return fmt.Sprintf("m.mem0.Uint%v(uint32(%4U), %d)", loadWidth, x.Uses[0], x.Instr.Offset())
+	return fmt.Sprintf("%s.Uint%v(uint32(%4U), %d)", mem0, loadWidth, x.Uses[0], x.Instr.Offset())
 }
 
 func (f *functionCompiler) emitStore(w io.Writer, x *wax.Def, storeWidth int, value string) error {
+	mem0, mem := f.memory()
+	if x.Flags&wax.FlagsInBounds != 0 && !f.m.useRawPointers {
+		return printf(w, "%s.UncheckedPutUint%v(%s, uint32(%4U), %d)\n", mem0, storeWidth, value, x.Uses[0], x.Instr.Offset())
+	}
+
 	switch {
 	case x.Instr.Offset() == 0:
 		if f.m.useRawPointers {
-			return printf(w, "*(*uint%v)(unsafe.Pointer(m.mem + uintptr(uint32(%4U)))) = %s\n", storeWidth, x.Uses[0], value)
+			return printf(w, "*(*uint%v)(unsafe.Pointer(%s + uintptr(uint32(%4U)))) = %s\n", storeWidth, mem, x.Uses[0], value)
 		}
-		return printf(w, "m.mem0.PutUint%vAt(%s, uint32(%4U))\n", storeWidth, value, x.Uses[0])
+		return printf(w, "%s.PutUint%vAt(%s, uint32(%4U))\n", mem0, storeWidth, value, x.Uses[0])
 	case isConst0(x.Uses[0]):
 		if f.m.useRawPointers {
-			return printf(w, "*(*uint%v)(unsafe.Pointer(m.mem + %d)) = %s\n", storeWidth, x.Instr.Offset(), value)
+			return printf(w, "*(*uint%v)(unsafe.Pointer(%s + %d)) = %s\n", storeWidth, mem, x.Instr.Offset(), value)
 		}
-		return printf(w, "m.mem0.PutUint%vAt(%s, %d)\n", storeWidth, value, x.Instr.Offset())
+		return printf(w, "%s.PutUint%vAt(%s, %d)\n", mem0, storeWidth, value, x.Instr.Offset())
 	}
 	if f.m.useRawPointers {
-		return printf(w, "*(*uint%v)(unsafe.Pointer(m.mem + uintptr(uint32(%4U)) + %d)) = %s\n", storeWidth, x.Uses[0], x.Instr.Offset(), value)
+		return printf(w, "*(*uint%v)(unsafe.Pointer(%s + uintptr(uint32(%4U)) + %d)) = %s\n", storeWidth, mem, x.Uses[0], x.Instr.Offset(), value)
 	}
-	return printf(w, "m.mem0.PutUint%v(%s, uint32(%4U), %d)\n", storeWidth, value, x.Uses[0], x.Instr.Offset())
+	return printf(w, "%s.PutUint%v(%s, uint32(%4U), %d)\n", mem0, storeWidth, value, x.Uses[0], x.Instr.Offset())
 }
 
 func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
+	if x.IsPseudo() {
+		return f.emitPseudoDef(w, x)
+	}
+
 	switch x.Instr.Opcode {
 	case code.OpUnreachable:
 		return printf(w, "panic(exec.TrapUnreachable)\n")
@@ -612,7 +700,7 @@ func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
 			threadArg = ", t"
 		}
 
-		return printf(w, "%s(m%s%s%u)\n", f.m.functionName(x.Instr.Funcidx()), threadArg, comma(len(x.Uses)), x.Uses)
+		return printf(w, "%s(m%s%s%u)\n", f.calleeName(x.Instr.Funcidx()), threadArg, comma(len(x.Uses)), x.Uses)
 
 	case code.OpCallIndirect:
 		typeidx := x.Instr.Typeidx()
@@ -635,7 +723,7 @@ func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
 		}
 		tableidx := x.Uses[len(x.Uses)-1]
 		uses := x.Uses[:len(x.Uses)-1]
-		return printf(w, "%sCallIndirect(m%s, uint32(%4U)%v%u)\n", f.m.functionTypeName(sig), threadArg, tableidx, comma(len(uses)), uses)
+		return printf(w, "%s%sCallIndirect(m%s, uint32(%4U)%v%u)\n", f.m.qualifier, f.m.functionTypeName(sig), threadArg, tableidx, comma(len(uses)), uses)
 
 	case code.OpReturn:
 		if len(x.Uses) > 0 {
@@ -678,7 +766,7 @@ func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
 	case code.OpGlobalSet:
 		globalidx := x.Instr.Globalidx()
 		if globalidx < uint32(len(f.m.importedGlobals)) || f.m.exportedGlobals[globalidx] {
-			if err := printf(w, "m.g%d", globalidx); err != nil {
+			if err := printf(w, "m.%s%d", f.m.ident("g"), globalidx); err != nil {
 				return err
 			}
 			switch f.m.globalType(globalidx) {
@@ -694,7 +782,7 @@ func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
 				panic("unexpected global type")
 			}
 		}
-		return printf(w, "m.g%d = %u\n", globalidx, x.Uses[0])
+		return printf(w, "m.%s%d = %u\n", f.m.ident("g"), globalidx, x.Uses[0])
 
 	case code.OpI32Store:
 		return f.emitStore(w, x, 32, fmt.Sprintf("uint32(%4U)", x.Uses[1]))
@@ -718,7 +806,7 @@ func (f *functionCompiler) emitDef(w io.Writer, x *wax.Def) error {
 		return f.emitStore(w, x, 32, fmt.Sprintf("uint32(%4U)", x.Uses[1]))
 
 	case code.OpMemoryGrow:
-		return printf(w, "var t%d int32\nif sz, err := m.mem0.Grow(uint32(%4U)); err != nil {\nt%d = -1\n} else {\nt%d = int32(sz)\n}\n", x.Temp, x.Uses[0], x.Temp, x.Temp)
+		return printf(w, "var t%d int32\nif sz, err := m.%s.Grow(uint32(%4U)); err != nil {\nt%d = -1\n} else {\nt%d = int32(sz)\n}\n", x.Temp, f.m.ident("mem0"), x.Uses[0], x.Temp, x.Temp)
 
 	default:
 		return printf(w, "t%d := %d\n", x.Temp, wax.UseExpression(x.Types[0], x.Expression))
@@ -737,7 +825,7 @@ func (f *functionCompiler) emitExpression(w io.Writer, x *wax.Expression, parent
 	case code.OpGlobalGet:
 		globalidx := x.Instr.Globalidx()
 		if globalidx < uint32(len(f.m.importedGlobals)) || f.m.exportedGlobals[globalidx] {
-			if err := printf(w, "m.g%d", globalidx); err != nil {
+			if err := printf(w, "m.%s%d", f.m.ident("g"), globalidx); err != nil {
 				return err
 			}
 			switch f.m.globalType(globalidx) {
@@ -753,7 +841,7 @@ func (f *functionCompiler) emitExpression(w io.Writer, x *wax.Expression, parent
 				panic("unexpected global type")
 			}
 		}
-		return printf(w, "m.g%d", globalidx)
+		return printf(w, "m.%s%d", f.m.ident("g"), globalidx)
 
 	case code.OpI32Load:
 		return printf(w, "int32(%s)", f.load(x, 32))
@@ -787,7 +875,7 @@ func (f *functionCompiler) emitExpression(w io.Writer, x *wax.Expression, parent
 		return printf(w, "int64(%s)", f.load(x, 32))
 
 	case code.OpMemorySize:
-		return printf(w, "int32(m.mem0.Size())")
+		return printf(w, "int32(m.%s.Size())", f.m.ident("mem0"))
 
 	case code.OpI32Const:
 		return printf(w, "%d", x.Instr.I32())
@@ -1098,6 +1186,25 @@ func (f *functionCompiler) emitExpression(w io.Writer, x *wax.Expression, parent
 	panic(fmt.Errorf("unexpected instruction %#v", x.Instr))
 }
 
+func (f *functionCompiler) emitPseudoDef(w io.Writer, x *wax.Def) error {
+	switch x.Instr.Opcode {
+	case wax.PseudoEnter:
+		if !f.m.noInternalThreads {
+			return printf(w, "t.Enter()\n")
+		}
+		return nil
+	case wax.PseudoLeave:
+		if !f.m.noInternalThreads {
+			return printf(w, "t.Leave()\n")
+		}
+		return nil
+	case wax.PseudoBoundsCheck:
+		return printf(w, "m.%s.Check(uint32(%4U), %d)\n", f.m.ident("mem0"), x.Uses[0], x.Instr.Immediate)
+	}
+
+	panic(fmt.Errorf("unexpected pseudo instruction %#v", x.Instr))
+}
+
 func (f *functionCompiler) emitPseudoOp(w io.Writer, x *wax.Expression, parentPrecedence int) error {
 	switch x.Instr.Opcode {
 	case wax.PseudoBoolConst:
@@ -1106,7 +1213,16 @@ func (f *functionCompiler) emitPseudoOp(w io.Writer, x *wax.Expression, parentPr
 		}
 		return printf(w, "false")
 	case wax.PseudoI32ConvertBool:
-		return printf(w, "m.i32Bool(%u)", x.Uses[0])
+		return printf(w, "m.%s(%u)", f.m.ident("i32Bool"), x.Uses[0])
+	case wax.PseudoInBounds:
+		return printf(w, "m.%s.InBounds(uint64(%8U))", f.m.ident("mem0"), x.Uses[0])
+	case wax.PseudoAnd:
+		fmtStr := strings.Repeat(" && %.2u", len(x.Uses))[len(" && "):]
+		args := make([]interface{}, len(x.Uses))
+		for i, u := range x.Uses {
+			args[i] = u
+		}
+		return printBinaryExpression(w, 2, parentPrecedence, fmtStr, args...)
 	}
 
 	panic(fmt.Errorf("unexpected pseudo instruction %#v", x.Instr))
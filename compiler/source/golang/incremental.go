@@ -0,0 +1,273 @@
+package golang
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/pgavlin/warp/internal/buildinfo"
+	"github.com/pgavlin/warp/wasm"
+)
+
+// ManifestPath is the path of the manifest written by incremental compilations, relative to the output directory.
+const ManifestPath = "manifest.json"
+
+// ErrNotIncremental indicates that incremental compilation was requested for an output directory that does not
+// implement IncrementalDirectory.
+var ErrNotIncremental = errors.New("incremental compilation requires an IncrementalDirectory")
+
+// ErrIncrementalFunctionsPerFile indicates that incremental compilation was requested with more than one function
+// per file. Incremental compilations write each function to its own file.
+var ErrIncrementalFunctionsPerFile = errors.New("incremental compilation writes one function per file")
+
+// An IncrementalDirectory is an OutputDirectory that supports the reading and removal of files. Incremental
+// compilation reads the manifest of the previous compilation from the directory and removes the files that the
+// previous compilation wrote and the current compilation does not.
+type IncrementalDirectory interface {
+	OutputDirectory
+
+	// Open opens the file with the given slash-separated path for reading. If the file does not exist, the error
+	// satisfies errors.Is(err, fs.ErrNotExist).
+	Open(path string) (io.ReadCloser, error)
+	// Remove removes the file with the given slash-separated path.
+	Remove(path string) error
+}
+
+// A Manifest describes the output of an incremental compilation.
+type Manifest struct {
+	// Context is the hash of the context in which the module's functions were compiled: the version of the
+	// compiler, the compilation options, and the module's sections other than its code. Context is empty if the
+	// module could not be hashed, in which case no function is considered unchanged.
+	Context string `json:"context"`
+	// Functions describes the module's defined functions in index order.
+	Functions []ManifestFunction `json:"functions"`
+	// Files lists the paths of the files that make up the compiled module, excluding the manifest.
+	Files []string `json:"files"`
+}
+
+// A ManifestFunction describes a compiled function.
+type ManifestFunction struct {
+	// Index is the function's index in the module's function index space.
+	Index uint32 `json:"index"`
+	// Name is the name of the function's Go declaration.
+	Name string `json:"name"`
+	// Hash is the hash of the function's body and its context.
+	Hash string `json:"hash"`
+	// File is the path of the file that contains the function.
+	File string `json:"file"`
+}
+
+// ReadManifest reads a manifest written by an incremental compilation.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// incrementalCompilation records the state of an incremental compilation.
+type incrementalCompilation struct {
+	dir      IncrementalDirectory
+	options  string    // The formatted compilation options.
+	previous *Manifest // The manifest of the previous compilation, if any.
+
+	context   string   // The hash of the compilation's context.
+	hashes    []string // The hashes of the module's defined functions.
+	unchanged []bool   // True for each defined function whose file is up to date.
+	files     []string // The paths of the files that were written.
+}
+
+// recordingDirectory records the paths of the files created in an output directory.
+type recordingDirectory struct {
+	OutputDirectory
+	files *[]string
+}
+
+func (d recordingDirectory) Create(path string) (io.WriteCloser, error) {
+	*d.files = append(*d.files, path)
+	return d.OutputDirectory.Create(path)
+}
+
+// readPrevious reads the manifest of the previous compilation. A missing or unreadable manifest is treated as if the
+// directory contained no previous output.
+func (c *incrementalCompilation) readPrevious() {
+	f, err := c.dir.Open(ManifestPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if manifest, err := ReadManifest(f); err == nil {
+		c.previous = manifest
+	}
+}
+
+// exists returns true if the file with the given path exists in the output directory.
+func (c *incrementalCompilation) exists(path string) bool {
+	f, err := c.dir.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// contextHash returns the hash of the context in which the module's functions are compiled. The context includes the
+// bodies of any functions that may be inlined into other functions, and, if line directives are enabled, the bodies
+// of all functions, as the lines of each function in the module's text format depend on those that precede it.
+func (m *moduleCompiler) contextHash(options string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%v %v\n", buildinfo.Version(), options, m.packageName, m.exportedName, m.isCommand, m.isPlugin)
+	for _, s := range moduleSections(m.module) {
+		if s.SectionID() == wasm.SectionIDCode && !m.lineDirectives {
+			continue
+		}
+		fmt.Fprintf(h, "section %d\n", s.SectionID())
+		if err := s.WritePayload(h); err != nil {
+			return ""
+		}
+	}
+
+	inlined := make([]int, 0, len(m.inlineFunctions))
+	for funcidx := range m.inlineFunctions {
+		inlined = append(inlined, int(funcidx))
+	}
+	sort.Ints(inlined)
+	for _, funcidx := range inlined {
+		fmt.Fprintf(h, "inline %d\n", funcidx)
+		hashBody(h, m.module.Code.Bodies[funcidx-len(m.importedFunctions)])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// moduleSections returns the sections of the given module. Modules that were not decoded from the binary format have
+// no list of sections, so their sections are collected from the module's fields.
+func moduleSections(m *wasm.Module) []wasm.Section {
+	if len(m.Sections) != 0 {
+		return m.Sections
+	}
+
+	var sections []wasm.Section
+	add := func(present bool, s wasm.Section) {
+		if present {
+			sections = append(sections, s)
+		}
+	}
+	add(m.Types != nil, m.Types)
+	add(m.Import != nil, m.Import)
+	add(m.Function != nil, m.Function)
+	add(m.Table != nil, m.Table)
+	add(m.Memory != nil, m.Memory)
+	add(m.Global != nil, m.Global)
+	add(m.Export != nil, m.Export)
+	add(m.Start != nil, m.Start)
+	add(m.Elements != nil, m.Elements)
+	add(m.Code != nil, m.Code)
+	add(m.Data != nil, m.Data)
+	for _, c := range m.Customs {
+		sections = append(sections, c)
+	}
+	return sections
+}
+
+func hashBody(w io.Writer, body wasm.FunctionBody) {
+	var buf [binary.MaxVarintLen64]byte
+	for _, l := range body.Locals {
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(l.Count))])
+		w.Write([]byte{byte(l.Type)})
+	}
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(body.Code)))])
+	w.Write(body.Code)
+}
+
+// prepareIncremental hashes the module's functions and determines which of them are unchanged since the previous
+// compilation. Unchanged functions are not compiled, and their files are not rewritten.
+func (m *moduleCompiler) prepareIncremental() {
+	c := m.incremental
+
+	c.context = m.contextHash(c.options)
+	c.hashes = make([]string, len(m.module.Code.Bodies))
+	c.unchanged = make([]bool, len(m.module.Code.Bodies))
+	if c.context == "" {
+		return
+	}
+
+	previous := map[uint32]ManifestFunction{}
+	if c.previous != nil {
+		for _, f := range c.previous.Functions {
+			previous[f.Index] = f
+		}
+	}
+
+	for i, body := range m.module.Code.Bodies {
+		funcidx := uint32(i + len(m.importedFunctions))
+
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\n%d\n", c.context, funcidx)
+		hashBody(h, body)
+		c.hashes[i] = hex.EncodeToString(h.Sum(nil))
+
+		if f, ok := previous[funcidx]; ok && f.Hash == c.hashes[i] && f.File == m.functionFilePath(i) {
+			c.unchanged[i] = c.exists(f.File)
+		}
+	}
+}
+
+// finishIncremental writes the compilation's manifest and removes the files of the previous compilation that are no
+// longer part of the output.
+func (m *moduleCompiler) finishIncremental() error {
+	c := m.incremental
+
+	manifest := Manifest{Context: c.context, Files: c.files}
+	current := map[string]bool{}
+	for _, path := range c.files {
+		current[path] = true
+	}
+	for i, f := range m.functions {
+		path := m.functionFilePath(i)
+		if c.unchanged[i] {
+			manifest.Files, current[path] = append(manifest.Files, path), true
+			if m.asmFunctions[uint32(f.index)] {
+				asmPath := assemblyFilePath(path)
+				manifest.Files, current[asmPath] = append(manifest.Files, asmPath), true
+			}
+		}
+		manifest.Functions = append(manifest.Functions, ManifestFunction{
+			Index: uint32(f.index),
+			Name:  m.functionName(uint32(f.index)),
+			Hash:  c.hashes[i],
+			File:  path,
+		})
+	}
+	sort.Strings(manifest.Files)
+
+	err := createFile(c.dir, ManifestPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(manifest)
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.previous != nil {
+		for _, path := range c.previous.Files {
+			// Only remove files inside the output directory.
+			if current[path] || path == ManifestPath || !fs.ValidPath(path) {
+				continue
+			}
+			if err := c.dir.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
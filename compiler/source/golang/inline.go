@@ -0,0 +1,158 @@
+package golang
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pgavlin/warp/compiler/wax"
+	"github.com/pgavlin/warp/internal/pprof"
+	"github.com/pgavlin/warp/wasm"
+)
+
+// DefaultInlineThreshold is the size threshold used by `warp compile --inline` when no threshold is given.
+const DefaultInlineThreshold = 32
+
+// ReadInlineProfile reads a pprof profile written by interpreter.Profiler and returns the number of calls to each of
+// the given module's functions, by function index. The result is suitable for use as Options.InlineProfile.
+//
+// Functions in the profile are matched to functions in the module by name. A profile function's name may be prefixed
+// by the name of the module that defined it, e.g. "main.foo" or "main.func 12"; the prefix is ignored when matching.
+func ReadInlineProfile(r io.Reader, module *wasm.Module) (map[uint32]int64, error) {
+	profile, err := pprof.Read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	calls := -1
+	for i, t := range profile.SampleType {
+		if t.Type == "calls" {
+			calls = i
+			break
+		}
+	}
+	if calls == -1 {
+		return nil, fmt.Errorf("profile does not record calls")
+	}
+
+	lookup := functionIndices(module)
+
+	functions := map[uint64]uint32{}
+	for _, f := range profile.Function {
+		if index, ok := lookup(f.Name); ok {
+			functions[f.ID] = index
+		}
+	}
+	locations := map[uint64]uint32{}
+	for _, l := range profile.Location {
+		if len(l.Line) != 0 {
+			if index, ok := functions[l.Line[0].Function]; ok {
+				locations[l.ID] = index
+			}
+		}
+	}
+
+	// Each sample records the calls to the innermost function in its stack.
+	counts := map[uint32]int64{}
+	for _, s := range profile.Sample {
+		if len(s.Location) == 0 || calls >= len(s.Value) {
+			continue
+		}
+		if index, ok := locations[s.Location[0]]; ok {
+			counts[index] += s.Value[calls]
+		}
+	}
+	return counts, nil
+}
+
+// functionIndices returns a function that maps the names of a module's functions to their indices. Names from the
+// name section take precedence over export names, which take precedence over names of the form "func N". A name may
+// be prefixed by the name of the module that defined it, e.g. "main.foo" or "main.func 12"; the prefix is ignored
+// when matching.
+func functionIndices(module *wasm.Module) func(name string) (uint32, bool) {
+	indices := map[string]uint32{}
+	functionCount := uint32(0)
+	if module.Function != nil {
+		functionCount = uint32(len(module.Function.Types))
+	}
+	if module.Import != nil {
+		for _, entry := range module.Import.Entries {
+			if _, ok := entry.Type.(wasm.FuncImport); ok {
+				functionCount++
+			}
+		}
+	}
+	for i := uint32(0); i < functionCount; i++ {
+		indices[fmt.Sprintf("func %d", i)] = i
+	}
+	if module.Export != nil {
+		for _, export := range module.Export.Entries {
+			if export.Kind == wasm.ExternalFunction {
+				indices[export.FieldStr] = export.Index
+			}
+		}
+	}
+	if names, err := module.Names(); err == nil {
+		for _, entry := range names.Entries {
+			if entry, ok := entry.(*wasm.FunctionNamesSubsection); ok {
+				for _, name := range entry.Names {
+					indices[name.Name] = name.Index
+				}
+			}
+		}
+	}
+
+	return func(name string) (uint32, bool) {
+		if index, ok := indices[name]; ok {
+			return index, true
+		}
+		for i, c := range name {
+			if c == '.' {
+				if index, ok := indices[name[i+1:]]; ok {
+					return index, true
+				}
+			}
+		}
+		return 0, false
+	}
+}
+
+// selectInlineFunctions decodes the defined functions whose calls will be inlined.
+func (m *moduleCompiler) selectInlineFunctions() error {
+	if m.inlineThreshold <= 0 || m.module.Code == nil {
+		return nil
+	}
+
+	totalCalls := int64(0)
+	for _, calls := range m.inlineProfile {
+		totalCalls += calls
+	}
+
+	for i, body := range m.module.Code.Bodies {
+		funcidx := uint32(i + len(m.importedFunctions))
+
+		threshold := m.inlineThreshold
+		if m.inlineProfile != nil {
+			// Functions that were never called are not worth inlining. Functions that account for at least 1% of
+			// all calls are hot, and are given a larger budget.
+			switch calls := m.inlineProfile[funcidx]; {
+			case calls == 0:
+				continue
+			case calls*100 >= totalCalls:
+				threshold = m.hotInlineThreshold
+			}
+		}
+
+		signature := m.module.Types.Entries[m.module.Function.Types[i]]
+		fn, err := wax.NewInlineFunction(signature, body, m)
+		if err != nil {
+			return fmt.Errorf("decoding function %v for inlining: %w", funcidx, err)
+		}
+		if fn.Size() <= threshold && fn.CanInline() {
+			if m.inlineFunctions == nil {
+				m.inlineFunctions = map[uint32]*wax.InlineFunction{}
+			}
+			m.inlineFunctions[funcidx] = fn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	"github.com/pgavlin/warp/internal/disasm"
+)
+
+// A sourceLocation is a position in the source of a module's code.
+type sourceLocation struct {
+	file string
+	line int
+}
+
+// functionLines returns the source location of the header of the function with the given index and the source
+// locations of its instructions, by instruction index. Instructions that have no location map to the zero value.
+//
+// Functions that are described by the module's DWARF are mapped to their source. Other functions are mapped to the
+// module's disassembly.
+func (m *moduleCompiler) functionLines(funcidx uint32) (sourceLocation, []sourceLocation, error) {
+	instructions, _, ok := m.debugInfo.DecodeInstructions(funcidx)
+	if !ok {
+		return sourceLocation{}, nil, fmt.Errorf("decoding function %v", funcidx)
+	}
+
+	var header sourceLocation
+	lines := make([]sourceLocation, len(instructions))
+	for ip := range instructions {
+		if file, line, ok := m.debugInfo.SourceLocation(funcidx, ip); ok {
+			lines[ip] = sourceLocation{file: file, line: line}
+			if header.file == "" {
+				header = lines[ip]
+			}
+		}
+	}
+	if header.file != "" {
+		return header, lines, nil
+	}
+
+	if m.disassembly == nil {
+		disassembly, err := disasm.Disassemble(m.module)
+		if err != nil {
+			return sourceLocation{}, nil, err
+		}
+		m.disassembly = disassembly
+	}
+
+	file := m.disassemblyPath
+	if !filepath.IsAbs(file) {
+		file = path.Join(m.lineDirectory, file)
+	}
+
+	line, _ := m.disassembly.Line(funcidx, -1)
+	header = sourceLocation{file: file, line: line}
+	for ip := range lines {
+		line, _ := m.disassembly.Line(funcidx, ip)
+		lines[ip] = sourceLocation{file: file, line: line}
+	}
+	return header, lines, nil
+}
+
+// A lineWriter precedes each line written to it with a line directive for its current source location.
+type lineWriter struct {
+	w        io.Writer
+	location sourceLocation
+	bol      bool // True if the next byte written begins a line.
+}
+
+func newLineWriter(w io.Writer, location sourceLocation) *lineWriter {
+	return &lineWriter{w: w, location: location, bol: true}
+}
+
+// seek sets the source location of subsequent lines. Unknown locations are ignored.
+func (l *lineWriter) seek(location sourceLocation) {
+	if location.file != "" {
+		l.location = location
+	}
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) != 0 {
+		if l.bol {
+			if _, err := fmt.Fprintf(l.w, "//line %s:%d\n", l.location.file, l.location.line); err != nil {
+				return written, err
+			}
+		}
+
+		n := bytes.IndexByte(b, '\n') + 1
+		l.bol = n != 0
+		if n == 0 {
+			n = len(b)
+		}
+
+		n, err := l.w.Write(b[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
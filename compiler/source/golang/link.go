@@ -0,0 +1,157 @@
+package golang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/validate"
+)
+
+// A LinkedModule is a module that is compiled by CompileLinkedModules.
+type LinkedModule struct {
+	// Name is the name of the module. Imports from other linked modules are matched against this name.
+	Name string
+	// Module is the module to compile.
+	Module *wasm.Module
+}
+
+// functionLink records the target of a function import that is resolved statically.
+type functionLink struct {
+	target *moduleCompiler
+	index  uint32
+}
+
+// CompileLinkedModules compiles the given modules into Go source code and writes the source to the given writer. The
+// source will be contained in the named package, and will contain an exec.ModuleDefinition for each module with the
+// exported version of the module's name.
+//
+// Function imports that refer to functions defined by other linked modules are resolved statically. If such an
+// import is resolved at instantiation time to the corresponding export of an instance of the linked module, calls
+// to the import are compiled to direct calls into that instance. Memories, tables, and globals imported from other
+// linked modules are shared with the exporting instance as usual.
+//
+// The options apply to every module. Options.InlineProfile must not be set, as inline profiles are specific to a
+// single module.
+func CompileLinkedModules(w io.Writer, packageName string, modules []LinkedModule, options *Options) error {
+	if len(modules) == 0 {
+		return errors.New("no modules to link")
+	}
+	if options != nil && options.InlineProfile != nil {
+		return errors.New("inline profiles cannot be used with linked modules")
+	}
+
+	compilers := make([]*moduleCompiler, len(modules))
+	byName, exportedNames := map[string]*moduleCompiler{}, map[string]bool{}
+	for i, m := range modules {
+		if err := validate.ValidateModule(m.Module, true); err != nil {
+			return fmt.Errorf("module %q: %w", m.Name, err)
+		}
+
+		name := identName(m.Name)
+		if name == "" {
+			return errors.New("linked modules must have names")
+		}
+		if _, ok := byName[m.Name]; ok || exportedNames[exportName(name)] {
+			return fmt.Errorf("duplicate module name %q", m.Name)
+		}
+
+		compiler := &moduleCompiler{
+			packageName:  packageName,
+			name:         unexportName(name),
+			exportedName: exportName(name),
+			module:       m.Module,
+			linked:       true,
+		}
+		options.apply(compiler)
+
+		compilers[i], byName[m.Name], exportedNames[compiler.exportedName] = compiler, compiler, true
+	}
+
+	for _, compiler := range compilers {
+		if err := compiler.compile(); err != nil {
+			return err
+		}
+		if len(compiler.asmFunctions) != 0 {
+			return ErrAssemblyOutput
+		}
+	}
+	for _, compiler := range compilers {
+		compiler.link(byName)
+	}
+
+	if err := compilers[0].emitPackage(w); err != nil {
+		return err
+	}
+	for _, compiler := range compilers {
+		if err := compiler.emitContents(w); err != nil {
+			return err
+		}
+	}
+	for _, compiler := range compilers {
+		if err := compiler.emitFunctions(w); err != nil {
+			return err
+		}
+	}
+
+	if f, ok := w.(*formatter); ok {
+		return f.flush()
+	}
+	return nil
+}
+
+// link resolves the module's function imports that refer to functions exported by the given modules.
+func (m *moduleCompiler) link(modules map[string]*moduleCompiler) {
+	if m.module.Import == nil {
+		return
+	}
+
+	funcidx := uint32(0)
+	for _, import_ := range m.module.Import.Entries {
+		if _, ok := import_.Type.(wasm.FuncImport); !ok {
+			continue
+		}
+		index := funcidx
+		funcidx++
+
+		target, ok := modules[import_.ModuleName]
+		if !ok || target.module.Export == nil {
+			continue
+		}
+		for _, export := range target.module.Export.Entries {
+			if export.Kind != wasm.ExternalFunction || export.FieldStr != import_.FieldName {
+				continue
+			}
+
+			// Re-exported imports are not linked, and imports whose signatures do not match the export will fail to
+			// resolve at instantiation time.
+			defined := export.Index >= uint32(len(target.importedFunctions))
+			if sig, _ := target.GetFunctionSignature(export.Index); defined && sig.Equals(m.importedFunctions[index]) {
+				if m.links == nil {
+					m.links = map[uint32]functionLink{}
+				}
+				m.links[index] = functionLink{target: target, index: export.Index}
+			}
+			break
+		}
+	}
+}
+
+// emitLinkedCall emits the fast path of an imported function's thunk, which calls the linked function directly if
+// the import was resolved to an instance of the linked module.
+func (m *moduleCompiler) emitLinkedCall(w io.Writer, index uint32, sig wasm.FunctionSig) error {
+	link, ok := m.links[index]
+	if !ok {
+		return nil
+	}
+
+	instance := fmt.Sprintf("m.link%d", index)
+	if err := printf(w, "if %s != nil {\n", instance); err != nil {
+		return err
+	}
+	if err := m.emitTypedCall(w, link.target.functionName(link.index), instance, sig); err != nil {
+		return err
+	}
+	return printf(w, "}\n")
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"go/format"
 	"io"
+	"path"
 	"strings"
 	"text/template"
 	"unicode"
 
+	"github.com/pgavlin/warp/compiler/wax"
 	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/debuginfo"
+	"github.com/pgavlin/warp/internal/disasm"
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 	"github.com/pgavlin/warp/wasm/validate"
@@ -21,24 +25,71 @@ var ErrInvalidMemoryIndex = fmt.Errorf("invalid memory index")
 
 type moduleCompiler struct {
 	isCommand         bool
+	isPlugin          bool
+	isGo              bool
 	noInternalThreads bool
 	useRawPointers    bool
+	typedAPI          bool
+	passes            wax.Passes
+
+	inlineThreshold    int
+	hotInlineThreshold int
+	inlineProfile      map[uint32]int64
+	inlineFunctions    map[uint32]*wax.InlineFunction
+
+	asmSelection map[uint32]bool
+	asmProfile   map[uint32]int64
+	allAsm       bool
+	// asmFunctions records the defined functions that are compiled to assembly, by function index.
+	asmFunctions map[uint32]bool
+	// asmOutput is true if the compilation writes the functions that are compiled to assembly.
+	asmOutput bool
 
 	packageName  string
 	name         string
 	exportedName string
 	module       *wasm.Module
 
+	// pluginHash is the content hash of the module, if it is compiled into a plugin.
+	pluginHash string
+	// incremental records the state of an incremental compilation, if any.
+	incremental *incrementalCompilation
+
 	importedFunctions []wasm.FunctionSig
 	importedMemory    *wasm.ImportEntry
 	importedTable     *wasm.ImportEntry
 	importedGlobals   []wasm.GlobalVar
 
+	typedImports typedImports
+
+	// linked is true if the module is compiled alongside other modules into the same package.
+	linked bool
+	// links records the function imports that are resolved statically, by function index.
+	links map[uint32]functionLink
+
 	exportedGlobals   map[uint32]bool
 	exportedFunctions map[uint32]bool
 
 	functionNames map[uint32]string
 	functions     []functionCompiler
+
+	functionsPerFile int
+	filesPerPackage  int
+	importPath       string
+
+	// sharded is true if the module's functions are emitted into packages other than the package that contains
+	// the module's declarations. Identifiers that are referenced by function bodies are exported in this mode.
+	sharded bool
+	// qualifier is the package qualifier used to refer to the module's declarations from the file being emitted.
+	qualifier string
+
+	lineDirectives  bool
+	disassemblyPath string
+	debugInfo       *debuginfo.Info
+	disassembly     *disasm.Disassembly
+	// lineDirectory is the slash-separated path from the directory of the file being emitted to the directory that
+	// contains the module's disassembly.
+	lineDirectory string
 }
 
 func unexportName(name string) string {
@@ -146,12 +197,122 @@ type Options struct {
 	UseRawPointers bool
 	// NoInternalThreads disables the use of *exec.Thread inside the generated code.
 	NoInternalThreads bool
+	// TypedAPI enables the generation of a typed API for the module in addition to its exec.ModuleDefinition. The
+	// typed API exposes the module's exports as Go methods with native parameter and result types, and describes the
+	// module's imports as a Go interface that is implemented by the host.
+	TypedAPI bool
+
+	// ConstantPropagation enables the propagation of constants stored to locals.
+	ConstantPropagation bool
+	// CopyPropagation enables the propagation of copies between locals.
+	CopyPropagation bool
+	// DeadCodeElimination enables the removal of dead stores to locals, pure drops, branches and if arms that are
+	// never taken, and code that is unreachable.
+	DeadCodeElimination bool
+	// RedundantLoadElimination enables the reuse of memory loads within straight-line code.
+	RedundantLoadElimination bool
+	// BoundsCheckElimination enables the use of unchecked memory accesses where an access is known to be in bounds,
+	// the coalescing of the bounds checks of nearby accesses to the same address, and the hoisting of the checks of
+	// accesses indexed by loop induction variables out of their loops. It has no effect if UseRawPointers is set.
+	BoundsCheckElimination bool
+
+	// InlineThreshold enables the inlining of calls to small leaf functions that return at most one value. A
+	// function is inlined if its body contains at most this many instructions. Inlining is disabled if the threshold
+	// is zero.
+	InlineThreshold int
+	// HotInlineThreshold is the size threshold for functions that InlineProfile reports as hot. Defaults to four
+	// times InlineThreshold.
+	HotInlineThreshold int
+	// InlineProfile records the number of calls to each function, by function index. If set, functions that were
+	// never called are not inlined, and functions that account for at least 1% of all calls are hot. See
+	// ReadInlineProfile.
+	InlineProfile map[uint32]int64
+
+	// FunctionsPerFile is the number of functions written to each file by CompileModuleDirectory and
+	// CompileCommandDirectory. Defaults to DefaultFunctionsPerFile, or to 1 for incremental compilations, which
+	// require it.
+	FunctionsPerFile int
+	// FilesPerPackage enables the sharding of functions written by CompileModuleDirectory and
+	// CompileCommandDirectory into sub-packages of at most this many files each. Sharding requires ImportPath.
+	FilesPerPackage int
+	// ImportPath is the import path of the directory written by CompileModuleDirectory and CompileCommandDirectory.
+	ImportPath string
+	// Incremental enables incremental compilation by CompileModuleDirectory and CompileCommandDirectory. Each
+	// function is written to its own file, which is named for a hash of the function's body and the context in which
+	// it is compiled. Functions whose files were written by the previous compilation into the same directory are not
+	// recompiled, and their files are not rewritten. The mapping from functions to files is recorded in the
+	// directory's manifest; see Manifest. The output directory must be an IncrementalDirectory.
+	//
+	// Without FilesPerPackage, every function's file belongs to the same package, which the Go toolchain rebuilds in
+	// its entirety if any function changes. Combined with FilesPerPackage, only the packages that contain changed
+	// functions are rebuilt.
+	Incremental bool
+
+	// LineDirectives enables the emission of //line directives that map each statement of a compiled function to the
+	// source of the instruction it implements, so that Go stack traces and profiles refer to the module's code. If
+	// the module's DWARF describes any instruction of a function, the function's statements are mapped to source
+	// lines. Otherwise they are mapped to the lines of the module's text format as written by wast.WriteTo.
+	LineDirectives bool
+	// DisassemblyPath is the path to the module's text format that is referenced by line directives. Relative paths
+	// are relative to the output directory. Defaults to the name of the module with the extension ".disasm.wat".
+	DisassemblyPath string
+
+	// AssemblyFunctions selects defined functions to compile to Go assembly for amd64 rather than to Go source, by
+	// function index. Compiled assembly accesses the module's memory directly, so packages that contain assembly
+	// only build for amd64 targets on which exec.Memory reserves guard pages. Assembly is experimental, and is only
+	// written by CompileModuleDirectory, CompileCommandDirectory, and CompileModuleAssembly. See also
+	// ReadAssemblyAnnotations and LookupFunctions.
+	AssemblyFunctions map[uint32]bool
+	// AssemblyProfile selects the functions that account for at least 1% of all calls for compilation to assembly.
+	// The profile has the same form as InlineProfile.
+	AssemblyProfile map[uint32]int64
+	// AllAssembly compiles every defined function to assembly.
+	AllAssembly bool
 }
 
 func (o *Options) apply(m *moduleCompiler) {
 	if o != nil {
 		m.useRawPointers = o.UseRawPointers
 		m.noInternalThreads = o.NoInternalThreads
+		m.typedAPI = o.TypedAPI
+		m.lineDirectives = o.LineDirectives
+		m.disassemblyPath = o.DisassemblyPath
+
+		if o.ConstantPropagation {
+			m.passes |= wax.PassConstantPropagation
+		}
+		if o.CopyPropagation {
+			m.passes |= wax.PassCopyPropagation
+		}
+		if o.DeadCodeElimination {
+			m.passes |= wax.PassDeadCodeElimination
+		}
+		if o.RedundantLoadElimination {
+			m.passes |= wax.PassRedundantLoadElimination
+		}
+		if o.BoundsCheckElimination && !o.UseRawPointers {
+			m.passes |= wax.PassBoundsCheckElimination
+		}
+
+		m.inlineThreshold, m.hotInlineThreshold = o.InlineThreshold, o.HotInlineThreshold
+		if m.hotInlineThreshold == 0 {
+			m.hotInlineThreshold = 4 * m.inlineThreshold
+		}
+		m.inlineProfile = o.InlineProfile
+
+		m.asmSelection, m.asmProfile, m.allAsm = o.AssemblyFunctions, o.AssemblyProfile, o.AllAssembly
+
+		m.functionsPerFile, m.filesPerPackage, m.importPath = o.FunctionsPerFile, o.FilesPerPackage, o.ImportPath
+
+		if o.Incremental {
+			m.incremental = &incrementalCompilation{options: fmt.Sprintf("%#v", *o)}
+			if m.functionsPerFile <= 0 {
+				m.functionsPerFile = 1
+			}
+		}
+	}
+	if m.functionsPerFile <= 0 {
+		m.functionsPerFile = DefaultFunctionsPerFile
 	}
 }
 
@@ -173,13 +334,16 @@ func CompileModule(w io.Writer, packageName, name string, module *wasm.Module, o
 	}
 	options.apply(&compiler)
 
-	compiler.compile()
+	if err := compiler.compile(); err != nil {
+		return err
+	}
 	return compiler.emit(w)
 }
 
-// CompileCommand compiles the given WASI module into Go source code and writes the source
-// to the given writer. The source will be contained in package main, and will contain a
-// main function.
+// CompileCommand compiles the given WASI or Go module into Go source code and writes the
+// source to the given writer. The source will be contained in package main, and will
+// contain a main function. Modules that import from the "go" module are Go programs, and
+// are run using go_wasm_exec. Other modules are run using wasi.
 func CompileCommand(w io.Writer, name string, module *wasm.Module, options *Options) error {
 	if err := validate.ValidateModule(module, true); err != nil {
 		return err
@@ -194,7 +358,9 @@ func CompileCommand(w io.Writer, name string, module *wasm.Module, options *Opti
 	}
 	options.apply(&compiler)
 
-	compiler.compile()
+	if err := compiler.compile(); err != nil {
+		return err
+	}
 	return compiler.emit(w)
 }
 
@@ -246,10 +412,15 @@ func (m *moduleCompiler) globalType(globalidx uint32) wasm.ValueType {
 	return m.module.Global.Globals[int(globalidx)-len(m.importedGlobals)].Type.Type
 }
 
-func (m *moduleCompiler) compile() {
+func (m *moduleCompiler) compile() error {
 	// Record import counts for index spaces
 	if m.module.Import != nil {
 		for i, import_ := range m.module.Import.Entries {
+			// Modules that import from the go module are Go programs that run under go_wasm_exec.
+			if import_.ModuleName == "go" {
+				m.isGo = true
+			}
+
 			switch type_ := import_.Type.(type) {
 			case wasm.FuncImport:
 				m.importedFunctions = append(m.importedFunctions, m.module.Types.Entries[int(type_.Type)])
@@ -263,6 +434,17 @@ func (m *moduleCompiler) compile() {
 		}
 	}
 
+	if m.typedAPI {
+		m.nameTypedImports()
+	}
+
+	if m.lineDirectives {
+		m.debugInfo = debuginfo.New(m.module)
+		if m.disassemblyPath == "" {
+			m.disassemblyPath = unexportName(m.exportedName) + ".disasm.wat"
+		}
+	}
+
 	// Record exports for global accesses + thunks
 	if m.module.Export != nil {
 		m.exportedFunctions, m.exportedGlobals = map[uint32]bool{}, map[uint32]bool{}
@@ -288,21 +470,49 @@ func (m *moduleCompiler) compile() {
 		}
 	}
 
+	// Select functions for inlining and for compilation to assembly.
+	if err := m.selectInlineFunctions(); err != nil {
+		return err
+	}
+	m.selectAssemblyFunctions()
+
 	// Compile functions
 	if m.module.Code != nil {
+		if m.incremental != nil {
+			m.prepareIncremental()
+		}
+
 		m.functions = make([]functionCompiler, len(m.module.Code.Bodies))
 		for i, body := range m.module.Code.Bodies {
 			funcidx := i + len(m.importedFunctions)
 
 			typeidx := m.module.Function.Types[i]
+			if m.incremental != nil && m.incremental.unchanged[i] {
+				// Unchanged functions are not emitted, but their signatures are still referenced.
+				f := &m.functions[i]
+				f.m, f.index, f.Signature = m, funcidx, m.module.Types.Entries[typeidx]
+				continue
+			}
 			m.functions[i].compile(m, funcidx, typeidx, m.module.Types.Entries[typeidx], body)
 		}
 	}
+	return nil
+}
+
+// ident returns the given identifier, exported if the module is sharded.
+func (m *moduleCompiler) ident(name string) string {
+	if m.sharded {
+		return exportName(name)
+	}
+	return name
 }
 
 func (m *moduleCompiler) functionName(index uint32) string {
 	if name, ok := m.functionNames[index]; ok {
-		return fmt.Sprintf("f%d_%v", index, name)
+		if m.linked {
+			return fmt.Sprintf("%s_f%d_%v", m.name, index, name)
+		}
+		return m.ident(fmt.Sprintf("f%d_%v", index, name))
 	}
 	return fmt.Sprintf("%s_f%d", m.name, index)
 }
@@ -315,44 +525,81 @@ func (m *moduleCompiler) typeName(typeidx uint32) string {
 	return m.functionTypeName(m.module.Types.Entries[int(typeidx)])
 }
 
-func (m *moduleCompiler) emit(w io.Writer) error {
-	// Commands must have a _start function with the signature [] -> []
-	if m.isCommand {
-		hasEntrypoint := false
-		if m.module.Export != nil {
-			for _, export := range m.module.Export.Entries {
-				if export.Kind == wasm.ExternalFunction && export.FieldStr == "_start" {
-					sig := m.module.Types.Entries[m.module.Function.Types[int(export.Index)-len(m.importedFunctions)]]
-					if !sig.Equals(wasm.FunctionSig{}) {
-						return fmt.Errorf("_start must not accept or return parameters")
-					}
-					hasEntrypoint = true
-					break
-				}
+// goEntrypoints are the functions that go_wasm_exec calls, and their signatures.
+var goEntrypoints = []struct {
+	name string
+	sig  wasm.FunctionSig
+}{
+	{"run", wasm.FunctionSig{ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}}},
+	{"resume", wasm.FunctionSig{}},
+	{"getsp", wasm.FunctionSig{ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}}},
+}
+
+// exportedFunction returns the signature of the function exported with the given name.
+func (m *moduleCompiler) exportedFunction(name string) (wasm.FunctionSig, bool) {
+	if m.module.Export != nil {
+		for _, export := range m.module.Export.Entries {
+			if export.Kind == wasm.ExternalFunction && export.FieldStr == name {
+				return m.GetFunctionSignature(export.Index)
 			}
 		}
-		if !hasEntrypoint {
-			return fmt.Errorf("missing _start function")
+	}
+	return wasm.FunctionSig{}, false
+}
+
+// checkEntrypoint checks that a command exports the functions that its runtime calls. WASI commands must export a
+// _start function with the signature [] -> []. Go commands must export the functions in goEntrypoints.
+func (m *moduleCompiler) checkEntrypoint() error {
+	if !m.isCommand {
+		return nil
+	}
+
+	if m.isGo {
+		for _, entrypoint := range goEntrypoints {
+			sig, ok := m.exportedFunction(entrypoint.name)
+			if !ok {
+				return fmt.Errorf("missing %v function", entrypoint.name)
+			}
+			if !sig.Equals(entrypoint.sig) {
+				return fmt.Errorf("%v must be of type %v", entrypoint.name, entrypoint.sig)
+			}
 		}
+		return nil
 	}
 
-	// Emit package declaration and imports
-	if err := m.emitPackage(w); err != nil {
-		return err
+	sig, ok := m.exportedFunction("_start")
+	if !ok {
+		return fmt.Errorf("missing _start function")
+	}
+	if !sig.Equals(wasm.FunctionSig{}) {
+		return fmt.Errorf("_start must not accept or return parameters")
 	}
+	return nil
+}
 
-	// Emit function types
-	if err := m.emitFunctionTypes(w); err != nil {
+// runtimePackage returns the import path of the package that runs a command.
+func (m *moduleCompiler) runtimePackage() string {
+	if m.isGo {
+		return "github.com/pgavlin/warp/go_wasm_exec"
+	}
+	return "github.com/pgavlin/warp/wasi"
+}
+
+func (m *moduleCompiler) emit(w io.Writer) error {
+	if err := m.checkEntrypoint(); err != nil {
 		return err
 	}
+	if len(m.asmFunctions) != 0 && !m.asmOutput {
+		return ErrAssemblyOutput
+	}
 
-	// Emit module definition
-	if err := m.emitModuleDefinition(w); err != nil {
+	// Emit package declaration and imports
+	if err := m.emitPackage(w); err != nil {
 		return err
 	}
 
-	// Emit module
-	if err := m.emitModule(w); err != nil {
+	// Emit function types, module definition, and module
+	if err := m.emitContents(w); err != nil {
 		return err
 	}
 
@@ -363,22 +610,61 @@ func (m *moduleCompiler) emit(w io.Writer) error {
 		}
 	}
 
+	// Emit the symbols of a plugin
+	if m.isPlugin {
+		if err := m.emitPluginSymbols(w); err != nil {
+			return err
+		}
+	}
+
+	// Emit functions. These are emitted last so that line directives in their bodies do not apply to other code.
+	if err := m.emitFunctions(w); err != nil {
+		return err
+	}
+
 	if f, ok := w.(*formatter); ok {
 		return f.flush()
 	}
 	return nil
 }
 
-func (m *moduleCompiler) emitPackage(w io.Writer) error {
-	t := template.Must(template.New("Package").Parse(`package {{.PackageName}}
+// emitContents emits the module's declarations and the thunks for its imported functions.
+func (m *moduleCompiler) emitContents(w io.Writer) error {
+	if err := m.emitDeclarations(w); err != nil {
+		return err
+	}
+	return m.emitImportedFunctions(w)
+}
 
-import (
-	{{range .Imports -}}
-	"{{.}}"
-	{{end -}}
-)
-`))
+// emitFunctions emits the module's defined functions.
+func (m *moduleCompiler) emitFunctions(w io.Writer) error {
+	for _, f := range m.functions {
+		if err := f.emit(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// emitDeclarations emits the module's function types, its module definition, its module, and its typed API, if
+// any.
+func (m *moduleCompiler) emitDeclarations(w io.Writer) error {
+	if err := m.emitFunctionTypes(w); err != nil {
+		return err
+	}
+	if err := m.emitModuleDefinition(w); err != nil {
+		return err
+	}
+	if err := m.emitModule(w); err != nil {
+		return err
+	}
+	if m.typedAPI {
+		return m.emitTypedAPI(w)
+	}
+	return nil
+}
+
+func (m *moduleCompiler) emitPackage(w io.Writer) error {
 	imports := []string{
 		"math",
 		"math/bits",
@@ -387,11 +673,23 @@ import (
 		"github.com/pgavlin/warp/wasm",
 	}
 	if m.isCommand {
-		imports = append(imports, "github.com/pgavlin/warp/wasi")
+		imports = append(imports, m.runtimePackage())
 	}
+	return emitPackageClause(w, m.packageName, imports)
+}
+
+func emitPackageClause(w io.Writer, packageName string, imports []string) error {
+	t := template.Must(template.New("Package").Parse(`package {{.PackageName}}
+
+import (
+	{{range .Imports -}}
+	"{{.}}"
+	{{end -}}
+)
+`))
 
 	return t.Execute(w, map[string]interface{}{
-		"PackageName": m.packageName,
+		"PackageName": packageName,
 		"Imports":     imports,
 	})
 }
@@ -439,7 +737,7 @@ func ({{.Name}}) Allocate(name string) (exec.AllocatedModule, error) {
 		types = m.module.Types.Entries
 	}
 	return t.Execute(w, map[string]interface{}{
-		"Name":         m.name,
+		"Name":         unexportName(m.name),
 		"ExportedName": m.exportedName,
 		"Imports":      imports,
 		"Types":        types,
@@ -465,33 +763,50 @@ func (m *moduleCompiler) emitModule(w io.Writer) error {
 	if err := m.emitGetters(w); err != nil {
 		return err
 	}
-	if err := m.emitHelpers(w); err != nil {
-		return err
-	}
-	return m.emitFunctions(w)
+	return m.emitHelpers(w)
 }
 
 func (m *moduleCompiler) emitModuleType(w io.Writer) error {
 	t := template.Must(template.New("Module").Parse(`type {{.Name}}Instance struct {
 	name string
 
-	mem0   *exec.Memory
+	{{.Mem0}}   *exec.Memory
 	table0 *exec.Table
 
-	mem    uintptr
+	{{.Mem}}    uintptr
 	table  []exec.Function
 
 	importedFunctions []exec.Function
 	importedGlobals   []*exec.Global
+	{{- if .TypedAPI}}
+
+	imports {{.ExportedName}}Imports
+	{{- end}}
+	{{- if .Links}}
+	{{range .Links}}
+	link{{.Index}} *{{.Instance}}Instance
+	{{- end}}
+	{{- end}}
 
 	exports map[string]interface{}
 
 	{{range .Globals -}}
-	g{{.Index}} {{.Type}}
+	{{$.G}}{{.Index}} {{.Type}}
 	{{end -}}
 }
 `))
 
+	type link struct {
+		Index    uint32
+		Instance string
+	}
+	var links []link
+	for i := range m.importedFunctions {
+		if l, ok := m.links[uint32(i)]; ok {
+			links = append(links, link{Index: uint32(i), Instance: l.target.name})
+		}
+	}
+
 	type global struct {
 		Index uint32
 		Type  string
@@ -510,8 +825,14 @@ func (m *moduleCompiler) emitModuleType(w io.Writer) error {
 		}
 	}
 	return t.Execute(w, map[string]interface{}{
-		"Name":    m.name,
-		"Globals": globals,
+		"Name":         m.name,
+		"ExportedName": m.exportedName,
+		"TypedAPI":     m.typedAPI,
+		"Links":        links,
+		"Mem0":         m.ident("mem0"),
+		"Mem":          m.ident("mem"),
+		"G":            m.ident("g"),
+		"Globals":      globals,
 	})
 }
 
@@ -529,7 +850,7 @@ func allocate{{.ExportedName}}(name string) (exec.AllocatedModule, error) {
 
 	{{if .NewMem0 -}}
 	mem0 := exec.NewMemory({{.MinMem0}}, {{.MaxMem0}})
-	m.mem0 = &mem0
+	m.{{.Mem0}} = &mem0
 	{{- end}}
 
 	{{if .NewTable0 -}}
@@ -539,21 +860,21 @@ func allocate{{.ExportedName}}(name string) (exec.AllocatedModule, error) {
 
 	{{range .Globals -}}
 	{{if .Exported -}}
-	m.g{{.Index}} = exec.NewGlobal{{.Type}}({{.Immutable}}, 0)
+	m.{{$.G}}{{.Index}} = exec.NewGlobal{{.Type}}({{.Immutable}}, 0)
 	{{- end}}
 	{{end -}}
 
 	{{if .HasExports -}}
 	m.exports = map[string]interface{}{}
 	{{if .ExportMem0 -}}
-	m.exports[{{printf "%q" .ExportMem0.FieldStr}}] = m.mem0
+	m.exports[{{printf "%q" .ExportMem0.FieldStr}}] = m.{{$.Mem0}}
 	{{- end}}
 	{{if .ExportTable0 -}}
 	m.exports[{{printf "%q" .ExportTable0.FieldStr}}] = m.table0
 	{{- end}}
 	{{range .ExportedGlobals -}}
 	{{if not .Imported -}}
-	m.exports[{{printf "%q" .FieldStr}}] = &m.g{{.Index}}
+	m.exports[{{printf "%q" .FieldStr}}] = &m.{{$.G}}{{.Index}}
 	{{- end}}
 	{{end -}}
 	{{range .ExportedFunctions -}}
@@ -574,19 +895,35 @@ func (m *allocated{{.ExportedName}}) Instantiate(imports exec.ImportResolver) (e
 	}
 
 	{{with .ImportMem0 -}}
+	{{if $.TypedAPI -}}
+	if m.imports != nil {
+		m.{{$.Mem0}} = m.imports.{{$.TypedMem0}}()
+	} else {
+	{{end -}}
 	mem, err := imports.ResolveMemory({{printf "%q" .ModuleName}}, {{printf "%q" .FieldName}}, {{printf "%#v" .Type}})
 	if err != nil {
 		return nil, err
 	}
-	m.mem0 = mem
+	m.{{$.Mem0}} = mem
+	{{- if $.TypedAPI}}
+	}
+	{{- end}}
 	{{- end}}
 
 	{{with .ImportTable0 -}}
+	{{if $.TypedAPI -}}
+	if m.imports != nil {
+		m.table0 = m.imports.{{$.TypedTable0}}()
+	} else {
+	{{end -}}
 	table, err := imports.ResolveTable({{printf "%q" .ModuleName}}, {{printf "%q" .FieldName}}, {{printf "%#v" .Type}})
 	if err != nil {
 		return nil, err
 	}
 	m.table0 = table
+	{{- if $.TypedAPI}}
+	}
+	{{- end}}
 	{{- end}}
 
 	if err := m.initGlobals(imports); err != nil {
@@ -600,21 +937,21 @@ func (m *allocated{{.ExportedName}}) Instantiate(imports exec.ImportResolver) (e
 	m.table = m.table0.Entries()
 	{{- end}}
 	m.initTable()
-	{{if and .UseRawPointers (or .ImportMem0 .NewMem0) -}}
-	m.mem = m.mem0.Start()
+	{{if and (or .UseRawPointers .Assembly) (or .ImportMem0 .NewMem0) -}}
+	m.{{.Mem}} = m.{{.Mem0}}.Start()
 	{{- end}}
 	m.initMemory()
 
 	{{if .HasExports -}}
 	{{if .ExportMem0 -}}
-	m.exports[{{printf "%q" .ExportMem0.FieldStr}}] = m.mem0
+	m.exports[{{printf "%q" .ExportMem0.FieldStr}}] = m.{{$.Mem0}}
 	{{- end}}
 	{{if .ExportTable0 -}}
 	m.exports[{{printf "%q" .ExportTable0.FieldStr}}] = m.table0
 	{{- end}}
 	{{range .ExportedGlobals -}}
 	{{if .Imported}}
-	m.exports[{{printf "%q" .FieldStr}}] = m.g{{.Index}}
+	m.exports[{{printf "%q" .FieldStr}}] = m.{{$.G}}{{.Index}}
 	{{- end}}
 	{{end -}}
 	{{range .ExportedFunctions -}}
@@ -735,7 +1072,14 @@ func (m *allocated{{.ExportedName}}) Instantiate(imports exec.ImportResolver) (e
 	return t.Execute(w, map[string]interface{}{
 		"Name":              m.name,
 		"ExportedName":      m.exportedName,
+		"Mem0":              m.ident("mem0"),
+		"Mem":               m.ident("mem"),
+		"G":                 m.ident("g"),
 		"UseRawPointers":    m.useRawPointers,
+		"Assembly":          len(m.asmFunctions) != 0,
+		"TypedAPI":          m.typedAPI,
+		"TypedMem0":         m.typedImports.memory,
+		"TypedTable0":       m.typedImports.table,
 		"ImportMem0":        importMem0,
 		"NewMem0":           newMem0,
 		"MinMem0":           minMem0,
@@ -759,11 +1103,24 @@ func (m *moduleCompiler) emitInitFunctions(w io.Writer) error {
 	{{if .Functions -}}
 	m.importedFunctions = make([]exec.Function, {{len .Functions}})
 	{{end -}}
+	{{if and .TypedAPI .Functions -}}
+	if m.imports != nil {
+		{{range $i, $f := .Functions -}}
+		m.importedFunctions[{{$i}}] = new{{$f.TypeName}}(m, {{$f.Name}})
+		{{end -}}
+		return nil
+	}
+	{{end -}}
 	{{range $i, $f := .Functions -}}
 	m.importedFunctions[{{$i}}], err = imports.ResolveFunction({{printf "%q" $f.ModuleName}}, {{printf "%q" $f.FieldName}}, {{printf "%#v" $f.Signature}})
 	if err != nil {
 		return err
 	}
+	{{with $f.LinkType -}}
+	if f, ok := m.importedFunctions[{{$i}}].(*{{.}}); ok && f.m.exports[{{printf "%q" $f.FieldName}}] == m.importedFunctions[{{$i}}] {
+		m.link{{$i}} = f.m
+	}
+	{{end -}}
 	{{end -}}
 	return nil
 }
@@ -774,21 +1131,32 @@ func (m *moduleCompiler) emitInitFunctions(w io.Writer) error {
 		ModuleName string
 		FieldName  string
 		Signature  wasm.FunctionSig
+		Name       string
+		TypeName   string
+		LinkType   string
 	}
 	functions := make([]function, 0, len(m.importedFunctions))
 	if m.module.Import != nil {
 		for _, import_ := range m.module.Import.Entries {
 			if _, ok := import_.Type.(wasm.FuncImport); ok {
+				sig, linkType := m.importedFunctions[len(functions)], ""
+				if l, ok := m.links[uint32(len(functions))]; ok {
+					linkType = l.target.functionTypeName(sig)
+				}
 				functions = append(functions, function{
 					ModuleName: import_.ModuleName,
 					FieldName:  import_.FieldName,
-					Signature:  m.importedFunctions[len(functions)],
+					Signature:  sig,
+					Name:       m.functionName(uint32(len(functions))),
+					TypeName:   exportName(m.functionTypeName(sig)),
+					LinkType:   linkType,
 				})
 			}
 		}
 	}
 	return t.Execute(w, map[string]interface{}{
 		"Name":      m.name,
+		"TypedAPI":  m.typedAPI,
 		"Functions": functions,
 	})
 }
@@ -797,14 +1165,22 @@ func (m *moduleCompiler) emitInitGlobals(w io.Writer) error {
 	t := template.Must(template.New("InitGlobals").Parse(`func (m *{{.Name}}Instance) initGlobals(imports exec.ImportResolver) (err error) {
 	{{range .Globals -}}
 	{{if .Imported -}}
-	m.g{{.Index}}, err = imports.ResolveGlobal({{printf "%q" .ModuleName}}, {{printf "%q" .FieldName}}, {{printf "%#v" .ImportType}})
+	{{if $.TypedAPI -}}
+	if m.imports != nil {
+		m.{{$.G}}{{.Index}} = m.imports.{{.TypedName}}()
+	} else {
+	{{end -}}
+	m.{{$.G}}{{.Index}}, err = imports.ResolveGlobal({{printf "%q" .ModuleName}}, {{printf "%q" .FieldName}}, {{printf "%#v" .ImportType}})
 	if err != nil {
 		return err
 	}
+	{{- if $.TypedAPI}}
+	}
+	{{- end}}
 	{{- else if .Exported -}}
-	m.g{{.Index}} = exec.NewGlobal{{.Type}}({{.Immutable}}, {{.Value}})
+	m.{{$.G}}{{.Index}} = exec.NewGlobal{{.Type}}({{.Immutable}}, {{.Value}})
 	{{- else -}}
-	m.g{{.Index}} = {{.Value}}
+	m.{{$.G}}{{.Index}} = {{.Value}}
 	{{- end}}
 	{{end -}}
 	return nil
@@ -817,6 +1193,7 @@ func (m *moduleCompiler) emitInitGlobals(w io.Writer) error {
 		ModuleName string
 		FieldName  string
 		ImportType wasm.GlobalVar
+		TypedName  string
 		Exported   bool
 		Index      uint32
 		Type       string
@@ -829,12 +1206,17 @@ func (m *moduleCompiler) emitInitGlobals(w io.Writer) error {
 		for _, import_ := range m.module.Import.Entries {
 			if _, ok := import_.Type.(wasm.GlobalVarImport); ok {
 				i := uint32(len(globals))
+				typedName := ""
+				if m.typedAPI {
+					typedName = m.typedImports.globals[i]
+				}
 				globals = append(globals, global{
 					Imported:   true,
 					ModuleName: import_.ModuleName,
 					FieldName:  import_.FieldName,
 					ImportType: import_.Type.(wasm.GlobalVarImport).Type,
 					Exported:   m.exportedGlobals[i],
+					TypedName:  typedName,
 					Index:      i,
 				})
 			}
@@ -870,8 +1252,10 @@ func (m *moduleCompiler) emitInitGlobals(w io.Writer) error {
 		}
 	}
 	return t.Execute(w, map[string]interface{}{
-		"Name":    m.name,
-		"Globals": globals,
+		"Name":     m.name,
+		"TypedAPI": m.typedAPI,
+		"G":        m.ident("g"),
+		"Globals":  globals,
 	})
 }
 
@@ -910,21 +1294,70 @@ func (m *{{.Name}}Instance) initMemory() {
 	})
 }
 
+// segmentOffsets compiles the offsets of a section's segments, which are given as constant expressions along with
+// the lengths of the segments. It returns the source code for each offset, the segments whose offsets are not
+// constant, and the largest end offset of the segments whose offsets are constant.
+func (m *moduleCompiler) segmentOffsets(exprs [][]byte, lengths []int, doesNotFit error) ([]string, []segment, uint64, error) {
+	offsets := make([]string, len(exprs))
+	var segments []segment
+	var end uint64
+	for i, expr := range exprs {
+		body, err := code.Decode(expr, m, []wasm.ValueType{wasm.ValueTypeI32})
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		c := constExpressionCompiler{m: m, code: body.Instructions}
+		c.compile()
+
+		offset, offsetText := c.emit()
+		if offset != nil && offset.(int32) < 0 {
+			return nil, nil, 0, doesNotFit
+		}
+
+		offsets[i] = offsetText
+		if offset == nil {
+			segments = append(segments, segment{Offset: offsetText, Length: lengths[i]})
+		} else if segmentEnd := uint64(offset.(int32)) + uint64(lengths[i]); segmentEnd > end {
+			end = segmentEnd
+		}
+	}
+	return offsets, segments, end, nil
+}
+
+// A segment is an element or data segment whose offset is not constant.
+type segment struct {
+	Offset string
+	Length int
+}
+
+// emitCheckOffsets emits a function that checks that the module's element and data segments fit in its table and
+// memory. Segments at constant offsets are checked at once against the largest end offset, so the size of the
+// function does not depend on the number of such segments.
 func (m *moduleCompiler) emitCheckOffsets(w io.Writer) ([]string, []string, error) {
 	t := template.Must(template.New("CheckOffsets").Parse(`func (m *{{.Name}}Instance) checkOffsets() error {
-	{{if .Elements -}}
+	{{if .HasElements -}}
 	table := m.table0.Entries()
+	{{if .ElementsEnd -}}
+	if uint64(len(table)) < {{.ElementsEnd}} {
+		return exec.ErrElementSegmentDoesNotFit
+	}
+	{{end -}}
 	{{range $i, $e := .Elements -}}
-	if int32(len(table)) < {{$e.Offset}} || len(table[int({{$e.Offset}}):]) < {{len $e.Elems}} {
+	if int32(len(table)) < {{$e.Offset}} || len(table[int({{$e.Offset}}):]) < {{$e.Length}} {
 		return exec.ErrElementSegmentDoesNotFit
 	}
 	{{end -}}
 	{{- end}}
 
-	{{if .Data -}}
-	bytes := m.mem0.Bytes()
+	{{if .HasData -}}
+	bytes := m.{{.Mem0}}.Bytes()
+	{{if .DataEnd -}}
+	if uint64(len(bytes)) < {{.DataEnd}} {
+		return exec.ErrDataSegmentDoesNotFit
+	}
+	{{end -}}
 	{{range $i, $e := .Data -}}
-	if int32(len(bytes)) < {{$e.Offset}} || len(bytes[int({{$e.Offset}}):]) < {{len $e.Data}} {
+	if int32(len(bytes)) < {{$e.Offset}} || len(bytes[int({{$e.Offset}}):]) < {{$e.Length}} {
 		return exec.ErrDataSegmentDoesNotFit
 	}
 	{{end -}}
@@ -935,67 +1368,43 @@ func (m *moduleCompiler) emitCheckOffsets(w io.Writer) ([]string, []string, erro
 
 `))
 
-	var elementOffsets []string
-	var dataOffsets []string
-
-	type element struct {
-		Offset string
-		Elems  []uint32
-	}
-	var elements []element
+	var elementOffsets, dataOffsets []string
+	var elements, datas []segment
+	var elementsEnd, dataEnd uint64
 	if m.module.Elements != nil {
-		for _, e := range m.module.Elements.Entries {
-			body, err := code.Decode(e.Offset, m, []wasm.ValueType{wasm.ValueTypeI32})
-			if err != nil {
-				return nil, nil, err
-			}
-			c := constExpressionCompiler{m: m, code: body.Instructions}
-			c.compile()
-
-			offset, offsetText := c.emit()
-			if offset != nil && offset.(int32) < 0 {
-				return nil, nil, exec.ErrElementSegmentDoesNotFit
-			}
-
-			elementOffsets = append(elementOffsets, offsetText)
-			elements = append(elements, element{
-				Offset: offsetText,
-				Elems:  e.Elems,
-			})
+		exprs, lengths := make([][]byte, len(m.module.Elements.Entries)), make([]int, len(m.module.Elements.Entries))
+		for i, e := range m.module.Elements.Entries {
+			exprs[i], lengths[i] = e.Offset, len(e.Elems)
 		}
-	}
 
-	type data struct {
-		Offset string
-		Data   []byte
+		var err error
+		elementOffsets, elements, elementsEnd, err = m.segmentOffsets(exprs, lengths, exec.ErrElementSegmentDoesNotFit)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	var datas []data
 	if m.module.Data != nil {
-		for _, e := range m.module.Data.Entries {
-			body, err := code.Decode(e.Offset, m, []wasm.ValueType{wasm.ValueTypeI32})
-			if err != nil {
-				return nil, nil, err
-			}
-			c := constExpressionCompiler{m: m, code: body.Instructions}
-			c.compile()
-
-			offset, offsetText := c.emit()
-			if offset != nil && offset.(int32) < 0 {
-				return nil, nil, exec.ErrDataSegmentDoesNotFit
-			}
+		exprs, lengths := make([][]byte, len(m.module.Data.Entries)), make([]int, len(m.module.Data.Entries))
+		for i, e := range m.module.Data.Entries {
+			exprs[i], lengths[i] = e.Offset, len(e.Data)
+		}
 
-			dataOffsets = append(dataOffsets, offsetText)
-			datas = append(datas, data{
-				Offset: offsetText,
-				Data:   e.Data,
-			})
+		var err error
+		dataOffsets, datas, dataEnd, err = m.segmentOffsets(exprs, lengths, exec.ErrDataSegmentDoesNotFit)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
 
 	return elementOffsets, dataOffsets, t.Execute(w, map[string]interface{}{
-		"Name":     m.name,
-		"Elements": elements,
-		"Data":     datas,
+		"Name":        m.name,
+		"Mem0":        m.ident("mem0"),
+		"HasElements": elementsEnd != 0 || len(elements) != 0,
+		"Elements":    elements,
+		"ElementsEnd": elementsEnd,
+		"HasData":     dataEnd != 0 || len(datas) != 0,
+		"Data":        datas,
+		"DataEnd":     dataEnd,
 	})
 }
 
@@ -1082,27 +1491,37 @@ func {{$moduleName}}_initTable_{{$i}}_{{$j}}(m *{{$moduleName}}Instance) {
 
 func (m *moduleCompiler) emitInitMemory(w io.Writer, offsets []string) error {
 	t := template.Must(template.New("InitMemory").Parse(`func (m *{{.Name}}Instance) initMemory() {
-	{{if .Data -}}
-	bytes := m.mem0.Bytes()
-	{{range $i, $e := .Data -}}
-	copy(bytes[{{$e.Offset}}:], {{printf "%#v" $e.Data}})
+	{{$moduleName := .Name}}
+	{{range $i, $chunk := .Chunks -}}
+	{{$moduleName}}_initMemory_{{$i}}(m)
+	{{end -}}
+}
+
+{{range $i, $chunk := .Chunks -}}
+func {{$moduleName}}_initMemory_{{$i}}(m *{{$moduleName}}Instance) {
+	bytes := m.{{$.Mem0}}.Bytes()
+	{{range $j, $e := $chunk -}}
+	copy(bytes[{{$e.Offset}}:], {{printf "%q" $e.Data}})
 	{{end -}}
-	{{- end}}
 }
 
+{{end -}}
 `))
 
 	type data struct {
 		Offset string
 		Data   []byte
 	}
-	var datas []data
+	var chunks [][]data
 	if m.module.Data != nil {
 		for i, e := range m.module.Data.Entries {
 			if len(e.Data) == 0 {
 				continue
 			}
-			datas = append(datas, data{
+			if len(chunks) == 0 || len(chunks[len(chunks)-1]) == 256 {
+				chunks = append(chunks, nil)
+			}
+			chunks[len(chunks)-1] = append(chunks[len(chunks)-1], data{
 				Offset: offsets[i],
 				Data:   e.Data,
 			})
@@ -1110,9 +1529,9 @@ func (m *moduleCompiler) emitInitMemory(w io.Writer, offsets []string) error {
 	}
 
 	return t.Execute(w, map[string]interface{}{
-		"Name":              m.name,
-		"ImportedFunctions": m.importedFunctions,
-		"Data":              datas,
+		"Name":   m.name,
+		"Mem0":   m.ident("mem0"),
+		"Chunks": chunks,
 	})
 }
 
@@ -1204,7 +1623,7 @@ func (m *{{.Name}}Instance) callFunction({{.ThreadParam}}function exec.Function,
 	function.UncheckedCall(t, args, results)
 }
 
-func (m *{{.Name}}Instance) i32Bool(b bool) int32 {
+func (m *{{.Name}}Instance) {{.I32Bool}}(b bool) int32 {
 	if b {
 		return 1
 	}
@@ -1221,35 +1640,37 @@ var _ = unsafe.Pointer(uintptr(0))
 		threadParam = ""
 	}
 
-	return t.Execute(w, map[string]interface{}{
+	err := t.Execute(w, map[string]interface{}{
 		"Name":         m.name,
 		"ExportedName": m.exportedName,
+		"I32Bool":      m.ident("i32Bool"),
 		"ThreadParam":  threadParam,
 	})
+	if err != nil {
+		return err
+	}
+	return m.emitAssemblyHelpers(w)
 }
 
-func (m *moduleCompiler) emitFunctions(w io.Writer) error {
+func (m *moduleCompiler) emitImportedFunctions(w io.Writer) error {
 	for i, f := range m.importedFunctions {
 		if err := m.emitImportedFunction(w, uint32(i), f); err != nil {
 			return err
 		}
 	}
-
-	for _, f := range m.functions {
-		if err := f.emit(w); err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
 func (m *moduleCompiler) emitMain(w io.Writer) error {
 	t := template.Must(template.New("Main").Parse(`func main() {
-	wasi.Main({{.ExportedName}})
+	{{.Runtime}}.Main({{.ExportedName}})
 }
 `))
 
-	return t.Execute(w, map[string]interface{}{"ExportedName": m.exportedName})
+	return t.Execute(w, map[string]interface{}{
+		"Runtime":      path.Base(m.runtimePackage()),
+		"ExportedName": m.exportedName,
+	})
 }
 
 func (m *moduleCompiler) emitFunctionType(w io.Writer, sig wasm.FunctionSig, typeidx uint32, name string) error {
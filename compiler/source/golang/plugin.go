@@ -0,0 +1,73 @@
+package golang
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/validate"
+)
+
+// newPluginCompiler returns a compiler for a module that is compiled into a plugin.
+func newPluginCompiler(name string, module *wasm.Module, options *Options) *moduleCompiler {
+	// The names of the module's declarations must not collide with the plugin's symbols.
+	name = identName(name)
+	if exported := exportName(name); exported == "ModuleDefinition" || exported == "ModuleHash" {
+		name += "Module"
+	}
+
+	compiler := &moduleCompiler{
+		isPlugin:     true,
+		packageName:  "main",
+		name:         unexportName(name),
+		exportedName: exportName(name),
+		module:       module,
+	}
+	compiler.pluginHash, _ = wasm.ContentHash(module)
+	options.apply(compiler)
+	return compiler
+}
+
+// CompilePlugin compiles the given module into Go source code that is suitable for building with
+// -buildmode=plugin and writes the source to the given writer. The source will be contained in package main, and
+// will export two symbols: ModuleDefinition, an exec.ModuleDefinition for the module, and ModuleHash, the module's
+// content hash as returned by wasm.ContentHash. ModuleHash is empty if the module cannot be hashed.
+//
+// A plugin can only be loaded by a host that was built with the same version of this module as the plugin.
+func CompilePlugin(w io.Writer, name string, module *wasm.Module, options *Options) error {
+	if err := validate.ValidateModule(module, true); err != nil {
+		return err
+	}
+
+	compiler := newPluginCompiler(name, module, options)
+	if err := compiler.compile(); err != nil {
+		return err
+	}
+	return compiler.emit(w)
+}
+
+// CompilePluginDirectory compiles the given module into Go source code that is suitable for building with
+// -buildmode=plugin and writes the source to a set of files in the given directory. See CompilePlugin and
+// CompileModuleDirectory for details.
+func CompilePluginDirectory(dir OutputDirectory, name string, module *wasm.Module, options *Options) error {
+	if err := validate.ValidateModule(module, true); err != nil {
+		return err
+	}
+
+	return newPluginCompiler(name, module, options).compileDirectory(dir)
+}
+
+func (m *moduleCompiler) emitPluginSymbols(w io.Writer) error {
+	t := template.Must(template.New("Plugin").Parse(`
+// ModuleDefinition is the definition of the module compiled into this plugin.
+var ModuleDefinition exec.ModuleDefinition = {{.ExportedName}}
+
+// ModuleHash is the content hash of the module compiled into this plugin.
+var ModuleHash = {{printf "%q" .Hash}}
+`))
+
+	return t.Execute(w, map[string]interface{}{
+		"ExportedName": m.exportedName,
+		"Hash":         m.pluginHash,
+	})
+}
@@ -3,10 +3,13 @@ package golang
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
 	"text/template"
@@ -14,12 +17,45 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/pgavlin/warp/internal/pprof"
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 	"github.com/pgavlin/warp/wast"
 )
 
+var allPasses = &Options{
+	ConstantPropagation:      true,
+	CopyPropagation:          true,
+	DeadCodeElimination:      true,
+	RedundantLoadElimination: true,
+	BoundsCheckElimination:   true,
+}
+
 func testModule(t *testing.T, def *wasm.Module, entrypoint string, expected ...uint64) {
+	testModuleWithOptions(t, def, nil, entrypoint, expected...)
+}
+
+func testModuleWithOptions(t *testing.T, def *wasm.Module, options *Options, entrypoint string, expected ...uint64) {
+	testCompiledModule(t, entrypoint, expected, func(dir, importPath string) error {
+		var source bytes.Buffer
+		if err := CompileModule(&source, "test", "test", def, options); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+	})
+}
+
+func testModuleDirectory(t *testing.T, def *wasm.Module, options *Options, entrypoint string, expected ...uint64) {
+	testCompiledModule(t, entrypoint, expected, func(dir, importPath string) error {
+		o := *options
+		o.ImportPath = importPath
+		return CompileModuleDirectory(Directory(dir), "test", "test", def, &o)
+	})
+}
+
+// testCompiledModule calls compile to write a compiled module to a temporary directory inside this package, then
+// tests the module by calling its entrypoint.
+func testCompiledModule(t *testing.T, entrypoint string, expected []uint64, compile func(dir, importPath string) error) {
 	testT := template.Must(template.New("module_test.go").Parse(`package test
 
 import (
@@ -56,18 +92,20 @@ func TestCompiledModule(t *testing.T) {
 		expected = []uint64{}
 	}
 
-	var source bytes.Buffer
-	err := CompileModule(&source, "test", "test", def, nil)
-	require.NoError(t, err)
-
 	var test bytes.Buffer
-	err = testT.Execute(&test, map[string]interface{}{
+	err := testT.Execute(&test, map[string]interface{}{
 		"Entrypoint": entrypoint,
 		"Expected":   expected,
 	})
 	require.NoError(t, err)
 
-	err = os.Mkdir("test", 0700)
+	testCompiledSource(t, test.Bytes(), compile)
+}
+
+// testCompiledSource calls compile to write a compiled module to a temporary directory inside this package, then
+// runs the given test file against the module. Any flags are passed to go test.
+func testCompiledSource(t *testing.T, test []byte, compile func(dir, importPath string) error, flags ...string) {
+	err := os.Mkdir("test", 0700)
 	if !os.IsExist(err) {
 		require.NoError(t, err)
 	}
@@ -75,13 +113,13 @@ func TestCompiledModule(t *testing.T) {
 	dir, err := ioutil.TempDir("test", "source_test")
 	require.NoError(t, err)
 
-	err = ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+	err = compile(dir, "github.com/pgavlin/warp/compiler/source/golang/"+filepath.ToSlash(dir))
 	require.NoError(t, err)
 
-	err = ioutil.WriteFile(filepath.Join(dir, "module_test.go"), test.Bytes(), 0600)
+	err = ioutil.WriteFile(filepath.Join(dir, "module_test.go"), test, 0600)
 	require.NoError(t, err)
 
-	cmd := exec.Command("go", "test", ".")
+	cmd := exec.Command("go", append(append([]string{"test"}, flags...), ".")...)
 	cmd.Dir = dir
 	output, err := cmd.CombinedOutput()
 	if !assert.NoError(t, err) {
@@ -102,6 +140,879 @@ func TestSpillLiveAcross(t *testing.T) {
 	testModule(t, SpillLiveAcross, "main", 42)
 }
 
+func TestOptimizedFibRecursive(t *testing.T) {
+	testModuleWithOptions(t, FibRecursive, allPasses, "app_main", 9227465)
+}
+
+func TestOptimizedSpillLiveAcross(t *testing.T) {
+	testModuleWithOptions(t, SpillLiveAcross, allPasses, "main", 42)
+}
+
+func TestOptimizations(t *testing.T) {
+	options := map[string]*Options{
+		"None":                     nil,
+		"ConstantPropagation":      {ConstantPropagation: true},
+		"CopyPropagation":          {CopyPropagation: true},
+		"DeadCodeElimination":      {DeadCodeElimination: true},
+		"RedundantLoadElimination": {RedundantLoadElimination: true},
+		"BoundsCheckElimination":   {BoundsCheckElimination: true},
+		"All":                      allPasses,
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testModuleWithOptions(t, Optimizations, options, "main", 137)
+		})
+	}
+}
+
+func TestInlining(t *testing.T) {
+	options := map[string]*Options{
+		"None":    nil,
+		"Inline":  {InlineThreshold: DefaultInlineThreshold},
+		"Tiny":    {InlineThreshold: 8},
+		"Profile": {InlineThreshold: 8, InlineProfile: map[uint32]int64{0: 100, 1: 1, 2: 100}},
+		"All": {
+			ConstantPropagation:      true,
+			CopyPropagation:          true,
+			DeadCodeElimination:      true,
+			RedundantLoadElimination: true,
+			InlineThreshold:          DefaultInlineThreshold,
+		},
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testModuleWithOptions(t, Inlining, options, "main", 28)
+		})
+	}
+}
+
+func TestUnreachableCodeElimination(t *testing.T) {
+	var source bytes.Buffer
+	err := CompileModule(&source, "test", "test", UnreachableCode, &Options{DeadCodeElimination: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(source.String(), "exec.TrapUnreachable"))
+	assert.NotContains(t, source.String(), "test_f1(m, t)")
+
+	testModule(t, UnreachableCode, "main", 54)
+	testModuleWithOptions(t, UnreachableCode, &Options{DeadCodeElimination: true}, "main", 54)
+	testModuleWithOptions(t, UnreachableCode, allPasses, "main", 54)
+}
+
+func TestBoundsCheckElimination(t *testing.T) {
+	var source bytes.Buffer
+	err := CompileModule(&source, "test", "test", BoundsChecks, &Options{BoundsCheckElimination: true})
+	require.NoError(t, err)
+	assert.Contains(t, source.String(), "m.mem0.Check(")
+	assert.Contains(t, source.String(), "m.mem0.UncheckedUint32(")
+	assert.Contains(t, source.String(), "m.mem0.UncheckedPutUint32(")
+
+	// Raw pointers are already unchecked.
+	source.Reset()
+	err = CompileModule(&source, "test", "test", BoundsChecks, &Options{BoundsCheckElimination: true, UseRawPointers: true})
+	require.NoError(t, err)
+	assert.NotContains(t, source.String(), "m.mem0.Check(")
+
+	testModule(t, BoundsChecks, "main", 12)
+	testModuleWithOptions(t, BoundsChecks, &Options{BoundsCheckElimination: true}, "main", 12)
+	testModuleWithOptions(t, BoundsChecks, allPasses, "main", 12)
+}
+
+func TestLoopBoundsCheckElimination(t *testing.T) {
+	var source bytes.Buffer
+	err := CompileModule(&source, "test", "test", LoopBoundsChecks, &Options{BoundsCheckElimination: true})
+	require.NoError(t, err)
+	assert.Contains(t, source.String(), "m.mem0.InBounds(")
+	assert.Contains(t, source.String(), "m.mem0.UncheckedUint32(")
+	assert.Contains(t, source.String(), "m.mem0.UncheckedPutUint32(")
+
+	testModule(t, LoopBoundsChecks, "main", 212)
+	testModuleWithOptions(t, LoopBoundsChecks, &Options{BoundsCheckElimination: true}, "main", 212)
+	testModuleWithOptions(t, LoopBoundsChecks, allPasses, "main", 212)
+
+	// A loop that runs out of bounds must trap at the same access as it would without the pass, after performing the
+	// stores that precede it.
+	test := []byte(`package test
+
+import (
+	"testing"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledModule(t *testing.T) {
+	store := exec.NewStore(exec.MapResolver{
+		"test": Test,
+	})
+
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+	fill, err := mod.GetFunction("fill")
+	require.NoError(t, err)
+	mem, err := mod.GetMemory("memory")
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	defer thread.Close()
+
+	assert.PanicsWithValue(t, exec.TrapOutOfBoundsMemoryAccess, func() {
+		fill.UncheckedCall(&thread, []uint64{65504, 16}, nil)
+	})
+	assert.Equal(t, uint32(7), mem.Uint32At(65532))
+}
+`)
+	testCompiledSource(t, test, func(dir, importPath string) error {
+		var source bytes.Buffer
+		if err := CompileModule(&source, "test", "test", LoopBoundsChecks, &Options{BoundsCheckElimination: true}); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+	})
+}
+
+func TestCompileModuleDirectory(t *testing.T) {
+	options := map[string]*Options{
+		"OneFile":            {},
+		"FunctionsPerFile":   {FunctionsPerFile: 2},
+		"FilesPerPackage":    {FunctionsPerFile: 2, FilesPerPackage: 2},
+		"FunctionPerPackage": {FunctionsPerFile: 1, FilesPerPackage: 1, UseRawPointers: true},
+		"All": {
+			ConstantPropagation:      true,
+			CopyPropagation:          true,
+			DeadCodeElimination:      true,
+			RedundantLoadElimination: true,
+			BoundsCheckElimination:   true,
+			FunctionsPerFile:         1,
+			FilesPerPackage:          2,
+		},
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testModuleDirectory(t, FibRecursive, options, "app_main", 9227465)
+			testModuleDirectory(t, BoundsChecks, options, "main", 12)
+			testModuleDirectory(t, Sharding, options, "main", 23)
+		})
+	}
+}
+
+func TestCompileModuleDirectoryLayout(t *testing.T) {
+	files := func(options *Options) []string {
+		dir, err := ioutil.TempDir("", "source_test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		err = CompileModuleDirectory(Directory(dir), "test", "test", BoundsChecks, options)
+		require.NoError(t, err)
+
+		var files []string
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(dir, path)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return files
+	}
+
+	assert.Equal(t, []string{"functions_0.go", "module.go"}, files(nil))
+	assert.Equal(t, []string{"functions_0.go", "functions_1.go", "functions_2.go", "module.go"}, files(&Options{FunctionsPerFile: 2}))
+	assert.Equal(t, []string{
+		"internal/functions0/functions_0.go",
+		"internal/functions0/functions_1.go",
+		"internal/functions1/functions_2.go",
+		"internal/shared/module.go",
+		"module.go",
+	}, files(&Options{FunctionsPerFile: 2, FilesPerPackage: 2, ImportPath: "example.com/test"}))
+
+	err := CompileModuleDirectory(Directory(os.TempDir()), "test", "test", BoundsChecks, &Options{FilesPerPackage: 1})
+	assert.Equal(t, ErrMissingImportPath, err)
+}
+
+// createdFiles is an IncrementalDirectory that records the paths of the files that are created.
+type createdFiles struct {
+	IncrementalDirectory
+	paths []string
+}
+
+func (d *createdFiles) Create(path string) (io.WriteCloser, error) {
+	d.paths = append(d.paths, path)
+	return d.IncrementalDirectory.Create(path)
+}
+
+func TestIncrementalCompilation(t *testing.T) {
+	// Change $add to return the sum plus one.
+	changed := *Sharding
+	codeSection := *Sharding.Code
+	codeSection.Bodies = append([]wasm.FunctionBody(nil), codeSection.Bodies...)
+	codeSection.Bodies[0] = wasm.FunctionBody{Code: expr(
+		code.GlobalGet(0), code.I32Const(1), code.I32Add(), code.GlobalSet(0),
+		code.LocalGet(0), code.LocalGet(1), code.I32Add(), code.I32Const(1), code.I32Add(),
+		code.End(),
+	)}
+	changed.Code = &codeSection
+
+	dir, err := ioutil.TempDir("", "source_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	compile := func(module *wasm.Module) ([]string, *Manifest) {
+		d := &createdFiles{IncrementalDirectory: Directory(dir).(IncrementalDirectory)}
+		options := &Options{Incremental: true, FilesPerPackage: 2, ImportPath: "example.com/test"}
+		require.NoError(t, CompileModuleDirectory(d, "test", "test", module, options))
+
+		f, err := os.Open(filepath.Join(dir, ManifestPath))
+		require.NoError(t, err)
+		defer f.Close()
+
+		manifest, err := ReadManifest(f)
+		require.NoError(t, err)
+
+		sort.Strings(d.paths)
+		return d.paths, manifest
+	}
+
+	created, first := compile(Sharding)
+	require.Len(t, first.Functions, 5)
+	assert.Equal(t, append(append([]string(nil), first.Files[:len(first.Files)-1]...), "manifest.json", "module.go"), created)
+	assert.Equal(t, "internal/functions0/functions_0_"+first.Functions[0].Hash[:16]+".go", first.Functions[0].File)
+	assert.Equal(t, "internal/functions2/functions_4_"+first.Functions[4].Hash[:16]+".go", first.Functions[4].File)
+
+	// Unchanged functions are not rewritten.
+	created, second := compile(Sharding)
+	assert.Equal(t, first, second)
+	assert.Equal(t, []string{"internal/shared/module.go", "manifest.json", "module.go"}, created)
+
+	// Changed functions are rewritten, and their stale files are removed.
+	created, third := compile(&changed)
+	assert.Equal(t, []string{third.Functions[0].File, "internal/shared/module.go", "manifest.json", "module.go"}, created)
+	assert.NotEqual(t, first.Functions[0].Hash, third.Functions[0].Hash)
+	assert.Equal(t, first.Functions[1:], third.Functions[1:])
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(first.Functions[0].File)))
+	assert.True(t, os.IsNotExist(err))
+
+	// Incremental compilation must be able to read the output directory.
+	err = CompileModuleDirectory(struct{ OutputDirectory }{Directory(dir)}, "test", "test", Sharding, &Options{Incremental: true})
+	assert.Equal(t, ErrNotIncremental, err)
+
+	// Incremental compilation writes one function per file.
+	err = CompileModuleDirectory(Directory(dir), "test", "test", Sharding, &Options{Incremental: true, FunctionsPerFile: 2})
+	assert.Equal(t, ErrIncrementalFunctionsPerFile, err)
+
+	// The result of an incremental update matches the result of a full compilation.
+	testCompiledModule(t, "main", []uint64{26}, func(dir, importPath string) error {
+		options := &Options{Incremental: true, FilesPerPackage: 2, ImportPath: importPath}
+		if err := CompileModuleDirectory(Directory(dir), "test", "test", Sharding, options); err != nil {
+			return err
+		}
+		return CompileModuleDirectory(Directory(dir), "test", "test", &changed, options)
+	})
+}
+
+func TestTypedAPI(t *testing.T) {
+	testT := template.Must(template.New("module_test.go").Parse(`package test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type typedHost struct {
+	memory exec.Memory
+	table  exec.Table
+	scale  exec.Global
+	logged []int64
+}
+
+func (h *typedHost) Env_add({{.ThreadParam}}v0, v1 int32) int32 {
+	return v0 + v1
+}
+
+func (h *typedHost) Env_log({{.ThreadParam}}v0 int64) {
+	h.logged = append(h.logged, v0)
+}
+
+func (h *typedHost) Env_memory() *exec.Memory {
+	return &h.memory
+}
+
+func (h *typedHost) Env_table() *exec.Table {
+	return &h.table
+}
+
+func (h *typedHost) Env_scale() *exec.Global {
+	return &h.scale
+}
+
+type genericHost struct {
+	Memory exec.Memory
+	Table  exec.Table
+	Scale  exec.Global
+}
+
+func (h *genericHost) Add(v0, v1 int32) int32 {
+	return v0 + v1
+}
+
+func (h *genericHost) Log(v0 int64) {
+}
+
+func TestTypedAPI(t *testing.T) {
+	host := &typedHost{
+		memory: exec.NewMemory(1, 1),
+		table:  exec.NewTable(1, 1),
+		scale:  exec.NewGlobalI32(true, 10),
+	}
+	m, err := NewTestModule("test", host)
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	defer thread.Close()
+
+	assert.Equal(t, int32(13), m.Sum({{.ThreadArg}}1, 2))
+	assert.Equal(t, int32(7), m.Add({{.ThreadArg}}3, 4))
+
+	m.Store({{.ThreadArg}}8, 40)
+	m.Store({{.ThreadArg}}16, 2)
+	assert.Equal(t, uint64(40), m.Memory().Uint64At(8))
+	assert.Same(t, &host.memory, m.Memory())
+	assert.Equal(t, int64(42), m.Total())
+	assert.Equal(t, []int64{40, 42}, host.logged)
+
+	m.SetTotal(-1)
+	total, err := m.GetGlobal("total")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), total.GetI64())
+
+	assert.Equal(t, 1.5, m.Version())
+
+	x, y := m.Swap({{.ThreadArg}}float32(math.Pi), math.E)
+	assert.Equal(t, math.E, x)
+	assert.Equal(t, float32(math.Pi), y)
+
+	assert.PanicsWithValue(t, exec.TrapOutOfBoundsMemoryAccess, func() { m.Store({{.ThreadArg}}65536, 0) })
+
+	// Modules compiled with a typed API must still be usable through an exec.ImportResolver.
+	store := exec.NewStore(exec.MapResolver{
+		"env": exec.NewHostModuleDefinition(func() (*genericHost, error) {
+			return &genericHost{
+				Memory: exec.NewMemory(1, 1),
+				Table:  exec.NewTable(1, 1),
+				Scale:  exec.NewGlobalI32(true, 10),
+			}, nil
+		}),
+		"test": Test,
+	})
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+
+	sum, err := mod.GetFunction("sum")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(13)}, sum.Call(&thread, int32(1), int32(2)))
+}
+`))
+
+	options := map[string]*Options{
+		"File":              {TypedAPI: true},
+		"NoInternalThreads": {TypedAPI: true, NoInternalThreads: true},
+		"Directory":         {TypedAPI: true, FunctionsPerFile: 1, FilesPerPackage: 1},
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			threadParam, threadArg := "t *exec.Thread, ", "&thread, "
+			if options.NoInternalThreads {
+				threadParam, threadArg = "", ""
+			}
+
+			var test bytes.Buffer
+			err := testT.Execute(&test, map[string]interface{}{
+				"ThreadParam": threadParam,
+				"ThreadArg":   threadArg,
+			})
+			require.NoError(t, err)
+
+			testCompiledSource(t, test.Bytes(), func(dir, importPath string) error {
+				if options.FilesPerPackage == 0 {
+					var source bytes.Buffer
+					if err := CompileModule(&source, "test", "test", TypedAPI, options); err != nil {
+						return err
+					}
+					return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+				}
+
+				o := *options
+				o.ImportPath = importPath
+				return CompileModuleDirectory(Directory(dir), "test", "test", TypedAPI, &o)
+			})
+		})
+	}
+}
+
+func TestCompileLinkedModules(t *testing.T) {
+	test := []byte(`package test
+
+import (
+	"testing"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type importCounter int
+
+func (c *importCounter) Before(thread *exec.Thread, call *exec.Call, args []uint64) error {
+	if call.Kind == exec.CallImport {
+		*c++
+	}
+	return nil
+}
+
+func (c *importCounter) After(thread *exec.Thread, call *exec.Call, results []uint64, err error) error {
+	return err
+}
+
+func runMain(t *testing.T, store *exec.Store) exec.Module {
+	mod, err := store.InstantiateModule("main")
+	require.NoError(t, err)
+
+	main, err := mod.GetFunction("main")
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	defer thread.Close()
+
+	returns := make([]uint64, 1)
+	main.UncheckedCall(&thread, nil, returns)
+	assert.Equal(t, []uint64{18}, returns)
+	return mod
+}
+
+func TestLinkedModules(t *testing.T) {
+	mod := runMain(t, exec.NewStore(exec.MapResolver{"lib": Lib, "main": Main}))
+	m := mod.(*allocatedMain)
+	assert.NotNil(t, m.link0)
+	assert.NotNil(t, m.link1)
+
+	// Intercepted imports must not be linked.
+	var count importCounter
+	store := exec.NewStore(exec.MapResolver{"lib": Lib, "main": Main})
+	store.Intercept(&count)
+	runMain(t, store)
+	assert.Equal(t, importCounter(2), count)
+}
+`)
+
+	modules := []LinkedModule{{Name: "lib", Module: LinkedLibrary}, {Name: "main", Module: LinkedMain}}
+	for name, options := range map[string]*Options{"Default": nil, "NoInternalThreads": {NoInternalThreads: true}} {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testCompiledSource(t, test, func(dir, importPath string) error {
+				var source bytes.Buffer
+				if err := CompileLinkedModules(&source, "test", modules, options); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+			})
+		})
+	}
+
+	var source bytes.Buffer
+	err := CompileLinkedModules(&source, "test", modules, nil)
+	require.NoError(t, err)
+	assert.Contains(t, source.String(), "return lib_f0(m.link0")
+
+	err = CompileLinkedModules(&source, "test", append(modules, modules[0]), nil)
+	assert.EqualError(t, err, `duplicate module name "lib"`)
+}
+
+func TestLineDirectives(t *testing.T) {
+	testT := template.Must(template.New("module_test.go").Parse(`package test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineDirectives(t *testing.T) {
+	store := exec.NewStore(exec.MapResolver{"test": Test})
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+
+	main, err := mod.GetFunction("main")
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	wat := filepath.Join(wd, "test.disasm.wat")
+
+	// Record the lines of the disassembly that are on the stack when the trap occurs.
+	var lines []int
+	func() {
+		defer func() {
+			pcs := make([]uintptr, 64)
+			frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+			for {
+				frame, more := frames.Next()
+				if frame.File == wat {
+					lines = append(lines, frame.Line)
+				}
+				if !more {
+					break
+				}
+			}
+			assert.Equal(t, exec.TrapUnreachable, recover())
+		}()
+
+		thread := exec.NewThread(0)
+		defer thread.Close()
+
+		main.UncheckedCall(&thread, nil, make([]uint64, 1))
+	}()
+	assert.Equal(t, {{printf "%#v" .Lines}}, lines)
+}
+`))
+
+	// The trap should be attributed to the unreachable instruction, and its caller to the call instruction.
+	var wat bytes.Buffer
+	err := wast.WriteTo(&wat, LineDirectives)
+	require.NoError(t, err)
+	var lines []int
+	for _, instr := range []string{"unreachable", "call 0"} {
+		for i, line := range strings.Split(wat.String(), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), instr) {
+				lines = append(lines, i+1)
+			}
+		}
+	}
+	require.Len(t, lines, 2)
+
+	var test bytes.Buffer
+	err = testT.Execute(&test, map[string]interface{}{"Lines": lines})
+	require.NoError(t, err)
+
+	options := map[string]*Options{
+		"File":      {LineDirectives: true},
+		"Directory": {LineDirectives: true, FunctionsPerFile: 1, FilesPerPackage: 1},
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testCompiledSource(t, test.Bytes(), func(dir, importPath string) error {
+				if options.FilesPerPackage == 0 {
+					var source bytes.Buffer
+					if err := CompileModule(Format(&source), "test", "test", LineDirectives, options); err != nil {
+						return err
+					}
+					return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+				}
+
+				o := *options
+				o.ImportPath = importPath
+				return CompileModuleDirectory(FormatDirectory(Directory(dir)), "test", "test", LineDirectives, &o)
+			})
+		})
+	}
+
+	// Functions that are described by DWARF are mapped to their source.
+	f, err := os.Open(filepath.Join("..", "..", "..", "wasi", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	mod, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+
+	var source bytes.Buffer
+	err = CompileCommand(&source, "hello", mod, &Options{LineDirectives: true})
+	require.NoError(t, err)
+	assert.Contains(t, source.String(), "std/src/io/stdio.rs:951\n")
+	assert.Contains(t, source.String(), "//line hello.disasm.wat:")
+}
+
+func TestGoCommand(t *testing.T) {
+	test := []byte(`package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pgavlin/warp/go_wasm_exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	err := go_wasm_exec.Run("hello", Hello, &go_wasm_exec.Options{Stdout: &stdout})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, WebAssembly!\n", stdout.String())
+}
+`)
+
+	f, err := os.Open(filepath.Join("..", "..", "..", "go_wasm_exec", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	mod, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+
+	testCompiledSource(t, test, func(dir, importPath string) error {
+		var source bytes.Buffer
+		if err := CompileCommand(&source, "hello", mod, nil); err != nil {
+			return err
+		}
+		if !strings.Contains(source.String(), "go_wasm_exec.Main(Hello)") {
+			return fmt.Errorf("missing call to go_wasm_exec.Main")
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+	}, "-vet=off") // The Go runtime's functions are nested too deeply for vet.
+
+	// Go commands must export the functions that go_wasm_exec calls.
+	var source bytes.Buffer
+	err = CompileCommand(&source, "hello", mustParseModule(`(module
+  (import "go" "runtime.wasmExit" (func (param i32)))
+  (memory (export "mem") 1)
+  (func (export "run") (param i32 i32))
+  (func (export "resume"))
+  (func (export "getsp") (result i64) (i64.const 0)))`), nil)
+	assert.EqualError(t, err, "getsp must be of type (func (result i32))")
+}
+
+func TestCompilePlugin(t *testing.T) {
+	test := []byte(`package main
+
+import (
+	"testing"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlugin(t *testing.T) {
+	store := exec.NewStore(exec.MapResolver{"test": ModuleDefinition})
+
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+
+	main, err := mod.GetFunction("main")
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	returns := make([]uint64, 1)
+	main.UncheckedCall(&thread, nil, returns)
+	assert.Equal(t, []uint64{23}, returns)
+	assert.Equal(t, "", ModuleHash)
+}
+`)
+
+	testCompiledSource(t, test, func(dir, importPath string) error {
+		var source bytes.Buffer
+		if err := CompilePlugin(&source, "test", Sharding, nil); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "module.go"), source.Bytes(), 0600)
+	})
+	testCompiledSource(t, test, func(dir, importPath string) error {
+		options := &Options{FunctionsPerFile: 1, FilesPerPackage: 1, ImportPath: importPath}
+		return CompilePluginDirectory(Directory(dir), "test", Sharding, options)
+	})
+
+	// Binary modules are hashed, and module names must not collide with the plugin's symbols.
+	f, err := os.Open(filepath.Join("..", "..", "..", "wasi", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	mod, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+	hash, ok := wasm.ContentHash(mod)
+	require.True(t, ok)
+
+	var source bytes.Buffer
+	err = CompilePlugin(&source, "moduleDefinition", mod, nil)
+	require.NoError(t, err)
+	assert.Contains(t, source.String(), "var ModuleDefinition exec.ModuleDefinition = ModuleDefinitionModule\n")
+	assert.Contains(t, source.String(), fmt.Sprintf("var ModuleHash = %q\n", hash))
+}
+
+func TestInlineSelection(t *testing.T) {
+	cases := []struct {
+		options  *Options
+		expected []uint32
+	}{
+		{options: nil},
+		{options: &Options{InlineThreshold: DefaultInlineThreshold}, expected: []uint32{0, 1, 2, 3}},
+		{options: &Options{InlineThreshold: 8}, expected: []uint32{0, 3}},
+		{options: &Options{InlineThreshold: 8, InlineProfile: map[uint32]int64{0: 100, 1: 1, 2: 100}}, expected: []uint32{0, 2}},
+	}
+	for _, c := range cases {
+		m := moduleCompiler{name: "test", module: Inlining}
+		c.options.apply(&m)
+		require.NoError(t, m.compile())
+
+		var inlined []uint32
+		for funcidx := uint32(0); funcidx < 5; funcidx++ {
+			if _, ok := m.inlineFunctions[funcidx]; ok {
+				inlined = append(inlined, funcidx)
+			}
+		}
+		assert.Equal(t, c.expected, inlined)
+	}
+}
+
+func TestInlineSelectionError(t *testing.T) {
+	// The body of $add underflows the stack. Compilation fails instead of panicking when the body is decoded for
+	// inlining.
+	section := *Inlining.Code
+	section.Bodies = append([]wasm.FunctionBody{{Code: []byte{code.OpI32Add, code.OpEnd}}}, section.Bodies[1:]...)
+	module := *Inlining
+	module.Code = &section
+
+	m := moduleCompiler{name: "test", module: &module}
+	(&Options{InlineThreshold: DefaultInlineThreshold}).apply(&m)
+	assert.Error(t, m.compile())
+}
+
+func TestReadInlineProfile(t *testing.T) {
+	profile := &pprof.Profile{
+		SampleType: []pprof.ValueType{{Type: "calls", Unit: "count"}, {Type: "time", Unit: "nanoseconds"}},
+		Sample: []pprof.Sample{
+			{Location: []uint64{1}, Value: []int64{1, 100}},
+			{Location: []uint64{2, 1}, Value: []int64{4, 10}},
+			{Location: []uint64{3, 2, 1}, Value: []int64{16, 10}},
+			{Location: []uint64{3, 1}, Value: []int64{2, 10}},
+			{Location: []uint64{4, 1}, Value: []int64{8, 10}},
+		},
+		Location: []pprof.Location{
+			{ID: 1, Line: []pprof.Line{{Function: 1}}},
+			{ID: 2, Line: []pprof.Line{{Function: 2}}},
+			{ID: 3, Line: []pprof.Line{{Function: 3}}},
+			{ID: 4, Line: []pprof.Line{{Function: 4}}},
+		},
+		Function: []pprof.Function{
+			{ID: 1, Name: "bench/inlining.main"},
+			{ID: 2, Name: "bench/inlining.func 2"},
+			{ID: 3, Name: "bench/inlining.func 0"},
+			{ID: 4, Name: "other.unknown"},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, profile.Write(&buf))
+
+	calls, err := ReadInlineProfile(&buf, Inlining)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]int64{0: 18, 2: 4, 4: 1}, calls)
+}
+
+func TestCompileAssembly(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("assembly is only supported on amd64")
+	}
+
+	options := map[string]*Options{
+		"All":         {AllAssembly: true},
+		"Selected":    {AssemblyFunctions: map[uint32]bool{0: true, 2: true}, FunctionsPerFile: 2},
+		"Optimized":   {AllAssembly: true, ConstantPropagation: true, CopyPropagation: true, DeadCodeElimination: true, RedundantLoadElimination: true, BoundsCheckElimination: true},
+		"Inlined":     {AllAssembly: true, InlineThreshold: DefaultInlineThreshold},
+		"Incremental": {AllAssembly: true, Incremental: true},
+	}
+	for name, options := range options {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			testModuleDirectory(t, FibRecursive, options, "app_main", 9227465)
+			testModuleDirectory(t, BoundsChecks, options, "main", 12)
+			testModuleDirectory(t, Sharding, options, "main", 23)
+			testModuleDirectory(t, Inlining, options, "main", 28)
+			testModuleDirectory(t, UnreachableCode, options, "main", 54)
+		})
+	}
+
+	noThreads := &Options{AllAssembly: true, NoInternalThreads: true}
+	testModuleDirectory(t, FibRecursive, noThreads, "app_main", 9227465)
+	testModuleDirectory(t, Inlining, noThreads, "main", 28)
+}
+
+func TestAssemblySelection(t *testing.T) {
+	selected := func(options *Options) []uint32 {
+		m := moduleCompiler{name: "test", module: Inlining}
+		options.apply(&m)
+		require.NoError(t, m.compile())
+
+		var functions []uint32
+		for funcidx := uint32(0); funcidx < 5; funcidx++ {
+			if m.asmFunctions[funcidx] {
+				functions = append(functions, funcidx)
+				assert.NotEmpty(t, m.functions[funcidx].asm)
+			} else {
+				assert.Empty(t, m.functions[funcidx].asm)
+			}
+		}
+		return functions
+	}
+
+	assert.Empty(t, selected(nil))
+	assert.Equal(t, []uint32{0, 1, 2, 3, 4}, selected(&Options{AllAssembly: true}))
+	assert.Equal(t, []uint32{1, 4}, selected(&Options{AssemblyFunctions: map[uint32]bool{1: true, 4: true}}))
+	assert.Equal(t, []uint32{0, 2}, selected(&Options{AssemblyProfile: map[uint32]int64{0: 100, 1: 1, 2: 100}}))
+
+	functions, err := LookupFunctions(Inlining, []string{"main", "func 1", "bench/inlining.func 3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]bool{1: true, 3: true, 4: true}, functions)
+
+	_, err = LookupFunctions(Inlining, []string{"missing"})
+	assert.Error(t, err)
+
+	// The annotation section lists function names.
+	annotated := *Inlining
+	annotated.Customs = []*wasm.SectionCustom{{Name: AssemblySectionName, Data: []byte{2, 4, 'm', 'a', 'i', 'n', 6, 'f', 'u', 'n', 'c', ' ', '0'}}}
+	functions, err = ReadAssemblyAnnotations(&annotated)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]bool{0: true, 4: true}, functions)
+
+	annotated.Customs = []*wasm.SectionCustom{{Name: AssemblySectionName, Data: []byte{1, 4, 'm', 'a'}}}
+	_, err = ReadAssemblyAnnotations(&annotated)
+	assert.Error(t, err)
+
+	functions, err = ReadAssemblyAnnotations(Inlining)
+	assert.NoError(t, err)
+	assert.Nil(t, functions)
+
+	// Functions compiled to assembly require an assembly output, and cannot be sharded.
+	err = CompileModule(ioutil.Discard, "test", "test", Inlining, &Options{AllAssembly: true})
+	assert.Equal(t, ErrAssemblyOutput, err)
+
+	dir, err := ioutil.TempDir("", "source_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = CompileModuleDirectory(Directory(dir), "test", "test", Inlining, &Options{AllAssembly: true, FunctionsPerFile: 2, FilesPerPackage: 1, ImportPath: "example.com/test"})
+	assert.Equal(t, ErrShardedAssembly, err)
+
+	err = CompileModuleDirectory(FormatDirectory(Directory(dir)), "test", "test", Inlining, &Options{AssemblyFunctions: map[uint32]bool{2: true}, FunctionsPerFile: 2})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "functions_0_amd64.s"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "functions_1_amd64.s"))
+	assert.NoError(t, err)
+}
+
 func expr(instrs ...code.Instruction) []byte {
 	var buf bytes.Buffer
 	if err := code.Encode(&buf, instrs); err != nil {
@@ -282,3 +1193,254 @@ var SpillLiveAcross = &wasm.Module{
 		},
 	},
 }
+
+var Optimizations = mustParseModule(`(module
+  (memory 1)
+  (func $f (param i32) (result i32) (local i32 i32 i32)
+    ;; constants merged across if/else
+    (local.set 1 (i32.const 3))
+    (if (local.get 0)
+      (then (local.set 2 (i32.const 4)))
+      (else (local.set 2 (i32.const 4))))
+
+    ;; locals stored inside a loop are unknown at its head
+    (local.set 3 (local.get 2))
+    (loop $l
+      (local.set 3 (i32.add (local.get 3) (local.get 1)))
+      (local.set 1 (i32.add (local.get 1) (i32.const 1)))
+      (br_if $l (i32.lt_u (local.get 1) (i32.const 6))))
+
+    ;; dead stores with side effects are kept as drops
+    (local.set 2 (i32.load (i32.const 8)))
+
+    ;; copies are killed by stores to their sources
+    (local.set 2 (local.get 0))
+    (local.set 0 (i32.const 100))
+
+    ;; loads are not reused across stores
+    (i32.store (i32.const 8) (i32.const 7))
+    (local.set 1 (i32.add (i32.load (i32.const 8)) (i32.load (i32.const 8))))
+    (i32.store (i32.const 8) (i32.const 1))
+    (local.set 1 (i32.add (local.get 1) (i32.load (i32.const 8))))
+
+    ;; branches that are never taken are removed along with their labels
+    (block $b
+      (br_if $b (i32.const 0))
+      (local.set 1 (i32.add (local.get 1) (i32.const 1))))
+
+    (i32.add (i32.add (local.get 3) (local.get 1)) (i32.add (local.get 2) (local.get 0))))
+  (func (export "main") (result i32)
+    (call $f (i32.const 5))))`)
+
+var UnreachableCode = mustParseModule(`(module
+  (func $id (param i32) (result i32)
+    (local.get 0))
+  (func $never (result i32)
+    (unreachable))
+  (func $f (param i32) (result i32) (local i32)
+    ;; code after a branch that is always taken is removed, but the call's result remains used
+    (block $b
+      (call $id (local.get 0))
+      (br_if $b (i32.const 1))
+      (local.set 1)
+      (block $inner
+        (br_if $inner (local.get 0))
+        (local.set 1 (call $never))))
+
+    ;; only the arm of an if whose condition is constant is kept
+    (if (i32.const 1)
+      (then (local.set 1 (i32.add (local.get 1) (i32.const 10))))
+      (else (local.set 1 (call $never))))
+    (if (i32.const 0)
+      (then (local.set 1 (call $never)))
+      (else (local.set 1 (i32.add (local.get 1) (i32.const 20)))))
+    (if (i32.const 0)
+      (then (local.set 1 (call $never))))
+    (local.set 1 (i32.add (local.get 1)
+      (if (result i32) (i32.const 1) (then (i32.const 3)) (else (call $never)))))
+
+    ;; comparisons of constants fold to their values when used as integers
+    (local.set 1 (i32.add (local.get 1) (i32.lt_s (i32.const 1) (i32.const 2))))
+
+    ;; code after a loop whose end is unreachable is removed
+    (block $done
+      (loop $l
+        (br_if $done (i32.ge_u (local.get 1) (i32.const 50)))
+        (local.set 1 (i32.add (local.get 1) (local.get 0)))
+        (br $l))
+      (local.set 1 (call $never)))
+
+    ;; code after a return is removed
+    (if (i32.const 1)
+      (then (return (local.get 1))))
+    (call $never))
+  (func (export "main") (result i32)
+    (call $f (i32.const 5))))`)
+
+var BoundsChecks = mustParseModule(`(module
+  (memory 1)
+  (func $fill (param i32) (local i32)
+    (block $done
+      (loop $l
+        (br_if $done (i32.ge_u (local.get 1) (local.get 0)))
+        (i32.store (i32.shl (local.get 1) (i32.const 2)) (local.get 1))
+        (local.set 1 (i32.add (local.get 1) (i32.const 1)))
+        (br $l))))
+  (func $sum3 (param i32) (result i32)
+    ;; the three loads share a single check
+    (i32.add
+      (i32.add (i32.load (local.get 0)) (i32.load offset=4 (local.get 0)))
+      (i32.load offset=8 (local.get 0))))
+  (func $swap (param i32) (local i32)
+    ;; the stores are dominated by the loads
+    (local.set 1 (i32.load offset=4 (local.get 0)))
+    (i32.store offset=4 (local.get 0) (i32.load (local.get 0)))
+    (i32.store (local.get 0) (local.get 1)))
+  (func $cond (param i32 i32) (result i32)
+    ;; the load in the if does not dominate the final load
+    (if (local.get 1)
+      (then (drop (i32.load offset=16 (local.get 0)))))
+    (i32.load offset=16 (local.get 0)))
+  (func (export "main") (result i32)
+    (call $fill (i32.const 8))
+    (call $swap (i32.const 0))
+    (i32.add
+      (i32.add (call $sum3 (i32.const 4)) (call $sum3 (i32.const 0)))
+      (call $cond (i32.const 0) (i32.const 1)))))`)
+
+var LoopBoundsChecks = mustParseModule(`(module
+  (memory (export "memory") 1)
+  (func $sum (param i32) (result i32) (local i32 i32)
+    ;; the loads are checked once before the loop
+    (local.set 1 (i32.const 0))
+    (loop $l
+      (local.set 2 (i32.add (local.get 2) (i32.load (i32.add (local.get 0) (i32.shl (local.get 1) (i32.const 2))))))
+      (local.set 1 (i32.add (local.get 1) (i32.const 1)))
+      (br_if $l (i32.lt_s (local.get 1) (i32.const 16))))
+    (local.get 2))
+  (func $fill (export "fill") (param i32 i32) (local i32)
+    ;; the exit branch bounds the index of the store
+    (block $done
+      (loop $l
+        (br_if $done (i32.ge_u (local.get 2) (local.get 1)))
+        (i32.store (i32.add (local.get 0) (i32.mul (local.get 2) (i32.const 4))) (local.get 2))
+        (local.set 2 (i32.add (local.get 2) (i32.const 1)))
+        (br $l))))
+  (func $find (param i32) (result i32) (local i32)
+    ;; the bound is too large for the pre-loop check to pass, so the original loop runs
+    (block $done
+      (loop $l
+        (br_if $done (i32.eq (i32.load8_u (local.get 1)) (local.get 0)))
+        (local.set 1 (i32.add (local.get 1) (i32.const 1)))
+        (br_if $l (i32.lt_u (local.get 1) (i32.const -1)))))
+    (local.get 1))
+  (func (export "main") (result i32)
+    (call $fill (i32.const 64) (i32.const 16))
+    (i32.add (call $sum (i32.const 64)) (call $find (i32.const 7)))))`)
+
+var Sharding = mustParseModule(`(module
+  (type $binop (func (param i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (global $counter (mut i32) (i32.const 0))
+  (global $base (export "base") (mut i32) (i32.const 16))
+  (table 2 funcref)
+  (elem (i32.const 0) $add $mul)
+  (func $add (type $binop)
+    (global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+    (i32.add (local.get 0) (local.get 1)))
+  (func $mul (type $binop)
+    (global.set $counter (i32.add (global.get $counter) (i32.const 1)))
+    (i32.mul (local.get 0) (local.get 1)))
+  (func $apply (param i32 i32 i32) (result i32)
+    (call_indirect (type $binop) (local.get 1) (local.get 2) (local.get 0)))
+  (func $init
+    (i32.store (global.get $base) (i32.const 3)))
+  (func (export "main") (result i32)
+    (i32.add
+      (call $apply (i32.const 1) (i32.load (global.get $base)) (call $apply (i32.const 0) (i32.const 2) (i32.const 5)))
+      (global.get $counter)))
+  (start $init))`)
+
+var TypedAPI = mustParseModule(`(module
+  (import "env" "add" (func $add (param i32 i32) (result i32)))
+  (import "env" "log" (func $log (param i64)))
+  (import "env" "memory" (memory 1))
+  (import "env" "table" (table 1 funcref))
+  (import "env" "scale" (global $scale i32))
+  (global $total (export "total") (mut i64) (i64.const 0))
+  (global $version (export "version") f64 (f64.const 1.5))
+  (elem (i32.const 0) $add)
+  (func (export "sum") (param i32 i32) (result i32)
+    (call_indirect (param i32 i32) (result i32)
+      (call $add (local.get 0) (local.get 1)) (global.get $scale) (i32.const 0)))
+  (func (export "store") (param i32 i64)
+    (i64.store (local.get 0) (local.get 1))
+    (global.set $total (i64.add (global.get $total) (local.get 1)))
+    (call $log (global.get $total)))
+  (func (export "swap") (param f32 f64) (result f64 f32)
+    (local.get 1) (local.get 0))
+  (export "add" (func $add)))`)
+
+var LinkedLibrary = mustParseModule(`(module
+  (memory (export "memory") 1)
+  (global $calls (export "calls") (mut i32) (i32.const 0))
+  (table (export "table") 1 funcref)
+  (elem (i32.const 0) $double)
+  (func $double (export "double") (param i32) (result i32)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (i32.mul (local.get 0) (i32.const 2)))
+  (func (export "store") (param i32 i32)
+    (i32.store (local.get 0) (local.get 1))))`)
+
+var LinkedMain = mustParseModule(`(module
+  (type $unop (func (param i32) (result i32)))
+  (import "lib" "double" (func $double (type $unop)))
+  (import "lib" "store" (func $store (param i32 i32)))
+  (import "lib" "memory" (memory 1))
+  (import "lib" "calls" (global $calls (mut i32)))
+  (import "lib" "table" (table 1 funcref))
+  (func (export "main") (result i32)
+    (call $store (i32.const 8) (call $double (i32.const 5)))
+    (i32.add
+      (i32.add (i32.load (i32.const 8)) (call_indirect (type $unop) (i32.const 3) (i32.const 0)))
+      (global.get $calls))))`)
+
+var LineDirectives = mustParseModule(`(module
+  (func $fail (param i32) (result i32)
+    local.get 0
+    if
+      unreachable
+    end
+    local.get 0)
+  (func (export "main") (result i32)
+    i32.const 1
+    call $fail))`)
+
+var Inlining = mustParseModule(`(module
+  (memory 1)
+  (func $add (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (func $abs (param i32) (result i32) (local i32)
+    (local.set 1 (local.get 0))
+    (if (i32.ge_s (local.get 1) (i32.const 0))
+      (then (return (local.get 1))))
+    (i32.sub (i32.const 0) (local.get 1)))
+  (func $sum (param i32) (result i32) (local i32)
+    ;; the accumulator must be cleared on each call
+    (block $done
+      (loop $l
+        (br_if $done (i32.eqz (local.get 0)))
+        (local.set 1 (i32.add (local.get 1) (local.get 0)))
+        (local.set 0 (i32.sub (local.get 0) (i32.const 1)))
+        (br $l)))
+    (local.get 1))
+  (func $store (param i32 i32)
+    (i32.store (local.get 0) (local.get 1)))
+  (func (export "main") (result i32) (local i32 i32)
+    (loop $l
+      (local.set 1 (call $add (local.get 1) (call $abs (i32.sub (i32.const 2) (local.get 0)))))
+      (local.set 1 (call $add (local.get 1) (call $sum (i32.const 3))))
+      (local.set 0 (i32.add (local.get 0) (i32.const 1)))
+      (br_if $l (i32.lt_u (local.get 0) (i32.const 4))))
+    (call $store (i32.const 8) (local.get 1))
+    (i32.load (i32.const 8))))`)
@@ -19,6 +19,11 @@ import (
 )
 
 var specTest = flag.String("spec", "", "spec test to run")
+var specOptimize = flag.Bool("optimize", false, "enable all optimization passes when compiling spec modules")
+var specInline = flag.Int("inline", 0, "the inlining threshold to use when compiling spec modules")
+var specTyped = flag.Bool("typed", false, "generate typed APIs when compiling spec modules")
+var specLineDirectives = flag.Bool("line-directives", false, "emit line directives when compiling spec modules")
+var specAssembly = flag.Bool("asm", false, "compile all functions of spec modules to assembly")
 
 func TestMain(m *testing.M) {
 	flag.Parse()
@@ -249,7 +254,51 @@ func compileModuleCommand(test io.Writer, dir string, command wast.ModuleCommand
 	}
 	defer f.Close()
 
-	if err = CompileModule(f, "test", name, m, nil); err != nil {
+	var options *Options
+	if *specOptimize {
+		options = &Options{
+			ConstantPropagation:      true,
+			CopyPropagation:          true,
+			DeadCodeElimination:      true,
+			RedundantLoadElimination: true,
+			BoundsCheckElimination:   true,
+		}
+	}
+	if *specInline != 0 {
+		if options == nil {
+			options = &Options{}
+		}
+		options.InlineThreshold = *specInline
+	}
+	if *specTyped {
+		if options == nil {
+			options = &Options{}
+		}
+		options.TypedAPI = true
+	}
+	if *specLineDirectives {
+		if options == nil {
+			options = &Options{}
+		}
+		options.LineDirectives = true
+	}
+	if *specAssembly {
+		if options == nil {
+			options = &Options{}
+		}
+		options.AllAssembly = true
+
+		asmPath := filepath.Join(dir, name+"_amd64.s")
+		s, err := os.Create(asmPath)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+
+		if err = CompileModuleAssembly(f, s, "test", name, m, options); err != nil {
+			return "", fmt.Errorf("%v: %w", path, err)
+		}
+	} else if err = CompileModule(f, "test", name, m, options); err != nil {
 		return "", fmt.Errorf("%v: %w", path, err)
 	}
 
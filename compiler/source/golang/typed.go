@@ -0,0 +1,327 @@
+package golang
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/pgavlin/warp/wasm"
+)
+
+// typedImports records the names of the methods of a module's typed imports interface.
+type typedImports struct {
+	functions []string
+	globals   []string
+	memory    string
+	table     string
+}
+
+// goName returns an exported Go identifier for the given WebAssembly name.
+func goName(name string) string {
+	name = strings.TrimLeft(identName(name), "_")
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return exportName(name)
+}
+
+// uniqueName returns the given name, suffixed with underscores if necessary to make it distinct from the names in
+// used. The result is added to used.
+func uniqueName(used map[string]bool, name string) string {
+	for used[name] {
+		name += "_"
+	}
+	used[name] = true
+	return name
+}
+
+// nameTypedImports assigns a method of the typed imports interface to each of the module's imports.
+func (m *moduleCompiler) nameTypedImports() {
+	if m.module.Import == nil {
+		return
+	}
+
+	used := map[string]bool{}
+	for _, import_ := range m.module.Import.Entries {
+		name := uniqueName(used, goName(import_.ModuleName+"_"+import_.FieldName))
+		switch import_.Type.(type) {
+		case wasm.FuncImport:
+			m.typedImports.functions = append(m.typedImports.functions, name)
+		case wasm.MemoryImport:
+			m.typedImports.memory = name
+		case wasm.TableImport:
+			m.typedImports.table = name
+		case wasm.GlobalVarImport:
+			m.typedImports.globals = append(m.typedImports.globals, name)
+		}
+	}
+}
+
+// emitTypedSignature emits the parameters and results of a typed API method with the given signature.
+func (m *moduleCompiler) emitTypedSignature(w io.Writer, sig wasm.FunctionSig) error {
+	if err := printf(w, "("); err != nil {
+		return err
+	}
+	params := 0
+	if !m.noInternalThreads {
+		if err := printf(w, "t *exec.Thread"); err != nil {
+			return err
+		}
+		params++
+	}
+	for i, t := range sig.ParamTypes {
+		if err := printf(w, "%vv%d %s", comma(params), i, goType(t)); err != nil {
+			return err
+		}
+		params++
+	}
+	if err := printf(w, ")"); err != nil {
+		return err
+	}
+
+	switch len(sig.ReturnTypes) {
+	case 0:
+		return nil
+	case 1:
+		return printf(w, " %s", goType(sig.ReturnTypes[0]))
+	default:
+		if err := printf(w, " ("); err != nil {
+			return err
+		}
+		for i, t := range sig.ReturnTypes {
+			if err := printf(w, "%v%s", comma(i), goType(t)); err != nil {
+				return err
+			}
+		}
+		return printf(w, ")")
+	}
+}
+
+// emitTypedCall emits a call to the given function that forwards the thread and parameters of a function with the
+// given signature. If receiver is not empty, it is passed as the function's first argument.
+func (m *moduleCompiler) emitTypedCall(w io.Writer, function, receiver string, sig wasm.FunctionSig) error {
+	if err := printf(w, "\t"); err != nil {
+		return err
+	}
+	if len(sig.ReturnTypes) > 0 {
+		if err := printf(w, "return "); err != nil {
+			return err
+		}
+	}
+	if err := printf(w, "%s(%s", function, receiver); err != nil {
+		return err
+	}
+	params := 0
+	if receiver != "" {
+		params++
+	}
+	if !m.noInternalThreads {
+		if err := printf(w, "%vt", comma(params)); err != nil {
+			return err
+		}
+		params++
+	}
+	for i := range sig.ParamTypes {
+		if err := printf(w, "%vv%d", comma(params), i); err != nil {
+			return err
+		}
+		params++
+	}
+	if err := printf(w, ")\n"); err != nil {
+		return err
+	}
+	if len(sig.ReturnTypes) == 0 {
+		return printf(w, "\treturn\n")
+	}
+	return nil
+}
+
+// emitTypedImportCall emits the fast path of an imported function's thunk, which calls the corresponding method of
+// the typed imports interface if the module was instantiated with one.
+func (m *moduleCompiler) emitTypedImportCall(w io.Writer, index uint32, sig wasm.FunctionSig) error {
+	if err := printf(w, "if m.imports != nil {\n"); err != nil {
+		return err
+	}
+	if err := m.emitTypedCall(w, "m.imports."+m.typedImports.functions[index], "", sig); err != nil {
+		return err
+	}
+	return printf(w, "}\n")
+}
+
+// emitTypedAPI emits the module's typed API: an interface that describes the module's imports, a wrapper around
+// the module's instances that exposes its exports as Go methods, and a constructor for the wrapper.
+func (m *moduleCompiler) emitTypedAPI(w io.Writer) error {
+	if err := m.emitTypedImportsInterface(w); err != nil {
+		return err
+	}
+
+	t := template.Must(template.New("TypedModule").Parse(`// {{.ExportedName}}Module is an instance of the {{.ExportedName}} module that exposes the module's exports as Go methods.
+type {{.ExportedName}}Module struct {
+	*{{.Name}}Instance
+}
+
+// New{{.ExportedName}}Module allocates and instantiates an instance of the {{.ExportedName}} module with the given name.
+// The module's imports are provided by the given implementation of {{.ExportedName}}Imports, which must not be nil if
+// the module has any imports.
+func New{{.ExportedName}}Module(name string, imports {{.ExportedName}}Imports) (*{{.ExportedName}}Module, error) {
+	a, err := allocate{{.ExportedName}}(name)
+	if err != nil {
+		return nil, err
+	}
+	m := a.(*allocated{{.ExportedName}})
+	m.imports = imports
+	if _, err := m.Instantiate(nil); err != nil {
+		return nil, err
+	}
+	return &{{.ExportedName}}Module{ {{- .Name}}Instance: m.{{.Name}}Instance}, nil
+}
+
+{{if .HasMemory -}}
+// Memory returns the module's memory.
+func (m *{{.ExportedName}}Module) Memory() *exec.Memory {
+	return m.{{.Mem0}}
+}
+
+{{end -}}
+`))
+
+	hasMemory := m.importedMemory != nil || m.module.Memory != nil && len(m.module.Memory.Entries) != 0
+	err := t.Execute(w, map[string]interface{}{
+		"Name":         m.name,
+		"ExportedName": m.exportedName,
+		"Mem0":         m.ident("mem0"),
+		"HasMemory":    hasMemory,
+	})
+	if err != nil {
+		return err
+	}
+
+	if m.module.Export == nil {
+		return nil
+	}
+
+	// Reserve the names of the methods and fields promoted from the module instance.
+	used := map[string]bool{
+		m.name + "Instance": true,
+		"Close":             true,
+		"Name":              true,
+		"GetFunction":       true,
+		"GetTable":          true,
+		"GetMemory":         true,
+		"GetGlobal":         true,
+		"Memory":            true,
+	}
+	for _, export := range m.module.Export.Entries {
+		switch export.Kind {
+		case wasm.ExternalFunction:
+			if err := m.emitTypedFunctionExport(w, uniqueName(used, goName(export.FieldStr)), export); err != nil {
+				return err
+			}
+		case wasm.ExternalGlobal:
+			getter := uniqueName(used, goName(export.FieldStr))
+			setter := ""
+			if type_, _ := m.GetGlobalType(export.Index); type_.Mutable {
+				setter = uniqueName(used, "Set"+getter)
+			}
+			if err := m.emitTypedGlobalExport(w, getter, setter, export); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *moduleCompiler) emitTypedImportsInterface(w io.Writer) error {
+	if err := printf(w, "// %sImports is implemented by hosts that provide the imports of the %[1]s module.\n", m.exportedName); err != nil {
+		return err
+	}
+	if err := printf(w, "type %sImports interface {\n", m.exportedName); err != nil {
+		return err
+	}
+
+	if m.module.Import != nil {
+		functions, globals := 0, 0
+		for _, import_ := range m.module.Import.Entries {
+			var err error
+			switch type_ := import_.Type.(type) {
+			case wasm.FuncImport:
+				name := m.typedImports.functions[functions]
+				functions++
+
+				err = printf(w, "\t// %s implements the function %q imported from %q.\n\t%[1]s", name, import_.FieldName, import_.ModuleName)
+				if err == nil {
+					err = m.emitTypedSignature(w, m.module.Types.Entries[int(type_.Type)])
+				}
+			case wasm.MemoryImport:
+				err = printf(w, "\t// %s returns the memory %q imported from %q.\n\t%[1]s() *exec.Memory", m.typedImports.memory, import_.FieldName, import_.ModuleName)
+			case wasm.TableImport:
+				err = printf(w, "\t// %s returns the table %q imported from %q.\n\t%[1]s() *exec.Table", m.typedImports.table, import_.FieldName, import_.ModuleName)
+			case wasm.GlobalVarImport:
+				name := m.typedImports.globals[globals]
+				globals++
+
+				err = printf(w, "\t// %s returns the global %q imported from %q.\n\t%[1]s() *exec.Global", name, import_.FieldName, import_.ModuleName)
+			}
+			if err == nil {
+				err = printf(w, "\n")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return printf(w, "}\n\n")
+}
+
+func (m *moduleCompiler) emitTypedFunctionExport(w io.Writer, name string, export wasm.ExportEntry) error {
+	sig, _ := m.GetFunctionSignature(export.Index)
+
+	if err := printf(w, "// %s calls the exported function %q.\nfunc (m *%sModule) %[1]s", name, export.FieldStr, m.exportedName); err != nil {
+		return err
+	}
+	if err := m.emitTypedSignature(w, sig); err != nil {
+		return err
+	}
+	if err := printf(w, " {\n"); err != nil {
+		return err
+	}
+	if err := emitTrapGuard(w); err != nil {
+		return err
+	}
+
+	if err := m.emitTypedCall(w, m.functionName(export.Index), fmt.Sprintf("m.%sInstance", m.name), sig); err != nil {
+		return err
+	}
+	return printf(w, "}\n\n")
+}
+
+func (m *moduleCompiler) emitTypedGlobalExport(w io.Writer, getter, setter string, export wasm.ExportEntry) error {
+	t := template.Must(template.New("TypedGlobal").Parse(`// {{.Getter}} returns the value of the exported global {{printf "%q" .FieldStr}}.
+func (m *{{.ExportedName}}Module) {{.Getter}}() {{.Type}} {
+	return m.{{.G}}{{.Index}}.Get{{.Kind}}()
+}
+
+{{if .Setter -}}
+// {{.Setter}} sets the value of the exported global {{printf "%q" .FieldStr}}.
+func (m *{{.ExportedName}}Module) {{.Setter}}(v {{.Type}}) {
+	m.{{.G}}{{.Index}}.Set{{.Kind}}(v)
+}
+
+{{end -}}
+`))
+
+	type_, _ := m.GetGlobalType(export.Index)
+	return t.Execute(w, map[string]interface{}{
+		"ExportedName": m.exportedName,
+		"Getter":       getter,
+		"Setter":       setter,
+		"FieldStr":     export.FieldStr,
+		"G":            m.ident("g"),
+		"Index":        export.Index,
+		"Type":         goType(type_.Type),
+		"Kind":         strings.ToUpper(type_.Type.String()),
+	})
+}
@@ -0,0 +1,356 @@
+package wax
+
+import (
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/willf/bitset"
+)
+
+// A boundsFact records that every effective address in the range [address, address+end) is known to be in bounds.
+// Memory never shrinks, so a fact remains true until a local or global read by its address is stored.
+type boundsFact struct {
+	end     uint64
+	locals  []int // the locals read by the address
+	globals bool  // true if the address reads globals
+}
+
+// boundsFacts maps address keys to the facts known about those addresses. The key of a constant address is the empty
+// string, and the ends of its facts are absolute. A nil boundsFacts describes unreachable code.
+type boundsFacts map[string]boundsFact
+
+func (s boundsFacts) clone() boundsFacts {
+	if s == nil {
+		return nil
+	}
+	c := make(boundsFacts, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// intersectBounds returns the facts that are known at a point reached from points described by a and b.
+func intersectBounds(a, b boundsFacts) boundsFacts {
+	switch {
+	case a == nil:
+		return b.clone()
+	case b == nil:
+		return a.clone()
+	}
+	c := boundsFacts{}
+	for k, fa := range a {
+		if fb, ok := b[k]; ok {
+			if fb.end < fa.end {
+				fa.end = fb.end
+			}
+			c[k] = fa
+		}
+	}
+	return c
+}
+
+func (s boundsFacts) prove(key string, fact boundsFact) {
+	if old, ok := s[key]; !ok || old.end < fact.end {
+		s[key] = fact
+	}
+}
+
+// kill forgets the facts whose addresses read the given locals or, if globals is true, any global.
+func (s boundsFacts) kill(locals *bitset.BitSet, globals bool) {
+	for k, fact := range s {
+		if fact.globals && globals {
+			delete(s, k)
+			continue
+		}
+		for _, l := range fact.locals {
+			if locals.Test(uint(l)) {
+				delete(s, k)
+				break
+			}
+		}
+	}
+}
+
+// A boundsFrame records the facts known at the entry to and the exits from an enclosing block.
+type boundsFrame struct {
+	block *Block
+	entry boundsFacts // the facts known on entry to the block
+	exit  boundsFacts // the facts known at the branches to the end of the block
+	else_ bool        // true if the frame's else branch has been entered
+}
+
+// A boundsGroup is a run of accesses to the same address within straight-line code that may share a single check.
+type boundsGroup struct {
+	host    *Def        // the def that contains the first access
+	first   *Expression // the first access
+	address *Use        // the address of the first access, or nil if the address is constant
+	end     uint64      // the end of the range accessed by the group
+	check   *Expression // the group's check, or nil if the group has a single access
+
+	locals  []int
+	globals bool
+}
+
+// loopEffects records the locals and globals that may be stored by a loop.
+type loopEffects struct {
+	locals  bitset.BitSet
+	globals bool
+}
+
+// accessSize returns the number of bytes accessed by the given memory load or store.
+func accessSize(x *Expression) (uint64, bool) {
+	if x.IsPseudo() {
+		return 0, false
+	}
+	switch x.Instr.Opcode {
+	case code.OpI32Load8S, code.OpI32Load8U, code.OpI64Load8S, code.OpI64Load8U, code.OpI32Store8, code.OpI64Store8:
+		return 1, true
+	case code.OpI32Load16S, code.OpI32Load16U, code.OpI64Load16S, code.OpI64Load16U, code.OpI32Store16, code.OpI64Store16:
+		return 2, true
+	case code.OpI32Load, code.OpF32Load, code.OpI64Load32S, code.OpI64Load32U, code.OpI32Store, code.OpF32Store, code.OpI64Store32:
+		return 4, true
+	case code.OpI64Load, code.OpF64Load, code.OpI64Store, code.OpF64Store:
+		return 8, true
+	}
+	return 0, false
+}
+
+// eliminateBoundsChecks marks memory accesses that are known to be in bounds with FlagsInBounds.
+//
+// An access is known to be in bounds if it is dominated by an access to, or a check of, a range that covers it. The
+// pass tracks the ranges that are known to be in bounds with a forward pass over the structured body: the facts known
+// at the end of a block are the facts known at every branch that targets it, and the facts known at the head of a loop
+// are the facts known on entry to the loop less those whose addresses read locals or globals that are stored inside the
+// loop. Ranges are keyed by address expression, so two accesses share facts if their addresses are the same
+// expression of the same locals, globals, and temps, and the offsets of the accesses are compared.
+//
+// Runs of accesses to the same address within straight-line code are coalesced: a PseudoBoundsCheck def is inserted
+// before the def that contains the first access in the run, and every access in the run is marked. The check covers
+// the entire run, so it may trap before the first access where the original code would have trapped at a later access.
+// In order to keep this from being observable, a run ends at any def or operation that has side effects, may trap
+// for reasons other than an out-of-bounds access, or transfers control, and a run may only begin in a def that
+// contains no operations that may trap before the run's first access.
+//
+// Before the forward pass, loops whose accesses are indexed by induction variables with known maximums are versioned
+// so that their accesses are checked once before the loop is entered. See versionLoops.
+func (f *Function) eliminateBoundsChecks() {
+	f.versionLoops()
+
+	// Find the locals and globals stored inside each loop.
+	loops := map[*Block]*loopEffects{}
+	var enclosing []*loopEffects
+	for _, d := range f.Body {
+		if d.IsPseudo() {
+			continue
+		}
+		switch d.Instr.Opcode {
+		case code.OpLoop:
+			effects := &loopEffects{}
+			loops[d.Block], enclosing = effects, append(enclosing, effects)
+		case code.OpBlock, code.OpIf:
+			enclosing = append(enclosing, nil)
+		case code.OpEnd:
+			if d.Block != nil {
+				enclosing = enclosing[:len(enclosing)-1]
+			}
+		}
+		for _, effects := range enclosing {
+			if effects != nil {
+				if d.Instr.Opcode == code.OpLocalSet {
+					effects.locals.Set(uint(d.Instr.Localidx()))
+				}
+				if d.Flags&FlagsStoreGlobal != 0 {
+					effects.globals = true
+				}
+			}
+		}
+	}
+
+	state := boundsFacts{}
+	groups := map[string]*boundsGroup{}
+	checks := map[*Def][]*Def{}
+
+	var frames []*boundsFrame
+	branch := func(target *Block) {
+		if target.Entry.Instr.Opcode == code.OpLoop {
+			return
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			if frames[i].block == target {
+				frames[i].exit = intersectBounds(frames[i].exit, state)
+				return
+			}
+		}
+	}
+
+	access := func(host *Def, x *Expression, canBeginRun bool) {
+		size, ok := accessSize(x)
+		if !ok || state == nil {
+			return
+		}
+
+		key, address, end := "", x.Uses[0], uint64(x.Instr.Offset())+size
+		var fact boundsFact
+		if !address.IsTemp() && !address.X.IsPseudo() && address.X.Instr.Opcode == code.OpI32Const {
+			address, end = nil, uint64(uint32(address.X.Instr.I32()))+end
+		} else {
+			key, fact.locals, fact.globals, ok = addressKey(address)
+			if !ok {
+				return
+			}
+		}
+		fact.end = end
+
+		// Accesses covered by a loop version's check are already in bounds, and prove the ranges they access.
+		if x.Flags&FlagsInBounds != 0 {
+			state.prove(key, fact)
+			return
+		}
+		if known, ok := state[key]; ok && end <= known.end {
+			x.Flags |= FlagsInBounds
+			return
+		}
+
+		if g, ok := groups[key]; ok {
+			if g.check == nil {
+				checkAddress := UseExpression(wasm.ValueTypeI32, &Expression{Function: f, Instr: code.I32Const(0)})
+				if g.address != nil {
+					checkAddress = cloneUse(g.address)
+				}
+				g.check = Pseudo(f, PseudoBoundsCheck, 0, FlagsMayTrap, checkAddress)
+				g.check.IP = g.first.IP
+				checks[g.host] = append(checks[g.host], &Def{Expression: g.check})
+				g.first.Flags |= FlagsInBounds
+			}
+			if end > g.end {
+				g.end = end
+			}
+			g.check.Instr.Immediate = g.end
+			x.Flags |= FlagsInBounds
+		} else if canBeginRun {
+			groups[key] = &boundsGroup{host: host, first: x, address: address, end: end, locals: fact.locals, globals: fact.globals}
+		}
+		state.prove(key, fact)
+	}
+
+	endRuns := func() {
+		if len(groups) != 0 {
+			groups = map[string]*boundsGroup{}
+		}
+	}
+
+	for _, d := range f.Body {
+		if d.IsPseudo() {
+			if mayTrap(d.Expression) {
+				endRuns()
+			}
+			continue
+		}
+
+		// Selects are compiled to conditionals, so their operands may not be evaluated.
+		if d.Instr.Opcode != code.OpSelect {
+			canBeginRun := true
+			forEachUse(d.Expression, func(u *Use) {
+				if u.IsTemp() {
+					return
+				}
+				access(d, u.X, canBeginRun)
+				if mayTrap(u.X) {
+					endRuns()
+					canBeginRun = false
+				}
+			})
+			access(d, d.Expression, canBeginRun)
+		}
+
+		if endsStraightLineCode(d) || mayTrap(d.Expression) || d.Flags&(FlagsStoreMem|FlagsStoreGlobal) != 0 {
+			endRuns()
+		}
+
+		switch d.Instr.Opcode {
+		case code.OpBlock, code.OpLoop, code.OpIf:
+			frame := &boundsFrame{block: d.Block, entry: state.clone()}
+			if d.Instr.Opcode == code.OpLoop && state != nil {
+				effects := loops[d.Block]
+				state.kill(&effects.locals, effects.globals)
+			}
+			frames = append(frames, frame)
+		case code.OpElse:
+			frame := frames[len(frames)-1]
+			frame.exit, frame.else_ = intersectBounds(frame.exit, state), true
+			state = frame.entry.clone()
+		case code.OpEnd:
+			if d.Block == nil {
+				break
+			}
+			frame := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if d.Block.Entry.Instr.Opcode != code.OpLoop {
+				if d.Block.Entry.Instr.Opcode == code.OpIf && !frame.else_ {
+					frame.exit = intersectBounds(frame.exit, frame.entry)
+				}
+				state = intersectBounds(frame.exit, state)
+			}
+		case code.OpBr, code.OpBrTable:
+			if state != nil {
+				for _, target := range d.BranchTargets {
+					branch(target)
+				}
+			}
+			state = nil
+		case code.OpBrIf:
+			if state != nil {
+				branch(d.BranchTargets[0])
+			}
+		case code.OpReturn, code.OpUnreachable:
+			state = nil
+		case code.OpLocalSet:
+			if state != nil {
+				var locals bitset.BitSet
+				locals.Set(uint(d.Instr.Localidx()))
+				state.kill(&locals, false)
+				for key, g := range groups {
+					for _, l := range g.locals {
+						if l == int(d.Instr.Localidx()) {
+							delete(groups, key)
+							break
+						}
+					}
+				}
+			}
+		}
+		if state != nil && d.Flags&FlagsStoreGlobal != 0 {
+			state.kill(&bitset.BitSet{}, true)
+		}
+	}
+
+	if len(checks) == 0 {
+		return
+	}
+
+	body := make([]*Def, 0, len(f.Body)+len(checks))
+	for _, d := range f.Body {
+		body = append(body, checks[d]...)
+		body = append(body, d)
+	}
+	f.Body = body
+}
+
+// cloneUse returns a deep copy of the given use.
+func cloneUse(u *Use) *Use {
+	c := *u
+	if u.X != nil {
+		c.X = cloneExpression(u.X)
+	}
+	return &c
+}
+
+// cloneExpression returns a deep copy of the given expression.
+func cloneExpression(x *Expression) *Expression {
+	c := *x
+	c.Uses = make(Uses, len(x.Uses))
+	for i, u := range x.Uses {
+		c.Uses[i] = cloneUse(u)
+	}
+	return &c
+}
@@ -5,6 +5,7 @@ import (
 	"math/bits"
 
 	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 )
 
@@ -61,6 +62,32 @@ func i32Bool(v bool) int32 {
 	return 0
 }
 
+// fold replaces a constant expression with its value. The types of the expression's results are given by types. Returns
+// true if the expression was replaced.
+func fold(x *Expression, types []wasm.ValueType) bool {
+	if len(types) != 1 {
+		return false
+	}
+	v, ok := evaluate(x)
+	if !ok {
+		return false
+	}
+
+	switch types[0] {
+	case ValueTypeBool:
+		x.Instr.Opcode, x.Instr.Immediate, x.Flags = PseudoBoolConst, v, x.Flags|FlagsPseudo
+	case wasm.ValueTypeI32:
+		x.Instr, x.Flags = code.I32Const(int32(v)), x.Flags&^FlagsPseudo
+	case wasm.ValueTypeI64:
+		x.Instr, x.Flags = code.I64Const(int64(v)), x.Flags&^FlagsPseudo
+	case wasm.ValueTypeF32:
+		x.Instr, x.Flags = code.F32Const(math.Float32frombits(uint32(v))), x.Flags&^FlagsPseudo
+	case wasm.ValueTypeF64:
+		x.Instr, x.Flags = code.F64Const(math.Float64frombits(v)), x.Flags&^FlagsPseudo
+	}
+	return true
+}
+
 func evaluate(x *Expression) (result uint64, ok bool) {
 	defer func() {
 		if x := recover(); x != nil {
@@ -69,7 +96,7 @@ func evaluate(x *Expression) (result uint64, ok bool) {
 	}()
 
 	// We can only evaluate pure expressions.
-	if x.Flags&^FlagsMayTrap != 0 {
+	if x.Flags&^(FlagsMayTrap|FlagsPseudo) != 0 {
 		return 0, false
 	}
 
@@ -88,8 +115,10 @@ func evaluate(x *Expression) (result uint64, ok bool) {
 	instr := x.Instr
 	if x.IsPseudo() {
 		switch instr.Opcode {
+		case PseudoBoolConst:
+			return instr.Immediate, true
 		case PseudoI32ConvertBool:
-			return uint64(instr.I32()), true
+			return args[0], true
 		}
 		return 0, false
 	}
@@ -1,8 +1,6 @@
 package wax
 
 import (
-	"math"
-
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 	"github.com/willf/bitset"
@@ -133,12 +131,14 @@ func (f *Function) Unreachable() bool {
 	return len(f.Blocks) > 0 && f.Blocks[len(f.Blocks)-1].Unreachable
 }
 
-func (f *Function) DropStack(until int) {
+// DropStack drops the stack entries above the given height. The drops are attributed to the instruction at ip.
+func (f *Function) DropStack(ip, until int) {
 	bb := f.basicBlocks[len(f.basicBlocks)-1]
 	for _, u := range f.Stack[until:] {
 		bb.body = append(bb.body, &Def{
 			Expression: &Expression{
 				Function: f,
+				IP:       ip,
 				Instr:    code.Drop(),
 				Uses:     []*Use{u},
 			},
@@ -524,27 +524,14 @@ func (f *Function) ImportInstruction(ip int, instr code.Instruction, scope *Func
 		f.Stack = f.Stack[:firstUse]
 
 		// Evaluate constant expressions.
-		if v, ok := evaluate(x); ok {
-			switch stackDefs[0] {
-			case ValueTypeBool:
-				x.Instr.Opcode, x.Instr.Immediate, x.Flags = PseudoBoolConst, v, x.Flags|FlagsPseudo
-			case wasm.ValueTypeI32:
-				x.Instr = code.I32Const(int32(v))
-			case wasm.ValueTypeI64:
-				x.Instr = code.I64Const(int64(v))
-			case wasm.ValueTypeF32:
-				x.Instr = code.F32Const(math.Float32frombits(uint32(v)))
-			case wasm.ValueTypeF64:
-				x.Instr = code.F64Const(math.Float64frombits(v))
-			}
-		}
+		fold(x, stackDefs)
 	}
 
 	if isUnreachable && len(f.Blocks) > 0 {
 		// clear the stack
 		b := f.Blocks[len(f.Blocks)-1]
 		b.Unreachable = true
-		f.DropStack(b.StackHeight)
+		f.DropStack(ip, b.StackHeight)
 	}
 
 	// If this expression is ordered, spill the stack to temps and append the expression to
@@ -552,19 +539,7 @@ func (f *Function) ImportInstruction(ip int, instr code.Instruction, scope *Func
 	var d *Def
 	if isOrdered {
 		if !isSelect {
-			// spill the stack
-			for _, u := range f.Stack {
-				if !u.CanMoveAfter(flags, storedLocals) {
-					d := &Def{
-						Expression: u.X,
-						Types:      []wasm.ValueType{u.Type},
-					}
-					d.Temp, f.Temps = len(f.Locals)+f.Temps, f.Temps+1
-					u.X.basicBlock.body = append(u.X.basicBlock.body, d)
-
-					u.X, u.Temp = nil, d.Temp
-				}
-			}
+			f.spillStack(flags, storedLocals)
 		}
 
 		d = &Def{Expression: x, Types: stackDefs}
@@ -640,6 +615,28 @@ func (f *Function) ImportInstruction(ip int, instr code.Instruction, scope *Func
 	}
 }
 
+// spillStack spills each stack entry that cannot be evaluated after an instruction with the given flags and local
+// stores to a temp.
+func (f *Function) spillStack(flags Flags, storedLocals bitset.BitSet) {
+	for _, u := range f.Stack {
+		if !u.CanMoveAfter(flags, storedLocals) {
+			f.spill(u)
+		}
+	}
+}
+
+// spill evaluates the given stack entry into a temp at the point at which its expression was imported.
+func (f *Function) spill(u *Use) {
+	d := &Def{
+		Expression: u.X,
+		Types:      []wasm.ValueType{u.Type},
+	}
+	d.Temp, f.Temps = len(f.Locals)+f.Temps, f.Temps+1
+	u.X.basicBlock.body = append(u.X.basicBlock.body, d)
+
+	u.X, u.Temp = nil, d.Temp
+}
+
 func boolConvertI32(u *Use) *Use {
 	zero := UseExpression(wasm.ValueTypeI32, &Expression{
 		Function: u.Function,
@@ -0,0 +1,580 @@
+package wax
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/willf/bitset"
+)
+
+// maxVersionedLoop is the size, in defs, of the largest loop that versionLoops will duplicate.
+const maxVersionedLoop = 256
+
+// maxIndexScale is the largest factor by which an induction variable may be scaled in an address that versionLoops
+// covers. Keeping scales small keeps the arithmetic in the pre-loop check from overflowing.
+const maxIndexScale = 1 << 16
+
+// An inductionBound records that a value of an induction variable is at most value+delta, where value is an i32 that
+// is computed before the loop and interpreted as unsigned.
+type inductionBound struct {
+	value *Use
+	delta int64
+}
+
+// An induction describes a local whose only stores inside a loop increment it by a positive constant, and the bounds
+// on its value at the head of each iteration after the first.
+type induction struct {
+	step   int64 // the sum of the increments stored inside the loop
+	bounds []inductionBound
+	signed bool // true if any bound was established by a signed comparison
+	ok     bool
+}
+
+// An indexedRange is the range of effective addresses accessed by the accesses whose addresses are
+// local*scale+sum(bases) for an induction variable local and loop-invariant bases.
+type indexedRange struct {
+	local int
+	scale uint64
+	bases []*Use
+	end   uint64 // the largest offset+size of the accesses
+}
+
+// versionLoops duplicates loops whose memory accesses are indexed by induction variables with known maximums.
+//
+// A loop is versioned by wrapping it in an if/else. The if's condition checks, before the loop is entered, that the
+// largest address each covered access may compute is in bounds. The then branch holds a copy of the loop in which the
+// covered accesses are marked with FlagsInBounds, and the else branch holds the original loop. Memory never shrinks,
+// so every covered access is in bounds if the condition holds. The condition itself never traps, so a loop that
+// would trap partway through runs the original code and traps at the same point, after the same side effects.
+//
+// An induction variable is an i32 local whose only stores inside the loop add a positive constant to it outside of
+// any nested loop. Its maximum is derived from the branches back to the loop's head: a br_if whose condition compares
+// the variable with a loop-invariant bound limits the values that reach the head, as does an unconditional branch
+// that is dominated by a br_if that exits the loop when the variable reaches a bound. Signed comparisons are only
+// used if the variable is known to remain non-negative; the pre-loop condition checks this when it cannot be proven
+// statically. Covered accesses are those whose addresses are the variable, optionally scaled by a constant, plus any
+// number of pure loop-invariant terms.
+func (f *Function) versionLoops() {
+	var body []*Def
+	for i := 0; i < len(f.Body); i++ {
+		d := f.Body[i]
+		if !d.IsPseudo() && d.Instr.Opcode == code.OpLoop {
+			if end := blockEnd(f.Body, i); end != -1 {
+				if versioned, ok := f.versionLoop(f.Body, i, end); ok {
+					body, i = append(body, versioned...), end
+					continue
+				}
+			}
+		}
+		body = append(body, d)
+	}
+	f.Body = body
+}
+
+// blockEnd returns the index of the def that ends the block entered by body[start], or -1 if there is no such def.
+func blockEnd(body []*Def, start int) int {
+	b := body[start].Block
+	for i := start + 1; i < len(body); i++ {
+		if d := body[i]; !d.IsPseudo() && d.Instr.Opcode == code.OpEnd && d.Block == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// versionLoop versions the loop in body[start:end+1]. See versionLoops.
+func (f *Function) versionLoop(body []*Def, start, end int) ([]*Def, bool) {
+	loop := body[start]
+	if len(loop.Block.Ins) != 0 || len(loop.Block.Outs) != 0 || end-start+1 > maxVersionedLoop {
+		return nil, false
+	}
+	inner := body[start+1 : end]
+
+	var stored bitset.BitSet
+	for _, d := range inner {
+		if !d.IsPseudo() && d.Instr.Opcode == code.OpLocalSet {
+			stored.Set(uint(d.Instr.Localidx()))
+		}
+	}
+
+	inductions := f.findInductions(loop.Block, inner, &stored)
+	if len(inductions) == 0 {
+		return nil, false
+	}
+
+	ranges := map[string]*indexedRange{}
+	var keys []string
+	forEachAccess(inner, func(x *Expression) {
+		size, _ := accessSize(x)
+		r, ok := f.matchIndexedAddress(x.Uses[0], inductions, &stored)
+		if !ok {
+			return
+		}
+		r.end = uint64(x.Instr.Offset()) + size
+
+		key, ok := r.key()
+		if !ok {
+			return
+		}
+		if existing, ok := ranges[key]; ok {
+			if r.end > existing.end {
+				existing.end = r.end
+			}
+			return
+		}
+		ranges[key], keys = r, append(keys, key)
+	})
+	if len(ranges) == 0 {
+		return nil, false
+	}
+
+	var conditions []*Use
+	checked := map[int]bool{}
+	for _, key := range keys {
+		r := ranges[key]
+		ind := inductions[r.local]
+
+		// The value of the variable at any point in the loop is at most the value at the head of the current
+		// iteration plus the sum of its increments.
+		extremes := []inductionBound{{value: f.initialValue(body, start, r.local), delta: ind.step}}
+		for _, b := range ind.bounds {
+			extremes = append(extremes, inductionBound{value: b.value, delta: b.delta + ind.step})
+		}
+
+		if ind.signed && !checked[r.local] {
+			checked[r.local] = true
+			for _, e := range extremes {
+				// The variable remains non-negative if every extreme is less than 2^31.
+				limit := f.linear(e.delta+1, []*Use{e.value}, 1)
+				if limit.IsConst() {
+					if uint64(limit.X.Instr.I64()) > 1<<31 {
+						return nil, false
+					}
+					continue
+				}
+				conditions = append(conditions, UseExpression(ValueTypeBool, &Expression{
+					Function: f,
+					IP:       loop.IP,
+					Instr:    code.I64LeU(),
+					Uses:     Uses{limit, f.i64Const(1 << 31)},
+				}))
+			}
+		}
+
+		for _, e := range extremes {
+			// The largest address computed by the range's accesses is extreme*scale+sum(bases)+end.
+			terms := append([]*Use{e.value}, r.bases...)
+			scales := make([]uint64, len(terms))
+			for i := range scales {
+				scales[i] = 1
+			}
+			scales[0] = r.scale
+			end := f.linearScaled(int64(r.end)+e.delta*int64(r.scale), terms, scales)
+			conditions = append(conditions, UseExpression(ValueTypeBool, Pseudo(f, PseudoInBounds, 0, FlagsLoadMem, end)))
+		}
+	}
+	for _, c := range conditions {
+		c.X.IP = loop.IP
+	}
+
+	condition := conditions[0]
+	if len(conditions) > 1 {
+		condition = UseExpression(ValueTypeBool, Pseudo(f, PseudoAnd, 0, 0, conditions...))
+		condition.X.IP = loop.IP
+	}
+
+	versioned := f.cloneDefs(body[start : end+1])
+	forEachAccess(versioned, func(x *Expression) {
+		if _, ok := f.matchIndexedAddress(x.Uses[0], inductions, &stored); ok {
+			x.Flags |= FlagsInBounds
+		}
+	})
+
+	b := &Block{
+		Label:          f.Labels,
+		StackHeight:    loop.Block.StackHeight,
+		Unreachable:    loop.Block.Unreachable,
+		NeverReachable: loop.Block.NeverReachable,
+	}
+	f.Labels++
+
+	ifDef := &Def{Expression: &Expression{Function: f, IP: loop.IP, Instr: code.If(code.BlockTypeEmpty), Uses: Uses{condition}}, Block: b}
+	elseDef := &Def{Expression: &Expression{Function: f, IP: body[end].IP, Instr: code.Else()}, Block: b}
+	endDef := &Def{Expression: &Expression{Function: f, IP: body[end].IP, Instr: code.End()}, Block: b}
+	b.Entry, b.Else, b.End = ifDef.Expression, elseDef.Expression, endDef.Expression
+
+	result := make([]*Def, 0, 2*(end-start+1)+3)
+	result = append(result, ifDef)
+	result = append(result, versioned...)
+	result = append(result, elseDef)
+	result = append(result, body[start:end+1]...)
+	return append(result, endDef), true
+}
+
+// findInductions returns the induction variables of the given loop, keyed by local index. Only variables with known
+// bounds are returned.
+func (f *Function) findInductions(loop *Block, inner []*Def, stored *bitset.BitSet) map[int]*induction {
+	inductions := map[int]*induction{}
+
+	// Find the locals whose only stores add positive constants to them outside of nested loops.
+	depth, loops := 0, []bool{}
+	for _, d := range inner {
+		if d.IsPseudo() {
+			continue
+		}
+		switch d.Instr.Opcode {
+		case code.OpBlock, code.OpLoop, code.OpIf:
+			loops, depth = append(loops, d.Instr.Opcode == code.OpLoop), depth+1
+		case code.OpEnd:
+			loops, depth = loops[:len(loops)-1], depth-1
+		case code.OpLocalSet:
+			local := int(d.Instr.Localidx())
+			ind, ok := inductions[local]
+			if !ok {
+				ind = &induction{ok: f.Locals[local] == wasm.ValueTypeI32}
+				inductions[local] = ind
+			}
+			c, isIncrement := increment(d, local)
+			for _, isLoop := range loops {
+				isIncrement = isIncrement && !isLoop
+			}
+			if !isIncrement {
+				ind.ok = false
+			}
+			ind.step += c
+		}
+	}
+
+	// Find the bounds on the value of each variable at the loop's head.
+	for local, ind := range inductions {
+		var guard *inductionBound
+		depth := 0
+		for _, d := range inner {
+			if !ind.ok {
+				break
+			}
+			if d.IsPseudo() {
+				continue
+			}
+			switch d.Instr.Opcode {
+			case code.OpBlock, code.OpLoop, code.OpIf:
+				depth++
+			case code.OpEnd:
+				depth--
+			case code.OpLocalSet:
+				if guard != nil && int(d.Instr.Localidx()) == local {
+					c, _ := increment(d, local)
+					guard.delta += c
+				}
+			}
+
+			continues := false
+			for _, target := range d.BranchTargets {
+				continues = continues || target == loop
+			}
+			switch {
+			case continues && d.Instr.Opcode == code.OpBrIf:
+				if bound, signed, ok := f.compareInduction(d.Uses[len(d.Uses)-1], local, stored, true); ok {
+					ind.bounds, ind.signed = append(ind.bounds, bound), ind.signed || signed
+				} else if depth == 0 && guard != nil {
+					ind.bounds = append(ind.bounds, *guard)
+				} else {
+					ind.ok = false
+				}
+			case continues && d.Instr.Opcode == code.OpBr && depth == 0 && guard != nil:
+				ind.bounds = append(ind.bounds, *guard)
+			case continues:
+				ind.ok = false
+			case d.Instr.Opcode == code.OpBrIf && depth == 0 && exits(d.BranchTargets[0], inner):
+				// If the branch exits the loop when the variable reaches a bound, the code that follows it sees the
+				// variable below that bound.
+				if bound, signed, ok := f.compareInduction(d.Uses[len(d.Uses)-1], local, stored, false); ok {
+					guard, ind.signed = &bound, ind.signed || signed
+				}
+			}
+		}
+		if !ind.ok {
+			delete(inductions, local)
+		}
+	}
+	return inductions
+}
+
+// exits returns true if the given branch target lies outside of the given loop body.
+func exits(target *Block, inner []*Def) bool {
+	for _, d := range inner {
+		if d.Block == target {
+			return false
+		}
+	}
+	return true
+}
+
+// increment returns the constant added to the given local by the given local.set, if the local.set adds a positive
+// constant to the local.
+func increment(d *Def, local int) (int64, bool) {
+	value := d.Uses[0]
+	if value.IsTemp() || value.X.IsPseudo() || value.X.Instr.Opcode != code.OpI32Add {
+		return 0, false
+	}
+	a, b := value.X.Uses[0], value.X.Uses[1]
+	if !isLocal(a, local) {
+		a, b = b, a
+	}
+	if !isLocal(a, local) || !b.IsConst() || b.X.Instr.I32() <= 0 {
+		return 0, false
+	}
+	return int64(b.X.Instr.I32()), true
+}
+
+// isLocal returns true if the given use reads the given local.
+func isLocal(u *Use, local int) bool {
+	return !u.IsTemp() && isLocalGet(u.X) && int(u.X.Instr.Localidx()) == local
+}
+
+// compareInduction returns the bound on the given local implied by the given condition. If taken is true, the bound
+// holds when the condition is true; otherwise it holds when the condition is false. The bound must be loop-invariant.
+func (f *Function) compareInduction(cond *Use, local int, stored *bitset.BitSet, taken bool) (inductionBound, bool, bool) {
+	if cond.IsTemp() || cond.X.IsPseudo() || len(cond.X.Uses) != 2 {
+		return inductionBound{}, false, false
+	}
+
+	// Normalize the comparison to "local op bound".
+	op, a, b := cond.X.Instr.Opcode, cond.X.Uses[0], cond.X.Uses[1]
+	if !isLocal(a, local) {
+		swapped := map[byte]byte{
+			code.OpI32LtU: code.OpI32GtU, code.OpI32LeU: code.OpI32GeU, code.OpI32GtU: code.OpI32LtU, code.OpI32GeU: code.OpI32LeU,
+			code.OpI32LtS: code.OpI32GtS, code.OpI32LeS: code.OpI32GeS, code.OpI32GtS: code.OpI32LtS, code.OpI32GeS: code.OpI32LeS,
+		}
+		op, a, b = swapped[op], b, a
+	}
+	if !isLocal(a, local) || !isInvariant(b, stored) {
+		return inductionBound{}, false, false
+	}
+
+	// Find the relation that holds in the requested case.
+	if !taken {
+		negated := map[byte]byte{
+			code.OpI32LtU: code.OpI32GeU, code.OpI32LeU: code.OpI32GtU, code.OpI32GtU: code.OpI32LeU, code.OpI32GeU: code.OpI32LtU,
+			code.OpI32LtS: code.OpI32GeS, code.OpI32LeS: code.OpI32GtS, code.OpI32GtS: code.OpI32LeS, code.OpI32GeS: code.OpI32LtS,
+		}
+		op = negated[op]
+	}
+	switch op {
+	case code.OpI32LtU:
+		return inductionBound{value: b, delta: -1}, false, true
+	case code.OpI32LeU:
+		return inductionBound{value: b, delta: 0}, false, true
+	case code.OpI32LtS:
+		return inductionBound{value: b, delta: -1}, true, true
+	case code.OpI32LeS:
+		return inductionBound{value: b, delta: 0}, true, true
+	}
+	return inductionBound{}, false, false
+}
+
+// isInvariant returns true if the given use is a pure i32 expression that does not read the given locals.
+func isInvariant(u *Use, stored *bitset.BitSet) bool {
+	if u.Type != wasm.ValueTypeI32 || !isPure(u) {
+		return false
+	}
+	invariant := true
+	var visit func(u *Use)
+	visit = func(u *Use) {
+		if u.X.Flags&^FlagsLoadLocal != 0 || u.X.IsPseudo() || isLocalGet(u.X) && stored.Test(uint(u.X.Instr.Localidx())) {
+			invariant = false
+		}
+		for _, u := range u.X.Uses {
+			visit(u)
+		}
+	}
+	visit(u)
+	return invariant
+}
+
+// initialValue returns a use that computes the value of the given local on entry to the loop that begins at
+// body[start]. If the local is set to a constant in the straight-line code that precedes the loop, the use is that
+// constant.
+func (f *Function) initialValue(body []*Def, start, local int) *Use {
+	for i := start - 1; i >= 0; i-- {
+		d := body[i]
+		if d.IsPseudo() {
+			continue
+		}
+		if d.Instr.Opcode == code.OpLocalSet && int(d.Instr.Localidx()) == local {
+			if d.Uses[0].IsConst() {
+				return UseExpression(wasm.ValueTypeI32, &Expression{Function: f, Instr: d.Uses[0].X.Instr})
+			}
+			break
+		}
+		if endsStraightLineCode(d) {
+			break
+		}
+	}
+	return UseExpression(wasm.ValueTypeI32, &Expression{Function: f, Instr: code.LocalGet(uint32(local)), Flags: FlagsLoadLocal})
+}
+
+// matchIndexedAddress matches addresses of the form local*scale+sum(bases), where local is one of the given induction
+// variables and the bases are loop-invariant.
+func (f *Function) matchIndexedAddress(address *Use, inductions map[int]*induction, stored *bitset.BitSet) (*indexedRange, bool) {
+	if address.IsTemp() || address.X.IsPseudo() {
+		return nil, false
+	}
+
+	x := address.X
+	switch x.Instr.Opcode {
+	case code.OpLocalGet:
+		if _, ok := inductions[int(x.Instr.Localidx())]; ok {
+			return &indexedRange{local: int(x.Instr.Localidx()), scale: 1}, true
+		}
+	case code.OpI32Shl:
+		if r, ok := f.matchIndexedAddress(x.Uses[0], inductions, stored); ok && len(r.bases) == 0 && x.Uses[1].IsConst() {
+			if shift := uint64(uint32(x.Uses[1].X.Instr.I32())); shift < 32 && r.scale<<shift <= maxIndexScale {
+				r.scale <<= shift
+				return r, true
+			}
+		}
+	case code.OpI32Mul:
+		for _, operands := range [][2]*Use{{x.Uses[0], x.Uses[1]}, {x.Uses[1], x.Uses[0]}} {
+			r, ok := f.matchIndexedAddress(operands[0], inductions, stored)
+			if ok && len(r.bases) == 0 && operands[1].IsConst() {
+				if factor := uint64(uint32(operands[1].X.Instr.I32())); factor != 0 && r.scale*factor <= maxIndexScale {
+					r.scale *= factor
+					return r, true
+				}
+			}
+		}
+	case code.OpI32Add:
+		for _, operands := range [][2]*Use{{x.Uses[0], x.Uses[1]}, {x.Uses[1], x.Uses[0]}} {
+			if r, ok := f.matchIndexedAddress(operands[0], inductions, stored); ok && isInvariant(operands[1], stored) {
+				r.bases = append(r.bases, operands[1])
+				return r, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// key returns a string that identifies the range's induction variable, scale, and bases.
+func (r *indexedRange) key() (string, bool) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d*%d", r.local, r.scale)
+	for _, base := range r.bases {
+		key, _, _, ok := addressKey(base)
+		if !ok {
+			return "", false
+		}
+		b.WriteString("+")
+		b.WriteString(key)
+	}
+	return b.String(), true
+}
+
+// forEachAccess calls fn for each memory access in the given defs.
+func forEachAccess(defs []*Def, fn func(x *Expression)) {
+	visit := func(x *Expression) {
+		if _, ok := accessSize(x); ok {
+			fn(x)
+		}
+	}
+	for _, d := range defs {
+		if d.IsPseudo() {
+			continue
+		}
+		forEachUse(d.Expression, func(u *Use) {
+			if !u.IsTemp() {
+				visit(u.X)
+			}
+		})
+		visit(d.Expression)
+	}
+}
+
+// linear returns a use that computes c+sum(terms) as an i64, where each term is an i32 interpreted as unsigned.
+func (f *Function) linear(c int64, terms []*Use, scale uint64) *Use {
+	scales := make([]uint64, len(terms))
+	for i := range scales {
+		scales[i] = scale
+	}
+	return f.linearScaled(c, terms, scales)
+}
+
+// linearScaled returns a use that computes c+sum(terms[i]*scales[i]) as an i64, where each term is an i32 interpreted
+// as unsigned. Constant terms are folded into c.
+func (f *Function) linearScaled(c int64, terms []*Use, scales []uint64) *Use {
+	var sum *Use
+	for i, t := range terms {
+		if t.IsConst() {
+			c += int64(uint32(t.X.Instr.I32())) * int64(scales[i])
+			continue
+		}
+
+		term := UseExpression(wasm.ValueTypeI64, &Expression{Function: f, Instr: code.I64ExtendI32U(), Uses: Uses{cloneUse(t)}})
+		if scales[i] != 1 {
+			term = f.i64Binary(code.I64Mul(), term, f.i64Const(int64(scales[i])))
+		}
+		if sum == nil {
+			sum = term
+		} else {
+			sum = f.i64Binary(code.I64Add(), sum, term)
+		}
+	}
+	switch {
+	case sum == nil:
+		return f.i64Const(c)
+	case c != 0:
+		return f.i64Binary(code.I64Add(), sum, f.i64Const(c))
+	default:
+		return sum
+	}
+}
+
+func (f *Function) i64Const(v int64) *Use {
+	return UseExpression(wasm.ValueTypeI64, &Expression{Function: f, Instr: code.I64Const(v)})
+}
+
+func (f *Function) i64Binary(instr code.Instruction, a, b *Use) *Use {
+	return UseExpression(wasm.ValueTypeI64, &Expression{Function: f, Instr: instr, Uses: Uses{a, b}})
+}
+
+// cloneDefs returns a deep copy of the given defs, which must contain entire blocks. The copies of the blocks are
+// assigned fresh labels. Branches to blocks outside of the defs are preserved.
+func (f *Function) cloneDefs(defs []*Def) []*Def {
+	blocks := map[*Block]*Block{}
+	for _, d := range defs {
+		if d.Block != nil && d.Block.Entry == d.Expression {
+			b := *d.Block
+			b.Label, f.Labels = f.Labels, f.Labels+1
+			blocks[d.Block] = &b
+		}
+	}
+
+	clones := make([]*Def, len(defs))
+	for i, d := range defs {
+		c := &Def{Expression: cloneExpression(d.Expression), Block: d.Block, Types: d.Types, Temp: d.Temp}
+		if b, ok := blocks[d.Block]; ok {
+			switch d.Expression {
+			case d.Block.Entry:
+				b.Entry = c.Expression
+			case d.Block.Else:
+				b.Else = c.Expression
+			case d.Block.End:
+				b.End = c.Expression
+			}
+			c.Block = b
+		}
+		if d.BranchTargets != nil {
+			c.BranchTargets = make([]*Block, len(d.BranchTargets))
+			for j, target := range d.BranchTargets {
+				if b, ok := blocks[target]; ok {
+					target = b
+				}
+				c.BranchTargets[j] = target
+			}
+		}
+		clones[i] = c
+	}
+	return clones
+}
@@ -0,0 +1,181 @@
+package wax
+
+import (
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/willf/bitset"
+)
+
+// An InlineFunction is a function whose body may be imported in place of calls to the function. See
+// Function.ImportInlinedCall.
+type InlineFunction struct {
+	Signature wasm.FunctionSig
+	Locals    []wasm.ValueType   // The types of the function's locals, excluding its parameters.
+	Body      []code.Instruction // The function's decoded body, including its final end.
+}
+
+// NewInlineFunction decodes the given function body for inlining.
+func NewInlineFunction(signature wasm.FunctionSig, body wasm.FunctionBody, scope code.Scope) (*InlineFunction, error) {
+	callee := Function{Signature: signature}
+	callee.Locals = append(callee.Locals, signature.ParamTypes...)
+	for _, l := range body.Locals {
+		for i := 0; i < int(l.Count); i++ {
+			callee.Locals = append(callee.Locals, l.Type)
+		}
+	}
+
+	decoded, err := code.Decode(body.Code, callee.Scope(scope), signature.ReturnTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &InlineFunction{
+		Signature: signature,
+		Locals:    callee.Locals[len(signature.ParamTypes):],
+		Body:      decoded.Instructions,
+	}, nil
+}
+
+// Size returns the number of instructions in the function's body.
+func (fn *InlineFunction) Size() int {
+	return len(fn.Body)
+}
+
+// CanInline returns true if calls to the function may be inlined. A function may be inlined if it is a leaf function
+// that returns at most one value.
+func (fn *InlineFunction) CanInline() bool {
+	if len(fn.Signature.ReturnTypes) > 1 {
+		return false
+	}
+	for _, instr := range fn.Body {
+		switch instr.Opcode {
+		case code.OpCall, code.OpCallIndirect:
+			return false
+		}
+	}
+	return true
+}
+
+// ImportInlinedCall imports a call to the given function by importing the function's body in place of the call. The
+// function must satisfy CanInline, and the current block must be reachable.
+//
+// The callee's parameters and locals are appended to the caller's locals, and its body is wrapped in a block whose
+// results are the callee's results. Returns from the callee become branches to the end of that block. The inlined
+// body is bracketed by PseudoEnter and PseudoLeave defs so that backends can preserve the call's stack-depth
+// accounting. The arguments to the call are evaluated in order before the PseudoEnter def, and the stack is spilled
+// at that def as it would be for the call.
+func (f *Function) ImportInlinedCall(ip int, fn *InlineFunction, scope *FunctionScope) {
+	params := fn.Signature.ParamTypes
+
+	// Allocate the callee's parameters and locals.
+	base := uint32(len(f.Locals))
+	f.Locals = append(f.Locals, params...)
+	f.Locals = append(f.Locals, fn.Locals...)
+	f.UsedLocals = append(f.UsedLocals, make([]bool, len(params)+len(fn.Locals))...)
+
+	// Store the arguments to the callee's parameters. The parameters are stored in reverse order, so any argument
+	// other than the last that may trap must be evaluated first.
+	if len(params) > 1 {
+		for _, u := range f.Stack[len(f.Stack)-len(params) : len(f.Stack)-1] {
+			if !canEvaluateLate(u) {
+				f.spill(u)
+			}
+		}
+	}
+	for i := len(params) - 1; i >= 0; i-- {
+		f.ImportInstruction(ip, code.LocalSet(base+uint32(i)), scope)
+	}
+
+	// Locals are zeroed on entry to a function. The caller's locals are zeroed on entry to the caller, so the callee's
+	// locals only need to be cleared if the call may execute more than once.
+	if f.inLoop() {
+		for i, t := range fn.Locals {
+			f.ImportInstruction(ip, zeroConst(t), scope)
+			f.ImportInstruction(ip, code.LocalSet(base+uint32(len(params)+i)), scope)
+		}
+	}
+
+	// Enter the callee. The entry may trap if the call stack is exhausted, and must be ordered with respect to memory
+	// and globals in the same way as the call itself.
+	f.importPseudoDef(ip, PseudoEnter, FlagsMayTrap, FlagsLoadGlobal|FlagsLoadMem|FlagsStoreGlobal|FlagsStoreMem)
+
+	blockType := uint64(code.BlockTypeEmpty)
+	if len(fn.Signature.ReturnTypes) != 0 {
+		blockType = code.BlockTypeSpecial | uint64(fn.Signature.ReturnTypes[0])
+	}
+	f.ImportInstruction(ip, code.Block(blockType), scope)
+
+	// Import the callee's body. Its final end closes the block that wraps the body.
+	depth := 0
+	for _, instr := range fn.Body {
+		switch instr.Opcode {
+		case code.OpBlock, code.OpLoop, code.OpIf:
+			depth++
+		case code.OpEnd:
+			depth--
+		case code.OpReturn:
+			instr = code.Br(depth)
+		case code.OpLocalGet:
+			instr = code.LocalGet(base + instr.Localidx())
+		case code.OpLocalSet:
+			instr = code.LocalSet(base + instr.Localidx())
+		case code.OpLocalTee:
+			instr = code.LocalTee(base + instr.Localidx())
+		}
+		f.ImportInstruction(ip, instr, scope)
+	}
+
+	f.importPseudoDef(ip, PseudoLeave, 0, 0)
+}
+
+// inLoop returns true if the current block is nested inside a loop.
+func (f *Function) inLoop() bool {
+	for _, b := range f.Blocks {
+		if b.Entry.Instr.Opcode == code.OpLoop {
+			return true
+		}
+	}
+	return false
+}
+
+// importPseudoDef appends an ordered pseudo-instruction with no operands or results to the function body. Stack
+// entries that cannot be evaluated after an instruction with the given interference flags are spilled first.
+func (f *Function) importPseudoDef(ip int, opcode byte, flags, interference Flags) {
+	f.spillStack(interference, bitset.BitSet{})
+
+	bb := f.basicBlocks[len(f.basicBlocks)-1]
+	x := Pseudo(f, opcode, 0, flags)
+	x.IP, x.basicBlock = ip, bb
+	bb.body = append(bb.body, &Def{Expression: x})
+}
+
+// canEvaluateLate returns true if the given stack entry may be evaluated after the entries above it without changing
+// the traps raised by the function.
+func canEvaluateLate(u *Use) bool {
+	if u.IsTemp() {
+		return true
+	}
+	if u.AllFlags&FlagsLoadMem != 0 {
+		return false
+	}
+	ok := !mayTrap(u.X)
+	forEachUse(u.X, func(u *Use) {
+		if !u.IsTemp() && mayTrap(u.X) {
+			ok = false
+		}
+	})
+	return ok
+}
+
+// zeroConst returns a constant instruction that produces the zero value of the given type.
+func zeroConst(t wasm.ValueType) code.Instruction {
+	switch t {
+	case wasm.ValueTypeI64:
+		return code.I64Const(0)
+	case wasm.ValueTypeF32:
+		return code.F32Const(0)
+	case wasm.ValueTypeF64:
+		return code.F64Const(0)
+	default:
+		return code.I32Const(0)
+	}
+}
@@ -21,6 +21,8 @@ const (
 	FlagsStoreMem
 	FlagsMayTrap
 	FlagsPseudo
+	// FlagsInBounds marks a memory access that is known to be in bounds. Backends may omit the access's bounds check.
+	FlagsInBounds
 
 	FlagsBackend = 1 << 16
 
@@ -46,6 +48,19 @@ type Expression struct {
 const (
 	PseudoBoolConst = 0 + iota
 	PseudoI32ConvertBool
+	// PseudoEnter and PseudoLeave mark the entry to and exit from the body of an inlined call. See
+	// Function.ImportInlinedCall.
+	PseudoEnter
+	PseudoLeave
+	// PseudoBoundsCheck traps if any effective address in the range [address, address+immediate) is out of bounds,
+	// where address is the check's operand.
+	PseudoBoundsCheck
+	// PseudoInBounds is true if every effective address below its i64 operand, interpreted as unsigned, is in bounds.
+	// Unlike PseudoBoundsCheck, it never traps.
+	PseudoInBounds
+	// PseudoAnd is true if all of its boolean operands are true. Its operands are evaluated from left to right, and
+	// evaluation stops at the first operand that is false.
+	PseudoAnd
 
 	PseudoBackend = 128
 )
@@ -0,0 +1,947 @@
+package wax
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/willf/bitset"
+)
+
+// Passes is a set of optimization passes to run over a function's body. See Function.Optimize.
+type Passes uint32
+
+const (
+	// PassConstantPropagation replaces reads of locals that hold known constants with those constants and refolds the
+	// expressions that contain them. Facts about locals flow across blocks, branches, and loops.
+	PassConstantPropagation Passes = 1 << iota
+	// PassCopyPropagation replaces reads of locals that hold copies of other locals with reads of the original locals.
+	PassCopyPropagation
+	// PassDeadCodeElimination removes stores to locals that are not live, pure drops, conditional branches that are
+	// never taken, the arms of ifs that are never taken, and code that is unreachable.
+	PassDeadCodeElimination
+	// PassRedundantLoadElimination reuses the results of memory loads within straight-line code when no intervening
+	// store may have changed the loaded value.
+	PassRedundantLoadElimination
+	// PassBoundsCheckElimination marks memory accesses that are known to be in bounds, coalesces the bounds checks
+	// of runs of accesses to the same address into a single check, and versions loops whose accesses are indexed by
+	// induction variables so that those accesses are checked once before the loop.
+	PassBoundsCheckElimination
+)
+
+// Optimize runs the given optimization passes over the function's body. Optimize must be called after FinishImport.
+//
+// The passes preserve the shape of the body expected by backends: temps remain defined before they are used and
+// remain used if they were used, blocks are only marked as branch targets if a branch to them remains, and the
+// traps raised by the function are unchanged.
+func (f *Function) Optimize(passes Passes) {
+	if passes == 0 {
+		return
+	}
+
+	if passes&(PassConstantPropagation|PassCopyPropagation) != 0 {
+		f.propagate(passes&PassConstantPropagation != 0, passes&PassCopyPropagation != 0)
+	}
+	if passes&PassDeadCodeElimination != 0 {
+		f.eliminateDeadCode()
+	}
+	if passes&PassRedundantLoadElimination != 0 {
+		f.eliminateRedundantLoads()
+	}
+	if passes&PassBoundsCheckElimination != 0 {
+		f.eliminateBoundsChecks()
+	}
+	f.updateUsedLocals()
+}
+
+// forEachUse calls fn for each use in the given expression tree in evaluation order. Uses are visited after the uses
+// they contain.
+func forEachUse(x *Expression, fn func(u *Use)) {
+	for _, u := range x.Uses {
+		if !u.IsTemp() {
+			forEachUse(u.X, fn)
+		}
+		fn(u)
+	}
+}
+
+// isLocalGet returns true if the expression reads a local.
+func isLocalGet(x *Expression) bool {
+	return !x.IsPseudo() && x.Instr.Opcode == code.OpLocalGet
+}
+
+// isLoad returns true if the expression loads a value from memory.
+func isLoad(x *Expression) bool {
+	if x.IsPseudo() {
+		return false
+	}
+	switch x.Instr.Opcode {
+	case code.OpI32Load, code.OpI64Load, code.OpF32Load, code.OpF64Load,
+		code.OpI32Load8S, code.OpI32Load8U, code.OpI32Load16S, code.OpI32Load16U,
+		code.OpI64Load8S, code.OpI64Load8U, code.OpI64Load16S, code.OpI64Load16U, code.OpI64Load32S, code.OpI64Load32U:
+		return true
+	}
+	return false
+}
+
+// isRem returns true if the expression computes a remainder.
+func isRem(x *Expression) bool {
+	if x.IsPseudo() {
+		return false
+	}
+	switch x.Instr.Opcode {
+	case code.OpI32RemS, code.OpI32RemU, code.OpI64RemS, code.OpI64RemU:
+		return true
+	}
+	return false
+}
+
+// mayTrap returns true if evaluating the expression's own operation may trap. Memory loads are not considered by
+// mayTrap.
+func mayTrap(x *Expression) bool {
+	if x.Flags&FlagsMayTrap != 0 {
+		return true
+	}
+	if x.IsPseudo() {
+		return false
+	}
+	switch x.Instr.Opcode {
+	case code.OpI32DivS, code.OpI32DivU, code.OpI32RemS, code.OpI32RemU,
+		code.OpI64DivS, code.OpI64DivU, code.OpI64RemS, code.OpI64RemU,
+		code.OpI32TruncF32S, code.OpI32TruncF32U, code.OpI32TruncF64S, code.OpI32TruncF64U,
+		code.OpI64TruncF32S, code.OpI64TruncF32U, code.OpI64TruncF64S, code.OpI64TruncF64U:
+		return true
+	}
+	return false
+}
+
+// isPure returns true if the use may be discarded without changing the function's behavior: it does not access
+// memory, cannot trap, and does not read a temp.
+func isPure(u *Use) bool {
+	if u.IsTemp() || u.X.Flags&FlagsLoadMem != 0 || mayTrap(u.X) {
+		return false
+	}
+	for _, u := range u.X.Uses {
+		if !isPure(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValueDef returns true if the def only evaluates its expression into its result temp.
+func isValueDef(d *Def) bool {
+	if d.Block != nil || len(d.BranchTargets) != 0 || len(d.Types) != 1 {
+		return false
+	}
+	switch d.Instr.Opcode {
+	case code.OpCall, code.OpCallIndirect, code.OpSelect, code.OpMemoryGrow:
+		return false
+	}
+	return true
+}
+
+// endsStraightLineCode returns true if the def begins or ends a block or transfers control.
+func endsStraightLineCode(d *Def) bool {
+	if d.IsPseudo() {
+		return false
+	}
+	switch d.Instr.Opcode {
+	case code.OpBlock, code.OpLoop, code.OpIf, code.OpElse, code.OpEnd,
+		code.OpBr, code.OpBrIf, code.OpBrTable, code.OpReturn, code.OpUnreachable:
+		return true
+	}
+	return false
+}
+
+// forEachLocalRead calls fn with the index of each local read by the given def.
+func forEachLocalRead(d *Def, fn func(localidx uint32)) {
+	forEachUse(d.Expression, func(u *Use) {
+		if !u.IsTemp() && isLocalGet(u.X) {
+			fn(u.X.Instr.Localidx())
+		}
+	})
+	if isLocalGet(d.Expression) {
+		fn(d.Instr.Localidx())
+	}
+}
+
+// updateUsedLocals recomputes the set of locals that are read by the function's body.
+func (f *Function) updateUsedLocals() {
+	for i := range f.UsedLocals {
+		f.UsedLocals[i] = false
+	}
+	for _, d := range f.Body {
+		forEachLocalRead(d, func(localidx uint32) {
+			f.UsedLocals[localidx] = true
+		})
+	}
+}
+
+// A localValue records what is known about the value of a local at a point in the function's body.
+type localValue struct {
+	kind      localValueKind
+	opcode    byte   // the opcode of a constant value
+	immediate uint64 // the immediate of a constant value
+	source    uint32 // the source local of a copy
+}
+
+type localValueKind int
+
+const (
+	unknownValue localValueKind = iota
+	constantValue
+	copyValue
+)
+
+// A localState records what is known about each of a function's locals at a point in the function's body. A nil state
+// is unreachable.
+type localState []localValue
+
+func (s localState) clone() localState {
+	if s == nil {
+		return nil
+	}
+	return append(localState(nil), s...)
+}
+
+// join merges the state b into the state a and returns the result. a is modified in place.
+func join(a, b localState) localState {
+	switch {
+	case b == nil:
+		return a
+	case a == nil:
+		return b.clone()
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			a[i] = localValue{}
+		}
+	}
+	return a
+}
+
+// store updates the state to reflect a store of the given use to the given local.
+func (s localState) store(localidx uint32, u *Use, constants, copies bool) {
+	for i, v := range s {
+		if v.kind == copyValue && v.source == localidx {
+			s[i] = localValue{}
+		}
+	}
+
+	s[localidx] = localValue{}
+	switch {
+	case u.IsTemp():
+		// Nothing is known.
+	case constants && u.IsConst():
+		s[localidx] = localValue{kind: constantValue, opcode: u.X.Instr.Opcode, immediate: u.X.Instr.Immediate}
+	case copies && isLocalGet(u.X) && u.X.Instr.Localidx() != localidx:
+		s[localidx] = localValue{kind: copyValue, source: u.X.Instr.Localidx()}
+	}
+}
+
+// kill forgets what is known about the given locals.
+func (s localState) kill(locals *bitset.BitSet) {
+	for i, v := range s {
+		if locals.Test(uint(i)) || v.kind == copyValue && locals.Test(uint(v.source)) {
+			s[i] = localValue{}
+		}
+	}
+}
+
+// A propagationFrame tracks the state of an enclosing block during constant and copy propagation.
+type propagationFrame struct {
+	block *Block
+	entry localState // the state on entry to an if block
+	exit  localState // the merged state of the branches to the end of the block
+	else_ bool       // true if the frame's else has been seen
+}
+
+// propagate performs constant and copy propagation over the function's body. The analysis is a single forward pass
+// over the structured body: the state at the end of a block is the join of the states of the branches that target it,
+// and the state at the head of a loop forgets every local that is stored inside the loop.
+func (f *Function) propagate(constants, copies bool) {
+	// Find the locals stored inside each loop.
+	loopStores := map[*Block]*bitset.BitSet{}
+	var loops []*bitset.BitSet
+	for _, d := range f.Body {
+		if d.IsPseudo() {
+			continue
+		}
+		switch d.Instr.Opcode {
+		case code.OpLoop:
+			stores := &bitset.BitSet{}
+			loopStores[d.Block], loops = stores, append(loops, stores)
+		case code.OpBlock, code.OpIf:
+			loops = append(loops, nil)
+		case code.OpEnd:
+			if d.Block != nil {
+				loops = loops[:len(loops)-1]
+			}
+		case code.OpLocalSet:
+			for _, stores := range loops {
+				if stores != nil {
+					stores.Set(uint(d.Instr.Localidx()))
+				}
+			}
+		}
+	}
+
+	// Parameters are unknown on entry. All other locals are zero.
+	state := make(localState, len(f.Locals))
+	if constants {
+		for i := len(f.Signature.ParamTypes); i < len(f.Locals); i++ {
+			switch f.Locals[i] {
+			case wasm.ValueTypeI32:
+				state[i] = localValue{kind: constantValue, opcode: code.OpI32Const}
+			case wasm.ValueTypeI64:
+				state[i] = localValue{kind: constantValue, opcode: code.OpI64Const}
+			case wasm.ValueTypeF32:
+				state[i] = localValue{kind: constantValue, opcode: code.OpF32Const}
+			case wasm.ValueTypeF64:
+				state[i] = localValue{kind: constantValue, opcode: code.OpF64Const}
+			}
+		}
+	}
+
+	var frames []*propagationFrame
+	branch := func(target *Block, state localState) {
+		if target.Entry.Instr.Opcode == code.OpLoop {
+			return
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			if frames[i].block == target {
+				frames[i].exit = join(frames[i].exit, state)
+				return
+			}
+		}
+	}
+
+	for _, d := range f.Body {
+		if d.IsPseudo() {
+			continue
+		}
+		if state != nil {
+			substitute(d.Expression, state, constants)
+			if isValueDef(d) {
+				if isLocalGet(d.Expression) {
+					substituteLocal(d.Expression, state, true)
+				} else if constants {
+					fold(d.Expression, d.Types)
+				}
+			}
+		}
+
+		switch d.Instr.Opcode {
+		case code.OpBlock, code.OpLoop, code.OpIf:
+			frame := &propagationFrame{block: d.Block}
+			switch {
+			case d.Instr.Opcode == code.OpIf:
+				frame.entry = state.clone()
+			case d.Instr.Opcode == code.OpLoop && state != nil:
+				state.kill(loopStores[d.Block])
+			}
+			frames = append(frames, frame)
+		case code.OpElse:
+			frame := frames[len(frames)-1]
+			frame.exit, frame.else_ = join(frame.exit, state), true
+			state = frame.entry.clone()
+		case code.OpEnd:
+			if d.Block == nil {
+				break
+			}
+			frame := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if d.Block.Entry.Instr.Opcode != code.OpLoop {
+				if d.Block.Entry.Instr.Opcode == code.OpIf && !frame.else_ {
+					frame.exit = join(frame.exit, frame.entry)
+				}
+				state = join(frame.exit, state)
+			}
+		case code.OpBr, code.OpBrTable:
+			if state != nil {
+				for _, target := range d.BranchTargets {
+					branch(target, state)
+				}
+			}
+			state = nil
+		case code.OpBrIf:
+			if state != nil {
+				branch(d.BranchTargets[0], state)
+			}
+		case code.OpReturn, code.OpUnreachable:
+			state = nil
+		case code.OpLocalSet:
+			if state != nil {
+				state.store(d.Instr.Localidx(), d.Uses[0], constants, copies)
+			}
+		}
+	}
+}
+
+// substitute replaces the reads of locals in the given expression's operands with their known values and folds any
+// operands that are constant. Returns true if any operand changed.
+func substitute(x *Expression, state localState, constants bool) bool {
+	changed := false
+	for i, u := range x.Uses {
+		// Leave zero divisors of remainders alone: the backend may not be able to express them as constants.
+		allowZero := !(isRem(x) && i == 1)
+
+		switch {
+		case u.IsTemp():
+			// Nothing to do.
+		case isLocalGet(u.X):
+			changed = substituteLocal(u.X, state, allowZero) || changed
+		default:
+			changed = substitute(u.X, state, constants) || changed
+			if constants && (allowZero || !isZero(u.X)) && fold(u.X, []wasm.ValueType{u.Type}) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// substituteLocal replaces a read of a local with the local's known value, if any. Returns true if the read was
+// replaced.
+func substituteLocal(x *Expression, state localState, allowZero bool) bool {
+	switch v := state[x.Instr.Localidx()]; v.kind {
+	case constantValue:
+		if v.immediate != 0 || allowZero {
+			x.Instr, x.Flags = code.Instruction{Opcode: v.opcode, Immediate: v.immediate}, 0
+			return true
+		}
+	case copyValue:
+		x.Instr = code.LocalGet(v.source)
+		return true
+	}
+	return false
+}
+
+// isZero returns true if the expression evaluates to zero.
+func isZero(x *Expression) bool {
+	v, ok := evaluate(x)
+	return ok && v == 0
+}
+
+// eliminateDeadCode removes stores and drops that have no effect, conditional branches that are never taken, and code
+// that can never execute. A store to a local is dead if the local is not live after the store. Dead stores with side
+// effects are turned into drops. Ifs whose conditions are constant are replaced by blocks that contain the arm that is
+// taken, and conditional branches that are always taken become unconditional.
+func (f *Function) eliminateDeadCode() {
+	for changed := true; changed; {
+		changed = false
+
+		// Turn dead stores into drops.
+		for _, d := range f.deadStores() {
+			d.Instr, d.Flags, changed = code.Drop(), 0, true
+		}
+
+		// Remove pure drops and branches whose conditions are constant false.
+		body := f.Body[:0]
+		for _, d := range f.Body {
+			switch d.Instr.Opcode {
+			case code.OpDrop:
+				if isPure(d.Uses[0]) {
+					changed = true
+					continue
+				}
+			case code.OpBrIf:
+				if taken, ok := constCondition(d.Uses[len(d.Uses)-1]); ok && !taken && len(d.Types) == 0 {
+					changed = true
+					continue
+				}
+			}
+			body = append(body, d)
+		}
+		f.Body = body
+
+		if f.foldConstantIfs() {
+			changed = true
+		}
+
+		// Removing a branch may leave its target untargeted, which may in turn leave the code that follows the
+		// target's end unreachable.
+		f.updateBranchTargets()
+		if f.removeUnreachableCode() {
+			changed = true
+			f.updateBranchTargets()
+		}
+	}
+}
+
+// constCondition returns the value of the given boolean condition and true if the condition is constant.
+func constCondition(cond *Use) (bool, bool) {
+	if cond.IsTemp() {
+		return false, false
+	}
+	v, ok := evaluate(cond.X)
+	return v != 0, ok
+}
+
+// isTerminator returns true if the def is never followed by the def after it.
+func isTerminator(d *Def) bool {
+	if d.IsPseudo() {
+		return false
+	}
+	switch d.Instr.Opcode {
+	case code.OpBr, code.OpBrTable, code.OpReturn, code.OpUnreachable:
+		return true
+	}
+	return false
+}
+
+// isBlockEntry returns true if the def begins a block, loop, or if.
+func isBlockEntry(d *Def) bool {
+	return d.Block != nil && !d.IsPseudo() && d.Instr.Opcode != code.OpElse && d.Instr.Opcode != code.OpEnd
+}
+
+// updateBranchTargets marks the blocks that are the targets of branches in the function's body.
+func (f *Function) updateBranchTargets() {
+	for _, d := range f.Body {
+		if isBlockEntry(d) {
+			d.Block.BranchTarget = false
+		}
+	}
+	for _, d := range f.Body {
+		for _, target := range d.BranchTargets {
+			target.BranchTarget = true
+		}
+	}
+}
+
+// dropLiveTemps returns drops attributed to the instruction at ip of the temps read by the given uses that are not
+// defined by the given defs. The uses and defs are about to be removed; the drops keep the temps used.
+func (f *Function) dropLiveTemps(ip int, defs []*Def, uses []Uses) []*Def {
+	defined := map[int]bool{}
+	for _, d := range defs {
+		for i := range d.Types {
+			defined[d.Temp+i] = true
+		}
+		if isBlockEntry(d) {
+			for i := range d.Block.Outs {
+				defined[d.Block.OutTemp+i] = true
+			}
+		}
+	}
+
+	var drops []*Def
+	dropTemp := func(u *Use) {
+		if u.IsTemp() && !defined[u.Temp] {
+			defined[u.Temp] = true
+			drops = append(drops, &Def{
+				Expression: &Expression{
+					Function: f,
+					IP:       ip,
+					Instr:    code.Drop(),
+					Uses:     []*Use{{Function: f, Type: u.Type, Temp: u.Temp}},
+				},
+			})
+		}
+	}
+	dropUses := func(uses Uses) {
+		for _, u := range uses {
+			if !u.IsTemp() {
+				forEachUse(u.X, dropTemp)
+			}
+			dropTemp(u)
+		}
+	}
+	for _, d := range defs {
+		dropUses(d.Uses)
+	}
+	for _, uses := range uses {
+		dropUses(uses)
+	}
+	return drops
+}
+
+// foldConstantIfs replaces each if whose condition is constant with a block that contains the arm that is taken. The
+// arm that is not taken is removed. Returns true if any if was replaced.
+func (f *Function) foldConstantIfs() bool {
+	changed := false
+	for i := 0; i < len(f.Body); i++ {
+		d := f.Body[i]
+		if d.IsPseudo() || d.Instr.Opcode != code.OpIf {
+			continue
+		}
+		ins, cond := d.Uses[:len(d.Uses)-1], d.Uses[len(d.Uses)-1]
+		taken, ok := constCondition(cond)
+		if !ok {
+			continue
+		}
+
+		b, elseIndex, endIndex := d.Block, -1, -1
+		for j := i + 1; endIndex == -1; j++ {
+			if e := f.Body[j]; e.Block == b {
+				if e.Instr.Opcode == code.OpElse {
+					elseIndex = j
+				} else {
+					endIndex = j
+				}
+			}
+		}
+		end := f.Body[endIndex]
+
+		// Pick the arm that is taken and the values with which it reaches the end of the if.
+		var arm, dead []*Def
+		var deadUses []Uses
+		switch {
+		case taken && elseIndex != -1:
+			arm, dead = f.Body[i+1:elseIndex], f.Body[elseIndex+1:endIndex]
+			deadUses, end.Uses = []Uses{end.Uses}, f.Body[elseIndex].Uses
+		case taken:
+			arm = f.Body[i+1 : endIndex]
+		case elseIndex != -1:
+			arm, dead = f.Body[elseIndex+1:endIndex], f.Body[i+1:elseIndex]
+			deadUses = []Uses{f.Body[elseIndex].Uses}
+		default:
+			// An if without an else passes its inputs through when its condition is false.
+			dead, deadUses = f.Body[i+1:endIndex], []Uses{end.Uses}
+			end.Uses = make(Uses, len(b.Ins))
+			for i, t := range b.Ins {
+				end.Uses[i] = &Use{Function: f, Type: t, Temp: b.InTemp + i}
+			}
+		}
+
+		d.Instr, d.Uses, b.Else = code.Block(d.Instr.Immediate), ins, nil
+
+		body := append([]*Def(nil), f.Body[:i+1]...)
+		body = append(body, f.dropLiveTemps(d.IP, dead, deadUses)...)
+		body = append(body, arm...)
+		body = append(body, end)
+		f.Body, changed = append(body, f.Body[endIndex+1:]...), true
+	}
+	return changed
+}
+
+// removeUnreachableCode removes the defs that can never execute: the defs that follow a branch, return, or
+// unreachable up to the end of the enclosing arm, and the defs that follow a block whose end is unreachable and that
+// is not the target of a branch. The values that would have been produced at the end of an unreachable arm are
+// removed as well. Conditional branches that are always taken become unconditional branches. Returns true if any code
+// was removed.
+//
+// Temps that are defined by reachable code but that were only read by removed code are dropped just before the def
+// that made the code unreachable.
+func (f *Function) removeUnreachableCode() bool {
+	type frame struct {
+		block        *Block
+		reachable    bool // true if the block's entry is reachable
+		fallsThrough bool // true if the end of the first arm of an if is reachable
+	}
+
+	var frames []frame
+	var body, dead []*Def
+	var deadUses []Uses
+	reachable, terminator, changed := true, 0, false
+
+	// flush drops the live temps read by the code that was removed since the last terminator.
+	flush := func() {
+		if len(dead) == 0 && len(deadUses) == 0 {
+			return
+		}
+		drops := f.dropLiveTemps(body[terminator].IP, dead, deadUses)
+		body = append(body[:terminator], append(drops, body[terminator:]...)...)
+		dead, deadUses, changed = nil, nil, true
+	}
+
+	for _, d := range f.Body {
+		isEnd := d.Block != nil && !d.IsPseudo() && (d.Instr.Opcode == code.OpElse || d.Instr.Opcode == code.OpEnd)
+
+		switch {
+		case isEnd && frames[len(frames)-1].reachable:
+			top := &frames[len(frames)-1]
+			if !reachable && len(d.Uses) != 0 {
+				deadUses, d.Uses = append(deadUses, d.Uses), nil
+			}
+
+			if d.Instr.Opcode == code.OpElse {
+				top.fallsThrough, reachable = reachable, true
+			} else {
+				b := d.Block
+				b.Unreachable = !reachable
+				switch {
+				case b.Entry.Instr.Opcode == code.OpIf:
+					reachable = reachable || top.fallsThrough || b.Else == nil || b.BranchTarget
+				case b.Entry.Instr.Opcode != code.OpLoop:
+					reachable = reachable || b.BranchTarget
+				}
+				frames = frames[:len(frames)-1]
+
+				// The code that follows the function's body is always kept.
+				reachable = reachable || len(frames) == 0
+			}
+
+			if reachable {
+				flush()
+			}
+			body = append(body, d)
+			continue
+
+		case !reachable:
+			dead = append(dead, d)
+			switch {
+			case isBlockEntry(d):
+				frames = append(frames, frame{block: d.Block})
+			case isEnd && d.Instr.Opcode == code.OpEnd:
+				frames = frames[:len(frames)-1]
+			}
+			continue
+		}
+
+		if !d.IsPseudo() && d.Instr.Opcode == code.OpBrIf && len(d.Types) == 0 {
+			if taken, ok := constCondition(d.Uses[len(d.Uses)-1]); ok && taken {
+				d.Instr.Opcode, d.Uses, changed = code.OpBr, d.Uses[:len(d.Uses)-1], true
+			}
+		}
+
+		body = append(body, d)
+		if isBlockEntry(d) {
+			frames = append(frames, frame{block: d.Block, reachable: true})
+		}
+		if isTerminator(d) {
+			reachable, terminator = false, len(body)-1
+		}
+	}
+	flush()
+
+	f.Body = body
+	return changed
+}
+
+// deadStores returns the stores to locals that are not live after the store. Liveness is computed by a backward
+// pass over the structured body: the locals live at a branch are the locals live at its target, which is the end of
+// a block or the head of a loop. The pass is repeated until the locals live at the head of each loop are stable.
+func (f *Function) deadStores() []*Def {
+	targets := map[*Block]*bitset.BitSet{} // the locals live at the target of a branch to each block
+	elses := map[*Block]*bitset.BitSet{}   // the locals live at the start of each else branch
+
+	liveAt := func(b *Block) *bitset.BitSet {
+		if live, ok := targets[b]; ok {
+			return live.Clone()
+		}
+		return &bitset.BitSet{}
+	}
+
+	for {
+		var dead []*Def
+		changed, live := false, &bitset.BitSet{}
+		for i := len(f.Body) - 1; i >= 0; i-- {
+			d := f.Body[i]
+			if d.IsPseudo() {
+				continue
+			}
+			switch d.Instr.Opcode {
+			case code.OpEnd:
+				if d.Block != nil && d.Block.Entry.Instr.Opcode != code.OpLoop {
+					targets[d.Block] = live.Clone()
+				}
+			case code.OpElse:
+				elses[d.Block], live = live, liveAt(d.Block)
+			case code.OpIf:
+				if d.Block.Else != nil {
+					live.InPlaceUnion(elses[d.Block])
+				} else {
+					live.InPlaceUnion(liveAt(d.Block))
+				}
+			case code.OpLoop:
+				if head, ok := targets[d.Block]; !ok || !head.Equal(live) {
+					targets[d.Block], changed = live.Clone(), true
+				}
+			case code.OpBr, code.OpBrTable:
+				live = &bitset.BitSet{}
+				for _, target := range d.BranchTargets {
+					live.InPlaceUnion(liveAt(target))
+				}
+			case code.OpBrIf:
+				live.InPlaceUnion(liveAt(d.BranchTargets[0]))
+			case code.OpReturn, code.OpUnreachable:
+				live = &bitset.BitSet{}
+			case code.OpLocalSet:
+				localidx := uint(d.Instr.Localidx())
+				if !live.Test(localidx) {
+					dead = append(dead, d)
+				}
+				live.Clear(localidx)
+			}
+
+			forEachLocalRead(d, func(localidx uint32) {
+				live.Set(uint(localidx))
+			})
+		}
+		if !changed {
+			return dead
+		}
+	}
+}
+
+// An availableLoad is a memory load whose result may be reused by later loads of the same address.
+type availableLoad struct {
+	host    *Def  // the def that contains the load
+	use     *Use  // the use of the load, or nil if the load is the host's expression
+	temp    int   // the temp that holds the result of the load, or -1 if the load has not been hoisted
+	locals  []int // the locals read by the load's address
+	globals bool  // true if the load's address reads globals
+}
+
+// eliminateRedundantLoads replaces loads that are repeated within straight-line code with a temp that holds the
+// result of the first load. A load is repeated if it has the same opcode, offset, and address expression as an earlier
+// load and no def between the two may store to memory, to a local read by the address, or to a global read by the
+// address. The first load is hoisted into a new temp immediately before the def that contains it.
+//
+// In order to avoid changing the trap raised by a def, loads are only hoisted out of defs that contain no other
+// operations that may trap. Loads are never hoisted out of or replaced in the operands of selects and conditional
+// branches, which may not be evaluated.
+func (f *Function) eliminateRedundantLoads() {
+	hoisted := map[*Def][]*Def{}
+	removed := map[*Def]bool{}
+	renamed := map[int]int{}
+
+	materialize := func(load *availableLoad) int {
+		if load.temp < 0 {
+			d := &Def{Expression: load.use.X, Types: []wasm.ValueType{load.use.Type}, Temp: len(f.Locals) + f.Temps}
+			f.Temps++
+
+			hoisted[load.host] = append(hoisted[load.host], d)
+			load.use.X, load.use.Temp, load.temp = nil, d.Temp, d.Temp
+		}
+		return load.temp
+	}
+
+	available := map[string]*availableLoad{}
+	for _, d := range f.Body {
+		switch {
+		case d.Instr.Opcode == code.OpBrIf || d.Instr.Opcode == code.OpBrTable || d.Instr.Opcode == code.OpSelect:
+			// Skip.
+		case isValueDef(d) && isLoad(d.Expression):
+			if key, locals, globals, ok := loadKey(d.Expression); ok {
+				if load, ok := available[key]; ok {
+					renamed[d.Temp], removed[d] = materialize(load), true
+				} else {
+					available[key] = &availableLoad{host: d, temp: d.Temp, locals: locals, globals: globals}
+				}
+			}
+		default:
+			hoistable := true
+			forEachUse(d.Expression, func(u *Use) {
+				if !u.IsTemp() && mayTrap(u.X) {
+					hoistable = false
+				}
+			})
+
+			forEachUse(d.Expression, func(u *Use) {
+				if u.IsTemp() || !isLoad(u.X) {
+					return
+				}
+				key, locals, globals, ok := loadKey(u.X)
+				if !ok {
+					return
+				}
+				if load, ok := available[key]; ok {
+					u.X, u.Temp = nil, materialize(load)
+				} else if hoistable {
+					available[key] = &availableLoad{host: d, use: u, temp: -1, locals: locals, globals: globals}
+				}
+			})
+		}
+
+		// Forget loads that may be invalidated by the def.
+		switch {
+		case d.Flags&FlagsStoreMem != 0:
+			available = map[string]*availableLoad{}
+		case d.Flags&FlagsStoreGlobal != 0:
+			for key, load := range available {
+				if load.globals {
+					delete(available, key)
+				}
+			}
+		case d.Instr.Opcode == code.OpLocalSet:
+			localidx := int(d.Instr.Localidx())
+			for key, load := range available {
+				for _, l := range load.locals {
+					if l == localidx {
+						delete(available, key)
+						break
+					}
+				}
+			}
+		case endsStraightLineCode(d):
+			available = map[string]*availableLoad{}
+		}
+	}
+
+	if len(hoisted) == 0 && len(removed) == 0 {
+		return
+	}
+
+	body := make([]*Def, 0, len(f.Body))
+	for _, d := range f.Body {
+		if !removed[d] {
+			body = append(body, hoisted[d]...)
+			body = append(body, d)
+		}
+	}
+	f.Body = body
+
+	if len(renamed) != 0 {
+		for _, d := range f.Body {
+			forEachUse(d.Expression, func(u *Use) {
+				if temp, ok := renamed[u.Temp]; ok && u.IsTemp() {
+					u.Temp = temp
+				}
+			})
+		}
+	}
+}
+
+// loadKey returns a key that identifies the value loaded by the given load. Two loads with the same key load the same
+// value if no store intervenes. ok is false if the load's address cannot be keyed: the address must consist only of
+// constants, temps, reads of locals and globals, and operations that cannot trap.
+func loadKey(x *Expression) (key string, locals []int, globals bool, ok bool) {
+	address, locals, globals, ok := addressKey(x.Uses[0])
+	if !ok {
+		return "", nil, false, false
+	}
+	return fmt.Sprintf("%d+%d:%s", x.Instr.Opcode, x.Instr.Offset(), address), locals, globals, true
+}
+
+// addressKey returns a key that identifies the value of the given address. Two addresses with the same key have the
+// same value if no store to a local or global read by the address intervenes. ok is false if the address cannot be
+// keyed: the address must consist only of constants, temps, reads of locals and globals, and operations that cannot
+// trap.
+func addressKey(address *Use) (key string, locals []int, globals bool, ok bool) {
+	var b strings.Builder
+
+	var write func(u *Use) bool
+	write = func(u *Use) bool {
+		if u.IsTemp() {
+			fmt.Fprintf(&b, "t%d", u.Temp)
+			return true
+		}
+
+		x := u.X
+		switch {
+		case x.IsPseudo() || x.Flags&^(FlagsLoadLocal|FlagsLoadGlobal) != 0 || mayTrap(x):
+			return false
+		case x.Instr.Opcode == code.OpLocalGet:
+			locals = append(locals, int(x.Instr.Localidx()))
+		case x.Instr.Opcode == code.OpGlobalGet:
+			globals = true
+		}
+
+		fmt.Fprintf(&b, "(%d %d", x.Instr.Opcode, x.Instr.Immediate)
+		for _, u := range x.Uses {
+			b.WriteByte(' ')
+			if !write(u) {
+				return false
+			}
+		}
+		b.WriteByte(')')
+		return true
+	}
+	if !write(address) {
+		return "", nil, false, false
+	}
+	return b.String(), locals, globals, true
+}
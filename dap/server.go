@@ -0,0 +1,700 @@
+// Package dap implements a Debug Adapter Protocol server for WASI commands that run under warp's interpreter.
+//
+// The server debugs a single module. Breakpoints and stack frames refer to source files if the module contains
+// DWARF line information. Code without line information is presented as a disassembly of the module in the
+// WebAssembly text format. Each instruction in the disassembly occupies its own line.
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/disasm"
+	"github.com/pgavlin/warp/interpreter"
+	"github.com/pgavlin/warp/load"
+	"github.com/pgavlin/warp/wasi"
+	"github.com/pgavlin/warp/wasm"
+)
+
+// Options configures a debug adapter server.
+type Options struct {
+	Preopen []wasi.Preopen // The directories to mount in the program's filesystem.
+}
+
+// errDisconnected is raised inside a stopped program when the client ends the debugging session.
+var errDisconnected = errors.New("disconnected")
+
+const (
+	// The ID of the program's only thread.
+	threadID = 1
+
+	// The source reference for the module's disassembly.
+	disassemblyReference = 1
+)
+
+// Variable scopes. Variable references encode a frame index and a scope.
+const (
+	scopeLocals = iota
+	scopeStack
+	scopeGlobals
+
+	scopeCount
+)
+
+// A stepOrigin records the source line from which a line-granularity step began.
+type stepOrigin struct {
+	mode     interpreter.ResumeMode
+	depth    int
+	function uint32
+	file     string
+	line     int
+}
+
+// contains returns true if the given stop is on the origin's line.
+func (o *stepOrigin) contains(stop *interpreter.Stop) bool {
+	if len(stop.Frames) != o.depth || stop.Frames[0].Function != o.function {
+		return false
+	}
+	file, line, ok := stop.Frames[0].SourceLocation()
+	return ok && file == o.file && line == o.line
+}
+
+type server struct {
+	options Options
+	r       *bufio.Reader
+
+	wm  sync.Mutex
+	w   io.Writer
+	seq int
+
+	// The program.
+	launch      launchArguments
+	def         exec.ModuleDefinition
+	debugger    *interpreter.Debugger
+	disassembly *disasm.Disassembly
+
+	launched     bool
+	configured   bool
+	started      bool
+	disconnected bool
+
+	sourceBreakpoints   map[string][]*interpreter.Breakpoint // Breakpoints set by setBreakpoints, by source.
+	functionBreakpoints []*interpreter.Breakpoint            // Breakpoints set by setFunctionBreakpoints.
+
+	// The program's execution state.
+	m        sync.Mutex
+	stop     *interpreter.Stop // The current stop, if the program is stopped.
+	step     *stepOrigin       // The origin of the current step, if any.
+	entry    bool              // True if the next pause is the program's entry point.
+	quitting bool              // True if the program should exit at its next stop.
+	resume   chan interpreter.ResumeMode
+	done     chan struct{}
+}
+
+// Serve runs a debug adapter that reads requests from r and writes responses and events to w. Serve returns once the
+// client disconnects or r is exhausted.
+func Serve(r io.Reader, w io.Writer, options *Options) error {
+	s := &server{
+		r:                 bufio.NewReader(r),
+		w:                 w,
+		sourceBreakpoints: map[string][]*interpreter.Breakpoint{},
+		resume:            make(chan interpreter.ResumeMode),
+		done:              make(chan struct{}),
+	}
+	if options != nil {
+		s.options = *options
+	}
+
+	for !s.disconnected {
+		content, err := readMessage(s.r)
+		if err != nil {
+			s.kill()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			s.kill()
+			return fmt.Errorf("decoding message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if err := s.handle(&req); err != nil {
+			s.respond(&req, false, err.Error(), nil)
+		}
+	}
+	return nil
+}
+
+// send writes a message to the client.
+func (s *server) send(message interface{}, header *protocolMessage) {
+	s.wm.Lock()
+	defer s.wm.Unlock()
+
+	s.seq++
+	header.Seq = s.seq
+	writeMessage(s.w, message)
+}
+
+// respond sends a response to the given request.
+func (s *server) respond(req *request, success bool, message string, body interface{}) {
+	resp := &response{
+		protocolMessage: protocolMessage{Type: "response"},
+		RequestSeq:      req.Seq,
+		Success:         success,
+		Command:         req.Command,
+		Message:         message,
+		Body:            body,
+	}
+	s.send(resp, &resp.protocolMessage)
+}
+
+// event sends an event to the client.
+func (s *server) event(name string, body interface{}) {
+	ev := &event{
+		protocolMessage: protocolMessage{Type: "event"},
+		Event:           name,
+		Body:            body,
+	}
+	s.send(ev, &ev.protocolMessage)
+}
+
+// An outputWriter sends the data written to it to the client as output events.
+type outputWriter struct {
+	s        *server
+	category string
+}
+
+func (w outputWriter) Write(b []byte) (int, error) {
+	w.s.event("output", &outputEvent{Category: w.category, Output: string(b)})
+	return len(b), nil
+}
+
+// handle handles a single request. If handle returns an error, the request has not been answered.
+func (s *server) handle(req *request) error {
+	decode := func(args interface{}) error {
+		if len(req.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(req.Arguments, args)
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, true, "", &capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsSteppingGranularity:      true,
+			SupportsTerminateRequest:         true,
+		})
+	case "launch":
+		var args launchArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		if err := s.load(&args); err != nil {
+			return err
+		}
+		s.respond(req, true, "", nil)
+
+		// Breakpoints can be resolved once the program has been loaded.
+		s.event("initialized", nil)
+		s.startIfReady()
+	case "configurationDone":
+		s.configured = true
+		s.respond(req, true, "", nil)
+		s.startIfReady()
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		breakpoints, err := s.setBreakpoints(&args)
+		if err != nil {
+			return err
+		}
+		s.respond(req, true, "", &breakpointsResponse{Breakpoints: breakpoints})
+	case "setFunctionBreakpoints":
+		var args setFunctionBreakpointsArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		breakpoints, err := s.setFunctionBreakpoints(&args)
+		if err != nil {
+			return err
+		}
+		s.respond(req, true, "", &breakpointsResponse{Breakpoints: breakpoints})
+	case "setExceptionBreakpoints":
+		s.respond(req, true, "", &breakpointsResponse{Breakpoints: []breakpoint{}})
+	case "threads":
+		s.respond(req, true, "", &threadsResponse{Threads: []thread{{ID: threadID, Name: "main"}}})
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		frames, err := s.stackTrace(&args)
+		if err != nil {
+			return err
+		}
+		s.respond(req, true, "", frames)
+	case "scopes":
+		var args scopesArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		scopes, err := s.scopes(&args)
+		if err != nil {
+			return err
+		}
+		s.respond(req, true, "", scopes)
+	case "variables":
+		var args variablesArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		variables, err := s.variables(&args)
+		if err != nil {
+			return err
+		}
+		s.respond(req, true, "", variables)
+	case "source":
+		var args sourceArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+		reference := args.SourceReference
+		if args.Source != nil && args.Source.SourceReference != 0 {
+			reference = args.Source.SourceReference
+		}
+		if reference != disassemblyReference || s.disassembly == nil {
+			return fmt.Errorf("unknown source reference %v", reference)
+		}
+		s.respond(req, true, "", &sourceResponse{Content: s.disassembly.Text, MimeType: "text/x-wasm"})
+	case "continue", "next", "stepIn", "stepOut":
+		var args stepArguments
+		if err := decode(&args); err != nil {
+			return err
+		}
+
+		var mode interpreter.ResumeMode
+		var body interface{}
+		switch req.Command {
+		case "continue":
+			mode, body = interpreter.Continue, &continueResponse{AllThreadsContinued: true}
+		case "next":
+			mode = interpreter.StepOver
+		case "stepIn":
+			mode = interpreter.StepInto
+		case "stepOut":
+			mode = interpreter.StepOut
+		}
+
+		s.m.Lock()
+		stop := s.stop
+		if stop == nil {
+			s.m.Unlock()
+			return errors.New("the program is not stopped")
+		}
+		s.stop, s.step = nil, nil
+		if mode != interpreter.Continue && args.Granularity != "instruction" {
+			if file, line, ok := stop.Frames[0].SourceLocation(); ok {
+				s.step = &stepOrigin{mode: mode, depth: len(stop.Frames), function: stop.Frames[0].Function, file: file, line: line}
+			}
+		}
+		s.m.Unlock()
+
+		s.respond(req, true, "", body)
+		s.resume <- mode
+	case "pause":
+		if s.debugger != nil {
+			s.debugger.Pause()
+		}
+		s.respond(req, true, "", nil)
+	case "terminate":
+		s.kill()
+		s.respond(req, true, "", nil)
+	case "disconnect":
+		s.kill()
+		s.disconnected = true
+		s.respond(req, true, "", nil)
+	default:
+		return fmt.Errorf("unsupported request %q", req.Command)
+	}
+	return nil
+}
+
+// load loads the program to debug.
+func (s *server) load(args *launchArguments) error {
+	if s.launched {
+		return errors.New("a program has already been launched")
+	}
+	if args.Program == "" {
+		return errors.New("missing program path")
+	}
+
+	mod, err := load.LoadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	if mod.Import != nil {
+		for _, entry := range mod.Import.Entries {
+			if entry.ModuleName == "go" {
+				return errors.New("only WASI programs can be debugged")
+			}
+		}
+	}
+
+	disassembly, err := disasm.Disassemble(mod)
+	if err != nil {
+		return err
+	}
+
+	s.launch, s.disassembly, s.launched = *args, disassembly, true
+	s.debugger = interpreter.NewDebugger(s.stopped)
+	s.def = interpreter.NewModuleDefinition(mod, &interpreter.Options{Debugger: s.debugger})
+	return nil
+}
+
+// startIfReady starts the program once it has been launched and configured.
+func (s *server) startIfReady() {
+	if !s.launched || !s.configured || s.started {
+		return
+	}
+	s.started = true
+
+	if s.launch.StopOnEntry {
+		s.entry = true
+		s.debugger.Pause()
+	}
+
+	go func() {
+		defer close(s.done)
+
+		code, ok := s.run()
+		if ok {
+			s.event("exited", &exitedEvent{ExitCode: code})
+		}
+		s.event("terminated", nil)
+	}()
+}
+
+// run runs the program and returns its exit code. run returns false if the program was killed.
+func (s *server) run() (code int, ok bool) {
+	defer func() {
+		if x := recover(); x != nil {
+			if x == errDisconnected {
+				code, ok = 0, false
+				return
+			}
+			s.event("output", &outputEvent{Category: "stderr", Output: fmt.Sprintf("%v\n", x)})
+			code, ok = 1, true
+		}
+	}()
+
+	env := map[string]string{}
+	for _, v := range os.Environ() {
+		kvp := strings.SplitN(v, "=", 2)
+		env[kvp[0]] = kvp[1]
+	}
+	for k, v := range s.launch.Env {
+		env[k] = v
+	}
+
+	program := s.launch.Program
+	name := strings.TrimSuffix(program, filepath.Ext(program))
+
+	err := wasi.Run(name, s.def, &wasi.RunOptions{
+		Options: &wasi.Options{
+			Env:     env,
+			Args:    s.launch.Args,
+			Stdin:   bytes.NewReader(nil),
+			Stdout:  outputWriter{s: s, category: "stdout"},
+			Stderr:  outputWriter{s: s, category: "stderr"},
+			Preopen: s.options.Preopen,
+		},
+		Resolver: load.NewFSResolver(os.DirFS("."), load.Intepret),
+	})
+	switch err := err.(type) {
+	case nil:
+		return 0, true
+	case *wasi.ExitError:
+		return err.Code(), true
+	default:
+		s.event("output", &outputEvent{Category: "stderr", Output: fmt.Sprintf("%v\n", err)})
+		return 1, true
+	}
+}
+
+// kill stops the program, if it is running, and waits for it to exit.
+func (s *server) kill() {
+	if !s.started {
+		return
+	}
+
+	s.m.Lock()
+	s.quitting = true
+	stopped := s.stop != nil
+	s.stop = nil
+	s.m.Unlock()
+
+	if stopped {
+		close(s.resume)
+	} else {
+		s.debugger.Pause()
+	}
+	<-s.done
+}
+
+// stopped is called by the debugger each time the program stops.
+func (s *server) stopped(stop *interpreter.Stop) interpreter.ResumeMode {
+	s.m.Lock()
+	if s.quitting {
+		s.m.Unlock()
+		panic(errDisconnected)
+	}
+
+	// Line-granularity steps continue until they leave the line on which they began.
+	if step := s.step; step != nil && stop.Reason == interpreter.StopStep && step.contains(stop) {
+		s.m.Unlock()
+		return step.mode
+	}
+
+	s.stop, s.step = stop, nil
+
+	ev := &stoppedEvent{ThreadID: threadID, AllThreadsStopped: true}
+	switch stop.Reason {
+	case interpreter.StopBreakpoint:
+		ev.Reason, ev.HitBreakpointIDs = "breakpoint", []int{stop.Breakpoint.ID}
+	case interpreter.StopStep:
+		ev.Reason = "step"
+	case interpreter.StopPause:
+		ev.Reason = "pause"
+		if s.entry {
+			ev.Reason = "entry"
+		}
+	}
+	s.entry = false
+	s.m.Unlock()
+
+	s.event("stopped", ev)
+
+	mode, ok := <-s.resume
+	if !ok {
+		panic(errDisconnected)
+	}
+	return mode
+}
+
+// setBreakpoints replaces the breakpoints in a source.
+func (s *server) setBreakpoints(args *setBreakpointsArguments) ([]breakpoint, error) {
+	if !s.launched {
+		return nil, errors.New("the program has not been launched")
+	}
+
+	key := args.Source.Path
+	if args.Source.SourceReference != 0 {
+		if args.Source.SourceReference != disassemblyReference {
+			return nil, fmt.Errorf("unknown source reference %v", args.Source.SourceReference)
+		}
+		key = ""
+	}
+
+	for _, b := range s.sourceBreakpoints[key] {
+		s.debugger.ClearBreakpoint(b)
+	}
+	delete(s.sourceBreakpoints, key)
+
+	var set []*interpreter.Breakpoint
+	result := make([]breakpoint, len(args.Breakpoints))
+	for i, requested := range args.Breakpoints {
+		src := args.Source
+		result[i] = breakpoint{Source: &src, Line: requested.Line}
+
+		if key == "" {
+			function, ip, ok := s.disassembly.Location(requested.Line)
+			if !ok {
+				result[i].Message = "no code on this line"
+				continue
+			}
+			b := s.debugger.SetBreakpoint(function, ip)
+			result[i].ID, result[i].Verified = b.ID, true
+			result[i].Line, _ = s.disassembly.Line(function, ip)
+			set = append(set, b)
+			continue
+		}
+
+		breakpoints, err := s.debugger.SetLineBreakpoint(key, requested.Line)
+		if err != nil {
+			result[i].Message = err.Error()
+			continue
+		}
+		result[i].ID, result[i].Verified = breakpoints[0].ID, true
+		set = append(set, breakpoints...)
+	}
+	if len(set) != 0 {
+		s.sourceBreakpoints[key] = set
+	}
+	return result, nil
+}
+
+// setFunctionBreakpoints replaces the breakpoints set by function name. A name may also be a function index.
+func (s *server) setFunctionBreakpoints(args *setFunctionBreakpointsArguments) ([]breakpoint, error) {
+	if !s.launched {
+		return nil, errors.New("the program has not been launched")
+	}
+
+	for _, b := range s.functionBreakpoints {
+		s.debugger.ClearBreakpoint(b)
+	}
+	s.functionBreakpoints = nil
+
+	result := make([]breakpoint, len(args.Breakpoints))
+	for i, requested := range args.Breakpoints {
+		var b *interpreter.Breakpoint
+		if index, err := strconv.ParseUint(requested.Name, 0, 32); err == nil {
+			b = s.debugger.SetBreakpoint(uint32(index), 0)
+		} else if b, err = s.debugger.SetFunctionBreakpoint(requested.Name, 0); err != nil {
+			result[i].Message = err.Error()
+			continue
+		}
+		result[i].ID, result[i].Verified = b.ID, true
+		s.functionBreakpoints = append(s.functionBreakpoints, b)
+	}
+	return result, nil
+}
+
+// currentStop returns the current stop.
+func (s *server) currentStop() (*interpreter.Stop, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.stop == nil {
+		return nil, errors.New("the program is not stopped")
+	}
+	return s.stop, nil
+}
+
+// frameSource returns the source and line for the given frame.
+func (s *server) frameSource(frame *interpreter.DebugFrame) (*source, int) {
+	if file, line, ok := frame.SourceLocation(); ok {
+		return &source{Name: path.Base(file), Path: file}, line
+	}
+	if line, ok := s.disassembly.Line(frame.Function, frame.IP); ok {
+		name := strings.TrimSuffix(filepath.Base(s.launch.Program), filepath.Ext(s.launch.Program)) + ".wat"
+		return &source{Name: name, SourceReference: disassemblyReference}, line
+	}
+	return nil, 0
+}
+
+func (s *server) stackTrace(args *stackTraceArguments) (*stackTraceResponse, error) {
+	stop, err := s.currentStop()
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := args.StartFrame, len(stop.Frames)
+	if start > end {
+		start = end
+	}
+	if args.Levels > 0 && start+args.Levels < end {
+		end = start + args.Levels
+	}
+
+	frames := make([]stackFrame, 0, end-start)
+	for i := start; i < end; i++ {
+		frame := stop.Frames[i]
+
+		name, ok := frame.FunctionName()
+		if !ok {
+			name = fmt.Sprintf("func %d", frame.Function)
+		}
+		src, line := s.frameSource(frame)
+		frames = append(frames, stackFrame{ID: i, Name: name, Source: src, Line: line, Column: 1})
+	}
+	return &stackTraceResponse{StackFrames: frames, TotalFrames: len(stop.Frames)}, nil
+}
+
+func (s *server) scopes(args *scopesArguments) (*scopesResponse, error) {
+	stop, err := s.currentStop()
+	if err != nil {
+		return nil, err
+	}
+	if args.FrameID < 0 || args.FrameID >= len(stop.Frames) {
+		return nil, fmt.Errorf("unknown frame %v", args.FrameID)
+	}
+
+	reference := func(scope int) int {
+		return args.FrameID*scopeCount + scope + 1
+	}
+	return &scopesResponse{Scopes: []scope{
+		{Name: "Locals", VariablesReference: reference(scopeLocals)},
+		{Name: "Operand Stack", VariablesReference: reference(scopeStack)},
+		{Name: "Globals", VariablesReference: reference(scopeGlobals)},
+	}}, nil
+}
+
+func (s *server) variables(args *variablesArguments) (*variablesResponse, error) {
+	stop, err := s.currentStop()
+	if err != nil {
+		return nil, err
+	}
+
+	index, scope := (args.VariablesReference-1)/scopeCount, (args.VariablesReference-1)%scopeCount
+	if args.VariablesReference <= 0 || index >= len(stop.Frames) {
+		return nil, fmt.Errorf("unknown variables reference %v", args.VariablesReference)
+	}
+	frame := stop.Frames[index]
+
+	variables := []variable{}
+	switch scope {
+	case scopeLocals:
+		types := frame.LocalTypes()
+		for i, v := range frame.Locals {
+			variables = append(variables, variable{Name: fmt.Sprintf("$%d", i), Value: formatValue(types[i], v), Type: types[i].String()})
+		}
+	case scopeStack:
+		for i := len(frame.Stack) - 1; i >= 0; i-- {
+			variables = append(variables, variable{Name: fmt.Sprintf("[%d]", i), Value: fmt.Sprintf("0x%x", frame.Stack[i])})
+		}
+	case scopeGlobals:
+		for i := 0; i < frame.Globals(); i++ {
+			global, _ := frame.Global(uint32(i))
+			typ := global.Type().Type
+			variables = append(variables, variable{Name: fmt.Sprintf("$%d", i), Value: formatValue(typ, global.Get()), Type: typ.String()})
+		}
+	}
+	return &variablesResponse{Variables: variables}, nil
+}
+
+// formatValue formats a raw value of the given type.
+func formatValue(t wasm.ValueType, v uint64) string {
+	switch t {
+	case wasm.ValueTypeI32:
+		return fmt.Sprintf("%d (0x%08x)", int32(v), uint32(v))
+	case wasm.ValueTypeI64:
+		return fmt.Sprintf("%d (0x%016x)", int64(v), v)
+	case wasm.ValueTypeF32:
+		return fmt.Sprintf("%g", math.Float32frombits(uint32(v)))
+	case wasm.ValueTypeF64:
+		return fmt.Sprintf("%g", math.Float64frombits(v))
+	default:
+		return fmt.Sprintf("0x%x", v)
+	}
+}
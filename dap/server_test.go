@@ -0,0 +1,353 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// A testClient is a scripted DAP client.
+type testClient struct {
+	t *testing.T
+
+	w   io.WriteCloser
+	r   *bufio.Reader
+	seq int
+
+	events []*testMessage // Events that have been received but not yet expected.
+	output strings.Builder
+	done   chan error
+}
+
+func startServer(t *testing.T) *testClient {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+
+	c := &testClient{t: t, w: requestWriter, r: bufio.NewReader(responseReader), done: make(chan error)}
+	go func() {
+		err := Serve(requestReader, responseWriter, nil)
+		responseWriter.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *testClient) read() *testMessage {
+	content, err := readMessage(c.r)
+	require.NoError(c.t, err)
+
+	var message testMessage
+	require.NoError(c.t, json.Unmarshal(content, &message))
+	if message.Type == "event" && message.Event == "output" {
+		var body outputEvent
+		require.NoError(c.t, json.Unmarshal(message.Body, &body))
+		c.output.WriteString(body.Output)
+	}
+	return &message
+}
+
+// request sends a request and waits for its response. If body is non-nil, the response's body is decoded into it.
+func (c *testClient) request(command string, args interface{}, body interface{}) *testMessage {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	require.NoError(c.t, writeMessage(c.w, req))
+
+	for {
+		message := c.read()
+		switch message.Type {
+		case "event":
+			c.events = append(c.events, message)
+		case "response":
+			require.Equal(c.t, c.seq, message.RequestSeq)
+			require.Equal(c.t, command, message.Command)
+			if body != nil && message.Success {
+				require.NoError(c.t, json.Unmarshal(message.Body, body))
+			}
+			return message
+		}
+	}
+}
+
+// succeed sends a request and requires that it succeeds.
+func (c *testClient) succeed(command string, args interface{}, body interface{}) {
+	message := c.request(command, args, body)
+	require.True(c.t, message.Success, "%v: %v", command, message.Message)
+}
+
+// expect waits for the named event. If body is non-nil, the event's body is decoded into it.
+func (c *testClient) expect(name string, body interface{}) {
+	for {
+		var message *testMessage
+		if len(c.events) != 0 {
+			message, c.events = c.events[0], c.events[1:]
+		} else {
+			message = c.read()
+			require.Equal(c.t, "event", message.Type)
+		}
+
+		if message.Event == name {
+			if body != nil {
+				require.NoError(c.t, json.Unmarshal(message.Body, body))
+			}
+			return
+		}
+	}
+}
+
+// expectStop waits for the program to stop and returns the stack.
+func (c *testClient) expectStop(reason string) []stackFrame {
+	var stopped stoppedEvent
+	c.expect("stopped", &stopped)
+	require.Equal(c.t, reason, stopped.Reason)
+
+	var trace stackTraceResponse
+	c.succeed("stackTrace", map[string]interface{}{"threadId": threadID}, &trace)
+	return trace.StackFrames
+}
+
+func (c *testClient) variables(frame, scope int) []variable {
+	var scopes scopesResponse
+	c.succeed("scopes", map[string]interface{}{"frameId": frame}, &scopes)
+
+	var variables variablesResponse
+	c.succeed("variables", map[string]interface{}{"variablesReference": scopes.Scopes[scope].VariablesReference}, &variables)
+	return variables.Variables
+}
+
+// disconnect ends the session and waits for the server to exit.
+func (c *testClient) disconnect() {
+	c.succeed("disconnect", nil, nil)
+	c.w.Close()
+	require.NoError(c.t, <-c.done)
+}
+
+func (c *testClient) launch(args map[string]interface{}) {
+	var caps capabilities
+	c.succeed("initialize", map[string]interface{}{"adapterID": "warp"}, &caps)
+	assert.True(c.t, caps.SupportsConfigurationDoneRequest)
+
+	c.succeed("launch", args, nil)
+	c.expect("initialized", nil)
+}
+
+func TestDisassembly(t *testing.T) {
+	c := startServer(t)
+	c.launch(map[string]interface{}{"program": "../wasi/testdata/hello_world.wast"})
+
+	var breakpoints breakpointsResponse
+	c.succeed("setFunctionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"name": "_start"}, {"name": "missing"}},
+	}, &breakpoints)
+	require.Len(t, breakpoints.Breakpoints, 2)
+	assert.True(t, breakpoints.Breakpoints[0].Verified)
+	assert.False(t, breakpoints.Breakpoints[1].Verified)
+
+	c.succeed("configurationDone", nil, nil)
+
+	frames := c.expectStop("breakpoint")
+	require.Len(t, frames, 1)
+	assert.Equal(t, "_start", frames[0].Name)
+	require.NotNil(t, frames[0].Source)
+	assert.Equal(t, "hello_world.wat", frames[0].Source.Name)
+	assert.Equal(t, disassemblyReference, frames[0].Source.SourceReference)
+
+	var src sourceResponse
+	c.succeed("source", map[string]interface{}{"sourceReference": disassemblyReference}, &src)
+	lines := strings.Split(src.Content, "\n")
+	assert.Equal(t, "i32.const 0", strings.TrimSpace(lines[frames[0].Line-1]))
+
+	callLine := 0
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "call ") {
+			callLine = i + 1
+		}
+	}
+	require.NotEqual(t, 0, callLine)
+
+	c.succeed("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": disassemblyReference},
+		"breakpoints": []map[string]interface{}{{"line": callLine}, {"line": 1}},
+	}, &breakpoints)
+	require.Len(t, breakpoints.Breakpoints, 2)
+	assert.True(t, breakpoints.Breakpoints[0].Verified)
+	assert.Equal(t, callLine, breakpoints.Breakpoints[0].Line)
+	assert.False(t, breakpoints.Breakpoints[1].Verified)
+
+	c.succeed("continue", map[string]interface{}{"threadId": threadID}, nil)
+	frames = c.expectStop("breakpoint")
+	assert.Equal(t, callLine, frames[0].Line)
+
+	// The call's arguments are on the operand stack, top first.
+	stack := c.variables(0, scopeStack)
+	require.Len(t, stack, 4)
+	assert.Equal(t, "0x14", stack[0].Value)
+	assert.Equal(t, "0x1", stack[1].Value)
+	assert.Equal(t, "0x0", stack[2].Value)
+	assert.Equal(t, "0x1", stack[3].Value)
+
+	c.succeed("next", map[string]interface{}{"threadId": threadID}, nil)
+	frames = c.expectStop("step")
+	assert.Equal(t, "drop)", strings.TrimSpace(lines[frames[0].Line-1]))
+
+	c.succeed("continue", map[string]interface{}{"threadId": threadID}, nil)
+
+	var exited exitedEvent
+	c.expect("exited", &exited)
+	assert.Equal(t, 0, exited.ExitCode)
+	c.expect("terminated", nil)
+	assert.Equal(t, "hello world\n", c.output.String())
+
+	c.disconnect()
+}
+
+func TestSourceLines(t *testing.T) {
+	c := startServer(t)
+	c.launch(map[string]interface{}{"program": "../wasi/testdata/hello.wasm"})
+
+	var breakpoints breakpointsResponse
+	c.succeed("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "std/src/io/stdio.rs"},
+		"breakpoints": []map[string]interface{}{{"line": 951}},
+	}, &breakpoints)
+	require.Len(t, breakpoints.Breakpoints, 1)
+	assert.True(t, breakpoints.Breakpoints[0].Verified)
+
+	c.succeed("configurationDone", nil, nil)
+
+	frames := c.expectStop("breakpoint")
+	require.True(t, len(frames) > 1)
+	require.NotNil(t, frames[0].Source)
+	assert.True(t, strings.HasSuffix(frames[0].Source.Path, "/std/src/io/stdio.rs"))
+	assert.Equal(t, 951, frames[0].Line)
+	assert.Equal(t, "_ZN5hello4main17h391fdbb81e062d23E", frames[1].Name)
+
+	locals := c.variables(0, scopeLocals)
+	require.Len(t, locals, 7)
+	assert.Equal(t, "$4", locals[4].Name)
+	assert.Equal(t, "i64", locals[4].Type)
+
+	// A line-granularity step leaves the breakpoint's line.
+	c.succeed("next", map[string]interface{}{"threadId": threadID}, nil)
+	frames = c.expectStop("step")
+	assert.NotEqual(t, 951, frames[0].Line)
+
+	c.succeed("continue", map[string]interface{}{"threadId": threadID}, nil)
+
+	var exited exitedEvent
+	c.expect("exited", &exited)
+	assert.Equal(t, 0, exited.ExitCode)
+	assert.Equal(t, "Hello, world!\n", c.output.String())
+
+	c.disconnect()
+}
+
+func TestStopOnEntry(t *testing.T) {
+	c := startServer(t)
+	c.launch(map[string]interface{}{"program": "../wasi/testdata/hello_world.wast", "stopOnEntry": true})
+	c.succeed("configurationDone", nil, nil)
+
+	frames := c.expectStop("entry")
+	require.Len(t, frames, 1)
+
+	message := c.request("continue", map[string]interface{}{"threadId": 2}, nil)
+	require.True(t, message.Success)
+	c.expect("exited", nil)
+
+	message = c.request("stackTrace", map[string]interface{}{"threadId": threadID}, nil)
+	assert.False(t, message.Success)
+
+	message = c.request("evaluate", map[string]interface{}{"expression": "1"}, nil)
+	assert.False(t, message.Success)
+
+	c.disconnect()
+}
+
+func TestDisconnectWhileStopped(t *testing.T) {
+	c := startServer(t)
+	c.launch(map[string]interface{}{"program": "../wasi/testdata/hello_world.wast", "stopOnEntry": true})
+	c.succeed("configurationDone", nil, nil)
+	c.expectStop("entry")
+
+	c.disconnect()
+	c.expect("terminated", nil)
+	assert.Equal(t, "", c.output.String())
+}
+
+func TestBreakpointsWhileRunning(t *testing.T) {
+	c := startServer(t)
+	c.launch(map[string]interface{}{"program": "testdata/spin.wast"})
+	c.succeed("configurationDone", nil, nil)
+
+	functionBreakpoints := func(names ...string) []breakpoint {
+		requested := []map[string]interface{}{}
+		for _, name := range names {
+			requested = append(requested, map[string]interface{}{"name": name})
+		}
+
+		var breakpoints breakpointsResponse
+		c.succeed("setFunctionBreakpoints", map[string]interface{}{"breakpoints": requested}, &breakpoints)
+		return breakpoints.Breakpoints
+	}
+
+	// Change the breakpoints repeatedly while the program calls tick. The breakpoint in _start never stops the
+	// program, as _start is already running.
+	for i := 0; i < 100; i++ {
+		functionBreakpoints("_start")
+		functionBreakpoints()
+	}
+
+	breakpoints := functionBreakpoints("tick")
+	require.Len(t, breakpoints, 1)
+	assert.True(t, breakpoints[0].Verified)
+
+	frames := c.expectStop("breakpoint")
+	require.Len(t, frames, 2)
+	assert.Equal(t, "tick", frames[0].Name)
+
+	functionBreakpoints()
+	c.succeed("continue", map[string]interface{}{"threadId": threadID}, nil)
+
+	var src sourceResponse
+	c.succeed("source", map[string]interface{}{"sourceReference": disassemblyReference}, &src)
+	setLine := 0
+	for i, line := range strings.Split(src.Content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "global.set") {
+			setLine = i + 1
+		}
+	}
+	require.NotEqual(t, 0, setLine)
+
+	var lineBreakpoints breakpointsResponse
+	c.succeed("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": disassemblyReference},
+		"breakpoints": []map[string]interface{}{{"line": setLine}},
+	}, &lineBreakpoints)
+	require.Len(t, lineBreakpoints.Breakpoints, 1)
+	assert.True(t, lineBreakpoints.Breakpoints[0].Verified)
+
+	frames = c.expectStop("breakpoint")
+	assert.Equal(t, "tick", frames[0].Name)
+	assert.Equal(t, setLine, frames[0].Line)
+
+	c.disconnect()
+	c.expect("terminated", nil)
+}
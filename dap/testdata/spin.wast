@@ -0,0 +1,18 @@
+(module
+    (memory 1)
+    (export "memory" (memory 0))
+
+    (global $ticks (mut i32) (i32.const 0))
+
+    (func $tick (export "tick")
+        (global.set $ticks (i32.add (global.get $ticks) (i32.const 1)))
+    )
+
+    ;; Call $tick forever. The program only exits when the debugger disconnects.
+    (func $main (export "_start")
+        (loop $forever
+            (call $tick)
+            (br $forever)
+        )
+    )
+)
@@ -0,0 +1,56 @@
+package exec
+
+import (
+	"errors"
+
+	"github.com/pgavlin/warp/wasm"
+)
+
+// ErrWouldSuspend is raised when an asynchronous function that does not complete immediately is called by a caller
+// that is unable to suspend.
+var ErrWouldSuspend = errors.New("asynchronous call would suspend")
+
+// An AsyncFunction is a function that may complete asynchronously.
+//
+// Callers that are able to suspend execution call StartCall. Callers that are not able to suspend call
+// UncheckedCall, which raises ErrWouldSuspend if the call does not complete immediately.
+type AsyncFunction interface {
+	Function
+
+	// StartCall begins a call to the function with the given arguments. If the call completes immediately, StartCall
+	// writes the call's results to returns and returns true. Otherwise, StartCall returns false, and the caller must
+	// suspend until the call's results are supplied by some other means.
+	StartCall(thread *Thread, args, returns []uint64) bool
+}
+
+// An AsyncHostFunction is an AsyncFunction that is implemented by a Go function. Host modules may export
+// asynchronous functions using exported fields of type *AsyncHostFunction.
+type AsyncHostFunction struct {
+	sig   wasm.FunctionSig
+	start func(thread *Thread, args, returns []uint64) bool
+}
+
+// NewAsyncHostFunction creates a new asynchronous host function with the given signature. Calls to the function are
+// started by calling start, which must follow the contract of AsyncFunction.StartCall. The args and returns slices
+// passed to start are only valid for the duration of the call to start.
+func NewAsyncHostFunction(sig wasm.FunctionSig, start func(thread *Thread, args, returns []uint64) bool) *AsyncHostFunction {
+	return &AsyncHostFunction{sig: sig, start: start}
+}
+
+func (f *AsyncHostFunction) GetSignature() wasm.FunctionSig {
+	return f.sig
+}
+
+func (f *AsyncHostFunction) Call(thread *Thread, args ...interface{}) []interface{} {
+	return callUnchecked(f, thread, args)
+}
+
+func (f *AsyncHostFunction) UncheckedCall(thread *Thread, args, returns []uint64) {
+	if !f.start(thread, args, returns) {
+		panic(ErrWouldSuspend)
+	}
+}
+
+func (f *AsyncHostFunction) StartCall(thread *Thread, args, returns []uint64) bool {
+	return f.start(thread, args, returns)
+}
@@ -0,0 +1,87 @@
+package exec
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Coverage records the instructions and branches executed by the functions of one or more modules. Engines record
+// coverage for the functions that execute on a thread whose coverage has been set using Thread.SetCoverage.
+//
+// Instructions are identified by their offset within their function's decoded body. The counts for a function may
+// only be updated by one thread at a time.
+type Coverage struct {
+	m       sync.Mutex
+	modules map[string]map[uint32]*FunctionCoverage
+}
+
+// FunctionCoverage records the execution counts for a single function.
+type FunctionCoverage struct {
+	// Instructions holds the number of times each of the function's instructions has executed.
+	Instructions []uint64
+	// Branches holds the number of times each outcome of each of the function's conditional branches has occurred,
+	// indexed by the offset of the branch instruction. The outcomes of an if or br_if instruction are 0 if the
+	// condition was true and 1 if it was false. The outcomes of a br_table instruction are the index of the chosen
+	// label, where the default label is last.
+	Branches map[int][]uint64
+}
+
+// NewCoverage creates a new, empty coverage record.
+func NewCoverage() *Coverage {
+	return &Coverage{modules: map[string]map[uint32]*FunctionCoverage{}}
+}
+
+// Function returns the coverage for the given function, creating it if necessary.
+func (c *Coverage) Function(moduleName string, functionIndex uint32, instructions int) *FunctionCoverage {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	functions, ok := c.modules[moduleName]
+	if !ok {
+		functions = map[uint32]*FunctionCoverage{}
+		c.modules[moduleName] = functions
+	}
+
+	fc, ok := functions[functionIndex]
+	if !ok {
+		fc = &FunctionCoverage{Instructions: make([]uint64, instructions), Branches: map[int][]uint64{}}
+		functions[functionIndex] = fc
+	}
+	return fc
+}
+
+// Modules returns the names of the modules that have coverage records.
+func (c *Coverage) Modules() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	names := make([]string, 0, len(c.modules))
+	for name := range c.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Module returns the coverage for the functions in the named module that have executed, indexed by function index.
+func (c *Coverage) Module(moduleName string) map[uint32]*FunctionCoverage {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	functions := make(map[uint32]*FunctionCoverage, len(c.modules[moduleName]))
+	for index, fc := range c.modules[moduleName] {
+		functions[index] = fc
+	}
+	return functions
+}
+
+// Branch records an outcome of the conditional branch at the given offset. The branch has the given number of
+// possible outcomes.
+func (fc *FunctionCoverage) Branch(ip, outcome, outcomes int) {
+	counts, ok := fc.Branches[ip]
+	if !ok {
+		counts = make([]uint64, outcomes)
+		fc.Branches[ip] = counts
+	}
+	counts[outcome]++
+}
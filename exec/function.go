@@ -1,6 +1,9 @@
 package exec
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/pgavlin/warp/wasm"
 )
 
@@ -16,6 +19,52 @@ type Function interface {
 	UncheckedCall(thread *Thread, args, returns []uint64)
 }
 
+// callUnchecked calls the given function using its UncheckedCall method. Arguments and results are converted between
+// Go values and their raw representations according to the function's signature.
+func callUnchecked(f Function, thread *Thread, args []interface{}) []interface{} {
+	sig := f.GetSignature()
+
+	rawArgs := make([]uint64, len(args))
+	for i, v := range args {
+		switch v := v.(type) {
+		case int32:
+			rawArgs[i] = uint64(uint32(v))
+		case uint32:
+			rawArgs[i] = uint64(v)
+		case int64:
+			rawArgs[i] = uint64(v)
+		case uint64:
+			rawArgs[i] = v
+		case float32:
+			rawArgs[i] = uint64(math.Float32bits(v))
+		case float64:
+			rawArgs[i] = math.Float64bits(v)
+		default:
+			panic(fmt.Errorf("cannot assign %T argument to a parameter of type %v", v, sig.ParamTypes[i]))
+		}
+	}
+
+	rawReturns := make([]uint64, len(sig.ReturnTypes))
+	f.UncheckedCall(thread, rawArgs, rawReturns)
+
+	returns := make([]interface{}, len(sig.ReturnTypes))
+	for i, t := range sig.ReturnTypes {
+		switch t {
+		case wasm.ValueTypeI32:
+			returns[i] = int32(rawReturns[i])
+		case wasm.ValueTypeI64:
+			returns[i] = int64(rawReturns[i])
+		case wasm.ValueTypeF32:
+			returns[i] = math.Float32frombits(uint32(rawReturns[i]))
+		case wasm.ValueTypeF64:
+			returns[i] = math.Float64frombits(rawReturns[i])
+		default:
+			panic("unreachable")
+		}
+	}
+	return returns
+}
+
 // UninitializedFunction represents an uninitialized table entry. Calling this function will
 // trap.
 var UninitializedFunction Function = uninitializedFunction(0)
@@ -170,6 +170,7 @@ func NewHostModule(name string, v interface{}) Module {
 var tableType = reflect.TypeOf((*Table)(nil)).Elem()
 var memoryType = reflect.TypeOf((*Memory)(nil)).Elem()
 var globalType = reflect.TypeOf((*Global)(nil)).Elem()
+var asyncHostFunctionType = reflect.TypeOf((*AsyncHostFunction)(nil))
 
 func isExported(n string) bool {
 	r, _ := utf8.DecodeRuneInString(n)
@@ -209,6 +210,11 @@ func newHostModule(name string, v reflect.Value) *hostModule {
 			fv = value.Field(i).Addr().Interface().(*Memory)
 		case globalType:
 			fv = value.Field(i).Addr().Interface().(*Global)
+		case asyncHostFunctionType:
+			if value.Field(i).IsNil() {
+				continue
+			}
+			fv = value.Field(i).Interface().(*AsyncHostFunction)
 		default:
 			continue
 		}
@@ -0,0 +1,184 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/pgavlin/warp/wasm"
+)
+
+// A CallKind describes the direction of an intercepted call.
+type CallKind int
+
+const (
+	// CallImport is a call from a module to one of its imported functions.
+	CallImport CallKind = iota
+	// CallExport is a call from the host to a function exported by a module.
+	CallExport
+)
+
+// A Call describes a single call that crosses a module boundary. Calls are shared between invocations of the same
+// function and must not be modified by interceptors.
+type Call struct {
+	Kind      CallKind         // The direction of the call.
+	Module    string           // The name of the module that defines the callee.
+	Name      string           // The name of the callee within its module.
+	Signature wasm.FunctionSig // The callee's signature.
+	Function  Function         // The callee.
+}
+
+// A CallInterceptor observes and optionally modifies calls that cross a module boundary.
+type CallInterceptor interface {
+	// Before is called before the callee is invoked. The interceptor may modify the arguments in place. If Before
+	// returns an error, the callee is not invoked, and the error is passed to the After methods of any interceptors
+	// whose Before methods have already been called.
+	Before(thread *Thread, call *Call, args []uint64) error
+	// After is called once the callee has returned or failed. The interceptor may modify the results in place. If
+	// the callee failed, err describes the failure; panics that do not carry an error are reported as a *PanicError.
+	// The error returned by After replaces err: returning nil from After resumes normal execution in the caller with
+	// the given results, and returning a non-nil error raises that error in the caller.
+	After(thread *Thread, call *Call, results []uint64, err error) error
+}
+
+// A PanicError wraps a value that was passed to panic by an intercepted call but does not implement error.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Intercept adds the given interceptors to the store. The interceptors observe calls to functions imported by
+// modules that are subsequently instantiated by the store and calls to functions exported by modules that are
+// returned by InstantiateModule and InstantiateModuleDefinition.
+//
+// Stores without interceptors do not wrap imported or exported functions, so interception has no cost when unused.
+func (s *Store) Intercept(interceptors ...CallInterceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+func (s *Store) intercept(kind CallKind, module, name string, f Function) Function {
+	if len(s.interceptors) == 0 {
+		return f
+	}
+	return &interceptedFunction{
+		call: Call{
+			Kind:      kind,
+			Module:    module,
+			Name:      name,
+			Signature: f.GetSignature(),
+			Function:  f,
+		},
+		interceptors: s.interceptors,
+	}
+}
+
+func (s *Store) interceptModule(m Module) Module {
+	if len(s.interceptors) == 0 {
+		return m
+	}
+	return &interceptedModule{Module: m, s: s}
+}
+
+type interceptedModule struct {
+	Module
+
+	s *Store
+}
+
+func (m *interceptedModule) GetFunction(name string) (Function, error) {
+	f, err := m.Module.GetFunction(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.s.intercept(CallExport, m.Name(), name, f), nil
+}
+
+type interceptedFunction struct {
+	call         Call
+	interceptors []CallInterceptor
+}
+
+func (f *interceptedFunction) GetSignature() wasm.FunctionSig {
+	return f.call.Signature
+}
+
+func (f *interceptedFunction) Call(thread *Thread, args ...interface{}) []interface{} {
+	return callUnchecked(f, thread, args)
+}
+
+func (f *interceptedFunction) UncheckedCall(thread *Thread, args, returns []uint64) {
+	i := Interception{f: f}
+	err := i.Before(thread, args)
+	if err == nil {
+		err = invoke(f.call.Function, thread, args, returns)
+	}
+	if err = i.After(thread, returns, err); err != nil {
+		if p, ok := err.(*PanicError); ok {
+			panic(p.Value)
+		}
+		panic(err)
+	}
+}
+
+// invoke calls the given function and recovers any failure as an error.
+func invoke(f Function, thread *Thread, args, returns []uint64) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(error)
+			if !ok {
+				e = &PanicError{Value: x}
+			}
+			err = e
+		}
+	}()
+
+	f.UncheckedCall(thread, args, returns)
+	return nil
+}
+
+// An Interception runs a store's interceptors around a single call that is not made using UncheckedCall. Callers
+// that are able to suspend use an Interception to observe calls that complete after StartCall returns.
+type Interception struct {
+	f *interceptedFunction
+	n int // The number of interceptors whose Before methods have succeeded.
+
+	// The thread's call stack at the start of the call, which is restored if the call fails so that an interceptor
+	// may choose to resume execution.
+	active *Frame
+	depth  uint
+}
+
+// Unintercept returns the function wrapped by f and an Interception for a single call to it if f was returned by a
+// store with interceptors. Otherwise, Unintercept returns f and nil.
+func Unintercept(f Function) (Function, *Interception) {
+	if f, ok := f.(*interceptedFunction); ok {
+		return f.call.Function, &Interception{f: f}
+	}
+	return f, nil
+}
+
+// Before calls the interceptors' Before methods with the call's arguments. If Before returns an error, the callee
+// must not be called, and the error must be passed to After.
+func (i *Interception) Before(thread *Thread, args []uint64) error {
+	i.active, i.depth = thread.active, thread.depth
+
+	for ; i.n < len(i.f.interceptors); i.n++ {
+		if err := i.f.interceptors[i.n].Before(thread, &i.f.call, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// After calls the After methods of the interceptors whose Before methods succeeded, innermost first, and returns the
+// error that the call raises in its caller, if any. If the call failed, err describes the failure.
+func (i *Interception) After(thread *Thread, returns []uint64, err error) error {
+	if err != nil {
+		thread.active, thread.depth = i.active, i.depth
+	}
+	for n := i.n - 1; n >= 0; n-- {
+		err = i.f.interceptors[n].After(thread, &i.f.call, returns, err)
+	}
+	return err
+}
@@ -8,14 +8,16 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"unsafe"
 )
 
 var ErrLimitExceeded = fmt.Errorf("memory limit exceeded")
 
 // Memory is a WASM linear memory.
 type Memory struct {
-	min, max uint32
-	bytes    []byte
+	min, max    uint32
+	bytes       []byte
+	watchpoints []*Watchpoint
 }
 
 // NewMemory creates a new linear memory with the given limits.
@@ -203,3 +205,71 @@ func (m *Memory) Float64At(offset uint32) float64 {
 func (m *Memory) PutFloat64At(v float64, offset uint32) {
 	m.PutUint64At(math.Float64bits(v), offset)
 }
+
+// Check panics with TrapOutOfBoundsMemoryAccess unless every effective address in the range [base, base+end) is in
+// bounds. Memory never shrinks, so accesses that lie within a range that has been checked may use the unchecked
+// accessors.
+func (m *Memory) Check(base uint32, end uint64) {
+	if uint64(base)+end > uint64(len(m.bytes)) {
+		panic(TrapOutOfBoundsMemoryAccess)
+	}
+}
+
+// InBounds returns true if every effective address below end is in bounds. Memory never shrinks, so accesses below an
+// end for which InBounds has returned true may use the unchecked accessors.
+func (m *Memory) InBounds(end uint64) bool {
+	return end <= uint64(len(m.bytes))
+}
+
+// pointer returns a pointer to the byte at the given effective address. The address is not bounds-checked.
+func (m *Memory) pointer(base, offset uint32) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(*(*unsafe.Pointer)(unsafe.Pointer(&m.bytes))) + uintptr(base) + uintptr(offset))
+}
+
+// UncheckedUint8 returns the byte stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint8(base, offset uint32) byte {
+	return *(*byte)(m.pointer(base, offset))
+}
+
+// UncheckedPutUint8 writes the given byte to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint8(v byte, base, offset uint32) {
+	*(*byte)(m.pointer(base, offset)) = v
+}
+
+// UncheckedUint16 returns the uint16 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint16(base, offset uint32) uint16 {
+	return binary.LittleEndian.Uint16((*[2]byte)(m.pointer(base, offset))[:])
+}
+
+// UncheckedPutUint16 writes the given uint16 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint16(v uint16, base, offset uint32) {
+	binary.LittleEndian.PutUint16((*[2]byte)(m.pointer(base, offset))[:], v)
+}
+
+// UncheckedUint32 returns the uint32 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint32(base, offset uint32) uint32 {
+	return binary.LittleEndian.Uint32((*[4]byte)(m.pointer(base, offset))[:])
+}
+
+// UncheckedPutUint32 writes the given uint32 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint32(v uint32, base, offset uint32) {
+	binary.LittleEndian.PutUint32((*[4]byte)(m.pointer(base, offset))[:], v)
+}
+
+// UncheckedUint64 returns the uint64 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint64(base, offset uint32) uint64 {
+	return binary.LittleEndian.Uint64((*[8]byte)(m.pointer(base, offset))[:])
+}
+
+// UncheckedPutUint64 writes the given uint64 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint64(v uint64, base, offset uint32) {
+	binary.LittleEndian.PutUint64((*[8]byte)(m.pointer(base, offset))[:], v)
+}
@@ -15,9 +15,10 @@ var ErrLimitExceeded = fmt.Errorf("memory limit exceeded")
 
 // Memory is a WASM linear memory.
 type Memory struct {
-	min, max uint32
-	start    uintptr
-	size     uintptr
+	min, max    uint32
+	start       uintptr
+	size        uintptr
+	watchpoints []*Watchpoint
 }
 
 //go:linkname mmap runtime.mmap
@@ -268,3 +269,68 @@ func (m *Memory) PutFloat64At(v float64, offset uint32) {
 	p := (*float64)(unsafe.Pointer(m.start + uintptr(offset)))
 	*p = v
 }
+
+// Check panics with TrapOutOfBoundsMemoryAccess unless every effective address in the range [base, base+end) is in
+// bounds. Memory never shrinks, so accesses that lie within a range that has been checked may use the unchecked
+// accessors.
+func (m *Memory) Check(base uint32, end uint64) {
+	if uint64(base)+end > uint64(m.size) {
+		panic(TrapOutOfBoundsMemoryAccess)
+	}
+}
+
+// InBounds returns true if every effective address below end is in bounds. Memory never shrinks, so accesses below an
+// end for which InBounds has returned true may use the unchecked accessors.
+func (m *Memory) InBounds(end uint64) bool {
+	return end <= uint64(m.size)
+}
+
+// UncheckedUint8 returns the byte stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+//
+// Accesses are already unchecked: out-of-bounds accesses fault on the memory's guard pages.
+func (m *Memory) UncheckedUint8(base, offset uint32) byte {
+	return m.Uint8(base, offset)
+}
+
+// UncheckedPutUint8 writes the given byte to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint8(v byte, base, offset uint32) {
+	m.PutUint8(v, base, offset)
+}
+
+// UncheckedUint16 returns the uint16 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint16(base, offset uint32) uint16 {
+	return m.Uint16(base, offset)
+}
+
+// UncheckedPutUint16 writes the given uint16 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint16(v uint16, base, offset uint32) {
+	m.PutUint16(v, base, offset)
+}
+
+// UncheckedUint32 returns the uint32 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint32(base, offset uint32) uint32 {
+	return m.Uint32(base, offset)
+}
+
+// UncheckedPutUint32 writes the given uint32 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint32(v uint32, base, offset uint32) {
+	m.PutUint32(v, base, offset)
+}
+
+// UncheckedUint64 returns the uint64 stored at the given effective address. The address must lie within a range that
+// has been checked using Check.
+func (m *Memory) UncheckedUint64(base, offset uint32) uint64 {
+	return m.Uint64(base, offset)
+}
+
+// UncheckedPutUint64 writes the given uint64 to the given effective address. The address must lie within a range
+// that has been checked using Check.
+func (m *Memory) UncheckedPutUint64(v uint64, base, offset uint32) {
+	m.PutUint64(v, base, offset)
+}
@@ -28,9 +28,10 @@ var ErrGlobalType = errors.New("global type mismatch")
 
 // A Store is responsible for instantiating modules.
 type Store struct {
-	resolver ModuleResolver
-	handlers []ModuleEventHandler
-	modules  map[string]Module
+	resolver     ModuleResolver
+	handlers     []ModuleEventHandler
+	interceptors []CallInterceptor
+	modules      map[string]Module
 }
 
 // NewStore creates a new store that will use the given resolver to resolve modules.
@@ -71,7 +72,7 @@ func (s *Store) instantiateModule(a AllocatedModule, resolver *resolver) (Module
 // InstantiateModule instantiates the given module. The name is resolved to a module definition using the store's ModuleResolver.
 func (s *Store) InstantiateModule(name string) (Module, error) {
 	if m, ok := s.modules[name]; ok {
-		return m, nil
+		return s.interceptModule(m), nil
 	}
 
 	definition, err := s.resolver.ResolveModule(name)
@@ -98,7 +99,7 @@ func (s *Store) InstantiateModuleDefinition(name string, def ModuleDefinition) (
 		return nil, err
 	}
 	s.modules[name] = m
-	return m, nil
+	return s.interceptModule(m), nil
 }
 
 type resolver struct {
@@ -157,7 +158,7 @@ func (r *resolver) ResolveFunction(moduleName, functionName string, type_ wasm.F
 			FieldName:  functionName,
 		}
 	}
-	return f, nil
+	return r.s.intercept(CallImport, moduleName, functionName, f), nil
 }
 
 func (r *resolver) ResolveMemory(moduleName, memoryName string, type_ wasm.Memory) (*Memory, error) {
@@ -23,6 +23,7 @@ type Thread struct {
 	debug    bool
 	depth    uint
 	maxDepth uint
+	coverage *Coverage
 }
 
 // NewThread creates a new thread with the given max depth, if any.
@@ -62,6 +63,17 @@ func (t *Thread) Debug() bool {
 	return t.debug
 }
 
+// SetCoverage sets the coverage record for functions that execute on this thread. Engines that support coverage
+// record the instructions and branches executed by each function. Coverage slows execution considerably.
+func (t *Thread) SetCoverage(c *Coverage) {
+	t.coverage = c
+}
+
+// Coverage returns this thread's coverage record, if any.
+func (t *Thread) Coverage() (*Coverage, bool) {
+	return t.coverage, t.coverage != nil
+}
+
 // MaxDepth returns the maximum call stack depth, if any.
 func (t *Thread) MaxDepth() uint {
 	return t.maxDepth
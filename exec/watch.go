@@ -0,0 +1,111 @@
+package exec
+
+// A WatchKind describes the kinds of memory accesses observed by a watchpoint.
+type WatchKind int
+
+const (
+	// WatchRead observes loads from a watched range.
+	WatchRead WatchKind = 1 << iota
+	// WatchWrite observes stores to a watched range.
+	WatchWrite
+	// WatchChange observes stores to a watched range that change the contents of memory.
+	WatchChange
+
+	// WatchReadWrite observes loads from and stores to a watched range.
+	WatchReadWrite = WatchRead | WatchWrite
+)
+
+// A MemoryAccess describes a single access to a watched range of memory.
+type MemoryAccess struct {
+	Kind          WatchKind // The kind of access. Either WatchRead or WatchWrite.
+	FunctionIndex uint32    // The index of the accessing function.
+	IP            int       // The offset of the accessing instruction within the function's decoded body.
+	Address       uint32    // The effective address of the access.
+	Size          int       // The width of the access in bytes.
+	OldValue      uint64    // The contents of the accessed bytes prior to the access.
+	NewValue      uint64    // The contents of the accessed bytes after the access. Equal to OldValue for reads.
+}
+
+// A WatchFunc is called when a watched range of memory is accessed. The function is called after the access
+// completes.
+type WatchFunc func(thread *Thread, access *MemoryAccess)
+
+// A Watchpoint observes accesses to a range of memory.
+type Watchpoint struct {
+	Address uint32    // The address of the first watched byte.
+	Size    uint32    // The number of watched bytes.
+	Kind    WatchKind // The kinds of accesses to observe.
+	Func    WatchFunc // The function to call when a matching access occurs.
+}
+
+func (w *Watchpoint) overlaps(address uint32, size int) bool {
+	start, end := uint64(address), uint64(address)+uint64(size)
+	return start < uint64(w.Address)+uint64(w.Size) && uint64(w.Address) < end
+}
+
+func (w *Watchpoint) matches(access *MemoryAccess) bool {
+	switch {
+	case !w.overlaps(access.Address, access.Size):
+		return false
+	case access.Kind == WatchRead:
+		return w.Kind&WatchRead != 0
+	case w.Kind&WatchWrite != 0:
+		return true
+	default:
+		return w.Kind&WatchChange != 0 && access.OldValue != access.NewValue
+	}
+}
+
+// Watch registers a watchpoint that observes accesses of the given kind to the size bytes starting at the given
+// address. The returned watchpoint can be passed to Unwatch to remove it.
+//
+// Watchpoints are observed by the interpreter. Function invocations that are already in progress when a watchpoint
+// is registered may not observe the new watchpoint.
+func (m *Memory) Watch(address, size uint32, kind WatchKind, f WatchFunc) *Watchpoint {
+	w := &Watchpoint{Address: address, Size: size, Kind: kind, Func: f}
+	m.watchpoints = append(m.watchpoints, w)
+	return w
+}
+
+// Unwatch removes the given watchpoint from the memory.
+func (m *Memory) Unwatch(w *Watchpoint) {
+	for i, x := range m.watchpoints {
+		if x == w {
+			m.watchpoints = append(m.watchpoints[:i:i], m.watchpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// Watchpoints returns the memory's watchpoints.
+func (m *Memory) Watchpoints() []*Watchpoint {
+	return m.watchpoints
+}
+
+// Watched returns true if the memory has any watchpoints. It is safe to call Watched on a nil memory.
+func (m *Memory) Watched() bool {
+	return m != nil && len(m.watchpoints) != 0
+}
+
+// Watching returns true if any of the memory's watchpoints observe accesses of the given kind to the size bytes
+// starting at the given address.
+func (m *Memory) Watching(kind WatchKind, address uint32, size int) bool {
+	if kind == WatchWrite {
+		kind |= WatchChange
+	}
+	for _, w := range m.watchpoints {
+		if w.Kind&kind != 0 && w.overlaps(address, size) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyAccess calls the functions for each of the memory's watchpoints that match the given access.
+func (m *Memory) NotifyAccess(thread *Thread, access *MemoryAccess) {
+	for _, w := range m.watchpoints {
+		if w.matches(access) {
+			w.Func(thread, access)
+		}
+	}
+}
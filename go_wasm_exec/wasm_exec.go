@@ -31,6 +31,7 @@ type Options struct {
 
 	Debug    bool
 	Trace    io.Writer
+	Coverage *exec.Coverage
 	Resolver exec.ModuleResolver
 }
 
@@ -96,6 +97,7 @@ func Run(name string, def exec.ModuleDefinition, options *Options) error {
 	}
 
 	t := exec.NewThread(0)
+	t.SetCoverage(options.Coverage)
 	wasm.thread = &t
 	code := wasm.main()
 	if code != 0 {
@@ -24,7 +24,7 @@ func loadModule(path string) (exec.ModuleDefinition, error) {
 		return nil, err
 	}
 
-	return interpreter.NewModuleDefinition(module), nil
+	return interpreter.NewModuleDefinition(module, nil), nil
 }
 
 func TestHello(t *testing.T) {
@@ -0,0 +1,44 @@
+// Package buildinfo describes the build of warp that is executing.
+package buildinfo
+
+import "runtime/debug"
+
+// Version returns a string that identifies the version of warp that is executing. Development builds are
+// identified by their VCS revision, if available.
+func Version() string {
+	const path = "github.com/pgavlin/warp"
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	mod := &info.Main
+	if mod.Path != path {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				mod = dep
+				break
+			}
+		}
+	}
+	if mod.Replace != nil {
+		mod = mod.Replace
+	}
+	if mod.Version != "" && mod.Version != "(devel)" {
+		return mod.Version
+	}
+
+	version := "(devel)"
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			version += " " + s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				version += " modified"
+			}
+		}
+	}
+	return version
+}
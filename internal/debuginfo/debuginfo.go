@@ -0,0 +1,333 @@
+// Package debuginfo maps between a module's functions, instructions, and source code.
+package debuginfo
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// Info holds the information that debuggers and compilers need in order to map between a module's functions,
+// instructions, and source code.
+//
+// Addresses in a module's DWARF are offsets from the start of the module's code section. Instructions are mapped to
+// addresses by re-decoding the bytecode for their function.
+type Info struct {
+	mod *wasm.Module
+
+	importedFunctions uint32   // The number of functions imported by the module.
+	functionNames     []string // The names of the module's functions, by function index.
+
+	m       sync.Mutex
+	offsets map[uint32][]uint64 // The addresses of each function's instructions, by function index.
+
+	linesOnce sync.Once
+	lines     []lineEntry // The module's line table, sorted by address.
+	linesErr  error
+}
+
+// A Location identifies an instruction within a function.
+type Location struct {
+	Function uint32 // The index of the function.
+	IP       int    // The index of the instruction within the function.
+}
+
+// A lineEntry maps an address to a source location.
+type lineEntry struct {
+	address uint64
+	file    string
+	line    int
+	stmt    bool // True if the address is a recommended breakpoint location for the line.
+	end     bool // True if the entry marks the end of a sequence of addresses.
+}
+
+// New returns the debugging information for the given module.
+func New(mod *wasm.Module) *Info {
+	info := &Info{mod: mod, offsets: map[uint32][]uint64{}}
+
+	if mod.Import != nil {
+		for _, entry := range mod.Import.Entries {
+			if _, ok := entry.Type.(wasm.FuncImport); ok {
+				info.importedFunctions++
+			}
+		}
+	}
+
+	functionCount := info.importedFunctions
+	if mod.Function != nil {
+		functionCount += uint32(len(mod.Function.Types))
+	}
+	info.functionNames = make([]string, int(functionCount))
+
+	if mod.Export != nil {
+		for _, export := range mod.Export.Entries {
+			if export.Kind == wasm.ExternalFunction && export.Index < functionCount {
+				info.functionNames[int(export.Index)] = export.FieldStr
+			}
+		}
+	}
+	if names, err := mod.Names(); err == nil {
+		for _, subsection := range names.Entries {
+			if functions, ok := subsection.(*wasm.FunctionNamesSubsection); ok {
+				for _, naming := range functions.Names {
+					if naming.Index < functionCount {
+						info.functionNames[int(naming.Index)] = naming.Name
+					}
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// ImportedFunctions returns the number of functions imported by the module.
+func (info *Info) ImportedFunctions() uint32 {
+	return info.importedFunctions
+}
+
+// FunctionCount returns the number of functions in the module's function index space.
+func (info *Info) FunctionCount() uint32 {
+	return uint32(len(info.functionNames))
+}
+
+// FunctionName returns the name of the function with the given index, if it has one.
+func (info *Info) FunctionName(index uint32) (string, bool) {
+	if index >= uint32(len(info.functionNames)) || info.functionNames[int(index)] == "" {
+		return "", false
+	}
+	return info.functionNames[int(index)], true
+}
+
+// LookupFunction returns the index of the function with the given name.
+func (info *Info) LookupFunction(name string) (uint32, bool) {
+	for i, n := range info.functionNames {
+		if n == name {
+			return uint32(i), true
+		}
+	}
+	return 0, false
+}
+
+// Body returns the body of the defined function with the given index.
+func (info *Info) Body(index uint32) (*wasm.FunctionBody, bool) {
+	if index < info.importedFunctions || info.mod.Code == nil {
+		return nil, false
+	}
+	index -= info.importedFunctions
+	if index >= uint32(len(info.mod.Code.Bodies)) {
+		return nil, false
+	}
+	return &info.mod.Code.Bodies[int(index)], true
+}
+
+// InstructionAddresses returns the addresses of the instructions in the function with the given index.
+func (info *Info) InstructionAddresses(index uint32) ([]uint64, bool) {
+	info.m.Lock()
+	defer info.m.Unlock()
+
+	if offsets, ok := info.offsets[index]; ok {
+		return offsets, offsets != nil
+	}
+
+	offsets, ok := info.decodeAddresses(index)
+	info.offsets[index] = offsets
+	return offsets, ok
+}
+
+func (info *Info) decodeAddresses(index uint32) ([]uint64, bool) {
+	_, offsets, ok := info.DecodeInstructions(index)
+	return offsets, ok
+}
+
+// DecodeInstructions decodes the instructions in the function with the given index and returns the instructions and
+// their addresses.
+func (info *Info) DecodeInstructions(index uint32) ([]code.Instruction, []uint64, bool) {
+	body, ok := info.Body(index)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// Function bodies record the offset of their size. Re-encode the body's header in order to find the offset of its
+	// first instruction.
+	var header bytes.Buffer
+	if err := body.MarshalWASM(&header); err != nil {
+		return nil, nil, false
+	}
+	start := uint64(body.Offset) + uint64(header.Len()-len(body.Code))
+
+	var instructions []code.Instruction
+	var offsets []uint64
+	r := bytes.NewReader(body.Code)
+	for r.Len() != 0 {
+		offsets = append(offsets, start+uint64(len(body.Code)-r.Len()))
+
+		var instr code.Instruction
+		if err := instr.Decode(r); err != nil {
+			return nil, nil, false
+		}
+		instructions = append(instructions, instr)
+	}
+	return instructions, offsets, true
+}
+
+// Function returns the index of the function that contains the given address and the index of the first instruction
+// at or after the address.
+func (info *Info) Function(address uint64) (uint32, int, bool) {
+	if info.mod.Code == nil {
+		return 0, 0, false
+	}
+
+	bodies := info.mod.Code.Bodies
+	i := sort.Search(len(bodies), func(i int) bool {
+		return uint64(bodies[i].Offset) > address
+	}) - 1
+	if i < 0 {
+		return 0, 0, false
+	}
+
+	// Addresses that precede the function's first instruction refer to the function's local declarations, which are
+	// part of its prologue.
+	index := info.importedFunctions + uint32(i)
+	offsets, ok := info.InstructionAddresses(index)
+	if !ok {
+		return 0, 0, false
+	}
+	ip := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= address })
+	if ip == len(offsets) {
+		return 0, 0, false
+	}
+	return index, ip, true
+}
+
+// loadLines reads the module's DWARF line tables.
+func (info *Info) loadLines() ([]lineEntry, error) {
+	info.linesOnce.Do(func() {
+		data, err := info.mod.DWARF()
+		if err != nil {
+			info.linesErr = err
+			return
+		}
+
+		var lines []lineEntry
+		entries := data.Reader()
+		for {
+			unit, err := entries.Next()
+			if err != nil {
+				info.linesErr = err
+				return
+			}
+			if unit == nil {
+				break
+			}
+			if unit.Tag != dwarf.TagCompileUnit {
+				entries.SkipChildren()
+				continue
+			}
+
+			lr, err := data.LineReader(unit)
+			if err != nil {
+				info.linesErr = err
+				return
+			}
+			if lr != nil {
+				var sequence []lineEntry
+				var row dwarf.LineEntry
+				for {
+					if err := lr.Next(&row); err != nil {
+						if err != io.EOF {
+							info.linesErr = err
+							return
+						}
+						break
+					}
+
+					entry := lineEntry{address: row.Address, line: row.Line, stmt: row.IsStmt, end: row.EndSequence}
+					if row.File != nil {
+						entry.file = row.File.Name
+					}
+					sequence = append(sequence, entry)
+
+					if row.EndSequence {
+						// Linkers relocate the addresses of code that was discarded to a tombstone value that lies
+						// outside of the code section. Skip sequences that do not begin inside a function.
+						if _, _, ok := info.Function(sequence[0].address); ok {
+							lines = append(lines, sequence...)
+						}
+						sequence = sequence[:0]
+					}
+				}
+			}
+			entries.SkipChildren()
+		}
+
+		// Sort the entries by address. Sequences may begin at the address at which another sequence ends, so sort entries
+		// that end a sequence before those that begin one.
+		sort.SliceStable(lines, func(i, j int) bool {
+			if lines[i].address != lines[j].address {
+				return lines[i].address < lines[j].address
+			}
+			return lines[i].end && !lines[j].end
+		})
+		info.lines = lines
+	})
+	return info.lines, info.linesErr
+}
+
+// SourceLocation returns the source location of the given instruction.
+func (info *Info) SourceLocation(function uint32, ip int) (string, int, bool) {
+	offsets, ok := info.InstructionAddresses(function)
+	if !ok || ip < 0 || ip >= len(offsets) {
+		return "", 0, false
+	}
+	lines, err := info.loadLines()
+	if err != nil {
+		return "", 0, false
+	}
+
+	address := offsets[ip]
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].address > address }) - 1
+	if i < 0 || lines[i].end || lines[i].line == 0 {
+		return "", 0, false
+	}
+	return lines[i].file, lines[i].line, true
+}
+
+// matchFile returns true if the given name refers to the given file. A name matches a file if it is equal to the
+// file's path or to a suffix of the path that begins after a path separator.
+func matchFile(file, name string) bool {
+	file, name = path.Clean(file), path.Clean(name)
+	return file == name || strings.HasSuffix(file, "/"+name)
+}
+
+// LineLocations returns the function index and instruction index of each breakpoint location for the given source
+// line. Each function that contains code for the line has at most one location: the lowest address that is marked
+// as a statement.
+func (info *Info) LineLocations(file string, line int) ([]Location, error) {
+	lines, err := info.loadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []Location
+	seen := map[uint32]bool{}
+	for _, entry := range lines {
+		if entry.end || !entry.stmt || entry.line != line || !matchFile(entry.file, file) {
+			continue
+		}
+		function, ip, ok := info.Function(entry.address)
+		if !ok || seen[function] {
+			continue
+		}
+		seen[function] = true
+		locations = append(locations, Location{Function: function, IP: ip})
+	}
+	return locations, nil
+}
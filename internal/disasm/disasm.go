@@ -0,0 +1,115 @@
+// Package disasm disassembles modules into the WebAssembly text format and maps between the disassembly's lines and
+// the instructions of the module's functions.
+package disasm
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
+)
+
+// A Disassembly is the text format of a module. Each instruction in a function body occupies its own line.
+type Disassembly struct {
+	Text string
+
+	functions []disassembledFunction // The module's defined functions, in index order.
+}
+
+// A disassembledFunction records the lines that hold a function's text.
+type disassembledFunction struct {
+	index  uint32 // The function's index.
+	header int    // The line of the function's header.
+	body   int    // The line of the function's first instruction.
+	lines  int    // The number of instruction lines. The function's final end instruction does not have a line.
+}
+
+// Disassemble writes the given module in the text format and records the lines of each function's instructions.
+//
+// wast.WriteTo writes each function's header on a single line, followed by an optional line of local declarations
+// and then one line per instruction. Instructions are indented more deeply than headers.
+func Disassemble(mod *wasm.Module) (*Disassembly, error) {
+	var buf bytes.Buffer
+	if err := wast.WriteTo(&buf, mod); err != nil {
+		return nil, err
+	}
+	text := buf.String()
+
+	index := uint32(0)
+	if mod.Import != nil {
+		for _, entry := range mod.Import.Entries {
+			if _, ok := entry.Type.(wasm.FuncImport); ok {
+				index++
+			}
+		}
+	}
+
+	var functions []disassembledFunction
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "  (func") {
+			continue
+		}
+
+		fn := disassembledFunction{index: index, header: i + 1, body: i + 2}
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "    (local") {
+			fn.body, i = fn.body+1, i+1
+		}
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], "    ") {
+			fn.lines, i = fn.lines+1, i+1
+		}
+
+		functions, index = append(functions, fn), index+1
+	}
+
+	return &Disassembly{Text: text, functions: functions}, nil
+}
+
+// function returns the disassembly of the function with the given index.
+func (d *Disassembly) function(index uint32) (*disassembledFunction, bool) {
+	i := sort.Search(len(d.functions), func(i int) bool { return d.functions[i].index >= index })
+	if i == len(d.functions) || d.functions[i].index != index {
+		return nil, false
+	}
+	return &d.functions[i], true
+}
+
+// Line returns the line that holds the given instruction. If the instruction's index is not known, line returns the
+// line of the function's header.
+func (d *Disassembly) Line(function uint32, ip int) (int, bool) {
+	fn, ok := d.function(function)
+	if !ok {
+		return 0, false
+	}
+
+	switch {
+	case ip < 0 || fn.lines == 0:
+		return fn.header, true
+	case ip >= fn.lines:
+		// The final end instruction shares the line of the instruction that precedes it.
+		return fn.body + fn.lines - 1, true
+	default:
+		return fn.body + ip, true
+	}
+}
+
+// Location returns the function index and instruction index for the given line. A function's header and local
+// declarations refer to its first instruction.
+func (d *Disassembly) Location(line int) (uint32, int, bool) {
+	i := sort.Search(len(d.functions), func(i int) bool { return d.functions[i].header > line }) - 1
+	if i < 0 {
+		return 0, 0, false
+	}
+
+	fn := &d.functions[i]
+	switch {
+	case line < fn.body:
+		return fn.index, 0, true
+	case line < fn.body+fn.lines:
+		return fn.index, line - fn.body, true
+	default:
+		return 0, 0, false
+	}
+}
@@ -0,0 +1,299 @@
+package pprof
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var errTruncated = errors.New("truncated profile")
+
+// Read reads a profile in the protocol buffer format. The encoding may be gzip-compressed. Fields that are not
+// represented by Profile are ignored.
+func Read(r io.Reader) (*Profile, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// A rawProfile is a decoded profile whose strings have not yet been resolved. String fields are recorded as indices
+// into the string table, which is not complete until the entire profile has been decoded.
+type rawProfile struct {
+	sampleTypes       [][2]int64
+	defaultSampleType int64
+	functions         []rawFunction
+	strings           []string
+}
+
+type rawFunction struct {
+	Function
+	name, systemName, filename int64
+}
+
+func decode(data []byte) (*Profile, error) {
+	var p Profile
+	var raw rawProfile
+	err := decodeMessage(data, func(field int, d *decoder) error {
+		switch field {
+		case profileSampleType:
+			var t [2]int64
+			err := d.message(func(field int, d *decoder) error {
+				switch field {
+				case valueTypeType:
+					return d.int(&t[0])
+				case valueTypeUnit:
+					return d.int(&t[1])
+				}
+				return d.skip()
+			})
+			raw.sampleTypes = append(raw.sampleTypes, t)
+			return err
+		case profileSample:
+			var s Sample
+			err := d.message(func(field int, d *decoder) error {
+				switch field {
+				case sampleLocationID:
+					return d.uints(&s.Location)
+				case sampleValue:
+					return d.ints(&s.Value)
+				}
+				return d.skip()
+			})
+			p.Sample = append(p.Sample, s)
+			return err
+		case profileLocation:
+			var l Location
+			err := d.message(func(field int, d *decoder) error {
+				switch field {
+				case locationID:
+					return d.uint(&l.ID)
+				case locationLine:
+					var line Line
+					err := d.message(func(field int, d *decoder) error {
+						switch field {
+						case lineFunctionID:
+							return d.uint(&line.Function)
+						case lineLine:
+							return d.int(&line.Line)
+						}
+						return d.skip()
+					})
+					l.Line = append(l.Line, line)
+					return err
+				}
+				return d.skip()
+			})
+			p.Location = append(p.Location, l)
+			return err
+		case profileFunction:
+			var f rawFunction
+			err := d.message(func(field int, d *decoder) error {
+				switch field {
+				case functionID:
+					return d.uint(&f.ID)
+				case functionName:
+					return d.int(&f.name)
+				case functionSystemName:
+					return d.int(&f.systemName)
+				case functionFilename:
+					return d.int(&f.filename)
+				case functionStartLine:
+					return d.int(&f.StartLine)
+				}
+				return d.skip()
+			})
+			raw.functions = append(raw.functions, f)
+			return err
+		case profileStringTable:
+			b, err := d.bytes()
+			raw.strings = append(raw.strings, string(b))
+			return err
+		case profileTimeNanos:
+			return d.int(&p.TimeNanos)
+		case profileDurationNanos:
+			return d.int(&p.DurationNanos)
+		case profileDefaultSampleType:
+			return d.int(&raw.defaultSampleType)
+		}
+		return d.skip()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) (string, error) {
+		if i < 0 || i >= int64(len(raw.strings)) {
+			return "", fmt.Errorf("invalid string index %v", i)
+		}
+		return raw.strings[int(i)], nil
+	}
+	for _, t := range raw.sampleTypes {
+		typ, err := str(t[0])
+		if err != nil {
+			return nil, err
+		}
+		unit, err := str(t[1])
+		if err != nil {
+			return nil, err
+		}
+		p.SampleType = append(p.SampleType, ValueType{Type: typ, Unit: unit})
+	}
+	for _, f := range raw.functions {
+		if f.Name, err = str(f.name); err != nil {
+			return nil, err
+		}
+		if f.SystemName, err = str(f.systemName); err != nil {
+			return nil, err
+		}
+		if f.Filename, err = str(f.filename); err != nil {
+			return nil, err
+		}
+		p.Function = append(p.Function, f.Function)
+	}
+	if p.DefaultSampleType, err = str(raw.defaultSampleType); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// A decoder decodes the value of a single field of a protocol buffer message.
+type decoder struct {
+	wireType int
+	data     []byte
+}
+
+// decodeMessage calls fn for each field in the given message. fn must consume the field's value using one of the
+// decoder's methods.
+func decodeMessage(data []byte, fn func(field int, d *decoder) error) error {
+	r := bytes.NewReader(data)
+	for r.Len() != 0 {
+		tag, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errTruncated
+		}
+
+		d := &decoder{wireType: int(tag & 7)}
+		switch d.wireType {
+		case wireVarint:
+			start := len(data) - r.Len()
+			if _, err := binary.ReadUvarint(r); err != nil {
+				return errTruncated
+			}
+			d.data = data[start : len(data)-r.Len()]
+		case wireFixed64, wireFixed32:
+			n := 8
+			if d.wireType == wireFixed32 {
+				n = 4
+			}
+			if r.Len() < n {
+				return errTruncated
+			}
+			start := len(data) - r.Len()
+			d.data = data[start : start+n]
+			r.Seek(int64(n), io.SeekCurrent)
+		case wireBytes:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return errTruncated
+			}
+			start := len(data) - r.Len()
+			d.data = data[start : start+int(n)]
+			r.Seek(int64(n), io.SeekCurrent)
+		default:
+			return fmt.Errorf("unsupported wire type %v", d.wireType)
+		}
+
+		if err := fn(int(tag>>3), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) skip() error {
+	return nil
+}
+
+func (d *decoder) uint(v *uint64) error {
+	if d.wireType != wireVarint {
+		return fmt.Errorf("unexpected wire type %v", d.wireType)
+	}
+	*v, _ = binary.Uvarint(d.data)
+	return nil
+}
+
+func (d *decoder) int(v *int64) error {
+	var u uint64
+	err := d.uint(&u)
+	*v = int64(u)
+	return err
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	if d.wireType != wireBytes {
+		return nil, fmt.Errorf("unexpected wire type %v", d.wireType)
+	}
+	return d.data, nil
+}
+
+// uints decodes a repeated unsigned field, which may be packed.
+func (d *decoder) uints(v *[]uint64) error {
+	if d.wireType == wireVarint {
+		var x uint64
+		err := d.uint(&x)
+		*v = append(*v, x)
+		return err
+	}
+
+	data, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	for len(data) != 0 {
+		x, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		*v, data = append(*v, x), data[n:]
+	}
+	return nil
+}
+
+// ints decodes a repeated signed field, which may be packed.
+func (d *decoder) ints(v *[]int64) error {
+	var u []uint64
+	if err := d.uints(&u); err != nil {
+		return err
+	}
+	for _, x := range u {
+		*v = append(*v, int64(x))
+	}
+	return nil
+}
+
+func (d *decoder) message(fn func(field int, d *decoder) error) error {
+	data, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return decodeMessage(data, fn)
+}
@@ -0,0 +1,215 @@
+// Package pprof encodes profiles in the protocol buffer format read by pprof. The format is described by
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+package pprof
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+)
+
+// A ValueType describes the type and unit of a sample value.
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// A Sample records values for a call stack.
+type Sample struct {
+	Location []uint64 // The IDs of the sample's locations, innermost first.
+	Value    []int64  // The sample's values, one per sample type.
+}
+
+// A Line identifies a source line within a function.
+type Line struct {
+	Function uint64 // The ID of the function.
+	Line     int64  // The line number, or 0 if it is unknown.
+}
+
+// A Location is a position in a program.
+type Location struct {
+	ID   uint64
+	Line []Line
+}
+
+// A Function describes a function in a program.
+type Function struct {
+	ID         uint64
+	Name       string
+	SystemName string
+	Filename   string
+	StartLine  int64
+}
+
+// A Profile is a set of samples.
+type Profile struct {
+	SampleType        []ValueType
+	DefaultSampleType string
+	Sample            []Sample
+	Location          []Location
+	Function          []Function
+	TimeNanos         int64
+	DurationNanos     int64
+}
+
+// Write writes the gzip-compressed encoding of the profile to w.
+func (p *Profile) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(p.encode()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Field numbers from profile.proto.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// An encoder encodes a profile. Strings are interned in the profile's string table as they are encoded.
+type encoder struct {
+	strings []string
+	indices map[string]int64
+}
+
+func (e *encoder) string(s string) int64 {
+	if i, ok := e.indices[s]; ok {
+		return i
+	}
+	i := int64(len(e.strings))
+	e.strings, e.indices[s] = append(e.strings, s), i
+	return i
+}
+
+func (p *Profile) encode() []byte {
+	e := &encoder{strings: []string{""}, indices: map[string]int64{"": 0}}
+
+	var b buffer
+	for _, t := range p.SampleType {
+		b.message(profileSampleType, func(b *buffer) {
+			b.int(valueTypeType, e.string(t.Type))
+			b.int(valueTypeUnit, e.string(t.Unit))
+		})
+	}
+	for _, s := range p.Sample {
+		b.message(profileSample, func(b *buffer) {
+			b.packedUints(sampleLocationID, s.Location)
+			b.packedInts(sampleValue, s.Value)
+		})
+	}
+	for _, l := range p.Location {
+		b.message(profileLocation, func(b *buffer) {
+			b.uint(locationID, l.ID)
+			for _, line := range l.Line {
+				b.message(locationLine, func(b *buffer) {
+					b.uint(lineFunctionID, line.Function)
+					b.int(lineLine, line.Line)
+				})
+			}
+		})
+	}
+	for _, f := range p.Function {
+		b.message(profileFunction, func(b *buffer) {
+			b.uint(functionID, f.ID)
+			b.int(functionName, e.string(f.Name))
+			b.int(functionSystemName, e.string(f.SystemName))
+			b.int(functionFilename, e.string(f.Filename))
+			b.int(functionStartLine, f.StartLine)
+		})
+	}
+	b.int(profileTimeNanos, p.TimeNanos)
+	b.int(profileDurationNanos, p.DurationNanos)
+	b.int(profileDefaultSampleType, e.string(p.DefaultSampleType))
+
+	// The string table must be encoded last, as encoding the rest of the profile fills it in.
+	for _, s := range e.strings {
+		b.bytes(profileStringTable, []byte(s))
+	}
+	return b
+}
+
+// A buffer accumulates an encoded protocol buffer message.
+type buffer []byte
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func (b *buffer) varint(v uint64) {
+	*b = append(*b, make([]byte, binary.MaxVarintLen64)...)
+	n := binary.PutUvarint((*b)[len(*b)-binary.MaxVarintLen64:], v)
+	*b = (*b)[:len(*b)-binary.MaxVarintLen64+n]
+}
+
+func (b *buffer) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint encodes a non-zero unsigned field. Zero-valued fields are omitted.
+func (b *buffer) uint(field int, v uint64) {
+	if v != 0 {
+		b.tag(field, wireVarint)
+		b.varint(v)
+	}
+}
+
+// int encodes a non-zero signed field. Zero-valued fields are omitted.
+func (b *buffer) int(field int, v int64) {
+	b.uint(field, uint64(v))
+}
+
+func (b *buffer) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) packedUints(field int, v []uint64) {
+	var packed buffer
+	for _, x := range v {
+		packed.varint(x)
+	}
+	b.bytes(field, packed)
+}
+
+func (b *buffer) packedInts(field int, v []int64) {
+	var packed buffer
+	for _, x := range v {
+		packed.varint(uint64(x))
+	}
+	b.bytes(field, packed)
+}
+
+func (b *buffer) message(field int, encode func(b *buffer)) {
+	var m buffer
+	encode(&m)
+	b.bytes(field, m)
+}
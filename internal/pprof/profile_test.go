@@ -0,0 +1,53 @@
+package pprof
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	expected := &Profile{
+		SampleType: []ValueType{
+			{Type: "calls", Unit: "count"},
+			{Type: "time", Unit: "nanoseconds"},
+		},
+		DefaultSampleType: "time",
+		Sample: []Sample{
+			{Location: []uint64{1}, Value: []int64{1, 1000}},
+			{Location: []uint64{2, 1}, Value: []int64{300, 20}},
+		},
+		Location: []Location{
+			{ID: 1, Line: []Line{{Function: 1, Line: 10}}},
+			{ID: 2, Line: []Line{{Function: 2}}},
+		},
+		Function: []Function{
+			{ID: 1, Name: "test.main", SystemName: "test.main", Filename: "main.c", StartLine: 10},
+			{ID: 2, Name: "test.func 2", SystemName: "test.func 2"},
+		},
+		TimeNanos:     1234,
+		DurationNanos: 5678,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, expected.Write(&buf))
+
+	actual, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// Uncompressed profiles are also accepted.
+	actual, err = Read(bytes.NewReader(expected.encode()))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestReadTruncated(t *testing.T) {
+	p := &Profile{Function: []Function{{ID: 1, Name: "f"}}}
+	data := p.encode()
+
+	_, err := Read(bytes.NewReader(data[:len(data)-1]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,343 @@
+package interpreter
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// An AsyncCall is a suspendable call to an interpreted function.
+//
+// Unlike synchronous calls, which use the Go stack to record the interpreter's activation records, suspendable calls
+// keep all of their state in the machine's explicit stack. This allows a call that is waiting on an asynchronous host
+// function to release its goroutine: the call's state is saved in the AsyncCall and execution continues on whichever
+// goroutine calls Resume.
+type AsyncCall struct {
+	m       machine
+	returns []uint64
+
+	interception *exec.Interception // The interception of the call to the exported function, if any.
+
+	calls       []activation       // The interpreted activations, innermost last.
+	pending     *function          // The descriptor for the frame of the pending host call, if any.
+	pendingHost *exec.Interception // The interception of the pending host call, if any.
+
+	fn *function // The function that contains the pending call.
+	ip int       // The instruction that follows the pending call.
+}
+
+// An activation records the function executing in an interpreted frame and the address in the caller's body at which
+// execution resumes once the function returns.
+type activation struct {
+	fn  *function
+	ret int
+}
+
+// StartAsync begins a suspendable call to the given function, which must be a function exported by an interpreted
+// module. The call executes on the calling goroutine until the function returns or the call is suspended. A call is
+// suspended when it calls an exec.AsyncFunction that does not complete immediately; the suspended call is continued
+// by passing the asynchronous function's results to Resume. Once the function returns, its results are written to
+// returns.
+//
+// Suspendable calls always execute the icode for each interpreted function, so they do not benefit from fcode. Host
+// functions called by a suspendable call execute synchronously unless they implement exec.AsyncFunction. Calls to
+// functions that are observed by a store's interceptors, including asynchronous host functions, are intercepted as
+// usual; the interceptors' After methods run once the intercepted call completes.
+//
+// If the call fails, StartAsync returns the trap or other failure as an error.
+func StartAsync(thread *exec.Thread, fn exec.Function, args, returns []uint64) (*AsyncCall, error) {
+	callee, interception := exec.Unintercept(fn)
+	f, ok := callee.(*function)
+	if !ok {
+		return nil, errors.New("suspendable calls require an interpreted function")
+	}
+
+	c := &AsyncCall{returns: returns, interception: interception}
+	c.m.init(thread)
+	c.m.async = true
+
+	maxStack := len(args)
+	if len(returns) > maxStack {
+		maxStack = len(returns)
+	}
+
+	caller := function{
+		metrics: code.Metrics{MaxStackDepth: maxStack, MaxNesting: 1},
+		kind:    functionKindVirtual,
+	}
+
+	frame := c.m.push(&caller)
+	frame.pushn(args)
+
+	var err error
+	if interception != nil {
+		err = interception.Before(thread, args)
+	}
+	if err == nil {
+		err = catch(func() { c.run(f, 0, true) })
+	}
+	if err = c.finish(err); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Done returns true if the call has completed.
+func (c *AsyncCall) Done() bool {
+	return c.pending == nil
+}
+
+// Resume continues a suspended call. The results must hold the results of the pending asynchronous function call.
+// Resume returns when the function returns or the call is suspended again. If the call fails, Resume returns the
+// trap or other failure as an error.
+func (c *AsyncCall) Resume(results []uint64) error {
+	if c.pending == nil {
+		return errors.New("call is not suspended")
+	}
+
+	err := catch(func() {
+		host := c.m.frames[len(c.m.frames)-1]
+		copy(host.stack, results)
+		if interception := c.pendingHost; interception != nil {
+			c.pendingHost = nil
+			if err := interception.After(c.m.thread, host.stack, nil); err != nil {
+				panic(err)
+			}
+		}
+		c.finishHost()
+
+		c.run(c.fn, c.ip, false)
+	})
+	return c.finish(err)
+}
+
+// finish completes the call if it has returned or failed. If the call is observed by interceptors, their After
+// methods determine the error returned to the caller. finish does nothing if the call is suspended.
+func (c *AsyncCall) finish(err error) error {
+	if err == nil && c.pending != nil {
+		return nil
+	}
+
+	c.pending, c.pendingHost = nil, nil
+	if c.interception != nil {
+		err = c.interception.After(c.m.thread, c.returns, err)
+	}
+	return err
+}
+
+// catch calls f and returns any trap or other failure that it raises as an error.
+func catch(f func()) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			switch x := x.(type) {
+			case runtime.Error:
+				if trap, ok := exec.TranslateRuntimeError(x); ok {
+					err = trap
+				} else {
+					err = x
+				}
+			case error:
+				err = x
+			default:
+				err = &exec.PanicError{Value: x}
+			}
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// enter pushes a frame for the given function. Execution of the caller resumes at ret when the function returns.
+func (c *AsyncCall) enter(fn *function, ret int) {
+	f := c.m.push(fn)
+
+	if c.m.thread.Debug() {
+		c.m.thread.EnterFrame(&exec.Frame{
+			ModuleName:        f.module.name,
+			FunctionIndex:     fn.index,
+			FunctionSignature: fn.signature,
+			Locals:            f.locals,
+		})
+	} else {
+		c.m.thread.Enter()
+	}
+
+	// Push the first label.
+	f.blocks = f.blocks[:2]
+	f.blocks[0] = uint64(len(fn.icode) - 1)
+	f.blocks[1] = uint64(len(fn.signature.ReturnTypes))
+
+	c.calls = append(c.calls, activation{fn: fn, ret: ret})
+}
+
+// leave pops the frame for the innermost function and returns the activation that executed in the frame.
+func (c *AsyncCall) leave() activation {
+	a := c.calls[len(c.calls)-1]
+	c.calls = c.calls[:len(c.calls)-1]
+
+	if c.m.thread.Debug() {
+		c.m.thread.LeaveFrame()
+	} else {
+		c.m.thread.Leave()
+	}
+
+	c.m.pop(a.fn)
+	c.drop(len(a.fn.signature.ParamTypes), len(a.fn.signature.ReturnTypes))
+	return a
+}
+
+// pushHost pushes a frame for a call to the given host function. The frame's locals hold the call's arguments and its
+// stack holds space for the call's results.
+func (c *AsyncCall) pushHost(fn exec.Function) (*function, *frame) {
+	sig := fn.GetSignature()
+	desc := &function{
+		signature: sig,
+		metrics: code.Metrics{
+			MaxStackDepth: len(sig.ReturnTypes),
+			MaxNesting:    1,
+		},
+		kind:      functionKindVirtual,
+		numLocals: len(sig.ParamTypes),
+	}
+
+	host := c.m.push(desc)
+	host.stack = host.stack[:len(sig.ReturnTypes)]
+	return desc, host
+}
+
+// callHost calls a host function. If the function is asynchronous and the call does not complete immediately,
+// callHost leaves the call's frame on the stack and returns false.
+func (c *AsyncCall) callHost(fn exec.Function) bool {
+	callee, interception := exec.Unintercept(fn)
+	async, ok := callee.(exec.AsyncFunction)
+	if !ok {
+		// Synchronous functions are called through their interceptors, if any.
+		desc, host := c.pushHost(fn)
+		fn.UncheckedCall(c.m.thread, host.locals, host.stack)
+
+		c.pending = desc
+		c.finishHost()
+		return true
+	}
+
+	desc, host := c.pushHost(async)
+	c.pending = desc
+	if interception == nil {
+		if !async.StartCall(c.m.thread, host.locals, host.stack) {
+			return false
+		}
+		c.finishHost()
+		return true
+	}
+
+	err := interception.Before(c.m.thread, host.locals)
+	if err == nil {
+		done := false
+		err = catch(func() { done = async.StartCall(c.m.thread, host.locals, host.stack) })
+		if err == nil && !done {
+			c.pendingHost = interception
+			return false
+		}
+	}
+	if err = interception.After(c.m.thread, host.stack, err); err != nil {
+		panic(err)
+	}
+	c.finishHost()
+	return true
+}
+
+// finishHost pops the frame for the completed host function call described by c.pending.
+func (c *AsyncCall) finishHost() {
+	desc := c.pending
+	c.pending = nil
+
+	c.m.pop(desc)
+	c.drop(len(desc.signature.ParamTypes), len(desc.signature.ReturnTypes))
+}
+
+// drop updates the innermost frame's stack after a call that consumed nparams operands and produced nresults results.
+func (c *AsyncCall) drop(nparams, nresults int) {
+	f := c.m.frames[len(c.m.frames)-1]
+	f.stack = f.stack[:len(f.stack)-nparams+nresults]
+}
+
+// run executes the call starting at the given instruction until it completes or suspends. If enter is true, run
+// first pushes a frame for fn.
+func (c *AsyncCall) run(fn *function, ip int, enter bool) {
+	if enter {
+		c.enter(fn, 0)
+	}
+
+	for {
+		if ip == len(fn.icode) {
+			a := c.leave()
+			if len(c.calls) == 0 {
+				c.m.frames[0].popn(c.returns)
+				return
+			}
+			fn, ip = c.calls[len(c.calls)-1].fn, a.ret
+			continue
+		}
+
+		f := c.m.frames[len(c.m.frames)-1]
+
+		var callee exec.Function
+		switch instr := &fn.icode[ip]; instr.Opcode {
+		case code.OpCall:
+			funcidx := instr.Funcidx()
+			if funcidx < uint32(len(f.module.importedFunctions)) {
+				callee = f.module.importedFunctions[funcidx]
+			} else {
+				callee = &f.module.functions[funcidx-uint32(len(f.module.importedFunctions))]
+			}
+		case code.OpCallIndirect:
+			callee = f.indirectCallee(instr)
+		default:
+			if f.module.mem0.Watched() {
+				ip = f.stepWatch(fn, ip)
+			} else {
+				ip = f.step(fn.icode, ip)
+			}
+			continue
+		}
+
+		ip++
+		switch callee := callee.(type) {
+		case *function:
+			c.enter(callee, ip)
+			fn, ip = callee, 0
+		default:
+			if !c.callHost(callee) {
+				c.fn, c.ip = fn, ip
+				return
+			}
+		}
+	}
+}
+
+// indirectCallee pops a table index and returns the function it refers to, trapping if the index does not refer to a
+// function with the expected signature.
+func (f *frame) indirectCallee(instr *code.Instruction) exec.Function {
+	table := f.module.table0.Entries()
+
+	tableidx := f.popI32()
+	if uint32(tableidx) >= uint32(len(table)) {
+		f.trap(exec.TrapUndefinedElement)
+	}
+
+	function := table[int(tableidx)]
+	if function == nil {
+		f.trap(exec.TrapUninitializedElement)
+	}
+
+	expectedSig := f.module.types[int(instr.Typeidx())]
+	actualSig := function.GetSignature()
+	if !actualSig.Equals(expectedSig) {
+		f.trap(exec.TrapIndirectCallTypeMismatch)
+	}
+
+	return function
+}
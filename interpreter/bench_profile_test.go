@@ -0,0 +1,51 @@
+//go:build warp_opcodeprofile
+// +build warp_opcodeprofile
+
+package interpreter
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pgavlin/warp/bench/data"
+	"github.com/pgavlin/warp/bench/flate"
+	"github.com/pgavlin/warp/wasi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var opcodeProfilePath = flag.String("opcode-profile", "", "path to which TestFlateOpcodeProfile writes its profile")
+
+// TestFlateOpcodeProfile records an opcode profile of the flate benchmark. If -opcode-profile is set, the profile is
+// written to the given path for use by gen-fcode.
+func TestFlateOpcodeProfile(t *testing.T) {
+	input := data.Enwik8[:1<<16]
+	if *opcodeProfilePath != "" {
+		input = data.Enwik8[:1<<20]
+	}
+
+	profile := NewOpcodeProfile()
+	options := &Options{CodeKind: FCodeOnly, OpcodeProfile: profile}
+
+	var stdout bytes.Buffer
+	err := wasi.Run("flate", NewModuleDefinition(flate.Module, options), &wasi.RunOptions{
+		Options: &wasi.Options{
+			Stdin:  bytes.NewReader(input),
+			Stdout: &stdout,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, input, stdout.Bytes())
+
+	var buf bytes.Buffer
+	require.NoError(t, profile.WriteProfile(&buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "total "))
+	assert.Contains(t, buf.String(), "I32Add")
+
+	if *opcodeProfilePath != "" {
+		require.NoError(t, os.WriteFile(*opcodeProfilePath, buf.Bytes(), 0600))
+	}
+}
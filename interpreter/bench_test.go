@@ -16,7 +16,7 @@ import (
 
 func TestFlate(t *testing.T) {
 	var stdout bytes.Buffer
-	err := wasi.Run("flate", NewModuleDefinition(flate.Module), &wasi.RunOptions{
+	err := wasi.Run("flate", NewModuleDefinition(flate.Module, nil), &wasi.RunOptions{
 		Options: &wasi.Options{
 			Stdin:  bytes.NewReader(data.Enwik8[:1<<20]),
 			Stdout: &stdout,
@@ -26,9 +26,26 @@ func TestFlate(t *testing.T) {
 	assert.Equal(t, data.Enwik8[:1<<20], stdout.Bytes())
 }
 
+func TestFlateCached(t *testing.T) {
+	options := &Options{CacheDir: t.TempDir()}
+
+	// The first run populates the cache and the second run reads from it.
+	for i := 0; i < 2; i++ {
+		var stdout bytes.Buffer
+		err := wasi.Run("flate", NewModuleDefinition(flate.Module, options), &wasi.RunOptions{
+			Options: &wasi.Options{
+				Stdin:  bytes.NewReader(data.Enwik8[:1<<16]),
+				Stdout: &stdout,
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, data.Enwik8[:1<<16], stdout.Bytes())
+	}
+}
+
 func TestFlateGo(t *testing.T) {
 	var stdout bytes.Buffer
-	err := go_wasm_exec.Run("flate", NewModuleDefinition(flate_go.Module), &go_wasm_exec.Options{
+	err := go_wasm_exec.Run("flate", NewModuleDefinition(flate_go.Module, nil), &go_wasm_exec.Options{
 		Stdin:  bytes.NewReader(data.Enwik8[:1<<20]),
 		Stdout: &stdout,
 	})
@@ -38,7 +55,7 @@ func TestFlateGo(t *testing.T) {
 
 func BenchmarkFlate(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		err := wasi.Run("flate", NewModuleDefinition(flate.Module), &wasi.RunOptions{
+		err := wasi.Run("flate", NewModuleDefinition(flate.Module, nil), &wasi.RunOptions{
 			Options: &wasi.Options{
 				Stdin:  bytes.NewReader(data.Enwik8[:1<<16]),
 				Stdout: io.Discard,
@@ -52,7 +69,7 @@ func BenchmarkFlate(b *testing.B) {
 
 func BenchmarkFlateGo(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		err := go_wasm_exec.Run("flate", NewModuleDefinition(flate_go.Module), &go_wasm_exec.Options{
+		err := go_wasm_exec.Run("flate", NewModuleDefinition(flate_go.Module, nil), &go_wasm_exec.Options{
 			Stdin:  bytes.NewReader(data.Enwik8[:1<<16]),
 			Stdout: io.Discard,
 		})
@@ -61,3 +78,34 @@ func BenchmarkFlateGo(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkFlateTiering compares the interpreter's tiering policies. Policies that compile more code to fcode earlier
+// pay a higher startup cost in exchange for faster execution, and vice versa.
+func BenchmarkFlateTiering(b *testing.B) {
+	policies := []struct {
+		name    string
+		options *Options
+	}{
+		{"mixed", nil},
+		{"icode", &Options{CodeKind: ICodeOnly}},
+		{"fcode", &Options{CodeKind: FCodeOnly}},
+		{"threshold=16", &Options{TierUpThreshold: 16}},
+		{"parallel", &Options{Compile: CompileParallel}},
+		{"background", &Options{Compile: CompileBackground}},
+	}
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := wasi.Run("flate", NewModuleDefinition(flate.Module, p.options), &wasi.RunOptions{
+					Options: &wasi.Options{
+						Stdin:  bytes.NewReader(data.Enwik8[:1<<16]),
+						Stdout: io.Discard,
+					},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
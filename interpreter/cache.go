@@ -0,0 +1,398 @@
+package interpreter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pgavlin/warp/internal/buildinfo"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// The fcode cache stores the compiled bodies of a module's functions. Each cache entry is a file named for the
+// content hash of its module. An entry is laid out as follows:
+//
+//     magic          [8]byte   "warpfc\x00\x00"
+//     format         uvarint   cacheFormat
+//     version        string    the version of warp that wrote the entry
+//     fingerprint    string    the fingerprint of the fcode instruction set; see opcodeFingerprint
+//     module hash    string    the content hash of the module
+//     function count uvarint
+//     functions      one record per function
+//     checksum       [32]byte  the SHA-256 of the preceding bytes
+//
+// Strings are encoded as a uvarint length followed by the string's bytes. Each function records its local count,
+// metrics, icode, fcode, labels, and switch tables. Signed values are encoded as varints and unsigned values as
+// uvarints, with the exception of fcode instructions, which are encoded as fixed-width little-endian values.
+//
+// Entries that fail to decode, whose checksum does not match, or that were written by a different version of warp or
+// for a different instruction set are ignored and replaced. The version alone is not sufficient: development builds
+// all report the same version unless they carry VCS information, but may assign different opcodes.
+
+const cacheMagic = "warpfc\x00\x00"
+
+// cacheFormat is the version of the cache's encoding. It must be incremented whenever the encoding changes.
+const cacheFormat = 2
+
+// cacheFingerprint is the fingerprint of the fcode instruction set used by this build.
+var cacheFingerprint = opcodeFingerprint()
+
+var errCorruptCache = errors.New("corrupt fcode cache entry")
+
+// opcodeFingerprint returns a hash of the fcode opcode table and the superinstruction definitions. Cache entries are
+// only valid for builds with the same fingerprint.
+func opcodeFingerprint() string {
+	ops := make([]int, 0, len(opcodeNames))
+	for op := range opcodeNames {
+		ops = append(ops, int(op))
+	}
+	sort.Ints(ops)
+
+	fusions := make([]string, 0, len(superinstructions))
+	for f, op := range superinstructions {
+		fusions = append(fusions, fmt.Sprintf("%v %v %v %v", f.producer, f.consumer, f.operand, op))
+	}
+	sort.Strings(fusions)
+
+	h := sha256.New()
+	for _, op := range ops {
+		fmt.Fprintf(h, "%v %v %+v\n", op, opcodeNames[opcode(op)], fusibleInstructions[opcode(op)])
+	}
+	for _, f := range fusions {
+		fmt.Fprintf(h, "%v\n", f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contentHash returns the module's content hash. See wasm.ContentHash.
+func (def *moduleDefinition) contentHash() (string, bool) {
+	def.hashOnce.Do(func() {
+		def.hash, _ = wasm.ContentHash(def.mod)
+	})
+	return def.hash, def.hash != ""
+}
+
+// precompileCached reads the module's compiled functions from the cache. If the cache does not contain a valid entry
+// for the module, precompileCached compiles the module's functions and writes them to the cache.
+func (o *Options) precompileCached(module *module, bodies []wasm.FunctionBody, hash string) {
+	path := filepath.Join(o.CacheDir, hash+".fcode")
+
+	if entry, err := ioutil.ReadFile(path); err == nil {
+		if functions, err := decodeCacheEntry(entry, module, hash); err == nil {
+			copy(module.functions, functions)
+			return
+		}
+	}
+
+	compiled := make([]bool, len(bodies))
+	compileAll(o.parallelism(), len(bodies), nil, func(i int) {
+		if fn, ok := compileFunction(&module.functions[i], bodies[i]); ok {
+			module.functions[i], compiled[i] = fn, true
+		}
+	})
+	for _, ok := range compiled {
+		if !ok {
+			// Functions that fail to decode report their errors when they are called, so don't cache the module.
+			return
+		}
+	}
+
+	// The cache is best-effort: failures to write it are not errors.
+	writeCacheEntry(o.CacheDir, path, encodeCacheEntry(module.functions, hash))
+}
+
+// writeCacheEntry atomically writes the given cache entry to path.
+func writeCacheEntry(dir, path string, entry []byte) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(entry)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+type cacheEncoder struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *cacheEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *cacheEncoder) varint(v int) {
+	n := binary.PutVarint(e.scratch[:], int64(v))
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *cacheEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *cacheEncoder) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(e.scratch[:], v)
+	e.buf.Write(e.scratch[:2])
+}
+
+func (e *cacheEncoder) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(e.scratch[:], v)
+	e.buf.Write(e.scratch[:4])
+}
+
+func (e *cacheEncoder) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(e.scratch[:], v)
+	e.buf.Write(e.scratch[:8])
+}
+
+func encodeCacheEntry(functions []function, hash string) []byte {
+	var e cacheEncoder
+	e.buf.WriteString(cacheMagic)
+	e.uvarint(cacheFormat)
+	e.string(buildinfo.Version())
+	e.string(cacheFingerprint)
+	e.string(hash)
+
+	e.uvarint(uint64(len(functions)))
+	for i := range functions {
+		fn := &functions[i]
+
+		e.varint(fn.numLocals)
+		e.varint(fn.metrics.MaxNesting)
+		e.varint(fn.metrics.MaxStackDepth)
+		e.varint(fn.metrics.LabelCount)
+		if fn.metrics.HasLoops {
+			e.uvarint(1)
+		} else {
+			e.uvarint(0)
+		}
+
+		e.uvarint(uint64(len(fn.icode)))
+		for _, instr := range fn.icode {
+			e.buf.WriteByte(instr.Opcode)
+			e.uvarint(instr.Immediate)
+			e.uvarint(uint64(len(instr.Labels)))
+			for _, l := range instr.Labels {
+				e.varint(l)
+			}
+		}
+
+		e.uvarint(uint64(len(fn.fcode)))
+		for _, instr := range fn.fcode {
+			e.uint16(uint16(instr.opcode))
+			e.uint16(instr.flags)
+			e.uint32(instr.dest)
+			e.uint32(instr.src1)
+			e.uint64(instr.src2)
+		}
+
+		e.uvarint(uint64(len(fn.labels)))
+		for _, l := range fn.labels {
+			e.varint(l.continuation[0])
+			e.varint(l.continuation[1])
+			e.varint(l.stackHeight)
+			e.varint(l.arity)
+		}
+
+		e.uvarint(uint64(len(fn.switches)))
+		for _, s := range fn.switches {
+			e.uvarint(uint64(len(s.indices)))
+			for _, i := range s.indices {
+				e.varint(i)
+			}
+		}
+	}
+
+	sum := sha256.Sum256(e.buf.Bytes())
+	e.buf.Write(sum[:])
+	return e.buf.Bytes()
+}
+
+type cacheDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *cacheDecoder) fail() {
+	d.err, d.buf = errCorruptCache, nil
+}
+
+func (d *cacheDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *cacheDecoder) varint() int {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 || int64(int(v)) != v {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+// count decodes a length. Each counted element occupies at least one byte, so lengths that exceed the number of
+// remaining bytes indicate corruption.
+func (d *cacheDecoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.buf)) {
+		d.fail()
+		return 0
+	}
+	return int(v)
+}
+
+func (d *cacheDecoder) bytes(n int) []byte {
+	if n > len(d.buf) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *cacheDecoder) string() string {
+	return string(d.bytes(d.count()))
+}
+
+func (d *cacheDecoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *cacheDecoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *cacheDecoder) uint64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// decodeCacheEntry decodes the compiled functions in the given cache entry. The returned functions belong to the
+// given module.
+func decodeCacheEntry(entry []byte, module *module, hash string) ([]function, error) {
+	if len(entry) < len(cacheMagic)+sha256.Size || string(entry[:len(cacheMagic)]) != cacheMagic {
+		return nil, errCorruptCache
+	}
+	body, sum := entry[:len(entry)-sha256.Size], entry[len(entry)-sha256.Size:]
+	if actual := sha256.Sum256(body); !bytes.Equal(actual[:], sum) {
+		return nil, errCorruptCache
+	}
+
+	d := cacheDecoder{buf: body[len(cacheMagic):]}
+	if d.uvarint() != cacheFormat || d.string() != buildinfo.Version() || d.string() != cacheFingerprint || d.string() != hash {
+		return nil, errors.New("stale fcode cache entry")
+	}
+
+	if d.count() != len(module.functions) {
+		return nil, errCorruptCache
+	}
+
+	functions := make([]function, len(module.functions))
+	for i := range functions {
+		fn := &functions[i]
+
+		template := &module.functions[i]
+		fn.module, fn.index, fn.signature, fn.localEntries = template.module, template.index, template.signature, template.localEntries
+
+		fn.numLocals = d.varint()
+		fn.metrics.MaxNesting = d.varint()
+		fn.metrics.MaxStackDepth = d.varint()
+		fn.metrics.LabelCount = d.varint()
+		fn.metrics.HasLoops = d.uvarint() != 0
+
+		fn.icode = make([]code.Instruction, d.count())
+		for j := range fn.icode {
+			instr := &fn.icode[j]
+			if b := d.bytes(1); b != nil {
+				instr.Opcode = b[0]
+			}
+			instr.Immediate = d.uvarint()
+			if n := d.count(); n != 0 {
+				instr.Labels = make([]int, n)
+				for k := range instr.Labels {
+					instr.Labels[k] = d.varint()
+				}
+			}
+		}
+
+		fn.fcode = make([]finstruction, d.count())
+		for j := range fn.fcode {
+			instr := &fn.fcode[j]
+			instr.opcode = opcode(d.uint16())
+			instr.flags = d.uint16()
+			instr.dest = d.uint32()
+			instr.src1 = d.uint32()
+			instr.src2 = d.uint64()
+		}
+
+		fn.labels = make([]label, d.count())
+		for j := range fn.labels {
+			l := &fn.labels[j]
+			l.continuation[0] = d.varint()
+			l.continuation[1] = d.varint()
+			l.stackHeight = d.varint()
+			l.arity = d.varint()
+		}
+
+		if n := d.count(); n != 0 {
+			fn.switches = make([]switchTable, n)
+			for j := range fn.switches {
+				s := &fn.switches[j]
+				s.indices = make([]int, d.count())
+				for k := range s.indices {
+					s.indices[k] = d.varint()
+				}
+			}
+		}
+
+		if d.err != nil {
+			return nil, d.err
+		}
+		if len(fn.icode) == 0 || len(fn.fcode) == 0 || len(fn.labels) == 0 {
+			return nil, errCorruptCache
+		}
+		fn.kind = functionKindFCode
+	}
+
+	if d.err != nil || len(d.buf) != 0 {
+		return nil, errCorruptCache
+	}
+	return functions, nil
+}
@@ -0,0 +1,237 @@
+package interpreter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/debuginfo"
+	"github.com/pgavlin/warp/internal/disasm"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// runCoverage executes the given function one instruction at a time, recording the instructions and branches that
+// execute.
+func (f *frame) runCoverage(fn *function, coverage *exec.Coverage) {
+	fc := coverage.Function(f.module.name, fn.index, len(fn.icode))
+
+	// Push the first label.
+	f.blocks = f.blocks[:2]
+	f.blocks[0] = uint64(len(fn.icode) - 1)
+	f.blocks[1] = uint64(len(fn.signature.ReturnTypes))
+
+	watched := f.module.mem0.Watched()
+	ip := 0
+	for {
+		fc.Instructions[ip]++
+
+		switch instr := &fn.icode[ip]; instr.Opcode {
+		case code.OpIf, code.OpBrIf:
+			outcome := 0
+			if uint32(f.stack[len(f.stack)-1]) == 0 {
+				outcome = 1
+			}
+			fc.Branch(ip, outcome, 2)
+		case code.OpBrTable:
+			outcome := int(int32(f.stack[len(f.stack)-1]))
+			if outcome < 0 || outcome >= len(instr.Labels) {
+				outcome = len(instr.Labels)
+			}
+			fc.Branch(ip, outcome, len(instr.Labels)+1)
+		}
+
+		if watched {
+			ip = f.stepWatch(fn, ip)
+		} else {
+			ip = f.step(fn.icode, ip)
+		}
+		if ip == len(fn.icode) {
+			return
+		}
+	}
+}
+
+// lcovFile accumulates the coverage records for a single source file.
+type lcovFile struct {
+	functions []lcovFunction
+	lines     map[int]uint64
+	branches  []lcovBranch
+}
+
+type lcovFunction struct {
+	line  int
+	name  string
+	calls uint64
+}
+
+type lcovBranch struct {
+	line     int
+	block    int // The offset of the branch instruction.
+	branch   int // The branch's outcome.
+	executed bool
+	taken    uint64
+}
+
+// WriteLCOV writes a coverage report for the given module in the lcov tracefile format. The coverage for the module's
+// functions is typically obtained from an exec.Coverage using the module's name.
+//
+// Instructions are mapped to source lines using the module's DWARF line information. Functions that have no line
+// information are reported against the module's disassembly: their source file is watPath, and their lines are lines
+// in the text format of the module as written by wast.WriteTo. WriteLCOV returns true if any functions were reported
+// against the disassembly, in which case the caller should write the disassembly to watPath.
+func WriteLCOV(w io.Writer, mod *wasm.Module, functions map[uint32]*exec.FunctionCoverage, watPath string) (disassembled bool, err error) {
+	info := debuginfo.New(mod)
+
+	var disassembly *disasm.Disassembly
+	files := map[string]*lcovFile{}
+	file := func(path string) *lcovFile {
+		f, ok := files[path]
+		if !ok {
+			f = &lcovFile{lines: map[int]uint64{}}
+			files[path] = f
+		}
+		return f
+	}
+
+	for index := info.ImportedFunctions(); index < info.FunctionCount(); index++ {
+		instructions, _, ok := info.DecodeInstructions(index)
+		if !ok {
+			continue
+		}
+
+		fc := functions[index]
+		count := func(ip int) uint64 {
+			if fc == nil || ip >= len(fc.Instructions) {
+				return 0
+			}
+			return fc.Instructions[ip]
+		}
+
+		// Find the source location of each instruction. If the function has no line information, use the disassembly.
+		type location struct {
+			file string
+			line int
+		}
+		locations, located := make([]location, len(instructions)), false
+		for ip := range instructions {
+			if file, line, ok := info.SourceLocation(index, ip); ok {
+				locations[ip], located = location{file, line}, true
+			}
+		}
+		if !located {
+			if disassembly == nil {
+				if disassembly, err = disasm.Disassemble(mod); err != nil {
+					return false, err
+				}
+			}
+			for ip := range instructions {
+				line, _ := disassembly.Line(index, ip)
+				locations[ip] = location{watPath, line}
+			}
+			disassembled = true
+		}
+
+		name, ok := info.FunctionName(index)
+		if !ok {
+			name = fmt.Sprintf("func %d", index)
+		}
+
+		entry := true
+		for ip, instr := range instructions {
+			loc := locations[ip]
+			if loc.line == 0 {
+				continue
+			}
+			f := file(loc.file)
+
+			if entry {
+				f.functions, entry = append(f.functions, lcovFunction{line: loc.line, name: name, calls: count(0)}), false
+			}
+
+			// A line's count is the count of its most-executed instruction.
+			if n, ok := f.lines[loc.line]; !ok || count(ip) > n {
+				f.lines[loc.line] = count(ip)
+			}
+
+			outcomes := 0
+			switch instr.Opcode {
+			case code.OpIf, code.OpBrIf:
+				outcomes = 2
+			case code.OpBrTable:
+				outcomes = len(instr.Labels) + 1
+			}
+			for outcome := 0; outcome < outcomes; outcome++ {
+				b := lcovBranch{line: loc.line, block: ip, branch: outcome, executed: count(ip) != 0}
+				if fc != nil {
+					if taken := fc.Branches[ip]; outcome < len(taken) {
+						b.taken = taken[outcome]
+					}
+				}
+				f.branches = append(f.branches, b)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	for _, path := range paths {
+		files[path].write(bw, path)
+	}
+	return disassembled, bw.Flush()
+}
+
+// write writes the file's records.
+func (f *lcovFile) write(w *bufio.Writer, path string) {
+	fmt.Fprintf(w, "SF:%s\n", path)
+
+	sort.SliceStable(f.functions, func(i, j int) bool { return f.functions[i].line < f.functions[j].line })
+	hit := 0
+	for _, fn := range f.functions {
+		fmt.Fprintf(w, "FN:%d,%s\n", fn.line, fn.name)
+	}
+	for _, fn := range f.functions {
+		fmt.Fprintf(w, "FNDA:%d,%s\n", fn.calls, fn.name)
+		if fn.calls != 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(f.functions), hit)
+
+	sort.SliceStable(f.branches, func(i, j int) bool { return f.branches[i].line < f.branches[j].line })
+	hit = 0
+	for _, b := range f.branches {
+		if !b.executed {
+			fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", b.line, b.block, b.branch)
+			continue
+		}
+		fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", b.line, b.block, b.branch, b.taken)
+		if b.taken != 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", len(f.branches), hit)
+
+	lines := make([]int, 0, len(f.lines))
+	for line := range f.lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	hit = 0
+	for _, line := range lines {
+		fmt.Fprintf(w, "DA:%d,%d\n", line, f.lines[line])
+		if f.lines[line] != 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\n", len(lines), hit)
+
+	fmt.Fprintln(w, "end_of_record")
+}
@@ -0,0 +1,545 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/debuginfo"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// A ResumeMode determines how execution proceeds once a debugger resumes a stopped thread.
+type ResumeMode int
+
+const (
+	// Continue resumes execution until the next breakpoint.
+	Continue ResumeMode = iota
+	// StepInto executes a single instruction. If the instruction calls an interpreted function, execution stops at the
+	// callee's first instruction.
+	StepInto
+	// StepOver executes a single instruction. Calls made by the instruction run to completion unless they hit a
+	// breakpoint.
+	StepOver
+	// StepOut resumes execution until the current function returns to its caller.
+	StepOut
+)
+
+// A StopReason describes why a debugger stopped execution.
+type StopReason int
+
+const (
+	// StopBreakpoint indicates that execution reached a breakpoint.
+	StopBreakpoint StopReason = iota
+	// StopStep indicates that a step completed.
+	StopStep
+	// StopPause indicates that execution was paused by a call to Debugger.Pause.
+	StopPause
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// A Breakpoint stops execution before a particular instruction executes.
+type Breakpoint struct {
+	ID       int    // The breakpoint's unique ID.
+	Function uint32 // The index of the function that contains the breakpoint.
+	Offset   int    // The index of the instruction within the function's body.
+	File     string // The source file for the breakpoint, if it was set by line.
+	Line     int    // The source line for the breakpoint, if it was set by line.
+
+	module *debuginfo.Info // The module that contains the breakpoint, or nil if the breakpoint applies to every module.
+}
+
+// breakpointKey identifies the breakpoints for a function.
+type breakpointKey struct {
+	module   *debuginfo.Info
+	function uint32
+}
+
+// A breakpointSet is an immutable snapshot of a debugger's breakpoints. Each change to the debugger's breakpoints
+// replaces its set, so the executing thread may read a set without synchronization while breakpoints are changed on
+// other goroutines.
+type breakpointSet struct {
+	functions map[breakpointKey]map[int]*Breakpoint
+}
+
+// function returns the breakpoints in the given function, indexed by instruction offset.
+func (s *breakpointSet) function(fn *function) map[int]*Breakpoint {
+	local := s.functions[breakpointKey{module: fn.module.debugInfo, function: fn.index}]
+	global := s.functions[breakpointKey{function: fn.index}]
+	switch {
+	case len(local) == 0:
+		return global
+	case len(global) == 0:
+		return local
+	}
+
+	merged := make(map[int]*Breakpoint, len(local)+len(global))
+	for offset, b := range global {
+		merged[offset] = b
+	}
+	for offset, b := range local {
+		merged[offset] = b
+	}
+	return merged
+}
+
+// A Stop describes the state of a thread that has been stopped by a debugger.
+type Stop struct {
+	Reason     StopReason    // The reason execution stopped.
+	Breakpoint *Breakpoint   // The breakpoint that stopped execution, if any.
+	Frames     []*DebugFrame // The thread's interpreted frames, innermost first.
+}
+
+// A DebugFrame describes an activation of an interpreted function.
+//
+// Only frames that are executing icode under the debugger have an instruction pointer, locals, and an operand stack.
+// Frames that are not executing one instruction at a time, such as frames executing fcode, report an IP of -1.
+type DebugFrame struct {
+	Module   string   // The name of the module that defines the function.
+	Function uint32   // The index of the function.
+	IP       int      // The index of the next instruction to execute, or -1 if it is not known.
+	Locals   []uint64 // The frame's parameters and locals. Modifications take effect when execution resumes.
+	Stack    []uint64 // The frame's operand stack, bottom first. Modifications take effect when execution resumes.
+
+	fn *function
+}
+
+// FunctionName returns the name of the frame's function, if it has one. Function names are taken from the module's
+// name section or its exports.
+func (f *DebugFrame) FunctionName() (string, bool) {
+	if f.fn.module.debugInfo == nil {
+		return "", false
+	}
+	return f.fn.module.debugInfo.FunctionName(f.Function)
+}
+
+// Signature returns the signature of the frame's function.
+func (f *DebugFrame) Signature() wasm.FunctionSig {
+	return f.fn.signature
+}
+
+// LocalTypes returns the types of the frame's parameters and locals.
+func (f *DebugFrame) LocalTypes() []wasm.ValueType {
+	locals := append([]wasm.ValueType(nil), f.fn.signature.ParamTypes...)
+	for _, entry := range f.fn.localEntries {
+		for i := 0; i < int(entry.Count); i++ {
+			locals = append(locals, entry.Type)
+		}
+	}
+	return locals
+}
+
+// Instructions returns the icode for the frame's function.
+func (f *DebugFrame) Instructions() []code.Instruction {
+	return f.fn.icode
+}
+
+// SourceLocation returns the source location of the frame's next instruction, if the module has DWARF line
+// information.
+func (f *DebugFrame) SourceLocation() (file string, line int, ok bool) {
+	if f.IP < 0 || f.fn.module.debugInfo == nil {
+		return "", 0, false
+	}
+	return f.fn.module.debugInfo.SourceLocation(f.Function, f.IP)
+}
+
+// Global returns the global with the given index in the frame's module.
+func (f *DebugFrame) Global(index uint32) (*exec.Global, bool) {
+	return f.fn.module.getGlobal(index)
+}
+
+// Globals returns the number of globals in the frame's module.
+func (f *DebugFrame) Globals() int {
+	return len(f.fn.module.importedGlobals) + len(f.fn.module.globals)
+}
+
+// Memory returns the default memory of the frame's module, if any.
+func (f *DebugFrame) Memory() *exec.Memory {
+	return f.fn.module.mem0
+}
+
+// A debugActivation records an interpreted frame that is executing under a debugger.
+type debugActivation struct {
+	m     *machine  // The machine that owns the frame.
+	frame int       // The index of the frame in the machine's frames.
+	fn    *function // The function executing in the frame.
+	ip    int       // The frame's instruction pointer, or -1 if the frame is not executing under the debugger.
+}
+
+// A Debugger controls the execution of interpreted modules. Modules are debugged by creating their definitions with
+// an Options value whose Debugger field refers to the debugger.
+//
+// The debugger pauses execution by calling its OnStop function on the executing goroutine. Until OnStop returns, the
+// stopped thread's frames may be inspected and modified, and breakpoints may be added or removed. Functions that
+// contain breakpoints, and all functions while the debugger is stepping into calls, execute as icode one instruction
+// at a time. Functions that have been compiled to fcode return to icode the next time they are called. Frames that
+// are already executing begin executing one instruction at a time the next time they reach the top of a loop, so
+// breakpoints, steps, and pauses also take effect in long-running loops that make no calls.
+//
+// Breakpoints may be added or removed from any goroutine, including while the debugged thread is running. Otherwise,
+// a Debugger may only be used by one thread at a time. Suspendable calls made using StartAsync are not debugged.
+type Debugger struct {
+	// OnStop is called each time execution stops. OnStop returns the mode in which execution resumes. If OnStop is
+	// nil, execution continues.
+	OnStop func(stop *Stop) ResumeMode
+
+	m           sync.Mutex // Serializes changes to modules and breakpoints.
+	modules     []*debuginfo.Info
+	nextID      int
+	breakpoints atomic.Value // The current *breakpointSet.
+
+	mode   ResumeMode
+	depth  int   // The depth of the frame that was stopped when the current mode was chosen.
+	paused int32 // Non-zero if Pause has been called.
+
+	activations []debugActivation
+}
+
+// NewDebugger creates a new debugger that calls the given function when execution stops.
+func NewDebugger(onStop func(stop *Stop) ResumeMode) *Debugger {
+	d := &Debugger{OnStop: onStop}
+	d.breakpoints.Store(&breakpointSet{})
+	return d
+}
+
+// attach registers a module with the debugger and returns its debugging information.
+func (d *Debugger) attach(mod *wasm.Module) *debuginfo.Info {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	info := debuginfo.New(mod)
+	d.modules = append(d.modules, info)
+	return info
+}
+
+// loadBreakpoints returns the debugger's current breakpoints.
+func (d *Debugger) loadBreakpoints() *breakpointSet {
+	return d.breakpoints.Load().(*breakpointSet)
+}
+
+// storeBreakpoints replaces the breakpoints for the given function. The caller must hold d.m.
+func (d *Debugger) storeBreakpoints(key breakpointKey, offsets map[int]*Breakpoint) {
+	old := d.loadBreakpoints().functions
+
+	functions := make(map[breakpointKey]map[int]*Breakpoint, len(old)+1)
+	for k, v := range old {
+		functions[k] = v
+	}
+	if len(offsets) == 0 {
+		delete(functions, key)
+	} else {
+		functions[key] = offsets
+	}
+	d.breakpoints.Store(&breakpointSet{functions: functions})
+}
+
+// addBreakpoint adds a breakpoint at the given location. If a breakpoint already exists at the location, addBreakpoint
+// returns the existing breakpoint. The caller must hold d.m.
+func (d *Debugger) addBreakpoint(info *debuginfo.Info, function uint32, offset int, file string, line int) *Breakpoint {
+	key := breakpointKey{module: info, function: function}
+	old := d.loadBreakpoints().functions[key]
+	if b, ok := old[offset]; ok {
+		return b
+	}
+
+	d.nextID++
+	b := &Breakpoint{ID: d.nextID, Function: function, Offset: offset, File: file, Line: line, module: info}
+
+	offsets := make(map[int]*Breakpoint, len(old)+1)
+	for o, existing := range old {
+		offsets[o] = existing
+	}
+	offsets[offset] = b
+	d.storeBreakpoints(key, offsets)
+	return b
+}
+
+// SetBreakpoint sets a breakpoint before the instruction at the given offset in the function with the given index.
+// The breakpoint applies to every module debugged by the debugger.
+func (d *Debugger) SetBreakpoint(function uint32, offset int) *Breakpoint {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.addBreakpoint(nil, function, offset, "", 0)
+}
+
+// SetFunctionBreakpoint sets a breakpoint before the instruction at the given offset in the named function. The first
+// debugged module that defines a function with the given name determines the breakpoint's location.
+func (d *Debugger) SetFunctionBreakpoint(name string, offset int) (*Breakpoint, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	for _, info := range d.modules {
+		if function, ok := info.LookupFunction(name); ok {
+			if _, ok := info.Body(function); !ok {
+				return nil, fmt.Errorf("function %v is imported", name)
+			}
+			return d.addBreakpoint(info, function, offset, "", 0), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown function %v", name)
+}
+
+// SetLineBreakpoint sets breakpoints at the given source line using the DWARF line information of the debugged
+// modules. A breakpoint is set in each function that contains code for the line.
+func (d *Debugger) SetLineBreakpoint(file string, line int) ([]*Breakpoint, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	var breakpoints []*Breakpoint
+	var lastErr error
+	for _, info := range d.modules {
+		locations, err := info.LineLocations(file, line)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, l := range locations {
+			breakpoints = append(breakpoints, d.addBreakpoint(info, l.Function, l.IP, file, line))
+		}
+	}
+	if len(breakpoints) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("reading line information: %w", lastErr)
+		}
+		return nil, fmt.Errorf("no code for %v:%v", file, line)
+	}
+	return breakpoints, nil
+}
+
+// ClearBreakpoint removes the given breakpoint.
+func (d *Debugger) ClearBreakpoint(b *Breakpoint) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	key := breakpointKey{module: b.module, function: b.Function}
+	old := d.loadBreakpoints().functions[key]
+	if old[b.Offset] != b {
+		return
+	}
+
+	offsets := make(map[int]*Breakpoint, len(old))
+	for o, existing := range old {
+		offsets[o] = existing
+	}
+	delete(offsets, b.Offset)
+	d.storeBreakpoints(key, offsets)
+}
+
+// Breakpoints returns the debugger's breakpoints, ordered by ID.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	var breakpoints []*Breakpoint
+	for _, offsets := range d.loadBreakpoints().functions {
+		for _, b := range offsets {
+			breakpoints = append(breakpoints, b)
+		}
+	}
+	sort.Slice(breakpoints, func(i, j int) bool { return breakpoints[i].ID < breakpoints[j].ID })
+	return breakpoints
+}
+
+// Pause requests that execution stop as soon as possible. Pause may be called from any goroutine. Execution stops
+// at the next instruction that executes under the debugger, when the next interpreted function is called, or when an
+// executing function next reaches the top of a loop.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+// stepping returns true if the given function must execute one instruction at a time. Because Pause may be called
+// at any time, the result is only consulted when a function's frame is pushed, and is recorded in the frame. Frames
+// that are not stepping consult safepoint instead.
+func (d *Debugger) stepping(fn *function) bool {
+	if d == nil {
+		return false
+	}
+	return d.mode == StepInto || atomic.LoadInt32(&d.paused) != 0 || len(d.loadBreakpoints().function(fn)) != 0
+}
+
+// run executes an interpreted function under the debugger.
+func (d *Debugger) run(f *frame, fn *function) {
+	n := len(d.activations)
+	d.activations = append(d.activations, debugActivation{m: f.m, frame: len(f.m.frames) - 1, fn: fn, ip: -1})
+	defer d.leave(n)
+
+	if !f.stepping {
+		f.run(fn)
+
+		// If the frame began stepping at a loop safepoint, it has not finished executing.
+		if !f.stepping {
+			return
+		}
+	}
+	f.runDebugger(d, fn, n)
+}
+
+// safepoint returns true if a frame that is not stepping must begin stepping at the top of a loop: the debugger is
+// stepping into calls, Pause has been called, or breakpoints have been added to the frame's function.
+func (f *frame) safepoint(fn *function) bool {
+	d := f.module.debugger
+	if d.mode == StepInto || atomic.LoadInt32(&d.paused) != 0 {
+		return true
+	}
+	if set := d.loadBreakpoints(); set != f.breakpoints {
+		f.breakpoints = set
+		return len(set.function(fn)) != 0
+	}
+	return false
+}
+
+// icodeSafepoint is called by frames executing icode after a branch to the instruction at the given index. Branches to
+// loops are safepoints: if the frame must begin stepping, icodeSafepoint switches the frame to stepping at the loop
+// and returns true.
+func (f *frame) icodeSafepoint(fn *function, ip int) bool {
+	if fn.icode[ip].Opcode != code.OpLoop || !f.safepoint(fn) {
+		return false
+	}
+	f.stepping, f.resume = true, ip
+	return true
+}
+
+// leaveFCode switches a frame that is executing fcode at a loop safepoint to stepping at the corresponding loop
+// instruction in the function's icode. The frame's operand stack is moved above a new block stack, and the block stack
+// is rebuilt from the blocks that enclose the loop.
+func (f *frame) leaveFCode(fn *function, ip int) {
+	loop := &fn.icode[ip]
+	f.m.reserveBlocks(f, fn.metrics.MaxNesting*2, loop.StackHeight()+f.module.blockArity(loop, true))
+
+	// Push the first label, then the labels of the enclosing blocks.
+	f.blocks = f.blocks[:2]
+	f.blocks[0] = uint64(len(fn.icode) - 1)
+	f.blocks[1] = uint64(len(fn.signature.ReturnTypes))
+	for i := 0; i < ip; i++ {
+		switch instr := &fn.icode[i]; instr.Opcode {
+		case code.OpBlock, code.OpIf:
+			f.pushContinuation(instr, false)
+		case code.OpLoop:
+			f.pushContinuation(instr, true)
+		case code.OpEnd:
+			f.popContinuation()
+		}
+	}
+
+	f.stepping, f.resume = true, ip
+}
+
+// leave pops the activations at and above n.
+func (d *Debugger) leave(n int) {
+	d.activations = d.activations[:n]
+
+	// If a step should stop in a caller that is not executing under the debugger, stop as soon as possible instead.
+	if (d.mode == StepOver || d.mode == StepOut) && n < d.depth && (n == 0 || d.activations[n-1].ip < 0) {
+		d.mode = StepInto
+	}
+}
+
+// check stops execution if the instruction at the given ip in the n'th activation should stop.
+func (d *Debugger) check(n int, b *Breakpoint) {
+	depth := n + 1
+
+	var reason StopReason
+	switch {
+	case b != nil:
+		reason = StopBreakpoint
+	case atomic.LoadInt32(&d.paused) != 0:
+		reason = StopPause
+	case d.mode == StepInto, d.mode == StepOver && depth <= d.depth, d.mode == StepOut && depth < d.depth:
+		reason = StopStep
+	default:
+		return
+	}
+
+	atomic.StoreInt32(&d.paused, 0)
+
+	mode := Continue
+	if d.OnStop != nil {
+		mode = d.OnStop(&Stop{Reason: reason, Breakpoint: b, Frames: d.frames()})
+	}
+	d.mode, d.depth = mode, depth
+}
+
+// frames returns the frames for the debugger's activations, innermost first.
+func (d *Debugger) frames() []*DebugFrame {
+	frames := make([]*DebugFrame, len(d.activations))
+	for i := range d.activations {
+		a := &d.activations[len(d.activations)-1-i]
+
+		frame := &DebugFrame{
+			Module:   a.fn.module.name,
+			Function: a.fn.index,
+			IP:       a.ip,
+			fn:       a.fn,
+		}
+		if a.ip >= 0 {
+			f := a.m.frames[a.frame]
+			frame.Locals, frame.Stack = f.locals, f.stack
+		}
+		frames[i] = frame
+	}
+	return frames
+}
+
+// runDebugger executes the given function one instruction at a time, stopping at breakpoints and steps.
+func (f *frame) runDebugger(d *Debugger, fn *function, n int) {
+	if f.m.thread.Debug() {
+		f.m.thread.EnterFrame(&exec.Frame{
+			ModuleName:        f.module.name,
+			FunctionIndex:     fn.index,
+			FunctionSignature: fn.signature,
+			Locals:            f.locals,
+		})
+	} else {
+		f.m.thread.Enter()
+	}
+
+	// Push the first label, unless the frame began stepping at a loop safepoint.
+	ip := f.resume
+	if ip < 0 {
+		f.blocks = f.blocks[:2]
+		f.blocks[0] = uint64(len(fn.icode) - 1)
+		f.blocks[1] = uint64(len(fn.signature.ReturnTypes))
+		ip = 0
+	}
+
+	set := d.loadBreakpoints()
+	breakpoints := set.function(fn)
+	for {
+		d.activations[n].ip = ip
+
+		if current := d.loadBreakpoints(); current != set {
+			set, breakpoints = current, current.function(fn)
+		}
+		if d.mode != Continue || breakpoints != nil || atomic.LoadInt32(&d.paused) != 0 {
+			d.check(n, breakpoints[ip])
+		}
+
+		if f.module.mem0.Watched() {
+			ip = f.stepWatch(fn, ip)
+		} else {
+			ip = f.step(fn.icode, ip)
+		}
+		if ip == len(fn.icode) {
+			break
+		}
+	}
+
+	if f.m.thread.Debug() {
+		f.m.thread.LeaveFrame()
+	} else {
+		f.m.thread.Leave()
+	}
+}
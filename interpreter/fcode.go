@@ -1,3 +1,5 @@
+//go:generate go run ./gen-fcode -profile gen-fcode/flate.profile
+
 package interpreter
 
 import (
@@ -32,6 +34,13 @@ import (
 // most local.get and constant instructions into the consuming instruction, combining
 // many compares and branches, and removing most block instructions.
 //
+// Fcode also contains superinstructions that fuse short sequences of arithmetic, load, and
+// store instructions in which each instruction consumes the result of its predecessor. The
+// set of superinstructions is selected by gen-fcode using an opcode profile of the flate
+// benchmark (see OpcodeProfile), and fcode_ops.go and the fcode execution loops are
+// generated accordingly. Do not edit the generated files directly: edit the templates in
+// gen-fcode and run go generate.
+//
 // An fcode function body is composed of a list of labels, a list of switch tables, and a
 // sequence of instructions. An fcode function _invocation_ has an associated frame
 // that holds the function's arguments, locals, and temporary values. The frame is
@@ -191,6 +200,7 @@ type switchTable struct {
 type fimporter struct {
 	fn     *function
 	locals int
+	ip     int // The index of the icode instruction being imported.
 
 	labels   []label
 	switches []switchTable
@@ -214,6 +224,7 @@ func (m *machine) emitFcode(fn *function, body []code.Instruction) {
 	imp.blocks[0] = block{outs: len(fn.signature.ReturnTypes)}
 
 	for i := range body {
+		imp.ip = i
 		imp.emitInstruction(&body[i])
 	}
 
@@ -332,6 +343,16 @@ func (imp *fimporter) emitBlock(ins, outs int, isLoop bool) {
 		label.arity = outs
 	}
 	imp.labels = append(imp.labels, label)
+
+	// The loops of debugged functions begin with a safepoint at which the frame may leave fcode for the debugger.
+	// Every value on the stack has been materialized, so the frame's slots hold the loop's icode state.
+	if isLoop && imp.fn.module.debugger != nil {
+		imp.body = append(imp.body, finstruction{
+			opcode: fopLoop,
+			dest:   uint32(len(imp.labels) - 1),
+			src1:   uint32(imp.ip),
+		})
+	}
 }
 
 func (imp *fimporter) emitElse() {
@@ -541,6 +562,78 @@ func (imp *fimporter) mergeConditionalBranch(conditionValue *fvalue, branch *fin
 	return true
 }
 
+// A fusion identifies a superinstruction by its producer, its consumer, and the operand of
+// the consumer that receives the producer's result. Operands are numbered in WASM order
+// starting at 1.
+type fusion struct {
+	producer opcode
+	consumer opcode
+	operand  int
+}
+
+// A fusibleInstruction describes an instruction that may be fused into a superinstruction.
+type fusibleInstruction struct {
+	operands   int   // the number of operands
+	immediates uint8 // a mask of the operands that are immediates rather than frame slots
+	store      bool  // true if the instruction is a store
+	result     bool  // true if the instruction produces a result
+}
+
+// fusionOperands returns the operands of a fusible instruction in WASM order. The operands
+// of a store are its address, which is held in the destination field, and its value. The
+// operands of a superinstruction are held in src1 and the two halves of src2.
+func fusionOperands(fi *finstruction) []uint32 {
+	info := fusibleInstructions[fi.opcode]
+	if info.store {
+		return []uint32{fi.dest, fi.src1}
+	}
+	return []uint32{fi.src1, fi.Src2(), fi.Src3()}[:info.operands]
+}
+
+// fuse attempts to fuse the instruction that produced v with the given consumer. If the
+// producer is the last instruction in the body, its result is not otherwise used, and the
+// pair is a superinstruction, the producer is replaced with the superinstruction and the
+// consumer's results are pushed.
+func (imp *fimporter) fuse(consumer *finstruction, v *fvalue, operand, nresults int) bool {
+	if imp.fn.module.opcodeProfile != nil || v.flags != vfFrame || v.ip != len(imp.body)-1 {
+		return false
+	}
+
+	producer := &imp.body[v.ip]
+	if producer.flags&ifDefLocal != 0 || producer.dest != v.addr {
+		return false
+	}
+
+	op, ok := superinstructions[fusion{producer: producer.opcode, consumer: consumer.opcode, operand: operand}]
+	if !ok {
+		return false
+	}
+
+	// The superinstruction's operands are the producer's operands followed by the consumer's
+	// remaining operands.
+	operands := fusionOperands(producer)
+	for i, o := range fusionOperands(consumer) {
+		if i != operand-1 {
+			operands = append(operands, o)
+		}
+	}
+
+	fi := finstruction{opcode: op, flags: ifSrc1Frame, src1: operands[0]}
+	if fusibleInstructions[op].result {
+		fi.dest = consumer.dest
+	}
+	if len(operands) > 1 {
+		fi.src2 = uint64(operands[1])
+	}
+	if len(operands) > 2 {
+		fi.src2 |= uint64(operands[2]) << 32
+	}
+
+	imp.body[v.ip] = fi
+	imp.pushValues(v.ip, uint32(len(imp.stack)), 0, nresults)
+	return true
+}
+
 func (imp *fimporter) emitConditionalBranch(labelidx int) {
 	condition := imp.stack[len(imp.stack)-1]
 	imp.stack = imp.stack[:len(imp.stack)-1]
@@ -661,13 +754,15 @@ func (imp *fimporter) emitLocalSet(localidx uint32) {
 }
 
 func (imp *fimporter) emitLoad(instr *code.Instruction) {
-	address := imp.popAddressable()
+	address := imp.stack[len(imp.stack)-1]
+	imp.stack = imp.stack[:len(imp.stack)-1]
+	imp.emitAddressable(&address)
 
 	fi := finstruction{
 		opcode: opcode(instr.Opcode),
 		flags:  ifSrc1Frame,
 		dest:   uint32(imp.locals + len(imp.stack)),
-		src1:   address,
+		src1:   address.addr,
 		src2:   uint64(instr.Offset()),
 	}
 
@@ -676,7 +771,9 @@ func (imp *fimporter) emitLoad(instr *code.Instruction) {
 		fi.opcode |= 0x0200
 	}
 
-	imp.emit(&fi, 1)
+	if !imp.fuse(&fi, &address, 1, 1) {
+		imp.emit(&fi, 1)
+	}
 }
 
 func (imp *fimporter) emitStore(instr *code.Instruction) {
@@ -708,7 +805,9 @@ func (imp *fimporter) emitStore(instr *code.Instruction) {
 		imp.emitAddressable(&value)
 	}
 
-	imp.emit(&fi, 0)
+	if !imp.fuse(&fi, &value, 2, 0) && !imp.fuse(&fi, &address, 1, 0) {
+		imp.emit(&fi, 0)
+	}
 }
 
 func (imp *fimporter) pushConst(v uint64) {
@@ -729,6 +828,12 @@ func (imp *fimporter) emitBinOp(instr *code.Instruction, commutative bool) {
 		rhs, lhs = lhs, rhs
 	}
 
+	// If the operation is commutative and the rhs was produced by the previous instruction,
+	// move it to the lhs. Superinstructions more commonly fuse a producer with the lhs.
+	if commutative && rhs.flags == vfFrame && rhs.ip == len(imp.body)-1 {
+		rhs, lhs = lhs, rhs
+	}
+
 	// Ensure the LHS is addressable.
 	imp.emitAddressable(&lhs)
 
@@ -743,7 +848,9 @@ func (imp *fimporter) emitBinOp(instr *code.Instruction, commutative bool) {
 	if rhs.flags&vfConst != 0 {
 		fi.src2 = rhs.immediate
 		fi.opcode |= 0x0200
-		imp.emit(&fi, 1)
+		if !imp.fuse(&fi, &lhs, 1, 1) {
+			imp.emit(&fi, 1)
+		}
 		return
 	}
 
@@ -751,7 +858,9 @@ func (imp *fimporter) emitBinOp(instr *code.Instruction, commutative bool) {
 	fi.src2 = uint64(rhs.addr)
 	fi.flags |= ifSrc2Frame
 
-	imp.emit(&fi, 1)
+	if !imp.fuse(&fi, &rhs, 2, 1) && !imp.fuse(&fi, &lhs, 1, 1) {
+		imp.emit(&fi, 1)
+	}
 }
 
 func (imp *fimporter) emitUnOp(instr *code.Instruction) {
@@ -760,11 +869,17 @@ func (imp *fimporter) emitUnOp(instr *code.Instruction) {
 
 func (imp *fimporter) emitUnOpF(fi *finstruction) {
 	// 1 operand
-	fi.src1 = imp.popAddressable()
+	operand := imp.stack[len(imp.stack)-1]
+	imp.stack = imp.stack[:len(imp.stack)-1]
+	imp.emitAddressable(&operand)
+
+	fi.src1 = operand.addr
 	fi.dest = uint32(imp.locals + len(imp.stack))
 	fi.flags = ifSrc1Frame
 
-	imp.emit(fi, 1)
+	if !imp.fuse(fi, &operand, 1, 1) {
+		imp.emit(fi, 1)
+	}
 }
 
 func (imp *fimporter) emitInstruction(instr *code.Instruction) {
@@ -988,18 +1103,18 @@ func (imp *fimporter) emitInstruction(instr *code.Instruction) {
 
 	case code.OpI32Clz, code.OpI32Ctz, code.OpI32Popcnt:
 		imp.emitUnOp(instr)
-	case code.OpI32Add, code.OpI32Mul:
+	case code.OpI32Add, code.OpI32Mul, code.OpI32And, code.OpI32Or, code.OpI32Xor:
 		imp.emitBinOp(instr, true)
-	case code.OpI32Sub, code.OpI32DivS, code.OpI32DivU, code.OpI32RemS, code.OpI32RemU, code.OpI32And, code.OpI32Or, code.OpI32Xor, code.OpI32Shl, code.OpI32ShrS, code.OpI32ShrU:
+	case code.OpI32Sub, code.OpI32DivS, code.OpI32DivU, code.OpI32RemS, code.OpI32RemU, code.OpI32Shl, code.OpI32ShrS, code.OpI32ShrU:
 		imp.emitBinOp(instr, false)
 	case code.OpI32Rotl, code.OpI32Rotr:
 		imp.emitBinOp(instr, false)
 
 	case code.OpI64Clz, code.OpI64Ctz, code.OpI64Popcnt:
 		imp.emitUnOp(instr)
-	case code.OpI64Add, code.OpI64Mul:
+	case code.OpI64Add, code.OpI64Mul, code.OpI64And, code.OpI64Or, code.OpI64Xor:
 		imp.emitBinOp(instr, true)
-	case code.OpI64Sub, code.OpI64DivS, code.OpI64DivU, code.OpI64RemS, code.OpI64RemU, code.OpI64And, code.OpI64Or, code.OpI64Xor, code.OpI64Shl, code.OpI64ShrS, code.OpI64ShrU:
+	case code.OpI64Sub, code.OpI64DivS, code.OpI64DivU, code.OpI64RemS, code.OpI64RemU, code.OpI64Shl, code.OpI64ShrS, code.OpI64ShrU:
 		imp.emitBinOp(instr, false)
 	case code.OpI64Rotl, code.OpI64Rotr:
 		imp.emitBinOp(instr, false)
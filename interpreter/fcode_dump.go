@@ -141,10 +141,37 @@ func (d *dumper) dumpUnOp(ip int, fi *finstruction, op string) {
 	}
 }
 
+func (d *dumper) dumpSuperinstruction(ip int, fi *finstruction) {
+	info, ndef := fusibleInstructions[fi.opcode], 0
+	if info.result {
+		ndef = 1
+	}
+	d.dumpOp(ip, fi, opcodeName(fi.opcode), ndef)
+
+	for i, operand := range fusionOperands(fi) {
+		if i > 0 {
+			fmt.Fprintf(d.w, ",")
+		}
+		if info.immediates&(1<<i) != 0 {
+			fmt.Fprintf(d.w, " 0x%08x", operand)
+		} else {
+			fmt.Fprintf(d.w, " v%v", operand)
+		}
+	}
+}
+
 func (d *dumper) dumpInstruction(ip int, fi *finstruction) {
+	if fi.opcode >= fopSuper {
+		d.dumpSuperinstruction(ip, fi)
+		return
+	}
+
 	switch fi.opcode & 0x1ff {
 	case fopUnreachable:
 		d.dumpOp(ip, fi, "unreachable", 0)
+	case fopLoop:
+		d.dumpOp(ip, fi, "safepoint", 0)
+		fmt.Fprintf(d.w, " @%v", fi.src1)
 	case fopIf:
 		d.dumpOp(ip, fi, "if", 0)
 		fmt.Fprintf(d.w, " v%v", fi.src1)
@@ -1,3 +1,5 @@
+// Code generated by gen-fcode. DO NOT EDIT.
+
 //go:build memtrace || js || plan9 || windows || armbe || arm64be || ppc || ppc64 || mips || mips64 || s390x
 // +build memtrace js plan9 windows armbe arm64be ppc ppc64 mips mips64 s390x
 
@@ -53,6 +55,13 @@ func (f *frame) runFCode(fn *function) {
 		case fopNop:
 			// no-op
 
+		case fopLoop:
+			// A loop safepoint. These are only emitted for debugged functions.
+			if f.safepoint(fn) {
+				f.leaveFCode(fn, int(instr.src1))
+				return
+			}
+
 		case fopIf:
 			if !frame.bool(instr.src1) {
 				l := &labels[instr.Labelidx()]
@@ -1308,6 +1317,110 @@ func (f *frame) runFCode(fn *function) {
 			f.module.mem0.PutUint16At(0, uint32(frame[instr.dest]))
 		case fopI64Store32ZI:
 			f.module.mem0.PutUint32At(0, uint32(frame[instr.dest]))
+
+		case fopI32AddThenI32AddI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(t0) + int32(instr.Src3()))
+
+		case fopI32AddThenI32Load8UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(f.module.mem0.ByteAt(uint32(t0))))
+
+		case fopI32AddIThenI32Load8UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(f.module.mem0.ByteAt(uint32(t0))))
+
+		case fopI32Load8UIThenI32ShlI:
+			t0 := uint64(int32(f.module.mem0.ByteAt(uint32(frame[instr.src1]))))
+			frame[instr.dest] = uint64(int32(t0) << (int32(instr.Src2()) & 31))
+
+		case fopI32AddIThenI32LoadI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(f.module.mem0.Uint32At(uint32(t0)))
+
+		case fopI32ShlIThenI32AddI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) + int32(instr.Src3()))
+
+		case fopI32ShlIThenI32AddIThenI32LoadI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			t1 := uint64(int32(t0) + int32(instr.Src3()))
+			frame[instr.dest] = uint64(f.module.mem0.Uint32At(uint32(t1)))
+
+		case fopI32ShlIThenI32Add:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) + int32(frame[instr.Src3()]))
+
+		case fopI32AddIThenI32LoadIThenI32Xor:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(f.module.mem0.Uint32At(uint32(t0)))
+			frame[instr.dest] = uint64(int32(t1) ^ int32(frame[instr.Src3()]))
+
+		case fopI32LoadIThenI32Xor:
+			t0 := uint64(f.module.mem0.Uint32At(uint32(frame[instr.src1])))
+			frame[instr.dest] = uint64(int32(t0) ^ int32(frame[instr.Src2()]))
+
+		case fopI32AddIThenI32Load8UIThenI32ShlI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(int32(f.module.mem0.ByteAt(uint32(t0))))
+			frame[instr.dest] = uint64(int32(t1) << (int32(instr.Src3()) & 31))
+
+		case fopI32Load8UIThenI32ShlIThenI32AddI:
+			t0 := uint64(int32(f.module.mem0.ByteAt(uint32(frame[instr.src1]))))
+			t1 := uint64(int32(t0) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t1) + int32(instr.Src3()))
+
+		case fopI32AddIThenI32Load16UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(f.module.mem0.Uint16At(uint32(t0))))
+
+		case fopI32LoadIThenI32Add:
+			t0 := uint64(f.module.mem0.Uint32At(uint32(frame[instr.src1])))
+			frame[instr.dest] = uint64(int32(t0) + int32(frame[instr.Src2()]))
+
+		case fopI32AndIThenI32ShlI:
+			t0 := uint64(int32(frame[instr.src1]) & int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(t0) << (int32(instr.Src3()) & 31))
+
+		case fopI32AddThenI32AddIThenI32Load16UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			t1 := uint64(int32(t0) + int32(instr.Src3()))
+			frame[instr.dest] = uint64(int32(f.module.mem0.Uint16At(uint32(t1))))
+
+		case fopI32LoadIThenI32AddThenI32AddI:
+			t0 := uint64(f.module.mem0.Uint32At(uint32(frame[instr.src1])))
+			t1 := uint64(int32(t0) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(t1) + int32(instr.Src3()))
+
+		case fopI32Load16UIThenI32Store16IRhs:
+			t0 := uint64(int32(f.module.mem0.Uint16At(uint32(frame[instr.src1]))))
+			f.module.mem0.PutUint16At(uint16(t0), uint32(frame[instr.Src2()]))
+
+		case fopI32AddIThenI32Store16I:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			f.module.mem0.PutUint16At(uint16(frame[instr.Src3()]), uint32(t0))
+
+		case fopI32ShlIThenI32AndI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) & int32(instr.Src3()))
+
+		case fopI32AddIThenI32Load16UIThenI32Store16IRhs:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(int32(f.module.mem0.Uint16At(uint32(t0))))
+			f.module.mem0.PutUint16At(uint16(t1), uint32(frame[instr.Src3()]))
+
+		case fopI32AndIThenI32Xor:
+			t0 := uint64(int32(frame[instr.src1]) & int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(t0) ^ int32(frame[instr.Src3()]))
+
+		case fopI32Load8UIThenI32Store8IRhs:
+			t0 := uint64(int32(f.module.mem0.ByteAt(uint32(frame[instr.src1]))))
+			f.module.mem0.PutByteAt(byte(t0), uint32(frame[instr.Src2()]))
+
+		case fopI32AddThenI32Load8UIThenI32Store8IRhs:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			t1 := uint64(int32(f.module.mem0.ByteAt(uint32(t0))))
+			f.module.mem0.PutByteAt(byte(t1), uint32(frame[instr.Src3()]))
 		}
 
 		ip++
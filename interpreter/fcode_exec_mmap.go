@@ -1,4 +1,6 @@
-//go:build (!memtrace && !js && !plan9 && !windows && !armbe && !arm64be && !ppc && !ppc64 && !mips && !mips64 && !s390x)
+// Code generated by gen-fcode. DO NOT EDIT.
+
+//go:build !memtrace && !js && !plan9 && !windows && !armbe && !arm64be && !ppc && !ppc64 && !mips && !mips64 && !s390x
 // +build !memtrace,!js,!plan9,!windows,!armbe,!arm64be,!ppc,!ppc64,!mips,!mips64,!s390x
 
 package interpreter
@@ -55,6 +57,13 @@ func (f *frame) runFCode(fn *function) {
 		case fopNop:
 			// no-op
 
+		case fopLoop:
+			// A loop safepoint. These are only emitted for debugged functions.
+			if f.safepoint(fn) {
+				f.leaveFCode(fn, int(instr.src1))
+				return
+			}
+
 		case fopIf:
 			if !frame.bool(instr.src1) {
 				l := &labels[instr.Labelidx()]
@@ -814,7 +823,7 @@ func (f *frame) runFCode(fn *function) {
 			frame[instr.dest] = *(*uint64)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))
 
 		case fopI32Load8SI:
-			frame[instr.dest] = uint64(int32(int8(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1])))))))
+			frame[instr.dest] = uint64(int32(*(*int8)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
 		case fopI32Load8UI:
 			frame[instr.dest] = uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
 		case fopI32Load16SI:
@@ -823,7 +832,7 @@ func (f *frame) runFCode(fn *function) {
 			frame[instr.dest] = uint64(int32(*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
 
 		case fopI64Load8SI:
-			frame[instr.dest] = uint64(int64(int8(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1])))))))
+			frame[instr.dest] = uint64(int64(*(*int8)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
 		case fopI64Load8UI:
 			frame[instr.dest] = uint64(int64(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
 		case fopI64Load16SI:
@@ -1310,6 +1319,110 @@ func (f *frame) runFCode(fn *function) {
 			*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.dest])))) = 0
 		case fopI64Store32ZI:
 			*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.dest])))) = 0
+
+		case fopI32AddThenI32AddI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(t0) + int32(instr.Src3()))
+
+		case fopI32AddThenI32Load8UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+
+		case fopI32AddIThenI32Load8UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+
+		case fopI32Load8UIThenI32ShlI:
+			t0 := uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
+			frame[instr.dest] = uint64(int32(t0) << (int32(instr.Src2()) & 31))
+
+		case fopI32AddIThenI32LoadI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(t0)))))
+
+		case fopI32ShlIThenI32AddI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) + int32(instr.Src3()))
+
+		case fopI32ShlIThenI32AddIThenI32LoadI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			t1 := uint64(int32(t0) + int32(instr.Src3()))
+			frame[instr.dest] = uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(t1)))))
+
+		case fopI32ShlIThenI32Add:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) + int32(frame[instr.Src3()]))
+
+		case fopI32AddIThenI32LoadIThenI32Xor:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(t0)))))
+			frame[instr.dest] = uint64(int32(t1) ^ int32(frame[instr.Src3()]))
+
+		case fopI32LoadIThenI32Xor:
+			t0 := uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1])))))
+			frame[instr.dest] = uint64(int32(t0) ^ int32(frame[instr.Src2()]))
+
+		case fopI32AddIThenI32Load8UIThenI32ShlI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+			frame[instr.dest] = uint64(int32(t1) << (int32(instr.Src3()) & 31))
+
+		case fopI32Load8UIThenI32ShlIThenI32AddI:
+			t0 := uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
+			t1 := uint64(int32(t0) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t1) + int32(instr.Src3()))
+
+		case fopI32AddIThenI32Load16UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+
+		case fopI32LoadIThenI32Add:
+			t0 := uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1])))))
+			frame[instr.dest] = uint64(int32(t0) + int32(frame[instr.Src2()]))
+
+		case fopI32AndIThenI32ShlI:
+			t0 := uint64(int32(frame[instr.src1]) & int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(t0) << (int32(instr.Src3()) & 31))
+
+		case fopI32AddThenI32AddIThenI32Load16UI:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			t1 := uint64(int32(t0) + int32(instr.Src3()))
+			frame[instr.dest] = uint64(int32(*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(t1))))))
+
+		case fopI32LoadIThenI32AddThenI32AddI:
+			t0 := uint64(*(*uint32)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1])))))
+			t1 := uint64(int32(t0) + int32(frame[instr.Src2()]))
+			frame[instr.dest] = uint64(int32(t1) + int32(instr.Src3()))
+
+		case fopI32Load16UIThenI32Store16IRhs:
+			t0 := uint64(int32(*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
+			*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.Src2()])))) = uint16(t0)
+
+		case fopI32AddIThenI32Store16I:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(t0)))) = uint16(frame[instr.Src3()])
+
+		case fopI32ShlIThenI32AndI:
+			t0 := uint64(int32(frame[instr.src1]) << (int32(instr.Src2()) & 31))
+			frame[instr.dest] = uint64(int32(t0) & int32(instr.Src3()))
+
+		case fopI32AddIThenI32Load16UIThenI32Store16IRhs:
+			t0 := uint64(int32(frame[instr.src1]) + int32(instr.Src2()))
+			t1 := uint64(int32(*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+			*(*uint16)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.Src3()])))) = uint16(t1)
+
+		case fopI32AndIThenI32Xor:
+			t0 := uint64(int32(frame[instr.src1]) & int32(instr.Src2()))
+			frame[instr.dest] = uint64(int32(t0) ^ int32(frame[instr.Src3()]))
+
+		case fopI32Load8UIThenI32Store8IRhs:
+			t0 := uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.src1]))))))
+			*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.Src2()])))) = byte(t0)
+
+		case fopI32AddThenI32Load8UIThenI32Store8IRhs:
+			t0 := uint64(int32(frame[instr.src1]) + int32(frame[instr.Src2()]))
+			t1 := uint64(int32(*(*byte)(unsafe.Pointer(mem + uintptr(uint32(t0))))))
+			*(*byte)(unsafe.Pointer(mem + uintptr(uint32(frame[instr.Src3()])))) = byte(t1)
 		}
 
 		ip++
@@ -1,3 +1,5 @@
+// Code generated by gen-fcode. DO NOT EDIT.
+
 package interpreter
 
 import "github.com/pgavlin/warp/wasm/code"
@@ -414,4 +416,544 @@ const (
 	fopI64Store8ZI  = 0x0200 | fopI64Store8Z
 	fopI64Store16ZI = 0x0200 | fopI64Store16Z
 	fopI64Store32ZI = 0x0200 | fopI64Store32Z
+
+	// fopSuper is the first superinstruction opcode.
+	fopSuper opcode = 0x0400
+)
+
+// Superinstructions. Each superinstruction fuses a sequence of instructions in which each instruction consumes the
+// result of its predecessor.
+const (
+	fopI32AddThenI32AddI = fopSuper + iota
+	fopI32AddThenI32Load8UI
+	fopI32AddIThenI32Load8UI
+	fopI32Load8UIThenI32ShlI
+	fopI32AddIThenI32LoadI
+	fopI32ShlIThenI32AddI
+	fopI32ShlIThenI32AddIThenI32LoadI
+	fopI32ShlIThenI32Add
+	fopI32AddIThenI32LoadIThenI32Xor
+	fopI32LoadIThenI32Xor
+	fopI32AddIThenI32Load8UIThenI32ShlI
+	fopI32Load8UIThenI32ShlIThenI32AddI
+	fopI32AddIThenI32Load16UI
+	fopI32LoadIThenI32Add
+	fopI32AndIThenI32ShlI
+	fopI32AddThenI32AddIThenI32Load16UI
+	fopI32LoadIThenI32AddThenI32AddI
+	fopI32Load16UIThenI32Store16IRhs
+	fopI32AddIThenI32Store16I
+	fopI32ShlIThenI32AndI
+	fopI32AddIThenI32Load16UIThenI32Store16IRhs
+	fopI32AndIThenI32Xor
+	fopI32Load8UIThenI32Store8IRhs
+	fopI32AddThenI32Load8UIThenI32Store8IRhs
 )
+
+// fusibleInstructions describes the instructions that may be fused into superinstructions.
+var fusibleInstructions = map[opcode]fusibleInstruction{
+	fopI32Add:                           {operands: 2, result: true},
+	fopI32AddI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI32And:                           {operands: 2, result: true},
+	fopI32AndI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI32Load16SI:                      {operands: 1, result: true},
+	fopI32Load16UI:                      {operands: 1, result: true},
+	fopI32Load8SI:                       {operands: 1, result: true},
+	fopI32Load8UI:                       {operands: 1, result: true},
+	fopI32LoadI:                         {operands: 1, result: true},
+	fopI32Mul:                           {operands: 2, result: true},
+	fopI32MulI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI32Or:                            {operands: 2, result: true},
+	fopI32OrI:                           {operands: 2, immediates: 0b10, result: true},
+	fopI32Shl:                           {operands: 2, result: true},
+	fopI32ShlI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI32ShrS:                          {operands: 2, result: true},
+	fopI32ShrSI:                         {operands: 2, immediates: 0b10, result: true},
+	fopI32ShrU:                          {operands: 2, result: true},
+	fopI32ShrUI:                         {operands: 2, immediates: 0b10, result: true},
+	fopI32Store16I:                      {operands: 2, store: true},
+	fopI32Store8I:                       {operands: 2, store: true},
+	fopI32StoreI:                        {operands: 2, store: true},
+	fopI32Sub:                           {operands: 2, result: true},
+	fopI32SubI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI32WrapI64:                       {operands: 1, result: true},
+	fopI32Xor:                           {operands: 2, result: true},
+	fopI32XorI:                          {operands: 2, immediates: 0b10, result: true},
+	fopI64Add:                           {operands: 2, result: true},
+	fopI64And:                           {operands: 2, result: true},
+	fopI64ExtendI32S:                    {operands: 1, result: true},
+	fopI64ExtendI32U:                    {operands: 1, result: true},
+	fopI64Load16SI:                      {operands: 1, result: true},
+	fopI64Load16UI:                      {operands: 1, result: true},
+	fopI64Load32SI:                      {operands: 1, result: true},
+	fopI64Load32UI:                      {operands: 1, result: true},
+	fopI64Load8SI:                       {operands: 1, result: true},
+	fopI64Load8UI:                       {operands: 1, result: true},
+	fopI64LoadI:                         {operands: 1, result: true},
+	fopI64Mul:                           {operands: 2, result: true},
+	fopI64Or:                            {operands: 2, result: true},
+	fopI64Shl:                           {operands: 2, result: true},
+	fopI64ShrS:                          {operands: 2, result: true},
+	fopI64ShrU:                          {operands: 2, result: true},
+	fopI64Store16I:                      {operands: 2, store: true},
+	fopI64Store32I:                      {operands: 2, store: true},
+	fopI64Store8I:                       {operands: 2, store: true},
+	fopI64StoreI:                        {operands: 2, store: true},
+	fopI64Sub:                           {operands: 2, result: true},
+	fopI64Xor:                           {operands: 2, result: true},
+	fopI32AddThenI32AddI:                {operands: 3, immediates: 0b100, result: true},
+	fopI32AddThenI32Load8UI:             {operands: 2, result: true},
+	fopI32AddIThenI32Load8UI:            {operands: 2, immediates: 0b10, result: true},
+	fopI32Load8UIThenI32ShlI:            {operands: 2, immediates: 0b10, result: true},
+	fopI32AddIThenI32LoadI:              {operands: 2, immediates: 0b10, result: true},
+	fopI32ShlIThenI32AddI:               {operands: 3, immediates: 0b110, result: true},
+	fopI32ShlIThenI32AddIThenI32LoadI:   {operands: 3, immediates: 0b110, result: true},
+	fopI32ShlIThenI32Add:                {operands: 3, immediates: 0b10, result: true},
+	fopI32AddIThenI32LoadIThenI32Xor:    {operands: 3, immediates: 0b10, result: true},
+	fopI32LoadIThenI32Xor:               {operands: 2, result: true},
+	fopI32AddIThenI32Load8UIThenI32ShlI: {operands: 3, immediates: 0b110, result: true},
+	fopI32Load8UIThenI32ShlIThenI32AddI: {operands: 3, immediates: 0b110, result: true},
+	fopI32AddIThenI32Load16UI:           {operands: 2, immediates: 0b10, result: true},
+	fopI32LoadIThenI32Add:               {operands: 2, result: true},
+	fopI32AndIThenI32ShlI:               {operands: 3, immediates: 0b110, result: true},
+	fopI32AddThenI32AddIThenI32Load16UI: {operands: 3, immediates: 0b100, result: true},
+	fopI32LoadIThenI32AddThenI32AddI:    {operands: 3, immediates: 0b100, result: true},
+	fopI32Load16UIThenI32Store16IRhs:    {operands: 2},
+	fopI32AddIThenI32Store16I:           {operands: 3, immediates: 0b10},
+	fopI32ShlIThenI32AndI:               {operands: 3, immediates: 0b110, result: true},
+	fopI32AddIThenI32Load16UIThenI32Store16IRhs: {operands: 3, immediates: 0b10},
+	fopI32AndIThenI32Xor:                        {operands: 3, immediates: 0b10, result: true},
+	fopI32Load8UIThenI32Store8IRhs:              {operands: 2},
+	fopI32AddThenI32Load8UIThenI32Store8IRhs:    {operands: 3},
+}
+
+// superinstructions maps each fused sequence of instructions to its superinstruction.
+var superinstructions = map[fusion]opcode{
+	{fopI32Add, fopI32AddI, 1}:                     fopI32AddThenI32AddI,
+	{fopI32Add, fopI32Load8UI, 1}:                  fopI32AddThenI32Load8UI,
+	{fopI32AddI, fopI32Load8UI, 1}:                 fopI32AddIThenI32Load8UI,
+	{fopI32Load8UI, fopI32ShlI, 1}:                 fopI32Load8UIThenI32ShlI,
+	{fopI32AddI, fopI32LoadI, 1}:                   fopI32AddIThenI32LoadI,
+	{fopI32ShlI, fopI32AddI, 1}:                    fopI32ShlIThenI32AddI,
+	{fopI32ShlIThenI32AddI, fopI32LoadI, 1}:        fopI32ShlIThenI32AddIThenI32LoadI,
+	{fopI32ShlI, fopI32Add, 1}:                     fopI32ShlIThenI32Add,
+	{fopI32AddIThenI32LoadI, fopI32Xor, 1}:         fopI32AddIThenI32LoadIThenI32Xor,
+	{fopI32LoadI, fopI32Xor, 1}:                    fopI32LoadIThenI32Xor,
+	{fopI32AddIThenI32Load8UI, fopI32ShlI, 1}:      fopI32AddIThenI32Load8UIThenI32ShlI,
+	{fopI32Load8UIThenI32ShlI, fopI32AddI, 1}:      fopI32Load8UIThenI32ShlIThenI32AddI,
+	{fopI32AddI, fopI32Load16UI, 1}:                fopI32AddIThenI32Load16UI,
+	{fopI32LoadI, fopI32Add, 1}:                    fopI32LoadIThenI32Add,
+	{fopI32AndI, fopI32ShlI, 1}:                    fopI32AndIThenI32ShlI,
+	{fopI32AddThenI32AddI, fopI32Load16UI, 1}:      fopI32AddThenI32AddIThenI32Load16UI,
+	{fopI32LoadIThenI32Add, fopI32AddI, 1}:         fopI32LoadIThenI32AddThenI32AddI,
+	{fopI32Load16UI, fopI32Store16I, 2}:            fopI32Load16UIThenI32Store16IRhs,
+	{fopI32AddI, fopI32Store16I, 1}:                fopI32AddIThenI32Store16I,
+	{fopI32ShlI, fopI32AndI, 1}:                    fopI32ShlIThenI32AndI,
+	{fopI32AddIThenI32Load16UI, fopI32Store16I, 2}: fopI32AddIThenI32Load16UIThenI32Store16IRhs,
+	{fopI32AndI, fopI32Xor, 1}:                     fopI32AndIThenI32Xor,
+	{fopI32Load8UI, fopI32Store8I, 2}:              fopI32Load8UIThenI32Store8IRhs,
+	{fopI32AddThenI32Load8UI, fopI32Store8I, 2}:    fopI32AddThenI32Load8UIThenI32Store8IRhs,
+}
+
+// opcodeNames maps opcodes to their names.
+var opcodeNames = map[opcode]string{
+	fopUnreachable:                      "Unreachable",
+	fopNop:                              "Nop",
+	fopBlock:                            "Block",
+	fopLoop:                             "Loop",
+	fopIf:                               "If",
+	fopElse:                             "Else",
+	fopEnd:                              "End",
+	fopBr:                               "Br",
+	fopBrIf:                             "BrIf",
+	fopBrTable:                          "BrTable",
+	fopReturn:                           "Return",
+	fopCall:                             "Call",
+	fopCallIndirect:                     "CallIndirect",
+	fopDrop:                             "Drop",
+	fopSelect:                           "Select",
+	fopLocalGet:                         "LocalGet",
+	fopLocalSet:                         "LocalSet",
+	fopLocalTee:                         "LocalTee",
+	fopGlobalGet:                        "GlobalGet",
+	fopGlobalSet:                        "GlobalSet",
+	fopI32Load:                          "I32Load",
+	fopI64Load:                          "I64Load",
+	fopF32Load:                          "F32Load",
+	fopF64Load:                          "F64Load",
+	fopI32Load8S:                        "I32Load8S",
+	fopI32Load8U:                        "I32Load8U",
+	fopI32Load16S:                       "I32Load16S",
+	fopI32Load16U:                       "I32Load16U",
+	fopI64Load8S:                        "I64Load8S",
+	fopI64Load8U:                        "I64Load8U",
+	fopI64Load16S:                       "I64Load16S",
+	fopI64Load16U:                       "I64Load16U",
+	fopI64Load32S:                       "I64Load32S",
+	fopI64Load32U:                       "I64Load32U",
+	fopI32Store:                         "I32Store",
+	fopI64Store:                         "I64Store",
+	fopF32Store:                         "F32Store",
+	fopF64Store:                         "F64Store",
+	fopI32Store8:                        "I32Store8",
+	fopI32Store16:                       "I32Store16",
+	fopI64Store8:                        "I64Store8",
+	fopI64Store16:                       "I64Store16",
+	fopI64Store32:                       "I64Store32",
+	fopMemorySize:                       "MemorySize",
+	fopMemoryGrow:                       "MemoryGrow",
+	fopI32Const:                         "I32Const",
+	fopI64Const:                         "I64Const",
+	fopF32Const:                         "F32Const",
+	fopF64Const:                         "F64Const",
+	fopI32Eqz:                           "I32Eqz",
+	fopI32Eq:                            "I32Eq",
+	fopI32Ne:                            "I32Ne",
+	fopI32LtS:                           "I32LtS",
+	fopI32LtU:                           "I32LtU",
+	fopI32GtS:                           "I32GtS",
+	fopI32GtU:                           "I32GtU",
+	fopI32LeS:                           "I32LeS",
+	fopI32LeU:                           "I32LeU",
+	fopI32GeS:                           "I32GeS",
+	fopI32GeU:                           "I32GeU",
+	fopI64Eqz:                           "I64Eqz",
+	fopI64Eq:                            "I64Eq",
+	fopI64Ne:                            "I64Ne",
+	fopI64LtS:                           "I64LtS",
+	fopI64LtU:                           "I64LtU",
+	fopI64GtS:                           "I64GtS",
+	fopI64GtU:                           "I64GtU",
+	fopI64LeS:                           "I64LeS",
+	fopI64LeU:                           "I64LeU",
+	fopI64GeS:                           "I64GeS",
+	fopI64GeU:                           "I64GeU",
+	fopF32Eq:                            "F32Eq",
+	fopF32Ne:                            "F32Ne",
+	fopF32Lt:                            "F32Lt",
+	fopF32Gt:                            "F32Gt",
+	fopF32Le:                            "F32Le",
+	fopF32Ge:                            "F32Ge",
+	fopF64Eq:                            "F64Eq",
+	fopF64Ne:                            "F64Ne",
+	fopF64Lt:                            "F64Lt",
+	fopF64Gt:                            "F64Gt",
+	fopF64Le:                            "F64Le",
+	fopF64Ge:                            "F64Ge",
+	fopI32Clz:                           "I32Clz",
+	fopI32Ctz:                           "I32Ctz",
+	fopI32Popcnt:                        "I32Popcnt",
+	fopI32Add:                           "I32Add",
+	fopI32Sub:                           "I32Sub",
+	fopI32Mul:                           "I32Mul",
+	fopI32DivS:                          "I32DivS",
+	fopI32DivU:                          "I32DivU",
+	fopI32RemS:                          "I32RemS",
+	fopI32RemU:                          "I32RemU",
+	fopI32And:                           "I32And",
+	fopI32Or:                            "I32Or",
+	fopI32Xor:                           "I32Xor",
+	fopI32Shl:                           "I32Shl",
+	fopI32ShrS:                          "I32ShrS",
+	fopI32ShrU:                          "I32ShrU",
+	fopI32Rotl:                          "I32Rotl",
+	fopI32Rotr:                          "I32Rotr",
+	fopI64Clz:                           "I64Clz",
+	fopI64Ctz:                           "I64Ctz",
+	fopI64Popcnt:                        "I64Popcnt",
+	fopI64Add:                           "I64Add",
+	fopI64Sub:                           "I64Sub",
+	fopI64Mul:                           "I64Mul",
+	fopI64DivS:                          "I64DivS",
+	fopI64DivU:                          "I64DivU",
+	fopI64RemS:                          "I64RemS",
+	fopI64RemU:                          "I64RemU",
+	fopI64And:                           "I64And",
+	fopI64Or:                            "I64Or",
+	fopI64Xor:                           "I64Xor",
+	fopI64Shl:                           "I64Shl",
+	fopI64ShrS:                          "I64ShrS",
+	fopI64ShrU:                          "I64ShrU",
+	fopI64Rotl:                          "I64Rotl",
+	fopI64Rotr:                          "I64Rotr",
+	fopF32Abs:                           "F32Abs",
+	fopF32Neg:                           "F32Neg",
+	fopF32Ceil:                          "F32Ceil",
+	fopF32Floor:                         "F32Floor",
+	fopF32Trunc:                         "F32Trunc",
+	fopF32Nearest:                       "F32Nearest",
+	fopF32Sqrt:                          "F32Sqrt",
+	fopF32Add:                           "F32Add",
+	fopF32Sub:                           "F32Sub",
+	fopF32Mul:                           "F32Mul",
+	fopF32Div:                           "F32Div",
+	fopF32Min:                           "F32Min",
+	fopF32Max:                           "F32Max",
+	fopF32Copysign:                      "F32Copysign",
+	fopF64Abs:                           "F64Abs",
+	fopF64Neg:                           "F64Neg",
+	fopF64Ceil:                          "F64Ceil",
+	fopF64Floor:                         "F64Floor",
+	fopF64Trunc:                         "F64Trunc",
+	fopF64Nearest:                       "F64Nearest",
+	fopF64Sqrt:                          "F64Sqrt",
+	fopF64Add:                           "F64Add",
+	fopF64Sub:                           "F64Sub",
+	fopF64Mul:                           "F64Mul",
+	fopF64Div:                           "F64Div",
+	fopF64Min:                           "F64Min",
+	fopF64Max:                           "F64Max",
+	fopF64Copysign:                      "F64Copysign",
+	fopI32WrapI64:                       "I32WrapI64",
+	fopI32TruncF32S:                     "I32TruncF32S",
+	fopI32TruncF32U:                     "I32TruncF32U",
+	fopI32TruncF64S:                     "I32TruncF64S",
+	fopI32TruncF64U:                     "I32TruncF64U",
+	fopI64ExtendI32S:                    "I64ExtendI32S",
+	fopI64ExtendI32U:                    "I64ExtendI32U",
+	fopI64TruncF32S:                     "I64TruncF32S",
+	fopI64TruncF32U:                     "I64TruncF32U",
+	fopI64TruncF64S:                     "I64TruncF64S",
+	fopI64TruncF64U:                     "I64TruncF64U",
+	fopF32ConvertI32S:                   "F32ConvertI32S",
+	fopF32ConvertI32U:                   "F32ConvertI32U",
+	fopF32ConvertI64S:                   "F32ConvertI64S",
+	fopF32ConvertI64U:                   "F32ConvertI64U",
+	fopF32DemoteF64:                     "F32DemoteF64",
+	fopF64ConvertI32S:                   "F64ConvertI32S",
+	fopF64ConvertI32U:                   "F64ConvertI32U",
+	fopF64ConvertI64S:                   "F64ConvertI64S",
+	fopF64ConvertI64U:                   "F64ConvertI64U",
+	fopF64PromoteF32:                    "F64PromoteF32",
+	fopI32ReinterpretF32:                "I32ReinterpretF32",
+	fopI64ReinterpretF64:                "I64ReinterpretF64",
+	fopF32ReinterpretI32:                "F32ReinterpretI32",
+	fopF64ReinterpretI64:                "F64ReinterpretI64",
+	fopI32Extend8S:                      "I32Extend8S",
+	fopI32Extend16S:                     "I32Extend16S",
+	fopI64Extend8S:                      "I64Extend8S",
+	fopI64Extend16S:                     "I64Extend16S",
+	fopI64Extend32S:                     "I64Extend32S",
+	fopI32TruncSatF32S:                  "I32TruncSatF32S",
+	fopI32TruncSatF32U:                  "I32TruncSatF32U",
+	fopI32TruncSatF64S:                  "I32TruncSatF64S",
+	fopI32TruncSatF64U:                  "I32TruncSatF64U",
+	fopI64TruncSatF32S:                  "I64TruncSatF32S",
+	fopI64TruncSatF32U:                  "I64TruncSatF32U",
+	fopI64TruncSatF64S:                  "I64TruncSatF64S",
+	fopI64TruncSatF64U:                  "I64TruncSatF64U",
+	fopBrL:                              "BrL",
+	fopBrIfL:                            "BrIfL",
+	fopBrTableL:                         "BrTableL",
+	fopBrIfI32Eqz:                       "BrIfI32Eqz",
+	fopBrIfI32Eq:                        "BrIfI32Eq",
+	fopBrIfI32Ne:                        "BrIfI32Ne",
+	fopBrIfI32LtS:                       "BrIfI32LtS",
+	fopBrIfI32LtU:                       "BrIfI32LtU",
+	fopBrIfI32GtS:                       "BrIfI32GtS",
+	fopBrIfI32GtU:                       "BrIfI32GtU",
+	fopBrIfI32LeS:                       "BrIfI32LeS",
+	fopBrIfI32LeU:                       "BrIfI32LeU",
+	fopBrIfI32GeS:                       "BrIfI32GeS",
+	fopBrIfI32GeU:                       "BrIfI32GeU",
+	fopBrIfI64Eqz:                       "BrIfI64Eqz",
+	fopBrIfI64Eq:                        "BrIfI64Eq",
+	fopBrIfI64Ne:                        "BrIfI64Ne",
+	fopBrIfI64LtS:                       "BrIfI64LtS",
+	fopBrIfI64LtU:                       "BrIfI64LtU",
+	fopBrIfI64GtS:                       "BrIfI64GtS",
+	fopBrIfI64GtU:                       "BrIfI64GtU",
+	fopBrIfI64LeS:                       "BrIfI64LeS",
+	fopBrIfI64LeU:                       "BrIfI64LeU",
+	fopBrIfI64GeS:                       "BrIfI64GeS",
+	fopBrIfI64GeU:                       "BrIfI64GeU",
+	fopBrIfF32Eq:                        "BrIfF32Eq",
+	fopBrIfF32Ne:                        "BrIfF32Ne",
+	fopBrIfF32Lt:                        "BrIfF32Lt",
+	fopBrIfF32Gt:                        "BrIfF32Gt",
+	fopBrIfF32Le:                        "BrIfF32Le",
+	fopBrIfF32Ge:                        "BrIfF32Ge",
+	fopBrIfF64Eq:                        "BrIfF64Eq",
+	fopBrIfF64Ne:                        "BrIfF64Ne",
+	fopBrIfF64Lt:                        "BrIfF64Lt",
+	fopBrIfF64Gt:                        "BrIfF64Gt",
+	fopBrIfF64Le:                        "BrIfF64Le",
+	fopBrIfF64Ge:                        "BrIfF64Ge",
+	fopI32StoreZ:                        "I32StoreZ",
+	fopI64StoreZ:                        "I64StoreZ",
+	fopF32StoreZ:                        "F32StoreZ",
+	fopF64StoreZ:                        "F64StoreZ",
+	fopI32Store8Z:                       "I32Store8Z",
+	fopI32Store16Z:                      "I32Store16Z",
+	fopI64Store8Z:                       "I64Store8Z",
+	fopI64Store16Z:                      "I64Store16Z",
+	fopI64Store32Z:                      "I64Store32Z",
+	fopLocalSetI:                        "LocalSetI",
+	fopGlobalSetI:                       "GlobalSetI",
+	fopI32LoadI:                         "I32LoadI",
+	fopI64LoadI:                         "I64LoadI",
+	fopF32LoadI:                         "F32LoadI",
+	fopF64LoadI:                         "F64LoadI",
+	fopI32Load8SI:                       "I32Load8SI",
+	fopI32Load8UI:                       "I32Load8UI",
+	fopI32Load16SI:                      "I32Load16SI",
+	fopI32Load16UI:                      "I32Load16UI",
+	fopI64Load8SI:                       "I64Load8SI",
+	fopI64Load8UI:                       "I64Load8UI",
+	fopI64Load16SI:                      "I64Load16SI",
+	fopI64Load16UI:                      "I64Load16UI",
+	fopI64Load32SI:                      "I64Load32SI",
+	fopI64Load32UI:                      "I64Load32UI",
+	fopI32StoreI:                        "I32StoreI",
+	fopI64StoreI:                        "I64StoreI",
+	fopF32StoreI:                        "F32StoreI",
+	fopF64StoreI:                        "F64StoreI",
+	fopI32Store8I:                       "I32Store8I",
+	fopI32Store16I:                      "I32Store16I",
+	fopI64Store8I:                       "I64Store8I",
+	fopI64Store16I:                      "I64Store16I",
+	fopI64Store32I:                      "I64Store32I",
+	fopI32EqI:                           "I32EqI",
+	fopI32NeI:                           "I32NeI",
+	fopI32LtSI:                          "I32LtSI",
+	fopI32LtUI:                          "I32LtUI",
+	fopI32GtSI:                          "I32GtSI",
+	fopI32GtUI:                          "I32GtUI",
+	fopI32LeSI:                          "I32LeSI",
+	fopI32LeUI:                          "I32LeUI",
+	fopI32GeSI:                          "I32GeSI",
+	fopI32GeUI:                          "I32GeUI",
+	fopI64EqI:                           "I64EqI",
+	fopI64NeI:                           "I64NeI",
+	fopI64LtSI:                          "I64LtSI",
+	fopI64LtUI:                          "I64LtUI",
+	fopI64GtSI:                          "I64GtSI",
+	fopI64GtUI:                          "I64GtUI",
+	fopI64LeSI:                          "I64LeSI",
+	fopI64LeUI:                          "I64LeUI",
+	fopI64GeSI:                          "I64GeSI",
+	fopI64GeUI:                          "I64GeUI",
+	fopF32EqI:                           "F32EqI",
+	fopF32NeI:                           "F32NeI",
+	fopF32LtI:                           "F32LtI",
+	fopF32GtI:                           "F32GtI",
+	fopF32LeI:                           "F32LeI",
+	fopF32GeI:                           "F32GeI",
+	fopF64EqI:                           "F64EqI",
+	fopF64NeI:                           "F64NeI",
+	fopF64LtI:                           "F64LtI",
+	fopF64GtI:                           "F64GtI",
+	fopF64LeI:                           "F64LeI",
+	fopF64GeI:                           "F64GeI",
+	fopI32AddI:                          "I32AddI",
+	fopI32SubI:                          "I32SubI",
+	fopI32MulI:                          "I32MulI",
+	fopI32DivSI:                         "I32DivSI",
+	fopI32DivUI:                         "I32DivUI",
+	fopI32RemSI:                         "I32RemSI",
+	fopI32RemUI:                         "I32RemUI",
+	fopI32AndI:                          "I32AndI",
+	fopI32OrI:                           "I32OrI",
+	fopI32XorI:                          "I32XorI",
+	fopI32ShlI:                          "I32ShlI",
+	fopI32ShrSI:                         "I32ShrSI",
+	fopI32ShrUI:                         "I32ShrUI",
+	fopI32RotlI:                         "I32RotlI",
+	fopI32RotrI:                         "I32RotrI",
+	fopI64AddI:                          "I64AddI",
+	fopI64SubI:                          "I64SubI",
+	fopI64MulI:                          "I64MulI",
+	fopI64DivSI:                         "I64DivSI",
+	fopI64DivUI:                         "I64DivUI",
+	fopI64RemSI:                         "I64RemSI",
+	fopI64RemUI:                         "I64RemUI",
+	fopI64AndI:                          "I64AndI",
+	fopI64OrI:                           "I64OrI",
+	fopI64XorI:                          "I64XorI",
+	fopI64ShlI:                          "I64ShlI",
+	fopI64ShrSI:                         "I64ShrSI",
+	fopI64ShrUI:                         "I64ShrUI",
+	fopI64RotlI:                         "I64RotlI",
+	fopI64RotrI:                         "I64RotrI",
+	fopF32AddI:                          "F32AddI",
+	fopF32SubI:                          "F32SubI",
+	fopF32MulI:                          "F32MulI",
+	fopF32DivI:                          "F32DivI",
+	fopF32MinI:                          "F32MinI",
+	fopF32MaxI:                          "F32MaxI",
+	fopF32CopysignI:                     "F32CopysignI",
+	fopF64AddI:                          "F64AddI",
+	fopF64SubI:                          "F64SubI",
+	fopF64MulI:                          "F64MulI",
+	fopF64DivI:                          "F64DivI",
+	fopF64MinI:                          "F64MinI",
+	fopF64MaxI:                          "F64MaxI",
+	fopF64CopysignI:                     "F64CopysignI",
+	fopBrIfI32EqzI:                      "BrIfI32EqzI",
+	fopBrIfI32EqI:                       "BrIfI32EqI",
+	fopBrIfI32NeI:                       "BrIfI32NeI",
+	fopBrIfI32LtSI:                      "BrIfI32LtSI",
+	fopBrIfI32LtUI:                      "BrIfI32LtUI",
+	fopBrIfI32GtSI:                      "BrIfI32GtSI",
+	fopBrIfI32GtUI:                      "BrIfI32GtUI",
+	fopBrIfI32LeSI:                      "BrIfI32LeSI",
+	fopBrIfI32LeUI:                      "BrIfI32LeUI",
+	fopBrIfI32GeSI:                      "BrIfI32GeSI",
+	fopBrIfI32GeUI:                      "BrIfI32GeUI",
+	fopBrIfI64EqzI:                      "BrIfI64EqzI",
+	fopBrIfI64EqI:                       "BrIfI64EqI",
+	fopBrIfI64NeI:                       "BrIfI64NeI",
+	fopBrIfI64LtSI:                      "BrIfI64LtSI",
+	fopBrIfI64LtUI:                      "BrIfI64LtUI",
+	fopBrIfI64GtSI:                      "BrIfI64GtSI",
+	fopBrIfI64GtUI:                      "BrIfI64GtUI",
+	fopBrIfI64LeSI:                      "BrIfI64LeSI",
+	fopBrIfI64LeUI:                      "BrIfI64LeUI",
+	fopBrIfI64GeSI:                      "BrIfI64GeSI",
+	fopBrIfI64GeUI:                      "BrIfI64GeUI",
+	fopBrIfF32EqI:                       "BrIfF32EqI",
+	fopBrIfF32NeI:                       "BrIfF32NeI",
+	fopBrIfF32LtI:                       "BrIfF32LtI",
+	fopBrIfF32GtI:                       "BrIfF32GtI",
+	fopBrIfF32LeI:                       "BrIfF32LeI",
+	fopBrIfF32GeI:                       "BrIfF32GeI",
+	fopBrIfF64EqI:                       "BrIfF64EqI",
+	fopBrIfF64NeI:                       "BrIfF64NeI",
+	fopBrIfF64LtI:                       "BrIfF64LtI",
+	fopBrIfF64GtI:                       "BrIfF64GtI",
+	fopBrIfF64LeI:                       "BrIfF64LeI",
+	fopBrIfF64GeI:                       "BrIfF64GeI",
+	fopI32StoreZI:                       "I32StoreZI",
+	fopI64StoreZI:                       "I64StoreZI",
+	fopF32StoreZI:                       "F32StoreZI",
+	fopF64StoreZI:                       "F64StoreZI",
+	fopI32Store8ZI:                      "I32Store8ZI",
+	fopI32Store16ZI:                     "I32Store16ZI",
+	fopI64Store8ZI:                      "I64Store8ZI",
+	fopI64Store16ZI:                     "I64Store16ZI",
+	fopI64Store32ZI:                     "I64Store32ZI",
+	fopI32AddThenI32AddI:                "I32AddThenI32AddI",
+	fopI32AddThenI32Load8UI:             "I32AddThenI32Load8UI",
+	fopI32AddIThenI32Load8UI:            "I32AddIThenI32Load8UI",
+	fopI32Load8UIThenI32ShlI:            "I32Load8UIThenI32ShlI",
+	fopI32AddIThenI32LoadI:              "I32AddIThenI32LoadI",
+	fopI32ShlIThenI32AddI:               "I32ShlIThenI32AddI",
+	fopI32ShlIThenI32AddIThenI32LoadI:   "I32ShlIThenI32AddIThenI32LoadI",
+	fopI32ShlIThenI32Add:                "I32ShlIThenI32Add",
+	fopI32AddIThenI32LoadIThenI32Xor:    "I32AddIThenI32LoadIThenI32Xor",
+	fopI32LoadIThenI32Xor:               "I32LoadIThenI32Xor",
+	fopI32AddIThenI32Load8UIThenI32ShlI: "I32AddIThenI32Load8UIThenI32ShlI",
+	fopI32Load8UIThenI32ShlIThenI32AddI: "I32Load8UIThenI32ShlIThenI32AddI",
+	fopI32AddIThenI32Load16UI:           "I32AddIThenI32Load16UI",
+	fopI32LoadIThenI32Add:               "I32LoadIThenI32Add",
+	fopI32AndIThenI32ShlI:               "I32AndIThenI32ShlI",
+	fopI32AddThenI32AddIThenI32Load16UI: "I32AddThenI32AddIThenI32Load16UI",
+	fopI32LoadIThenI32AddThenI32AddI:    "I32LoadIThenI32AddThenI32AddI",
+	fopI32Load16UIThenI32Store16IRhs:    "I32Load16UIThenI32Store16IRhs",
+	fopI32AddIThenI32Store16I:           "I32AddIThenI32Store16I",
+	fopI32ShlIThenI32AndI:               "I32ShlIThenI32AndI",
+	fopI32AddIThenI32Load16UIThenI32Store16IRhs: "I32AddIThenI32Load16UIThenI32Store16IRhs",
+	fopI32AndIThenI32Xor:                        "I32AndIThenI32Xor",
+	fopI32Load8UIThenI32Store8IRhs:              "I32Load8UIThenI32Store8IRhs",
+	fopI32AddThenI32Load8UIThenI32Store8IRhs:    "I32AddThenI32Load8UIThenI32Store8IRhs",
+}
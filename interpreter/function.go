@@ -37,6 +37,27 @@ type function struct {
 	switches     []switchTable      // The function's switch tables.
 }
 
+// decode decodes the function's bytecode into icode.
+func (fn *function) decode() error {
+	locals := append([]wasm.ValueType(nil), fn.signature.ParamTypes...)
+	for _, entry := range fn.localEntries {
+		for i := 0; i < int(entry.Count); i++ {
+			locals = append(locals, entry.Type)
+		}
+	}
+	fn.numLocals = len(locals)
+
+	body, err := code.Decode(fn.bytecode, &scope{
+		module: fn.module,
+		locals: locals,
+	}, fn.signature.ReturnTypes)
+	if err != nil {
+		return err
+	}
+	fn.icode, fn.metrics, fn.bytecode = body.Instructions, body.Metrics, nil
+	return nil
+}
+
 func (fn *function) blockType(instr *code.Instruction) (ins []wasm.ValueType, outs []wasm.ValueType) {
 	return fn.module.blockType(instr)
 }
@@ -0,0 +1,776 @@
+total 96069768
+3982582 I32AddI I32AddI
+3539794 I32Add I32AddI:1
+2864121 I32Add I32Load8UI:1
+2482016 I32AddI I32Load8UI:1
+2480668 I32Load8UI I32ShlI:1
+2321374 I32AddI I32LoadI:1
+2203427 I32ShlI I32AddI:1
+2097330 I32ShlI I32AddI:1 I32LoadI:1
+2080621 I32ShlI I32Add:1
+2063586 I32AddI I32AddI I32AddI
+1966980 I32AddI I32LoadI:1 I32Xor:1
+1966980 I32LoadI I32Xor:1
+1902920 I32ShlI I32Add
+1832490 I32AndI I32Add
+1572720 I32AddI I32Load8UI:1 I32ShlI:1
+1572720 I32Load8UI I32ShlI:1 I32AddI:1
+1556625 I32Add I32AddI
+1543177 BrIfI32GeU I32AddI
+1539520 I32Add I32Add
+1441862 I32Xor I32AddI
+1441660 I32LoadI I32Xor:1 I32AddI
+1408895 I32Xor I32AddI I32Load8UI:1
+1408642 I32AddI BrIfI32Eqz
+1213562 I32AddI I32Load16UI:1
+1122381 I32LoadI I32Add:1
+1103701 I32AndI I32ShlI:1
+1103499 I32AndI I32ShlI:1 I32Add:1
+1102001 I32AddI I32Load8UI
+1101292 I32Add I32AddI:1 I32Load8UI
+1089692 I32Add I32AddI:1 I32Load16UI:1
+1083869 I32LoadI I32Add:1 I32AddI:1
+1048747 I32Store16I I32LoadI
+1048655 I32Load16UI I32Store16I:2
+1048600 I32Add I32AddI:1 I32Store16I:1
+1048600 I32AddI I32Store16I:1
+1048584 I32ShlI I32AndI:1
+1048576 I32AndI I32Add I32AddI:1
+1048576 I32Load8UI I32Store8I
+1048576 I32Load8UI I32Store8I BrIfI32GtUI
+1048576 I32Store8I BrIfI32GtUI
+1048574 I32AddI I32Load16UI:1 I32Store16I:2
+1048574 I32AndI I32Xor:1
+1048574 I32Load16UI I32Store16I:2 I32LoadI
+1048574 I32ShlI I32Add I32AddI:1
+1048574 I32ShlI I32AndI:1 I32Xor:1
+1048574 I32Store16I I32LoadI I32Add:1
+1048421 I32LoadI I32AndI
+1048393 I32Add I32ShlI
+1048328 I32Add I32ShlI I32AndI:1
+1048328 I32ShlI I32Add:1 I32ShlI
+1048318 I32AddI I32Load8UI I32Store8I
+1048318 I32AddI I32Store16I:1 BrIfL
+1048318 I32AndI I32Xor:1 I32ShlI
+1048318 I32LoadI I32AndI I32ShlI:1
+1048318 I32Store16I BrIfL
+1048318 I32Xor I32ShlI
+1048318 I32Xor I32ShlI I32Add
+1031645 I32Store8I I32AddI
+1031389 I32Load8UI I32Store8I:2
+1030960 I32Add I32Load8UI:1 I32Store8I:2
+1030943 I32And BrIfI32GeU
+1013585 I32Load8UI I32Store8I:2 I32AddI
+1013432 I32AddI BrIfI32GtUI
+995982 I32AddI I32LoadI
+995459 I32LoadI BrL
+995328 I32AddI I32AddI I32LoadI
+995328 I32AddI I32LoadI BrL
+924200 I32AddI I32Add
+890170 I32Add I32Load8UI:1 I32ShlI:1
+889493 I32Sub I32AndI:1
+856949 I32Add I32AddI BrIfI32GtUI
+854346 I32Add I32Load8UI:1 I32Or:1
+854346 I32Load8UI I32Or:1
+854346 I32ShlI I32Add I32Load8UI:1
+836667 BrIfI32GtUI BrIfI32EqI
+836667 I32AddI BrIfI32GtUI BrIfI32EqI
+836667 I32Load8UI I32ShlI:1 I32Add
+818955 I32AndI I32AndI
+817906 I32Load16UI BrIfI32Eqz
+817739 I32Add I32Load16UI:1
+817640 I32ShlI I32Add:1 I32Load16UI:1
+817285 BrIfI32Eqz I32ShlI
+817214 BrIfI32Eqz I32ShlI I32Add:1
+817198 I32Add I32Load16UI:1 BrIfI32Eqz
+817198 I32AddI BrIfI32Eqz I32ShlI
+815602 BrIfI32Eqz I32Sub
+815417 BrIfI32Eqz I32Sub I32AndI:1
+815417 I32AndI BrIfI32GtU
+815417 I32Load16UI BrIfI32Eqz I32Sub
+815417 I32Sub I32AndI:1 BrIfI32GtU
+810131 I32AddI BrIfI32GeU
+804654 I32AndI BrIfI32Ne
+794930 I32AddI I32Add I32Load8UI:1
+792423 BrIfI32GeU I32AddI BrIfI32GeU
+784234 I32Or I32AndI:1
+783798 I32AndI I32Add I32AddI
+783694 BrIfI32EqI I32Add
+783694 BrIfI32EqI I32Add I32Load8UI:1
+783694 BrIfI32GtU I32AndI
+783694 BrIfI32GtU I32AndI I32Add
+783694 BrIfI32GtUI BrIfI32EqI I32Add
+783694 I32AndI BrIfI32GtU I32AndI
+783694 I32AndI I32AndI BrIfI32Ne
+783694 I32Load8UI I32Or:1 I32AndI:1
+783694 I32Or I32AndI:1 I32AndI
+766120 I32And BrIfI32GeU I32AddI
+759378 I32AddI BrIfI32GeU I32AddI
+741699 BrIfI32GeU I32AddI I32Add
+577433 I32Load8UI I32Xor:1
+550951 I32Load8UI I32Add:1
+536798 BrIfI32GeU I32Add
+533200 I32Load8UI I32Add:1 I32Add
+532992 I32Load8U I32Add
+532992 I32Load8U I32Add I32Add
+524496 I32Xor I32ShlI:1
+524240 I32Load8UI I32Xor:1 I32ShlI:1
+524240 I32Xor I32ShlI:1 I32AddI:1
+518267 I32Add I32Add I32AddI
+510965 I32AddI BrIfL
+510356 I32AddI I32AddI BrIfL
+494502 I32AddI I32And
+494466 I32AddI I32And BrIfI32GeU
+494466 I32Store8I I32AddI I32And
+491475 I32AddI I32Load8UI:1 I32Xor:1
+393180 I32LoadI I32Xor:1 I32ShrUI
+393180 I32Xor I32ShrUI
+360421 I32AndI I32AddI
+360415 I32AndI I32AddI I32Load8UI:1
+355328 I32Add I32Add I32Load8U
+355328 I32Add I32Load8U
+355328 I32Add I32Load8U I32Add
+319077 I64Const Select
+316294 I32StoreI I32AddI
+291783 I32StoreI I32AddI I32AddI
+289261 I32Add I32Add I32Load8UI:1
+288771 I32AddI I32Load8UI:1 I32Add:1
+280348 I32LoadI I32StoreI:2
+280309 I32LoadI I32StoreI:2 I32AddI
+272734 I32AddI I32And:1
+271671 BrIfI32GeU I32Add I32Add
+271654 I32AddI I32And:1 BrIfI32GeU
+271654 I32Store8I I32AddI I32And:1
+262474 I32Add I32AddI I32AddI
+262200 I32ShrUI I32AndI:1
+262120 I32ShrUI I32AndI:1 I32AddI
+262120 I32Xor I32ShrUI I32AndI:1
+253440 I32Add I32AddI I32Load8UI:1
+250651 BrIfI32Eqz BrL
+250344 I32AddI BrIfI32Eqz BrL
+247474 I32Store8I I32AddI I32AddI
+247233 BrIfI32GeU I32Add BrIfI32GeU
+247233 I32Add BrIfI32GeU
+247233 I32Add BrIfI32GeU I32Add
+247233 I32Add I32And
+247233 I32Add I32And BrIfI32GeU
+247233 I32And BrIfI32GeU I32Add
+235636 LocalGet LocalGet
+218482 I32AddI I32AddI I32LoadI:1
+212790 LocalSet LocalSet
+210259 I32AddI I32LoadI:1 I32StoreI:2
+184733 I32LoadI I32AddI
+179968 I64Const I32Sub
+179801 LocalGet LocalGet LocalGet
+177664 I32Add I32Add I32Load8UI
+177664 I32Add I32Load8UI
+177664 I32Add I32Load8UI I32Add:1
+157964 I32AddI BrIfI32Eq
+157934 BrIfI32Eq I32AddI
+157641 I32AddI I64LoadI:1
+157355 I32Add LocalSet
+157291 I64LoadI I32AddI
+157287 I32Add I32Add LocalSet
+157286 BrIfI32Eq I32Add
+157286 BrIfI32Eq I32Add I32Add
+157286 BrIfI32Eq I32AddI BrIfI32Eq
+157286 BrIfI32EqI BrIfI32Eq
+157286 BrIfI32EqI BrIfI32Eq I32AddI
+157286 I32Add LocalSet I64LoadI
+157286 I32AddI BrIfI32Eq I32Add
+157286 I32AddI I64LoadI:1 I64Xor:1
+157286 I64LoadI I32AddI I64LoadI:1
+157286 I64LoadI I64Xor:1
+157286 I64LoadI I64Xor:1 BrIfI64Eqz
+157286 I64Xor BrIfI64Eqz
+157286 LocalSet I64LoadI
+157286 LocalSet I64LoadI I32AddI
+150074 LocalSet BrL
+150019 I32ShrUI I32AddI
+140439 I32Load I32Add
+131381 I32AddI I32LoadI:1 I32AddI
+131131 I32LoadI I32AddI I32Load8UI:1
+131060 I32LoadI I32Xor:1 I32AndI
+131060 I32ShrUI I32AddI I32Load8UI:1
+131060 I32Xor I32AndI
+131060 I32Xor I32ShrUI I32AddI
+124910 I32LoadI I32AddI:1
+124699 I32AndI BrIfL
+123987 I32GtU Select
+123558 I32AndI I64ExtendI32U:1
+121137 I32AddI I32AddI BrIfI32GtUI
+111269 I32Load16UI I32AddI
+111125 I32Add I32AddI:1 I32Load8UI:1
+107936 BrIfI32GeUI I32Add
+107936 BrIfI32GeUI I32Add I32AddI:1
+106240 I32AndI I32Add:1
+106238 I32AndI I32Add:1 I32Load8UI:1
+106147 Select I32Store
+106043 I32Sub I32GtU
+106043 I32Sub I32GtU Select
+105968 I32LtUI I64Const
+105968 I32LtUI I64Const Select
+103283 I32Load I32AndI
+98295 I32Xor I32AndI I32AddI
+91367 LocalSetI LocalSet
+90225 I32AddI I32AndI
+88508 I32Load I32AddI
+88476 BrIfI32LtU I32LoadI
+88476 I32Add BrIfI32LtU
+88332 I32AndI I64ExtendI32U:1 I64Shl:2
+88332 I32Load8UI I32ShlI
+88332 I64ExtendI32U I64Shl:2
+88332 I64ExtendI32U I64Shl:2 I64Or:1
+88332 I64Shl I64Or:1
+88033 I32AddI I32Load16UI:1 I32AddI
+87240 I32AndI BrIfI32Eqz
+73842 I64Const I32Sub I32AndI:1
+73385 BrIfI32LeU I32Add
+71121 I32AddI I32AndI:1
+70966 I32ShlI I32Add:1 I32AddI:1
+70722 I32ShlI I32AddI:1 I32Load16UI:1
+70654 I32Store8I I32LoadI
+70653 I32Store8I I32LoadI I32AddI:1
+70054 I32Sub I32AndI
+69455 LocalSet LocalSet LocalSet
+64648 BrIfI32LeUI LocalSet
+62717 I64StoreI I32AddI
+58802 BrIfI32Eqz I32Add
+58561 I32AddI I32StoreI
+56887 LocalGet Call
+55862 I32Add I32LoadI:1
+55663 LocalGet LocalGet Call
+54828 I32Load BrIfI32GtU
+53584 BrIfL LocalSet
+53584 BrIfL LocalSet BrL
+53531 I32AddI I32Add I32AddI
+53514 I32And I32Or:1
+53484 I32Add I32AndI
+53469 I32AddI I32Store
+53331 I32Load I64Const
+53272 I32Add I32Load
+53270 I32LoadI I32Load
+53268 I32Add I32Load I32Add
+53264 I32Sub I32Sub
+53247 I32AddI I32AndI:1 I32Add:1
+53247 I32Xor I32Add
+53231 I32LoadI I32Add
+53218 I32AndI I64Const
+53190 I32AndI I32ShrU:2
+53184 I32ShlI I32Add:1 I32LoadI:1
+53178 BrIfL LocalSetI
+53172 I32GtU Select I32Store
+53146 I32Add I32AddI I32Load16UI:1
+53137 BrIfI32Eqz I32Load
+53129 I32AddI I32Store16I:2
+53129 I32Load16UI I32AddI:1
+53129 I32Load16UI I32AddI:1 I32Store16I:2
+53088 BrIfI32LtU BrIfI32LtU
+53085 I32AddI I64Const
+53070 I32Store BrIfI32GtUI
+53058 I32Add I32Load16SI:1
+53058 I32Add I32Load16SI:1 BrIfI32GtSI
+53058 I32Load16SI BrIfI32GtSI
+53058 I32ShlI I32Add:1 I32Load16SI:1
+53048 I32Load I32AddI I32Store
+53040 Select BrIfI32Eqz
+53039 I32GtU Select BrIfI32Eqz
+53038 I32Store8I I32Load
+53037 I32Add I32AndI I64ExtendI32U:1
+53031 I32Add BrIfI32GtUI
+53025 I32AndI LocalSetI
+53018 I32ShlI I32AddI
+53018 I32Store I32Sub
+53015 BrIfI32LtU I32Add
+53015 I32AndI I64Const Select
+53015 I32Or I64Const
+53014 I32Or I32Or:1
+53009 I64Const Select I64Const
+53009 Select I64Const
+53007 I32Add BrIfI32LtU BrIfI32LtU
+53007 I32GtUI I64Const
+53007 I32GtUI I64Const Select
+53006 I32Or I32Or:1 I64Const
+53004 I32Load I64Const I32Sub
+53004 I32Sub I32Sub I32GtU
+53004 I64Const I32Sub I32GtU
+53004 I64Const I32Sub I32Sub
+53004 Select I32Store BrIfI32GtUI
+53000 BrIfI32Eqz I32LoadI
+52992 BrIfI32Eqz I32Load I32Add
+52992 I32Load I32Add BrIfI32GtUI
+52992 Select BrIfI32Eqz I32Load
+52991 BrIfI32LtU BrIfI32LtU I32LoadI
+52991 BrIfI32LtU I32LoadI I32AddI
+52991 I32Add I32AddI I32Add
+52991 I32Add I32AddI I32AndI
+52991 I32Add I32Load8UI:1 I32Xor:1
+52991 I32AddI I32AndI I32Add:1
+52991 I32Load8UI I32ShlI:1 I32AddI
+52991 I32Load8UI I32Xor:1 I32Add
+52991 I32LoadI I32AddI I32Add
+52991 I32ShlI I32AddI I32AndI:1
+52991 I32Store16I BrIfL LocalSet
+52991 I32Xor I32Add I32AddI
+52980 I32AndI I32ShrUI:1
+52976 I32AndI BrIfL LocalSetI
+52976 I32Load BrIfI32GeUI
+52976 I32Load BrIfI32GeUI I32Add
+52976 I32Load BrIfI32LeUI
+52976 I32Sub I32LoadI
+52975 I64Const Select BrIfL
+52975 I64Const Select I32Load
+52975 Select BrIfL
+52975 Select I32GtUI
+52975 Select I32GtUI I64Const
+52975 Select I32Load
+52974 BrIfI32LtU I32Add I32Load
+52974 BrIfL LocalSetI I32LtUI
+52974 I32Add I32AddI:1 I64Load16UI:1
+52974 I32Add I32LtUI
+52974 I32Add I32LtUI I64Const
+52974 I32AddI I64Load16UI:1
+52974 I32And I32LtUI
+52974 I32And I32LtUI I32AndI
+52974 I32And I32Or:1 I32Or:1
+52974 I32AndI I32ShrUI:1 I32And:1
+52974 I32Eq I32EqI
+52974 I32Eq I32EqI I32GtUI
+52974 I32EqI I32GtUI
+52974 I32EqI I32GtUI I32And
+52974 I32GtUI I32And
+52974 I32GtUI I32And I32LtUI
+52974 I32Load I32Add I32LtUI
+52974 I32Load I32AndI BrIfL
+52974 I32Load8UI I32ShlI I32Add:1
+52974 I32LoadI I32Load BrIfI32LeUI
+52974 I32LtUI I32AndI
+52974 I32LtUI I32AndI I32ShrUI:1
+52974 I32Or I64Const Select
+52974 I32ShrUI I32And:1
+52974 I32ShrUI I32And:1 I32Or:1
+52974 I32Store I32Sub I32LoadI
+52974 I32Sub I32LoadI I32Load
+52974 I64Const Select BrIfI32LeU
+52974 I64Const Select I32GtUI
+52974 I64Const Select I32Store
+52974 LocalSetI I32LtUI
+52974 LocalSetI I32LtUI I64Const
+52974 Select BrIfI32LeU
+52974 Select I32Load I32AndI
+52974 Select I32Store I32Sub
+52974 Select I64Const Select
+52973 BrIfI32EqI I32GtUI
+52973 BrIfI32EqI I32GtUI I32ShlI
+52973 BrIfI32Eqz I32LoadI I32Add
+52973 BrIfI32GtUI BrIfI32EqI I32GtUI
+52973 BrIfI32LeU I32Add I32AddI
+52973 I32Add I32LoadI:1 I32AddI:1
+52973 I32AddI BrIfI32Eqz I32LoadI
+52973 I32AddI I64Const I32Sub
+52973 I32AndI LocalSetI LocalSet
+52973 I32GtUI I32ShlI
+52973 I32GtUI I32ShlI I32Add:1
+52973 I32Load16UI I32AddI I32Add
+52973 I32Load8UI I32Or:1 I32AddI
+52973 I32LoadI I32Add I32AddI
+52973 I32LoadI I32AddI:1 BrIfI32Eqz
+52973 I32Or I32AddI
+52973 I32Or I32AddI I64Const
+52973 I32Sub I32AndI:1 LocalSetI
+52973 Select BrIfI32LeU I32Add
+52910 BrIfI32GtUI I32Load
+52786 I32ShrUI BrIfL
+52525 I32Sub I32AndI I32ShrU:2
+50480 BrIfI32LeUI LocalSet BrL
+49768 I32Load I32AndI BrIfI32Eqz
+49756 BrIfI32GeU I32Load
+49755 BrIfI32LeUI BrIfI32GtUI
+49755 I32Load BrIfI32LeUI BrIfI32GtUI
+49754 BrIfI32GeU I32Load I32AndI
+49754 BrIfI32GtUI I32MulI
+49754 BrIfI32GtUI I32MulI I32ShrUI:1
+49754 BrIfI32LeUI BrIfI32GtUI I32MulI
+49754 I32MulI I32ShrUI:1
+49754 I32MulI I32ShrUI:1 BrIfI32GeU
+49754 I32ShrUI BrIfI32GeU
+49754 I32ShrUI BrIfI32GeU I32Load
+46853 I32AddI I64StoreI:1
+46852 I32AddI I64StoreI:1 I32AddI
+46851 I64StoreI I32AddI I64StoreI:1
+45036 LocalSet LocalSet BrL
+41238 I32AddI I32StoreI:2
+40817 I32Add I32Add:1
+40683 I32Sub BrIfI32LeUI
+39744 I32Store I32LoadI
+39353 BrIfI32GtUI I32AddI
+38512 I32AddI LocalSetI
+38376 I32ShrUI I32Add
+36544 I32LoadI I32AddI:1 I32StoreI:2
+36005 I32Load8UI BrIfI32GtUI
+36001 I32Store I32AndI
+35655 BrIfI32Eqz BrIfI32GtUI
+35493 I64Const I32Shl
+35487 I32Add I32Sub
+35457 I32Load16UI BrIfI32GtUI
+35413 I32XorI I32And:1
+35408 I32Add I32Load16UI
+35408 I32ShlI I32Add:1 I32Load16UI
+35403 I32Shl I32XorI:1
+35403 I32Shl I32XorI:1 I32And:1
+35403 I64Const I32Shl I32XorI:1
+35385 I32Load BrIfI32LtU
+35361 I32And I32Add:1
+35361 I32AndI I32ShrU
+35361 I32XorI I32And:1 I32Add:1
+35359 I32Store8I I32Load I32AddI
+35358 I32Add I32AddI:1 I32AddI
+35358 I32AddI I32AddI I32Store8I
+35358 I32AddI I32Load16UI:1 BrIfI32GtUI
+35358 I32AddI I32Load8UI:1 I32ShlI
+35358 I32AddI I32Store8I
+35358 I32AddI I32Store8I I32LoadI
+35358 I32AddI I32StoreI BrIfI32GeUI
+35358 I32AddI I64Load16UI:1 I32Add
+35358 I32AddI I64Load32UI:1
+35358 I32AddI I64Load32UI:1 I64ExtendI32U
+35358 I32LoadI I32AddI:1 I32StoreI
+35358 I32ShlI I32AddI:1 I64Load32UI:1
+35358 I32StoreI BrIfI32GeUI
+35358 I32StoreI BrIfI32GeUI I32Add
+35358 I64And I32Add
+35358 I64ExtendI32U I64And:1
+35358 I64ExtendI32U I64And:1 I32Add
+35358 I64Load16UI I32Add
+35358 I64Load32UI I64ExtendI32U
+35358 I64Load32UI I64ExtendI32U I64And:1
+35358 I64Or I32ShlI
+35358 I64Or I32ShlI I32AddI:1
+35358 I64Shl I64Or:1 I32ShlI
+35337 BrIfL I32Add
+35335 I32StoreI I32Load
+35328 I32Load I32ShrUI
+35297 I32AddI I32StoreI:2 I32Load
+35297 I32LoadI BrIfI32GeUI
+35295 I32Add I32Load16UI I32AddI:1
+35295 I32Add I32Load8UI:1 I32ShlI
+35295 I32AddI I32Load8UI I32ShrUI:1
+35295 I32AddI I32Store BrIfL
+35295 I32Load8UI I32ShrUI:1
+35295 I32Load8UI I32ShrUI:1 I32Store8I:2
+35295 I32ShrUI I32Store8I:2
+35295 I32ShrUI I32Store8I:2 I32Load
+35295 I32Store BrIfL
+35295 I32Store I32LoadI BrIfI32GeUI
+35295 I32StoreI I32Load BrIfI32GeUI
+35281 BrIfI32GtUI I32ShlI
+35269 LocalSetI I32Load
+35262 BrIfI32LtU I32Load
+35227 BrIfI32GtU I32Store
+35227 I64ExtendI32U I64ShrU:2
+35227 I64StoreI LocalSetI
+35226 BrIfI32GtU I32Store I32AndI
+35226 BrIfI32GtUI I32Load BrIfI32LtU
+35226 BrIfI32LtU I32Load BrIfI32GtU
+35226 BrIfI32LtU I32LoadI I32Add:1
+35226 I32Add BrIfI32LtU I32Load
+35226 I32Add I64StoreI:1
+35226 I32Add I64StoreI:1 LocalSetI
+35226 I32AddI BrIfI32GtUI I32Load
+35226 I32AndI I32AndI I64ExtendI32U:1
+35226 I32AndI I64ExtendI32U:1 I64ShrU:2
+35226 I32Load BrIfI32GtU I32Store
+35226 I32Load BrIfI32LtU I32LoadI
+35226 I32Load I32AddI BrIfI32GtUI
+35226 I32Load I32ShrUI I32Add:1
+35226 I32LoadI I32Add:1 I64StoreI:1
+35226 I32ShrUI I32Add:1
+35226 I32ShrUI I32Add:1 BrIfI32LtU
+35226 I32Store I32AndI I32AndI
+35226 I64ExtendI32U I64ShrU:2 BrIfI32LtU
+35226 I64ShrU BrIfI32LtU
+35226 I64StoreI LocalSetI I32Load
+35226 LocalSetI I32Load I32ShrUI
+35060 I32Load16UI I32AddI I32Load8UI:1
+35040 I32AndI I32ShrU I64Const
+35040 I32ShrU I64Const
+35040 I32ShrU I64Const I32Shl
+34324 LocalSetI BrL
+33375 BrIfL BrIfI32Eqz
+33372 Select BrIfL BrIfI32Eqz
+32967 I32Xor I32AddI I32AddI
+32765 I32AndI I32Load8UI
+32765 I32AndI I32Load8UI I32Xor:1
+32765 I32Xor I32AndI I32Load8UI
+31599 BrIfI32EqI LocalSet
+30813 BrIfI32EqI LocalSet LocalSet
+26439 I32AddI I32Store8I:1
+25994 I32Add I32AddI:1 I32Store8I:1
+25379 I32AddI BrIfI32GeU I32Add
+23209 I32Shl I32Or:1
+23172 BrIfI32LeUI I32Load16UI
+23172 BrIfI32LeUI I32Load16UI I32AddI
+23172 I32Sub BrIfI32LeUI I32Load16UI
+23158 I32AddI I32StoreI I32AddI
+23126 I32Load16UI I32AddI I32StoreI
+23020 I32AddI I32Shl
+23020 I32AddI I32Shl I32Or:1
+23020 I32Or LocalSet
+23020 I32Or LocalSet LocalSet
+23020 I32Shl I32Or:1 LocalSet
+23020 I32StoreI I32AddI I32Shl
+22676 I32AddI I32Add I32AddI:1
+22092 I32Load8UI I32OrI:1
+20886 I32Load16UI I32AndI
+20886 I32Load16UI I32AndI BrIfI32Ne
+20828 I32Add I64Const
+20802 I32Add I64Const I32Sub
+20781 BrIfI32Eqz I32Add I32AddI:1
+20761 BrIfI32Ne I32Add
+20760 BrIfI32Ne BrIfI32Eqz
+20760 BrIfI32Ne BrIfI32Eqz I32Add
+20760 BrIfI32Ne I32Add I64Const
+20760 BrIfI64Eqz I64Ctz
+20760 BrIfI64Eqz I64Ctz I32WrapI64
+20760 I32Add I32AddI:1 BrIfI32LeU
+20760 I32AddI BrIfI32LeU
+20760 I32AddI I32Load16UI:1 I32AndI
+20760 I32AndI BrIfI32Ne BrIfI32Eqz
+20760 I32AndI BrIfI32Ne I32Add
+20760 I32AndI I32AddI:1
+20760 I32AndI I32AddI:1 LocalSetI
+20760 I32ShrUI I32Add I32AddI:1
+20760 I32Sub I32AndI:1 I32AddI:1
+20760 I32WrapI64 I32ShrUI
+20760 I32WrapI64 I32ShrUI I32Add
+20760 I64Ctz I32WrapI64
+20760 I64Ctz I32WrapI64 I32ShrUI
+20760 I64Xor BrIfI64Eqz I64Ctz
+20345 I32AddI BrIfI32GtUI I32AddI
+20317 I32Add I32Add:1 I32AddI:1
+20283 BrIfI32LeU BrIfI32LeU
+20283 I32AddI BrIfI32LeU BrIfI32LeU
+20282 BrIfI32Eqz I32Add I32Add:1
+20282 BrIfI32GtUI I32AddI BrIfI32Eqz
+20282 BrIfI32LeU BrIfI32LeU I32Add
+20282 BrIfI32LeU I32Add I32Add:1
+20282 I32Add I32Add:1 I32AddI
+20282 I32AddI BrIfI32Eqz I32Add
+20282 I32AddI I32Load16UI:1 LocalSet
+20282 I32Load16UI LocalSet
+20282 I32Load16UI LocalSet LocalSet
+19634 LocalSetI LocalSet LocalSet
+19602 I32LoadI BrIfI32GeUI I32Add
+19471 I32Load8UI BrIfI32Eqz
+18994 I32AndI BrTableL
+18959 I32ShrUI I32AddI I32AndI
+18725 I32AndI I32ShlI
+18360 I32Add I32Store
+18180 I32AddI I32Load8UI:1 BrIfI32Eqz
+18024 BrIfI32GtUI I32Add
+18007 I32Sub BrIfI32LtUI
+17972 Call I32AddI
+17945 LocalGet Call I32AddI
+17937 BrIfI32GtUI I32Add I32AddI:1
+17929 I32AddI I32Load16UI
+17883 I32Load I32Add I32Store
+17855 LocalSetI I32AndI
+17840 I32Add LocalSetI
+17833 I32Sub I32And:1
+17829 I32AndI I32ShlI I32AddI:1
+17810 BrIfI32Eqz I32AndI
+17789 I32AndI BrIfI32NeI
+17786 I32Add BrIfI32Eqz
+17781 BrIfI32GeUI I32ShlI
+17778 I32Load8UI I32ShlI:1 I32Add:1
+17770 I64Or I32Add
+17747 I32AddI I32Load16UI I32AddI:1
+17747 I32Load8U I32AndI
+17742 LocalSetI I32AndI BrIfL
+17736 I32Add I32AddI:1 I32Load16UI
+17730 I32Add I32Store8I:1
+17703 I32Add I32Add I32AddI:1
+17701 I32ShrUI BrIfI32Eqz
+17696 BrIfI32Eqz I32Add LocalSetI
+17694 I32Sub BrIfI32GeUI
+17693 I32Load8U I32AndI BrIfI32Eqz
+17690 BrIfL I32Add I32Add
+17690 I32AddI BrIfL I32Add
+17690 I32ShrUI BrIfI32Eqz I32Add
+17689 I32AddI I32LtUI
+17682 I32AddI LocalSetI BrL
+17682 I32Or BrIfI32LtUI
+17681 I32AddI I32ShrUI
+17680 BrIfI32GtUI I32ShrUI
+17679 BrIfI32Eqz BrIfI32GtUI I32AndI
+17679 BrIfI32Eqz BrIfI32GtUI I32Load
+17679 BrIfI32GeU I32Add I32AddI:1
+17679 BrIfI32GeUI I32ShlI I32AddI:1
+17679 BrIfI32GtU LocalGet
+17679 BrIfI32GtU LocalGet LocalGet
+17679 BrIfI32GtUI I32AddI I32Load8UI:1
+17679 BrIfI32GtUI I32AndI
+17679 BrIfI32GtUI I32AndI BrIfL
+17679 BrIfI32GtUI I32Load I32Add
+17679 BrIfI32GtUI I32ShlI I32Add:1
+17679 BrIfI32GtUI I32ShrUI I32AddI
+17679 BrIfI32LtUI BrIfI32Eqz
+17679 BrIfI32LtUI BrIfI32Eqz BrIfI32GtUI
+17679 BrIfL BrIfI32Eqz BrIfI32GtUI
+17679 BrIfL I32Load8U
+17679 BrIfL I32Load8U I32AndI
+17679 Call I32AddI LocalSetI
+17679 I32Add I32AddI:1 I32ShrUI
+17679 I32Add I32Store I32LoadI
+17679 I32AddI I32AddI I32LtUI
+17679 I32AddI I32AndI I64ExtendI32U:1
+17679 I32AddI I32AndI:1 I32ShlI
+17679 I32AddI I32Load8UI I32OrI:1
+17679 I32AddI I32Load8UI:1 BrIfI32GtUI
+17679 I32AddI I32LtUI Select
+17679 I32AddI I32ShrUI I32Store8I
+17679 I32AddI I32Store16I:2 BrIfI32GeUI
+17679 I32AddI I32Store16I:2 Return
+17679 I32AndI BrIfL I32Load8U
+17679 I32Load BrIfI32GtU LocalGet
+17679 I32Load16UI BrIfI32GtUI I32AddI
+17679 I32Load16UI BrIfI32GtUI I32ShlI
+17679 I32Load8UI BrIfI32GtUI I32Add
+17679 I32Load8UI BrIfI32GtUI I32ShrUI
+17679 I32Load8UI I32Add:1 I32AndI
+17679 I32Load8UI I32Or:1 BrIfI32LtUI
+17679 I32Load8UI I32OrI:1 I32Store8I:2
+17679 I32Load8UI I32ShlI I32Add
+17679 I32Load8UI I32ShlI I32AddI:1
+17679 I32LtUI Select
+17679 I32LtUI Select I32Load8UI
+17679 I32OrI I32Store8I:2
+17679 I32OrI I32Store8I:2 I32Load
+17679 I32ShrUI I32Store8I
+17679 I32ShrUI I32Store8I I32LoadI
+17679 I32Store16I BrIfI32GeUI
+17679 I32Store16I BrIfI32GeUI I32ShlI
+17679 I32Store16I Return
+17679 I32Store8I I32Load BrIfI32GeUI
+17679 I64And I32Add I32AddI:1
+17679 I64And I32Add I32AndI
+17679 I64Load16UI I32Add I32AndI
+17679 I64Load16UI I32Add I32Load8UI:1
+17679 I64Or I32Load8UI
+17679 I64Or I32Load8UI I32ShlI
+17679 I64Shl I64Or:1 I32Add
+17679 I64Shl I64Or:1 I32Load8UI
+17679 Select I32Load8UI
+17679 Select I32Load8UI I32ShlI:1
+17677 Select I32Sub
+17658 I32AddI BrL
+17648 I32And BrIfI32EqI
+17648 I32Sub I32And:1 BrIfI32EqI
+17646 Call Return
+17643 LocalGet Call Return
+17634 BrIfI32EqI LocalGet
+17633 BrIfI32EqI LocalGet LocalGet
+17631 I32And BrIfI32EqI LocalGet
+17631 LocalSet BrIfI32Eqz
+17626 I32AndI LocalSet
+17616 BrIfI32Eqz I32ShrUI
+17616 BrIfI32Eqz I32ShrUI I32Add
+17616 BrIfI32GeU I32AndI
+17616 BrIfI32GeU I32AndI LocalSet
+17616 I32Add BrIfI32Eqz I32ShrUI
+17616 I32Add I32AddI:1 BrIfI32GeU
+17616 I32Add I32Store8I:1 I32AddI
+17616 I32AddI BrIfI32GeU I32AndI
+17616 I32AddI I32Store I32LoadI
+17616 I32AddI I32Store8I:1 I32LoadI
+17616 I32AddI I64Load16UI:1 I32AndI
+17616 I32AndI LocalSet LocalSet
+17616 I32ShrUI I32Add I32Load8UI:1
+17616 I64Load16UI I32AndI
+17616 I64Load16UI I32AndI I64ExtendI32U:1
+17616 I64Or I32AddI
+17616 I64Or I32AddI I32Add
+17616 I64Shl I64Or:1 I32AddI
+17616 LocalSet LocalSet BrIfI32Eqz
+17615 I32ShlI I32ShrSI:1
+17595 I32AndI I32ShrU:2 LocalSetI
+17595 I32ShrU LocalSetI
+17595 I32ShrU LocalSetI I32AndI
+17590 I32AddI I32Load8UI:1 BrIfL
+17590 I32Load8UI BrIfL
+17582 I32AddI I32GtU
+17571 I32Add BrL
+17548 BrIfL BrIfI32LeUI
+17548 I32AndI BrIfL BrIfI32LeUI
+17548 I32Store8I I32AddI I32AndI
+17547 BrIfL I32Add I32AddI
+17547 I32Add I32AddI I32GtU
+17547 I32AddI I32GtU Select
+17547 I32AndI BrIfL I32Add
+17547 I32GtU Select I32Sub
+17547 I32Sub LocalSetI
+17547 I32Sub LocalSetI LocalSet
+17547 Select I32Sub LocalSetI
+17535 I32ShrU I32AndI
+17533 LocalSetI I32Sub
+17533 LocalSetI I32Sub BrIfI32LtUI
+17529 BrIfI32GtUI I32ShlI I32AddI:1
+17529 Call I32Add
+17529 Call I32Add I32Sub
+17529 I32Add I32Sub BrIfI32GeUI
+17529 I32AndI BrIfI32GtUI
+17529 I32AndI BrIfI32GtUI I32ShlI
+17529 I32AndI I32ShrU:2 I32AndI
+17529 I32ShrU I32AndI BrIfI32GtUI
+17529 I32Sub I32AndI I32ShrU
+17529 LocalGet Call I32Add
+17515 BrIfI32LeSI I32AddI
+17515 BrIfI32LeSI I32AddI I32AddI
+17515 BrIfI32LtUI I32ShlI
+17515 BrIfI32LtUI I32ShlI I32ShrSI:1
+17515 I32AddI I32AddI BrL
+17515 I32Or BrIfI32LtUI I32ShlI
+17515 I32ShlI I32ShrSI:1 BrIfI32LeSI
+17515 I32ShrSI BrIfI32LeSI
+17515 I32ShrSI BrIfI32LeSI I32AddI
+17511 BrIfI32Eqz I32AndI I32ShrU
+17511 I32Add I32Sub BrIfI32LeUI
+17511 I32And I32Add:1 I32Sub
+17511 I32Load8UI BrIfI32Eqz I32AndI
+17468 BrIfI32Eqz LocalSet
+17451 I32AndI BrIfI32Eqz LocalSet
+17401 BrIfI32GeU I32Sub
+17401 BrIfI32GeU I32Sub I32AndI
+17401 I32AddI I32AndI BrIfI32Eqz
+17401 I32AndI I32ShrU:2 I32Add
+17401 I32ShrU I32Add
+17401 I32ShrU I32Add I32Store8I:1
+17336 BrIfI32Eqz LocalSet LocalSet
+16937 BrIfI32GeU BrIfI32GeU
+16738 BrIfI32GeU BrIfI32GeU I32Add
+16721 I32And BrIfI32GeU BrIfI32GeU
+15951 I32Load I32Add I32AddI:1
+15699 LocalSetI I32ShlI
+15699 LocalSetI I32ShlI I32Add:1
+15695 BrIfI32GeUI I32Load
+15695 BrIfI32GeUI I32Load I32Add
+15693 I32AddI I32Load8UI:1 I32Load
+15693 I32AddI I32Store16I:2 LocalSetI
+15693 I32Load8UI I32Load
+15693 I32Load8UI I32Load I32AddI
+15693 I32LoadI BrIfI32GeUI I32Load
+15693 I32Store16I LocalSetI
+15693 I32Store16I LocalSetI BrL
+15686 I64StoreI I32AddI I32AddI
+14168 BrIfI32LeUI LocalSet LocalSet
+14168 I32Sub BrIfI32LeUI LocalSet
+12760 BrIfL BrIfI32LeUI LocalSet
+10732 I32AddI I32LoadI:1 I32ShlI
+10732 I32LoadI I32ShlI
+10732 I32LoadI I32ShlI I32ShrUI
+10732 I32Or I32StoreI:2
+10732 I32Or I32StoreI:2 I32AddI
+10732 I32ShlI I32ShrUI
+10732 I32ShlI I32ShrUI I32Or:1
+10732 I32ShrUI I32Or:1
+10732 I32ShrUI I32Or:1 I32StoreI:2
@@ -0,0 +1,557 @@
+// gen-fcode generates the interpreter's fcode opcode definitions and fcode execution loops from the templates in this
+// directory. It also selects the interpreter's superinstructions from an opcode profile.
+//
+// Usage (from the interpreter directory):
+//
+//	go run ./gen-fcode -profile gen-fcode/flate.profile
+//
+// gen-fcode writes fcode_ops.go, fcode_exec.go, fcode_exec_mmap.go, and fcode_exec_profile.go. The last is an
+// instrumented copy of the execution loop that is used to record opcode profiles. It is only built with the
+// warp_opcodeprofile build tag.
+//
+// An opcode profile records the number of times that sequences of two and three fcode instructions execute. Sequences
+// in which each instruction consumes the result of its predecessor are candidates for superinstructions: gen-fcode
+// selects the most frequent candidates that it knows how to fuse (see ops.go) and generates an opcode and an
+// implementation for each. The fcode importer fuses sequences as it emits them.
+//
+// To record a new profile of the flate benchmark:
+//
+//	go test -tags warp_opcodeprofile -run TestFlateOpcodeProfile -opcode-profile gen-fcode/flate.profile
+//
+// Profiles are recorded without superinstructions, so the generated code does not affect the next selection.
+//
+// Running gen-fcode without a profile generates code without superinstructions. interpreter/bench.txt compares the
+// flate benchmarks built with and without superinstructions on the same machine; to compare them, run
+//
+//	benchstat -col superinstructions bench.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed fcode_ops.go.tmpl
+var opsTemplate string
+
+//go:embed fcode_exec.go.tmpl
+var execTemplate string
+
+const header = "// Code generated by gen-fcode. DO NOT EDIT.\n\n"
+
+// A variant describes one of the generated fcode execution loops.
+type variant struct {
+	path       string // The name of the generated file.
+	constraint string // The file's build constraint, if any.
+	mmap       bool   // True if the loop accesses memory directly. Requires a guard-page memory layout.
+	profile    bool   // True if the loop counts the instructions it executes.
+}
+
+var variants = []variant{
+	{
+		path:       "fcode_exec.go",
+		constraint: "//go:build memtrace || js || plan9 || windows || armbe || arm64be || ppc || ppc64 || mips || mips64 || s390x\n// +build memtrace js plan9 windows armbe arm64be ppc ppc64 mips mips64 s390x\n\n",
+	},
+	{
+		path:       "fcode_exec_mmap.go",
+		constraint: "//go:build (!memtrace && !js && !plan9 && !windows && !armbe && !arm64be && !ppc && !ppc64 && !mips && !mips64 && !s390x)\n// +build !memtrace,!js,!plan9,!windows,!armbe,!arm64be,!ppc,!ppc64,!mips,!mips64,!s390x\n\n",
+		mmap:       true,
+	},
+	{
+		path:       "fcode_exec_profile.go",
+		constraint: "//go:build warp_opcodeprofile\n// +build warp_opcodeprofile\n\n",
+		profile:    true,
+	},
+}
+
+func main() {
+	profilePath := flag.String("profile", "", "the opcode profile used to select superinstructions")
+	max := flag.Int("n", 24, "the maximum number of superinstructions")
+	threshold := flag.Float64("threshold", 0.002, "the minimum fraction of executed instructions covered by a superinstruction")
+	dir := flag.String("dir", ".", "the directory to which the generated files are written")
+	flag.Parse()
+
+	var supers []*superinstruction
+	if *profilePath != "" {
+		f, err := os.Open(*profilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		total, candidates, err := readProfile(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("reading %v: %v", *profilePath, err)
+		}
+		supers = selectSuperinstructions(candidates, *max, uint64(*threshold*float64(total)))
+	}
+
+	if err := writeOps(filepath.Join(*dir, "fcode_ops.go"), supers); err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range variants {
+		if err := writeExec(filepath.Join(*dir, v.path), v, supers); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// A superinstruction fuses a sequence of instructions in which each instruction consumes the result of its
+// predecessor.
+//
+// The fields of an fcode instruction can hold at most three operands: src1 and the low and high halves of src2. The
+// operands of a superinstruction are the operands of its first instruction followed by the operands of each later
+// instruction that are not produced by its predecessor. All operands must fit in a superinstruction's fields.
+type superinstruction struct {
+	ops      []string // The names of the fused instructions.
+	operands []int    // operands[i] is the operand of ops[i+1] that consumes the result of ops[i].
+	count    uint64   // The number of times the sequence executed in the profile.
+}
+
+// Name returns the name of the superinstruction's opcode, without the fop prefix.
+func (s *superinstruction) Name() string {
+	var b strings.Builder
+	b.WriteString(s.ops[0])
+	for i, name := range s.ops[1:] {
+		b.WriteString("Then")
+		b.WriteString(name)
+		if s.operands[i] == 2 {
+			b.WriteString("Rhs")
+		}
+	}
+	return b.String()
+}
+
+// prefix returns the superinstruction that fuses all but the last of the superinstruction's instructions.
+func (s *superinstruction) prefix() *superinstruction {
+	return &superinstruction{ops: s.ops[:len(s.ops)-1], operands: s.operands[:len(s.operands)-1]}
+}
+
+// Producer returns the name of the instruction that produces the value consumed by the superinstruction's last
+// instruction. The producer of a sequence of three instructions is itself a superinstruction.
+func (s *superinstruction) Producer() string {
+	if len(s.ops) == 2 {
+		return s.ops[0]
+	}
+	return s.prefix().Name()
+}
+
+// Consumer returns the name of the superinstruction's last instruction.
+func (s *superinstruction) Consumer() string {
+	return s.ops[len(s.ops)-1]
+}
+
+// Operand returns the operand of the superinstruction's last instruction that consumes its producer's result.
+func (s *superinstruction) Operand() int {
+	return s.operands[len(s.operands)-1]
+}
+
+// fields returns the kinds of the superinstruction's operands in the order in which they are stored. ok is false if
+// the instructions cannot be fused.
+func (s *superinstruction) fields() (kinds []operandKind, ok bool) {
+	for i, name := range s.ops {
+		o, known := ops[name]
+		if !known {
+			return nil, false
+		}
+		if i < len(s.ops)-1 && !o.result {
+			return nil, false
+		}
+		for j, kind := range o.operands {
+			if i > 0 && j == s.operands[i-1]-1 {
+				if kind != slot {
+					return nil, false
+				}
+				continue
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, len(kinds) <= 3
+}
+
+// readProfile reads an opcode profile. Each line of the profile is either the total number of instructions executed,
+//
+//	total <count>
+//
+// or the number of times a sequence of instructions executed:
+//
+//	<count> <name> <name>[:<operand>] [<name>[:<operand>]]
+//
+// The operand annotation is present if the instruction consumes the result of its predecessor, and gives the index of
+// the operand in WASM order, starting at 1. readProfile returns the sequences that are candidates for fusion.
+func readProfile(r io.Reader) (total uint64, candidates []*superinstruction, err error) {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "total" {
+			if len(fields) != 2 {
+				return 0, nil, fmt.Errorf("line %v: malformed total", line)
+			}
+			if total, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return 0, nil, fmt.Errorf("line %v: %w", line, err)
+			}
+			continue
+		}
+
+		if len(fields) < 3 || len(fields) > 4 {
+			return 0, nil, fmt.Errorf("line %v: malformed sequence", line)
+		}
+		count, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("line %v: %w", line, err)
+		}
+
+		candidate, fusible := &superinstruction{ops: []string{fields[1]}, count: count}, true
+		for _, f := range fields[2:] {
+			colon := strings.IndexByte(f, ':')
+			if colon == -1 {
+				fusible = false
+				break
+			}
+			operand, err := strconv.Atoi(f[colon+1:])
+			if err != nil {
+				return 0, nil, fmt.Errorf("line %v: %w", line, err)
+			}
+			candidate.ops, candidate.operands = append(candidate.ops, f[:colon]), append(candidate.operands, operand)
+		}
+		if _, ok := candidate.fields(); ok && fusible {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, nil, err
+	}
+	if total == 0 {
+		return 0, nil, fmt.Errorf("missing total")
+	}
+	return total, candidates, nil
+}
+
+// selectSuperinstructions selects at most max of the most frequent candidates that executed at least threshold times.
+// A sequence of three instructions is only selected along with the superinstruction for its first two instructions,
+// as the importer fuses instructions one at a time.
+func selectSuperinstructions(candidates []*superinstruction, max int, threshold uint64) []*superinstruction {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].count != candidates[j].count {
+			return candidates[i].count > candidates[j].count
+		}
+		return candidates[i].Name() < candidates[j].Name()
+	})
+
+	var selected []*superinstruction
+	names := map[string]bool{}
+	for _, c := range candidates {
+		if c.count < threshold || len(selected) == max {
+			break
+		}
+		if names[c.Name()] {
+			continue
+		}
+
+		if len(c.ops) == 3 && !names[c.Producer()] {
+			if len(selected)+2 > max {
+				continue
+			}
+			prefix := c.prefix()
+			selected, names[prefix.Name()] = append(selected, prefix), true
+		}
+		selected, names[c.Name()] = append(selected, c), true
+	}
+	return selected
+}
+
+// A fusible describes an instruction that may be fused into a superinstruction.
+type fusible struct {
+	Name       string
+	Operands   int
+	Immediates int
+	Store      bool
+	Result     bool
+}
+
+var constPattern = regexp.MustCompile(`(?m)^\tfop(\w+)\s`)
+
+func writeOps(path string, supers []*superinstruction) error {
+	var fusibles []fusible
+	for name, o := range ops {
+		f := fusible{Name: name, Operands: len(o.operands), Store: o.isStore(), Result: o.result}
+		for i, kind := range o.operands {
+			if kind == immediate {
+				f.Immediates |= 1 << i
+			}
+		}
+		fusibles = append(fusibles, f)
+	}
+	sort.Slice(fusibles, func(i, j int) bool { return fusibles[i].Name < fusibles[j].Name })
+	for _, s := range supers {
+		kinds, _ := s.fields()
+		f := fusible{Name: s.Name(), Operands: len(kinds), Result: ops[s.Consumer()].result}
+		for i, kind := range kinds {
+			if kind == immediate {
+				f.Immediates |= 1 << i
+			}
+		}
+		fusibles = append(fusibles, f)
+	}
+
+	var names []string
+	for _, m := range constPattern.FindAllStringSubmatch(opsTemplate, -1) {
+		if m[1] != "Super" {
+			names = append(names, m[1])
+		}
+	}
+	for _, s := range supers {
+		names = append(names, s.Name())
+	}
+
+	return render(path, opsTemplate, nil, map[string]interface{}{
+		"Constraint":        header,
+		"Superinstructions": supers,
+		"Fusible":           fusibles,
+		"Names":             names,
+	})
+}
+
+// A superCase is the implementation of a superinstruction in an execution loop.
+type superCase struct {
+	Name string
+	Body string
+}
+
+func writeExec(path string, v variant, supers []*superinstruction) error {
+	funcs := v.funcs()
+
+	cases := make([]superCase, len(supers))
+	for i, s := range supers {
+		body, err := s.body(funcs)
+		if err != nil {
+			return fmt.Errorf("%v: %w", s.Name(), err)
+		}
+		cases[i] = superCase{Name: s.Name(), Body: body}
+	}
+
+	return render(path, execTemplate, funcs, map[string]interface{}{
+		"Constraint":        header + v.constraint,
+		"Mmap":              v.mmap,
+		"Profile":           v.profile,
+		"Superinstructions": cases,
+	})
+}
+
+// render executes a template and writes the formatted result to the given path.
+func render(path, text string, funcs template.FuncMap, data interface{}) error {
+	t, err := template.New(filepath.Base(path)).Funcs(funcs).Parse(text)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return err
+	}
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting %v: %w", path, err)
+	}
+	return os.WriteFile(path, source, 0600)
+}
+
+// A valueKind determines how a value in a superinstruction's implementation is accessed.
+type valueKind int
+
+const (
+	slotValue      valueKind = iota // The value is in the frame slot named by the expression.
+	immediateValue                  // The value is the uint32 expression.
+	tempValue                       // The value is the uint64 expression.
+)
+
+// A value is an operand in a superinstruction's implementation.
+type value struct {
+	kind valueKind
+	expr string
+}
+
+// fieldExprs holds the expressions for the operands stored in an instruction's fields.
+var fieldExprs = []string{"instr.src1", "instr.Src2()", "instr.Src3()"}
+
+// body returns the implementation of the superinstruction.
+func (s *superinstruction) body(funcs template.FuncMap) (string, error) {
+	kinds, _ := s.fields()
+	fields := make([]value, len(kinds))
+	for i, kind := range kinds {
+		if kind == slot {
+			fields[i] = value{kind: slotValue, expr: fieldExprs[i]}
+		} else {
+			fields[i] = value{kind: immediateValue, expr: fieldExprs[i]}
+		}
+	}
+
+	var b strings.Builder
+	var result value
+	for i, name := range s.ops {
+		o := ops[name]
+
+		args := make([]value, len(o.operands))
+		for j := range args {
+			if i > 0 && j == s.operands[i-1]-1 {
+				args[j] = result
+			} else {
+				args[j], fields = fields[0], fields[1:]
+			}
+		}
+		data := map[string]value{"A": args[0]}
+		if len(args) > 1 {
+			data["B"] = args[1]
+		}
+
+		t, err := template.New(name).Funcs(funcs).Parse(o.template)
+		if err != nil {
+			return "", err
+		}
+		var expr strings.Builder
+		if err := t.Execute(&expr, data); err != nil {
+			return "", err
+		}
+
+		switch {
+		case i < len(s.ops)-1:
+			temp := fmt.Sprintf("t%d", i)
+			fmt.Fprintf(&b, "\t\t\t%s := %s\n", temp, expr.String())
+			result = value{kind: tempValue, expr: temp}
+		case o.result:
+			fmt.Fprintf(&b, "\t\t\tframe[instr.dest] = %s", expr.String())
+		default:
+			fmt.Fprintf(&b, "\t\t\t%s", expr.String())
+		}
+	}
+	return b.String(), nil
+}
+
+// conv converts a value to the given type.
+func conv(t string, v value) string {
+	switch v.kind {
+	case slotValue:
+		return fmt.Sprintf("%s(frame[%s])", t, v.expr)
+	case immediateValue:
+		if t == "uint32" {
+			return v.expr
+		}
+	}
+	return fmt.Sprintf("%s(%s)", t, v.expr)
+}
+
+// funcs returns the template functions for the variant.
+func (v variant) funcs() template.FuncMap {
+	load, store := portableLoad, portableStore
+	if v.mmap {
+		load, store = mmapLoad, mmapStore
+	}
+	return template.FuncMap{
+		"conv": conv,
+		"i32":  func(v value) string { return conv("int32", v) },
+		"u32":  func(v value) string { return conv("uint32", v) },
+		"i64":  func(v value) string { return conv("int64", v) },
+		"u64": func(v value) string {
+			switch v.kind {
+			case slotValue:
+				return fmt.Sprintf("frame[%s]", v.expr)
+			case tempValue:
+				return v.expr
+			}
+			return conv("uint64", v)
+		},
+		"load":  load,
+		"store": store,
+	}
+}
+
+// accessors maps the types of memory accesses to the names of the exec.Memory methods that perform them. Signed types
+// are converted from the corresponding unsigned type.
+var accessors = map[string]struct{ name, unsigned string }{
+	"byte":   {"Byte", ""},
+	"int8":   {"Byte", "byte"},
+	"uint16": {"Uint16", ""},
+	"int16":  {"Uint16", "uint16"},
+	"uint32": {"Uint32", ""},
+	"int32":  {"Uint32", "uint32"},
+	"uint64": {"Uint64", ""},
+}
+
+// portableLoad returns an expression that loads a value of type t from the given address and optional offset using
+// the methods of exec.Memory.
+func portableLoad(t, address string, offset ...string) (string, error) {
+	a, ok := accessors[t]
+	if !ok {
+		return "", fmt.Errorf("unsupported load type %v", t)
+	}
+	var expr string
+	if len(offset) == 0 {
+		expr = fmt.Sprintf("f.module.mem0.%sAt(%s)", a.name, address)
+	} else {
+		expr = fmt.Sprintf("f.module.mem0.%s(%s, %s)", a.name, address, offset[0])
+	}
+	if a.unsigned != "" {
+		expr = fmt.Sprintf("%s(%s)", t, expr)
+	}
+	return expr, nil
+}
+
+// portableStore returns a statement that stores a value of type t to the given address and optional offset using the
+// methods of exec.Memory.
+func portableStore(t, value, address string, offset ...string) (string, error) {
+	a, ok := accessors[t]
+	if !ok || a.unsigned != "" {
+		return "", fmt.Errorf("unsupported store type %v", t)
+	}
+	if len(offset) == 0 {
+		return fmt.Sprintf("f.module.mem0.Put%sAt(%s, %s)", a.name, value, address), nil
+	}
+	return fmt.Sprintf("f.module.mem0.Put%s(%s, %s, %s)", a.name, value, address, offset[0]), nil
+}
+
+// mmapPointer returns an expression for a pointer of type *t to the given address and optional offset in the
+// memory that starts at mem.
+func mmapPointer(t, address string, offset []string) (string, error) {
+	if _, ok := accessors[t]; !ok {
+		return "", fmt.Errorf("unsupported access type %v", t)
+	}
+	if len(offset) == 0 {
+		return fmt.Sprintf("(*%s)(unsafe.Pointer(mem + uintptr(%s)))", t, address), nil
+	}
+	return fmt.Sprintf("(*%s)(unsafe.Pointer(mem + uintptr(%s) + uintptr(%s)))", t, address, offset[0]), nil
+}
+
+// mmapLoad returns an expression that loads a value of type t from the given address and optional offset. The
+// memory must use a guard-page layout.
+func mmapLoad(t, address string, offset ...string) (string, error) {
+	p, err := mmapPointer(t, address, offset)
+	if err != nil {
+		return "", err
+	}
+	return "*" + p, nil
+}
+
+// mmapStore returns a statement that stores a value of type t to the given address and optional offset. The memory
+// must use a guard-page layout.
+func mmapStore(t, value, address string, offset ...string) (string, error) {
+	p, err := mmapPointer(t, address, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("*%s = %s", p, value), nil
+}
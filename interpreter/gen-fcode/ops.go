@@ -0,0 +1,97 @@
+package main
+
+// An operandKind determines where an operand of an fcode instruction is stored.
+type operandKind int
+
+const (
+	// slot operands are stored in the frame. The instruction holds the index of the operand's frame slot.
+	slot operandKind = iota
+	// immediate operands are stored in the instruction itself.
+	immediate
+)
+
+// An op describes an fcode instruction that may be fused into a superinstruction.
+//
+// The semantics of the instruction are given by a template that is expanded once for each superinstruction that
+// contains the instruction. The template's data has two fields, A and B, that hold the instruction's operands in WASM
+// order. Operands must be converted to the appropriate type using one of the i32, u32, i64, or u64 template functions,
+// or the conv function for other types. Memory is accessed using the load and store template functions.
+//
+// The template for an instruction that produces a result is an expression of type uint64. The template for a store is
+// a statement.
+type op struct {
+	operands []operandKind // The kinds of the instruction's operands, in WASM order.
+	result   bool          // True if the instruction produces a result.
+	template string        // The template for the instruction's semantics.
+}
+
+var (
+	binary  = []operandKind{slot, slot}
+	binaryI = []operandKind{slot, immediate}
+	unary   = []operandKind{slot}
+)
+
+// ops describes the instructions that may be fused into superinstructions. Only the forms of loads and stores that do
+// not have an offset are fusible, as the offset would occupy an operand field. Compares are not fusible, as they are
+// already merged with conditional branches by the fcode importer.
+var ops = map[string]op{
+	"I32Add":  {binary, true, "uint64({{i32 .A}} + {{i32 .B}})"},
+	"I32Sub":  {binary, true, "uint64({{i32 .A}} - {{i32 .B}})"},
+	"I32Mul":  {binary, true, "uint64({{i32 .A}} * {{i32 .B}})"},
+	"I32And":  {binary, true, "uint64({{i32 .A}} & {{i32 .B}})"},
+	"I32Or":   {binary, true, "uint64({{i32 .A}} | {{i32 .B}})"},
+	"I32Xor":  {binary, true, "uint64({{i32 .A}} ^ {{i32 .B}})"},
+	"I32Shl":  {binary, true, "uint64({{i32 .A}} << ({{i32 .B}} & 31))"},
+	"I32ShrS": {binary, true, "uint64({{i32 .A}} >> ({{i32 .B}} & 31))"},
+	"I32ShrU": {binary, true, "uint64({{u32 .A}} >> ({{u32 .B}} & 31))"},
+
+	"I32AddI":  {binaryI, true, "uint64({{i32 .A}} + {{i32 .B}})"},
+	"I32SubI":  {binaryI, true, "uint64({{i32 .A}} - {{i32 .B}})"},
+	"I32MulI":  {binaryI, true, "uint64({{i32 .A}} * {{i32 .B}})"},
+	"I32AndI":  {binaryI, true, "uint64({{i32 .A}} & {{i32 .B}})"},
+	"I32OrI":   {binaryI, true, "uint64({{i32 .A}} | {{i32 .B}})"},
+	"I32XorI":  {binaryI, true, "uint64({{i32 .A}} ^ {{i32 .B}})"},
+	"I32ShlI":  {binaryI, true, "uint64({{i32 .A}} << ({{i32 .B}} & 31))"},
+	"I32ShrSI": {binaryI, true, "uint64({{i32 .A}} >> ({{i32 .B}} & 31))"},
+	"I32ShrUI": {binaryI, true, "uint64({{u32 .A}} >> ({{u32 .B}} & 31))"},
+
+	"I64Add":  {binary, true, "uint64({{i64 .A}} + {{i64 .B}})"},
+	"I64Sub":  {binary, true, "uint64({{i64 .A}} - {{i64 .B}})"},
+	"I64Mul":  {binary, true, "uint64({{i64 .A}} * {{i64 .B}})"},
+	"I64And":  {binary, true, "uint64({{i64 .A}} & {{i64 .B}})"},
+	"I64Or":   {binary, true, "uint64({{i64 .A}} | {{i64 .B}})"},
+	"I64Xor":  {binary, true, "uint64({{i64 .A}} ^ {{i64 .B}})"},
+	"I64Shl":  {binary, true, "uint64({{i64 .A}} << ({{i64 .B}} & 63))"},
+	"I64ShrS": {binary, true, "uint64({{i64 .A}} >> ({{i64 .B}} & 63))"},
+	"I64ShrU": {binary, true, "{{u64 .A}} >> ({{u64 .B}} & 63)"},
+
+	"I32WrapI64":    {unary, true, "uint64(int32({{i64 .A}}))"},
+	"I64ExtendI32S": {unary, true, "uint64(int64({{i32 .A}}))"},
+	"I64ExtendI32U": {unary, true, "uint64(int64({{u32 .A}}))"},
+
+	"I32LoadI":    {unary, true, `uint64({{load "uint32" (u32 .A)}})`},
+	"I64LoadI":    {unary, true, `{{load "uint64" (u32 .A)}}`},
+	"I32Load8SI":  {unary, true, `uint64(int32({{load "int8" (u32 .A)}}))`},
+	"I32Load8UI":  {unary, true, `uint64(int32({{load "byte" (u32 .A)}}))`},
+	"I32Load16SI": {unary, true, `uint64(int32({{load "int16" (u32 .A)}}))`},
+	"I32Load16UI": {unary, true, `uint64(int32({{load "uint16" (u32 .A)}}))`},
+	"I64Load8SI":  {unary, true, `uint64(int64({{load "int8" (u32 .A)}}))`},
+	"I64Load8UI":  {unary, true, `uint64(int64({{load "byte" (u32 .A)}}))`},
+	"I64Load16SI": {unary, true, `uint64(int64({{load "int16" (u32 .A)}}))`},
+	"I64Load16UI": {unary, true, `uint64(int64({{load "uint16" (u32 .A)}}))`},
+	"I64Load32SI": {unary, true, `uint64(int64({{load "int32" (u32 .A)}}))`},
+	"I64Load32UI": {unary, true, `uint64(int64({{load "uint32" (u32 .A)}}))`},
+
+	"I32StoreI":   {binary, false, `{{store "uint32" (u32 .B) (u32 .A)}}`},
+	"I64StoreI":   {binary, false, `{{store "uint64" (u64 .B) (u32 .A)}}`},
+	"I32Store8I":  {binary, false, `{{store "byte" (conv "byte" .B) (u32 .A)}}`},
+	"I32Store16I": {binary, false, `{{store "uint16" (conv "uint16" .B) (u32 .A)}}`},
+	"I64Store8I":  {binary, false, `{{store "byte" (conv "byte" .B) (u32 .A)}}`},
+	"I64Store16I": {binary, false, `{{store "uint16" (conv "uint16" .B) (u32 .A)}}`},
+	"I64Store32I": {binary, false, `{{store "uint32" (u32 .B) (u32 .A)}}`},
+}
+
+// isStore returns true if the op is a store. The operands of a store are its address and its value.
+func (o *op) isStore() bool {
+	return !o.result
+}
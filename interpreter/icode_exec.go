@@ -796,20 +796,33 @@ func (f *frame) runICode(fn *function) int {
 			}
 			f.popContinuation()
 
+		// Branches to loops are the safepoints of debugged functions.
 		case code.OpBr:
 			ip = f.branch(instr.Labelidx())
+			if f.module.debugger != nil && f.icodeSafepoint(fn, ip) {
+				return ip
+			}
 			continue
 		case code.OpBrIf:
 			if f.popBool() {
 				ip = f.branch(instr.Labelidx())
+				if f.module.debugger != nil && f.icodeSafepoint(fn, ip) {
+					return ip
+				}
 				continue
 			}
 		case code.OpBrTable:
 			if li := int(f.popI32()); li >= 0 && li < len(instr.Labels) {
 				ip = f.branch(instr.Labels[li])
+				if f.module.debugger != nil && f.icodeSafepoint(fn, ip) {
+					return ip
+				}
 				continue
 			}
 			ip = f.branch(instr.Default())
+			if f.module.debugger != nil && f.icodeSafepoint(fn, ip) {
+				return ip
+			}
 			continue
 
 		case code.OpReturn:
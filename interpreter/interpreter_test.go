@@ -2,12 +2,23 @@ package interpreter
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/disasm"
+	"github.com/pgavlin/warp/wasi"
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 )
@@ -79,7 +90,7 @@ var EmptyFunction = NewModuleDefinition(&wasm.Module{
 	Code: &wasm.SectionCode{
 		Bodies: []wasm.FunctionBody{{Code: []byte{code.OpReturn, code.OpEnd}}},
 	},
-})
+}, nil)
 
 var FibRecursive = NewModuleDefinition(&wasm.Module{
 	Version: 1,
@@ -170,4 +181,1450 @@ var FibRecursive = NewModuleDefinition(&wasm.Module{
 			},
 		},
 	},
-})
+}, nil)
+
+func TestWatchpoints(t *testing.T) {
+	for _, codeKind := range []CodeKind{MixedCode, ICodeOnly, FCodeOnly} {
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(WatchedMemory, &Options{CodeKind: codeKind}),
+		})
+
+		mod, err := store.InstantiateModule("test")
+		if !assert.NoError(t, err) {
+			return
+		}
+		mem, err := mod.GetMemory("memory")
+		if !assert.NoError(t, err) {
+			return
+		}
+		main, err := mod.GetFunction("main")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var accesses, changes []exec.MemoryAccess
+		w := mem.Watch(16, 4, exec.WatchReadWrite, func(_ *exec.Thread, access *exec.MemoryAccess) {
+			accesses = append(accesses, *access)
+		})
+		mem.Watch(16, 4, exec.WatchChange, func(_ *exec.Thread, access *exec.MemoryAccess) {
+			changes = append(changes, *access)
+		})
+
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		main.UncheckedCall(&thread, nil, returns)
+		assert.Equal(t, []uint64{42}, returns)
+
+		assert.Equal(t, []exec.MemoryAccess{
+			{Kind: exec.WatchWrite, IP: 2, Address: 16, Size: 4, OldValue: 0, NewValue: 42},
+			{Kind: exec.WatchWrite, IP: 5, Address: 16, Size: 4, OldValue: 42, NewValue: 42},
+			{Kind: exec.WatchWrite, IP: 11, Address: 18, Size: 1, OldValue: 0, NewValue: 0},
+			{Kind: exec.WatchRead, IP: 13, Address: 16, Size: 4, OldValue: 42, NewValue: 42},
+		}, accesses)
+		assert.Equal(t, []exec.MemoryAccess{
+			{Kind: exec.WatchWrite, IP: 2, Address: 16, Size: 4, OldValue: 0, NewValue: 42},
+		}, changes)
+
+		mem.Unwatch(w)
+		accesses, changes = nil, nil
+		main.UncheckedCall(&thread, nil, returns)
+		assert.Len(t, accesses, 0)
+		assert.Len(t, changes, 0)
+	}
+}
+
+var WatchedMemory = &wasm.Module{
+	Version: 1,
+
+	Types: &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			{Form: 0x60, ParamTypes: []wasm.ValueType{}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+		},
+	},
+	Function: &wasm.SectionFunctions{
+		Types: []uint32{0},
+	},
+	Memory: &wasm.SectionMemories{
+		Entries: []wasm.Memory{
+			{Limits: wasm.ResizableLimits{Initial: 1}},
+		},
+	},
+	Export: &wasm.SectionExports{
+		Entries: []wasm.ExportEntry{
+			{FieldStr: "memory", Kind: wasm.ExternalMemory, Index: 0},
+			{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 0},
+		},
+	},
+	Code: &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{
+			{
+				Code: expr(
+					code.I32Const(16),
+					code.I32Const(42),
+					code.I32Store(0, 2),
+					code.I32Const(16),
+					code.I32Const(42),
+					code.I32Store(0, 2),
+					code.I32Const(24),
+					code.I32Const(7),
+					code.I32Store(0, 2),
+					code.I32Const(16),
+					code.I32Const(0),
+					code.I32Store8(2, 0),
+					code.I32Const(16),
+					code.I32Load(0, 2),
+					code.End(),
+				),
+			},
+		},
+	},
+}
+
+type interceptorHost struct{}
+
+func (interceptorHost) Add(x, y int32) int32 {
+	return x + y
+}
+
+func (interceptorHost) Fail() int32 {
+	panic(exec.TrapUnreachable)
+}
+
+type recordingInterceptor struct {
+	log []string
+}
+
+func (i *recordingInterceptor) Before(thread *exec.Thread, call *exec.Call, args []uint64) error {
+	i.log = append(i.log, fmt.Sprintf("before %v.%v%v", call.Module, call.Name, args))
+	if call.Name == "add" {
+		args[0] *= 2
+	}
+	return nil
+}
+
+func (i *recordingInterceptor) After(thread *exec.Thread, call *exec.Call, results []uint64, err error) error {
+	i.log = append(i.log, fmt.Sprintf("after %v.%v%v %v", call.Module, call.Name, results, err))
+	if err == exec.TrapUnreachable {
+		results[0] = 10
+		return nil
+	}
+	return err
+}
+
+func TestCallInterceptors(t *testing.T) {
+	for _, codeKind := range []CodeKind{MixedCode, ICodeOnly, FCodeOnly} {
+		store := exec.NewStore(exec.MapResolver{
+			"env": exec.NewHostModuleDefinition(func() (interceptorHost, error) {
+				return interceptorHost{}, nil
+			}),
+			"test": NewModuleDefinition(InterceptedImports, &Options{CodeKind: codeKind}),
+		})
+
+		var interceptor recordingInterceptor
+		store.Intercept(&interceptor)
+
+		mod, err := store.InstantiateModule("test")
+		if !assert.NoError(t, err) {
+			return
+		}
+		main, err := mod.GetFunction("main")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		main.UncheckedCall(&thread, nil, returns)
+		assert.Equal(t, []uint64{17}, returns)
+
+		assert.Equal(t, []string{
+			"before test.main[]",
+			"before env.add[2 3]",
+			"after env.add[7] <nil>",
+			"before env.fail[]",
+			"after env.fail[0] unreachable",
+			"after test.main[17] <nil>",
+		}, interceptor.log)
+	}
+}
+
+var InterceptedImports = &wasm.Module{
+	Version: 1,
+
+	Types: &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			{Form: 0x60, ParamTypes: []wasm.ValueType{}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+		},
+	},
+	Import: &wasm.SectionImports{
+		Entries: []wasm.ImportEntry{
+			{ModuleName: "env", FieldName: "add", Type: wasm.FuncImport{Type: 1}},
+			{ModuleName: "env", FieldName: "fail", Type: wasm.FuncImport{Type: 0}},
+		},
+	},
+	Function: &wasm.SectionFunctions{
+		Types: []uint32{0},
+	},
+	Export: &wasm.SectionExports{
+		Entries: []wasm.ExportEntry{
+			{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 2},
+		},
+	},
+	Code: &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{
+			{
+				Code: expr(
+					code.I32Const(2),
+					code.I32Const(3),
+					code.Call(0),
+					code.Call(1),
+					code.I32Add(),
+					code.End(),
+				),
+			},
+		},
+	},
+}
+
+type asyncHost struct {
+	Read *exec.AsyncHostFunction
+}
+
+func TestAsyncCall(t *testing.T) {
+	reads := 0
+	read := exec.NewAsyncHostFunction(AsyncImports.Types.Entries[0], func(_ *exec.Thread, _, returns []uint64) bool {
+		reads++
+		if reads == 1 {
+			return false
+		}
+		returns[0] = 20
+		return true
+	})
+
+	store := exec.NewStore(exec.MapResolver{
+		"env": exec.NewHostModuleDefinition(func() (*asyncHost, error) {
+			return &asyncHost{Read: read}, nil
+		}),
+		"test": NewModuleDefinition(AsyncImports, nil),
+	})
+
+	mod, err := store.InstantiateModule("test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	main, err := mod.GetFunction("main")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	thread := exec.NewThread(0)
+	returns := make([]uint64, 1)
+	call, err := StartAsync(&thread, main, nil, returns)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, call.Done())
+	assert.Equal(t, 1, reads)
+
+	done := make(chan bool)
+	go func() {
+		assert.NoError(t, call.Resume([]uint64{10}))
+		done <- call.Done()
+	}()
+	assert.True(t, <-done)
+	assert.Equal(t, 2, reads)
+	assert.Equal(t, []uint64{32}, returns)
+
+	// Synchronous callers cannot suspend.
+	reads = 0
+	assert.PanicsWithValue(t, exec.ErrWouldSuspend, func() {
+		main.UncheckedCall(&thread, nil, returns)
+	})
+}
+
+func TestAsyncCallInterceptors(t *testing.T) {
+	reads := 0
+	read := exec.NewAsyncHostFunction(AsyncImports.Types.Entries[0], func(_ *exec.Thread, _, returns []uint64) bool {
+		reads++
+		switch reads {
+		case 1:
+			return false
+		case 2:
+			panic(exec.TrapUnreachable)
+		default:
+			returns[0] = 20
+			return true
+		}
+	})
+
+	store := exec.NewStore(exec.MapResolver{
+		"env": exec.NewHostModuleDefinition(func() (*asyncHost, error) {
+			return &asyncHost{Read: read}, nil
+		}),
+		"test": NewModuleDefinition(AsyncImports, nil),
+	})
+
+	var interceptor recordingInterceptor
+	store.Intercept(&interceptor)
+
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+	main, err := mod.GetFunction("main")
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	returns := make([]uint64, 1)
+	call, err := StartAsync(&thread, main, nil, returns)
+	require.NoError(t, err)
+	assert.False(t, call.Done())
+	assert.Equal(t, []string{
+		"before test.main[]",
+		"before env.read[]",
+	}, interceptor.log)
+
+	// The interceptor replaces the trap raised by the second read with a result of 10.
+	require.NoError(t, call.Resume([]uint64{20}))
+	assert.True(t, call.Done())
+	assert.Equal(t, []uint64{32}, returns)
+	assert.Equal(t, []string{
+		"before test.main[]",
+		"before env.read[]",
+		"after env.read[20] <nil>",
+		"before env.read[]",
+		"after env.read[0] unreachable",
+		"after test.main[32] <nil>",
+	}, interceptor.log)
+}
+
+func TestAsyncCallTraps(t *testing.T) {
+	reads := 0
+	read := exec.NewAsyncHostFunction(AsyncImports.Types.Entries[0], func(_ *exec.Thread, _, returns []uint64) bool {
+		reads++
+		switch reads {
+		case 1:
+			panic(exec.TrapUnreachable)
+		case 2:
+			return false
+		default:
+			panic(exec.TrapIntegerDivideByZero)
+		}
+	})
+
+	store := exec.NewStore(exec.MapResolver{
+		"env": exec.NewHostModuleDefinition(func() (*asyncHost, error) {
+			return &asyncHost{Read: read}, nil
+		}),
+		"test": NewModuleDefinition(AsyncImports, nil),
+	})
+	mod, err := store.InstantiateModule("test")
+	require.NoError(t, err)
+	main, err := mod.GetFunction("main")
+	require.NoError(t, err)
+
+	thread := exec.NewThread(0)
+	returns := make([]uint64, 1)
+
+	// A trap raised before the call suspends is returned by StartAsync.
+	_, err = StartAsync(&thread, main, nil, returns)
+	assert.Equal(t, exec.TrapUnreachable, err)
+
+	// A trap raised after the call resumes is returned by Resume.
+	call, err := StartAsync(&thread, main, nil, returns)
+	require.NoError(t, err)
+	assert.Equal(t, exec.TrapIntegerDivideByZero, call.Resume([]uint64{10}))
+	assert.True(t, call.Done())
+	assert.Error(t, call.Resume([]uint64{10}))
+}
+
+var AsyncImports = &wasm.Module{
+	Version: 1,
+
+	Types: &wasm.SectionTypes{
+		Entries: []wasm.FunctionSig{
+			{Form: 0x60, ParamTypes: []wasm.ValueType{}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+		},
+	},
+	Import: &wasm.SectionImports{
+		Entries: []wasm.ImportEntry{
+			{ModuleName: "env", FieldName: "read", Type: wasm.FuncImport{Type: 0}},
+		},
+	},
+	Function: &wasm.SectionFunctions{
+		Types: []uint32{0, 0},
+	},
+	Export: &wasm.SectionExports{
+		Entries: []wasm.ExportEntry{
+			{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 2},
+		},
+	},
+	Code: &wasm.SectionCode{
+		Bodies: []wasm.FunctionBody{
+			{
+				Code: expr(
+					code.Call(0),
+					code.I32Const(1),
+					code.I32Add(),
+					code.End(),
+				),
+			},
+			{
+				Code: expr(
+					code.Call(1),
+					code.Call(1),
+					code.I32Add(),
+					code.End(),
+				),
+			},
+		},
+	},
+}
+
+func TestTieringOptions(t *testing.T) {
+	body := []code.Instruction{code.I32Const(0)}
+	for i := 0; i < 10; i++ {
+		body = append(body, code.I32Const(1), code.I32Add())
+	}
+	body = append(body, code.End())
+
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 0},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{{Code: expr(body...)}},
+		},
+	}
+
+	// Background compilation does not start if its context is already done.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name    string
+		options *Options
+		kinds   []functionKind
+	}{
+		{"default", nil, []functionKind{functionKindCountingICode, functionKindCountingICode, functionKindFCode}},
+		{"threshold", &Options{TierUpThreshold: 3}, []functionKind{functionKindCountingICode, functionKindCountingICode, functionKindCountingICode, functionKindCountingICode, functionKindFCode}},
+		{"icode", &Options{CodeKind: ICodeOnly, Compile: CompileParallel}, []functionKind{functionKindICode, functionKindICode, functionKindICode}},
+		{"fcode", &Options{CodeKind: FCodeOnly}, []functionKind{functionKindFCode}},
+		{"parallel", &Options{Compile: CompileParallel}, []functionKind{functionKindFCode}},
+		{"background", &Options{Compile: CompileBackground}, []functionKind{functionKindFCode}},
+		{"cancelled", &Options{Compile: CompileBackground, Context: cancelled}, []functionKind{functionKindCountingICode, functionKindCountingICode, functionKindFCode}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := exec.NewStore(exec.MapResolver{
+				"test": NewModuleDefinition(module, c.options),
+			})
+
+			mod, err := store.InstantiateModule("test")
+			if !assert.NoError(t, err) {
+				return
+			}
+			main, err := mod.GetFunction("main")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			fn := main.(*function)
+			if c.options != nil && c.options.Compile == CompileBackground {
+				if c.options.Context != nil {
+					assert.Nil(t, fn.module.precompiled)
+				} else {
+					for fn.module.precompiled[0].Load() == nil {
+						runtime.Gosched()
+					}
+				}
+			}
+
+			thread := exec.NewThread(0)
+			for _, kind := range c.kinds {
+				returns := make([]uint64, 1)
+				main.UncheckedCall(&thread, nil, returns)
+				assert.Equal(t, []uint64{10}, returns)
+				assert.Equal(t, kind, fn.kind)
+			}
+		})
+	}
+}
+
+func TestCompileAllCancelled(t *testing.T) {
+	// compileAll stops handing out functions once done is closed.
+	const n = 100
+	done, compiled := make(chan struct{}), 0
+	compileAll(1, n, done, func(i int) {
+		if i == 0 {
+			close(done)
+		}
+		compiled++
+	})
+	assert.Less(t, compiled, n)
+}
+
+func TestFCodeCache(t *testing.T) {
+	body := []code.Instruction{code.I32Const(0)}
+	for i := 0; i < 10; i++ {
+		body = append(body, code.I32Const(1), code.I32Add())
+	}
+	body = append(body, code.End())
+
+	literal := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 0},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{{Code: expr(body...)}},
+		},
+	}
+	literal.Sections = []wasm.Section{literal.Types, literal.Function, literal.Export, literal.Code}
+
+	var buf bytes.Buffer
+	if !assert.NoError(t, wasm.EncodeModule(&buf, literal)) {
+		return
+	}
+
+	options := &Options{CacheDir: t.TempDir()}
+
+	// run instantiates the module, checks that its function was precompiled, and calls it.
+	run := func(t *testing.T) *function {
+		module, err := wasm.DecodeModule(bytes.NewReader(buf.Bytes()))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, options),
+		})
+		mod, err := store.InstantiateModule("test")
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		main, err := mod.GetFunction("main")
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		fn := main.(*function)
+		assert.Equal(t, functionKind(functionKindFCode), fn.kind)
+
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		main.UncheckedCall(&thread, nil, returns)
+		assert.Equal(t, []uint64{10}, returns)
+		return fn
+	}
+
+	entries := func(t *testing.T) []string {
+		paths, err := filepath.Glob(filepath.Join(options.CacheDir, "*"))
+		assert.NoError(t, err)
+		return paths
+	}
+
+	// The first run should compile the module and populate the cache.
+	compiled := run(t)
+	if compiled == nil {
+		return
+	}
+	paths := entries(t)
+	if !assert.Len(t, paths, 1) || !assert.True(t, strings.HasSuffix(paths[0], ".fcode")) {
+		return
+	}
+	entry, err := ioutil.ReadFile(paths[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The entry should decode to the same code that was compiled.
+	decoded, err := wasm.DecodeModule(bytes.NewReader(buf.Bytes()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	def := NewModuleDefinition(decoded, nil).(*moduleDefinition)
+	hash, ok := def.contentHash()
+	if !assert.True(t, ok) || !assert.Equal(t, hash+".fcode", filepath.Base(paths[0])) {
+		return
+	}
+	template := &module{functions: []function{{}}}
+	functions, err := decodeCacheEntry(entry, template, hash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, compiled.numLocals, functions[0].numLocals)
+		assert.Equal(t, compiled.metrics, functions[0].metrics)
+		assert.Equal(t, compiled.icode, functions[0].icode)
+		assert.Equal(t, compiled.fcode, functions[0].fcode)
+		assert.Equal(t, compiled.labels, functions[0].labels)
+		assert.Equal(t, compiled.switches, functions[0].switches)
+	}
+
+	// Subsequent runs should read the entry from the cache rather than rewriting it.
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if !assert.NoError(t, os.Chtimes(paths[0], past, past)) {
+		return
+	}
+	run(t)
+	if info, err := os.Stat(paths[0]); assert.NoError(t, err) {
+		assert.True(t, info.ModTime().Equal(past))
+	}
+
+	// Corrupt and stale entries should be ignored and replaced. Entries written for a different instruction set are
+	// stale even if they were written by the same version of warp.
+	stale := encodeCacheEntry(functions, "stale")
+	fingerprint := cacheFingerprint
+	cacheFingerprint = "other"
+	foreign := encodeCacheEntry(functions, hash)
+	cacheFingerprint = fingerprint
+	corrupt := append([]byte(nil), entry...)
+	corrupt[len(cacheMagic)+4] ^= 0xff
+	for _, bad := range [][]byte{corrupt, entry[:len(entry)/2], stale, foreign, nil} {
+		if !assert.NoError(t, ioutil.WriteFile(paths[0], bad, 0600)) {
+			return
+		}
+		_, err := decodeCacheEntry(bad, template, hash)
+		assert.Error(t, err)
+
+		run(t)
+		rewritten, err := ioutil.ReadFile(paths[0])
+		if assert.NoError(t, err) {
+			assert.Equal(t, entry, rewritten)
+		}
+		assert.Len(t, entries(t), 1)
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0, 1},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "add", Kind: wasm.ExternalFunction, Index: 0},
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 1},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.LocalGet(1),
+						code.I32Add(),
+						code.End(),
+					),
+				},
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.I32Const(1),
+						code.Call(0), // add
+						code.I32Const(2),
+						code.I32Add(),
+						code.End(),
+					),
+				},
+			},
+		},
+	}
+
+	type stop struct {
+		reason   StopReason
+		function uint32
+		ip       int
+		depth    int
+	}
+
+	// newMain instantiates the module with the given debugger and returns its main function.
+	newMain := func(t *testing.T, options *Options) exec.Function {
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, options),
+		})
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		main, err := mod.GetFunction("main")
+		require.NoError(t, err)
+		return main
+	}
+
+	call := func(main exec.Function, arg uint64) uint64 {
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		main.UncheckedCall(&thread, []uint64{arg}, returns)
+		return returns[0]
+	}
+
+	t.Run("stepping", func(t *testing.T) {
+		var stops []stop
+		modes := []ResumeMode{StepInto, StepInto, StepInto, StepInto, StepOut, StepOver, Continue}
+
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.Function, top.IP, len(s.Frames)})
+
+			switch len(stops) {
+			case 1:
+				assert.Equal(t, []uint64{40}, top.Locals)
+				assert.Empty(t, top.Stack)
+				name, ok := top.FunctionName()
+				assert.True(t, ok)
+				assert.Equal(t, "main", name)
+			case 3:
+				assert.Equal(t, []uint64{40, 1}, top.Stack)
+			case 4:
+				assert.Equal(t, []uint64{40, 1}, top.Locals)
+				assert.Equal(t, 2, s.Frames[1].IP)
+			case 6:
+				assert.Equal(t, []uint64{41}, top.Stack)
+			}
+
+			mode := modes[0]
+			modes = modes[1:]
+			return mode
+		})
+		d.SetBreakpoint(1, 0)
+
+		main := newMain(t, &Options{Debugger: d})
+		assert.Equal(t, uint64(43), call(main, 40))
+		assert.Equal(t, []stop{
+			{StopBreakpoint, 1, 0, 1},
+			{StopStep, 1, 1, 1},
+			{StopStep, 1, 2, 1},
+			{StopStep, 0, 0, 2},
+			{StopStep, 0, 1, 2},
+			{StopStep, 1, 3, 1},
+			{StopStep, 1, 4, 1},
+		}, stops)
+	})
+
+	t.Run("step over", func(t *testing.T) {
+		var stops []stop
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.Function, top.IP, len(s.Frames)})
+			if len(stops) < 3 {
+				return StepOver
+			}
+			return Continue
+		})
+		d.SetBreakpoint(1, 1)
+
+		main := newMain(t, &Options{Debugger: d})
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Equal(t, []stop{
+			{StopBreakpoint, 1, 1, 1},
+			{StopStep, 1, 2, 1},
+			{StopStep, 1, 3, 1},
+		}, stops)
+	})
+
+	t.Run("fcode", func(t *testing.T) {
+		var stops []stop
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.Function, top.IP, len(s.Frames)})
+
+			// The caller executes as fcode, so its IP is unknown.
+			assert.Equal(t, -1, s.Frames[1].IP)
+			assert.Nil(t, s.Frames[1].Locals)
+
+			top.Locals[0] = 100
+			return Continue
+		})
+
+		main := newMain(t, &Options{CodeKind: FCodeOnly, Debugger: d})
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Equal(t, functionKind(functionKindFCode), main.(*function).kind)
+		assert.Empty(t, stops)
+
+		// Setting a breakpoint in a function that has been compiled to fcode should cause it to execute as icode.
+		b, err := d.SetFunctionBreakpoint("add", 0)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(103), call(main, 0))
+		assert.Equal(t, []stop{{StopBreakpoint, 0, 0, 2}}, stops)
+
+		// Clearing the breakpoint should return the function to fcode.
+		d.ClearBreakpoint(b)
+		assert.Empty(t, d.Breakpoints())
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Len(t, stops, 1)
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		var stops []stop
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.Function, top.IP, len(s.Frames)})
+			return Continue
+		})
+
+		main := newMain(t, &Options{Debugger: d})
+		d.Pause()
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Equal(t, []stop{{StopPause, 1, 0, 1}}, stops)
+	})
+
+	t.Run("pause after push", func(t *testing.T) {
+		var stops []stop
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.Function, top.IP, len(s.Frames)})
+			return Continue
+		})
+
+		main := newMain(t, &Options{CodeKind: FCodeOnly, Debugger: d}).(*function)
+		assert.Equal(t, uint64(3), call(main, 0))
+
+		// Pause between pushing main's frame and running it. The frame was pushed for fcode, so main must run as fcode,
+		// and execution stops when main calls add.
+		thread := exec.NewThread(0)
+		var m machine
+		m.init(&thread)
+		caller := m.push(&function{metrics: code.Metrics{MaxStackDepth: 1, MaxNesting: 1}, kind: functionKindVirtual})
+		caller.pushn([]uint64{0})
+
+		callee := m.push(main)
+		d.Pause()
+		assert.NotPanics(t, func() { d.run(callee, main) })
+		m.pop(main)
+		assert.Equal(t, []stop{{StopPause, 0, 0, 2}}, stops)
+
+		assert.Equal(t, uint64(3), call(main, 0))
+		assert.Len(t, stops, 1)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		d := NewDebugger(nil)
+		newMain(t, &Options{Debugger: d})
+
+		_, err := d.SetFunctionBreakpoint("missing", 0)
+		assert.Error(t, err)
+		_, err = d.SetLineBreakpoint("main.c", 1)
+		assert.Error(t, err)
+	})
+}
+
+type loopHost struct {
+	start func()
+}
+
+func (h *loopHost) Start() {
+	h.start()
+}
+
+func TestDebuggerLoopSafepoints(t *testing.T) {
+	// main spins in a loop without calls until its first local is cleared. The loop runs with a value on the stack
+	// beneath it and inside an enclosing block so that switching to the stepping path must rebuild both.
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60},
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Import: &wasm.SectionImports{
+			Entries: []wasm.ImportEntry{
+				{ModuleName: "env", FieldName: "start", Type: wasm.FuncImport{Type: 0}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{1},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 1},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{
+				{
+					Locals: []wasm.LocalEntry{{Count: 1, Type: wasm.ValueTypeI32}},
+					Code: expr(
+						code.Call(0), // start
+						code.I32Const(7),
+						code.Block(code.BlockTypeEmpty),
+						code.Loop(code.BlockTypeEmpty),
+						code.LocalGet(1),
+						code.I32Const(1),
+						code.I32Add(),
+						code.LocalSet(1),
+						code.LocalGet(0),
+						code.BrIf(0),
+						code.End(),
+						code.End(),
+						code.LocalGet(1),
+						code.I32Add(),
+						code.End(),
+					),
+				},
+			},
+		},
+	}
+
+	type stop struct {
+		reason StopReason
+		ip     int
+		stack  []uint64
+	}
+
+	// run calls main with a debugger whose stops are triggered by interrupt once the loop is about to start. Each
+	// stop clears main's first local so that the loop exits, and the first stop steps once before continuing.
+	run := func(t *testing.T, codeKind CodeKind, interrupt func(d *Debugger)) ([]stop, uint64, uint64) {
+		var stops []stop
+		var count uint64
+		d := NewDebugger(func(s *Stop) ResumeMode {
+			top := s.Frames[0]
+			stops = append(stops, stop{s.Reason, top.IP, append([]uint64(nil), top.Stack...)})
+			assert.Len(t, s.Frames, 1)
+
+			top.Locals[0], count = 0, top.Locals[1]
+			if len(stops) == 1 {
+				return StepInto
+			}
+			return Continue
+		})
+
+		store := exec.NewStore(exec.MapResolver{
+			"env": exec.NewHostModuleDefinition(func() (*loopHost, error) {
+				return &loopHost{start: func() {
+					go func() {
+						time.Sleep(time.Millisecond)
+						interrupt(d)
+					}()
+				}}, nil
+			}),
+			"test": NewModuleDefinition(module, &Options{CodeKind: codeKind, Debugger: d}),
+		})
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		main, err := mod.GetFunction("main")
+		require.NoError(t, err)
+
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		main.UncheckedCall(&thread, []uint64{1}, returns)
+		return stops, count, returns[0]
+	}
+
+	kinds := []struct {
+		name string
+		kind CodeKind
+	}{
+		{"mixed", MixedCode},
+		{"icode", ICodeOnly},
+		{"fcode", FCodeOnly},
+	}
+	for _, k := range kinds {
+		codeKind := k.kind
+		t.Run("pause/"+k.name, func(t *testing.T) {
+			stops, count, result := run(t, codeKind, (*Debugger).Pause)
+
+			// The loop stops at its top, then executes one more iteration.
+			assert.Equal(t, []stop{
+				{StopPause, 3, []uint64{7}},
+				{StopStep, 4, []uint64{7}},
+			}, stops)
+			assert.Equal(t, 7+count+1, result)
+		})
+
+		t.Run("breakpoint/"+k.name, func(t *testing.T) {
+			stops, count, result := run(t, codeKind, func(d *Debugger) { d.SetBreakpoint(1, 8) })
+
+			// The loop stops at the breakpoint, then exits.
+			assert.Equal(t, []stop{
+				{StopBreakpoint, 8, []uint64{7}},
+				{StopStep, 9, []uint64{7, 0}},
+			}, stops)
+			assert.Equal(t, 7+count, result)
+		})
+	}
+}
+
+func TestDebuggerLines(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "wasi", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	module, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+
+	type stop struct {
+		function string
+		file     string
+		line     int
+	}
+
+	var stops []stop
+	d := NewDebugger(func(s *Stop) ResumeMode {
+		top := s.Frames[0]
+		name, _ := top.FunctionName()
+		file, line, _ := top.SourceLocation()
+		stops = append(stops, stop{name, filepath.Base(file), line})
+		return Continue
+	})
+	def := NewModuleDefinition(module, &Options{Debugger: d})
+
+	// std::io::stdio::_print
+	breakpoints, err := d.SetLineBreakpoint("std/src/io/stdio.rs", 951)
+	require.NoError(t, err)
+	require.Len(t, breakpoints, 1)
+	assert.Equal(t, 0, breakpoints[0].Offset)
+
+	var stdout bytes.Buffer
+	err = wasi.Run("hello", def, &wasi.RunOptions{
+		Options: &wasi.Options{Stdout: &stdout},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, world!\n", stdout.String())
+	assert.Equal(t, []stop{{"_ZN3std2io5stdio6_print17hed02de74696c327cE", "stdio.rs", 951}}, stops)
+}
+
+func TestProfiler(t *testing.T) {
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0, 0},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "leaf", Kind: wasm.ExternalFunction, Index: 0},
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 1},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.I32Const(1),
+						code.I32Add(),
+						code.End(),
+					),
+				},
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.Call(0), // leaf
+						code.Call(0), // leaf
+						code.End(),
+					),
+				},
+			},
+		},
+	}
+
+	for _, kind := range []CodeKind{ICodeOnly, FCodeOnly} {
+		p := NewProfiler()
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, &Options{CodeKind: kind, Profiler: p}),
+		})
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		main, err := mod.GetFunction("main")
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			thread := exec.NewThread(0)
+			assert.Equal(t, []interface{}{int32(i + 2)}, main.Call(&thread, int32(i)))
+		}
+
+		// The profile has a single stack for each function: main and main -> leaf.
+		require.Len(t, p.root.children, 1)
+		for _, mainNode := range p.root.children {
+			assert.Equal(t, uint32(1), mainNode.fn.index)
+			assert.Equal(t, int64(10), mainNode.calls)
+
+			require.Len(t, mainNode.children, 1)
+			for _, leafNode := range mainNode.children {
+				assert.Equal(t, uint32(0), leafNode.fn.index)
+				assert.Equal(t, int64(20), leafNode.calls)
+				assert.Len(t, leafNode.children, 0)
+			}
+		}
+		assert.Same(t, &p.root, p.active)
+
+		var buf bytes.Buffer
+		require.NoError(t, p.WriteProfile(&buf))
+		gz, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "test.main")
+		assert.Contains(t, string(data), "test.leaf")
+	}
+}
+
+func TestProfilerLines(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "wasi", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	module, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+
+	p := NewProfiler()
+	err = wasi.Run("hello", NewModuleDefinition(module, &Options{Profiler: p}), &wasi.RunOptions{
+		Options: &wasi.Options{Stdout: ioutil.Discard},
+	})
+	require.NoError(t, err)
+
+	// Find std::io::stdio::_print and check its source location.
+	var find func(node *profileNode) *function
+	find = func(node *profileNode) *function {
+		if node.fn != nil && node.fn.index == 110 {
+			return node.fn
+		}
+		for _, child := range node.children {
+			if fn := find(child); fn != nil {
+				return fn
+			}
+		}
+		return nil
+	}
+	print := find(&p.root)
+	require.NotNil(t, print)
+
+	function := profileFunction(1, print)
+	assert.Equal(t, "_ZN3std2io5stdio6_print17hed02de74696c327cE", function.Name)
+	assert.True(t, strings.HasSuffix(function.Filename, "std/src/io/stdio.rs"))
+	assert.Equal(t, int64(951), function.StartLine)
+}
+
+func TestCoverage(t *testing.T) {
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0, 0},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "classify", Kind: wasm.ExternalFunction, Index: 0},
+				{FieldStr: "unused", Kind: wasm.ExternalFunction, Index: 1},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.If(code.BlockTypeI32),
+						code.I32Const(1),
+						code.Else(),
+						code.I32Const(0),
+						code.End(),
+						code.End(),
+					),
+				},
+				{
+					Code: expr(
+						code.LocalGet(0),
+						code.End(),
+					),
+				},
+			},
+		},
+	}
+
+	for _, kind := range []CodeKind{ICodeOnly, FCodeOnly} {
+		coverage := exec.NewCoverage()
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, &Options{CodeKind: kind}),
+		})
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		classify, err := mod.GetFunction("classify")
+		require.NoError(t, err)
+
+		for _, v := range []int32{1, 2, 0} {
+			thread := exec.NewThread(0)
+			thread.SetCoverage(coverage)
+			expected := int32(0)
+			if v != 0 {
+				expected = 1
+			}
+			assert.Equal(t, []interface{}{expected}, classify.Call(&thread, v))
+		}
+
+		assert.Equal(t, []string{"test"}, coverage.Modules())
+		functions := coverage.Module("test")
+		require.Len(t, functions, 1)
+		fc := functions[0]
+		require.NotNil(t, fc)
+		assert.Equal(t, uint64(3), fc.Instructions[0])
+		assert.Equal(t, uint64(2), fc.Instructions[2])
+		assert.Equal(t, uint64(1), fc.Instructions[4])
+		assert.Equal(t, map[int][]uint64{1: {2, 1}}, fc.Branches)
+
+		// Neither function has line information, so the report refers to the disassembly.
+		disassembly, err := disasm.Disassemble(module)
+		require.NoError(t, err)
+		thenLine, ok := disassembly.Line(0, 2)
+		require.True(t, ok)
+		elseLine, ok := disassembly.Line(0, 4)
+		require.True(t, ok)
+		ifLine, ok := disassembly.Line(0, 1)
+		require.True(t, ok)
+
+		var buf bytes.Buffer
+		disassembled, err := WriteLCOV(&buf, module, functions, "test.wat")
+		require.NoError(t, err)
+		assert.True(t, disassembled)
+
+		report := buf.String()
+		assert.True(t, strings.HasPrefix(report, "SF:test.wat\n"))
+		assert.Contains(t, report, "FNDA:3,classify\n")
+		assert.Contains(t, report, "FNDA:0,unused\n")
+		assert.Contains(t, report, "FNF:2\nFNH:1\n")
+		assert.Contains(t, report, fmt.Sprintf("BRDA:%d,1,0,2\nBRDA:%d,1,1,1\n", ifLine, ifLine))
+		assert.Contains(t, report, "BRF:2\nBRH:2\n")
+		assert.Contains(t, report, fmt.Sprintf("DA:%d,2\n", thenLine))
+		assert.Contains(t, report, fmt.Sprintf("DA:%d,1\n", elseLine))
+		assert.True(t, strings.HasSuffix(report, "end_of_record\n"))
+	}
+}
+
+func TestCoverageLines(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "wasi", "testdata", "hello.wasm"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	module, err := wasm.DecodeModule(f)
+	require.NoError(t, err)
+
+	coverage := exec.NewCoverage()
+	err = wasi.Run("hello", NewModuleDefinition(module, nil), &wasi.RunOptions{
+		Options:  &wasi.Options{Stdout: ioutil.Discard},
+		Coverage: coverage,
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = WriteLCOV(&buf, module, coverage.Module(""), "hello.wat")
+	require.NoError(t, err)
+
+	// std::io::stdio::_print begins at stdio.rs:951 and is called once.
+	var record string
+	for _, r := range strings.SplitAfter(buf.String(), "end_of_record\n") {
+		if strings.HasPrefix(r, "SF:") && strings.HasSuffix(strings.SplitN(r, "\n", 2)[0], "std/src/io/stdio.rs") {
+			record = r
+		}
+	}
+	require.NotEqual(t, "", record)
+	assert.Contains(t, record, "FN:951,_ZN3std2io5stdio6_print17hed02de74696c327cE\n")
+	assert.Contains(t, record, "FNDA:1,_ZN3std2io5stdio6_print17hed02de74696c327cE\n")
+	assert.Contains(t, record, "DA:951,1\n")
+}
+
+func TestSuperinstructions(t *testing.T) {
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0},
+		},
+		Memory: &wasm.SectionMemories{
+			Entries: []wasm.Memory{
+				{Limits: wasm.ResizableLimits{Initial: 1}},
+			},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "main", Kind: wasm.ExternalFunction, Index: 0},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{
+				{
+					Code: expr(
+						// *(a + 0) = 0x01020304
+						code.LocalGet(0),
+						code.I32Const(0x01020304),
+						code.I32Store(0, 2),
+						// *(a + 4) = 0xff00ff00
+						code.LocalGet(0),
+						code.I32Const(4),
+						code.I32Add(),
+						code.I32Const(-0x00ff0100),
+						code.I32Store(0, 2),
+						// *(a + 8) = *(a + b) & 0xff
+						code.LocalGet(0),
+						code.I32Const(8),
+						code.I32Add(),
+						code.LocalGet(0),
+						code.LocalGet(1),
+						code.I32Add(),
+						code.I32Load8U(0, 0),
+						code.I32Store8(0, 0),
+						// return (*(a + b) & 0xff) << 8 ^ *(a + 4) + *(a + 8)
+						code.LocalGet(0),
+						code.LocalGet(1),
+						code.I32Add(),
+						code.I32Load8U(0, 0),
+						code.I32Const(8),
+						code.I32Shl(),
+						code.LocalGet(0),
+						code.I32Const(4),
+						code.I32Add(),
+						code.I32Load(0, 2),
+						code.I32Xor(),
+						code.LocalGet(0),
+						code.I32Const(8),
+						code.I32Add(),
+						code.I32Load8U(0, 0),
+						code.I32Add(),
+						code.End(),
+					),
+				},
+			},
+		},
+	}
+
+	// run calls main with and without an opcode profile and returns the function's fcode.
+	run := func(t *testing.T, profile *OpcodeProfile) []finstruction {
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, &Options{CodeKind: FCodeOnly, OpcodeProfile: profile}),
+		})
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		main, err := mod.GetFunction("main")
+		require.NoError(t, err)
+
+		thread := exec.NewThread(0)
+		assert.Equal(t, []interface{}{int32(-0x00ff0100 ^ 0x0300 + 0x03)}, main.Call(&thread, int32(16), int32(1)))
+		return main.(*function).fcode
+	}
+
+	// Superinstructions are used if the interpreter has any.
+	fused := false
+	for _, fi := range run(t, nil) {
+		fused = fused || fi.opcode >= fopSuper
+	}
+	assert.Equal(t, len(superinstructions) != 0, fused)
+
+	// Superinstructions are not used if the module has an opcode profile. Without the warp_opcodeprofile build tag,
+	// modules with opcode profiles fail to allocate.
+	profile := NewOpcodeProfile()
+	if !opcodeProfiling {
+		_, err := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, &Options{CodeKind: FCodeOnly, OpcodeProfile: profile}),
+		}).InstantiateModule("test")
+		assert.ErrorIs(t, err, ErrOpcodeProfilingUnsupported)
+		return
+	}
+	for _, fi := range run(t, profile) {
+		assert.Less(t, fi.opcode, fopSuper)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, profile.WriteProfile(&buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "total "))
+	assert.Contains(t, buf.String(), "2 I32Add I32Load8UI:1\n")
+	assert.Contains(t, buf.String(), "1 I32AddI I32LoadI:1 I32Xor:1\n")
+}
+
+func TestDeepRecursion(t *testing.T) {
+	// sum(n) = n == 0 ? 0 : n + sum(n - 1). Deep enough recursion grows the machine's frame stack while callers are
+	// still active.
+	module := &wasm.Module{
+		Version: 1,
+
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{
+				{Form: 0x60, ParamTypes: []wasm.ValueType{wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}},
+			},
+		},
+		Function: &wasm.SectionFunctions{
+			Types: []uint32{0},
+		},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{
+				{FieldStr: "sum", Kind: wasm.ExternalFunction, Index: 0},
+			},
+		},
+		Code: &wasm.SectionCode{
+			Bodies: []wasm.FunctionBody{{
+				Code: expr(
+					code.LocalGet(0),
+					code.I32Eqz(),
+					code.If(code.BlockTypeI32),
+					code.I32Const(0),
+					code.Else(),
+					code.LocalGet(0),
+					code.I32Const(1),
+					code.I32Sub(),
+					code.Call(0),
+					code.LocalGet(0),
+					code.I32Add(),
+					code.End(),
+					code.End(),
+				),
+			}},
+		},
+	}
+
+	for _, kind := range []CodeKind{ICodeOnly, FCodeOnly} {
+		store := exec.NewStore(exec.MapResolver{
+			"test": NewModuleDefinition(module, &Options{CodeKind: kind}),
+		})
+
+		mod, err := store.InstantiateModule("test")
+		require.NoError(t, err)
+		sum, err := mod.GetFunction("sum")
+		require.NoError(t, err)
+
+		thread := exec.NewThread(0)
+		returns := make([]uint64, 1)
+		sum.UncheckedCall(&thread, []uint64{1000}, returns)
+		assert.Equal(t, []uint64{500500}, returns)
+	}
+}
@@ -18,13 +18,25 @@ type frame struct {
 	locals []uint64
 	blocks []uint64
 	stack  []uint64
+
+	// stepping is true if the frame's function executes under a debugger one instruction at a time. The decision is
+	// made when the frame is pushed, and revisited at the loop safepoints of frames that are not stepping.
+	stepping bool
+	// resume is the index of the icode instruction at which a frame that began stepping at a loop safepoint resumes,
+	// or -1 if the frame began stepping when it was pushed.
+	resume int
+	// breakpoints is the breakpoint set most recently checked at a loop safepoint, if any.
+	breakpoints *breakpointSet
 }
 
 type machine struct {
 	thread *exec.Thread
+	async  bool
 
-	stack  []uint64
-	frames []frame
+	stack []uint64
+	// frames holds the active frames. Frames are allocated individually so that pointers to active frames remain
+	// valid as the call stack grows. Frames past the end of the slice are retained for reuse.
+	frames []*frame
 }
 
 type scope struct {
@@ -73,7 +85,7 @@ func (s *scope) HasMemory(memoryidx uint32) bool {
 func (m *machine) init(t *exec.Thread) {
 	m.thread = t
 	m.stack = make([]uint64, 0, 1024)
-	m.frames = make([]frame, 0, 128)
+	m.frames = make([]*frame, 0, 128)
 }
 
 func (m *machine) zero64(s []uint64) {
@@ -113,31 +125,15 @@ func (m *machine) alloc(nparams, nlocals, maxStack, maxBlocks int) *frame {
 		stack = m.stack[:m.frames[len(m.frames)-1].sp()]
 	}
 
-	// If we don't have enough room to allocate this frame, we need to grow the stack. Because this involves a copy, we
-	// also need to crawl the call stack and update each frame's pointers.
-	if cap(stack)-len(stack) < maxFrame {
-		x := (maxFrame/1024 + 1) * 1024
-		newStack := make([]uint64, len(stack), len(stack)+x)
-		copy(newStack, stack)
-		stack = newStack
-
-		for i := range m.frames {
-			f := &m.frames[i]
-
-			frame := stack[f.fp-f.params:]
-			f.locals, frame = frame[0:len(f.locals):len(f.locals)+cap(f.stack)], frame[len(f.locals):]
-			f.blocks, frame = frame[0:len(f.blocks):cap(f.blocks)], frame[cap(f.blocks):]
-			f.stack = frame[0:len(f.stack):cap(f.stack)]
-		}
-	}
+	// Make room for the frame.
+	stack = m.grow(stack, maxFrame)
 
-	if cap(m.frames)-len(m.frames) < 1 {
-		frames := make([]frame, len(m.frames), cap(m.frames)+128)
-		copy(frames, m.frames)
-		m.frames = frames
+	if len(m.frames) == cap(m.frames) {
+		m.frames = append(m.frames, &frame{})
+	} else if m.frames = m.frames[:len(m.frames)+1]; m.frames[len(m.frames)-1] == nil {
+		m.frames[len(m.frames)-1] = &frame{}
 	}
-	m.frames = m.frames[:len(m.frames)+1]
-	f := &m.frames[len(m.frames)-1]
+	f := m.frames[len(m.frames)-1]
 
 	fp := len(stack)
 	fr := stack[fp-nparams:]
@@ -157,6 +153,43 @@ func (m *machine) alloc(nparams, nlocals, maxStack, maxBlocks int) *frame {
 	return f
 }
 
+// grow returns a stack with room for at least n more values past the end of the given stack. If the stack must be
+// reallocated, the pointers of the active frames are updated to refer to the new stack.
+func (m *machine) grow(stack []uint64, n int) []uint64 {
+	if cap(stack)-len(stack) >= n {
+		return stack
+	}
+
+	x := (n/1024 + 1) * 1024
+	newStack := make([]uint64, len(stack), len(stack)+x)
+	copy(newStack, stack)
+	stack = newStack
+
+	for _, f := range m.frames {
+		frame := stack[f.fp-f.params:]
+		f.locals, frame = frame[0:len(f.locals):len(f.locals)+cap(f.stack)], frame[len(f.locals):]
+		f.blocks, frame = frame[0:len(f.blocks):cap(f.blocks)], frame[cap(f.blocks):]
+		f.stack = frame[0:len(f.stack):cap(f.stack)]
+	}
+	return stack
+}
+
+// reserveBlocks adds space for a block stack with room for maxBlocks entries to the active frame, which must not have
+// one. The first height values of the frame's operand stack are moved above the new space.
+func (m *machine) reserveBlocks(f *frame, maxBlocks, height int) {
+	nlocals, maxStack := len(f.locals), cap(f.stack)
+	base := f.fp - f.params
+	end := base + nlocals + maxStack
+
+	stack := m.grow(m.stack[:end], maxBlocks)
+	m.stack = stack[:end+maxBlocks]
+
+	fr := m.stack[base:]
+	copy(fr[nlocals+maxBlocks:nlocals+maxBlocks+height], fr[nlocals:nlocals+height])
+	f.blocks = fr[nlocals : nlocals : nlocals+maxBlocks]
+	f.stack = fr[nlocals+maxBlocks : nlocals+maxBlocks+height : nlocals+maxBlocks+maxStack]
+}
+
 func (m *machine) free(sp int) {
 	m.stack = m.stack[:sp]
 	m.frames = m.frames[:len(m.frames)-1]
@@ -166,26 +199,17 @@ func (m *machine) push(fn *function) *frame {
 	// Decode the function if necessary.
 	switch fn.kind {
 	case functionKindBytecode:
-		locals := append([]wasm.ValueType(nil), fn.signature.ParamTypes...)
-		for _, entry := range fn.localEntries {
-			for i := 0; i < int(entry.Count); i++ {
-				locals = append(locals, entry.Type)
-			}
+		if fn.adoptPrecompiled() {
+			break
 		}
-		fn.numLocals = len(locals)
 
-		body, err := code.Decode(fn.bytecode, &scope{
-			module: fn.module,
-			locals: locals,
-		}, fn.signature.ReturnTypes)
-		if err != nil {
+		if err := fn.decode(); err != nil {
 			panic(err)
 		}
-		fn.icode, fn.metrics, fn.bytecode = body.Instructions, body.Metrics, nil
 
 		switch {
-		case fn.module.codeKind != 0:
-			if fn.module.codeKind == fcodeOnly {
+		case fn.module.codeKind != MixedCode:
+			if fn.module.codeKind == FCodeOnly {
 				m.emitFcode(fn, fn.icode)
 				fn.kind = functionKindFCode
 			} else {
@@ -201,15 +225,25 @@ func (m *machine) push(fn *function) *frame {
 		}
 
 	case functionKindCountingICode:
+		if fn.adoptPrecompiled() {
+			break
+		}
+
 		fn.invokeCount++
-		if fn.invokeCount > 1 {
+		if fn.invokeCount > fn.module.tierUpThreshold {
 			m.emitFcode(fn, fn.icode)
 			fn.kind = functionKindFCode
 		}
+
+	case functionKindICode:
+		fn.adoptPrecompiled()
 	}
 
+	stepping := fn.kind != functionKindVirtual && fn.module.debugger.stepping(fn)
+
 	nblocks := 0
-	if fn.kind != functionKindFCode || m.thread.Debug() {
+	_, covering := m.thread.Coverage()
+	if fn.kind != functionKindFCode || m.async || m.thread.Debug() || covering || fn.module.mem0.Watched() || stepping {
 		nblocks = fn.metrics.MaxNesting * 2
 	}
 
@@ -219,12 +253,13 @@ func (m *machine) push(fn *function) *frame {
 
 	// Fill in the frame's details.
 	f.module = fn.module
+	f.stepping, f.resume, f.breakpoints = stepping, -1, nil
 
 	return f
 }
 
 func (m *machine) pop(fn *function) {
-	f := &m.frames[len(m.frames)-1]
+	f := m.frames[len(m.frames)-1]
 
 	// Move results to the top of the stack.
 	nresults := len(fn.signature.ReturnTypes)
@@ -253,7 +288,11 @@ func (f *frame) runDebug(fn *function) {
 
 	if trace, tracing := f.m.thread.Trace(); tracing {
 		f.runTrace(trace, fn)
-	} else if f.module.codeKind == icodeTrace {
+	} else if coverage, covering := f.m.thread.Coverage(); covering {
+		f.runCoverage(fn, coverage)
+	} else if f.module.mem0.Watched() {
+		f.runWatch(fn)
+	} else if f.module.codeKind == ICodeTrace {
 		f.runTraceTest(fn)
 	} else {
 		f.runICode(fn)
@@ -276,6 +315,7 @@ func (f *frame) runTrace(w io.Writer, fn *function) {
 	}
 
 	s := scope{module: f.module, locals: locals}
+	watched := f.module.mem0.Watched()
 	ip := 0
 	for {
 		instr := &fn.icode[ip]
@@ -291,7 +331,11 @@ func (f *frame) runTrace(w io.Writer, fn *function) {
 		}
 		copy(traceEntry.Args, f.stack[len(f.stack)-pop:])
 
-		ip = f.step(fn.icode, ip)
+		if watched {
+			ip = f.stepWatch(fn, ip)
+		} else {
+			ip = f.step(fn.icode, ip)
+		}
 
 		copy(traceEntry.Results, f.stack[len(f.stack)-push:])
 		traceEntry.Encode(w)
@@ -345,19 +389,42 @@ func (f *frame) invoke(fn exec.Function) {
 func (f *frame) invokeDirect(fn *function) {
 	callee := f.m.push(fn)
 
-	if f.m.thread.Debug() || fn.module.codeKind == icodeTrace {
-		callee.runDebug(fn)
+	if p := fn.module.profiler; p != nil {
+		p.run(callee, fn)
+	} else if d := fn.module.debugger; d != nil {
+		d.run(callee, fn)
 	} else {
-		callee.m.thread.Enter()
-		if fn.kind == functionKindFCode {
-			callee.runFCode(fn)
-		} else {
-			callee.runICode(fn)
-		}
-		callee.m.thread.Leave()
+		callee.run(fn)
 	}
 
 	callee.m.pop(fn)
 
 	f.stack = f.stack[:len(f.stack)-len(fn.signature.ParamTypes)+len(fn.signature.ReturnTypes)]
 }
+
+// run executes the given function in the frame using the function's current tier.
+func (f *frame) run(fn *function) {
+	if f.m.thread.Debug() || fn.module.codeKind == ICodeTrace {
+		f.runDebug(fn)
+	} else if coverage, covering := f.m.thread.Coverage(); covering {
+		f.m.thread.Enter()
+		f.runCoverage(fn, coverage)
+		f.m.thread.Leave()
+	} else if fn.module.mem0.Watched() {
+		f.m.thread.Enter()
+		f.runWatch(fn)
+		f.m.thread.Leave()
+	} else {
+		f.m.thread.Enter()
+		if fn.kind == functionKindFCode {
+			if p := fn.module.opcodeProfile; p != nil {
+				f.runFCodeProfile(fn, p.counts(fn))
+			} else {
+				f.runFCode(fn)
+			}
+		} else {
+			f.runICode(fn)
+		}
+		f.m.thread.Leave()
+	}
+}
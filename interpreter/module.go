@@ -1,15 +1,19 @@
 package interpreter
 
 import (
+	"sync/atomic"
+
 	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/debuginfo"
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
 )
 
 // A module holds an instance of a WASM module.
 type module struct {
-	name     string // The name of the module.
-	codeKind int    // The code kind for the module. Used for testing purposes.
+	name            string   // The name of the module.
+	codeKind        CodeKind // The code kind for the module.
+	tierUpThreshold int32    // The number of icode invocations after which a function is compiled to fcode.
 
 	types     []wasm.FunctionSig // The types used by this module.
 	functions []function         // The function table for this module.
@@ -20,6 +24,14 @@ type module struct {
 	importedFunctions []exec.Function // The functions imported by this module.
 	importedGlobals   []*exec.Global  // The globals imported by this module.
 
+	precompiled []atomic.Value // Functions compiled to fcode in the background, if any.
+
+	debugger  *Debugger       // The debugger for this module, if any.
+	profiler  *Profiler       // The profiler for this module, if any.
+	debugInfo *debuginfo.Info // Debugging information for this module, if it is being debugged or profiled.
+
+	opcodeProfile *OpcodeProfile // The opcode profile for this module, if any.
+
 	exports map[string]interface{} // The module's exports.
 }
 
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
 
 	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/internal/debuginfo"
 	"github.com/pgavlin/warp/wasm"
 )
 
@@ -13,41 +15,62 @@ import (
 // not valid.
 var ErrInvalidMemoryIndex = fmt.Errorf("invalid memory index")
 
-const (
-	mixedCode  = 0
-	icodeOnly  = 1
-	fcodeOnly  = 2
-	icodeTrace = 3
-)
+// ErrOpcodeProfilingUnsupported indicates that a module has an opcode profile, but the interpreter was built without
+// the warp_opcodeprofile build tag.
+var ErrOpcodeProfilingUnsupported = fmt.Errorf("opcode profiling requires the warp_opcodeprofile build tag")
 
 type moduleDefinition struct {
-	mod      *wasm.Module
-	codeKind int
-}
+	mod     *wasm.Module
+	options *Options
 
-// NewModuleDefinition creates a new ModuleDefinition from the given WASM module. The
-// module's functions will be executed by the intepreter.
-func NewModuleDefinition(module *wasm.Module) exec.ModuleDefinition {
-	return newModuleDefinition(module, mixedCode)
+	hashOnce sync.Once
+	hash     string // The module's content hash. Computed on demand.
+
+	debugInfo *debuginfo.Info // Debugging information for the module, if it is being debugged or profiled.
 }
 
-func newModuleDefinition(module *wasm.Module, codeKind int) exec.ModuleDefinition {
-	return &moduleDefinition{mod: module, codeKind: codeKind}
+// NewModuleDefinition creates a new ModuleDefinition from the given WASM module. The
+// module's functions will be executed by the intepreter using the given options. If
+// options is nil, the default options are used.
+func NewModuleDefinition(module *wasm.Module, options *Options) exec.ModuleDefinition {
+	def := &moduleDefinition{mod: module, options: options}
+	if options != nil {
+		switch {
+		case options.Debugger != nil:
+			def.debugInfo = options.Debugger.attach(module)
+		case options.Profiler != nil:
+			def.debugInfo = debuginfo.New(module)
+		}
+	}
+	return def
 }
 
 // LoadModuleDefinition decodes a WASM module from the given Reader and uses it to create
 // a ModuleDefinition.
-func LoadModuleDefinition(r io.Reader) (exec.ModuleDefinition, error) {
+func LoadModuleDefinition(r io.Reader, options *Options) (exec.ModuleDefinition, error) {
 	mod, err := wasm.DecodeModule(r)
 	if err != nil {
 		return nil, err
 	}
-	return NewModuleDefinition(mod), nil
+	return NewModuleDefinition(mod, options), nil
 }
 
 func (def *moduleDefinition) Allocate(name string) (exec.AllocatedModule, error) {
+	if def.options != nil && def.options.OpcodeProfile != nil && !opcodeProfiling {
+		return nil, ErrOpcodeProfilingUnsupported
+	}
+
 	module := allocatedModule{
-		module: &module{name: name, codeKind: def.codeKind},
+		module:     &module{name: name, tierUpThreshold: 1},
+		definition: def,
+	}
+	if def.options != nil {
+		module.codeKind = def.options.CodeKind
+		if def.options.TierUpThreshold > 0 {
+			module.tierUpThreshold = int32(def.options.TierUpThreshold)
+		}
+		module.debugger, module.profiler, module.debugInfo = def.options.Debugger, def.options.Profiler, def.debugInfo
+		module.opcodeProfile = def.options.OpcodeProfile
 	}
 
 	// Allocate import entries.
@@ -191,6 +214,8 @@ type allocatedModule struct {
 	elements []wasm.ElementSegment      // The module's element segments.
 	data     []wasm.DataSegment         // The module's data segments.
 	start    *wasm.SectionStartFunction // The module's start function, if any.
+
+	definition *moduleDefinition // The module's definition.
 }
 
 func (m *allocatedModule) Instantiate(imports exec.ImportResolver) (exec.Module, error) {
@@ -264,6 +289,10 @@ func (m *allocatedModule) Instantiate(imports exec.ImportResolver) (exec.Module,
 		}
 	}
 
+	// Compile the module's functions to fcode if requested. The functions' signatures and types depend on the module's
+	// imports and globals, so compilation cannot begin any earlier.
+	m.definition.precompile(m.module)
+
 	// Check element and data segments.
 	elementOffsets, err := m.checkElementSegments()
 	if err != nil {
@@ -0,0 +1,149 @@
+package interpreter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// An OpcodeProfile records the number of times each fcode instruction executes. The profile is used to select the
+// interpreter's superinstructions: see the gen-fcode command in this package's source tree.
+//
+// An OpcodeProfile is attached to a module using Options.OpcodeProfile. The functions of a module with an opcode profile
+// are compiled without superinstructions, and only functions that execute as fcode are profiled. A single
+// OpcodeProfile may be shared by several modules. An OpcodeProfile may only be used by one thread at a time.
+//
+// Recording an opcode profile requires an instrumented copy of the fcode execution loop, which is only built with the
+// warp_opcodeprofile build tag.
+type OpcodeProfile struct {
+	functions map[*finstruction]*opcodeCounts
+}
+
+// opcodeCounts records the number of times each instruction in a function's fcode executed.
+type opcodeCounts struct {
+	fcode  []finstruction
+	labels []label
+	counts []uint64
+}
+
+// NewOpcodeProfile creates a new opcode profile.
+func NewOpcodeProfile() *OpcodeProfile {
+	return &OpcodeProfile{functions: map[*finstruction]*opcodeCounts{}}
+}
+
+// counts returns the instruction counts for the function's current fcode.
+func (p *OpcodeProfile) counts(fn *function) []uint64 {
+	// Functions may be recompiled (e.g. after debugging), so key the counts by the function's fcode.
+	c, ok := p.functions[&fn.fcode[0]]
+	if !ok {
+		c = &opcodeCounts{fcode: fn.fcode, labels: fn.labels, counts: make([]uint64, len(fn.fcode))}
+		p.functions[&fn.fcode[0]] = c
+	}
+	return c.counts
+}
+
+// WriteProfile writes the profile in the text format read by gen-fcode. The first line of the profile records the
+// total number of instructions executed:
+//
+//	total <count>
+//
+// Each remaining line records the number of times a sequence of two or three adjacent instructions executed:
+//
+//	<count> <name> <name>[:<operand>] [<name>[:<operand>]]
+//
+// An instruction is annotated with an operand if the instruction consumes the result of its predecessor as the given
+// operand, numbered in WASM order starting at 1. Sequences are written in order of decreasing count. Sequences that
+// account for less than 0.01% of the total are omitted.
+func (p *OpcodeProfile) WriteProfile(w io.Writer) error {
+	total, sequences := uint64(0), map[string]uint64{}
+	for _, c := range p.functions {
+		for _, n := range c.counts {
+			total += n
+		}
+		c.sequences(sequences)
+	}
+
+	type sequence struct {
+		text  string
+		count uint64
+	}
+	var sorted []sequence
+	for text, count := range sequences {
+		if count >= total/10000 {
+			sorted = append(sorted, sequence{text, count})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].text < sorted[j].text
+	})
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "total %d\n", total)
+	for _, s := range sorted {
+		fmt.Fprintf(b, "%d %s\n", s.count, s.text)
+	}
+	return b.Flush()
+}
+
+// sequences adds the function's sequences of two and three instructions to the given map. The count of a sequence
+// is the smallest count of its instructions. Sequences that span the target of a branch are ignored.
+func (c *opcodeCounts) sequences(sequences map[string]uint64) {
+	targets := map[int]bool{}
+	for _, l := range c.labels {
+		targets[l.continuation[0]], targets[l.continuation[1]] = true, true
+	}
+
+	for ip := range c.fcode {
+		var text strings.Builder
+		text.WriteString(opcodeName(c.fcode[ip].opcode))
+
+		count := c.counts[ip]
+		for n := 1; n < 3 && ip+n < len(c.fcode) && !targets[ip+n] && count != 0; n++ {
+			producer, consumer := &c.fcode[ip+n-1], &c.fcode[ip+n]
+
+			text.WriteByte(' ')
+			text.WriteString(opcodeName(consumer.opcode))
+			if operand, ok := consumedOperand(producer, consumer); ok {
+				fmt.Fprintf(&text, ":%d", operand)
+			}
+
+			if c.counts[ip+n] < count {
+				count = c.counts[ip+n]
+			}
+			if count != 0 {
+				sequences[text.String()] += count
+			}
+		}
+	}
+}
+
+// consumedOperand returns the operand of consumer that receives the result of producer, if any.
+func consumedOperand(producer, consumer *finstruction) (int, bool) {
+	p, ok := fusibleInstructions[producer.opcode]
+	if !ok || !p.result || producer.flags&ifDefLocal != 0 {
+		return 0, false
+	}
+	c, ok := fusibleInstructions[consumer.opcode]
+	if !ok {
+		return 0, false
+	}
+	for i, operand := range fusionOperands(consumer) {
+		if c.immediates&(1<<i) == 0 && operand == producer.dest {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// opcodeName returns the name of the given opcode.
+func opcodeName(op opcode) string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(op))
+}
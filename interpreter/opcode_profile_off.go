@@ -0,0 +1,14 @@
+//go:build !warp_opcodeprofile
+// +build !warp_opcodeprofile
+
+package interpreter
+
+// opcodeProfiling is true if the interpreter includes the instrumented fcode execution loop that records opcode
+// profiles.
+const opcodeProfiling = false
+
+// runFCodeProfile is never called without the warp_opcodeprofile build tag: modules with opcode profiles fail to
+// allocate.
+func (f *frame) runFCodeProfile(fn *function, counts []uint64) {
+	panic("interpreter built without opcode profiling")
+}
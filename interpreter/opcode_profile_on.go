@@ -0,0 +1,8 @@
+//go:build warp_opcodeprofile
+// +build warp_opcodeprofile
+
+package interpreter
+
+// opcodeProfiling is true if the interpreter includes the instrumented fcode execution loop that records opcode
+// profiles.
+const opcodeProfiling = true
@@ -0,0 +1,220 @@
+package interpreter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pgavlin/warp/wasm"
+)
+
+// A CodeKind determines the forms of code that the interpreter uses to execute a module's functions.
+//
+// ICode is the decoded form of a function's bytecode. It is cheap to produce, but is relatively slow to execute.
+// FCode is a register-machine-like form that is compiled from icode. It executes faster than icode, but takes
+// additional time and memory to produce.
+//
+// The right balance trades startup cost against steady-state speed. BenchmarkFlateTiering compresses 64 KiB with the
+// flate program under each policy, including the cost of instantiating the module in each run; its results are
+// recorded in bench.txt. On that compute-heavy workload, ICodeOnly takes about three times as long as MixedCode,
+// because the time spent compiling to fcode is repaid many times over by the program's loops. FCodeOnly is no faster
+// than MixedCode, which compiles the hot functions to fcode anyway, and CompileParallel and CompileBackground are
+// about 15% and 35% slower on a single CPU, because they also compile functions that never run. ICodeOnly only pays
+// off for programs that do too little work to repay compilation.
+type CodeKind int
+
+const (
+	// MixedCode executes functions using a mix of icode and fcode. Functions that contain loops are compiled to fcode
+	// when they are first called. Other functions with at least 16 instructions execute as icode until they have been
+	// called more than Options.TierUpThreshold times, at which point they are compiled to fcode. Smaller functions
+	// always execute as icode.
+	MixedCode CodeKind = iota
+	// ICodeOnly executes every function as icode. This minimizes startup time and memory usage, and is a good choice
+	// for short-lived programs that run most of their code once.
+	ICodeOnly
+	// FCodeOnly compiles every function to fcode when it is first called. This maximizes steady-state throughput at the
+	// cost of compiling functions that may only run once.
+	FCodeOnly
+	// ICodeTrace executes every function as icode one instruction at a time. This is the slowest option, and is
+	// primarily useful for testing the interpreter's tracing path.
+	ICodeTrace
+)
+
+// A CompileMode determines when the interpreter compiles a module's functions to fcode.
+type CompileMode int
+
+const (
+	// CompileLazy compiles functions to fcode on demand as determined by the module's CodeKind.
+	CompileLazy CompileMode = iota
+	// CompileParallel compiles every function to fcode in parallel when the module is instantiated. Compilation begins
+	// as soon as the module's imports have been resolved, as the imports determine the types of some of the module's
+	// instructions. Instantiation does not complete until all functions have been compiled, which trades startup latency
+	// for steady-state throughput. This pays off for long-running programs that execute most of their code.
+	CompileParallel
+	// CompileBackground begins compiling every function to fcode in parallel when the module is instantiated, but does
+	// not wait for compilation to complete. Until its fcode is available, a function executes as determined by the
+	// module's CodeKind.
+	//
+	// Background compilation runs until every function has been compiled or Options.Context is done, whichever comes
+	// first. It is not otherwise tied to the lifetime of the module, so set Options.Context to stop compiling the
+	// functions of a module that is no longer in use.
+	CompileBackground
+)
+
+// Options control how the interpreter executes a module's functions.
+type Options struct {
+	// CodeKind determines the forms of code used to execute the module's functions. Defaults to MixedCode.
+	CodeKind CodeKind
+	// TierUpThreshold is the number of times a function may execute as icode before it is compiled to fcode. Only
+	// applies to MixedCode. Defaults to 1. Raising the threshold avoids compiling functions that run only a few times
+	// at the cost of running hot functions as icode for longer. BenchmarkFlateTiering measures no difference between
+	// the default and a threshold of 16, as functions with loops are compiled when they are first called regardless of
+	// the threshold.
+	TierUpThreshold int
+	// Compile determines when functions are compiled to fcode. Ignored for ICodeOnly and ICodeTrace. Defaults to
+	// CompileLazy.
+	Compile CompileMode
+	// Parallelism is the maximum number of functions to compile concurrently when Compile is CompileParallel or
+	// CompileBackground. Defaults to runtime.GOMAXPROCS(0).
+	Parallelism int
+	// Context, if set, bounds background compilation when Compile is CompileBackground. Once the context is done, no
+	// further functions are compiled in the background, and functions that have not been compiled execute as
+	// determined by the module's CodeKind.
+	Context context.Context
+	// CacheDir is the path to a directory used to cache compiled fcode across processes. If CacheDir is set, the first
+	// instantiation of a module compiles all of the module's functions to fcode in parallel and writes the results to
+	// the cache. Later instantiations of the same module by the same version of warp read the compiled functions from
+	// the cache rather than compiling them. Corrupt or stale cache entries are ignored and replaced. Only modules that
+	// were decoded from the binary format can be cached. Ignored for ICodeOnly and ICodeTrace.
+	//
+	// Cached fcode is executed without further validation, so the cache directory must only be writable by trusted
+	// users.
+	CacheDir string
+	// Debugger is the debugger that controls the execution of the module's functions, if any.
+	Debugger *Debugger
+	// Profiler is the profiler that records calls to the module's functions, if any.
+	Profiler *Profiler
+	// OpcodeProfile is the profile that records the fcode instructions executed by the module's functions, if any.
+	// CacheDir is ignored if OpcodeProfile or Debugger is set. Opcode profiles require the warp_opcodeprofile build tag: without
+	// it, modules with opcode profiles fail to allocate with ErrOpcodeProfilingUnsupported.
+	OpcodeProfile *OpcodeProfile
+}
+
+// precompile compiles the module's functions to fcode as requested by the definition's options.
+func (def *moduleDefinition) precompile(module *module) {
+	o := def.options
+	if o == nil || o.CodeKind == ICodeOnly || o.CodeKind == ICodeTrace || def.mod.Code == nil {
+		return
+	}
+	bodies := def.mod.Code.Bodies
+
+	if o.CacheDir != "" && o.OpcodeProfile == nil && o.Debugger == nil {
+		if hash, ok := def.contentHash(); ok {
+			o.precompileCached(module, bodies, hash)
+			return
+		}
+	}
+
+	parallelism := o.parallelism()
+	switch o.Compile {
+	case CompileLazy:
+		return
+	case CompileParallel:
+		compileAll(parallelism, len(bodies), nil, func(i int) {
+			if fn, ok := compileFunction(&module.functions[i], bodies[i]); ok {
+				module.functions[i] = fn
+			}
+		})
+	case CompileBackground:
+		var done <-chan struct{}
+		if o.Context != nil {
+			if o.Context.Err() != nil {
+				return
+			}
+			done = o.Context.Done()
+		}
+
+		// The functions may execute while they are being compiled, so compile from a snapshot.
+		functions := append([]function(nil), module.functions...)
+
+		module.precompiled = make([]atomic.Value, len(bodies))
+		go compileAll(parallelism, len(bodies), done, func(i int) {
+			if fn, ok := compileFunction(&functions[i], bodies[i]); ok {
+				module.precompiled[i].Store(&fn)
+			}
+		})
+	}
+}
+
+func (o *Options) parallelism() int {
+	if o.Parallelism <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Parallelism
+}
+
+// compileAll calls compile for each index in [0, n) using at most parallelism goroutines. If done is closed, compileAll
+// stops once the calls in progress have returned.
+func compileAll(parallelism, n int, done <-chan struct{}, compile func(i int)) {
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism && i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				compile(i)
+			}
+		}()
+	}
+
+send:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+		case <-done:
+			break send
+		}
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// compileFunction decodes the given function body and compiles it to fcode.
+//
+// If the body fails to decode, compileFunction returns false. The function will report the error when it is called.
+func compileFunction(fn *function, body wasm.FunctionBody) (function, bool) {
+	compiled := function{
+		module:       fn.module,
+		index:        fn.index,
+		signature:    fn.signature,
+		localEntries: fn.localEntries,
+		bytecode:     body.Code,
+	}
+	if err := compiled.decode(); err != nil {
+		return function{}, false
+	}
+
+	var m machine
+	m.emitFcode(&compiled, compiled.icode)
+	compiled.kind = functionKindFCode
+	return compiled, true
+}
+
+// adoptPrecompiled replaces the function's body with its fcode if the function was compiled in the background and
+// its fcode is available. adoptPrecompiled returns true if the function's body was replaced.
+func (fn *function) adoptPrecompiled() bool {
+	if fn.module.precompiled == nil {
+		return false
+	}
+	compiled, ok := fn.module.precompiled[int(fn.index)-len(fn.module.importedFunctions)].Load().(*function)
+	if ok {
+		// Background compilation reads the signatures of the module's functions, so only replace the function's body.
+		fn.numLocals, fn.metrics, fn.kind = compiled.numLocals, compiled.metrics, compiled.kind
+		fn.bytecode, fn.icode, fn.fcode = nil, compiled.icode, compiled.fcode
+		fn.labels, fn.switches = compiled.labels, compiled.switches
+	}
+	return ok
+}
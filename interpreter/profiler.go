@@ -0,0 +1,161 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pgavlin/warp/internal/pprof"
+)
+
+// A Profiler records the number of calls to and the time spent in each interpreted call stack. Time spent in host
+// functions is attributed to their interpreted callers.
+//
+// A Profiler is attached to a module using Options.Profiler. A single Profiler may be shared by several modules, in
+// which case its call stacks span the modules. A Profiler may only be used by one thread at a time. Suspendable calls
+// made using StartAsync are not profiled.
+type Profiler struct {
+	root   profileNode
+	active *profileNode // The node for the currently-executing function.
+
+	start time.Time     // The time at which the profiler was created.
+	last  time.Duration // The time of the most recent call or return, relative to start.
+}
+
+// A profileNode records the calls to a function from a particular call stack.
+type profileNode struct {
+	fn       *function
+	parent   *profileNode
+	children map[*function]*profileNode
+
+	calls int64         // The number of calls to the function from this stack.
+	self  time.Duration // The time spent in the function itself from this stack.
+}
+
+// NewProfiler creates a new profiler.
+func NewProfiler() *Profiler {
+	p := &Profiler{start: time.Now()}
+	p.active = &p.root
+	return p
+}
+
+// tick attributes the time since the last call or return to the active function.
+func (p *Profiler) tick() {
+	now := time.Since(p.start)
+	p.active.self += now - p.last
+	p.last = now
+}
+
+// enter records a call to the given function.
+func (p *Profiler) enter(fn *function) {
+	p.tick()
+
+	node := p.active.children[fn]
+	if node == nil {
+		if p.active.children == nil {
+			p.active.children = map[*function]*profileNode{}
+		}
+		node = &profileNode{fn: fn, parent: p.active}
+		p.active.children[fn] = node
+	}
+	node.calls++
+	p.active = node
+}
+
+// leave records a return from the active function.
+func (p *Profiler) leave() {
+	p.tick()
+	p.active = p.active.parent
+}
+
+// run executes an interpreted function under the profiler.
+func (p *Profiler) run(f *frame, fn *function) {
+	p.enter(fn)
+	defer p.leave()
+
+	if d := fn.module.debugger; d != nil {
+		d.run(f, fn)
+	} else {
+		f.run(fn)
+	}
+}
+
+// WriteProfile writes the profile in the gzip-compressed protocol buffer format read by pprof. The profile has two
+// sample types: the number of calls to each call stack, and the time spent in each call stack in nanoseconds.
+// Functions are named using their module's name section or exports. If a module has DWARF line information, each
+// function's source location is that of its first instruction.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	profile := &pprof.Profile{
+		SampleType: []pprof.ValueType{
+			{Type: "calls", Unit: "count"},
+			{Type: "time", Unit: "nanoseconds"},
+		},
+		DefaultSampleType: "time",
+		TimeNanos:         p.start.UnixNano(),
+		DurationNanos:     int64(time.Since(p.start)),
+	}
+
+	ids := map[*function]uint64{}
+	var walk func(node *profileNode, stack []uint64)
+	walk = func(node *profileNode, stack []uint64) {
+		if node.fn != nil {
+			id, ok := ids[node.fn]
+			if !ok {
+				id = uint64(len(ids) + 1)
+				ids[node.fn] = id
+				profile.Function = append(profile.Function, profileFunction(id, node.fn))
+				profile.Location = append(profile.Location, pprof.Location{
+					ID:   id,
+					Line: []pprof.Line{{Function: id, Line: profile.Function[len(profile.Function)-1].StartLine}},
+				})
+			}
+
+			stack = append([]uint64{id}, stack...)
+			profile.Sample = append(profile.Sample, pprof.Sample{
+				Location: stack,
+				Value:    []int64{node.calls, int64(node.self)},
+			})
+		}
+
+		// Visit the children in a deterministic order.
+		children := make([]*profileNode, 0, len(node.children))
+		for _, child := range node.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			fi, fj := children[i].fn, children[j].fn
+			if fi.module.name != fj.module.name {
+				return fi.module.name < fj.module.name
+			}
+			return fi.index < fj.index
+		})
+		for _, child := range children {
+			walk(child, stack)
+		}
+	}
+	walk(&p.root, nil)
+
+	return profile.Write(w)
+}
+
+// profileFunction describes the given function for a profile.
+func profileFunction(id uint64, fn *function) pprof.Function {
+	name := fmt.Sprintf("func %d", fn.index)
+	if info := fn.module.debugInfo; info != nil {
+		if n, ok := info.FunctionName(fn.index); ok {
+			name = n
+		}
+	}
+	if fn.module.name != "" {
+		name = fn.module.name + "." + name
+	}
+
+	f := pprof.Function{ID: id, Name: name, SystemName: name}
+	if info := fn.module.debugInfo; info != nil {
+		if file, line, ok := info.SourceLocation(fn.index, 0); ok {
+			f.Filename, f.StartLine = file, int64(line)
+		}
+	}
+	return f
+}
@@ -24,16 +24,16 @@ func TestMain(m *testing.M) {
 func TestSpec(t *testing.T) {
 	if *specTest != "" {
 		warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
-			codeKind := mixedCode
+			codeKind := MixedCode
 			switch *codeKindF {
 			case "icodeOnly":
-				codeKind = icodeOnly
+				codeKind = ICodeOnly
 			case "icodeTrace":
-				codeKind = icodeTrace
+				codeKind = ICodeTrace
 			case "fcodeOnly":
-				codeKind = fcodeOnly
+				codeKind = FCodeOnly
 			}
-			return newModuleDefinition(m, codeKind), nil
+			return NewModuleDefinition(m, &Options{CodeKind: codeKind}), nil
 		}, *specTest, false, ignore[filepath.Base(*specTest)])
 		return
 	}
@@ -52,23 +52,36 @@ func TestSpec(t *testing.T) {
 			//t.Parallel()
 
 			warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
-				return NewModuleDefinition(m), nil
+				return NewModuleDefinition(m, nil), nil
 			}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
 
 			// ICode only
 			warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
-				return newModuleDefinition(m, icodeOnly), nil
+				return NewModuleDefinition(m, &Options{CodeKind: ICodeOnly}), nil
 			}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
 
 			// Tracing ICode only
 			warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
-				return newModuleDefinition(m, icodeTrace), nil
+				return NewModuleDefinition(m, &Options{CodeKind: ICodeTrace}), nil
 			}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
 
 			// FCode only
 			warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
-				return newModuleDefinition(m, fcodeOnly), nil
+				return NewModuleDefinition(m, &Options{CodeKind: FCodeOnly}), nil
 			}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
+
+			// Parallel FCode
+			warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
+				return NewModuleDefinition(m, &Options{Compile: CompileParallel}), nil
+			}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
+
+			// Cached FCode. Only modules in the binary format are cached: the second run reads their fcode from the cache.
+			cached := &Options{CacheDir: t.TempDir()}
+			for i := 0; i < 2; i++ {
+				warp_testing.RunScript(t, func(m *wasm.Module) (exec.ModuleDefinition, error) {
+					return NewModuleDefinition(m, cached), nil
+				}, filepath.Join(specDir, entry.Name()), false, ignore[entry.Name()])
+			}
 		})
 	}
 }
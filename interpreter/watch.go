@@ -0,0 +1,101 @@
+package interpreter
+
+import (
+	"encoding/binary"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// memoryAccess returns the kind and width of the memory access performed by the given opcode, if any.
+func memoryAccess(opcode byte) (exec.WatchKind, int) {
+	switch opcode {
+	case code.OpI32Load8S, code.OpI32Load8U, code.OpI64Load8S, code.OpI64Load8U:
+		return exec.WatchRead, 1
+	case code.OpI32Load16S, code.OpI32Load16U, code.OpI64Load16S, code.OpI64Load16U:
+		return exec.WatchRead, 2
+	case code.OpI32Load, code.OpF32Load, code.OpI64Load32S, code.OpI64Load32U:
+		return exec.WatchRead, 4
+	case code.OpI64Load, code.OpF64Load:
+		return exec.WatchRead, 8
+	case code.OpI32Store8, code.OpI64Store8:
+		return exec.WatchWrite, 1
+	case code.OpI32Store16, code.OpI64Store16:
+		return exec.WatchWrite, 2
+	case code.OpI32Store, code.OpF32Store, code.OpI64Store32:
+		return exec.WatchWrite, 4
+	case code.OpI64Store, code.OpF64Store:
+		return exec.WatchWrite, 8
+	default:
+		return 0, 0
+	}
+}
+
+// peek reads the little-endian value of the given width stored at the given address. If the access is out of
+// bounds, peek returns 0.
+func peek(mem *exec.Memory, address uint64, size int) uint64 {
+	bytes := mem.Bytes()
+	if address+uint64(size) > uint64(len(bytes)) {
+		return 0
+	}
+	var buf [8]byte
+	copy(buf[:], bytes[int(address):int(address)+size])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// runWatch executes the given function one instruction at a time, reporting accesses to watched memory.
+func (f *frame) runWatch(fn *function) {
+	// Push the first label.
+	f.blocks = f.blocks[:2]
+	f.blocks[0] = uint64(len(fn.icode) - 1)
+	f.blocks[1] = uint64(len(fn.signature.ReturnTypes))
+
+	ip := 0
+	for {
+		ip = f.stepWatch(fn, ip)
+		if ip == len(fn.icode) {
+			return
+		}
+	}
+}
+
+// stepWatch executes the instruction at the given ip. If the instruction accesses a watched range of memory, the
+// access is reported to the memory's watchpoints once the instruction completes.
+func (f *frame) stepWatch(fn *function, ip int) int {
+	instr := &fn.icode[ip]
+
+	kind, size := memoryAccess(instr.Opcode)
+	if kind == 0 {
+		return f.step(fn.icode, ip)
+	}
+
+	base := f.stack[len(f.stack)-1]
+	if kind == exec.WatchWrite {
+		base = f.stack[len(f.stack)-2]
+	}
+	address := uint64(uint32(base)) + uint64(instr.Offset())
+
+	mem := f.module.mem0
+	if address > 0xffffffff || !mem.Watching(kind, uint32(address), size) {
+		return f.step(fn.icode, ip)
+	}
+
+	old := peek(mem, address, size)
+	next := f.step(fn.icode, ip)
+
+	access := exec.MemoryAccess{
+		Kind:          kind,
+		FunctionIndex: fn.index,
+		IP:            ip,
+		Address:       uint32(address),
+		Size:          size,
+		OldValue:      old,
+		NewValue:      old,
+	}
+	if kind == exec.WatchWrite {
+		access.NewValue = peek(mem, address, size)
+	}
+	mem.NotifyAccess(f.m.thread, &access)
+
+	return next
+}
@@ -0,0 +1,73 @@
+package load
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
+)
+
+// A Mapping is a module that was decoded from a memory-mapped file. The function bodies and custom sections of a
+// binary module refer directly to the mapped file, and are only read from disk when they are used. The module must not
+// be used after the mapping is closed.
+type Mapping struct {
+	Module *wasm.Module // The decoded module.
+
+	data []byte // The mapped file, or nil if the file has been unmapped.
+}
+
+// MapFile maps the file at the given path into memory and decodes the module it contains. Binary modules are decoded
+// using wasm.DecodeModuleBytes. Modules in the text format are parsed as usual, and their files are unmapped before
+// MapFile returns. On platforms that do not support memory-mapped files, MapFile reads the entire file into memory.
+func MapFile(path string) (*Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("%v is too large to map", path)
+	}
+
+	data, err := mapFile(f, int(size))
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{data: data}
+
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == wasm.Magic {
+		m.Module, err = wasm.DecodeModuleBytes(data)
+	} else {
+		var syntax *wast.Module
+		if syntax, err = wast.ParseModule(wast.NewScanner(bytes.NewReader(data))); err == nil {
+			m.Module, err = syntax.Decode()
+		}
+		if closeErr := m.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close unmaps the mapping's file.
+func (m *Mapping) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return unmapFile(data)
+}
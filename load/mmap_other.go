@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package load
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return ioutil.ReadAll(f)
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package load
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	warpexec "github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+)
+
+func TestMapFileBinary(t *testing.T) {
+	m := answerModule(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, wasm.EncodeModule(&buf, m))
+	path := filepath.Join(t.TempDir(), "answer.wasm")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	mapping, err := MapFile(path)
+	require.NoError(t, err)
+	defer mapping.Close()
+
+	def, err := Intepret(mapping.Module)
+	require.NoError(t, err)
+
+	store := warpexec.NewStore(warpexec.MapResolver{"answer": def})
+	instance, err := store.InstantiateModule("answer")
+	require.NoError(t, err)
+	answer, err := instance.GetFunction("answer")
+	require.NoError(t, err)
+
+	thread := warpexec.NewThread(0)
+	returns := make([]uint64, 1)
+	answer.UncheckedCall(&thread, nil, returns)
+	assert.Equal(t, []uint64{42}, returns)
+
+	require.NoError(t, mapping.Close())
+	assert.NoError(t, mapping.Close())
+}
+
+func TestMapFileText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answer.wat")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`(module (func (export "answer") (result i32) i32.const 42))`), 0600))
+
+	mapping, err := MapFile(path)
+	require.NoError(t, err)
+	defer mapping.Close()
+
+	require.NotNil(t, mapping.Module.Code)
+	assert.Len(t, mapping.Module.Code.Bodies, 1)
+	assert.Nil(t, mapping.data)
+}
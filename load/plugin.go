@@ -0,0 +1,148 @@
+package load
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/pgavlin/warp/exec"
+)
+
+const (
+	// PluginDefinitionSymbol is the name of the exec.ModuleDefinition exported by a plugin compiled with
+	// golang.CompilePlugin.
+	PluginDefinitionSymbol = "ModuleDefinition"
+	// PluginHashSymbol is the name of the module content hash exported by a plugin compiled with
+	// golang.CompilePlugin.
+	PluginHashSymbol = "ModuleHash"
+	// PluginHashExt is the extension of the file that records the module content hash of a plugin. The file is
+	// named for the plugin, e.g. "module.so.hash" for "module.so".
+	PluginHashExt = ".hash"
+)
+
+// A Plugin is a compiled module loaded from a Go plugin.
+type Plugin struct {
+	// Definition is the definition of the plugin's module.
+	Definition exec.ModuleDefinition
+	// Hash is the content hash of the plugin's module. Hash is empty if the module could not be hashed when the
+	// plugin was compiled.
+	Hash string
+}
+
+// OpenPlugin opens the Go plugin at the given path. The plugin must have been built from the output of
+// golang.CompilePlugin by a toolchain and a version of this module that match those of the running program.
+func OpenPlugin(path string) (*Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defSym, err := p.Lookup(PluginDefinitionSymbol)
+	if err != nil {
+		return nil, err
+	}
+	def, ok := defSym.(*exec.ModuleDefinition)
+	if !ok || *def == nil {
+		return nil, fmt.Errorf("plugin %v: %v is not an exec.ModuleDefinition", path, PluginDefinitionSymbol)
+	}
+
+	hashSym, err := p.Lookup(PluginHashSymbol)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := hashSym.(*string)
+	if !ok {
+		return nil, fmt.Errorf("plugin %v: %v is not a string", path, PluginHashSymbol)
+	}
+
+	return &Plugin{Definition: *def, Hash: *hash}, nil
+}
+
+// WritePluginHash records the module content hash of the plugin at the given path in a file alongside the plugin. The
+// plugin need not exist yet. PluginResolver.ResolveModuleHash reads the hash from this file before it opens the plugin.
+func WritePluginHash(path, hash string) error {
+	return ioutil.WriteFile(path+PluginHashExt, []byte(hash+"\n"), 0644)
+}
+
+// readPluginHash reads the module content hash recorded for the plugin at the given path by WritePluginHash.
+func readPluginHash(path string) (string, error) {
+	hash, err := ioutil.ReadFile(path + PluginHashExt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(hash)), nil
+}
+
+// A PluginResolver is an exec.ModuleResolver that resolves module names to compiled modules loaded from the Go
+// plugins in a directory. The plugin for a module is named for the module with the extension ".so". The module
+// content hash of a plugin is recorded alongside the plugin by WritePluginHash.
+//
+// Go plugins cannot be unloaded, so each plugin remains loaded once it has been opened.
+type PluginResolver struct {
+	dir string
+}
+
+// NewPluginResolver returns a PluginResolver that loads plugins from the given directory.
+func NewPluginResolver(dir string) *PluginResolver {
+	return &PluginResolver{dir: dir}
+}
+
+// OpenPlugin opens the plugin for the named module. If the plugin does not exist, OpenPlugin returns
+// exec.ErrModuleNotFound.
+func (r *PluginResolver) OpenPlugin(name string) (*Plugin, error) {
+	path := r.pluginPath(name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, exec.ErrModuleNotFound
+		}
+		return nil, err
+	}
+	return OpenPlugin(path)
+}
+
+// pluginPath returns the path to the plugin for the named module.
+func (r *PluginResolver) pluginPath(name string) string {
+	return filepath.Join(r.dir, name+".so")
+}
+
+func (r *PluginResolver) ResolveModule(name string) (exec.ModuleDefinition, error) {
+	p, err := r.OpenPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Definition, nil
+}
+
+// ResolveModuleHash resolves the named module to the definition in its plugin if the plugin was compiled from a
+// module with the given content hash. The hash recorded alongside the plugin is checked before the plugin is opened,
+// so a stale plugin is never loaded. If the plugin or its recorded hash does not exist or the recorded hash does not
+// match, ResolveModuleHash returns exec.ErrModuleNotFound.
+func (r *PluginResolver) ResolveModuleHash(name, hash string) (exec.ModuleDefinition, error) {
+	if hash == "" {
+		return nil, exec.ErrModuleNotFound
+	}
+
+	path := r.pluginPath(name)
+	recorded, err := readPluginHash(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, exec.ErrModuleNotFound
+		}
+		return nil, err
+	}
+	if recorded != hash {
+		return nil, exec.ErrModuleNotFound
+	}
+
+	p, err := r.OpenPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	if p.Hash != hash {
+		return nil, fmt.Errorf("plugin %v: %v %q does not match the recorded hash %q", path, PluginHashSymbol, p.Hash, hash)
+	}
+	return p.Definition, nil
+}
@@ -0,0 +1,124 @@
+package load
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pgavlin/warp/compiler/source/golang"
+	warpexec "github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// answerModule returns a binary module that exports a function that returns 42.
+func answerModule(t *testing.T) *wasm.Module {
+	var body bytes.Buffer
+	require.NoError(t, code.Encode(&body, []code.Instruction{code.I32Const(42), code.End()}))
+
+	m := &wasm.Module{
+		Types: &wasm.SectionTypes{
+			Entries: []wasm.FunctionSig{{Form: 0x60, ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32}}},
+		},
+		Function: &wasm.SectionFunctions{Types: []uint32{0}},
+		Export: &wasm.SectionExports{
+			Entries: []wasm.ExportEntry{{FieldStr: "answer", Kind: wasm.ExternalFunction, Index: 0}},
+		},
+		Code: &wasm.SectionCode{Bodies: []wasm.FunctionBody{{Code: body.Bytes()}}},
+	}
+	m.Sections = []wasm.Section{m.Types, m.Function, m.Export, m.Code}
+
+	var buf bytes.Buffer
+	require.NoError(t, wasm.EncodeModule(&buf, m))
+	m, err := wasm.DecodeModule(&buf)
+	require.NoError(t, err)
+	return m
+}
+
+func TestPluginResolver(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping plugin test in short mode")
+	}
+	if !build.Default.CgoEnabled {
+		t.Skip("plugins require cgo")
+	}
+
+	mod := answerModule(t)
+	hash, ok := wasm.ContentHash(mod)
+	require.True(t, ok)
+
+	// The plugin must be built from this module so that its dependencies match those of the test.
+	dir, err := ioutil.TempDir(".", "plugin_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var source bytes.Buffer
+	require.NoError(t, golang.CompilePlugin(&source, "answer", mod, nil))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "answer.go"), source.Bytes(), 0600))
+
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", "answer.so", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	r := NewPluginResolver(dir)
+
+	// Without a recorded hash, the plugin is not resolved.
+	_, err = r.ResolveModuleHash("answer", hash)
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+	require.NoError(t, WritePluginHash(filepath.Join(dir, "answer.so"), hash))
+
+	p, err := r.OpenPlugin("answer")
+	require.NoError(t, err)
+	assert.Equal(t, hash, p.Hash)
+
+	def, err := r.ResolveModuleHash("answer", hash)
+	require.NoError(t, err)
+
+	store := warpexec.NewStore(warpexec.MapResolver{"answer": def})
+	instance, err := store.InstantiateModule("answer")
+	require.NoError(t, err)
+	answer, err := instance.GetFunction("answer")
+	require.NoError(t, err)
+
+	thread := warpexec.NewThread(0)
+	returns := make([]uint64, 1)
+	answer.UncheckedCall(&thread, nil, returns)
+	assert.Equal(t, []uint64{42}, returns)
+
+	_, err = r.ResolveModuleHash("answer", "0000")
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+	_, err = r.ResolveModule("question")
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+}
+
+func TestPluginResolverStaleHash(t *testing.T) {
+	// The plugin is not a shared object, so opening it would fail.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answer.so")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a plugin"), 0600))
+
+	r := NewPluginResolver(dir)
+
+	// A plugin without a recorded hash or with a stale recorded hash is never opened.
+	_, err := r.ResolveModuleHash("answer", "0001")
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+
+	require.NoError(t, WritePluginHash(path, "0000"))
+	_, err = r.ResolveModuleHash("answer", "0001")
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+	_, err = r.ResolveModuleHash("answer", "")
+	assert.Equal(t, warpexec.ErrModuleNotFound, err)
+
+	// A plugin with a matching recorded hash is opened.
+	_, err = r.ResolveModuleHash("answer", "0000")
+	assert.Error(t, err)
+	assert.NotEqual(t, warpexec.ErrModuleNotFound, err)
+}
@@ -11,7 +11,14 @@ import (
 type ModuleDefinitionFunc func(m *wasm.Module) (exec.ModuleDefinition, error)
 
 func Intepret(m *wasm.Module) (exec.ModuleDefinition, error) {
-	return interpreter.NewModuleDefinition(m), nil
+	return interpreter.NewModuleDefinition(m, nil), nil
+}
+
+// Interpreter returns a ModuleDefinitionFunc that interprets modules using the given options.
+func Interpreter(options *interpreter.Options) ModuleDefinitionFunc {
+	return func(m *wasm.Module) (exec.ModuleDefinition, error) {
+		return interpreter.NewModuleDefinition(m, options), nil
+	}
 }
 
 type FSResolver struct {
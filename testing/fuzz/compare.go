@@ -0,0 +1,90 @@
+package fuzz
+
+import "fmt"
+
+// A Mismatch describes a difference between the behavior of two backends.
+type Mismatch struct {
+	// Backends are the names of the backends whose behavior differs.
+	Backends [2]string
+	// Description describes the difference.
+	Description string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("%v and %v disagree: %v", m.Backends[0], m.Backends[1], m.Description)
+}
+
+// Compare runs the given program on each of the given backends and compares the results with those of the first
+// backend. If the backends disagree, Compare returns a *Mismatch. If a backend fails to run the program, Compare
+// returns the backend's error.
+func Compare(p *Program, backends ...Backend) error {
+	var expected *Result
+	for i, backend := range backends {
+		result, err := backend.Run(p)
+		if err != nil {
+			return fmt.Errorf("%v: %w", backend.Name, err)
+		}
+
+		if i == 0 {
+			expected = result
+			continue
+		}
+		if description, ok := diff(p, expected, result); !ok {
+			return &Mismatch{Backends: [2]string{backends[0].Name, backend.Name}, Description: description}
+		}
+	}
+	return nil
+}
+
+func valuesEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diff describes the first difference between the given results of a program. It returns false if the results
+// differ.
+func diff(p *Program, expected, actual *Result) (string, bool) {
+	if expected.Error != actual.Error {
+		return fmt.Sprintf("instantiation: %q != %q", expected.Error, actual.Error), false
+	}
+
+	if len(expected.Calls) != len(actual.Calls) {
+		return fmt.Sprintf("%v calls != %v calls", len(expected.Calls), len(actual.Calls)), false
+	}
+	for i, e := range expected.Calls {
+		a, call := actual.Calls[i], p.Calls[i]
+		if e.Trap != a.Trap {
+			return fmt.Sprintf("call %v (%v%v): trap %q != trap %q", i, call.Function, call.Args, e.Trap, a.Trap), false
+		}
+		if !valuesEqual(e.Results, a.Results) {
+			return fmt.Sprintf("call %v (%v%v): results %#x != results %#x", i, call.Function, call.Args, e.Results, a.Results), false
+		}
+	}
+
+	if len(expected.Globals) != len(actual.Globals) {
+		return fmt.Sprintf("%v globals != %v globals", len(expected.Globals), len(actual.Globals)), false
+	}
+	for i, e := range expected.Globals {
+		if a := actual.Globals[i]; a != e {
+			return fmt.Sprintf("global %v: %#x != %#x", p.Globals[i], e, a), false
+		}
+	}
+
+	if len(expected.Memory) != len(actual.Memory) {
+		return fmt.Sprintf("memory size %v != memory size %v", len(expected.Memory), len(actual.Memory)), false
+	}
+	for i, b := range expected.Memory {
+		if actual.Memory[i] != b {
+			return fmt.Sprintf("memory[%#x]: %#02x != %#02x", i, b, actual.Memory[i]), false
+		}
+	}
+
+	return "", true
+}
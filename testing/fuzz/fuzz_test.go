@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package fuzz
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/pgavlin/warp/wast"
+)
+
+// FuzzDifferential generates programs from the fuzzer's input and compares their behavior on the interpreter's
+// backends and, if requested, the Go compiler's backends. Failing programs are minimized before they are reported.
+func FuzzDifferential(f *testing.F) {
+	for seed := int64(0); seed < 16; seed++ {
+		data := make([]byte, 4096)
+		rand.New(rand.NewSource(seed)).Read(data)
+		f.Add(data)
+	}
+
+	backends := testBackends()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := Generate(data, nil)
+
+		err := Compare(p, backends...)
+		if err == nil {
+			return
+		}
+
+		var mismatch *Mismatch
+		if !errors.As(err, &mismatch) {
+			t.Fatal(err)
+		}
+
+		p = Minimize(p, func(p *Program) bool {
+			var m *Mismatch
+			return errors.As(Compare(p, backends...), &m)
+		})
+
+		var wat strings.Builder
+		if err := wast.WriteTo(&wat, p.Module); err != nil {
+			t.Fatal(err)
+		}
+		t.Fatalf("%v\n\nminimized program:\n%v\ncalls: %+v\n\nminimized error: %v", err, wat.String(), p.Calls, Compare(p, backends...))
+	})
+}
@@ -0,0 +1,956 @@
+// Package fuzz implements differential testing of warp's execution backends. Generate produces a random, valid
+// WebAssembly module and a sequence of calls to its exports from an arbitrary byte string, Compare runs the result
+// on a set of backends and reports any difference in their behavior, and Minimize shrinks a program that exposes such
+// a difference.
+package fuzz
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// Config controls the shape of the programs produced by Generate.
+type Config struct {
+	// MaxFunctions is the maximum number of functions in a module. Defaults to 8.
+	MaxFunctions int
+	// MaxInstructions is the approximate maximum number of instructions in each function body. Defaults to 256.
+	MaxInstructions int
+	// MaxDepth is the maximum nesting depth of expressions and blocks. Defaults to 6.
+	MaxDepth int
+	// MaxCalls is the maximum number of calls to the module's exports. Defaults to 8.
+	MaxCalls int
+	// Fuel is the number of function calls and loop iterations that each call to an export may execute before it
+	// traps. Defaults to 1000.
+	Fuel int32
+}
+
+func (c *Config) withDefaults() Config {
+	var config Config
+	if c != nil {
+		config = *c
+	}
+	if config.MaxFunctions <= 0 {
+		config.MaxFunctions = 8
+	}
+	if config.MaxInstructions <= 0 {
+		config.MaxInstructions = 256
+	}
+	if config.MaxDepth <= 0 {
+		config.MaxDepth = 6
+	}
+	if config.MaxCalls <= 0 {
+		config.MaxCalls = 8
+	}
+	if config.Fuel <= 0 {
+		config.Fuel = 1000
+	}
+	return config
+}
+
+// A Call is a call to an exported function.
+type Call struct {
+	// Function is the name of the exported function.
+	Function string `json:"function"`
+	// Args are the raw values of the call's arguments.
+	Args []uint64 `json:"args,omitempty"`
+}
+
+// A Program is a module and the calls to make to its exports.
+//
+// Each of the program's functions consumes a unit of fuel when it is called and on each iteration of a loop, and
+// traps with an unreachable trap if no fuel remains. This guarantees that every call terminates.
+type Program struct {
+	// Module is the program's module. Its functions, memory, and globals are exported.
+	Module *wasm.Module `json:"-"`
+	// Fuel is the value of the exported global "fuel" at the start of each call.
+	Fuel int32 `json:"fuel"`
+	// Calls are the calls to make, in order, to a single instance of the module.
+	Calls []Call `json:"calls"`
+	// Globals are the names of the exported globals whose final values are compared.
+	Globals []string `json:"globals"`
+
+	bodies [][]code.Instruction
+}
+
+// A source supplies the choices made by the generator. Once its data is exhausted, a source returns zeros, so
+// generation always terminates, and the first option of each choice must be the simplest.
+type source struct {
+	data []byte
+}
+
+func (s *source) byte() byte {
+	if len(s.data) == 0 {
+		return 0
+	}
+	b := s.data[0]
+	s.data = s.data[1:]
+	return b
+}
+
+func (s *source) uint32() uint32 {
+	return uint32(s.byte()) | uint32(s.byte())<<8 | uint32(s.byte())<<16 | uint32(s.byte())<<24
+}
+
+func (s *source) uint64() uint64 {
+	return uint64(s.uint32()) | uint64(s.uint32())<<32
+}
+
+// intn returns a value in [0, n).
+func (s *source) intn(n int) int {
+	switch {
+	case n <= 1:
+		return 0
+	case n <= 256:
+		return int(s.byte()) % n
+	default:
+		return int(s.uint32() % uint32(n))
+	}
+}
+
+// chance returns true with a probability of roughly 1 in n.
+func (s *source) chance(n int) bool {
+	return s.intn(n) == n-1
+}
+
+var valueTypes = []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64}
+
+// value returns the raw bits of an interesting value of the given type.
+func (s *source) value(t wasm.ValueType) uint64 {
+	switch t {
+	case wasm.ValueTypeI32:
+		values := []int32{0, 1, -1, math.MinInt32, math.MaxInt32, 8, 31, 32, 0xff, 0x10000}
+		if i := s.intn(len(values) + 1); i < len(values) {
+			return uint64(uint32(values[i]))
+		}
+		return uint64(s.uint32())
+	case wasm.ValueTypeI64:
+		values := []int64{0, 1, -1, math.MinInt64, math.MaxInt64, 63, 64, math.MinInt32, math.MaxUint32}
+		if i := s.intn(len(values) + 1); i < len(values) {
+			return uint64(values[i])
+		}
+		return s.uint64()
+	case wasm.ValueTypeF32:
+		values := []float32{0, float32(math.Copysign(0, -1)), 1, -1, 0.5, -1.5, float32(math.NaN()), float32(math.Inf(1)),
+			float32(math.Inf(-1)), math.MaxFloat32, math.SmallestNonzeroFloat32, 1 << 31, 1 << 32, 1 << 63}
+		if i := s.intn(len(values) + 1); i < len(values) {
+			return uint64(math.Float32bits(values[i]))
+		}
+		return uint64(s.uint32())
+	default:
+		values := []float64{0, math.Copysign(0, -1), 1, -1, 0.5, -1.5, math.NaN(), math.Inf(1), math.Inf(-1),
+			math.MaxFloat64, math.SmallestNonzeroFloat64, 1 << 31, 1 << 32, 1 << 63, 1 << 64}
+		if i := s.intn(len(values) + 1); i < len(values) {
+			return math.Float64bits(values[i])
+		}
+		return s.uint64()
+	}
+}
+
+// valueType returns a random value type.
+func (s *source) valueType() wasm.ValueType {
+	return valueTypes[s.intn(len(valueTypes))]
+}
+
+// constant returns an instruction that pushes the given raw value.
+func constant(t wasm.ValueType, v uint64) code.Instruction {
+	switch t {
+	case wasm.ValueTypeI32:
+		return code.I32Const(int32(v))
+	case wasm.ValueTypeI64:
+		return code.I64Const(int64(v))
+	case wasm.ValueTypeF32:
+		return code.Instruction{Opcode: code.OpF32Const, Immediate: uint64(uint32(v))}
+	default:
+		return code.Instruction{Opcode: code.OpF64Const, Immediate: v}
+	}
+}
+
+// constExpr returns a constant expression that computes the given raw value.
+func constExpr(t wasm.ValueType, v uint64) []byte {
+	var buf bytes.Buffer
+	if err := code.Encode(&buf, []code.Instruction{constant(t, v), code.End()}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// numericOps are the numeric instructions that produce values of each type.
+var numericOps = func() map[wasm.ValueType][]code.Instruction {
+	var instrs []code.Instruction
+	for op := code.OpI32Eqz; op <= code.OpI64Extend32S; op++ {
+		instrs = append(instrs, code.Instruction{Opcode: byte(op)})
+	}
+	for op := code.OpI32TruncSatF32S; op <= code.OpI64TruncSatF64U; op++ {
+		instrs = append(instrs, code.Instruction{Opcode: code.OpPrefix, Immediate: uint64(op)})
+	}
+
+	ops := map[wasm.ValueType][]code.Instruction{}
+	for _, instr := range instrs {
+		_, push := instr.Types(nil)
+		ops[push[0]] = append(ops[push[0]], instr)
+	}
+	return ops
+}()
+
+// canonicalizes returns true if the result of the given instruction may be a NaN whose bits are not determined by
+// the instruction's operands, and must therefore be canonicalized.
+func canonicalizes(instr code.Instruction) bool {
+	switch instr.Opcode {
+	case code.OpF32Abs, code.OpF32Neg, code.OpF32Copysign, code.OpF64Abs, code.OpF64Neg, code.OpF64Copysign,
+		code.OpF32ReinterpretI32, code.OpF64ReinterpretI64:
+		return false
+	}
+	_, push := instr.Types(nil)
+	return push[0] == wasm.ValueTypeF32 || push[0] == wasm.ValueTypeF64
+}
+
+type memoryOp struct {
+	new   func(offset, align uint32) code.Instruction
+	type_ wasm.ValueType
+	align uint32
+}
+
+var loads = []memoryOp{
+	{code.I32Load, wasm.ValueTypeI32, 2},
+	{code.I64Load, wasm.ValueTypeI64, 3},
+	{code.F32Load, wasm.ValueTypeF32, 2},
+	{code.F64Load, wasm.ValueTypeF64, 3},
+	{code.I32Load8S, wasm.ValueTypeI32, 0},
+	{code.I32Load8U, wasm.ValueTypeI32, 0},
+	{code.I32Load16S, wasm.ValueTypeI32, 1},
+	{code.I32Load16U, wasm.ValueTypeI32, 1},
+	{code.I64Load8S, wasm.ValueTypeI64, 0},
+	{code.I64Load8U, wasm.ValueTypeI64, 0},
+	{code.I64Load16S, wasm.ValueTypeI64, 1},
+	{code.I64Load16U, wasm.ValueTypeI64, 1},
+	{code.I64Load32S, wasm.ValueTypeI64, 2},
+	{code.I64Load32U, wasm.ValueTypeI64, 2},
+}
+
+var stores = []memoryOp{
+	{code.I32Store, wasm.ValueTypeI32, 2},
+	{code.I64Store, wasm.ValueTypeI64, 3},
+	{code.F32Store, wasm.ValueTypeF32, 2},
+	{code.F64Store, wasm.ValueTypeF64, 3},
+	{code.I32Store8, wasm.ValueTypeI32, 0},
+	{code.I32Store16, wasm.ValueTypeI32, 1},
+	{code.I64Store8, wasm.ValueTypeI64, 0},
+	{code.I64Store16, wasm.ValueTypeI64, 1},
+	{code.I64Store32, wasm.ValueTypeI64, 2},
+}
+
+var blockTypes = map[wasm.ValueType]uint64{
+	wasm.ValueTypeI32: code.BlockTypeI32,
+	wasm.ValueTypeI64: code.BlockTypeI64,
+	wasm.ValueTypeF32: code.BlockTypeF32,
+	wasm.ValueTypeF64: code.BlockTypeF64,
+}
+
+const pageSize = 65536
+
+// The fuel global is always the module's first global.
+const fuelGlobal = 0
+
+type moduleGenerator struct {
+	src    *source
+	config Config
+
+	types     []wasm.FunctionSig
+	functions []uint32 // the type of each function
+	globals   []wasm.GlobalVar
+	tableSize int
+}
+
+// Generate generates a program from the given data. The same data and configuration always produce the same
+// program. If config is nil, the default configuration is used.
+func Generate(data []byte, config *Config) *Program {
+	g := &moduleGenerator{src: &source{data: data}, config: config.withDefaults()}
+
+	// Function types. Blocks may also use these types.
+	for i, n := 0, 1+g.src.intn(6); i < n; i++ {
+		var sig wasm.FunctionSig
+		sig.Form = 0x60
+		sig.ParamTypes = make([]wasm.ValueType, g.src.intn(5))
+		for j := range sig.ParamTypes {
+			sig.ParamTypes[j] = g.src.valueType()
+		}
+		sig.ReturnTypes = make([]wasm.ValueType, g.src.intn(3))
+		for j := range sig.ReturnTypes {
+			sig.ReturnTypes[j] = g.src.valueType()
+		}
+		g.types = append(g.types, sig)
+	}
+
+	g.functions = make([]uint32, 1+g.src.intn(g.config.MaxFunctions))
+	for i := range g.functions {
+		g.functions[i] = uint32(g.src.intn(len(g.types)))
+	}
+	g.tableSize = len(g.functions) + g.src.intn(3)
+
+	module := &wasm.Module{
+		Version:  1,
+		Types:    &wasm.SectionTypes{Entries: g.types},
+		Function: &wasm.SectionFunctions{Types: g.functions},
+		Table: &wasm.SectionTables{Entries: []wasm.Table{{
+			ElementType: wasm.ElemTypeAnyFunc,
+			Limits:      wasm.ResizableLimits{Flags: 1, Initial: uint32(g.tableSize), Maximum: uint32(g.tableSize)},
+		}}},
+		Memory: &wasm.SectionMemories{Entries: []wasm.Memory{{
+			Limits: wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: uint32(1 + g.src.intn(3))},
+		}}},
+		Global: &wasm.SectionGlobals{},
+		Export: &wasm.SectionExports{},
+	}
+	program := &Program{Fuel: g.config.Fuel}
+
+	// Globals. The first global is the fuel.
+	g.globals = append(g.globals, wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true})
+	module.Global.Globals = append(module.Global.Globals, wasm.GlobalEntry{Type: g.globals[0], Init: constExpr(wasm.ValueTypeI32, 0)})
+	module.Export.Entries = append(module.Export.Entries, wasm.ExportEntry{FieldStr: "fuel", Kind: wasm.ExternalGlobal, Index: fuelGlobal})
+	for i, n := 0, g.src.intn(8); i < n; i++ {
+		type_ := wasm.GlobalVar{Type: g.src.valueType(), Mutable: g.src.intn(4) != 0}
+		index := uint32(len(g.globals))
+		g.globals = append(g.globals, type_)
+
+		name := "g" + strconv.Itoa(int(index))
+		module.Global.Globals = append(module.Global.Globals, wasm.GlobalEntry{Type: type_, Init: constExpr(type_.Type, g.src.value(type_.Type))})
+		module.Export.Entries = append(module.Export.Entries, wasm.ExportEntry{FieldStr: name, Kind: wasm.ExternalGlobal, Index: index})
+		program.Globals = append(program.Globals, name)
+	}
+
+	module.Export.Entries = append(module.Export.Entries, wasm.ExportEntry{FieldStr: "memory", Kind: wasm.ExternalMemory})
+
+	// Every function is exported and present in the table.
+	elems := make([]uint32, len(g.functions))
+	for i := range g.functions {
+		elems[i] = uint32(i)
+		module.Export.Entries = append(module.Export.Entries, wasm.ExportEntry{FieldStr: functionName(i), Kind: wasm.ExternalFunction, Index: uint32(i)})
+	}
+	module.Elements = &wasm.SectionElements{Entries: []wasm.ElementSegment{{Offset: constExpr(wasm.ValueTypeI32, 0), Elems: elems}}}
+
+	// Data segments.
+	if n := g.src.intn(3); n != 0 {
+		module.Data = &wasm.SectionData{}
+		for i := 0; i < n; i++ {
+			data := make([]byte, 1+g.src.intn(64))
+			for j := range data {
+				data[j] = g.src.byte()
+			}
+			offset := uint64(g.src.intn(pageSize - len(data)))
+			module.Data.Entries = append(module.Data.Entries, wasm.DataSegment{Offset: constExpr(wasm.ValueTypeI32, offset), Data: data})
+		}
+	}
+
+	// Function bodies.
+	module.Code = &wasm.SectionCode{Bodies: make([]wasm.FunctionBody, len(g.functions))}
+	program.bodies = make([][]code.Instruction, len(g.functions))
+	for i := range g.functions {
+		f := g.newFunctionGenerator(i)
+		module.Code.Bodies[i].Locals = f.localEntries()
+		program.bodies[i] = f.body()
+	}
+
+	// Calls.
+	for i, n := 0, 1+g.src.intn(g.config.MaxCalls); i < n; i++ {
+		index := g.src.intn(len(g.functions))
+		sig := g.types[g.functions[index]]
+
+		args := make([]uint64, len(sig.ParamTypes))
+		for j, t := range sig.ParamTypes {
+			args[j] = g.src.value(t)
+		}
+		program.Calls = append(program.Calls, Call{Function: functionName(index), Args: args})
+	}
+
+	if err := program.setModule(module); err != nil {
+		panic(err)
+	}
+	return program
+}
+
+func functionName(index int) string {
+	return "f" + strconv.Itoa(index)
+}
+
+// A label is the target of a branch.
+type label struct {
+	types []wasm.ValueType
+	loop  bool
+}
+
+type functionGenerator struct {
+	*moduleGenerator
+
+	index  int
+	sig    wasm.FunctionSig
+	locals []wasm.ValueType
+
+	// The function's locals begin with its parameters and the locals that its code may access. These are followed by
+	// a scratch local of each floating-point type for NaN canonicalization and a loop counter for each nesting level.
+	userLocals int
+	scratch    map[wasm.ValueType]uint32
+	counters   uint32
+
+	labels []label
+	instrs []code.Instruction
+	budget int
+	depth  int
+}
+
+func (g *moduleGenerator) newFunctionGenerator(index int) *functionGenerator {
+	sig := g.types[g.functions[index]]
+
+	f := &functionGenerator{
+		moduleGenerator: g,
+		index:           index,
+		sig:             sig,
+		locals:          append([]wasm.ValueType(nil), sig.ParamTypes...),
+		budget:          g.config.MaxInstructions,
+	}
+	for i, n := 0, g.src.intn(8); i < n; i++ {
+		f.locals = append(f.locals, g.src.valueType())
+	}
+	f.userLocals = len(f.locals)
+
+	f.scratch = map[wasm.ValueType]uint32{}
+	for _, t := range []wasm.ValueType{wasm.ValueTypeF32, wasm.ValueTypeF64} {
+		f.scratch[t] = uint32(len(f.locals))
+		f.locals = append(f.locals, t)
+	}
+
+	f.counters = uint32(len(f.locals))
+	for i := 0; i <= g.config.MaxDepth; i++ {
+		f.locals = append(f.locals, wasm.ValueTypeI32)
+	}
+	return f
+}
+
+// localEntries returns the declarations of the function's non-parameter locals.
+func (f *functionGenerator) localEntries() []wasm.LocalEntry {
+	var entries []wasm.LocalEntry
+	for _, t := range f.locals[len(f.sig.ParamTypes):] {
+		if len(entries) != 0 && entries[len(entries)-1].Type == t {
+			entries[len(entries)-1].Count++
+		} else {
+			entries = append(entries, wasm.LocalEntry{Count: 1, Type: t})
+		}
+	}
+	return entries
+}
+
+func (f *functionGenerator) body() []code.Instruction {
+	f.labels = []label{{types: f.sig.ReturnTypes}}
+	f.emit(consumeFuel...)
+	f.sequence(f.sig.ReturnTypes)
+	f.emit(code.End())
+	return f.instrs
+}
+
+func (f *functionGenerator) emit(instrs ...code.Instruction) {
+	f.instrs = append(f.instrs, instrs...)
+	f.budget -= len(instrs)
+}
+
+// exhausted returns true if the generator should emit only the simplest code.
+func (f *functionGenerator) exhausted() bool {
+	return f.budget <= 0 || f.depth >= f.config.MaxDepth
+}
+
+// consumeFuel is the code that traps if the fuel is exhausted and decrements it otherwise. It begins each function
+// body and loop.
+var consumeFuel = []code.Instruction{
+	code.GlobalGet(fuelGlobal),
+	code.I32Eqz(),
+	code.If(),
+	code.Unreachable(),
+	code.End(),
+	code.GlobalGet(fuelGlobal),
+	code.I32Const(1),
+	code.I32Sub(),
+	code.GlobalSet(fuelGlobal),
+}
+
+// consumesFuel returns true if the given body and each of its loops begin with consumeFuel.
+func consumesFuel(body []code.Instruction) bool {
+	begins := func(start int) bool {
+		if len(body)-start < len(consumeFuel) {
+			return false
+		}
+		for i, instr := range consumeFuel {
+			if actual := body[start+i]; actual.Opcode != instr.Opcode || actual.Immediate != instr.Immediate {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !begins(0) {
+		return false
+	}
+	for i, instr := range body {
+		if instr.Opcode == code.OpLoop && !begins(i+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// enter pushes a label and increases the nesting depth. The returned function undoes both.
+func (f *functionGenerator) enter(l label) func() {
+	f.labels, f.depth = append(f.labels, l), f.depth+1
+	return func() { f.labels, f.depth = f.labels[:len(f.labels)-1], f.depth-1 }
+}
+
+// label returns the label with the given relative depth.
+func (f *functionGenerator) label(depth int) label {
+	return f.labels[len(f.labels)-1-depth]
+}
+
+// branchTarget returns the relative depth of a branch's target. Branches to loops usually repeat until the fuel is
+// exhausted, so they are rare.
+func (f *functionGenerator) branchTarget() int {
+	if f.src.chance(8) {
+		return f.src.intn(len(f.labels))
+	}
+
+	var targets []int
+	for i := range f.labels {
+		if !f.label(i).loop {
+			targets = append(targets, i)
+		}
+	}
+	return targets[f.src.intn(len(targets))]
+}
+
+// sequence emits a sequence of statements followed by either values of the given types or an instruction that
+// transfers control elsewhere.
+func (f *functionGenerator) sequence(results []wasm.ValueType) {
+	n := 0
+	if !f.exhausted() {
+		n = f.src.intn(5)
+	}
+	for i := 0; i < n; i++ {
+		f.statement()
+	}
+
+	if !f.exhausted() && f.src.chance(8) {
+		f.terminator()
+		return
+	}
+	for _, t := range results {
+		f.expr(t)
+	}
+}
+
+// localsOfType returns the indices of the accessible locals of the given type.
+func (f *functionGenerator) localsOfType(t wasm.ValueType) []uint32 {
+	var locals []uint32
+	for i, lt := range f.locals[:f.userLocals] {
+		if lt == t {
+			locals = append(locals, uint32(i))
+		}
+	}
+	return locals
+}
+
+// globalsOfType returns the indices of the globals of the given type. The fuel global is excluded.
+func (f *functionGenerator) globalsOfType(t wasm.ValueType, mutable bool) []uint32 {
+	var globals []uint32
+	for i, g := range f.globals[1:] {
+		if g.Type == t && (g.Mutable || !mutable) {
+			globals = append(globals, uint32(i+1))
+		}
+	}
+	return globals
+}
+
+// callees returns the functions with the given results that the function may call directly. Functions only call
+// functions with greater indices, so direct calls never recurse.
+func (f *functionGenerator) callees(results func([]wasm.ValueType) bool) []int {
+	var callees []int
+	for i := f.index + 1; i < len(f.functions); i++ {
+		if results(f.types[f.functions[i]].ReturnTypes) {
+			callees = append(callees, i)
+		}
+	}
+	return callees
+}
+
+// typesWithResults returns the indices of the types with the given results.
+func (f *functionGenerator) typesWithResults(results func([]wasm.ValueType) bool) []int {
+	var types []int
+	for i, sig := range f.types {
+		if results(sig.ReturnTypes) {
+			types = append(types, i)
+		}
+	}
+	return types
+}
+
+func single(t wasm.ValueType) func([]wasm.ValueType) bool {
+	return func(results []wasm.ValueType) bool { return len(results) == 1 && results[0] == t }
+}
+
+func anyResults([]wasm.ValueType) bool {
+	return true
+}
+
+func typesEqual(a, b []wasm.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// leaf emits an instruction that pushes a value of the given type without consuming any operands.
+func (f *functionGenerator) leaf(t wasm.ValueType) {
+	switch f.src.intn(4) {
+	case 1:
+		if locals := f.localsOfType(t); len(locals) != 0 {
+			f.emit(code.LocalGet(locals[f.src.intn(len(locals))]))
+			return
+		}
+	case 2:
+		if globals := f.globalsOfType(t, false); len(globals) != 0 {
+			f.emit(code.GlobalGet(globals[f.src.intn(len(globals))]))
+			return
+		}
+	}
+	f.emit(constant(t, f.src.value(t)))
+}
+
+// address emits an expression that computes a memory address. Most addresses are masked to fall within the first
+// half of the first page of memory, which leaves room for the access's offset.
+func (f *functionGenerator) address() {
+	f.expr(wasm.ValueTypeI32)
+	if !f.src.chance(32) {
+		f.emit(code.I32Const(pageSize/2-1), code.I32And())
+	}
+}
+
+// memarg returns the offset and alignment for an access with the given natural alignment.
+func (f *functionGenerator) memarg(natural uint32) (uint32, uint32) {
+	offset := uint32(f.src.intn(16))
+	if f.src.chance(64) {
+		offset = f.src.uint32()
+	}
+	return offset, uint32(f.src.intn(int(natural) + 1))
+}
+
+// args emits expressions for the given parameters.
+func (f *functionGenerator) args(params []wasm.ValueType) {
+	for _, t := range params {
+		f.expr(t)
+	}
+}
+
+// callIndirectIndex emits an expression that computes a table index for a call_indirect with the given type. Most
+// indices refer to functions of that type.
+func (f *functionGenerator) callIndirectIndex(typeidx int) {
+	var matches []int
+	for i, t := range f.functions {
+		if f.types[t].Equals(f.types[typeidx]) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch {
+	case f.src.chance(16):
+		f.expr(wasm.ValueTypeI32)
+	case len(matches) == 0 || f.src.chance(16):
+		f.emit(code.I32Const(int32(f.src.intn(f.tableSize + 1))))
+	default:
+		f.emit(code.I32Const(int32(matches[f.src.intn(len(matches))])))
+	}
+}
+
+// canonicalize emits code that replaces a NaN of the given type on top of the stack with the canonical NaN.
+func (f *functionGenerator) canonicalize(t wasm.ValueType) {
+	scratch := f.scratch[t]
+	nan, eq := constant(t, uint64(0x7fc00000)), code.Instruction{Opcode: code.OpF32Eq}
+	if t == wasm.ValueTypeF64 {
+		nan, eq = constant(t, 0x7ff8000000000000), code.Instruction{Opcode: code.OpF64Eq}
+	}
+	f.emit(code.LocalTee(scratch), nan, code.LocalGet(scratch), code.LocalGet(scratch), eq, code.Select())
+}
+
+// expr emits an expression that pushes a single value of the given type.
+func (f *functionGenerator) expr(t wasm.ValueType) {
+	if f.exhausted() {
+		f.leaf(t)
+		return
+	}
+
+	f.depth++
+	defer func() { f.depth-- }()
+
+	switch f.src.intn(14) {
+	case 1, 2, 3:
+		ops := numericOps[t]
+		instr := ops[f.src.intn(len(ops))]
+		pop, _ := instr.Types(nil)
+		for _, p := range pop {
+			f.expr(p)
+		}
+		f.emit(instr)
+		if canonicalizes(instr) {
+			f.canonicalize(t)
+		}
+		return
+	case 4:
+		var candidates []memoryOp
+		for _, op := range loads {
+			if op.type_ == t {
+				candidates = append(candidates, op)
+			}
+		}
+		op := candidates[f.src.intn(len(candidates))]
+		f.address()
+		f.emit(op.new(f.memarg(op.align)))
+		return
+	case 5:
+		f.expr(t)
+		f.expr(t)
+		f.expr(wasm.ValueTypeI32)
+		f.emit(code.Select())
+		return
+	case 6:
+		f.emit(code.Block(blockTypes[t]))
+		exit := f.enter(label{types: []wasm.ValueType{t}})
+		f.sequence([]wasm.ValueType{t})
+		exit()
+		f.emit(code.End())
+		return
+	case 7:
+		f.expr(wasm.ValueTypeI32)
+		f.emit(code.If(blockTypes[t]))
+		exit := f.enter(label{types: []wasm.ValueType{t}})
+		f.sequence([]wasm.ValueType{t})
+		f.emit(code.Else())
+		f.sequence([]wasm.ValueType{t})
+		exit()
+		f.emit(code.End())
+		return
+	case 8:
+		f.loop([]wasm.ValueType{t})
+		return
+	case 9:
+		if callees := f.callees(single(t)); len(callees) != 0 {
+			callee := callees[f.src.intn(len(callees))]
+			f.args(f.types[f.functions[callee]].ParamTypes)
+			f.emit(code.Call(uint32(callee)))
+			return
+		}
+	case 10:
+		if types := f.typesWithResults(single(t)); len(types) != 0 {
+			typeidx := types[f.src.intn(len(types))]
+			f.args(f.types[typeidx].ParamTypes)
+			f.callIndirectIndex(typeidx)
+			f.emit(code.CallIndirect(uint32(typeidx)))
+			return
+		}
+	case 11:
+		if locals := f.localsOfType(t); len(locals) != 0 {
+			f.expr(t)
+			f.emit(code.LocalTee(locals[f.src.intn(len(locals))]))
+			return
+		}
+	case 12:
+		if types := f.typesWithResults(single(t)); len(types) != 0 {
+			f.multiValueBlock(types[f.src.intn(len(types))])
+			return
+		}
+	case 13:
+		if t == wasm.ValueTypeI32 {
+			if f.src.intn(2) == 0 {
+				f.emit(code.MemorySize())
+			} else {
+				f.expr(wasm.ValueTypeI32)
+				f.emit(code.I32Const(3), code.I32And(), code.MemoryGrow())
+			}
+			return
+		}
+	}
+	f.leaf(t)
+}
+
+// loop emits a loop that iterates a bounded number of times and produces values of the given types. Branches to the
+// loop from within its body skip the decrement of its counter, but still consume fuel.
+func (f *functionGenerator) loop(results []wasm.ValueType) {
+	counter := f.counters + uint32(f.depth)
+
+	blockType := uint64(code.BlockTypeEmpty)
+	if len(results) == 1 {
+		blockType = blockTypes[results[0]]
+	}
+
+	f.emit(code.I32Const(int32(1+f.src.intn(4))), code.LocalSet(counter), code.Loop(blockType))
+	exit := f.enter(label{loop: true})
+	f.emit(consumeFuel...)
+	n := 0
+	if !f.exhausted() {
+		n = f.src.intn(4)
+	}
+	for i := 0; i < n; i++ {
+		f.statement()
+	}
+	f.emit(code.LocalGet(counter), code.I32Const(1), code.I32Sub(), code.LocalTee(counter), code.BrIf(0))
+	for _, t := range results {
+		f.expr(t)
+	}
+	exit()
+	f.emit(code.End())
+}
+
+// multiValueBlock emits a block with the given type. The block's parameters are dropped.
+func (f *functionGenerator) multiValueBlock(typeidx int) {
+	sig := f.types[typeidx]
+
+	f.args(sig.ParamTypes)
+	f.emit(code.Block(code.BlockType(uint32(typeidx))))
+	exit := f.enter(label{types: sig.ReturnTypes})
+	for range sig.ParamTypes {
+		f.emit(code.Drop())
+	}
+	f.sequence(sig.ReturnTypes)
+	exit()
+	f.emit(code.End())
+}
+
+// drop emits instructions that drop values of the given types.
+func (f *functionGenerator) drop(types []wasm.ValueType) {
+	for range types {
+		f.emit(code.Drop())
+	}
+}
+
+// statement emits a sequence of instructions that leaves the stack unchanged.
+func (f *functionGenerator) statement() {
+	f.depth++
+	defer func() { f.depth-- }()
+
+	switch f.src.intn(14) {
+	case 1, 2:
+		t := f.src.valueType()
+		if locals := f.localsOfType(t); len(locals) != 0 {
+			f.expr(t)
+			f.emit(code.LocalSet(locals[f.src.intn(len(locals))]))
+			return
+		}
+	case 3:
+		t := f.src.valueType()
+		if globals := f.globalsOfType(t, true); len(globals) != 0 {
+			f.expr(t)
+			f.emit(code.GlobalSet(globals[f.src.intn(len(globals))]))
+			return
+		}
+	case 4, 5:
+		op := stores[f.src.intn(len(stores))]
+		f.address()
+		f.expr(op.type_)
+		f.emit(op.new(f.memarg(op.align)))
+		return
+	case 6:
+		f.expr(f.src.valueType())
+		f.emit(code.Drop())
+		return
+	case 7:
+		if callees := f.callees(anyResults); len(callees) != 0 {
+			callee := callees[f.src.intn(len(callees))]
+			sig := f.types[f.functions[callee]]
+			f.args(sig.ParamTypes)
+			f.emit(code.Call(uint32(callee)))
+			f.drop(sig.ReturnTypes)
+			return
+		}
+	case 8:
+		typeidx := f.src.intn(len(f.types))
+		sig := f.types[typeidx]
+		f.args(sig.ParamTypes)
+		f.callIndirectIndex(typeidx)
+		f.emit(code.CallIndirect(uint32(typeidx)))
+		f.drop(sig.ReturnTypes)
+		return
+	case 9:
+		f.emit(code.Block())
+		exit := f.enter(label{})
+		f.sequence(nil)
+		exit()
+		f.emit(code.End())
+		return
+	case 10:
+		f.loop(nil)
+		return
+	case 11:
+		f.expr(wasm.ValueTypeI32)
+		f.emit(code.If())
+		exit := f.enter(label{})
+		f.sequence(nil)
+		if f.src.intn(2) == 0 {
+			f.emit(code.Else())
+			f.sequence(nil)
+		}
+		exit()
+		f.emit(code.End())
+		return
+	case 12:
+		target := f.branchTarget()
+		types := f.label(target).types
+		f.args(types)
+		f.expr(wasm.ValueTypeI32)
+		f.emit(code.BrIf(target))
+		f.drop(types)
+		return
+	case 13:
+		typeidx := f.src.intn(len(f.types))
+		f.multiValueBlock(typeidx)
+		f.drop(f.types[typeidx].ReturnTypes)
+		return
+	}
+	f.emit(code.Nop())
+}
+
+// terminator emits an instruction that unconditionally transfers control elsewhere, along with its operands.
+func (f *functionGenerator) terminator() {
+	f.depth++
+	defer func() { f.depth-- }()
+
+	switch f.src.intn(8) {
+	case 0:
+		f.emit(code.Unreachable())
+	case 1, 2:
+		f.args(f.sig.ReturnTypes)
+		f.emit(code.Return())
+	case 3, 4, 5:
+		target := f.branchTarget()
+		f.args(f.label(target).types)
+		f.emit(code.Br(target))
+	default:
+		target := f.branchTarget()
+		types := f.label(target).types
+
+		var targets []int
+		for i := range f.labels {
+			if typesEqual(f.label(i).types, types) {
+				targets = append(targets, i)
+			}
+		}
+		labels := make([]int, 1+f.src.intn(4))
+		for i := range labels {
+			labels[i] = targets[f.src.intn(len(targets))]
+		}
+
+		f.args(types)
+		f.expr(wasm.ValueTypeI32)
+		f.emit(code.BrTable(labels[0], labels[1:]...))
+	}
+}
@@ -0,0 +1,127 @@
+package fuzz
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/pgavlin/warp/compiler/source/golang"
+	"github.com/pgavlin/warp/wasm/validate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fuzzGo = flag.Bool("go", false, "compare the Go compiler's output with the interpreter")
+var fuzzGoOptimize = flag.Bool("go-optimize", false, "compare the optimized Go compiler's output with the interpreter")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+// testBackends returns the backends selected by the test flags.
+func testBackends() []Backend {
+	backends := []Backend{ICode, FCode}
+	if *fuzzGo {
+		backends = append(backends, GoBackend("go", "../..", nil))
+	}
+	if *fuzzGoOptimize {
+		backends = append(backends, GoBackend("go-optimize", "../..", &golang.Options{
+			ConstantPropagation:      true,
+			CopyPropagation:          true,
+			DeadCodeElimination:      true,
+			RedundantLoadElimination: true,
+			BoundsCheckElimination:   true,
+		}))
+	}
+	return backends
+}
+
+func seedProgram(seed int64, config *Config) *Program {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return Generate(data, config)
+}
+
+func TestGenerate(t *testing.T) {
+	for seed := int64(0); seed < 64; seed++ {
+		p := seedProgram(seed, nil)
+		require.NoError(t, validate.ValidateModule(p.Module, true), "seed %v", seed)
+		assert.NotEmpty(t, p.Calls, "seed %v", seed)
+	}
+
+	// Generation is deterministic and tolerates short inputs.
+	assert.Equal(t, seedProgram(1, nil).Calls, seedProgram(1, nil).Calls)
+	for _, data := range [][]byte{nil, {0}, {0xff, 0xff, 0xff}} {
+		require.NoError(t, validate.ValidateModule(Generate(data, nil).Module, true))
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	backends := testBackends()
+
+	seeds := int64(64)
+	if len(backends) > 2 {
+		seeds = 4
+	}
+	for seed := int64(0); seed < seeds; seed++ {
+		assert.NoError(t, Compare(seedProgram(seed, nil), backends...), "seed %v", seed)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	p := seedProgram(1, nil)
+
+	broken := Backend{
+		Name: "broken",
+		Run: func(p *Program) (*Result, error) {
+			result, err := ICode.Run(p)
+			if err == nil {
+				result.Memory[len(result.Memory)-1]++
+			}
+			return result, err
+		},
+	}
+
+	var mismatch *Mismatch
+	require.True(t, errors.As(Compare(p, ICode, broken), &mismatch))
+	assert.Equal(t, [2]string{"icode", "broken"}, mismatch.Backends)
+
+	failing := Backend{
+		Name: "failing",
+		Run: func(p *Program) (*Result, error) {
+			return nil, errors.New("failed")
+		},
+	}
+	err := Compare(p, ICode, failing)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &mismatch))
+}
+
+func TestMinimize(t *testing.T) {
+	p := seedProgram(1, nil)
+	function := p.Calls[len(p.Calls)-1].Function
+
+	// Keep any program whose last call targets the same function.
+	minimized := Minimize(p, func(p *Program) bool {
+		return len(p.Calls) != 0 && p.Calls[len(p.Calls)-1].Function == function
+	})
+	require.NoError(t, validate.ValidateModule(minimized.Module, true))
+	assert.Equal(t, []Call{p.Calls[len(p.Calls)-1]}, minimized.Calls)
+	for i, body := range minimized.bodies {
+		assert.Less(t, len(body), len(p.bodies[i])+1)
+		assert.True(t, consumesFuel(body))
+	}
+	assert.NoError(t, Compare(minimized, ICode, FCode))
+}
+
+func TestGoBackend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Go backend test in short mode")
+	}
+
+	p := seedProgram(1, nil)
+	assert.NoError(t, Compare(p, ICode, GoBackend("go", "../..", nil)))
+}
@@ -0,0 +1,91 @@
+package fuzz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pgavlin/warp/compiler/source/golang"
+)
+
+const goMain = `package main
+
+import "github.com/pgavlin/warp/testing/fuzz"
+
+func main() {
+	fuzz.Main(Program)
+}
+`
+
+// GoBackend returns a backend with the given name that compiles programs to Go using the given options and runs them
+// with go run. Each program is compiled in a temporary module that replaces github.com/pgavlin/warp with the module at
+// the given root. The root must contain the module's go.mod and go.sum files.
+//
+// Building the compiled program dominates the cost of running it, so the Go backend is much slower than the
+// interpreter's backends.
+func GoBackend(name, root string, options *golang.Options) Backend {
+	return Backend{
+		Name: name,
+		Run: func(p *Program) (*Result, error) {
+			return runGo(root, options, p)
+		},
+	}
+}
+
+func runGo(root string, options *golang.Options, p *Program) (*Result, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "warp-fuzz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var source bytes.Buffer
+	if err := golang.CompileModule(&source, "main", "program", p.Module, options); err != nil {
+		return nil, fmt.Errorf("compiling program: %w", err)
+	}
+
+	goMod := fmt.Sprintf("module fuzz\n\ngo 1.16\n\nrequire github.com/pgavlin/warp v0.0.0\n\nreplace github.com/pgavlin/warp => %q\n", root)
+	goSum, err := ioutil.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		"go.mod":     []byte(goMod),
+		"go.sum":     goSum,
+		"program.go": source.Bytes(),
+		"main.go":    []byte(goMain),
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	input, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = dir, bytes.NewReader(input), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running program: %w\n%s", err, stderr.String())
+	}
+
+	var result Result
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("decoding result: %w", err)
+	}
+	return &result, nil
+}
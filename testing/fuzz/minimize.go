@@ -0,0 +1,109 @@
+package fuzz
+
+import (
+	"github.com/pgavlin/warp/wasm/code"
+)
+
+// Minimize shrinks a program for which fails returns true. It repeatedly removes calls, replaces function bodies with
+// trivial bodies, removes ranges of instructions, and unwraps blocks and loops, keeping each change that produces a
+// valid program for which fails still returns true. Minimize returns the smallest such program that it finds.
+//
+// Typically, fails returns true if Compare returns an error for the program. Minimize calls fails many times, so
+// minimizing programs with slow backends can take a long time.
+func Minimize(p *Program, fails func(p *Program) bool) *Program {
+	for changed := true; changed; {
+		changed = false
+
+		for i := len(p.Calls) - 1; i >= 0; i-- {
+			calls := append(append([]Call(nil), p.Calls[:i]...), p.Calls[i+1:]...)
+			if c := p.withChanges(calls, p.bodies); c != nil && fails(c) {
+				p, changed = c, true
+			}
+		}
+
+		for i := range p.bodies {
+			if c, ok := p.minimizeBody(i, fails); ok {
+				p, changed = c, true
+			}
+		}
+	}
+	return p
+}
+
+// withBody returns a copy of the program with the given body for the given function, or nil if the result is not
+// valid or does not fail. Bodies that do not consume fuel are rejected, as they may not terminate.
+func (p *Program) withBody(index int, body []code.Instruction, fails func(p *Program) bool) *Program {
+	if !consumesFuel(body) {
+		return nil
+	}
+
+	bodies := append([][]code.Instruction(nil), p.bodies...)
+	bodies[index] = body
+	if c := p.withChanges(p.Calls, bodies); c != nil && fails(c) {
+		return c
+	}
+	return nil
+}
+
+// minimizeBody shrinks the body of the given function.
+func (p *Program) minimizeBody(index int, fails func(p *Program) bool) (*Program, bool) {
+	changed := false
+
+	// Try a body that returns zeros.
+	sig := p.Module.Types.Entries[p.Module.Function.Types[index]]
+	trivial := append([]code.Instruction(nil), consumeFuel...)
+	for _, t := range sig.ReturnTypes {
+		trivial = append(trivial, constant(t, 0))
+	}
+	trivial = append(trivial, code.End())
+	if len(trivial) < len(p.bodies[index]) {
+		if c := p.withBody(index, trivial, fails); c != nil {
+			return c, true
+		}
+	}
+
+	// Remove ranges of instructions, largest first. The final end instruction is always kept.
+	for size := len(p.bodies[index]) / 2; size >= 1; size /= 2 {
+		for start := 0; start+size < len(p.bodies[index]); start++ {
+			body := p.bodies[index]
+			candidate := append(append([]code.Instruction(nil), body[:start]...), body[start+size:]...)
+			if c := p.withBody(index, candidate, fails); c != nil {
+				p, changed = c, true
+			}
+		}
+	}
+
+	// Unwrap blocks and loops.
+	for start := 0; start < len(p.bodies[index]); start++ {
+		body := p.bodies[index]
+		if op := body[start].Opcode; op != code.OpBlock && op != code.OpLoop {
+			continue
+		}
+
+		end, depth := start+1, 0
+		for ; end < len(body); end++ {
+			switch body[end].Opcode {
+			case code.OpBlock, code.OpLoop, code.OpIf:
+				depth++
+				continue
+			case code.OpEnd:
+				if depth > 0 {
+					depth--
+					continue
+				}
+			default:
+				continue
+			}
+			break
+		}
+
+		candidate := append([]code.Instruction(nil), body[:start]...)
+		candidate = append(candidate, body[start+1:end]...)
+		candidate = append(candidate, body[end+1:]...)
+		if c := p.withBody(index, candidate, fails); c != nil {
+			p, changed = c, true
+		}
+	}
+
+	return p, changed
+}
@@ -0,0 +1,55 @@
+package fuzz
+
+import (
+	"bytes"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wasm/code"
+	"github.com/pgavlin/warp/wasm/validate"
+)
+
+// setModule encodes the program's function bodies into the given module and sets the program's module to the result
+// of encoding and decoding the module, which matches the modules that the backends see in practice.
+func (p *Program) setModule(module *wasm.Module) error {
+	for i, body := range p.bodies {
+		var buf bytes.Buffer
+		if err := code.Encode(&buf, body); err != nil {
+			return err
+		}
+		module.Code.Bodies[i].Code = buf.Bytes()
+	}
+
+	module.Sections = []wasm.Section{module.Types, module.Function, module.Table, module.Memory, module.Global, module.Export, module.Elements, module.Code}
+	if module.Data != nil {
+		module.Sections = append(module.Sections, module.Data)
+	}
+
+	var buf bytes.Buffer
+	if err := wasm.EncodeModule(&buf, module); err != nil {
+		return err
+	}
+	decoded, err := wasm.DecodeModule(&buf)
+	if err != nil {
+		return err
+	}
+	p.Module = decoded
+	return nil
+}
+
+// withChanges returns a copy of the program with the given calls and function bodies. The result is nil if the
+// bodies are not valid.
+func (p *Program) withChanges(calls []Call, bodies [][]code.Instruction) *Program {
+	module := *p.Module
+	codeSection := *module.Code
+	codeSection.Bodies = append([]wasm.FunctionBody(nil), codeSection.Bodies...)
+	module.Code = &codeSection
+
+	result := &Program{Fuel: p.Fuel, Calls: calls, Globals: p.Globals, bodies: bodies}
+	if err := result.setModule(&module); err != nil {
+		return nil
+	}
+	if err := validate.ValidateModule(result.Module, true); err != nil {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,154 @@
+package fuzz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pgavlin/warp/exec"
+	"github.com/pgavlin/warp/interpreter"
+)
+
+// An Outcome records the results of a call or the trap that it raised.
+type Outcome struct {
+	// Results are the raw values of the call's results.
+	Results []uint64 `json:"results,omitempty"`
+	// Trap is the message of the trap raised by the call, if any.
+	Trap string `json:"trap,omitempty"`
+}
+
+// A Result records the behavior of a program.
+type Result struct {
+	// Error is the error returned by the module's instantiation, if any.
+	Error string `json:"error,omitempty"`
+	// Calls are the outcomes of the program's calls.
+	Calls []Outcome `json:"calls"`
+	// Globals are the final raw values of the program's globals.
+	Globals []uint64 `json:"globals"`
+	// Memory is the final content of the module's memory.
+	Memory []byte `json:"memory"`
+}
+
+// A Backend executes programs.
+type Backend struct {
+	// Name is the name of the backend.
+	Name string
+	// Run runs the given program. An error indicates that the backend was unable to run the program.
+	Run func(p *Program) (*Result, error)
+}
+
+// interpreterBackend returns a backend that runs programs using the interpreter with the given options.
+func interpreterBackend(name string, options interpreter.Options) Backend {
+	return Backend{
+		Name: name,
+		Run: func(p *Program) (*Result, error) {
+			return Run(interpreter.NewModuleDefinition(p.Module, &options), p), nil
+		},
+	}
+}
+
+// ICode runs programs using the interpreter's icode.
+var ICode = interpreterBackend("icode", interpreter.Options{CodeKind: interpreter.ICodeOnly})
+
+// FCode runs programs using the interpreter's fcode.
+var FCode = interpreterBackend("fcode", interpreter.Options{CodeKind: interpreter.FCodeOnly})
+
+// trapMessage returns the message for a recovered panic.
+func trapMessage(x interface{}) string {
+	switch x := x.(type) {
+	case exec.Trap:
+		return x.Error()
+	case runtime.Error:
+		if trap, ok := exec.TranslateRuntimeError(x); ok {
+			return trap.Error()
+		}
+		return fmt.Sprintf("panic: %v", x)
+	default:
+		return fmt.Sprintf("panic: %v", x)
+	}
+}
+
+// Run instantiates the given definition of a program's module and runs the program. The calls share a single
+// instance of the module.
+func Run(def exec.ModuleDefinition, p *Program) *Result {
+	var result Result
+
+	module, err := instantiate(def)
+	if err != nil {
+		result.Error = err.Error()
+		return &result
+	}
+
+	fuel, err := module.GetGlobal("fuel")
+	if err != nil {
+		result.Error = err.Error()
+		return &result
+	}
+
+	for _, call := range p.Calls {
+		fuel.SetI32(p.Fuel)
+		result.Calls = append(result.Calls, runCall(module, call))
+	}
+
+	for _, name := range p.Globals {
+		g, err := module.GetGlobal(name)
+		if err != nil {
+			result.Error = err.Error()
+			return &result
+		}
+		result.Globals = append(result.Globals, g.Get())
+	}
+
+	mem, err := module.GetMemory("memory")
+	if err != nil {
+		result.Error = err.Error()
+		return &result
+	}
+	result.Memory = append([]byte(nil), mem.Bytes()...)
+
+	return &result
+}
+
+func instantiate(def exec.ModuleDefinition) (module exec.Module, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("%v", trapMessage(x))
+		}
+	}()
+
+	store := exec.NewStore(exec.MapResolver{"program": def})
+	return store.InstantiateModule("program")
+}
+
+func runCall(module exec.Module, call Call) (outcome Outcome) {
+	defer func() {
+		if x := recover(); x != nil {
+			outcome = Outcome{Trap: trapMessage(x)}
+		}
+	}()
+
+	fn, err := module.GetFunction(call.Function)
+	if err != nil {
+		return Outcome{Trap: err.Error()}
+	}
+
+	thread := exec.NewThread(0)
+	returns := make([]uint64, len(fn.GetSignature().ReturnTypes))
+	fn.UncheckedCall(&thread, call.Args, returns)
+	return Outcome{Results: returns}
+}
+
+// Main is the entry point of a program compiled by the Go backend. It reads a JSON-encoded Program from stdin, runs
+// the program using the given module definition, and writes the JSON-encoded Result to stdout.
+func Main(def exec.ModuleDefinition) {
+	var p Program
+	if err := json.NewDecoder(os.Stdin).Decode(&p); err != nil {
+		fmt.Fprintf(os.Stderr, "decoding program: %v\n", err)
+		os.Exit(1)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(Run(def, &p)); err != nil {
+		fmt.Fprintf(os.Stderr, "encoding result: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package testing
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -193,8 +194,15 @@ func (e *Environment) RunCommand(t *testing.T, command wast.Command, strict bool
 			err = validate.ValidateModule(m, true)
 			if err == nil {
 				e.errorf(t, pos, "assert_invalid: module was not invalid")
-			} else if strict && err.Error() != command.Failure {
-				e.errorf(t, pos, "assert_invalid: expected %v, got %v", command.Failure, err.Error())
+			} else if strict {
+				// Compare the failure against the underlying error rather than its location.
+				var verr *validate.Error
+				if errors.As(err, &verr) {
+					err = verr.Err
+				}
+				if err.Error() != command.Failure {
+					e.errorf(t, pos, "assert_invalid: expected %v, got %v", command.Failure, err.Error())
+				}
 			}
 		case wast.ASSERT_UNLINKABLE:
 			def, err := (&decodeAndInstantiate{ModuleCommand: command.Module}).decode(e)
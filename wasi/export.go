@@ -94,15 +94,17 @@ func (r Resolver) ResolveModule(name string) (exec.ModuleDefinition, error) {
 type RunOptions struct {
 	*Options
 
-	Debug    bool
-	Trace    io.Writer
-	Resolver exec.ModuleResolver
+	Debug        bool
+	Trace        io.Writer
+	Coverage     *exec.Coverage
+	Resolver     exec.ModuleResolver
+	Interceptors []exec.CallInterceptor
 }
 
 func Run(name string, def exec.ModuleDefinition, runOptions *RunOptions) error {
-	options, resolver := (*Options)(nil), exec.ModuleResolver(nil)
+	options, resolver, interceptors := (*Options)(nil), exec.ModuleResolver(nil), []exec.CallInterceptor(nil)
 	if runOptions != nil {
-		options = runOptions.Options
+		options, interceptors = runOptions.Options, runOptions.Interceptors
 		if runOptions.Resolver != nil {
 			resolver = runOptions.Resolver
 		}
@@ -113,6 +115,7 @@ func Run(name string, def exec.ModuleDefinition, runOptions *RunOptions) error {
 	options.Args = append([]string{name}, options.Args...)
 
 	store := exec.NewStore(NewResolver(resolver), NewModuleEventHandler(options))
+	store.Intercept(interceptors...)
 
 	mod, err := store.InstantiateModuleDefinition("", def)
 	if err != nil {
@@ -128,7 +131,7 @@ func Run(name string, def exec.ModuleDefinition, runOptions *RunOptions) error {
 		return fmt.Errorf("_start must not accept or return parameters")
 	}
 
-	code := run(start, runOptions.Debug, runOptions.Trace)
+	code := run(start, runOptions.Debug, runOptions.Trace, runOptions.Coverage)
 	if code != 0 {
 		return &ExitError{code: code}
 	}
@@ -199,13 +202,14 @@ func MainErr(def exec.ModuleDefinition) error {
 	return nil
 }
 
-func run(start exec.Function, debug bool, trace io.Writer) (code int) {
+func run(start exec.Function, debug bool, trace io.Writer, coverage *exec.Coverage) (code int) {
 	var thread exec.Thread
 	if debug || trace != nil {
 		thread = exec.NewDebugThread(trace, 0)
 	} else {
 		thread = exec.NewThread(0)
 	}
+	thread.SetCoverage(coverage)
 
 	defer func() {
 		if x := recover(); x != nil {
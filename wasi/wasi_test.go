@@ -33,7 +33,7 @@ func parseModule(path string) (exec.ModuleDefinition, error) {
 		return nil, err
 	}
 
-	return interpreter.NewModuleDefinition(module), nil
+	return interpreter.NewModuleDefinition(module, nil), nil
 }
 
 func loadModule(path string) (exec.ModuleDefinition, error) {
@@ -48,7 +48,7 @@ func loadModule(path string) (exec.ModuleDefinition, error) {
 		return nil, err
 	}
 
-	return interpreter.NewModuleDefinition(module), nil
+	return interpreter.NewModuleDefinition(module, nil), nil
 }
 
 func TestHelloWorld(t *testing.T) {
@@ -12,6 +12,29 @@ import (
 
 var ErrInvalidInstruction = errors.New("wasm: invalid instruction")
 
+// An Error is an error that occurred while decoding a particular instruction in a function body or initializer
+// expression.
+type Error struct {
+	Offset int // The offset of the first byte of the instruction relative to the start of the body.
+	IP     int // The index of the instruction within the body.
+
+	// Expected and Actual describe the operand stack of the innermost block if the instruction failed because the
+	// stack did not hold the operands it requires. Expected holds the types the instruction requires, and Actual holds
+	// the types that were present. Both are nil for other errors.
+	Expected []wasm.ValueType
+	Actual   []wasm.ValueType
+
+	Err error // The underlying error.
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 func decodeBlockType(body []byte) (uint64, []byte, error) {
 	// Block encoding
 	if len(body) == 0 {
@@ -53,6 +76,11 @@ type decoder struct {
 
 	blocks []block
 	stack  []wasm.ValueType
+
+	ip       int
+	offset   int
+	expected []wasm.ValueType
+	actual   []wasm.ValueType
 }
 
 type Body struct {
@@ -62,7 +90,17 @@ type Body struct {
 
 func Decode(body []byte, scope Scope, out []wasm.ValueType) (Body, error) {
 	decoder := decoder{Scope: scope}
-	return decoder.decode(body, out)
+	result, err := decoder.decode(body, out)
+	if err != nil {
+		return Body{}, &Error{
+			Offset:   decoder.offset,
+			IP:       decoder.ip,
+			Expected: decoder.expected,
+			Actual:   decoder.actual,
+			Err:      err,
+		}
+	}
+	return result, nil
 }
 
 func (d *decoder) GetStackType(num int) wasm.ValueType {
@@ -86,19 +124,31 @@ func (d *decoder) popOpd() (wasm.ValueType, error) {
 }
 
 func (d *decoder) popOpds(types ...wasm.ValueType) error {
+	top := len(d.stack)
 	for i := len(types) - 1; i >= 0; i-- {
 		expected := types[i]
 		actual, err := d.popOpd()
 		if err != nil {
+			d.stackError(types, top)
 			return err
 		}
 		if actual != wasm.ValueTypeT && expected != wasm.ValueTypeT && actual != expected {
+			d.stackError(types, top)
 			return wasm.ValidationError("stack type mismatch")
 		}
 	}
 	return nil
 }
 
+// stackError records the expected operand types and the innermost block's operands for an error. top is the height
+// of the operand stack before any operands were popped. Popping operands does not overwrite them, so they are still
+// present in the stack's backing array.
+func (d *decoder) stackError(expected []wasm.ValueType, top int) {
+	b := &d.blocks[len(d.blocks)-1]
+	d.expected = append([]wasm.ValueType{}, expected...)
+	d.actual = append([]wasm.ValueType{}, d.stack[b.stackHeight:top]...)
+}
+
 func (d *decoder) pushOpds(types ...wasm.ValueType) {
 	d.stack = append(d.stack, types...)
 
@@ -127,16 +177,30 @@ func (d *decoder) popBlock() (*block, error) {
 		return nil, wasm.ValidationError("label stack underflow")
 	}
 	b := &d.blocks[len(d.blocks)-1]
+	top := len(d.stack)
 	if err := d.popOpds(b.out...); err != nil {
 		return nil, err
 	}
 	if b.Instruction != nil && len(d.stack) != b.stackHeight {
+		d.stackError(b.out, top)
 		return nil, wasm.ValidationError("unbalanced stack")
 	}
 	d.blocks = d.blocks[:len(d.blocks)-1]
 	return b, nil
 }
 
+func typesEqual(a, b []wasm.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (d *decoder) labelTypes(n int) ([]wasm.ValueType, error) {
 	if len(d.blocks)-1 < n {
 		return nil, wasm.ValidationError("invalid label")
@@ -681,9 +745,12 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 	var instr *Instruction
 	d.pushBlock(instr, nil, out)
 
+	size := len(body)
+
 	var err error
 	for {
 		ip := len(d.ibuf)
+		d.ip, d.offset = ip, size-len(body)
 		if instr, body, err = d.decodeInstruction(body); err != nil {
 			return Body{}, err
 		}
@@ -716,7 +783,9 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 			d.unreachable()
 
 		case OpIf:
-			d.popOpds(wasm.ValueTypeI32)
+			if err := d.popOpds(wasm.ValueTypeI32); err != nil {
+				return Body{}, err
+			}
 			fallthrough
 
 		case OpBlock, OpLoop:
@@ -738,7 +807,7 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 				return Body{}, err
 			}
 
-			if b.Opcode != OpIf || b.Labels[1] != 0 {
+			if b.Instruction == nil || b.Opcode != OpIf || b.Labels[1] != 0 {
 				return Body{}, wasm.ValidationError("invalid nesting")
 			}
 			b.Labels[1] = ip
@@ -746,6 +815,7 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 			d.pushBlock(b.Instruction, b.in, b.out)
 
 		case OpEnd:
+			top := len(d.stack)
 			b, err := d.popBlock()
 			if err != nil {
 				return Body{}, err
@@ -753,6 +823,11 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 
 			switch {
 			case b.Instruction != nil:
+				// An if without an else must produce the values it consumes.
+				if b.Opcode == OpIf && b.Labels[1] == 0 && !typesEqual(b.in, b.out) {
+					d.expected, d.actual = b.out, b.in
+					return Body{}, wasm.ValidationError("type mismatch")
+				}
 				if b.Opcode != OpLoop {
 					b.Labels[0] = ip + 1
 				}
@@ -764,6 +839,8 @@ func (d *decoder) decode(body []byte, out []wasm.ValueType) (Body, error) {
 				return Body{}, wasm.ValidationError("unexpected end instruction")
 			default:
 				if len(d.stack) != 0 {
+					d.expected = out
+					d.actual = append([]wasm.ValueType{}, d.stack[:top]...)
 					return Body{}, wasm.ValidationError("type mismatch")
 				}
 
@@ -0,0 +1,49 @@
+package code
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeInvalidIf(t *testing.T) {
+	sig := wasm.FunctionSig{Form: 0x60}
+
+	cases := []struct {
+		name string
+		body []Instruction
+		err  wasm.ValidationError
+	}{
+		{
+			name: "if without a condition",
+			body: []Instruction{If(BlockTypeEmpty), End(), End()},
+			err:  "stack underflow",
+		},
+		{
+			name: "else outside of an if",
+			body: []Instruction{Else(), End()},
+			err:  "invalid nesting",
+		},
+		{
+			name: "if without an else whose results differ from its parameters",
+			body: []Instruction{I32Const(1), If(BlockTypeI32), I32Const(2), End(), Drop(), End()},
+			err:  "type mismatch",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Encode(&buf, c.body))
+
+			s := NewStaticScope(&wasm.Module{})
+			s.SetFunction(sig, wasm.FunctionBody{})
+
+			_, err := Decode(buf.Bytes(), s, sig.ReturnTypes)
+			assert.True(t, errors.Is(err, c.err), "expected %q, got %v", c.err, err)
+		})
+	}
+}
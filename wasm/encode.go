@@ -6,7 +6,9 @@ package wasm
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"io"
 
 	"github.com/pgavlin/warp/wasm/leb128"
@@ -42,6 +44,19 @@ func EncodeModule(w io.Writer, m *Module) error {
 	return nil
 }
 
+// ContentHash returns the hex-encoded SHA-256 hash of the module's binary encoding. Modules that were not decoded from
+// the binary format have no sections to encode, and therefore cannot be hashed.
+func ContentHash(m *Module) (string, bool) {
+	if len(m.Sections) == 0 {
+		return "", false
+	}
+	h := sha256.New()
+	if err := EncodeModule(h, m); err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
 func writeStringUint(w io.Writer, s string) error {
 	return writeBytesUint(w, []byte(s))
 }
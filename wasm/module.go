@@ -169,6 +169,22 @@ func DecodeModule(r io.Reader) (*Module, error) {
 		R:      r,
 		CurPos: 0,
 	}
+	return decodeModule(reader, nil)
+}
+
+// DecodeModuleBytes decodes a WASM module from its binary encoding without copying function bodies or the contents
+// of custom sections. The Code of each function body and the Data of each custom section are slices of data, so data
+// must not be modified while the module is in use. If data is a memory-mapped file, the pages that hold function bodies
+// and custom sections are not read until the module uses them.
+func DecodeModuleBytes(data []byte) (*Module, error) {
+	reader := &readpos.ReadPos{
+		R:      bytes.NewReader(data),
+		CurPos: 0,
+	}
+	return decodeModule(reader, data)
+}
+
+func decodeModule(reader *readpos.ReadPos, data []byte) (*Module, error) {
 	m := &Module{}
 	magic, err := readU32(reader)
 	if err != nil {
@@ -184,8 +200,9 @@ func DecodeModule(r io.Reader) (*Module, error) {
 		return nil, errors.New("unknown binary version")
 	}
 
-	err = newSectionsReader(m).readSections(reader)
-	if err != nil {
+	sections := newSectionsReader(m)
+	sections.data = data
+	if err = sections.readSections(reader); err != nil {
 		return nil, err
 	}
 
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/pgavlin/warp/wasm"
@@ -45,3 +46,61 @@ func TestReadModule(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeModuleBytes(t *testing.T) {
+	for _, dir := range testPaths {
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fname := range fnames {
+			name := fname
+			t.Run(filepath.Base(name), func(t *testing.T) {
+				raw, err := ioutil.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected, err := wasm.DecodeModule(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("error reading module %v", err)
+				}
+				actual, err := wasm.DecodeModuleBytes(raw)
+				if err != nil {
+					t.Fatalf("error reading module %v", err)
+				}
+				if !reflect.DeepEqual(expected, actual) {
+					t.Fatalf("modules differ")
+				}
+
+				aliases := func(b []byte) bool {
+					if len(b) == 0 {
+						return true
+					}
+					for i := range raw {
+						if &raw[i] == &b[0] {
+							return true
+						}
+					}
+					return false
+				}
+				if actual.Code != nil {
+					for i, body := range actual.Code.Bodies {
+						if !aliases(body.Code) {
+							t.Errorf("function body %v was copied", i)
+						}
+					}
+				}
+				for _, s := range actual.Customs {
+					if !aliases(s.Data) {
+						t.Errorf("custom section %q was copied", s.Name)
+					}
+				}
+
+				if _, err := wasm.DecodeModuleBytes(raw[:len(raw)-1]); err == nil && len(raw) > 8 {
+					t.Errorf("expected an error decoding a truncated module")
+				}
+			})
+		}
+	}
+}
@@ -108,6 +108,11 @@ func (e MissingSectionError) Error() string {
 type sectionsReader struct {
 	lastSecOrder uint8 // previous non-custom sectionid
 	m            *Module
+
+	// data holds the encoded module if it is being decoded by DecodeModuleBytes. If data is non-nil, the reader
+	// passed to readSection must be a *bytes.Reader over data, and function bodies and custom section payloads alias
+	// data rather than being copied out of it.
+	data []byte
 }
 
 func newSectionsReader(m *Module) *sectionsReader {
@@ -158,11 +163,6 @@ func (sr *sectionsReader) readSection(r *readpos.ReadPos) (bool, error) {
 
 	s.Start = r.CurPos
 
-	sectionBytes := new(bytes.Buffer)
-
-	sectionBytes.Grow(int(getInitialCap(payloadDataLen)))
-	sectionReader := io.LimitReader(io.TeeReader(r, sectionBytes), int64(payloadDataLen))
-
 	var sec Section
 	switch s.ID {
 	case SectionIDCustom:
@@ -217,13 +217,20 @@ func (sr *sectionsReader) readSection(r *readpos.ReadPos) (bool, error) {
 	default:
 		return false, InvalidSectionIDError(s.ID)
 	}
-	err = sec.ReadPayload(sectionReader)
+	if sr.data != nil {
+		err = sr.mapPayload(r, &s, sec, payloadDataLen)
+	} else {
+		sectionBytes := new(bytes.Buffer)
+		sectionBytes.Grow(int(getInitialCap(payloadDataLen)))
+		sectionReader := io.LimitReader(io.TeeReader(r, sectionBytes), int64(payloadDataLen))
+
+		err = sec.ReadPayload(sectionReader)
+		s.End, s.Bytes = r.CurPos, sectionBytes.Bytes()
+	}
 	if err != nil {
 		logger.Println(err)
 		return false, err
 	}
-	s.End = r.CurPos
-	s.Bytes = sectionBytes.Bytes()
 	*sec.GetRawSection() = s
 	switch s.ID {
 	case SectionIDCode:
@@ -236,6 +243,39 @@ func (sr *sectionsReader) readSection(r *readpos.ReadPos) (bool, error) {
 	return false, nil
 }
 
+// mapPayload decodes a section's payload from the module's encoded bytes. The payloads of custom and code sections are
+// not copied: custom section data and function bodies are slices of the encoded module. Other sections are small, and
+// are decoded as usual. Once the payload has been decoded, r is positioned at its end.
+func (sr *sectionsReader) mapPayload(r *readpos.ReadPos, s *RawSection, sec Section, payloadLen uint32) error {
+	end := s.Start + int64(payloadLen)
+	if end > int64(len(sr.data)) {
+		return io.ErrUnexpectedEOF
+	}
+	payload := sr.data[s.Start:end:end]
+	reader := bytes.NewReader(payload)
+
+	var err error
+	switch sec := sec.(type) {
+	case *SectionCustom:
+		err = sec.mapPayload(reader, payload)
+	case *SectionCode:
+		err = sec.mapPayload(reader, payload)
+	default:
+		err = sec.ReadPayload(reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.End = s.Start + reader.Size() - int64(reader.Len())
+	s.Bytes = payload[:s.End-s.Start]
+	if _, err := r.R.(*bytes.Reader).Seek(s.End, io.SeekStart); err != nil {
+		return err
+	}
+	r.CurPos = s.End
+	return nil
+}
+
 var _ Section = (*SectionCustom)(nil)
 
 type SectionCustom struct {
@@ -262,6 +302,18 @@ func (s *SectionCustom) ReadPayload(r io.Reader) error {
 	return nil
 }
 
+// mapPayload decodes the section's name from r. The section's data aliases the rest of the payload.
+func (s *SectionCustom) mapPayload(r *bytes.Reader, payload []byte) error {
+	var err error
+	s.Name, err = readUTF8StringUint(r)
+	if err != nil {
+		return err
+	}
+	s.Data = payload[len(payload)-r.Len():]
+	_, err = r.Seek(0, io.SeekEnd)
+	return err
+}
+
 func (s *SectionCustom) WritePayload(w io.Writer) error {
 	if err := writeStringUint(w, s.Name); err != nil {
 		return err
@@ -845,6 +897,42 @@ func (s *SectionCode) ReadPayload(r io.Reader) error {
 	return nil
 }
 
+// mapPayload decodes the section's function bodies from r. The code of each body aliases the payload.
+func (s *SectionCode) mapPayload(r *bytes.Reader, payload []byte) error {
+	count, err := leb128.ReadVarUint32(r)
+	if err != nil {
+		return err
+	}
+	s.Bodies = make([]FunctionBody, 0, getInitialCap(count))
+	logger.Printf("%d function bodies\n", count)
+
+	for i := uint32(0); i < count; i++ {
+		offset := r.Size() - int64(r.Len())
+		logger.Printf("Reading function %d\n", i)
+
+		bodySize, err := leb128.ReadVarUint32(r)
+		if err != nil {
+			return err
+		}
+		if int64(bodySize) > int64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		start := len(payload) - r.Len()
+		end := start + int(bodySize)
+
+		body := FunctionBody{Offset: offset}
+		if err = body.unmarshalBody(payload[start:end:end]); err != nil {
+			return err
+		}
+		s.Bodies = append(s.Bodies, body)
+
+		if _, err = r.Seek(int64(bodySize), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *SectionCode) WritePayload(w io.Writer) error {
 	if _, err := leb128.WriteVarUint32(w, uint32(len(s.Bodies))); err != nil {
 		return err
@@ -876,7 +964,12 @@ func (f *FunctionBody) UnmarshalWASM(r io.Reader) error {
 	if err != nil {
 		return err
 	}
+	return f.unmarshalBody(body)
+}
 
+// unmarshalBody decodes a function body's locals from the given encoded body. The body's code aliases the remainder of
+// the encoded body.
+func (f *FunctionBody) unmarshalBody(body []byte) error {
 	bytesReader := bytes.NewBuffer(body)
 
 	localCount, err := leb128.ReadVarUint32(bytesReader)
@@ -893,7 +986,7 @@ func (f *FunctionBody) UnmarshalWASM(r io.Reader) error {
 		f.Locals = append(f.Locals, local)
 	}
 
-	logger.Printf("bodySize: %d, localCount: %d\n", bodySize, localCount)
+	logger.Printf("bodySize: %d, localCount: %d\n", len(body), localCount)
 
 	f.Code = bytesReader.Bytes()
 	logger.Printf("Read %d bytes for function body", len(f.Code))
@@ -901,6 +994,16 @@ func (f *FunctionBody) UnmarshalWASM(r io.Reader) error {
 	return nil
 }
 
+// CodeOffset returns the offset of the first byte of the function's code relative to the start of the code section.
+func (f *FunctionBody) CodeOffset() int64 {
+	header := len(leb128.AppendUleb128(nil, uint64(len(f.Locals))))
+	for _, l := range f.Locals {
+		header += len(leb128.AppendUleb128(nil, uint64(l.Count))) + 1
+	}
+	size := len(leb128.AppendUleb128(nil, uint64(header+len(f.Code))))
+	return f.Offset + int64(size+header)
+}
+
 func (f *FunctionBody) MarshalWASM(w io.Writer) error {
 	body := new(bytes.Buffer)
 	if _, err := leb128.WriteVarUint32(body, uint32(len(f.Locals))); err != nil {
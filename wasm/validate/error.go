@@ -0,0 +1,83 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
+)
+
+// An Error is a validation error annotated with the location of the invalid definition or instruction.
+type Error struct {
+	Section wasm.SectionID // The section that holds the invalid definition.
+	Index   int            // The index of the invalid entry within its section, or -1 if the error is not specific to an entry.
+
+	Function     int    // The index of the invalid function in the function index space, or -1.
+	FunctionName string // The name of the invalid function, if it is known.
+
+	// Offset is the offset of the invalid instruction relative to the start of the code section, or -1 if the error
+	// did not occur in a function body.
+	Offset int64
+	// Instruction is the index of the invalid instruction within its function body or initializer expression, or -1
+	// if the error did not occur in an instruction.
+	Instruction int
+
+	// Expected and Actual describe the operand stack if the invalid instruction did not have the operands it
+	// requires. Expected holds the types the instruction requires, and Actual holds the types of the operands of
+	// the innermost block.
+	Expected []wasm.ValueType
+	Actual   []wasm.ValueType
+
+	// Pos is the position of the invalid definition or instruction if the module was decoded from the text format
+	// and its source map was supplied to Validate. Otherwise, Pos is the zero value.
+	Pos wast.Pos
+
+	Err error // The underlying error.
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Pos.Line != 0 {
+		fmt.Fprintf(&b, "%v,%v: ", e.Pos.Line, e.Pos.Column)
+	}
+	fmt.Fprintf(&b, "%v section", e.Section)
+	switch {
+	case e.Function >= 0:
+		fmt.Fprintf(&b, ": function %v", e.Function)
+		if e.FunctionName != "" {
+			fmt.Fprintf(&b, " (%v)", e.FunctionName)
+		}
+	case e.Index >= 0:
+		fmt.Fprintf(&b, ": entry %v", e.Index)
+	}
+	switch {
+	case e.Offset >= 0:
+		fmt.Fprintf(&b, ": offset %#x", e.Offset)
+	case e.Instruction >= 0:
+		fmt.Fprintf(&b, ": instruction %v", e.Instruction)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	if e.Expected != nil || e.Actual != nil {
+		fmt.Fprintf(&b, " (expected %v, got %v)", e.Expected, e.Actual)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// An ErrorList is the list of errors returned by Validate when Options.AllErrors is set.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%v (and %d more errors)", l[0], len(l)-1)
+	}
+}
@@ -1,13 +1,31 @@
 package validate
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
+	"github.com/pgavlin/warp/wast"
 )
 
+// Options control the behavior of Validate.
+type Options struct {
+	// ValidateCode enables validation of function bodies.
+	ValidateCode bool
+	// AllErrors causes Validate to report every error it finds as an ErrorList rather than stopping at the first.
+	AllErrors bool
+	// Source is the source map of a module that was decoded from the text format. If Source is non-nil, Validate
+	// records the positions of invalid functions and instructions in its errors.
+	Source *wast.SourceMap
+	// Parallelism is the number of goroutines used to validate function bodies. If Parallelism is less than 2,
+	// function bodies are validated sequentially. The errors Validate returns do not depend on Parallelism.
+	Parallelism int
+}
+
 type validator struct {
-	module       *wasm.Module
-	validateCode bool
+	module  *wasm.Module
+	options Options
 
 	importedFunctions []uint32
 	importedGlobals   []wasm.GlobalVar
@@ -16,12 +34,23 @@ type validator struct {
 	memories int
 
 	locals []wasm.ValueType
+
+	names  map[uint32]string
+	errors ErrorList
 }
 
+// ValidateModule validates the given module and returns the first error it finds, if any. Function bodies are only
+// validated if validateCode is true.
 func ValidateModule(m *wasm.Module, validateCode bool) error {
+	return Validate(m, Options{ValidateCode: validateCode})
+}
+
+// Validate validates the given module. If options.AllErrors is false, Validate returns the first error it finds as an
+// *Error. Otherwise, Validate returns all of the errors it finds as an ErrorList.
+func Validate(m *wasm.Module, options Options) error {
 	v := validator{
-		module:       m,
-		validateCode: validateCode,
+		module:  m,
+		options: options,
 	}
 
 	if v.module.Import != nil {
@@ -45,7 +74,93 @@ func ValidateModule(m *wasm.Module, validateCode bool) error {
 		v.memories += len(v.module.Memory.Entries)
 	}
 
-	return v.validateModule()
+	if err := v.validateModule(); err != nil {
+		return err
+	}
+	if len(v.errors) != 0 {
+		return v.errors
+	}
+	return nil
+}
+
+// fail records an error in the given section entry. fail returns a non-nil error if validation should stop.
+func (v *validator) fail(section wasm.SectionID, index int, err error) error {
+	return v.report(&Error{
+		Section:     section,
+		Index:       index,
+		Function:    -1,
+		Offset:      -1,
+		Instruction: -1,
+		Err:         err,
+	})
+}
+
+// failExpr records an error in an initializer expression of the given section entry. fail returns a non-nil error
+// if validation should stop.
+func (v *validator) failExpr(section wasm.SectionID, index int, ip int, err error) error {
+	e := &Error{
+		Section:     section,
+		Index:       index,
+		Function:    -1,
+		Offset:      -1,
+		Instruction: ip,
+		Err:         err,
+	}
+	if err, ok := err.(*code.Error); ok {
+		e.Instruction, e.Expected, e.Actual, e.Err = err.IP, err.Expected, err.Actual, err.Err
+	}
+	return v.report(e)
+}
+
+// failFunction records an error in the function with the given code section index. fail returns a non-nil error if
+// validation should stop.
+func (v *validator) failFunction(section wasm.SectionID, index int, err error) error {
+	e := &Error{
+		Section:      section,
+		Index:        index,
+		Function:     len(v.importedFunctions) + index,
+		FunctionName: v.functionName(index),
+		Offset:       -1,
+		Instruction:  -1,
+		Err:          err,
+	}
+	if err, ok := err.(*code.Error); ok {
+		e.Offset = v.module.Code.Bodies[index].CodeOffset() + int64(err.Offset)
+		e.Instruction, e.Expected, e.Actual, e.Err = err.IP, err.Expected, err.Actual, err.Err
+	}
+	if v.options.Source != nil {
+		e.Pos, _ = v.options.Source.InstructionPos(index, e.Instruction)
+	}
+	return v.report(e)
+}
+
+func (v *validator) report(e *Error) error {
+	if !v.options.AllErrors {
+		return e
+	}
+	v.errors = append(v.errors, e)
+	return nil
+}
+
+// functionName returns the name of the function with the given code section index, if any.
+func (v *validator) functionName(index int) string {
+	if v.options.Source != nil && index < len(v.options.Source.Functions) {
+		return v.options.Source.Functions[index].Name
+	}
+
+	if v.names == nil {
+		v.names = map[uint32]string{}
+		if names, err := v.module.Names(); err == nil {
+			for _, e := range names.Entries {
+				if e, ok := e.(*wasm.FunctionNamesSubsection); ok {
+					for _, n := range e.Names {
+						v.names[n.Index] = n.Name
+					}
+				}
+			}
+		}
+	}
+	return v.names[uint32(len(v.importedFunctions)+index)]
 }
 
 func (v *validator) validateModule() error {
@@ -99,31 +214,88 @@ func (v *validator) validateFunctions() error {
 	}
 
 	if len(types) != len(bodies) {
-		return wasm.ValidationError("function and code section have inconsistent lengths")
+		return v.fail(wasm.SectionIDFunction, -1, wasm.ValidationError("function and code section have inconsistent lengths"))
+	}
+
+	var codeErrors []error
+	if v.options.ValidateCode && v.options.Parallelism > 1 {
+		codeErrors = v.validateCodeParallel(types, bodies)
 	}
 
 	for i, typeidx := range types {
 		sig, ok := v.GetType(typeidx)
 		if !ok {
-			return wasm.ValidationError("unknown type")
+			if err := v.failFunction(wasm.SectionIDFunction, i, wasm.ValidationError("unknown type")); err != nil {
+				return err
+			}
+			continue
 		}
 
-		if !v.validateCode {
+		if !v.options.ValidateCode {
 			continue
 		}
 
-		body := bodies[i]
+		var err error
+		if codeErrors != nil {
+			err = codeErrors[i]
+		} else {
+			body := bodies[i]
 
-		v.SetFunction(sig, body)
-		_, err := code.Decode(body.Code, v, sig.ReturnTypes)
+			v.SetFunction(sig, body)
+			_, err = code.Decode(body.Code, v, sig.ReturnTypes)
+		}
 		if err != nil {
-			return err
+			if err := v.failFunction(wasm.SectionIDCode, i, err); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// validateCodeParallel validates function bodies using options.Parallelism goroutines and returns the error for each
+// body, if any. Bodies with unknown types are skipped. Bodies are claimed in index order, so if options.AllErrors is
+// false, the workers stop claiming bodies after the first error without skipping any body that precedes it.
+func (v *validator) validateCodeParallel(types []uint32, bodies []wasm.FunctionBody) []error {
+	errs := make([]error, len(bodies))
+
+	var next int64
+	var failed int32
+	var wg sync.WaitGroup
+	for n := 0; n < v.options.Parallelism; n++ {
+		// Each worker needs its own scope, as the scope holds the locals of the function being validated.
+		w := *v
+		w.locals = nil
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for v.options.AllErrors || atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(bodies) {
+					return
+				}
+
+				sig, ok := w.GetType(types[i])
+				if !ok {
+					continue
+				}
+
+				w.SetFunction(sig, bodies[i])
+				if _, err := code.Decode(bodies[i].Code, &w, sig.ReturnTypes); err != nil {
+					errs[i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
 func (v *validator) validateLimits(limits wasm.ResizableLimits) error {
 	if limits.Flags != 0 && limits.Initial > limits.Maximum {
 		return wasm.ValidationError("size minimum must not be greater than maximum")
@@ -136,9 +308,14 @@ func (v *validator) validateTables() error {
 		return nil
 	}
 	if v.tables > 1 {
-		return wasm.ValidationError("multiple tables")
+		if err := v.fail(wasm.SectionIDTable, 0, wasm.ValidationError("multiple tables")); err != nil {
+			return err
+		}
+	}
+	if err := v.validateLimits(v.module.Table.Entries[0].Limits); err != nil {
+		return v.fail(wasm.SectionIDTable, 0, err)
 	}
-	return v.validateLimits(v.module.Table.Entries[0].Limits)
+	return nil
 }
 
 func (v *validator) validateMemories() error {
@@ -146,15 +323,17 @@ func (v *validator) validateMemories() error {
 		return nil
 	}
 	if v.memories > 1 {
-		return wasm.ValidationError("multiple memories")
+		if err := v.fail(wasm.SectionIDMemory, 0, wasm.ValidationError("multiple memories")); err != nil {
+			return err
+		}
 	}
 
 	limits := v.module.Memory.Entries[0].Limits
 	if err := v.validateLimits(limits); err != nil {
-		return err
+		return v.fail(wasm.SectionIDMemory, 0, err)
 	}
 	if limits.Initial > 65536 || limits.Flags != 0 && limits.Maximum > 65536 {
-		return wasm.ValidationError("memory size must be at most 65536 pages (4GiB)")
+		return v.fail(wasm.SectionIDMemory, 0, wasm.ValidationError("memory size must be at most 65536 pages (4GiB)"))
 	}
 	return nil
 }
@@ -165,9 +344,11 @@ func (v *validator) validateGlobals() error {
 	}
 
 	scope := v.globalScope()
-	for _, g := range v.module.Global.Globals {
-		if err := v.validateInitExpr(g.Init, g.Type.Type, scope); err != nil {
-			return err
+	for i, g := range v.module.Global.Globals {
+		if ip, err := v.validateInitExpr(g.Init, g.Type.Type, scope); err != nil {
+			if err := v.failExpr(wasm.SectionIDGlobal, i, ip, err); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -178,16 +359,23 @@ func (v *validator) validateElements() error {
 	if v.module.Elements == nil {
 		return nil
 	}
-	for _, elem := range v.module.Elements.Entries {
+	for i, elem := range v.module.Elements.Entries {
 		if elem.Index >= uint32(v.tables) {
-			return wasm.ValidationError("unknown table")
+			if err := v.fail(wasm.SectionIDElement, i, wasm.ValidationError("unknown table")); err != nil {
+				return err
+			}
 		}
-		if err := v.validateInitExpr(elem.Offset, wasm.ValueTypeI32, v); err != nil {
-			return err
+		if ip, err := v.validateInitExpr(elem.Offset, wasm.ValueTypeI32, v); err != nil {
+			if err := v.failExpr(wasm.SectionIDElement, i, ip, err); err != nil {
+				return err
+			}
 		}
 		for _, funcidx := range elem.Elems {
 			if _, ok := v.GetFunctionSignature(funcidx); !ok {
-				return wasm.ValidationError("unknown function")
+				if err := v.fail(wasm.SectionIDElement, i, wasm.ValidationError("unknown function")); err != nil {
+					return err
+				}
+				break
 			}
 		}
 	}
@@ -198,12 +386,16 @@ func (v *validator) validateData() error {
 	if v.module.Data == nil {
 		return nil
 	}
-	for _, data := range v.module.Data.Entries {
+	for i, data := range v.module.Data.Entries {
 		if data.Index >= uint32(v.memories) {
-			return wasm.ValidationError("unknown memory")
+			if err := v.fail(wasm.SectionIDData, i, wasm.ValidationError("unknown memory")); err != nil {
+				return err
+			}
 		}
-		if err := v.validateInitExpr(data.Offset, wasm.ValueTypeI32, v); err != nil {
-			return err
+		if ip, err := v.validateInitExpr(data.Offset, wasm.ValueTypeI32, v); err != nil {
+			if err := v.failExpr(wasm.SectionIDData, i, ip, err); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -215,10 +407,10 @@ func (v *validator) validateStart() error {
 	}
 	sig, ok := v.GetFunctionSignature(v.module.Start.Index)
 	if !ok {
-		return wasm.ValidationError("unknown function")
+		return v.fail(wasm.SectionIDStart, 0, wasm.ValidationError("unknown function"))
 	}
 	if len(sig.ParamTypes) != 0 || len(sig.ReturnTypes) != 0 {
-		return wasm.ValidationError("start function")
+		return v.fail(wasm.SectionIDStart, 0, wasm.ValidationError("start function"))
 	}
 	return nil
 }
@@ -227,23 +419,25 @@ func (v *validator) validateImports() error {
 	if v.module.Import == nil {
 		return nil
 	}
-	for _, i := range v.module.Import.Entries {
-		switch i := i.Type.(type) {
+	for i, entry := range v.module.Import.Entries {
+		var err error
+		switch entry := entry.Type.(type) {
 		case wasm.FuncImport:
-			if _, ok := v.GetFunctionSignature(i.Type); !ok {
-				return wasm.ValidationError("unknown type")
+			if _, ok := v.GetFunctionSignature(entry.Type); !ok {
+				err = wasm.ValidationError("unknown type")
 			}
 		case wasm.TableImport:
-			if err := v.validateLimits(i.Type.Limits); err != nil {
-				return err
-			}
+			err = v.validateLimits(entry.Type.Limits)
 		case wasm.MemoryImport:
-			if err := v.validateLimits(i.Type.Limits); err != nil {
-				return err
-			}
+			err = v.validateLimits(entry.Type.Limits)
 		case wasm.GlobalVarImport:
 			// OK
 		}
+		if err != nil {
+			if err := v.fail(wasm.SectionIDImport, i, err); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -254,50 +448,58 @@ func (v *validator) validateExports() error {
 	}
 
 	names := map[string]bool{}
-	for _, e := range v.module.Export.Entries {
+	for i, e := range v.module.Export.Entries {
+		var err error
 		if names[e.FieldStr] {
-			return wasm.ValidationError("duplicate export name")
+			err = wasm.ValidationError("duplicate export name")
 		}
 		names[e.FieldStr] = true
 
 		switch e.Kind {
 		case wasm.ExternalFunction:
-			if _, ok := v.GetFunctionSignature(e.Index); !ok {
-				return wasm.ValidationError("unknown function")
+			if _, ok := v.GetFunctionSignature(e.Index); !ok && err == nil {
+				err = wasm.ValidationError("unknown function")
 			}
 		case wasm.ExternalTable:
-			if e.Index >= uint32(v.tables) {
-				return wasm.ValidationError("unknown table")
+			if e.Index >= uint32(v.tables) && err == nil {
+				err = wasm.ValidationError("unknown table")
 			}
 		case wasm.ExternalMemory:
-			if e.Index >= uint32(v.memories) {
-				return wasm.ValidationError("unknown memory")
+			if e.Index >= uint32(v.memories) && err == nil {
+				err = wasm.ValidationError("unknown memory")
 			}
 		case wasm.ExternalGlobal:
-			if _, ok := v.GetGlobalType(e.Index); !ok {
-				return wasm.ValidationError("unknown global")
+			if _, ok := v.GetGlobalType(e.Index); !ok && err == nil {
+				err = wasm.ValidationError("unknown global")
+			}
+		}
+		if err != nil {
+			if err := v.fail(wasm.SectionIDExport, i, err); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func (v *validator) validateInitExpr(expr []byte, expected wasm.ValueType, scope code.Scope) error {
+// validateInitExpr validates an initializer expression. If the expression is invalid, validateInitExpr returns the
+// index of the invalid instruction along with the error.
+func (v *validator) validateInitExpr(expr []byte, expected wasm.ValueType, scope code.Scope) (int, error) {
 	decoded, err := code.Decode(expr, scope, []wasm.ValueType{expected})
 	if err != nil {
-		return err
+		return -1, err
 	}
-	for _, instr := range decoded.Instructions {
+	for ip, instr := range decoded.Instructions {
 		switch instr.Opcode {
 		case code.OpI32Const, code.OpI64Const, code.OpF32Const, code.OpF64Const, code.OpEnd:
 			// OK
 		case code.OpGlobalGet:
 			if v.importedGlobals[int(instr.Globalidx())].Mutable {
-				return wasm.ValidationError("constant expression required")
+				return ip, wasm.ValidationError("constant expression required")
 			}
 		default:
-			return wasm.ValidationError("constant expression required")
+			return ip, wasm.ValidationError("constant expression required")
 		}
 	}
-	return nil
+	return -1, nil
 }
@@ -0,0 +1,115 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pgavlin/warp/wasm"
+	"github.com/pgavlin/warp/wast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const invalidModule = `(module
+  (func $add (param i32 i64) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (func $ok (result i32)
+    i32.const 1)
+  (func $drop
+    drop)
+  (global i32 (i64.const 0))
+  (export "a" (func $add))
+  (export "a" (func $ok)))
+`
+
+func decodeSource(t *testing.T, text string) (*wasm.Module, *wast.SourceMap) {
+	syntax, err := wast.ParseModule(wast.NewScanner(strings.NewReader(text)))
+	require.NoError(t, err)
+
+	m, source, err := syntax.DecodeSource()
+	require.NoError(t, err)
+	return m, source
+}
+
+func TestValidateFirstError(t *testing.T) {
+	m, _ := decodeSource(t, invalidModule)
+
+	err := ValidateModule(m, true)
+	require.Error(t, err)
+
+	var verr *Error
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, wasm.SectionIDCode, verr.Section)
+	assert.Equal(t, 0, verr.Function)
+	assert.Equal(t, 2, verr.Instruction)
+	assert.Equal(t, int64(7), verr.Offset)
+	assert.Equal(t, []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}, verr.Expected)
+	assert.Equal(t, []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64}, verr.Actual)
+	assert.Equal(t, wast.Pos{}, verr.Pos)
+	assert.True(t, errors.Is(err, wasm.ValidationError("stack type mismatch")))
+	assert.Equal(t, "code section: function 0: offset 0x7: stack type mismatch (expected [i32 i32], got [i32 i64])", err.Error())
+}
+
+func TestValidateAllErrors(t *testing.T) {
+	m, source := decodeSource(t, invalidModule)
+
+	err := Validate(m, Options{ValidateCode: true, AllErrors: true, Source: source})
+	require.Error(t, err)
+
+	var list ErrorList
+	require.True(t, errors.As(err, &list))
+	require.Len(t, list, 4)
+
+	assert.Equal(t, wast.Pos{Line: 3, Column: 6}, list[0].Pos)
+	assert.Equal(t, "$add", list[0].FunctionName)
+
+	assert.Equal(t, wasm.SectionIDCode, list[1].Section)
+	assert.Equal(t, 2, list[1].Function)
+	assert.Equal(t, "$drop", list[1].FunctionName)
+	assert.Equal(t, int64(0x10), list[1].Offset)
+	assert.Equal(t, wast.Pos{Line: 7, Column: 5}, list[1].Pos)
+	assert.Equal(t, wasm.ValidationError("stack underflow"), list[1].Err)
+
+	assert.Equal(t, wasm.SectionIDGlobal, list[2].Section)
+	assert.Equal(t, 0, list[2].Index)
+	assert.Equal(t, -1, list[2].Function)
+	assert.Equal(t, int64(-1), list[2].Offset)
+
+	assert.Equal(t, wasm.SectionIDExport, list[3].Section)
+	assert.Equal(t, 1, list[3].Index)
+	assert.Equal(t, wasm.ValidationError("duplicate export name"), list[3].Err)
+
+	assert.True(t, strings.HasPrefix(err.Error(), "3,6: code section: function 0 ($add): "))
+	assert.True(t, strings.HasSuffix(err.Error(), " (and 3 more errors)"))
+}
+
+func TestValidateValidModule(t *testing.T) {
+	m, source := decodeSource(t, `(module (func (result i32) (i32.add (i32.const 1) (i32.const 2))))`)
+	assert.NoError(t, Validate(m, Options{ValidateCode: true, AllErrors: true, Source: source}))
+}
+
+func TestValidateParallel(t *testing.T) {
+	var text strings.Builder
+	text.WriteString("(module\n")
+	for i := 0; i < 64; i++ {
+		if i%5 == 3 {
+			text.WriteString("  (func (param i32) (result i64) (local.get 0))\n")
+		} else {
+			text.WriteString("  (func (param i32) (result i32) (i32.add (local.get 0) (i32.const 1)))\n")
+		}
+	}
+	text.WriteString(")\n")
+
+	m, source := decodeSource(t, text.String())
+
+	for _, all := range []bool{false, true} {
+		expected := Validate(m, Options{ValidateCode: true, AllErrors: all, Source: source})
+		require.Error(t, expected)
+
+		for _, parallelism := range []int{2, 4, 16} {
+			actual := Validate(m, Options{ValidateCode: true, AllErrors: all, Source: source, Parallelism: parallelism})
+			assert.Equal(t, expected, actual)
+		}
+	}
+}
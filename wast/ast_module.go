@@ -33,6 +33,8 @@ type Typedef struct {
 }
 
 type Func struct {
+	Pos Pos
+
 	Name    string
 	Exports []string
 	Import  *InlineImport
@@ -162,6 +164,8 @@ type Instr interface {
 }
 
 type Block struct {
+	Pos Pos
+
 	Name   string
 	Type   *FuncType
 	Instrs []Instr
@@ -170,6 +174,8 @@ type Block struct {
 func (*Block) isInstr() {}
 
 type Loop struct {
+	Pos Pos
+
 	Name   string
 	Type   *FuncType
 	Instrs []Instr
@@ -178,6 +184,8 @@ type Loop struct {
 func (*Loop) isInstr() {}
 
 type If struct {
+	Pos Pos
+
 	Name      string
 	Type      *FuncType
 	Condition []Instr
@@ -188,12 +196,16 @@ type If struct {
 func (*If) isInstr() {}
 
 type Op struct {
+	Pos Pos
+
 	Code TokenKind
 }
 
 func (*Op) isInstr() {}
 
 type VarOp struct {
+	Pos Pos
+
 	Code TokenKind
 	Vars []Var
 }
@@ -201,12 +213,16 @@ type VarOp struct {
 func (*VarOp) isInstr() {}
 
 type CallIndirect struct {
+	Pos Pos
+
 	Type FuncType
 }
 
 func (*CallIndirect) isInstr() {}
 
 type MemOp struct {
+	Pos Pos
+
 	Code   TokenKind
 	Offset *int64
 	Align  *int64
@@ -215,6 +231,8 @@ type MemOp struct {
 func (*MemOp) isInstr() {}
 
 type ConstOp struct {
+	Pos Pos
+
 	Code  TokenKind
 	Value interface{}
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pgavlin/warp/wasm"
 	"github.com/pgavlin/warp/wasm/code"
+	"github.com/pgavlin/warp/wasm/leb128"
 )
 
 func (m *Module) Decode() (*wasm.Module, error) {
@@ -16,6 +17,43 @@ func (m *Module) Decode() (*wasm.Module, error) {
 	return decoder.decodeModule()
 }
 
+// DecodeSource decodes the module and returns a source map that records the positions of the module's functions and
+// their instructions.
+func (m *Module) DecodeSource() (*wasm.Module, *SourceMap, error) {
+	decoder := moduleDecoder{m: m, source: &SourceMap{}}
+	module, err := decoder.decodeModule()
+	if err != nil {
+		return nil, nil, err
+	}
+	return module, decoder.source, nil
+}
+
+// A SourceMap records the source positions of the functions in a decoded module.
+type SourceMap struct {
+	Functions []FunctionSource // The module's defined functions, in code section order.
+}
+
+// A FunctionSource records the name and position of a function and the positions of its instructions.
+type FunctionSource struct {
+	Name         string // The function's name, if any.
+	Pos          Pos    // The position of the function's definition.
+	Instructions []Pos  // The positions of the function's instructions, indexed by instruction.
+}
+
+// InstructionPos returns the position of an instruction in the function with the given code section index. If the
+// instruction's position is not known, InstructionPos returns the position of the function. Instructions that are
+// implicit in the text format, such as the final end of a function, do not have positions of their own.
+func (s *SourceMap) InstructionPos(body, ip int) (Pos, bool) {
+	if body < 0 || body >= len(s.Functions) {
+		return Pos{}, false
+	}
+	fn := &s.Functions[body]
+	if ip < 0 || ip >= len(fn.Instructions) {
+		return fn.Pos, true
+	}
+	return fn.Instructions[ip], true
+}
+
 type indexes struct {
 	functionTypes map[string]int
 
@@ -303,6 +341,9 @@ func (c *context) useLabel(v Var) int {
 type moduleDecoder struct {
 	m *Module
 
+	source    *SourceMap
+	positions []Pos
+
 	context *context
 	depth   int
 
@@ -604,6 +645,9 @@ func (b *moduleDecoder) decodeFuncs() (*wasm.SectionFunctions, *wasm.SectionCode
 	code := wasm.SectionCode{
 		Bodies: make([]wasm.FunctionBody, 0, b.functionBodies),
 	}
+
+	// Record the offset each body would have in the binary encoding of the code section.
+	offset := int64(len(leb128.AppendUleb128(nil, uint64(b.functionBodies))))
 	for _, f := range b.m.Funcs {
 		if f.Import == nil {
 			functions.Types = append(functions.Types, uint32(b.context.functionType(f.Type)))
@@ -612,7 +656,17 @@ func (b *moduleDecoder) decodeFuncs() (*wasm.SectionFunctions, *wasm.SectionCode
 			if err != nil {
 				return nil, nil, err
 			}
+			body.Offset = offset
+			offset = body.CodeOffset() + int64(len(body.Code))
 			code.Bodies = append(code.Bodies, body)
+
+			if b.source != nil {
+				b.source.Functions = append(b.source.Functions, FunctionSource{
+					Name:         f.Name,
+					Pos:          f.Pos,
+					Instructions: b.positions,
+				})
+			}
 		}
 	}
 	return &functions, &code, nil
@@ -980,6 +1034,7 @@ func (b *moduleDecoder) decodeFunctionBody(f *Func) (wasm.FunctionBody, error) {
 }
 
 func (b *moduleDecoder) decodeBytecode(instrs []Instr, or []byte) ([]byte, error) {
+	b.positions = nil
 	if len(instrs) == 0 {
 		return or, nil
 	}
@@ -1016,20 +1071,24 @@ func (b *moduleDecoder) linearize(dest *[]code.Instruction, instr Instr) error {
 		defer b.popBlock()
 
 		*dest = append(*dest, code.Block(b.decodeBlockType(instr.Type)))
+		b.mark(*dest, instr.Pos)
 		if err := b.linearizeInstrs(dest, instr.Instrs); err != nil {
 			return err
 		}
 		*dest = append(*dest, code.End())
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *Loop:
 		b.pushBlock(instr.Name, instr.Type)
 		defer b.popBlock()
 
 		*dest = append(*dest, code.Loop(b.decodeBlockType(instr.Type)))
+		b.mark(*dest, instr.Pos)
 		if err := b.linearizeInstrs(dest, instr.Instrs); err != nil {
 			return err
 		}
 		*dest = append(*dest, code.End())
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *If:
 		b.pushBlock(instr.Name, instr.Type)
@@ -1039,37 +1098,55 @@ func (b *moduleDecoder) linearize(dest *[]code.Instruction, instr Instr) error {
 			return err
 		}
 		*dest = append(*dest, code.If(b.decodeBlockType(instr.Type)))
+		b.mark(*dest, instr.Pos)
 		if err := b.linearizeInstrs(dest, instr.Then); err != nil {
 			return err
 		}
 		if len(instr.Else) != 0 {
 			*dest = append(*dest, code.Else())
+			b.mark(*dest, instr.Pos)
 			if err := b.linearizeInstrs(dest, instr.Else); err != nil {
 				return err
 			}
 		}
 		*dest = append(*dest, code.End())
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *Op:
 		*dest = append(*dest, b.decodeOp(instr))
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *VarOp:
 		*dest = append(*dest, b.decodeVarOp(instr))
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *CallIndirect:
 		*dest = append(*dest, code.CallIndirect(uint32(b.context.functionType(&instr.Type))))
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *MemOp:
 		*dest = append(*dest, b.decodeMemOp(instr))
+		b.mark(*dest, instr.Pos)
 		return nil
 	case *ConstOp:
 		*dest = append(*dest, b.decodeConstOp(instr))
+		b.mark(*dest, instr.Pos)
 		return nil
 	default:
 		panic("unreachable")
 	}
 }
 
+// mark records the given position for the instructions that have been appended to dest since the last call to mark.
+func (b *moduleDecoder) mark(dest []code.Instruction, pos Pos) {
+	if b.source == nil {
+		return
+	}
+	for len(b.positions) < len(dest) {
+		b.positions = append(b.positions, pos)
+	}
+}
+
 func (b *moduleDecoder) decodeBlockType(t *FuncType) uint64 {
 	switch {
 	case t == nil:
@@ -93,6 +93,7 @@ func (p *parser) parseTypedef() *Typedef {
 }
 
 func (p *parser) parseFunc() *Func {
+	pos := p.tok.Start
 	p.expectSExpr(FUNC)
 	defer p.closeSExpr()
 
@@ -108,6 +109,7 @@ func (p *parser) parseFunc() *Func {
 	}
 
 	return &Func{
+		Pos:     pos,
 		Name:    name,
 		Exports: exports,
 		Import:  import_,
@@ -324,6 +326,7 @@ func (p *parser) parseStart() *Var {
 }
 
 func (p *parser) parseBlock() *Block {
+	pos := p.tok.Start
 	p.expect(BLOCK)
 
 	name, _ := p.maybe(VAR).(string)
@@ -334,6 +337,7 @@ func (p *parser) parseBlock() *Block {
 	p.maybe(VAR)
 
 	return &Block{
+		Pos:    pos,
 		Name:   name,
 		Type:   typ,
 		Instrs: instrs,
@@ -341,11 +345,13 @@ func (p *parser) parseBlock() *Block {
 }
 
 func (p *parser) parseBlockExpr() *Block {
+	pos := p.tok.Start
 	p.expectSExpr(BLOCK)
 	defer p.closeSExpr()
 
 	name, _ := p.maybe(VAR).(string)
 	return &Block{
+		Pos:    pos,
 		Name:   name,
 		Type:   p.parseFuncType(),
 		Instrs: p.parseInstrs(')'),
@@ -455,6 +461,7 @@ func (p *parser) parseGlobalType() GlobalType {
 }
 
 func (p *parser) parseIf() *If {
+	pos := p.tok.Start
 	p.expect(IF)
 
 	name, _ := p.maybe(VAR).(string)
@@ -474,6 +481,7 @@ func (p *parser) parseIf() *If {
 	p.maybe(VAR)
 
 	return &If{
+		Pos:  pos,
 		Name: name,
 		Type: typ,
 		Then: then,
@@ -482,6 +490,7 @@ func (p *parser) parseIf() *If {
 }
 
 func (p *parser) parseIfExpr() *If {
+	pos := p.tok.Start
 	p.expectSExpr(IF)
 	defer p.closeSExpr()
 
@@ -505,6 +514,7 @@ func (p *parser) parseIfExpr() *If {
 	}
 
 	return &If{
+		Pos:       pos,
 		Name:      name,
 		Type:      typ,
 		Condition: condition,
@@ -563,6 +573,7 @@ func (p *parser) parseLocals() []*Local {
 }
 
 func (p *parser) parseLoop() *Loop {
+	pos := p.tok.Start
 	p.expect(LOOP)
 
 	name, _ := p.maybe(VAR).(string)
@@ -574,6 +585,7 @@ func (p *parser) parseLoop() *Loop {
 	p.maybe(VAR)
 
 	return &Loop{
+		Pos:    pos,
 		Name:   name,
 		Type:   typ,
 		Instrs: instrs,
@@ -581,11 +593,13 @@ func (p *parser) parseLoop() *Loop {
 }
 
 func (p *parser) parseLoopExpr() *Loop {
+	pos := p.tok.Start
 	p.expectSExpr(LOOP)
 	defer p.closeSExpr()
 
 	name, _ := p.maybe(VAR).(string)
 	return &Loop{
+		Pos:    pos,
 		Name:   name,
 		Type:   p.parseFuncType(),
 		Instrs: p.parseInstrs(')'),
@@ -671,6 +685,7 @@ func (p *parser) parseVar() *Var {
 }
 
 func (p *parser) parseOp() Instr {
+	pos := p.tok.Start
 	switch p.tok.Kind {
 	case BR_TABLE:
 		code := p.tok.Kind
@@ -680,19 +695,19 @@ func (p *parser) parseOp() Instr {
 		for p.tok.Kind == VAR || p.tok.Kind == NAT || p.tok.Kind == INT {
 			vars = append(vars, *p.parseVar())
 		}
-		return &VarOp{Code: code, Vars: vars}
+		return &VarOp{Pos: pos, Code: code, Vars: vars}
 
 	case CALL_INDIRECT:
 		p.scan()
 
 		typ := p.parseFuncType()
-		return &CallIndirect{Type: *typ}
+		return &CallIndirect{Pos: pos, Type: *typ}
 
 	case BR, BR_IF, CALL, LOCAL_GET, LOCAL_SET, LOCAL_TEE, GLOBAL_GET, GLOBAL_SET:
 		code := p.tok.Kind
 		p.scan()
 
-		return &VarOp{Code: code, Vars: []Var{*p.parseVar()}}
+		return &VarOp{Pos: pos, Code: code, Vars: []Var{*p.parseVar()}}
 
 	case F32_LOAD, F64_LOAD, I32_LOAD, I64_LOAD, I32_LOAD16_S, I32_LOAD16_U, I32_LOAD8_S, I32_LOAD8_U, I64_LOAD16_S, I64_LOAD16_U, I64_LOAD32_S, I64_LOAD32_U, I64_LOAD8_S, I64_LOAD8_U, F32_STORE, F64_STORE, I32_STORE, I64_STORE, I32_STORE16, I32_STORE8, I64_STORE16, I64_STORE32, I64_STORE8:
 		code := p.tok.Kind
@@ -710,7 +725,7 @@ func (p *parser) parseOp() Instr {
 			align = &a
 		}
 
-		return &MemOp{Code: code, Offset: offset, Align: align}
+		return &MemOp{Pos: pos, Code: code, Offset: offset, Align: align}
 
 	case F32_CONST:
 		p.scan()
@@ -720,7 +735,7 @@ func (p *parser) parseOp() Instr {
 			panic(p.errorf("expected INT or FLOAT"))
 		}
 		p.scan()
-		return &ConstOp{Code: F32_CONST, Value: v}
+		return &ConstOp{Pos: pos, Code: F32_CONST, Value: v}
 
 	case F64_CONST:
 		p.scan()
@@ -730,7 +745,7 @@ func (p *parser) parseOp() Instr {
 			panic(p.errorf("expected INT or FLOAT"))
 		}
 		p.scan()
-		return &ConstOp{Code: F64_CONST, Value: v}
+		return &ConstOp{Pos: pos, Code: F64_CONST, Value: v}
 
 	case I32_CONST:
 		p.scan()
@@ -740,7 +755,7 @@ func (p *parser) parseOp() Instr {
 			panic(p.errorf("expected INT"))
 		}
 		p.scan()
-		return &ConstOp{Code: I32_CONST, Value: v}
+		return &ConstOp{Pos: pos, Code: I32_CONST, Value: v}
 
 	case I64_CONST:
 		p.scan()
@@ -750,7 +765,7 @@ func (p *parser) parseOp() Instr {
 			panic(p.errorf("expected INT"))
 		}
 		p.scan()
-		return &ConstOp{Code: I64_CONST, Value: v}
+		return &ConstOp{Pos: pos, Code: I64_CONST, Value: v}
 
 	case UNREACHABLE, NOP, RETURN, DROP, SELECT, MEMORY_GROW, MEMORY_SIZE,
 		F32_ABS, F32_ADD, F32_CEIL, F32_CONVERT_I32_S, F32_CONVERT_I32_U, F32_CONVERT_I64_S, F32_CONVERT_I64_U, F32_COPYSIGN, F32_DEMOTE_F64, F32_DIV, F32_EQ, F32_FLOOR, F32_GE, F32_GT, F32_LE, F32_LT, F32_MAX, F32_MIN, F32_MUL, F32_NE, F32_NEAREST, F32_NEG, F32_REINTERPRET_I32, F32_SQRT, F32_SUB, F32_TRUNC,
@@ -760,7 +775,7 @@ func (p *parser) parseOp() Instr {
 
 		code := p.tok.Kind
 		p.scan()
-		return &Op{Code: code}
+		return &Op{Pos: pos, Code: code}
 
 	default:
 		panic(p.errorf("unknown operator"))
@@ -26,13 +26,19 @@ type Scanner struct {
 
 	line, column int
 
+	// start is the position of the first character of the current token. Unlike column, which is retained for
+	// compatibility with positions recorded by existing scripts, startColumn is always the one-based column of the
+	// next character.
+	start       Pos
+	startColumn int
+
 	tok   TokenKind
 	text  bytes.Buffer
 	value interface{}
 }
 
 func NewScanner(r io.Reader) *Scanner {
-	return &Scanner{r: r, line: 1}
+	return &Scanner{r: r, line: 1, startColumn: 1}
 }
 
 func (s *Scanner) Pos() Pos {
@@ -94,6 +100,7 @@ func (s *Scanner) skip() {
 	s.nb--
 	s.buf[0] = s.buf[1]
 	s.column++
+	s.startColumn++
 }
 
 func (s *Scanner) chomp() rune {
@@ -395,7 +402,7 @@ func (s *Scanner) scanLineComment() {
 		s.skip()
 		if n == '\n' || n == 0 {
 			s.line++
-			s.column = 0
+			s.column, s.startColumn = 0, 1
 			break
 		}
 	}
@@ -425,7 +432,7 @@ func (s *Scanner) scanBlockComment() {
 		} else {
 			if m == '\n' {
 				s.line++
-				s.column = 0
+				s.column, s.startColumn = 0, 0
 			}
 			s.skip()
 		}
@@ -437,6 +444,8 @@ func (s *Scanner) scan() (TokenKind, error) {
 	s.value = nil
 
 	for {
+		s.start = Pos{Line: s.line, Column: s.startColumn}
+
 		m, n := s.peek2()
 		switch {
 		case isDigit(m) || m == '-' || m == '+':
@@ -456,7 +465,7 @@ func (s *Scanner) scan() (TokenKind, error) {
 			return TokenKind(m), nil
 		case m == '\n':
 			s.line++
-			s.column = 0
+			s.column, s.startColumn = 0, 0
 			fallthrough
 		case isSpace(m):
 			s.skip()
@@ -474,7 +483,7 @@ func (s *Scanner) scan() (TokenKind, error) {
 }
 
 func (s *Scanner) token() *Token {
-	return &Token{Kind: s.tok, Text: s.Text(), Pos: s.Pos(), Value: s.value}
+	return &Token{Kind: s.tok, Text: s.Text(), Pos: s.Pos(), Start: s.start, Value: s.value}
 }
 
 func (s *Scanner) Scan() (*Token, error) {
@@ -484,7 +493,7 @@ func (s *Scanner) Scan() (*Token, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Token{Kind: tok, Text: s.Text(), Pos: s.Pos(), Value: s.value}, nil
+	return &Token{Kind: tok, Text: s.Text(), Pos: s.Pos(), Start: s.start, Value: s.value}, nil
 }
 
 func isSpace(r rune) bool {
@@ -16,6 +16,7 @@ type Pos struct {
 type Token struct {
 	Kind  TokenKind
 	Pos   Pos
+	Start Pos // The position of the token's first character.
 	Text  string
 	Value interface{}
 }